@@ -0,0 +1,230 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+type BasicStats struct {
+	Stats []string `toml:"stats"`
+
+	cache       map[uint64]basicstatsAggregate
+	statsConfig *basicstatsConfig
+}
+
+type basicstatsConfig struct {
+	count    bool
+	min      bool
+	max      bool
+	mean     bool
+	variance bool
+	stdev    bool
+	sum      bool
+}
+
+func NewBasicStats() Aggregator {
+	mm := &BasicStats{}
+	mm.Reset()
+	return mm
+}
+
+type basicstatsAggregate struct {
+	fields map[string]basicstats
+	name   string
+	tags   map[string]string
+}
+
+type basicstats struct {
+	count float64
+	min   float64
+	max   float64
+	sum   float64
+	mean  float64
+	M2    float64 //intermedia value for variance/stdev
+}
+
+var basicstatsSampleConfig = `
+  ## General Aggregator Arguments:
+  ## The period on which to flush & clear the aggregator.
+  period = "30s"
+  ## If true, the original metric will be dropped by the
+  ## aggregator and will not get sent to the output plugins.
+  drop_original = false
+
+  ## Configures which basic stats to push as fields
+  # stats = ["count", "min", "max", "mean", "stdev", "s2", "sum"]
+`
+
+func (m *BasicStats) SampleConfig() string {
+	return basicstatsSampleConfig
+}
+
+func (m *BasicStats) Description() string {
+	return "Keep the aggregate basicstats of each metric passing through."
+}
+
+func (m *BasicStats) Add(in Metric) {
+	id := in.HashID()
+	if _, ok := m.cache[id]; !ok {
+		// hit an uncached metric, create caches for first time:
+		a := basicstatsAggregate{
+			name:   in.Name(),
+			tags:   in.Tags(),
+			fields: make(map[string]basicstats),
+		}
+		for k, v := range in.Fields() {
+			if fv, ok := aggregatorConvert(v); ok {
+				a.fields[k] = basicstats{
+					count: 1,
+					min:   fv,
+					max:   fv,
+					mean:  fv,
+					sum:   fv,
+					M2:    0.0,
+				}
+			}
+		}
+		m.cache[id] = a
+	} else {
+		for k, v := range in.Fields() {
+			if fv, ok := aggregatorConvert(v); ok {
+				if _, ok := m.cache[id].fields[k]; !ok {
+					// hit an uncached field of a cached metric
+					m.cache[id].fields[k] = basicstats{
+						count: 1,
+						min:   fv,
+						max:   fv,
+						mean:  fv,
+						sum:   fv,
+						M2:    0.0,
+					}
+					continue
+				}
+
+				tmp := m.cache[id].fields[k]
+				//https://en.m.wikipedia.org/wiki/Algorithms_for_calculating_variance
+				//variable initialization
+				x := fv
+				mean := tmp.mean
+				M2 := tmp.M2
+				//counter compute
+				n := tmp.count + 1
+				tmp.count = n
+				//mean compute
+				delta := x - mean
+				mean = mean + delta/n
+				tmp.mean = mean
+				//variance/stdev compute
+				M2 = M2 + delta*(x-mean)
+				tmp.M2 = M2
+				//max/min compute
+				if fv < tmp.min {
+					tmp.min = fv
+				} else if fv > tmp.max {
+					tmp.max = fv
+				}
+				//sum compute
+				tmp.sum += fv
+				//store final data
+				m.cache[id].fields[k] = tmp
+			}
+		}
+	}
+}
+
+// Init checks the names of the stats, rather than ignoring the unknown ones.
+func (m *BasicStats) Init() error {
+	config, err := parseBasicstatsConfig(m.Stats)
+	if err != nil {
+		return err
+	}
+	m.statsConfig = config
+	return nil
+}
+
+func (m *BasicStats) Push(acc Accumulator) {
+	config := m.statsConfig
+
+	for _, aggregate := range m.cache {
+		fields := map[string]interface{}{}
+		for k, v := range aggregate.fields {
+			if config.count {
+				fields[k+"_count"] = v.count
+			}
+			if config.min {
+				fields[k+"_min"] = v.min
+			}
+			if config.max {
+				fields[k+"_max"] = v.max
+			}
+			if config.mean {
+				fields[k+"_mean"] = v.mean
+			}
+			if config.sum {
+				fields[k+"_sum"] = v.sum
+			}
+
+			//v.count always >=1
+			if v.count > 1 {
+				variance := v.M2 / (v.count - 1)
+
+				if config.variance {
+					fields[k+"_s2"] = variance
+				}
+				if config.stdev {
+					fields[k+"_stdev"] = math.Sqrt(variance)
+				}
+			}
+			//if count == 1 StdDev = infinite => so I won't send data
+		}
+
+		if len(fields) > 0 {
+			acc.AddFields(aggregate.name, fields, aggregate.tags)
+		}
+	}
+}
+
+func parseBasicstatsConfig(stats []string) (*basicstatsConfig, error) {
+	if stats == nil {
+		return defaultBasicstatsConfig(), nil
+	}
+
+	config := &basicstatsConfig{}
+	for _, stat := range stats {
+		switch stat {
+		case "count":
+			config.count = true
+		case "min":
+			config.min = true
+		case "max":
+			config.max = true
+		case "mean":
+			config.mean = true
+		case "s2":
+			config.variance = true
+		case "stdev":
+			config.stdev = true
+		case "sum":
+			config.sum = true
+		default:
+			return nil, fmt.Errorf("unknown stat %q", stat)
+		}
+	}
+
+	return config, nil
+}
+
+func defaultBasicstatsConfig() *basicstatsConfig {
+	return &basicstatsConfig{
+		count:    true,
+		min:      true,
+		max:      true,
+		mean:     true,
+		variance: true,
+		stdev:    true,
+	}
+}
+
+func (m *BasicStats) Reset() {
+	m.cache = make(map[uint64]basicstatsAggregate)
+}
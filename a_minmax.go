@@ -0,0 +1,110 @@
+package main
+
+type MinMax struct {
+	cache map[uint64]minmaxAggregate
+}
+
+func NewMinMax() Aggregator {
+	mm := &MinMax{}
+	mm.Reset()
+	return mm
+}
+
+type minmaxAggregate struct {
+	fields map[string]minmax
+	name   string
+	tags   map[string]string
+}
+
+type minmax struct {
+	min float64
+	max float64
+}
+
+var minmaxSampleConfig = `
+  ## General Aggregator Arguments:
+  ## The period on which to flush & clear the aggregator.
+  period = "30s"
+  ## If true, the original metric will be dropped by the
+  ## aggregator and will not get sent to the output plugins.
+  drop_original = false
+`
+
+func (m *MinMax) SampleConfig() string {
+	return minmaxSampleConfig
+}
+
+func (m *MinMax) Description() string {
+	return "Keep the aggregate min/max of each metric passing through."
+}
+
+func (m *MinMax) Add(in Metric) {
+	id := in.HashID()
+	if _, ok := m.cache[id]; !ok {
+		// hit an uncached metric, create caches for first time:
+		a := minmaxAggregate{
+			name:   in.Name(),
+			tags:   in.Tags(),
+			fields: make(map[string]minmax),
+		}
+		for k, v := range in.Fields() {
+			if fv, ok := aggregatorConvert(v); ok {
+				a.fields[k] = minmax{
+					min: fv,
+					max: fv,
+				}
+			}
+		}
+		m.cache[id] = a
+	} else {
+		for k, v := range in.Fields() {
+			if fv, ok := aggregatorConvert(v); ok {
+				if _, ok := m.cache[id].fields[k]; !ok {
+					// hit an uncached field of a cached metric
+					m.cache[id].fields[k] = minmax{
+						min: fv,
+						max: fv,
+					}
+					continue
+				}
+				if fv < m.cache[id].fields[k].min {
+					tmp := m.cache[id].fields[k]
+					tmp.min = fv
+					m.cache[id].fields[k] = tmp
+				} else if fv > m.cache[id].fields[k].max {
+					tmp := m.cache[id].fields[k]
+					tmp.max = fv
+					m.cache[id].fields[k] = tmp
+				}
+			}
+		}
+	}
+}
+
+func (m *MinMax) Push(acc Accumulator) {
+	for _, aggregate := range m.cache {
+		fields := map[string]interface{}{}
+		for k, v := range aggregate.fields {
+			fields[k+"_min"] = v.min
+			fields[k+"_max"] = v.max
+		}
+		acc.AddFields(aggregate.name, fields, aggregate.tags)
+	}
+}
+
+func (m *MinMax) Reset() {
+	m.cache = make(map[uint64]minmaxAggregate)
+}
+
+// aggregatorConvert returns the value of a numeric field as a float64, and
+// false for any other field type.
+func aggregatorConvert(in interface{}) (float64, bool) {
+	switch v := in.(type) {
+	case float64:
+		return v, true
+	case int64:
+		return float64(v), true
+	default:
+		return 0, false
+	}
+}
@@ -1,6 +1,11 @@
 package main
 
-import "time"
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
 
 // Accumulator is an interface for "accumulating" metrics from plugin(s).
 // The metrics are sent down a channel shared between all plugins.
@@ -42,5 +47,43 @@ type Accumulator interface {
 
 	SetPrecision(precision, interval time.Duration)
 
+	// AddError reports an error of the plugin, that it could not gather all
+	// of its metrics. The error is logged with the name of the plugin and
+	// counted, a GatherError adding the context it happened in.
 	AddError(err error)
 }
+
+// GatherError is an error of a plugin with the context it happened in, ie,
+// the server or the file it was reading, as key value pairs.
+type GatherError struct {
+	Err     error
+	Context map[string]string
+}
+
+// NewGatherError returns the error with its context, the keys and values of
+// the pairs alternating, ie, NewGatherError(err, "server", url).
+func NewGatherError(err error, keyvals ...string) *GatherError {
+	e := &GatherError{Err: err, Context: make(map[string]string, len(keyvals)/2)}
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		e.Context[keyvals[i]] = keyvals[i+1]
+	}
+	return e
+}
+
+// Error returns the message of the error followed by its context, sorted by
+// key, ie, "connection refused (server=http://localhost:8080)".
+func (e *GatherError) Error() string {
+	if len(e.Context) == 0 {
+		return e.Err.Error()
+	}
+	keys := make([]string, 0, len(e.Context))
+	for k := range e.Context {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = k + "=" + e.Context[k]
+	}
+	return fmt.Sprintf("%s (%s)", e.Err, strings.Join(pairs, ", "))
+}
@@ -20,6 +20,12 @@ type MetricMaker interface {
 	) Metric
 }
 
+// errorObserver is implemented by the makers which may collect the errors
+// rather than have them logged, ie, inputs on probation.
+type errorObserver interface {
+	ObserveError(err error) bool
+}
+
 func NewAccumulator(
 	maker MetricMaker,
 	metrics chan Metric,
@@ -28,6 +34,8 @@ func NewAccumulator(
 		maker:     maker,
 		metrics:   metrics,
 		precision: time.Nanosecond,
+		errors: Register("errors", "errors",
+			map[string]string{"plugin": maker.Name()}),
 	}
 	return &acc
 }
@@ -38,6 +46,9 @@ type accumulator struct {
 	maker MetricMaker
 
 	precision time.Duration
+
+	// errors counts the errors of the plugin
+	errors Stat
 }
 
 func (ac *accumulator) AddFields(
@@ -96,12 +107,17 @@ func (ac *accumulator) AddHistogram(
 }
 
 // AddError passes a runtime error to the accumulator.
-// The error will be tagged with the plugin name and written to the log.
+// The error will be tagged with the plugin name, written to the log and
+// counted in the errors of the plugin.
 func (ac *accumulator) AddError(err error) {
 	if err == nil {
 		return
 	}
 	NErrors.Incr(1)
+	ac.errors.Incr(1)
+	if o, ok := ac.maker.(errorObserver); ok && o.ObserveError(err) {
+		return
+	}
 	//TODO suppress/throttle consecutive duplicate errors?
 	log.Printf("E! Error in plugin [%s]: %s", ac.maker.Name(), err)
 }
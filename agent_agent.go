@@ -6,18 +6,71 @@ import (
 	"runtime"
 	"time"
 	"sync"
+	"sync/atomic"
 	"fmt"
 )
 
 // Agent runs telegraf and collects data based on the given config
 type Agent struct {
 	Config *Config
+
+	// counts metrics dropped for missing one of the required tags
+	droppedUntagged Stat
+	// counts metrics dropped by the timestamp guard
+	droppedTimestamp Stat
+	// count metrics dropped for, and coerced into, the schema
+	droppedSchema Stat
+	coercedSchema Stat
+	// counts the steps of the wall clock, and the metrics dropped for being
+	// timestamped in the range a step went back over
+	clockSteps       Stat
+	droppedClockStep Stat
+
+	clock clockState
+
+	// the debug tap enabled, a *debugTap nil when disabled
+	tap atomic.Value
+	// the maintenance window open, a *MaintenanceWindow nil when closed
+	maintenance atomic.Value
 }
 
 // NewAgent returns an Agent struct based off the given Config
 func NewAgent(config *Config) (*Agent, error) {
 	a := &Agent{
-		Config: config,
+		Config:           config,
+		droppedUntagged:  RegisterDropped("enforce", "agent", "missing_tag"),
+		droppedTimestamp: RegisterDropped("guard", "agent", "bad_timestamp"),
+		droppedSchema:    RegisterDropped("enforce", "agent", "schema"),
+		coercedSchema:    Register("agent", "metrics_coerced", map[string]string{}),
+		clockSteps:       Register("agent", "clock_steps", map[string]string{}),
+		droppedClockStep: RegisterDropped("guard", "agent", "clock_step"),
+	}
+
+	switch a.Config.Agent.TimestampGuardAction {
+	case "":
+		a.Config.Agent.TimestampGuardAction = "correct"
+	case "correct", "drop", "tag":
+	default:
+		return nil, fmt.Errorf("Invalid timestamp_guard_action: %s",
+			a.Config.Agent.TimestampGuardAction)
+	}
+
+	switch a.Config.Agent.ClockStepAction {
+	case "":
+		a.Config.Agent.ClockStepAction = "tag"
+	case "tag", "drop", "ignore":
+	default:
+		return nil, fmt.Errorf("Invalid clock_step_action: %s",
+			a.Config.Agent.ClockStepAction)
+	}
+	ClockStepThreshold = a.Config.Agent.ClockStepThreshold.Duration
+
+	// the series of strict ordering outputs are forgotten after ten flushes
+	// by default
+	for _, o := range a.Config.Outputs {
+		if o.Config.StrictOrdering && o.Config.OrderingWindow == 0 {
+			o.Config.OrderingWindow = 10 * a.Config.Agent.FlushInterval.Duration
+		}
 	}
 
 	if !a.Config.Agent.OmitHostname {
@@ -33,26 +86,53 @@ func NewAgent(config *Config) (*Agent, error) {
 		config.Tags["host"] = a.Config.Agent.Hostname
 	}
 
+	if err := a.setupZone(zoneRunner); err != nil {
+		return nil, err
+	}
+
+	DefaultResolver.SetTTL(a.Config.Agent.DNSCacheTTL.Duration)
+
 	return a, nil
 }
 
-// Connect connects to all configured outputs
+// Connect connects to all configured outputs, concurrently so that the
+// retry of an output does not hold the others up.
 func (a *Agent) Connect() error {
+	errC := make(chan error, len(a.Config.Outputs))
 	for _, o := range a.Config.Outputs {
+		go func(o *RunningOutput) {
+			errC <- a.connect(o)
+		}(o)
+	}
+
+	var err error
+	for range a.Config.Outputs {
+		if e := <-errC; e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
 
-		log.Printf("D! Attempting connection to output: %s\n", o.Name)
-		err := o.Output.Connect()
+// connect connects to the output, retrying once after 15s.
+func (a *Agent) connect(o *RunningOutput) error {
+	log.Printf("D! Attempting connection to output: %s\n", o.Name)
+	start := time.Now()
+	o.ResolveEndpoints()
+	o.AcquireToken()
+	err := o.Output.Connect()
+	if err != nil {
+		log.Printf("E! Failed to connect to output %s, retrying in 15s, "+
+			"error was '%s' \n", o.Name, err)
+		time.Sleep(15 * time.Second)
+		o.ResolveEndpoints()
+		err = o.Output.Connect()
 		if err != nil {
-			log.Printf("E! Failed to connect to output %s, retrying in 15s, "+
-				"error was '%s' \n", o.Name, err)
-			time.Sleep(15 * time.Second)
-			err = o.Output.Connect()
-			if err != nil {
-				return err
-			}
+			return err
 		}
-		log.Printf("D! Successfully connected to output: %s\n", o.Name)
 	}
+	log.Printf("D! Successfully connected to output: %s in %s\n", o.Name,
+		time.Since(start))
 	return nil
 }
 
@@ -91,22 +171,97 @@ func (a *Agent) gatherer(
 		"gather_time_ns",
 		map[string]string{"input": input.Config.Name},
 	)
+	// the CPU time and the allocations of the process, and the CPU time of
+	// the commands it waited for, during the gather: they are approximate as
+	// they include the usage of the gathers overlapping it, which are counted
+	// so that the usage can be discounted
+	GatherCPUTime := RegisterTiming("gather",
+		"gather_cpu_time_approx_ns",
+		map[string]string{"input": input.Config.Name},
+	)
+	GatherAlloc := RegisterTiming("gather",
+		"gather_alloc_bytes_approx",
+		map[string]string{"input": input.Config.Name},
+	)
+	GatherAllocs := RegisterTiming("gather",
+		"gather_allocs_approx",
+		map[string]string{"input": input.Config.Name},
+	)
+	GatherOverlapping := RegisterTiming("gather",
+		"gather_overlapping",
+		map[string]string{"input": input.Config.Name},
+	)
 
 	acc := NewAccumulator(input, metricC)
 	acc.SetPrecision(a.Config.Agent.Precision.Duration,
 		a.Config.Agent.Interval.Duration)
 
-	ticker := time.NewTicker(interval)
+	if input.Config.Probation > 0 {
+		log.Printf("I! Input [%s] is on probation for %d gathers, its metrics "+
+			"are counted but not sent", input.Name(), input.Config.Probation)
+	}
+
+	// the delay before restarting the service after it failed, doubled on
+	// each consecutive failure and reset once it gathers again
+	var restartDelay time.Duration
+	service, isService := input.Input.(ServiceInput)
+	if isService {
+		var ok bool
+		if restartDelay, ok = startService(shutdown, input, service, acc, 0); !ok {
+			return
+		}
+		defer service.Stop()
+	}
+
+	// a scheduled input is gathered at most once per matching minute, but
+	// must be looked at in every minute for none of them to be missed
+	tick := interval
+	if input.Config.Schedule != nil && tick > scheduleTick {
+		tick = scheduleTick
+	}
+	ticker := time.NewTicker(tick)
 	defer ticker.Stop()
 
 	for {
 		RandomSleep(a.Config.Agent.CollectionJitter.Duration, shutdown)
 
 		start := time.Now()
-		gatherWithTimeout(shutdown, input, acc, interval)
-		elapsed := time.Since(start)
-
-		GatherTime.Incr(elapsed.Nanoseconds())
+		if input.ShouldGather(start) {
+			overlap := beginGather()
+			usage := GetResourceUsage()
+			err := gatherWithTimeout(shutdown, input, acc, interval)
+			if err != nil && isService {
+				log.Printf("E! Service input [%s] failed, stopping it", input.Name())
+				service.Stop()
+				if input.Config.RestartPolicy == "never" {
+					return
+				}
+				var ok bool
+				restartDelay, ok = startService(shutdown, input, service, acc,
+					restartDelay)
+				if !ok {
+					return
+				}
+			} else if isService {
+				restartDelay = input.Config.RestartDelay
+			}
+			input.Gathered()
+			elapsed := time.Since(start)
+			used := usage.Since()
+			overlapping := overlap.end()
+
+			GatherTime.Incr(elapsed.Nanoseconds())
+			GatherCPUTime.Incr(used.CPUTime.Nanoseconds())
+			GatherAlloc.Incr(int64(used.AllocBytes))
+			GatherAllocs.Incr(int64(used.Allocs))
+			GatherOverlapping.Incr(overlapping)
+			log.Printf("D! Input [%s] gathered in %s, using about %s of CPU "+
+				"and allocating about %d bytes, overlapping %d other gathers",
+				input.Name(), elapsed, used.CPUTime, used.AllocBytes, overlapping)
+		} else {
+			log.Printf("D! Input [%s] is outside of its schedule, skipping",
+				input.Name())
+		}
 
 		select {
 		case <-shutdown:
@@ -117,17 +272,67 @@ func (a *Agent) gatherer(
 	}
 }
 
+// startService starts the service input after the wait, if any, retrying
+// after the restart delay of the input, doubled on each failed attempt up to
+// maxRestartDelay, unless its restart policy is "never". It returns the
+// delay to wait before restarting the service if it fails again, and false
+// if the service could not be started or the agent is shutting down.
+func startService(
+	shutdown chan struct{},
+	input *RunningInput,
+	service ServiceInput,
+	acc *accumulator,
+	wait time.Duration,
+) (time.Duration, bool) {
+	delay := wait
+	for {
+		if delay > 0 {
+			log.Printf("I! Restarting the service input [%s] in %s",
+				input.Name(), delay)
+			t := time.NewTimer(delay)
+			select {
+			case <-shutdown:
+				t.Stop()
+				return 0, false
+			case <-t.C:
+			}
+		}
+
+		// the delay of the next attempt
+		if delay == 0 {
+			delay = input.Config.RestartDelay
+		} else if delay *= 2; delay > maxRestartDelay {
+			delay = maxRestartDelay
+		}
+
+		err := service.Start(acc)
+		if err == nil {
+			log.Printf("I! Started the service input [%s]", input.Name())
+			return delay, true
+		}
+		acc.AddError(fmt.Errorf("could not start the service: %s", err))
+		if input.Config.RestartPolicy == "never" {
+			log.Printf("E! Service input [%s] failed, not restarting it "+
+				"per its restart_policy", input.Name())
+			return 0, false
+		}
+	}
+}
+
+// maxRestartDelay caps the delay between the attempts to start a service.
+const maxRestartDelay = 5 * time.Minute
+
 // gatherWithTimeout gathers from the given input, with the given timeout.
 //   when the given timeout is reached, gatherWithTimeout logs an error message
 //   but continues waiting for it to return. This is to avoid leaving behind
 //   hung processes, and to prevent re-calling the same hung process over and
-//   over.
+//   over. It returns the error of the gather, nil on shutdown.
 func gatherWithTimeout(
 	shutdown chan struct{},
 	input *RunningInput,
 	acc *accumulator,
 	timeout time.Duration,
-) {
+) error {
 	ticker := time.NewTicker(timeout)
 	defer ticker.Stop()
 	done := make(chan error)
@@ -141,17 +346,114 @@ func gatherWithTimeout(
 			if err != nil {
 				acc.AddError(err)
 			}
-			return
+			return err
 		case <-ticker.C:
 			err := fmt.Errorf("took longer to collect than collection interval (%s)",
 				timeout)
 			acc.AddError(err)
 			continue
 		case <-shutdown:
-			return
+			return nil
 		}
 	}
 }
+// enforceSchema makes the metric of an input conform to the schema, if any.
+// It returns nil if the metric must be dropped.
+func (a *Agent) enforceSchema(m Metric) Metric {
+	if a.Config.Schema == nil {
+		return m
+	}
+	conformed, coerced := a.Config.Schema.conform(m)
+	if conformed == nil {
+		a.droppedSchema.Incr(1)
+	} else if coerced {
+		a.coercedSchema.Incr(1)
+	}
+	return conformed
+}
+
+// enforceTags makes sure the metric carries all of the required tags, adding
+// the configured defaults for missing ones. It returns nil if a required tag
+// is missing and has no default, in which case the metric must be dropped.
+func (a *Agent) enforceTags(m Metric) Metric {
+	if len(a.Config.Agent.RequiredTags) == 0 {
+		return m
+	}
+
+	tags := m.Tags()
+	var modified bool
+	for _, key := range a.Config.Agent.RequiredTags {
+		if _, ok := tags[key]; ok {
+			continue
+		}
+		if value, ok := a.Config.Agent.RequiredTagDefaults[key]; ok {
+			tags[key] = value
+			modified = true
+			continue
+		}
+		log.Printf("D! Dropping metric %s, missing required tag %s",
+			m.Name(), key)
+		a.droppedUntagged.Incr(1)
+		return nil
+	}
+	if !modified {
+		return m
+	}
+
+	tagged, err := New(m.Name(), tags, m.Fields(), m.Time(), m.Type())
+	if err != nil {
+		log.Printf("E! Could not add required tags to metric %s: %s",
+			m.Name(), err)
+		a.droppedUntagged.Incr(1)
+		return nil
+	}
+	tagged.SetAggregate(m.IsAggregate())
+	return tagged
+}
+
+// guardTimestamp applies the timestamp_guard_action to metrics timestamped
+// outside of the configured bounds around the current time. It returns nil
+// if the metric must be dropped.
+func (a *Agent) guardTimestamp(m Metric) Metric {
+	maxPast := a.Config.Agent.TimestampMaxPast.Duration
+	maxFuture := a.Config.Agent.TimestampMaxFuture.Duration
+	if maxPast == 0 && maxFuture == 0 {
+		return m
+	}
+
+	now := time.Now()
+	t := m.Time()
+	if (maxPast == 0 || !t.Before(now.Add(-maxPast))) &&
+		(maxFuture == 0 || !t.After(now.Add(maxFuture))) {
+		return m
+	}
+
+	log.Printf("D! Metric %s has timestamp %s out of bounds, applying %q",
+		m.Name(), t.Format(time.RFC3339), a.Config.Agent.TimestampGuardAction)
+
+	var guarded Metric
+	var err error
+	switch a.Config.Agent.TimestampGuardAction {
+	case "drop":
+		a.droppedTimestamp.Incr(1)
+		return nil
+	case "tag":
+		tags := m.Tags()
+		tags["timestamp_invalid"] = "true"
+		guarded, err = New(m.Name(), tags, m.Fields(), t, m.Type())
+	default:
+		guarded, err = New(m.Name(), m.Tags(), m.Fields(), now, m.Type())
+	}
+	if err != nil {
+		log.Printf("E! Could not guard timestamp of metric %s: %s",
+			m.Name(), err)
+		a.droppedTimestamp.Incr(1)
+		return nil
+	}
+	guarded.SetAggregate(m.IsAggregate())
+	return guarded
+}
+
 // flush writes a list of metrics to all configured outputs
 func (a *Agent) flush() {
 	var wg sync.WaitGroup
@@ -178,6 +480,8 @@ func (a *Agent) Run(shutdown chan struct{}) error {
 	log.Printf("I! Agent Config: Interval:%s, Hostname:%#v, \n",
 		a.Config.Agent.Interval.Duration,
 		a.Config.Agent.Hostname)
+	a.LoadTap()
+	a.LoadMaintenance()
 
 	// channel shared between all input threads for accumulating metrics
 	metricC := make(chan Metric, 100)
@@ -189,6 +493,12 @@ func (a *Agent) Run(shutdown chan struct{}) error {
 		time.Sleep(time.Duration(i - (time.Now().UnixNano() % i)))
 	}
 
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		a.watchClock(shutdown)
+	}()
+
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
@@ -198,6 +508,17 @@ func (a *Agent) Run(shutdown chan struct{}) error {
 		}
 	}()
 
+	wg.Add(len(a.Config.Aggregators))
+	for _, aggregator := range a.Config.Aggregators {
+		go func(agg *RunningAggregator) {
+			defer wg.Done()
+			acc := NewAccumulator(agg, aggC)
+			acc.SetPrecision(a.Config.Agent.Precision.Duration,
+				a.Config.Agent.Interval.Duration)
+			agg.Run(acc, shutdown)
+		}(aggregator)
+	}
+
 	wg.Add(len(a.Config.Inputs))
 	for _, input := range a.Config.Inputs {
 		input.SetDefaultTags(a.Config.Tags)
@@ -217,6 +538,25 @@ func (a *Agent) Run(shutdown chan struct{}) error {
 	return nil
 }
 
+// addToOutputs adds the metric to the outputs writing it at their
+// resolution, downsampled telling whether an aggregator takes it.
+func (a *Agent) addToOutputs(m Metric, downsampled bool) {
+	var outputs []*RunningOutput
+	for _, o := range a.Config.Outputs {
+		if o.Accepts(m, downsampled) {
+			outputs = append(outputs, o)
+		}
+	}
+	for i, o := range outputs {
+		a.tapMetric("output", o.Name, m)
+		if i == len(outputs)-1 {
+			o.AddMetric(m)
+		} else {
+			o.AddMetric(m.Copy())
+		}
+	}
+}
+
 // flusher monitors the metrics input channel and flushes on the minimum interval
 func (a *Agent) flusher(shutdown chan struct{}, metricC chan Metric, aggC chan Metric) error {
 	// Inelegant, but this sleep is to allow the Gather threads to run, so that
@@ -241,15 +581,26 @@ func (a *Agent) flusher(shutdown chan struct{}, metricC chan Metric, aggC chan M
 			case m := <-outMetricC:
 				// if dropOriginal is set to true, then we will only send this
 				// metric to the aggregators, not the outputs.
-				var dropOriginal bool
-				if !dropOriginal {
-					for i, o := range a.Config.Outputs {
-						if i == len(a.Config.Outputs)-1 {
-							o.AddMetric(m)
-						} else {
-							o.AddMetric(m.Copy())
+				var dropOriginal, downsampled bool
+				if !m.IsAggregate() {
+					for _, agg := range a.Config.Aggregators {
+						if !agg.Selects(m) {
+							continue
 						}
+						downsampled = true
+						if ok := agg.Add(m.Copy()); ok {
+							dropOriginal = true
+						}
+					}
+				}
+				if !dropOriginal {
+					if m = a.enforceSchema(m); m == nil {
+						continue
+					}
+					if m = a.enforceTags(m); m == nil {
+						continue
 					}
+					a.addToOutputs(a.tagMaintenance(m), downsampled)
 				}
 			}
 		}
@@ -267,15 +618,21 @@ func (a *Agent) flusher(shutdown chan struct{}, metricC chan Metric, aggC chan M
 				}
 				return
 			case metric := <-aggC:
+				a.tapMetric("aggregator", "", metric)
 				metrics := []Metric{metric}
+				for _, processor := range a.Config.Processors {
+					metrics = processor.Apply(metrics...)
+					for _, m := range metrics {
+						a.tapMetric("processor", processor.Name, m)
+					}
+				}
+				// the schema is of the metrics of the inputs, the fields
+				// of the aggregates being named by the aggregators
 				for _, m := range metrics {
-					for i, o := range a.Config.Outputs {
-						if i == len(a.Config.Outputs)-1 {
-							o.AddMetric(m)
-						} else {
-							o.AddMetric(m.Copy())
-						}
+					if m = a.enforceTags(m); m == nil {
+						continue
 					}
+					a.addToOutputs(a.tagMaintenance(m), false)
 				}
 			}
 		}
@@ -305,9 +662,22 @@ func (a *Agent) flusher(shutdown chan struct{}, metricC chan Metric, aggC chan M
 				}
 			}()
 		case metric := <-metricC:
+			a.tapMetric("input", "", metric)
 			// NOTE potential bottleneck here as we put each metric through the
 			// processors serially.
+			if metric = a.guardTimestamp(metric); metric == nil {
+				continue
+			}
+			if metric = a.guardClockStep(metric); metric == nil {
+				continue
+			}
 			mS := []Metric{metric}
+			for _, processor := range a.Config.Processors {
+				mS = processor.Apply(mS...)
+				for _, m := range mS {
+					a.tapMetric("processor", processor.Name, m)
+				}
+			}
 			for _, m := range mS {
 				outMetricC <- m
 			}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// benchResult is what the gathers of an input cost over a benchmark.
+type benchResult struct {
+	name      string
+	gathers   int
+	metrics   int
+	errors    int
+	durations []time.Duration
+	usage     ResourceUsage
+}
+
+// Bench gathers each input the given number of times, one input at a time
+// so that the process wide resource usage is charged to it alone, writes
+// their metrics to the discard output, and prints what each one cost: its
+// throughput, the percentiles of its gather times, and the CPU time and
+// allocations of a gather.
+func (a *Agent) Bench(iterations int, w io.Writer) error {
+	if iterations < 1 {
+		return fmt.Errorf("the number of iterations must be positive")
+	}
+	if err := a.InitPlugins(); err != nil {
+		return err
+	}
+	discard := NewRunningOutput("discard", &Discard{}, &OutputConfig{Name: "discard"},
+		a.Config.Agent.MetricBatchSize, a.Config.Agent.MetricBufferLimit)
+
+	var results []*benchResult
+	for _, input := range a.Config.Inputs {
+		// every gather is measured, even those of an input on probation
+		config := *input.Config
+		config.Probation = 0
+		ri := NewRunningInput(input.Input, &config)
+		ri.SetDefaultTags(a.Config.Tags)
+
+		log.Printf("I! Benchmarking input [%s] over %d gathers", ri.Name(), iterations)
+		result, err := benchInput(ri, iterations, discard)
+		if err != nil {
+			return err
+		}
+		results = append(results, result)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "input\tgathers\tmetrics\terrors\tmetrics/s\tp50\tp90\tp99\tmax\t"+
+		"cpu/gather\tbytes/gather\tallocs/gather\t")
+	for _, r := range results {
+		var total time.Duration
+		for _, d := range r.durations {
+			total += d
+		}
+		sort.Slice(r.durations, func(i, j int) bool { return r.durations[i] < r.durations[j] })
+		throughput := 0.0
+		if total > 0 {
+			throughput = float64(r.metrics) / total.Seconds()
+		}
+		n := int64(r.gathers)
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%.1f\t%s\t%s\t%s\t%s\t%s\t%d\t%d\t\n",
+			r.name, r.gathers, r.metrics, r.errors, throughput,
+			benchPercentile(r.durations, 0.50),
+			benchPercentile(r.durations, 0.90),
+			benchPercentile(r.durations, 0.99),
+			r.durations[len(r.durations)-1],
+			r.usage.CPUTime/time.Duration(n),
+			int64(r.usage.AllocBytes)/n,
+			int64(r.usage.Allocs)/n)
+	}
+	return tw.Flush()
+}
+
+func benchInput(ri *RunningInput, iterations int, discard *RunningOutput) (*benchResult, error) {
+	result := &benchResult{name: ri.Name(), gathers: iterations}
+
+	metricC := make(chan Metric, 100)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for m := range metricC {
+			result.metrics++
+			discard.AddMetric(m)
+		}
+	}()
+	acc := NewAccumulator(ri, metricC)
+
+	if service, ok := ri.Input.(ServiceInput); ok {
+		if err := service.Start(acc); err != nil {
+			close(metricC)
+			<-done
+			return nil, fmt.Errorf("could not start the service input [%s]: %s",
+				ri.Name(), err)
+		}
+		defer service.Stop()
+	}
+
+	errors := Register("errors", "errors", map[string]string{"plugin": ri.Name()})
+	errorsBefore := errors.Get()
+	var usage ResourceUsage
+	for i := 0; i < iterations; i++ {
+		before := GetResourceUsage()
+		start := time.Now()
+		if err := ri.Input.Gather(acc); err != nil {
+			acc.AddError(err)
+		}
+		result.durations = append(result.durations, time.Since(start))
+		used := before.Since()
+		usage.CPUTime += used.CPUTime
+		usage.AllocBytes += used.AllocBytes
+		usage.Allocs += used.Allocs
+	}
+	close(metricC)
+	<-done
+	discard.Write()
+
+	result.usage = usage
+	result.errors = int(errors.Get() - errorsBefore)
+	return result, nil
+}
+
+// benchPercentile returns the nearest rank percentile of the sorted
+// durations.
+func benchPercentile(sorted []time.Duration, p float64) time.Duration {
+	i := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	return sorted[i]
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// replayMaxLine is the longest line of an archive replayed.
+const replayMaxLine = 1024 * 1024
+
+// Replay writes the metrics of the given archives, files of metrics in the
+// influx line protocol, gzipped if their name ends in .gz, to the outputs
+// with their original timestamps, at most rate metrics per second if rate
+// is positive. The metrics do not go through the processors and aggregators
+// again. Replay stops at the first output failing to write, telling how
+// many metrics were replayed, so that the backfill does not go on while the
+// central service is still down.
+func (a *Agent) Replay(paths []string, rate int) error {
+	if len(paths) == 0 {
+		return fmt.Errorf("no archive to replay")
+	}
+	if err := a.InitPlugins(); err != nil {
+		return err
+	}
+	if err := a.Connect(); err != nil {
+		return err
+	}
+	defer a.Close()
+
+	r := &replayer{agent: a, rate: rate, start: time.Now()}
+	for _, path := range paths {
+		if err := r.replayFile(path); err != nil {
+			return err
+		}
+	}
+	if err := r.flush(); err != nil {
+		return err
+	}
+	log.Printf("I! Replayed %d metrics in %s, skipped %d invalid lines",
+		r.replayed, time.Since(r.start), r.invalid)
+	return nil
+}
+
+// replayer tracks the progress of a replay.
+type replayer struct {
+	agent *Agent
+	rate  int
+	start time.Time
+
+	replayed int
+	invalid  int
+	// metrics added to the outputs since they were last written
+	pending int
+}
+
+// replayFile replays the metrics of an archive.
+func (r *replayer) replayFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var reader io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return fmt.Errorf("error reading %s: %s", path, err)
+		}
+		defer gz.Close()
+		reader = gz
+	}
+
+	log.Printf("I! Replaying %s", path)
+	parser := &InfluxParser{}
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), replayMaxLine)
+	lineno := 0
+	for scanner.Scan() {
+		lineno++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		m, err := parser.ParseLine(line)
+		if err != nil {
+			log.Printf("W! Skipping line %d of %s: %s", lineno, path, err)
+			r.invalid++
+			continue
+		}
+		if err := r.add(m); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading %s: %s", path, err)
+	}
+	return nil
+}
+
+// add adds a metric to the outputs, writing them every batch of metrics,
+// and waits as long as needed to keep to the rate.
+func (r *replayer) add(m Metric) error {
+	r.agent.addToOutputs(m, false)
+	r.replayed++
+	r.pending++
+	batchSize := r.agent.Config.Agent.MetricBatchSize
+	if batchSize <= 0 {
+		batchSize = DEFAULT_METRIC_BATCH_SIZE
+	}
+	if r.pending >= batchSize {
+		if err := r.flush(); err != nil {
+			return err
+		}
+	}
+	if r.rate > 0 {
+		due := r.start.Add(time.Duration(r.replayed) * time.Second / time.Duration(r.rate))
+		if wait := time.Until(due); wait > 0 {
+			time.Sleep(wait)
+		}
+	}
+	return nil
+}
+
+// flush writes the metrics pending to the outputs.
+func (r *replayer) flush() error {
+	r.pending = 0
+	for _, o := range r.agent.Config.Outputs {
+		if err := o.Write(); err != nil {
+			return fmt.Errorf("error writing to output [%s] after %d metrics "+
+				"were replayed: %s", o.Name, r.replayed, err)
+		}
+	}
+	return nil
+}
@@ -75,19 +75,157 @@ func InitAllInputs() {
 		return &Processes{}
 	})
 
-	AddInput("diskio", func() Input {
-		return &DiskIOStats{}
-	})
+	AddInput("diskio", NewDiskIOStats)
 
-	AddInput("net", func() Input {
-		return &NetIOStats{}
-	})
+	AddInput("net", NewNetIOStats)
 
 	AddInput("swap", func() Input {
 		return &SwapStats{}
 	})
+
+	AddInput("internal", NewSelf)
+
+	AddInput("kstat", NewKstat)
+
+	AddInput("zones", NewZones)
+
+	AddInput("zfs", NewZfs)
+
+	AddInput("smf", NewSMF)
+
+	AddInput("fmadm", NewFmadm)
+
+	AddInput("otlp", NewOTLPReceiver)
+
+	AddInput("nfsstat", NewNFSStat)
+
+	AddInput("dtrace", NewDTrace)
+
+	AddInput("procstat", NewProcstat)
+
+	AddInput("exec", NewExec)
+
+	AddInput("execd", NewExecd)
+
+	AddInput("tail", NewTail)
+
+	AddInput("syslog", NewSyslog)
+
+	AddInput("socket_listener", NewSocketListener)
+
+	AddInput("http_listener_v2", NewHTTPListenerV2)
+
+	AddInput("snmp", NewSNMP)
+
+	AddInput("clock", NewClock)
+
+	AddInput("smf_log", NewSMFLog)
+
+	AddInput("x509_cert", NewX509Cert)
+
+	AddInput("ldom", NewLDom)
+
+	AddInput("process_tree", NewProcessTree)
+
+	AddInput("ipmp", NewIPMP)
+
+	AddInput("syscall_errors", NewSyscallErrors)
+
+	AddInput("fc", NewFC)
+
+	AddInput("file_growth", NewFileGrowth)
+
+	AddInput("sensors", NewSensors)
+	AddInput("quota", NewQuota)
+	AddInput("rcap", NewRcap)
+	AddInput("project", NewProject)
+	AddInput("interrupts", NewInterrupts)
+	AddInput("netstat_proto", NewNetstatProto)
+	AddInput("pkg", NewPkg)
+	AddInput("zpool_iostat", NewZpoolIostat)
+	AddInput("vmstat", NewVmstat)
 }
 
 func InitAllOutputs() {
 	AddOutput("influxdb", func() Output { return newInflux() })
+
+	AddOutput("otlp", func() Output { return newOTLP() })
+
+	AddOutput("zabbix", func() Output { return newZabbix() })
+
+	AddOutput("nsca", func() Output { return newNSCA() })
+
+	AddOutput("icinga2", func() Output { return newIcinga2() })
+
+	AddOutput("statsd", func() Output { return newStatsd() })
+
+	AddOutput("servicenow", func() Output { return newServiceNow() })
+
+	AddOutput("webhook", func() Output { return newWebhook() })
+
+	AddOutput("sharding", func() Output { return newSharding() })
+
+	AddOutput("prometheus_client", func() Output { return newPrometheusClient() })
+
+	AddOutput("kafka", func() Output { return newKafka() })
+
+	AddOutput("amqp", func() Output { return newAMQP() })
+
+	AddOutput("socket_writer", func() Output { return newSocketWriter() })
+
+	AddOutput("exec", func() Output { return newExecOutput() })
+
+	AddOutput("execd", func() Output { return &ExecdOutput{} })
+
+	AddOutput("discard", func() Output { return &Discard{} })
+}
+
+func InitAllProcessors() {
+	AddProcessor("rename", func() Processor {
+		return &Rename{}
+	})
+
+	AddProcessor("regex", func() Processor {
+		return NewRegex()
+	})
+
+	AddProcessor("converter", func() Processor {
+		return &Converter{}
+	})
+
+	AddProcessor("device_alias", func() Processor {
+		return NewDeviceAlias()
+	})
+
+	AddProcessor("delta", func() Processor {
+		return NewDelta()
+	})
+
+	AddProcessor("lookup", func() Processor {
+		return NewLookup()
+	})
+
+	AddProcessor("geoip", func() Processor {
+		return NewGeoIP()
+	})
+
+	AddProcessor("deadband", func() Processor {
+		return NewDeadband()
+	})
+
+	AddProcessor("scrub", func() Processor {
+		return NewScrub()
+	})
+
+	AddProcessor("units", func() Processor {
+		return NewUnits()
+	})
+}
+
+func InitAllAggregators() {
+	AddAggregator("minmax", NewMinMax)
+
+	AddAggregator("basicstats", NewBasicStats)
+
+	AddAggregator("histogram", NewHistogramAggregator)
 }
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// carbon2Sanitizer replaces the separators of the carbon2 protocol in the
+// names and values of the tags.
+var carbon2Sanitizer = strings.NewReplacer(" ", "_", "=", "_")
+
+// Carbon2Serializer writes the numeric and boolean fields of the metrics in
+// the carbon2 protocol of Metrics 2.0, a line per field: the metric and
+// field intrinsic tags, then the tags of the metric by key, two spaces, the
+// value and the timestamp.
+type Carbon2Serializer struct {
+}
+
+func NewCarbon2Serializer() (Serializer, error) {
+	return &Carbon2Serializer{}, nil
+}
+
+func (s *Carbon2Serializer) Serialize(m Metric) ([]byte, error) {
+	tags := m.Tags()
+	tagKeys := make([]string, 0, len(tags))
+	for k := range tags {
+		tagKeys = append(tagKeys, k)
+	}
+	sort.Strings(tagKeys)
+	var meta string
+	for _, k := range tagKeys {
+		meta += " " + carbon2Sanitizer.Replace(k) + "=" +
+			carbon2Sanitizer.Replace(tags[k])
+	}
+
+	fields := m.Fields()
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var out []byte
+	timestamp := m.UnixNano() / 1000000000
+	for _, k := range keys {
+		value, ok := graphiteValue(fields[k])
+		if !ok {
+			continue
+		}
+		out = append(out, fmt.Sprintf("metric=%s field=%s%s  %s %d\n",
+			carbon2Sanitizer.Replace(m.Name()), carbon2Sanitizer.Replace(k),
+			meta, value, timestamp)...)
+	}
+	return out, nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// ClockStepThreshold is the smallest jump of the wall clock taken for a
+// step, as done by an NTP step or a manual date change, rather than the
+// drift of the clock, 0 disabling the detection of the steps. It is set by
+// the agent from its config.
+var ClockStepThreshold = 2 * time.Second
+
+// clockStep returns how far the wall clock jumped between the prev and now
+// readings of time.Now, the difference between the wall and the monotonic
+// time elapsed, or 0 if the jump is under ClockStepThreshold. The tickers
+// run on the monotonic clock, so only the timestamps are thrown off.
+func clockStep(prev, now time.Time) time.Duration {
+	if ClockStepThreshold <= 0 {
+		return 0
+	}
+	step := now.Round(0).Sub(prev.Round(0)) - now.Sub(prev)
+	if step > -ClockStepThreshold && step < ClockStepThreshold {
+		return 0
+	}
+	return step
+}
+
+// clockState tracks the steps of the wall clock seen by the agent.
+type clockState struct {
+	sync.Mutex
+	// the range of timestamps stepped back over by the last backward step,
+	// which the metrics timestamped in duplicate the ones written before
+	overlapStart, overlapEnd time.Time
+}
+
+// watchClock checks the wall clock for steps every second until shutdown,
+// logging and counting them, and recording the range of timestamps a
+// backward step makes the metrics overlap.
+func (a *Agent) watchClock(shutdown chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	last := time.Now()
+	for {
+		select {
+		case <-shutdown:
+			return
+		case <-ticker.C:
+			now := time.Now()
+			step := clockStep(last, now)
+			last = now
+			if step == 0 {
+				continue
+			}
+			a.clockSteps.Incr(1)
+			if step > 0 {
+				log.Printf("W! The wall clock stepped forward by %s", step)
+				continue
+			}
+			log.Printf("W! The wall clock stepped back by %s, applying %q "+
+				"to the metrics timestamped until %s", -step,
+				a.Config.Agent.ClockStepAction,
+				now.Round(0).Add(-step).Format(time.RFC3339))
+			a.clock.Lock()
+			a.clock.overlapStart = now.Round(0)
+			a.clock.overlapEnd = now.Round(0).Add(-step)
+			a.clock.Unlock()
+		}
+	}
+}
+
+// guardClockStep applies the clock_step_action to the metrics timestamped
+// in the range a backward step of the wall clock went back over. It returns
+// nil if the metric must be dropped.
+func (a *Agent) guardClockStep(m Metric) Metric {
+	if a.Config.Agent.ClockStepAction == "ignore" {
+		return m
+	}
+	a.clock.Lock()
+	start, end := a.clock.overlapStart, a.clock.overlapEnd
+	a.clock.Unlock()
+	t := m.Time()
+	if end.IsZero() || t.Before(start) || t.After(end) {
+		return m
+	}
+
+	if a.Config.Agent.ClockStepAction == "drop" {
+		a.droppedClockStep.Incr(1)
+		return nil
+	}
+	tags := m.Tags()
+	tags["clock_step"] = "true"
+	flagged, err := New(m.Name(), tags, m.Fields(), t, m.Type())
+	if err != nil {
+		log.Printf("E! Could not flag the clock step of metric %s: %s",
+			m.Name(), err)
+		a.droppedClockStep.Incr(1)
+		return nil
+	}
+	flagged.SetAggregate(m.IsAggregate())
+	return flagged
+}
@@ -43,25 +43,43 @@ type Config struct {
 	InputFilters  []string
 	OutputFilters []string
 
-	Agent   *AgentConfig
-	Inputs  []*RunningInput
-	Outputs []*RunningOutput
+	Agent       *AgentConfig
+	Inputs      []*RunningInput
+	Outputs     []*RunningOutput
+	Aggregators []*RunningAggregator
+	Processors  RunningProcessors
+
+	// Profiles are the tables of the named profiles defined in the config
+	// files, applied over the rest of the config when selected, and Profile
+	// is the one applied.
+	Profiles map[string][]*profileTable
+	Profile  string
+
+	// Schema declares the measurements expected, nil if not enforced.
+	Schema *Schema
 }
 
 func NewConfig() *Config {
 	c := &Config{
 		// Agent defaults:
 		Agent: &AgentConfig{
-			Interval:      Duration{Duration: 10 * time.Second},
-			RoundInterval: true,
-			FlushInterval: Duration{Duration: 10 * time.Second},
+			Interval:           Duration{Duration: 10 * time.Second},
+			RoundInterval:      true,
+			FlushInterval:      Duration{Duration: 10 * time.Second},
+			DNSCacheTTL:        Duration{Duration: DEFAULT_DNS_CACHE_TTL},
+			ClockStepThreshold: Duration{Duration: 2 * time.Second},
+			ZoneMode:           "auto",
+			ZoneTag:            "zone",
 		},
 
 		Tags:          make(map[string]string),
 		Inputs:        make([]*RunningInput, 0),
 		Outputs:       make([]*RunningOutput, 0),
+		Aggregators:   make([]*RunningAggregator, 0),
+		Processors:    make([]*RunningProcessor, 0),
 		InputFilters:  make([]string, 0),
 		OutputFilters: make([]string, 0),
+		Profiles:      make(map[string][]*profileTable),
 	}
 	return c
 }
@@ -84,6 +102,49 @@ type AgentConfig struct {
 	Quiet               bool
 	Hostname            string
 	OmitHostname        bool
+
+	// StateDirectory holds the writable state of the agent: the Pidfile and
+	// Logfile are resolved in it when relative, and written to it when their
+	// path cannot be, as in sparse-root and read-only zones.
+	StateDirectory string `toml:"state_directory"`
+	Pidfile        string
+
+	// Profile is the profile applied by default, unless one is selected
+	// with the --profile flag or switched to with the profile command.
+	Profile string
+
+	// StartupTimeout bounds the time the plugins have to initialize when
+	// the agent starts, zero waiting for them however long they take.
+	StartupTimeout Duration `toml:"startup_timeout"`
+
+	// DNSCacheTTL is how long output plugins cache resolved hostnames at
+	// most, the TTL of their records when shorter.
+	DNSCacheTTL Duration `toml:"dns_cache_ttl"`
+
+	// RequiredTags must be present on every metric sent to the outputs.
+	// Missing tags are set from RequiredTagDefaults if it has a value for
+	// them, otherwise the metric is dropped.
+	RequiredTags        []string          `toml:"required_tags"`
+	RequiredTagDefaults map[string]string `toml:"required_tag_defaults"`
+
+	// TimestampMaxPast and TimestampMaxFuture bound how far a metric timestamp
+	// may be from the current time before TimestampGuardAction is applied.
+	TimestampMaxPast     Duration `toml:"timestamp_max_past"`
+	TimestampMaxFuture   Duration `toml:"timestamp_max_future"`
+	TimestampGuardAction string   `toml:"timestamp_guard_action"`
+
+	// ClockStepThreshold is the smallest jump of the wall clock taken for a
+	// step, and ClockStepAction what is done with the metrics timestamped
+	// in the range a backward step goes back over.
+	ClockStepThreshold Duration `toml:"clock_step_threshold"`
+	ClockStepAction    string   `toml:"clock_step_action"`
+
+	// ZoneMode is "auto" to detect whether the agent runs in a non-global
+	// zone, "delegated" to always run as in one, or "global" to never.
+	// In a non-global zone the inputs that cannot work there are disabled,
+	// and the metrics get the ZoneTag tag, unless it is empty.
+	ZoneMode string `toml:"zone_mode"`
+	ZoneTag  string `toml:"zone_tag"`
 }
 
 // ListTags returns a string of tags specified in the config,
@@ -174,11 +235,254 @@ var header = `# Telegraf Configuration
   ## Specify the log file name. The empty string means to log to stderr.
   logfile = ""
 
+  ## Directory for the writable state of the agent, created if missing.
+  ## Relative logfile and pidfile paths are resolved in it, and it is used
+  ## instead of their directory when it cannot be written, as in sparse-root
+  ## and read-only zones. When it cannot be written itself, /var/run/telegraf
+  ## is used.
+  # state_directory = "/var/telegraf"
+  ## File to write the pid of the agent to, the --pidfile flag overrides it.
+  # pidfile = "telegraf.pid"
+
   ## Override default hostname, if empty use os.Hostname()
   hostname = ""
   ## If set to true, do no set the "host" tag in the telegraf agent.
   omit_hostname = false
 
+  ## The plugins are initialized concurrently when the agent starts, after
+  ## the profile is applied and the inputs unsupported in the zone are
+  ## disabled. The agent fails to start if they are not all initialized
+  ## within startup_timeout, "0s" waiting for them however long they take.
+  ## The time each one takes is logged in debug mode.
+  # startup_timeout = "0s"
+
+  ## How long output plugins cache resolved hostnames before looking them up
+  ## again, at most: the hostnames are cached for the TTL of their DNS
+  ## records when shorter, and for dns_cache_ttl when not resolved with DNS,
+  ## as from the hosts file. Connections are re-established when the
+  ## addresses change, which lets outputs follow DNS based failover. "0s"
+  ## resolves on every write.
+  dns_cache_ttl = "60s"
+
+  ## Tags every metric must carry before it is written to the outputs.
+  ## Missing tags are set from required_tag_defaults when it has a value for
+  ## them, otherwise the metric is dropped and counted in internal_dropped.
+  # required_tags = ["tenant", "environment"]
+  # required_tag_defaults = {environment = "production"}
+
+  ## Guard against metrics timestamped too far in the past or future, for
+  ## example epochs misparsed by exec scripts. "0s" disables either check.
+  # timestamp_max_past = "0s"
+  # timestamp_max_future = "0s"
+  ## What to do with such metrics: "correct" sets their timestamp to the
+  ## current time, "drop" discards them, and "tag" adds a timestamp_invalid
+  ## tag but keeps the timestamp.
+  # timestamp_guard_action = "correct"
+
+  ## The intervals are timed on the monotonic clock, so that a step of the
+  ## wall clock, by NTP or a manual date change, does not shift the gathers,
+  ## but it shifts the timestamps. Steps of at least clock_step_threshold
+  ## are logged and counted in internal_agent, "0s" disabling the check.
+  ## After a backward step, the metrics timestamped in the range already
+  ## gone through would duplicate the ones written then: "tag" adds a
+  ## clock_step tag to them, "drop" discards them, and "ignore" writes them
+  ## as they are.
+  # clock_step_threshold = "2s"
+  # clock_step_action = "tag"
+
+  ## Running in a non-global zone: "auto" detects it with zonename, then
+  ## disables the inputs that cannot work in the zone, for lack of the
+  ## global zone, of privileges or of kstats, instead of letting them fail
+  ## on every gather. "delegated" always runs as in a non-global zone, and
+  ## "global" never does.
+  # zone_mode = "auto"
+  ## Tag added to every metric with the name of the non-global zone, unless
+  ## set in global_tags. An empty tag disables it.
+  # zone_tag = "zone"
+
+  ## Profile applied by default, see the profiles below. The --profile flag
+  ## overrides it, and "telegraf profile <name>" switches the running agent
+  ## to another one, kept over restarts until "telegraf profile" switches it
+  ## back.
+  # profile = "baseline"
+
+
+# Named profiles, applied over the rest of the config when selected: their
+# agent settings and global tags override the ones above, and their plugins
+# are added to the ones of the config.
+# [profiles.deep-debug.agent]
+#   interval = "1s"
+#   debug = true
+# [[profiles.deep-debug.inputs.interrupts]]
+#   sample_interval = "1s"
+
+
+# Schema of the measurements expected from the inputs, with the type of
+# each of their fields: "integer", "float", "boolean" or "string". The
+# metrics of a declared measurement that do not conform to it are dropped
+# with action = "drop", or with action = "coerce" stripped of their
+# undeclared fields and converted to the declared types. Strict also drops
+# the measurements not declared. The metrics dropped are counted in
+# internal_dropped, and the ones coerced in internal_agent. The schema only
+# applies to the metrics of the inputs, the aggregates of the aggregators,
+# with fields such as usage_mean or usage_count, are written as they are.
+# [schema]
+#   strict = false
+#   action = "drop"
+# [[schema.measurement]]
+#   name = "app_requests"
+#   fields = {count = "integer", latency = "float", path = "string"}
+
+
+# Dual-resolution emission: aggregators only take the measurements matching
+# their measurements globs when set, outputs with resolution = "raw" only
+# write the metrics as gathered, and outputs with resolution = "aggregated"
+# the metrics of the aggregators along with the ones no aggregator takes.
+# The aggregators must keep the originals, drop_original being false.
+# [[outputs.influxdb]]
+#   database = "short_retention"
+#   resolution = "raw"
+# [[outputs.influxdb]]
+#   database = "long_retention"
+#   resolution = "aggregated"
+# [[aggregators.basicstats]]
+#   period = "5m"
+#   measurements = ["cpu", "diskio"]
+
+# Every output takes max_payload_bytes, the maximum size of the batches
+# written at once: larger batches are split, and metrics larger than it
+# alone are dropped, logged and counted in internal_dropped. The size is the
+# one of the data format of the output, or of the line protocol for the
+# outputs without one. 0 does not limit it.
+#   max_payload_bytes = 1000000
+
+# With strict_ordering, an output writes the metrics of each series, the
+# measurement and tags, in timestamp order, across the retries of failed
+# writes: the batches are sorted by timestamp, and wait behind the metrics
+# to write again, and the metrics older than the last one written of their
+# series, as after a partial write, are dropped, logged and counted in
+# internal_dropped. The series are forgotten once the last metric written
+# of theirs is older than strict_ordering_window, ten flush_interval by
+# default: a metric of a series forgotten is written whatever its
+# timestamp.
+#   strict_ordering = true
+#   # strict_ordering_window = "100s"
+
+# The alias of an output tags the counters of the metrics it dropped in
+# internal_dropped, which are otherwise shared by the outputs of the same
+# plugin.
+#   alias = "long_retention"
+
+# The influxdb, webhook, kafka, amqp and socket_writer outputs can resolve
+# their endpoints from a service registry instead of their config: the SRV
+# records of service, or the instances of the Consul service, by priority or
+# the nearest first.
+# With health_check, only the SRV targets accepting a connection and the
+# Consul instances passing their checks are used. The endpoints are
+# resolved again every refresh_interval and after a failed write, and the
+# output reconnects when they change; when the registry cannot be reached,
+# the output keeps its endpoints.
+# [[outputs.influxdb]]
+#   database = "telegraf"
+#   [outputs.influxdb.discovery]
+#     source = "srv"   # or "consul"
+#     service = "_influxdb._tcp.metrics.example.com"
+#     ## endpoints are scheme://host:port/path, or host:port without scheme
+#     scheme = "http"
+#     # path = ""
+#     # health_check = true
+#     # consul_address = "http://127.0.0.1:8500"
+#     # consul_tag = ""
+#     # consul_datacenter = ""
+#     # consul_token = ""
+#     # refresh_interval = "60s"
+#     # timeout = "5s"
+
+# The influxdb, otlp and webhook outputs can authenticate with short-lived
+# bearer tokens, renewed refresh_before they expire from an OAuth2 token
+# endpoint with the client credentials grant, or from the output of a
+# command printing the token alone or an OAuth2 token response. Failed
+# renewals are retried after retry_backoff, doubled up to 5 minutes, the
+# output keeping its previous token meanwhile.
+# [[outputs.otlp]]
+#   endpoint = "https://gateway.example.com:4318"
+#   [outputs.otlp.token]
+#     source = "oauth2"   # or "exec"
+#     token_url = "https://auth.example.com/oauth2/token"
+#     client_id = "telegraf"
+#     client_secret = "s3cret"
+#     # scopes = ["metrics.write"]
+#     # audience = ""
+#     ## with the exec source, the command printing the token
+#     # command = ["/usr/local/bin/vault-token", "metrics"]
+#     ## lifetime of the tokens the source gives no expiry of
+#     # lifetime = "10m"
+#     # refresh_before = "1m"
+#     # retry_backoff = "5s"
+#     # timeout = "10s"
+#     # ssl_ca = "/etc/telegraf/ca.pem"
+
+# The influxdb, otlp, webhook, servicenow and icinga2 outputs can
+# authenticate with Kerberos, the Negotiate scheme of SPNEGO, to the services
+# behind a single sign-on accepting Kerberos only, instead of a token or of
+# their username and password. The principal logs in with its AES keys of
+# the keytab and gets the tickets of the <service>/<host> principals of the
+# hosts of the urls, renewed before they expire.
+# [[outputs.influxdb]]
+#   urls = ["https://influxdb.example.com:8086"]
+#   [outputs.influxdb.kerberos]
+#     # keytab = "/etc/krb5.keytab"
+#     ## defaults to the principal of the first key of the keytab
+#     # principal = "host/web01.example.com@EXAMPLE.COM"
+#     ## defaults to the _kerberos._tcp SRV records of the realm
+#     # kdcs = ["kdc1.example.com:88"]
+#     # service = "HTTP"
+#     # timeout = "10s"
+
+# On multi-homed hosts, the network inputs and outputs can be bound to the
+# address of the network their traffic must go through: local_address, or
+# interface for the first address of the interface of the family of the
+# peer, looked up at each connection. The socket_listener, http_listener_v2,
+# otlp and syslog inputs and the prometheus_client output listen on it
+# instead of the host of their address; the influxdb, otlp, webhook,
+# servicenow, icinga2, nsca, zabbix, statsd, kafka, amqp and socket_writer
+# outputs connect from it, to their SOCKS5 proxy with one. Unix sockets
+# cannot be bound.
+# [[outputs.influxdb]]
+#   urls = ["http://influxdb.mgmt.example.com:8086"]
+#   local_address = "10.1.0.12"
+#   # interface = "net1"
+
+# The metrics of any input or output can be filtered with globs: namepass
+# and namedrop on the measurement, fieldpass and fielddrop on the fields,
+# the metrics left without fields being dropped, and the tagpass and tagdrop
+# tables of globs of the values of tags, passing or dropping the metrics
+# with one of the tags matching. The tables go last in the plugin.
+# [[inputs.kstat]]
+#   namepass = ["zfs_*", "nic"]
+#   fielddrop = ["*_hwm", "crtime", "snaptime"]
+#   [inputs.kstat.tagpass]
+#     link = ["net*", "aggr*"]
+#   [inputs.kstat.tagdrop]
+#     zone = ["build-*"]
+
+# Any input can be gathered at its own interval, overriding the one of the
+# agent, for the costly ones to be gathered less often than cpu or mem. The
+# collection_jitter of the agent still delays each of their gathers, so that
+# the inputs of the same interval do not all gather at the same time.
+# [[inputs.fmadm]]
+#   interval = "5m"
+
+# Any input can be restricted to a schedule, a cron expression of minute,
+# hour, day of month, month and day of week in local time: it is then
+# gathered once in each minute matching it, whatever its interval. The
+# blackout_windows, daily "HH:MM-HH:MM" ranges, optionally prefixed by the
+# days they apply to, skip the gathers falling in them, past midnight for
+# the ones ending before they start.
+# [[inputs.pkg]]
+#   schedule = "*/15 * * * *"
+#   blackout_windows = ["Mon-Fri 01:00-04:30", "Sat,Sun 22:00-02:00"]
+
 
 ###############################################################################
 #                            OUTPUT PLUGINS                                   #
@@ -190,6 +494,11 @@ var processorHeader = `
 ###############################################################################
 #                            PROCESSOR PLUGINS                                #
 ###############################################################################
+
+# The processors run in the order they are configured, the files of the
+# config directory in order, unless they set an order: the ones of the
+# lowest order run first, 0 by default, and the ones of the same order in
+# the order they are configured.
 `
 
 var aggregatorHeader = `
@@ -222,6 +531,24 @@ func (c *Config) InputNames() []string {
 	return name
 }
 
+// AggregatorNames returns a list of strings of the configured aggregators.
+func (c *Config) AggregatorNames() []string {
+	var name []string
+	for _, aggregator := range c.Aggregators {
+		name = append(name, aggregator.Config.Name)
+	}
+	return name
+}
+
+// ProcessorNames returns a list of strings of the configured processors.
+func (c *Config) ProcessorNames() []string {
+	var name []string
+	for _, processor := range c.Processors {
+		name = append(name, processor.Name)
+	}
+	return name
+}
+
 // Outputs returns a list of strings of the configured outputs.
 func (c *Config) OutputNames() []string {
 	var name []string
@@ -289,6 +616,13 @@ func (c *Config) LoadConfig(path string) error {
 	if err != nil {
 		return fmt.Errorf("Error parsing %s, %s", path, err)
 	}
+	return c.loadTable(path, tbl, true)
+}
+
+// loadTable applies the tables of the config file at path to c. The
+// profiles tables are only allowed at the top of the file, not in profiles.
+func (c *Config) loadTable(path string, tbl *Table, profiles bool) error {
+	var err error
 
 	// Parse tags tables first:
 	for _, tableName := range []string{"tags", "global_tags"} {
@@ -325,6 +659,24 @@ func (c *Config) LoadConfig(path string) error {
 
 		switch name {
 		case "agent", "global_tags", "tags":
+		case "schema":
+			if c.Schema == nil {
+				c.Schema = &Schema{}
+			}
+			if err = UnmarshalTable(subTable, c.Schema); err != nil {
+				log.Printf("E! Could not parse [schema] config\n")
+				return fmt.Errorf("Error parsing %s, %s", path, err)
+			}
+			if err = c.Schema.init(); err != nil {
+				return fmt.Errorf("Error parsing %s, %s", path, err)
+			}
+		case "profiles":
+			if !profiles {
+				return fmt.Errorf("%s: profiles cannot be nested", path)
+			}
+			if err = c.addProfiles(path, subTable); err != nil {
+				return fmt.Errorf("Error parsing %s, %s", path, err)
+			}
 		case "outputs":
 			for pluginName, pluginVal := range subTable.Fields {
 				switch pluginSubTable := pluginVal.(type) {
@@ -344,6 +696,45 @@ func (c *Config) LoadConfig(path string) error {
 						pluginName, path)
 				}
 			}
+		case "processors":
+			// the processors are added in the order of their tables in the
+			// file, which is the order they run in unless they set one
+			var tables []*Table
+			names := make(map[*Table]string)
+			for pluginName, pluginVal := range subTable.Fields {
+				switch pluginSubTable := pluginVal.(type) {
+				case []*Table:
+					for _, t := range pluginSubTable {
+						tables = append(tables, t)
+						names[t] = pluginName
+					}
+				default:
+					return fmt.Errorf("Unsupported config format: %s, file %s",
+						pluginName, path)
+				}
+			}
+			sort.Slice(tables, func(i, j int) bool {
+				return tables[i].Position.Begin < tables[j].Position.Begin
+			})
+			for _, t := range tables {
+				if err = c.addProcessor(names[t], t); err != nil {
+					return fmt.Errorf("Error parsing %s, %s", path, err)
+				}
+			}
+		case "aggregators":
+			for pluginName, pluginVal := range subTable.Fields {
+				switch pluginSubTable := pluginVal.(type) {
+				case []*Table:
+					for _, t := range pluginSubTable {
+						if err = c.addAggregator(pluginName, t); err != nil {
+							return fmt.Errorf("Error parsing %s, %s", path, err)
+						}
+					}
+				default:
+					return fmt.Errorf("Unsupported config format: %s, file %s",
+						pluginName, path)
+				}
+			}
 		case "inputs", "plugins":
 			for pluginName, pluginVal := range subTable.Fields {
 				switch pluginSubTable := pluginVal.(type) {
@@ -369,6 +760,11 @@ func (c *Config) LoadConfig(path string) error {
 			}
 		}
 	}
+
+	// the processors of the same order run in the order they are added
+	if len(c.Processors) > 1 {
+		sort.SliceStable(c.Processors, c.Processors.Less)
+	}
 	return nil
 }
 
@@ -384,9 +780,11 @@ func (c *Config) addOutput(name string, table *Table) error {
 
 	// If the output has a SetSerializer function, then this means it can write
 	// arbitrary types of output, so build the serializer and set it.
+	var serializer Serializer
 	switch t := output.(type) {
 	case SerializerOutput:
-		serializer, err := buildSerializer(name, table)
+		var err error
+		serializer, err = buildSerializer(name, table)
 		if err != nil {
 			return err
 		}
@@ -397,10 +795,35 @@ func (c *Config) addOutput(name string, table *Table) error {
 	if err != nil {
 		return err
 	}
+	outputConfig.serializer = serializer
+	if _, ok := output.(EndpointOutput); !ok && outputConfig.Discovery != nil {
+		return fmt.Errorf("output %s does not support discovery", name)
+	}
+	if _, ok := output.(TokenOutput); !ok && outputConfig.Token != nil {
+		return fmt.Errorf("output %s does not support token authentication", name)
+	}
+	if _, ok := output.(KerberosOutput); !ok && outputConfig.Kerberos != nil {
+		return fmt.Errorf("output %s does not support kerberos authentication", name)
+	}
+
+	// The local_address and interface options are only those of the binding
+	// plugins, other plugins may have options of the same names.
+	var binding *LocalBinding
+	if _, ok := output.(BindingPlugin); ok {
+		if binding, err = buildLocalBinding(name, table); err != nil {
+			return err
+		}
+	}
 
 	if err := UnmarshalTable(table, output); err != nil {
 		return err
 	}
+	if outputConfig.Kerberos != nil {
+		output.(KerberosOutput).SetKerberos(outputConfig.Kerberos)
+	}
+	if binding != nil {
+		output.(BindingPlugin).SetLocalBinding(binding)
+	}
 
 	ro := NewRunningOutput(name, output, outputConfig,
 		c.Agent.MetricBatchSize, c.Agent.MetricBufferLimit)
@@ -408,6 +831,47 @@ func (c *Config) addOutput(name string, table *Table) error {
 	return nil
 }
 
+func (c *Config) addAggregator(name string, table *Table) error {
+	creator, ok := Aggregators[name]
+	if !ok {
+		return fmt.Errorf("Undefined but requested aggregator: %s", name)
+	}
+	aggregator := creator()
+
+	conf, err := buildAggregator(name, table)
+	if err != nil {
+		return err
+	}
+
+	if err := UnmarshalTable(table, aggregator); err != nil {
+		return err
+	}
+
+	c.Aggregators = append(c.Aggregators, NewRunningAggregator(aggregator, conf))
+	return nil
+}
+
+func (c *Config) addProcessor(name string, table *Table) error {
+	creator, ok := Processors[name]
+	if !ok {
+		return fmt.Errorf("Undefined but requested processor: %s", name)
+	}
+	processor := creator()
+
+	processorConfig, err := buildProcessor(name, table)
+	if err != nil {
+		return err
+	}
+
+	if err := UnmarshalTable(table, processor); err != nil {
+		return err
+	}
+
+	rf := NewRunningProcessor(processor, processorConfig)
+	c.Processors = append(c.Processors, rf)
+	return nil
+}
+
 func (c *Config) addInput(name string, table *Table) error {
 	if len(c.InputFilters) > 0 && !sliceContains(name, c.InputFilters) {
 		return nil
@@ -439,9 +903,19 @@ func (c *Config) addInput(name string, table *Table) error {
 		return err
 	}
 
+	var binding *LocalBinding
+	if _, ok := input.(BindingPlugin); ok {
+		if binding, err = buildLocalBinding(name, table); err != nil {
+			return err
+		}
+	}
+
 	if err := UnmarshalTable(table, input); err != nil {
 		return err
 	}
+	if binding != nil {
+		input.(BindingPlugin).SetLocalBinding(binding)
+	}
 
 	rp := NewRunningInput(input, pluginConfig)
 	c.Inputs = append(c.Inputs, rp)
@@ -500,6 +974,22 @@ func AddOutput(name string, creator OutputCreator) {
 	Outputs[name] = creator
 }
 
+type AggregatorCreator func() Aggregator
+
+var Aggregators = map[string]AggregatorCreator{}
+
+func AddAggregator(name string, creator AggregatorCreator) {
+	Aggregators[name] = creator
+}
+
+type ProcessorCreator func() Processor
+
+var Processors = map[string]ProcessorCreator{}
+
+func AddProcessor(name string, creator ProcessorCreator) {
+	Processors[name] = creator
+}
+
 // PrintInputConfig prints the config usage of a single input.
 func PrintInputConfig(name string) error {
 	if creator, ok := Inputs[name]; ok {
@@ -520,6 +1010,34 @@ func PrintOutputConfig(name string) error {
 	return nil
 }
 
+// PrintAggregatorConfig prints the config usage of a single aggregator.
+func PrintAggregatorConfig(name string) error {
+	if creator, ok := Aggregators[name]; ok {
+		printConfig(name, creator(), "aggregators", false)
+	} else {
+		return errors.New(fmt.Sprintf("Aggregator %s not found", name))
+	}
+	return nil
+}
+
+// PrintProcessorConfig prints the config usage of a single processor.
+func PrintProcessorConfig(name string) error {
+	if creator, ok := Processors[name]; ok {
+		printConfig(name, creator(), "processors", false)
+	} else {
+		return errors.New(fmt.Sprintf("Processor %s not found", name))
+	}
+	return nil
+}
+
+// processorOrderConfig is the order option of every processor, printed
+// before the options of its sample config.
+var processorOrderConfig = `
+  ## Order the processor runs in among the processors, the lowest first.
+  ## The processors of the same order run in the order they are configured.
+  # order = 0
+`
+
 type printer interface {
 	Description() string
 	SampleConfig() string
@@ -534,6 +1052,9 @@ func printConfig(name string, p printer, op string, commented bool) {
 		op, name)
 
 	config := p.SampleConfig()
+	if op == "processors" {
+		config = processorOrderConfig + config
+	}
 	if config == "" {
 		fmt.Printf("\n%s  # no configuration\n\n", comment)
 	} else {
@@ -660,11 +1181,264 @@ func buildSerializer(name string, tbl *Table) (Serializer, error) {
 // Note: error exists in the return for future calls that might require error
 func buildOutput(name string, tbl *Table) (*OutputConfig, error) {
 	oc := &OutputConfig{
-		Name: name,
+		Name:       name,
+		Resolution: "all",
+	}
+
+	if node, ok := tbl.Fields["alias"]; ok {
+		if kv, ok := node.(*KeyValue); ok {
+			if str, ok := kv.Value.(*String); ok {
+				oc.Alias = str.Value
+			}
+		}
+	}
+
+	if node, ok := tbl.Fields["resolution"]; ok {
+		if kv, ok := node.(*KeyValue); ok {
+			if str, ok := kv.Value.(*String); ok {
+				oc.Resolution = str.Value
+			}
+		}
+	}
+	switch oc.Resolution {
+	case "all", "raw", "aggregated":
+	default:
+		return nil, fmt.Errorf("invalid resolution %q for output %s, expected "+
+			"all, raw or aggregated", oc.Resolution, name)
+	}
+
+	if node, ok := tbl.Fields["max_payload_bytes"]; ok {
+		if kv, ok := node.(*KeyValue); ok {
+			if b, ok := kv.Value.(*Integer); ok {
+				n, err := strconv.Atoi(b.Value)
+				if err != nil || n < 0 {
+					return nil, fmt.Errorf("invalid max_payload_bytes %q for "+
+						"output %s", b.Value, name)
+				}
+				oc.MaxPayloadBytes = n
+			}
+		}
+	}
+
+	if node, ok := tbl.Fields["strict_ordering"]; ok {
+		if kv, ok := node.(*KeyValue); ok {
+			if b, ok := kv.Value.(*Boolean); ok {
+				var err error
+				oc.StrictOrdering, err = strconv.ParseBool(b.Value)
+				if err != nil {
+					return nil, fmt.Errorf("invalid strict_ordering %q for "+
+						"output %s", b.Value, name)
+				}
+			}
+		}
+	}
+
+	if node, ok := tbl.Fields["strict_ordering_window"]; ok {
+		if kv, ok := node.(*KeyValue); ok {
+			if str, ok := kv.Value.(*String); ok {
+				dur, err := time.ParseDuration(str.Value)
+				if err != nil || dur <= 0 {
+					return nil, fmt.Errorf("invalid strict_ordering_window %q "+
+						"for output %s", str.Value, name)
+				}
+				oc.OrderingWindow = dur
+			}
+		}
+	}
+
+	if node, ok := tbl.Fields["discovery"]; ok {
+		if subtbl, ok := node.(*Table); ok {
+			oc.Discovery = newEndpointDiscovery()
+			if err := UnmarshalTable(subtbl, oc.Discovery); err != nil {
+				return nil, fmt.Errorf("could not parse the discovery of output "+
+					"%s: %s", name, err)
+			}
+			if err := oc.Discovery.init(); err != nil {
+				return nil, fmt.Errorf("output %s: %s", name, err)
+			}
+		}
+	}
+
+	if node, ok := tbl.Fields["token"]; ok {
+		if subtbl, ok := node.(*Table); ok {
+			oc.Token = newTokenSource()
+			if err := UnmarshalTable(subtbl, oc.Token); err != nil {
+				return nil, fmt.Errorf("could not parse the token of output "+
+					"%s: %s", name, err)
+			}
+			if err := oc.Token.init(); err != nil {
+				return nil, fmt.Errorf("output %s: %s", name, err)
+			}
+		}
+	}
+
+	if node, ok := tbl.Fields["kerberos"]; ok {
+		if subtbl, ok := node.(*Table); ok {
+			oc.Kerberos = newKerberos()
+			if err := UnmarshalTable(subtbl, oc.Kerberos); err != nil {
+				return nil, fmt.Errorf("could not parse the kerberos of output "+
+					"%s: %s", name, err)
+			}
+			if err := oc.Kerberos.init(); err != nil {
+				return nil, fmt.Errorf("output %s: %s", name, err)
+			}
+		}
+	}
+	if oc.Token != nil && oc.Kerberos != nil {
+		return nil, fmt.Errorf("output %s cannot authenticate with both a "+
+			"token and kerberos", name)
+	}
+
+	delete(tbl.Fields, "alias")
+	delete(tbl.Fields, "resolution")
+	delete(tbl.Fields, "max_payload_bytes")
+	delete(tbl.Fields, "strict_ordering")
+	delete(tbl.Fields, "strict_ordering_window")
+	delete(tbl.Fields, "discovery")
+	delete(tbl.Fields, "token")
+	delete(tbl.Fields, "kerberos")
+
+	var err error
+	if oc.Filter, err = buildFilter(name, tbl); err != nil {
+		return nil, err
 	}
 	return oc, nil
 }
 
+// buildAggregator parses Aggregator specific items from the ast.Table,
+// builds the filter and returns a
+// AggregatorConfig to be inserted into RunningAggregator
+func buildAggregator(name string, tbl *Table) (*AggregatorConfig, error) {
+	unsupportedFields := []string{"tagexclude", "taginclude"}
+	for _, field := range unsupportedFields {
+		if _, ok := tbl.Fields[field]; ok {
+			return nil, fmt.Errorf("%s is not supported for aggregator plugins (%s).",
+				field, name)
+		}
+	}
+
+	conf := &AggregatorConfig{
+		Name:   name,
+		Delay:  time.Millisecond * 100,
+		Period: time.Second * 30,
+	}
+
+	if node, ok := tbl.Fields["period"]; ok {
+		if kv, ok := node.(*KeyValue); ok {
+			if str, ok := kv.Value.(*String); ok {
+				dur, err := time.ParseDuration(str.Value)
+				if err != nil {
+					return nil, err
+				}
+
+				conf.Period = dur
+			}
+		}
+	}
+
+	if node, ok := tbl.Fields["delay"]; ok {
+		if kv, ok := node.(*KeyValue); ok {
+			if str, ok := kv.Value.(*String); ok {
+				dur, err := time.ParseDuration(str.Value)
+				if err != nil {
+					return nil, err
+				}
+
+				conf.Delay = dur
+			}
+		}
+	}
+
+	if node, ok := tbl.Fields["drop_original"]; ok {
+		if kv, ok := node.(*KeyValue); ok {
+			if b, ok := kv.Value.(*Boolean); ok {
+				var err error
+				conf.DropOriginal, err = strconv.ParseBool(b.Value)
+				if err != nil {
+					log.Printf("Error parsing boolean value for %s: %s\n", name, err)
+				}
+			}
+		}
+	}
+
+	if node, ok := tbl.Fields["name_prefix"]; ok {
+		if kv, ok := node.(*KeyValue); ok {
+			if str, ok := kv.Value.(*String); ok {
+				conf.MeasurementPrefix = str.Value
+			}
+		}
+	}
+
+	if node, ok := tbl.Fields["name_suffix"]; ok {
+		if kv, ok := node.(*KeyValue); ok {
+			if str, ok := kv.Value.(*String); ok {
+				conf.MeasurementSuffix = str.Value
+			}
+		}
+	}
+
+	if node, ok := tbl.Fields["name_override"]; ok {
+		if kv, ok := node.(*KeyValue); ok {
+			if str, ok := kv.Value.(*String); ok {
+				conf.NameOverride = str.Value
+			}
+		}
+	}
+
+	if node, ok := tbl.Fields["measurements"]; ok {
+		if kv, ok := node.(*KeyValue); ok {
+			if ary, ok := kv.Value.(*Array); ok {
+				for _, elem := range ary.Value {
+					if str, ok := elem.(*String); ok {
+						conf.Measurements = append(conf.Measurements, str.Value)
+					}
+				}
+			}
+		}
+	}
+
+	conf.Tags = make(map[string]string)
+	if node, ok := tbl.Fields["tags"]; ok {
+		if subtbl, ok := node.(*Table); ok {
+			if err := UnmarshalTable(subtbl, conf.Tags); err != nil {
+				return nil, fmt.Errorf("could not parse the tags of aggregator "+
+					"%s: %s", name, err)
+			}
+		}
+	}
+
+	delete(tbl.Fields, "period")
+	delete(tbl.Fields, "delay")
+	delete(tbl.Fields, "drop_original")
+	delete(tbl.Fields, "name_prefix")
+	delete(tbl.Fields, "name_suffix")
+	delete(tbl.Fields, "name_override")
+	delete(tbl.Fields, "measurements")
+	delete(tbl.Fields, "tags")
+	return conf, nil
+}
+
+// buildProcessor parses processor specific items from the ast.Table,
+// and returns a ProcessorConfig to be inserted into a RunningProcessor.
+func buildProcessor(name string, tbl *Table) (*ProcessorConfig, error) {
+	conf := &ProcessorConfig{Name: name}
+
+	if node, ok := tbl.Fields["order"]; ok {
+		if kv, ok := node.(*KeyValue); ok {
+			if b, ok := kv.Value.(*Integer); ok {
+				var err error
+				conf.Order, err = strconv.ParseInt(b.Value, 10, 64)
+				if err != nil {
+					log.Printf("E! Error parsing int value for %s: %s\n", name, err)
+				}
+			}
+		}
+	}
+
+	delete(tbl.Fields, "order")
+	return conf, nil
+}
+
 // buildParser grabs the necessary entries from the ast.Table for creating
 // a parsers.Parser object, and creates it, which can then be added onto
 // an Input object.
@@ -718,6 +1492,14 @@ func buildParser(name string, tbl *Table) (Parser, error) {
 		}
 	}
 
+	if node, ok := tbl.Fields["json_query"]; ok {
+		if kv, ok := node.(*KeyValue); ok {
+			if str, ok := kv.Value.(*String); ok {
+				c.JSONQuery = str.Value
+			}
+		}
+	}
+
 	if node, ok := tbl.Fields["data_type"]; ok {
 		if kv, ok := node.(*KeyValue); ok {
 			if str, ok := kv.Value.(*String); ok {
@@ -754,20 +1536,278 @@ func buildParser(name string, tbl *Table) (Parser, error) {
 		}
 	}
 
+	if node, ok := tbl.Fields["grok_patterns"]; ok {
+		if kv, ok := node.(*KeyValue); ok {
+			if ary, ok := kv.Value.(*Array); ok {
+				for _, elem := range ary.Value {
+					if str, ok := elem.(*String); ok {
+						c.GrokPatterns = append(c.GrokPatterns, str.Value)
+					}
+				}
+			}
+		}
+	}
+
+	if node, ok := tbl.Fields["grok_custom_patterns"]; ok {
+		if kv, ok := node.(*KeyValue); ok {
+			if str, ok := kv.Value.(*String); ok {
+				c.GrokCustomPatterns = str.Value
+			}
+		}
+	}
+
+	if node, ok := tbl.Fields["csv_header_row_count"]; ok {
+		if kv, ok := node.(*KeyValue); ok {
+			if integer, ok := kv.Value.(*Integer); ok {
+				n, err := strconv.Atoi(integer.Value)
+				if err != nil {
+					return nil, fmt.Errorf("invalid csv_header_row_count of %s: %s", name, err)
+				}
+				c.CSVHeaderRowCount = n
+			}
+		}
+	}
+
+	if node, ok := tbl.Fields["csv_skip_rows"]; ok {
+		if kv, ok := node.(*KeyValue); ok {
+			if integer, ok := kv.Value.(*Integer); ok {
+				n, err := strconv.Atoi(integer.Value)
+				if err != nil {
+					return nil, fmt.Errorf("invalid csv_skip_rows of %s: %s", name, err)
+				}
+				c.CSVSkipRows = n
+			}
+		}
+	}
+
+	if node, ok := tbl.Fields["csv_skip_columns"]; ok {
+		if kv, ok := node.(*KeyValue); ok {
+			if integer, ok := kv.Value.(*Integer); ok {
+				n, err := strconv.Atoi(integer.Value)
+				if err != nil {
+					return nil, fmt.Errorf("invalid csv_skip_columns of %s: %s", name, err)
+				}
+				c.CSVSkipColumns = n
+			}
+		}
+	}
+
+	if node, ok := tbl.Fields["csv_delimiter"]; ok {
+		if kv, ok := node.(*KeyValue); ok {
+			if str, ok := kv.Value.(*String); ok {
+				c.CSVDelimiter = str.Value
+			}
+		}
+	}
+
+	if node, ok := tbl.Fields["csv_comment"]; ok {
+		if kv, ok := node.(*KeyValue); ok {
+			if str, ok := kv.Value.(*String); ok {
+				c.CSVComment = str.Value
+			}
+		}
+	}
+
+	if node, ok := tbl.Fields["csv_trim_space"]; ok {
+		if kv, ok := node.(*KeyValue); ok {
+			if b, ok := kv.Value.(*Boolean); ok {
+				c.CSVTrimSpace, _ = strconv.ParseBool(b.Value)
+			}
+		}
+	}
+
+	if node, ok := tbl.Fields["csv_column_names"]; ok {
+		if kv, ok := node.(*KeyValue); ok {
+			if ary, ok := kv.Value.(*Array); ok {
+				for _, elem := range ary.Value {
+					if str, ok := elem.(*String); ok {
+						c.CSVColumnNames = append(c.CSVColumnNames, str.Value)
+					}
+				}
+			}
+		}
+	}
+
+	if node, ok := tbl.Fields["csv_column_types"]; ok {
+		if kv, ok := node.(*KeyValue); ok {
+			if ary, ok := kv.Value.(*Array); ok {
+				for _, elem := range ary.Value {
+					if str, ok := elem.(*String); ok {
+						c.CSVColumnTypes = append(c.CSVColumnTypes, str.Value)
+					}
+				}
+			}
+		}
+	}
+
+	if node, ok := tbl.Fields["csv_tag_columns"]; ok {
+		if kv, ok := node.(*KeyValue); ok {
+			if ary, ok := kv.Value.(*Array); ok {
+				for _, elem := range ary.Value {
+					if str, ok := elem.(*String); ok {
+						c.CSVTagColumns = append(c.CSVTagColumns, str.Value)
+					}
+				}
+			}
+		}
+	}
+
+	if node, ok := tbl.Fields["csv_measurement_column"]; ok {
+		if kv, ok := node.(*KeyValue); ok {
+			if str, ok := kv.Value.(*String); ok {
+				c.CSVMeasurementColumn = str.Value
+			}
+		}
+	}
+
+	if node, ok := tbl.Fields["csv_timestamp_column"]; ok {
+		if kv, ok := node.(*KeyValue); ok {
+			if str, ok := kv.Value.(*String); ok {
+				c.CSVTimestampColumn = str.Value
+			}
+		}
+	}
+
+	if node, ok := tbl.Fields["csv_timestamp_format"]; ok {
+		if kv, ok := node.(*KeyValue); ok {
+			if str, ok := kv.Value.(*String); ok {
+				c.CSVTimestampFormat = str.Value
+			}
+		}
+	}
+
 	c.MetricName = name
 
 	delete(tbl.Fields, "data_format")
 	delete(tbl.Fields, "separator")
 	delete(tbl.Fields, "templates")
 	delete(tbl.Fields, "tag_keys")
+	delete(tbl.Fields, "json_query")
 	delete(tbl.Fields, "data_type")
 	delete(tbl.Fields, "collectd_auth_file")
 	delete(tbl.Fields, "collectd_security_level")
 	delete(tbl.Fields, "collectd_typesdb")
+	delete(tbl.Fields, "grok_patterns")
+	delete(tbl.Fields, "grok_custom_patterns")
+	delete(tbl.Fields, "csv_header_row_count")
+	delete(tbl.Fields, "csv_skip_rows")
+	delete(tbl.Fields, "csv_skip_columns")
+	delete(tbl.Fields, "csv_delimiter")
+	delete(tbl.Fields, "csv_comment")
+	delete(tbl.Fields, "csv_trim_space")
+	delete(tbl.Fields, "csv_column_names")
+	delete(tbl.Fields, "csv_column_types")
+	delete(tbl.Fields, "csv_tag_columns")
+	delete(tbl.Fields, "csv_measurement_column")
+	delete(tbl.Fields, "csv_timestamp_column")
+	delete(tbl.Fields, "csv_timestamp_format")
 
 	return NewParser(c)
 }
 
+// buildFilter builds the Filter (namepass/namedrop, tagpass/tagdrop,
+// fieldpass/fielddrop) of the glob filtering of the metrics, to be inserted
+// into the InputConfig or OutputConfig.
+func buildFilter(name string, tbl *Table) (Filter, error) {
+	f := Filter{}
+
+	if node, ok := tbl.Fields["namepass"]; ok {
+		if kv, ok := node.(*KeyValue); ok {
+			if ary, ok := kv.Value.(*Array); ok {
+				for _, elem := range ary.Value {
+					if str, ok := elem.(*String); ok {
+						f.NamePass = append(f.NamePass, str.Value)
+					}
+				}
+			}
+		}
+	}
+
+	if node, ok := tbl.Fields["namedrop"]; ok {
+		if kv, ok := node.(*KeyValue); ok {
+			if ary, ok := kv.Value.(*Array); ok {
+				for _, elem := range ary.Value {
+					if str, ok := elem.(*String); ok {
+						f.NameDrop = append(f.NameDrop, str.Value)
+					}
+				}
+			}
+		}
+	}
+
+	if node, ok := tbl.Fields["fieldpass"]; ok {
+		if kv, ok := node.(*KeyValue); ok {
+			if ary, ok := kv.Value.(*Array); ok {
+				for _, elem := range ary.Value {
+					if str, ok := elem.(*String); ok {
+						f.FieldPass = append(f.FieldPass, str.Value)
+					}
+				}
+			}
+		}
+	}
+
+	if node, ok := tbl.Fields["fielddrop"]; ok {
+		if kv, ok := node.(*KeyValue); ok {
+			if ary, ok := kv.Value.(*Array); ok {
+				for _, elem := range ary.Value {
+					if str, ok := elem.(*String); ok {
+						f.FieldDrop = append(f.FieldDrop, str.Value)
+					}
+				}
+			}
+		}
+	}
+
+	if node, ok := tbl.Fields["tagpass"]; ok {
+		if subtbl, ok := node.(*Table); ok {
+			for name, val := range subtbl.Fields {
+				if kv, ok := val.(*KeyValue); ok {
+					tf := TagFilter{Name: name}
+					if ary, ok := kv.Value.(*Array); ok {
+						for _, elem := range ary.Value {
+							if str, ok := elem.(*String); ok {
+								tf.Filter = append(tf.Filter, str.Value)
+							}
+						}
+					}
+					f.TagPass = append(f.TagPass, tf)
+				}
+			}
+		}
+	}
+
+	if node, ok := tbl.Fields["tagdrop"]; ok {
+		if subtbl, ok := node.(*Table); ok {
+			for name, val := range subtbl.Fields {
+				if kv, ok := val.(*KeyValue); ok {
+					tf := TagFilter{Name: name}
+					if ary, ok := kv.Value.(*Array); ok {
+						for _, elem := range ary.Value {
+							if str, ok := elem.(*String); ok {
+								tf.Filter = append(tf.Filter, str.Value)
+							}
+						}
+					}
+					f.TagDrop = append(f.TagDrop, tf)
+				}
+			}
+		}
+	}
+
+	if err := f.Validate(); err != nil {
+		return f, fmt.Errorf("%s: %s", name, err)
+	}
+
+	delete(tbl.Fields, "namepass")
+	delete(tbl.Fields, "namedrop")
+	delete(tbl.Fields, "fieldpass")
+	delete(tbl.Fields, "fielddrop")
+	delete(tbl.Fields, "tagpass")
+	delete(tbl.Fields, "tagdrop")
+	return f, nil
+}
+
 // buildInput parses input specific items from the ast.Table,
 // builds the filter and returns a
 // models.InputConfig to be inserted into models.RunningInput
@@ -786,6 +1826,84 @@ func buildInput(name string, tbl *Table) (*InputConfig, error) {
 		}
 	}
 
+	if node, ok := tbl.Fields["schedule"]; ok {
+		if kv, ok := node.(*KeyValue); ok {
+			if str, ok := kv.Value.(*String); ok {
+				schedule, err := ParseSchedule(str.Value)
+				if err != nil {
+					return nil, err
+				}
+
+				cp.Schedule = schedule
+			}
+		}
+	}
+
+	if node, ok := tbl.Fields["blackout_windows"]; ok {
+		if kv, ok := node.(*KeyValue); ok {
+			if ary, ok := kv.Value.(*Array); ok {
+				for _, elem := range ary.Value {
+					if str, ok := elem.(*String); ok {
+						window, err := ParseBlackoutWindow(str.Value)
+						if err != nil {
+							return nil, err
+						}
+
+						cp.BlackoutWindows = append(cp.BlackoutWindows, window)
+					}
+				}
+			}
+		}
+	}
+
+	cp.RestartPolicy = "on_failure"
+	if node, ok := tbl.Fields["restart_policy"]; ok {
+		if kv, ok := node.(*KeyValue); ok {
+			if str, ok := kv.Value.(*String); ok {
+				switch str.Value {
+				case "on_failure", "never":
+					cp.RestartPolicy = str.Value
+				default:
+					return nil, fmt.Errorf("invalid restart_policy %q, "+
+						"expected on_failure or never", str.Value)
+				}
+			}
+		}
+	}
+
+	cp.RestartDelay = 10 * time.Second
+	if node, ok := tbl.Fields["restart_delay"]; ok {
+		if kv, ok := node.(*KeyValue); ok {
+			if str, ok := kv.Value.(*String); ok {
+				dur, err := time.ParseDuration(str.Value)
+				if err != nil {
+					return nil, err
+				}
+				// a zero delay would restart a failing service in a loop
+				if dur <= 0 {
+					return nil, fmt.Errorf("invalid restart_delay %q for input "+
+						"%s, it must be positive", str.Value, name)
+				}
+
+				cp.RestartDelay = dur
+			}
+		}
+	}
+
+	if node, ok := tbl.Fields["probation"]; ok {
+		if kv, ok := node.(*KeyValue); ok {
+			if b, ok := kv.Value.(*Integer); ok {
+				n, err := strconv.Atoi(b.Value)
+				if err != nil || n < 0 {
+					return nil, fmt.Errorf("invalid probation %q, expected "+
+						"a number of gathers", b.Value)
+				}
+
+				cp.Probation = n
+			}
+		}
+	}
+
 	if node, ok := tbl.Fields["name_prefix"]; ok {
 		if kv, ok := node.(*KeyValue); ok {
 			if str, ok := kv.Value.(*String); ok {
@@ -823,6 +1941,16 @@ func buildInput(name string, tbl *Table) (*InputConfig, error) {
 	delete(tbl.Fields, "name_suffix")
 	delete(tbl.Fields, "name_override")
 	delete(tbl.Fields, "interval")
+	delete(tbl.Fields, "schedule")
+	delete(tbl.Fields, "blackout_windows")
+	delete(tbl.Fields, "restart_policy")
+	delete(tbl.Fields, "restart_delay")
+	delete(tbl.Fields, "probation")
 	delete(tbl.Fields, "tags")
+
+	var err error
+	if cp.Filter, err = buildFilter(name, tbl); err != nil {
+		return nil, err
+	}
 	return cp, nil
 }
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// CSVParser parses the rows of CSV data, a metric per row with a field per
+// column, named after the header rows or the column names of the config.
+// The values of the columns without a type are parsed as integers, floats
+// or booleans, or else kept as strings; empty values are left out.
+type CSVParser struct {
+	MetricName        string
+	HeaderRowCount    int
+	SkipRows          int
+	SkipColumns       int
+	Delimiter         string
+	Comment           string
+	TrimSpace         bool
+	ColumnNames       []string
+	ColumnTypes       []string
+	TagColumns        []string
+	MeasurementColumn string
+	TimestampColumn   string
+	TimestampFormat   string
+	DefaultTags       map[string]string
+
+	// rows skipped and header rows read by ParseLine so far, and the names
+	// of the columns once known
+	skipped int
+	headers int
+	names   []string
+}
+
+func NewCSVParser(config *ParserConfig) (*CSVParser, error) {
+	p := &CSVParser{
+		MetricName:        config.MetricName,
+		HeaderRowCount:    config.CSVHeaderRowCount,
+		SkipRows:          config.CSVSkipRows,
+		SkipColumns:       config.CSVSkipColumns,
+		Delimiter:         config.CSVDelimiter,
+		Comment:           config.CSVComment,
+		TrimSpace:         config.CSVTrimSpace,
+		ColumnNames:       config.CSVColumnNames,
+		ColumnTypes:       config.CSVColumnTypes,
+		TagColumns:        config.CSVTagColumns,
+		MeasurementColumn: config.CSVMeasurementColumn,
+		TimestampColumn:   config.CSVTimestampColumn,
+		TimestampFormat:   config.CSVTimestampFormat,
+		DefaultTags:       config.DefaultTags,
+	}
+	if p.HeaderRowCount == 0 && len(p.ColumnNames) == 0 {
+		return nil, fmt.Errorf("csv: csv_header_row_count or csv_column_names " +
+			"must be set")
+	}
+	if p.Delimiter == "" {
+		p.Delimiter = ","
+	}
+	if utf8.RuneCountInString(p.Delimiter) != 1 {
+		return nil, fmt.Errorf("csv: csv_delimiter must be a single character")
+	}
+	if utf8.RuneCountInString(p.Comment) > 1 {
+		return nil, fmt.Errorf("csv: csv_comment must be a single character")
+	}
+	if len(p.ColumnTypes) != 0 && len(p.ColumnTypes) != len(p.ColumnNames) {
+		return nil, fmt.Errorf("csv: csv_column_types must have a type per " +
+			"column of csv_column_names")
+	}
+	for _, typ := range p.ColumnTypes {
+		switch typ {
+		case "", "int", "float", "bool", "string":
+		default:
+			return nil, fmt.Errorf("csv: unknown column type %q", typ)
+		}
+	}
+	if p.TimestampColumn != "" && p.TimestampFormat == "" {
+		return nil, fmt.Errorf("csv: csv_timestamp_format is required with " +
+			"csv_timestamp_column")
+	}
+	if len(p.ColumnNames) != 0 {
+		p.names = p.ColumnNames
+	}
+	return p, nil
+}
+
+// Parse parses the rows of the buffer, after its header rows. The names of
+// the columns are those of the header of the buffer, unless set by the
+// config.
+func (p *CSVParser) Parse(buf []byte) ([]Metric, error) {
+	br := bufio.NewReader(bytes.NewReader(buf))
+	for i := 0; i < p.SkipRows; i++ {
+		if _, err := br.ReadString('\n'); err != nil {
+			return nil, nil
+		}
+	}
+
+	r := p.reader(br)
+	var header []string
+	for i := 0; i < p.HeaderRowCount; i++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("csv: %s", err)
+		}
+		header = p.addHeader(header, record)
+	}
+	names := p.ColumnNames
+	if len(names) == 0 {
+		names = header
+	}
+
+	var metrics []Metric
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return metrics, fmt.Errorf("csv: %s", err)
+		}
+		m, err := p.metric(names, record)
+		if err != nil {
+			return metrics, err
+		}
+		metrics = append(metrics, m)
+	}
+	return metrics, nil
+}
+
+// ParseLine parses a row of the data fed line by line, returning nil for
+// the rows skipped and the header rows, the first ones fed.
+func (p *CSVParser) ParseLine(line string) (Metric, error) {
+	if p.skipped < p.SkipRows {
+		p.skipped++
+		return nil, nil
+	}
+	record, err := p.reader(strings.NewReader(line)).Read()
+	if err == io.EOF {
+		// a comment
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("csv: %s", err)
+	}
+	if p.headers < p.HeaderRowCount {
+		p.headers++
+		header := p.addHeader(p.names, record)
+		if len(p.ColumnNames) == 0 {
+			p.names = header
+		}
+		return nil, nil
+	}
+	return p.metric(p.names, record)
+}
+
+func (p *CSVParser) SetDefaultTags(tags map[string]string) {
+	p.DefaultTags = tags
+}
+
+func (p *CSVParser) reader(r io.Reader) *csv.Reader {
+	cr := csv.NewReader(r)
+	cr.Comma, _ = utf8.DecodeRuneInString(p.Delimiter)
+	if p.Comment != "" {
+		cr.Comment, _ = utf8.DecodeRuneInString(p.Comment)
+	}
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = p.TrimSpace
+	return cr
+}
+
+// columns returns the values of the columns of the record left after the
+// skipped ones.
+func (p *CSVParser) columns(record []string) []string {
+	if len(record) <= p.SkipColumns {
+		return nil
+	}
+	record = record[p.SkipColumns:]
+	if p.TrimSpace {
+		for i := range record {
+			record[i] = strings.TrimSpace(record[i])
+		}
+	}
+	return record
+}
+
+// addHeader appends the columns of the header row to the names of the
+// columns, the names of the header rows being concatenated.
+func (p *CSVParser) addHeader(names []string, record []string) []string {
+	names = append([]string(nil), names...)
+	for i, name := range p.columns(record) {
+		if i < len(names) {
+			names[i] += name
+		} else {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func (p *CSVParser) metric(names []string, record []string) (Metric, error) {
+	measurement := p.MetricName
+	tags := make(map[string]string, len(p.DefaultTags))
+	for k, v := range p.DefaultTags {
+		tags[k] = v
+	}
+	fields := make(map[string]interface{})
+	timestamp := time.Now()
+	var found bool
+
+	for i, value := range p.columns(record) {
+		if i >= len(names) {
+			break
+		}
+		name := names[i]
+		if value == "" {
+			continue
+		}
+		switch {
+		case name == p.MeasurementColumn:
+			measurement = value
+		case name == p.TimestampColumn:
+			t, err := csvTimestamp(p.TimestampFormat, value)
+			if err != nil {
+				return nil, fmt.Errorf("csv: %s: %s", name, err)
+			}
+			timestamp, found = t, true
+		case sliceContains(name, p.TagColumns):
+			tags[name] = value
+		default:
+			var typ string
+			if i < len(p.ColumnTypes) {
+				typ = p.ColumnTypes[i]
+			}
+			v, err := csvValue(typ, value)
+			if err != nil {
+				return nil, fmt.Errorf("csv: %s: %s", name, err)
+			}
+			fields[name] = v
+		}
+	}
+
+	if p.TimestampColumn != "" && !found {
+		return nil, fmt.Errorf("csv: no value of the timestamp column %s",
+			p.TimestampColumn)
+	}
+	return New(measurement, tags, fields, timestamp)
+}
+
+// csvValue parses the value of a column of the type, guessing it if empty.
+func csvValue(typ, value string) (interface{}, error) {
+	switch typ {
+	case "int":
+		return strconv.ParseInt(value, 10, 64)
+	case "float":
+		return strconv.ParseFloat(value, 64)
+	case "bool":
+		return strconv.ParseBool(value)
+	case "string":
+		return value, nil
+	}
+	if v, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return v, nil
+	}
+	if v, err := strconv.ParseFloat(value, 64); err == nil {
+		return v, nil
+	}
+	if v, err := strconv.ParseBool(value); err == nil {
+		return v, nil
+	}
+	return value, nil
+}
+
+// csvTimestamp parses the timestamp in the format: unix, unix_ms, unix_us
+// or unix_ns for the epoch in seconds, fractional or not, or in
+// milli-, micro- or nanoseconds, or else a Go time layout.
+func csvTimestamp(format, value string) (time.Time, error) {
+	var unit time.Duration
+	switch format {
+	case "unix":
+		secs, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return time.Unix(0, int64(secs*float64(time.Second))), nil
+	case "unix_ms":
+		unit = time.Millisecond
+	case "unix_us":
+		unit = time.Microsecond
+	case "unix_ns":
+		unit = time.Nanosecond
+	default:
+		return time.ParseInLocation(format, value, time.Local)
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(0, n*int64(unit)), nil
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// discoverTimeout is the timeout of each command run to inspect the system.
+const discoverTimeout = 30 * time.Second
+
+// discoveredSystem is what discovery found running on the system.
+type discoveredSystem struct {
+	// the FMRIs of the online SMF services
+	services map[string]bool
+	// the TCP ports listened on
+	ports map[int]bool
+	// the installed packages, by name without the publisher
+	packages  map[string]bool
+	processes []*psinfo
+}
+
+// online returns the first online service matching the glob, or "".
+func (s *discoveredSystem) online(glob string) string {
+	var fmris []string
+	for fmri := range s.services {
+		if matchesAny(fmri, []string{glob}) {
+			fmris = append(fmris, fmri)
+		}
+	}
+	sort.Strings(fmris)
+	if len(fmris) == 0 {
+		return ""
+	}
+	return fmris[0]
+}
+
+// running returns the arguments of the processes the arguments of which
+// match the regular expression, sorted.
+func (s *discoveredSystem) running(re *regexp.Regexp) []string {
+	var args []string
+	for _, p := range s.processes {
+		if re.MatchString(p.psargs) {
+			args = append(args, p.psargs)
+		}
+	}
+	sort.Strings(args)
+	return args
+}
+
+// discoveredInput is an input suggested by discovery, with why and its
+// config table.
+type discoveredInput struct {
+	name   string
+	reason string
+	config string
+}
+
+// discoveryRule suggests inputs for what it finds on the system.
+type discoveryRule func(s *discoveredSystem) []*discoveredInput
+
+var (
+	oraclePmon = regexp.MustCompile(`^ora_pmon_(\w+)`)
+	kafkaMain  = regexp.MustCompile(`\bkafka\.Kafka\b`)
+	tomcatMain = regexp.MustCompile(`\borg\.apache\.catalina\.startup\.Bootstrap\b`)
+	jbossMain  = regexp.MustCompile(`\bjboss-modules\.jar\b`)
+)
+
+// discoveryRules are the rules of the inputs discovery knows of.
+var discoveryRules = []discoveryRule{
+	func(s *discoveredSystem) []*discoveredInput {
+		fmri := s.online("svc:/network/http:apache*")
+		if fmri == "" {
+			return nil
+		}
+		return []*discoveredInput{{
+			name:   "apache",
+			reason: fmt.Sprintf("service %s is online", fmri),
+			config: `  ## mod_status must be enabled with ExtendedStatus On
+  urls = ["http://localhost/server-status?auto"]
+`,
+		}}
+	},
+	func(s *discoveredSystem) []*discoveredInput {
+		reason := ""
+		if fmri := s.online("svc:/network/http:tomcat*"); fmri != "" {
+			reason = fmt.Sprintf("service %s is online", fmri)
+		} else if len(s.running(tomcatMain)) != 0 {
+			reason = "a Tomcat process is running"
+		} else {
+			return nil
+		}
+		return []*discoveredInput{{
+			name:   "tomcat",
+			reason: reason,
+			config: `  ## the manager application must be deployed with a user granted
+  ## the manager-status role
+  url = "http://127.0.0.1:8080/manager/status/all?XML=true"
+  # username = "tomcat"
+  # password = "s3cret"
+`,
+		}}
+	},
+	func(s *discoveredSystem) []*discoveredInput {
+		if len(s.running(jbossMain)) == 0 {
+			return nil
+		}
+		return []*discoveredInput{{
+			name:   "jboss",
+			reason: "a JBoss process is running",
+			config: `  servers = ["http://localhost:9990/management"]
+  username = ""
+  password = ""
+  authorization = "digest"
+`,
+		}}
+	},
+	func(s *discoveredSystem) []*discoveredInput {
+		server := s.online("svc:/network/nfs/server:*") != ""
+		client := s.online("svc:/network/nfs/client:*") != ""
+		if !server {
+			return nil
+		}
+		return []*discoveredInput{{
+			name:   "nfsstat",
+			reason: "the NFS server is online",
+			config: fmt.Sprintf("  client = %t\n  server = true\n", client),
+		}}
+	},
+	func(s *discoveredSystem) []*discoveredInput {
+		var found []*discoveredInput
+		for _, args := range s.running(oraclePmon) {
+			sid := oraclePmon.FindStringSubmatch(args)[1]
+			found = append(found, &discoveredInput{
+				name:   "procstat",
+				reason: fmt.Sprintf("the Oracle instance %s is running", sid),
+				config: fmt.Sprintf("  pattern = \"^ora_pmon_%s$\"\n", sid),
+			})
+		}
+		return found
+	},
+	func(s *discoveredSystem) []*discoveredInput {
+		if len(s.running(kafkaMain)) == 0 {
+			return nil
+		}
+		return []*discoveredInput{{
+			name:   "procstat",
+			reason: "a Kafka broker is running",
+			config: "  pattern = \"kafka\\\\.Kafka\"\n",
+		}}
+	},
+	func(s *discoveredSystem) []*discoveredInput {
+		var sources []string
+		for _, port := range []int{443, 636, 993, 995} {
+			if s.ports[port] {
+				sources = append(sources, fmt.Sprintf("%q",
+					"tcp://localhost:"+strconv.Itoa(port)))
+			}
+		}
+		if len(sources) == 0 {
+			return nil
+		}
+		return []*discoveredInput{{
+			name:   "x509_cert",
+			reason: "TLS ports are listened on",
+			config: fmt.Sprintf("  sources = [%s]\n", strings.Join(sources, ", ")),
+		}}
+	},
+	func(s *discoveredSystem) []*discoveredInput {
+		if s.online("svc:/system/zones:default") == "" {
+			return nil
+		}
+		return []*discoveredInput{{
+			name:   "zones",
+			reason: "the zones service is online",
+		}}
+	},
+	func(s *discoveredSystem) []*discoveredInput {
+		if s.online("svc:/ldoms/ldmd:default") == "" {
+			return nil
+		}
+		return []*discoveredInput{{
+			name:   "ldom",
+			reason: "the Logical Domains Manager is online",
+		}}
+	},
+	func(s *discoveredSystem) []*discoveredInput {
+		if s.online("svc:/system/fmd:default") == "" {
+			return nil
+		}
+		return []*discoveredInput{{
+			name:   "fmadm",
+			reason: "the fault manager is online",
+		}}
+	},
+	func(s *discoveredSystem) []*discoveredInput {
+		if !s.packages["system/management/ipmitool"] {
+			return nil
+		}
+		return []*discoveredInput{{
+			name:   "sensors",
+			reason: "ipmitool is installed",
+		}}
+	},
+}
+
+// inspectSystem finds the online services, the TCP ports listened on, the
+// installed packages and the processes running. What cannot be inspected is
+// logged and left empty.
+func inspectSystem(run ZoneRunner, procRoot string) *discoveredSystem {
+	s := &discoveredSystem{
+		services: make(map[string]bool),
+		ports:    make(map[int]bool),
+		packages: make(map[string]bool),
+	}
+
+	if out, err := run(discoverTimeout, "svcs", "-H", "-o", "state,fmri"); err != nil {
+		log.Printf("W! Could not list the services: %s", err)
+	} else {
+		for _, line := range strings.Split(string(out), "\n") {
+			cols := strings.Fields(line)
+			if len(cols) == 2 && cols[0] == "online" {
+				s.services[cols[1]] = true
+			}
+		}
+	}
+
+	// the listening sockets are listed as
+	//       *.22                 *.*                0      0 128000      0 LISTEN
+	if out, err := run(discoverTimeout, "netstat", "-an", "-P", "tcp"); err != nil {
+		log.Printf("W! Could not list the ports listened on: %s", err)
+	} else {
+		for _, line := range strings.Split(string(out), "\n") {
+			cols := strings.Fields(line)
+			if len(cols) < 2 || cols[len(cols)-1] != "LISTEN" {
+				continue
+			}
+			local := cols[0]
+			port, err := strconv.Atoi(local[strings.LastIndex(local, ".")+1:])
+			if err == nil {
+				s.ports[port] = true
+			}
+		}
+	}
+
+	if out, err := run(discoverTimeout, "pkg", "list", "-H"); err != nil {
+		log.Printf("W! Could not list the packages: %s", err)
+	} else {
+		for _, line := range strings.Split(string(out), "\n") {
+			if cols := strings.Fields(line); len(cols) != 0 {
+				s.packages[cols[0]] = true
+			}
+		}
+	}
+
+	procs, err := readAllPsinfo(procRoot)
+	if err != nil {
+		log.Printf("W! Could not list the processes: %s", err)
+	}
+	s.processes = procs
+	return s
+}
+
+// Discover inspects the system for the services that inputs could monitor,
+// and returns a drop-in config enabling them. The inputs the config already
+// has, but for procstat, are left out, as well as the ones that cannot work
+// in the zone the agent runs in.
+func Discover(c *Config, run ZoneRunner, procRoot string) []byte {
+	if run == nil {
+		run = zoneRunner
+	}
+	zone, err := detectZone(run)
+	if err != nil {
+		log.Printf("D! Could not detect the zone, assuming the global zone: %s", err)
+		zone = &zoneInfo{name: "global", global: true}
+	}
+
+	configured := make(map[string]bool)
+	for _, input := range c.Inputs {
+		if input.Config.Name != "procstat" {
+			configured[input.Config.Name] = true
+		}
+	}
+
+	s := inspectSystem(run, procRoot)
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# Inputs discovered by telegraf discover in the %s zone\n",
+		zone.name)
+	found := 0
+	for _, rule := range discoveryRules {
+		for _, input := range rule(s) {
+			if configured[input.name] {
+				log.Printf("I! Input [inputs.%s] already configured, %s",
+					input.name, input.reason)
+				continue
+			}
+			if !zone.global {
+				if reason := zone.unsupported(input.name, run); reason != "" {
+					log.Printf("I! Input [inputs.%s] not enabled, %s, but %s",
+						input.name, input.reason, reason)
+					continue
+				}
+			}
+			log.Printf("I! Input [inputs.%s] enabled, %s", input.name, input.reason)
+			fmt.Fprintf(&buf, "\n# %s\n[[inputs.%s]]\n%s", input.reason,
+				input.name, input.config)
+			found++
+		}
+	}
+	if found == 0 {
+		buf.WriteString("\n# no input discovered\n")
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// TagFilter is the globs of the values of a tag.
+type TagFilter struct {
+	Name   string
+	Filter []string
+}
+
+// Filter selects the metrics of a plugin by their measurement and tags, and
+// their fields, with globs. The metrics pass if their measurement matches a
+// glob of NamePass, if set, and none of NameDrop, and if one of their tags
+// matches a TagPass filter, if set, and none a TagDrop filter. Their fields
+// are those matching a glob of FieldPass, if set, and none of FieldDrop; the
+// metrics left without any field are dropped.
+type Filter struct {
+	NamePass  []string
+	NameDrop  []string
+	FieldPass []string
+	FieldDrop []string
+	TagPass   []TagFilter
+	TagDrop   []TagFilter
+}
+
+// Validate returns an error if a glob of the filter is malformed.
+func (f *Filter) Validate() error {
+	globs := [][]string{f.NamePass, f.NameDrop, f.FieldPass, f.FieldDrop}
+	for _, tf := range append(f.TagPass, f.TagDrop...) {
+		globs = append(globs, tf.Filter)
+	}
+	for _, patterns := range globs {
+		for _, pattern := range patterns {
+			if _, err := filepath.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid glob %q: %s", pattern, err)
+			}
+		}
+	}
+	return nil
+}
+
+// IsActive returns true if the filter has any glob.
+func (f *Filter) IsActive() bool {
+	return len(f.NamePass) != 0 || len(f.NameDrop) != 0 ||
+		len(f.FieldPass) != 0 || len(f.FieldDrop) != 0 ||
+		len(f.TagPass) != 0 || len(f.TagDrop) != 0
+}
+
+// Apply returns the metric with the fields the filter passes, nil if it
+// drops the metric.
+func (f *Filter) Apply(m Metric) Metric {
+	if !f.IsActive() {
+		return m
+	}
+	if len(f.NamePass) != 0 && !matchesAny(m.Name(), f.NamePass) {
+		return nil
+	}
+	if matchesAny(m.Name(), f.NameDrop) {
+		return nil
+	}
+	if len(f.TagPass) != 0 || len(f.TagDrop) != 0 {
+		tags := m.Tags()
+		if len(f.TagPass) != 0 && !matchesTags(tags, f.TagPass) {
+			return nil
+		}
+		if matchesTags(tags, f.TagDrop) {
+			return nil
+		}
+	}
+	if len(f.FieldPass) == 0 && len(f.FieldDrop) == 0 {
+		return m
+	}
+
+	fields := m.Fields()
+	filtered := false
+	for k := range fields {
+		if len(f.FieldPass) != 0 && !matchesAny(k, f.FieldPass) ||
+			matchesAny(k, f.FieldDrop) {
+			delete(fields, k)
+			filtered = true
+		}
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+	if !filtered {
+		return m
+	}
+	// the fields are rebuilt rather than removed, as a field is looked up by
+	// a substring of the serialized fields
+	out, err := rebuild(m, m.Name(), m.Tags(), fields, m.Time())
+	if err != nil {
+		return nil
+	}
+	return out
+}
+
+// matchesTags returns true if a tag of the tag filters has a value matching
+// one of its globs.
+func matchesTags(tags map[string]string, filters []TagFilter) bool {
+	for _, tf := range filters {
+		if v, ok := tags[tf.Name]; ok && matchesAny(v, tf.Filter) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesAny returns true if key matches any of the given glob patterns.
+func matchesAny(key string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(pattern, key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// checkGlobs returns an error naming the option if one of its globs is
+// malformed, as matchesAny would silently never match it.
+func checkGlobs(option string, patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid glob %q in %s: %s", pattern, option, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Minimum and maximum supported dates for timestamps.
+var (
+	minGraphiteDate = time.Date(1901, 12, 13, 0, 0, 0, 0, time.UTC)
+	maxGraphiteDate = time.Date(2038, 1, 19, 0, 0, 0, 0, time.UTC)
+)
+
+const defaultGraphiteSeparator = "."
+
+// GraphiteParser parses the graphite plaintext protocol, "path value
+// timestamp" lines, the measurement, field and tags being extracted from the
+// dot separated path by templates, ie, "host.measurement.field*".
+type GraphiteParser struct {
+	Separator   string
+	DefaultTags map[string]string
+
+	templates       []graphiteTemplate
+	defaultTemplate graphiteTemplate
+}
+
+// graphiteTemplate maps the parts of the paths matching its filter to the
+// measurement, field or tags of the metric.
+type graphiteTemplate struct {
+	filter []string
+	parts  []string
+	tags   map[string]string
+}
+
+// NewGraphiteParser builds the templates, "[filter] template [tags]" with
+// tags being "key=value" pairs separated by commas. The first template whose
+// filter matches a path is used, the one without filter by default.
+func NewGraphiteParser(
+	separator string,
+	templates []string,
+	defaultTags map[string]string,
+) (*GraphiteParser, error) {
+	if separator == "" {
+		separator = defaultGraphiteSeparator
+	}
+	p := &GraphiteParser{
+		Separator:       separator,
+		DefaultTags:     defaultTags,
+		defaultTemplate: graphiteTemplate{parts: []string{"measurement*"}},
+	}
+
+	for _, line := range templates {
+		parts := strings.Fields(line)
+		var t graphiteTemplate
+		switch len(parts) {
+		case 1:
+			t.parts = strings.Split(parts[0], ".")
+		case 2:
+			if strings.Contains(parts[1], "=") {
+				t.parts = strings.Split(parts[0], ".")
+				t.tags = parseGraphiteTags(parts[1])
+			} else {
+				t.filter = strings.Split(parts[0], ".")
+				t.parts = strings.Split(parts[1], ".")
+			}
+		case 3:
+			t.filter = strings.Split(parts[0], ".")
+			t.parts = strings.Split(parts[1], ".")
+			t.tags = parseGraphiteTags(parts[2])
+		default:
+			return nil, fmt.Errorf("invalid graphite template: %q", line)
+		}
+		if err := t.validate(); err != nil {
+			return nil, fmt.Errorf("invalid graphite template %q: %s", line, err)
+		}
+		if t.filter == nil {
+			p.defaultTemplate = t
+		} else {
+			p.templates = append(p.templates, t)
+		}
+	}
+	return p, nil
+}
+
+func parseGraphiteTags(s string) map[string]string {
+	tags := make(map[string]string)
+	for _, kv := range strings.Split(s, ",") {
+		if i := strings.Index(kv, "="); i > 0 {
+			tags[kv[:i]] = kv[i+1:]
+		}
+	}
+	return tags
+}
+
+func (t graphiteTemplate) validate() error {
+	var measurement, field bool
+	for i, part := range t.parts {
+		switch part {
+		case "measurement*", "field*":
+			if i != len(t.parts)-1 {
+				return fmt.Errorf("%s must be the last part", part)
+			}
+		}
+		measurement = measurement || strings.HasPrefix(part, "measurement")
+		field = field || strings.HasPrefix(part, "field")
+	}
+	if !measurement && !field {
+		return fmt.Errorf("no measurement nor field")
+	}
+	return nil
+}
+
+func (t graphiteTemplate) matches(path []string) bool {
+	if len(t.filter) > len(path) {
+		return false
+	}
+	for i, f := range t.filter {
+		if ok, _ := filepath.Match(f, path[i]); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// apply returns the measurement, field and tags of the path, the field
+// being empty when the template has none.
+func (t graphiteTemplate) apply(path []string, separator string) (string, string, map[string]string) {
+	var measurement, field []string
+	tagParts := make(map[string][]string)
+	for i, part := range t.parts {
+		if i >= len(path) {
+			break
+		}
+		switch part {
+		case "":
+		case "measurement":
+			measurement = append(measurement, path[i])
+		case "measurement*":
+			measurement = append(measurement, path[i:]...)
+		case "field":
+			field = append(field, path[i])
+		case "field*":
+			field = append(field, path[i:]...)
+		default:
+			tagParts[part] = append(tagParts[part], path[i])
+		}
+	}
+
+	tags := make(map[string]string, len(t.tags)+len(tagParts))
+	for k, v := range t.tags {
+		tags[k] = v
+	}
+	for k, v := range tagParts {
+		tags[k] = strings.Join(v, separator)
+	}
+	if len(measurement) == 0 {
+		measurement = path
+	}
+	return strings.Join(measurement, separator), strings.Join(field, separator), tags
+}
+
+func (p *GraphiteParser) Parse(buf []byte) ([]Metric, error) {
+	var metrics []Metric
+	for _, line := range bytes.Split(buf, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		m, err := p.ParseLine(string(line))
+		if err != nil {
+			return metrics, err
+		}
+		metrics = append(metrics, m)
+	}
+	return metrics, nil
+}
+
+func (p *GraphiteParser) ParseLine(line string) (Metric, error) {
+	cols := strings.Fields(line)
+	if len(cols) < 2 {
+		return nil, fmt.Errorf("received %q which doesn't have required fields", line)
+	}
+
+	path := strings.Split(cols[0], ".")
+	t := p.defaultTemplate
+	for _, candidate := range p.templates {
+		if candidate.matches(path) {
+			t = candidate
+			break
+		}
+	}
+	measurement, field, tags := t.apply(path, p.Separator)
+	if field == "" {
+		field = "value"
+	}
+
+	value, err := strconv.ParseFloat(cols[1], 64)
+	if err != nil {
+		return nil, fmt.Errorf(`field "%s" value: %s`, cols[0], err)
+	}
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return nil, fmt.Errorf(`field "%s" value: '%v' is unsupported`, cols[0], value)
+	}
+
+	timestamp := time.Now().UTC()
+	if len(cols) >= 3 {
+		unix, err := strconv.ParseFloat(cols[2], 64)
+		if err != nil {
+			return nil, fmt.Errorf(`field "%s" time: %s`, cols[0], err)
+		}
+		// -1 is a special value that gets converted to current UTC time
+		if unix != -1 {
+			timestamp = time.Unix(int64(unix), 0).UTC()
+			if timestamp.Before(minGraphiteDate) || timestamp.After(maxGraphiteDate) {
+				return nil, fmt.Errorf("timestamp out of range")
+			}
+		}
+	}
+
+	for k, v := range p.DefaultTags {
+		if _, ok := tags[k]; !ok {
+			tags[k] = v
+		}
+	}
+	return New(measurement, tags, map[string]interface{}{field: value}, timestamp)
+}
+
+func (p *GraphiteParser) SetDefaultTags(tags map[string]string) {
+	p.DefaultTags = tags
+}
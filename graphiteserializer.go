@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// defaultGraphiteTemplate is the template of the paths of the graphite
+// serializer without one.
+const defaultGraphiteTemplate = "host.tags.measurement.field"
+
+// graphiteSanitizer replaces the characters graphite does not accept in the
+// nodes of the paths.
+var graphiteSanitizer = strings.NewReplacer(
+	"/", "-", "@", "-", "*", "-", " ", "_", `\`, "", ")", "_", "(", "_",
+	".", "_")
+
+// GraphiteSerializer writes the numeric and boolean fields of the metrics in
+// the graphite plaintext protocol, "path value timestamp", a line per field.
+// The nodes of the path are those of the template: measurement, field,
+// tags for the values of the tags left, by tag key, and the names of tags,
+// such as host, for their value. The field node is left out of the path of
+// the fields named value.
+type GraphiteSerializer struct {
+	Prefix   string
+	Template string
+
+	parts []string
+	// tags of their own node in the template
+	placed map[string]bool
+}
+
+func NewGraphiteSerializer(prefix, template string) (Serializer, error) {
+	if template == "" {
+		template = defaultGraphiteTemplate
+	}
+	s := &GraphiteSerializer{
+		Prefix:   prefix,
+		Template: template,
+		parts:    strings.Split(template, "."),
+		placed:   make(map[string]bool),
+	}
+	for _, part := range s.parts {
+		switch part {
+		case "":
+			return nil, fmt.Errorf("invalid graphite template %q", template)
+		case "measurement", "field", "tags":
+		default:
+			s.placed[part] = true
+		}
+	}
+	return s, nil
+}
+
+func (s *GraphiteSerializer) Serialize(m Metric) ([]byte, error) {
+	fields := m.Fields()
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var out []byte
+	timestamp := m.UnixNano() / 1000000000
+	for _, k := range keys {
+		value, ok := graphiteValue(fields[k])
+		if !ok {
+			continue
+		}
+		out = append(out, s.path(m, k)...)
+		out = append(out, fmt.Sprintf(" %s %d\n", value, timestamp)...)
+	}
+	return out, nil
+}
+
+// path returns the path of the field of the metric.
+func (s *GraphiteSerializer) path(m Metric, field string) string {
+	tags := m.Tags()
+	var nodes []string
+	if s.Prefix != "" {
+		nodes = append(nodes, s.Prefix)
+	}
+
+	for _, part := range s.parts {
+		switch part {
+		case "measurement":
+			nodes = append(nodes, graphiteSanitizer.Replace(m.Name()))
+		case "field":
+			if field != "value" {
+				nodes = append(nodes, graphiteSanitizer.Replace(field))
+			}
+		case "tags":
+			keys := make([]string, 0, len(tags))
+			for k := range tags {
+				if !s.placed[k] {
+					keys = append(keys, k)
+				}
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				nodes = append(nodes, graphiteSanitizer.Replace(tags[k]))
+			}
+		default:
+			if v, ok := tags[part]; ok {
+				nodes = append(nodes, graphiteSanitizer.Replace(v))
+			}
+		}
+	}
+	return strings.Join(nodes, ".")
+}
+
+// graphiteValue formats the value of a numeric or boolean field.
+func graphiteValue(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case int64, uint64:
+		return fmt.Sprintf("%d", v), true
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return "", false
+		}
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case bool:
+		if v {
+			return "1", true
+		}
+		return "0", true
+	}
+	return "", false
+}
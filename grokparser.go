@@ -0,0 +1,286 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// grokDefaultPatterns are the patterns of logstash most logs are parsed
+// with, rewritten for the RE2 syntax of Go which has no lookarounds.
+const grokDefaultPatterns = `
+USERNAME [a-zA-Z0-9._-]+
+USER %{USERNAME}
+INT (?:[+-]?(?:[0-9]+))
+BASE10NUM (?:[+-]?(?:[0-9]+(?:\.[0-9]+)?|\.[0-9]+))
+NUMBER (?:%{BASE10NUM})
+POSINT \b(?:[1-9][0-9]*)\b
+NONNEGINT \b(?:[0-9]+)\b
+WORD \b\w+\b
+NOTSPACE \S+
+SPACE \s*
+DATA .*?
+GREEDYDATA .*
+QUOTEDSTRING "(?:[^"\\]|\\.)*"
+QS %{QUOTEDSTRING}
+UUID [A-Fa-f0-9]{8}-(?:[A-Fa-f0-9]{4}-){3}[A-Fa-f0-9]{12}
+IPV4 (?:(?:25[0-5]|2[0-4][0-9]|[01]?[0-9]{1,2})\.){3}(?:25[0-5]|2[0-4][0-9]|[01]?[0-9]{1,2})
+IPV6 (?:[0-9A-Fa-f]{0,4}:){2,7}[0-9A-Fa-f]{0,4}
+IP (?:%{IPV6}|%{IPV4})
+HOSTNAME \b[0-9A-Za-z][0-9A-Za-z-]{0,62}(?:\.[0-9A-Za-z][0-9A-Za-z-]{0,62})*\.?
+IPORHOST (?:%{IP}|%{HOSTNAME})
+HOSTPORT %{IPORHOST}:%{POSINT}
+UNIXPATH (?:/[\w_%!$@:.,~+-]*)+
+PATH %{UNIXPATH}
+URIPATHPARAM \S+
+MONTH \b(?:Jan(?:uary)?|Feb(?:ruary)?|Mar(?:ch)?|Apr(?:il)?|May|June?|July?|Aug(?:ust)?|Sep(?:tember)?|Oct(?:ober)?|Nov(?:ember)?|Dec(?:ember)?)\b
+MONTHNUM (?:0?[1-9]|1[0-2])
+MONTHDAY (?:0[1-9]|[12][0-9]|3[01]|[1-9])
+YEAR (?:\d\d){1,2}
+HOUR (?:2[0123]|[01]?[0-9])
+MINUTE (?:[0-5][0-9])
+SECOND (?:(?:[0-5]?[0-9]|60)(?:[:.,][0-9]+)?)
+TIME %{HOUR}:%{MINUTE}:%{SECOND}
+ISO8601_TIMEZONE (?:Z|[+-]%{HOUR}(?::?%{MINUTE}))
+TIMESTAMP_ISO8601 %{YEAR}-%{MONTHNUM}-%{MONTHDAY}[T ]%{HOUR}:?%{MINUTE}(?::?%{SECOND})?%{ISO8601_TIMEZONE}?
+HTTPDATE %{MONTHDAY}/%{MONTH}/%{YEAR}:%{TIME} %{INT}
+SYSLOGTIMESTAMP %{MONTH} +%{MONTHDAY} %{TIME}
+LOGLEVEL (?:[Aa]lert|ALERT|[Tt]race|TRACE|[Dd]ebug|DEBUG|[Nn]otice|NOTICE|[Ii]nfo|INFO|[Ww]arn(?:ing)?|WARN(?:ING)?|[Ee]rr(?:or)?|ERR(?:OR)?|[Cc]rit(?:ical)?|CRIT(?:ICAL)?|[Ff]atal|FATAL|[Ss]evere|SEVERE|[Ee]merg(?:ency)?|EMERG(?:ENCY)?)
+COMMON_LOG_FORMAT %{IPORHOST:client_ip} %{NOTSPACE:ident} %{NOTSPACE:auth} \[%{HTTPDATE:ts:ts-httpd}\] "(?:%{WORD:verb:tag} %{NOTSPACE:request}(?: HTTP/%{NUMBER:http_version:float})?|%{DATA})" %{NUMBER:resp_code:tag} (?:%{NUMBER:resp_bytes:int}|-)
+COMBINED_LOG_FORMAT %{COMMON_LOG_FORMAT} %{QS:referrer} %{QS:agent}
+`
+
+// grokReference matches the %{SYNTAX:semantic:modifier} references to
+// patterns, the modifier possibly being a quoted time layout with colons.
+var grokReference = regexp.MustCompile(`%\{(\w+)(?::([\w.-]+))?(?::([^}]+))?\}`)
+
+// Layouts of the ts-<name> modifiers.
+var grokTimeLayouts = map[string]string{
+	"ts-ansic":    time.ANSIC,
+	"ts-httpd":    "02/Jan/2006:15:04:05 -0700",
+	"ts-rfc3339":  time.RFC3339,
+	"ts-rfc1123":  time.RFC1123,
+	"ts-syslog":   "Jan _2 15:04:05",
+	"ts-unixdate": time.UnixDate,
+}
+
+// GrokParser parses lines with grok patterns, the first pattern matching a
+// line giving its metric. The semantic of a capture is the name of its field
+// and its modifier one of int, float, string (the default), tag, drop,
+// measurement, or a ts-* modifier making it the timestamp of the metric:
+// ts-epoch, ts-epochnano, ts-httpd, ts-rfc3339, ts-syslog, ts-ansic,
+// ts-unixdate or ts-"<Go layout>".
+type GrokParser struct {
+	Patterns       []string
+	CustomPatterns string
+	Measurement    string
+	DefaultTags    map[string]string
+
+	compiled []grokPattern
+}
+
+type grokPattern struct {
+	re *regexp.Regexp
+	// semantic and modifier of each named group, by group index
+	semantics []string
+	modifiers []string
+}
+
+func NewGrokParser(
+	measurement string,
+	patterns []string,
+	customPatterns string,
+	defaultTags map[string]string,
+) (*GrokParser, error) {
+	p := &GrokParser{
+		Patterns:       patterns,
+		CustomPatterns: customPatterns,
+		Measurement:    measurement,
+		DefaultTags:    defaultTags,
+	}
+	if len(patterns) == 0 {
+		return nil, fmt.Errorf("grok: no grok_patterns configured")
+	}
+
+	library := make(map[string]string)
+	for _, defs := range []string{grokDefaultPatterns, customPatterns} {
+		for _, line := range strings.Split(defs, "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			i := strings.IndexAny(line, " \t")
+			if i == -1 {
+				return nil, fmt.Errorf("grok: invalid pattern definition %q", line)
+			}
+			library[line[:i]] = strings.TrimSpace(line[i:])
+		}
+	}
+
+	for _, pattern := range patterns {
+		var gp grokPattern
+		expanded, err := gp.expand(pattern, library, 0)
+		if err != nil {
+			return nil, err
+		}
+		if gp.re, err = regexp.Compile(expanded); err != nil {
+			return nil, fmt.Errorf("grok: pattern %q: %s", pattern, err)
+		}
+		p.compiled = append(p.compiled, gp)
+	}
+	return p, nil
+}
+
+// expand replaces the references to patterns by their regular expression,
+// named groups for the captures, ie, %{NUMBER:duration:float}.
+func (gp *grokPattern) expand(pattern string, library map[string]string, depth int) (string, error) {
+	if depth > 32 {
+		return "", fmt.Errorf("grok: pattern %q: too many levels of references", pattern)
+	}
+	var err error
+	expanded := grokReference.ReplaceAllStringFunc(pattern, func(ref string) string {
+		if err != nil {
+			return ""
+		}
+		m := grokReference.FindStringSubmatch(ref)
+		def, ok := library[m[1]]
+		if !ok {
+			err = fmt.Errorf("grok: undefined pattern %s", m[1])
+			return ""
+		}
+		var sub string
+		if sub, err = gp.expand(def, library, depth+1); err != nil {
+			return ""
+		}
+		if m[2] == "" {
+			return "(?:" + sub + ")"
+		}
+		// group names only hold word characters, the groups are numbered
+		// and their semantics kept aside
+		gp.semantics = append(gp.semantics, m[2])
+		gp.modifiers = append(gp.modifiers, m[3])
+		return fmt.Sprintf("(?P<g%d>%s)", len(gp.semantics)-1, sub)
+	})
+	return expanded, err
+}
+
+func (p *GrokParser) Parse(buf []byte) ([]Metric, error) {
+	var metrics []Metric
+	for _, line := range strings.Split(string(buf), "\n") {
+		m, err := p.ParseLine(line)
+		if err != nil {
+			return metrics, err
+		}
+		if m != nil {
+			metrics = append(metrics, m)
+		}
+	}
+	return metrics, nil
+}
+
+// ParseLine returns the metric of the first pattern matching the line, nil
+// when none does.
+func (p *GrokParser) ParseLine(line string) (Metric, error) {
+	for _, gp := range p.compiled {
+		match := gp.re.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		return p.metric(gp, match)
+	}
+	return nil, nil
+}
+
+func (p *GrokParser) metric(gp grokPattern, match []string) (Metric, error) {
+	measurement := p.Measurement
+	tags := make(map[string]string, len(p.DefaultTags))
+	for k, v := range p.DefaultTags {
+		tags[k] = v
+	}
+	fields := make(map[string]interface{})
+	timestamp := time.Now()
+
+	for i, name := range gp.re.SubexpNames() {
+		if !strings.HasPrefix(name, "g") || match[i] == "" {
+			continue
+		}
+		n, _ := strconv.Atoi(name[1:])
+		semantic, modifier, value := gp.semantics[n], gp.modifiers[n], match[i]
+
+		switch {
+		case modifier == "" || modifier == "string":
+			fields[semantic] = strings.Trim(value, `"`)
+		case modifier == "int":
+			v, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("grok: %s: %s", semantic, err)
+			}
+			fields[semantic] = v
+		case modifier == "float":
+			v, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return nil, fmt.Errorf("grok: %s: %s", semantic, err)
+			}
+			fields[semantic] = v
+		case modifier == "tag":
+			tags[semantic] = value
+		case modifier == "measurement":
+			measurement = value
+		case modifier == "drop":
+		case strings.HasPrefix(modifier, "ts"):
+			t, err := grokTimestamp(modifier, value)
+			if err != nil {
+				return nil, fmt.Errorf("grok: %s: %s", semantic, err)
+			}
+			timestamp = t
+		default:
+			return nil, fmt.Errorf("grok: %s: unknown modifier %q", semantic, modifier)
+		}
+	}
+
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	return New(measurement, tags, fields, timestamp)
+}
+
+func grokTimestamp(modifier, value string) (time.Time, error) {
+	switch modifier {
+	case "ts-epoch":
+		secs, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return time.Unix(0, int64(secs*float64(time.Second))), nil
+	case "ts-epochnano":
+		ns, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return time.Unix(0, ns), nil
+	}
+
+	layout, ok := grokTimeLayouts[modifier]
+	if !ok {
+		layout = strings.Trim(strings.TrimPrefix(modifier, "ts-"), `"`)
+	}
+	t, err := time.ParseInLocation(layout, value, time.Local)
+	if err != nil {
+		return time.Time{}, err
+	}
+	// syslog timestamps have no year
+	if t.Year() == 0 {
+		now := time.Now()
+		t = t.AddDate(now.Year(), 0, 0)
+		if t.After(now.Add(24 * time.Hour)) {
+			t = t.AddDate(-1, 0, 0)
+		}
+	}
+	return t, nil
+}
+
+func (p *GrokParser) SetDefaultTags(tags map[string]string) {
+	p.DefaultTags = tags
+}
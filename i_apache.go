@@ -26,6 +26,7 @@ type Apache struct {
 	// Use SSL but skip chain & host verification
 	InsecureSkipVerify bool
 
+	addrs  []*url.URL
 	client *http.Client
 }
 
@@ -58,7 +59,8 @@ func (n *Apache) Description() string {
 	return "Read Apache status information (mod_status)"
 }
 
-func (n *Apache) Gather(acc Accumulator) error {
+// Init sets the default values, parses the URLs and creates the client.
+func (n *Apache) Init() error {
 	if len(n.Urls) == 0 {
 		n.Urls = []string{"http://localhost/server-status?auto"}
 	}
@@ -66,23 +68,27 @@ func (n *Apache) Gather(acc Accumulator) error {
 		n.ResponseTimeout.Duration = time.Second * 5
 	}
 
-	if n.client == nil {
-		client, err := n.createHttpClient()
-		if err != nil {
-			return err
-		}
-		n.client = client
-	}
-
-	var wg sync.WaitGroup
-	wg.Add(len(n.Urls))
+	n.addrs = n.addrs[:0]
 	for _, u := range n.Urls {
 		addr, err := url.Parse(u)
 		if err != nil {
-			acc.AddError(fmt.Errorf("Unable to parse address '%s': %s", u, err))
-			continue
+			return fmt.Errorf("Unable to parse address '%s': %s", u, err)
 		}
+		n.addrs = append(n.addrs, addr)
+	}
 
+	client, err := n.createHttpClient()
+	if err != nil {
+		return err
+	}
+	n.client = client
+	return nil
+}
+
+func (n *Apache) Gather(acc Accumulator) error {
+	var wg sync.WaitGroup
+	wg.Add(len(n.addrs))
+	for _, addr := range n.addrs {
 		go func(addr *url.URL) {
 			defer wg.Done()
 			acc.AddError(n.gatherUrl(addr, acc))
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MdbRunner runs the given dcmds with mdb(1) on the live kernel and returns
+// its output. It can be replaced with a mocked function for unit test
+// purposes.
+type MdbRunner func(timeout time.Duration, dcmds string) ([]byte, error)
+
+// ntpEpochOffset is the number of seconds from the NTP epoch, 1900, to the
+// Unix epoch.
+const ntpEpochOffset = 2208988800
+
+// todFaults names the values of the kernel's enum tod_fault_type.
+var todFaults = []string{"reversed", "stalled", "jumped", "ratechanged", "rdonly", "none"}
+
+// Clock reports the kernel clock statistics: the clock tick rate, the skew
+// of the wall clock from the high resolution time since boot, the offset of
+// the wall clock from an NTP server and the fault of the time of day chip.
+type Clock struct {
+	NTPServer  string   `toml:"ntp_server"`
+	NTPTimeout Duration `toml:"ntp_timeout"`
+	TODStatus  bool     `toml:"tod_status"`
+	Timeout    Duration
+
+	runKstat KstatRunner
+	runMdb   MdbRunner
+}
+
+func NewClock() Input {
+	return &Clock{
+		NTPTimeout: Duration{Duration: 5 * time.Second},
+		Timeout:    Duration{Duration: 5 * time.Second},
+		runKstat:   kstatRunner,
+		runMdb:     mdbRunner,
+	}
+}
+
+func (_ *Clock) Description() string {
+	return "Read the kernel clock tick rate and the drift of the clocks"
+}
+
+var clockSampleConfig = `
+  ## The tick rate is read from the unix:0:system_misc kstat, along with the
+  ## hrtime_skew, the wall clock time since boot minus the high resolution
+  ## time since boot. As the boot time is in whole seconds the skew is off by
+  ## up to a second, its change over time is the drift of the wall clock.
+
+  ## NTP server to query for the offset of the wall clock, the offset is not
+  ## reported if empty
+  # ntp_server = "pool.ntp.org"
+  ## Timeout for the NTP server to answer
+  # ntp_timeout = "5s"
+  ## Report the fault detected on the time of day chip, read from the
+  ## tod_faulted kernel variable with mdb -k, which requires root
+  # tod_status = false
+  ## Timeout for the kstat and mdb commands to complete
+  # timeout = "5s"
+`
+
+func (_ *Clock) SampleConfig() string {
+	return clockSampleConfig
+}
+
+func (c *Clock) Gather(acc Accumulator) error {
+	fields := make(map[string]interface{})
+	tags := make(map[string]string)
+
+	before := time.Now()
+	entries, err := readKstat(c.runKstat, c.Timeout.Duration, "unix:0:system_misc")
+	if err != nil {
+		return err
+	}
+	// the kstat was read at some point while the command ran
+	now := before.Add(time.Since(before) / 2)
+	for _, entry := range entries {
+		clockKstatFields(fields, entry.Stats, now)
+	}
+
+	if c.NTPServer != "" {
+		offset, delay, stratum, err := ntpQuery(c.NTPServer, c.NTPTimeout.Duration)
+		if err != nil {
+			acc.AddError(NewGatherError(err, "ntp_server", c.NTPServer))
+		} else {
+			fields["ntp_offset"] = offset.Seconds()
+			fields["ntp_delay"] = delay.Seconds()
+			fields["ntp_stratum"] = stratum
+			tags["ntp_server"] = c.NTPServer
+		}
+	}
+
+	if c.TODStatus {
+		fault, err := c.todFault()
+		if err != nil {
+			acc.AddError(err)
+		} else {
+			fields["tod_fault"] = fault
+			fields["tod_faulted"] = fault != "none"
+		}
+	}
+
+	if len(fields) != 0 {
+		acc.AddFields("clock", fields, tags)
+	}
+	return nil
+}
+
+// clockKstatFields adds the tick rate and the hrtime skew derived from the
+// unix:0:system_misc statistics read at the wall clock time now.
+func clockKstatFields(fields map[string]interface{}, stats map[string]string, now time.Time) {
+	// snaptime is the high resolution time since boot the kstat was read at
+	snaptime, err := strconv.ParseFloat(stats["snaptime"], 64)
+	if err != nil || snaptime <= 0 {
+		return
+	}
+	if lbolt, err := strconv.ParseFloat(stats["lbolt"], 64); err == nil {
+		fields["tick_rate"] = lbolt / snaptime
+	}
+	if v, err := strconv.ParseInt(stats["clk_intr"], 10, 64); err == nil {
+		fields["clock_interrupts"] = v
+	}
+	if boot, err := strconv.ParseInt(stats["boot_time"], 10, 64); err == nil {
+		uptime := float64(now.UnixNano()-boot*int64(time.Second)) / float64(time.Second)
+		fields["hrtime_skew"] = uptime - snaptime
+	}
+}
+
+// todFault returns the name of the fault the kernel detected on the time of
+// day chip, "none" if there is none.
+func (c *Clock) todFault() (string, error) {
+	run := c.runMdb
+	if run == nil {
+		run = mdbRunner
+	}
+	out, err := run(c.Timeout.Duration, "tod_faulted/D")
+	if err != nil {
+		return "", fmt.Errorf("error reading tod_faulted: %s", err)
+	}
+	// tod_faulted:
+	// tod_faulted:    5
+	cols := strings.Fields(string(out))
+	if len(cols) == 0 {
+		return "", fmt.Errorf("error reading tod_faulted: no output")
+	}
+	v, err := strconv.Atoi(cols[len(cols)-1])
+	if err != nil || v < 0 || v >= len(todFaults) {
+		return "", fmt.Errorf("error reading tod_faulted: unexpected output %q",
+			strings.TrimSpace(string(out)))
+	}
+	return todFaults[v], nil
+}
+
+// ntpQuery sends an SNTP client request to the server and returns the offset
+// of the local clock from the server's, the round trip delay and the
+// stratum of the server.
+func ntpQuery(server string, timeout time.Duration) (time.Duration, time.Duration, int, error) {
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "123")
+	}
+	conn, err := net.DialTimeout("udp", server, timeout)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	// version 4, client mode, with the transmit time echoed back by the
+	// server as the originate time
+	req := make([]byte, 48)
+	req[0] = 4<<3 | 3
+	sent := time.Now()
+	binary.BigEndian.PutUint64(req[40:], ntpTime(sent))
+	if _, err := conn.Write(req); err != nil {
+		return 0, 0, 0, err
+	}
+
+	resp := make([]byte, 48)
+	for {
+		n, err := conn.Read(resp)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		received := time.Now()
+		if n < 48 || resp[0]&0x7 != 4 ||
+			binary.BigEndian.Uint64(resp[24:]) != binary.BigEndian.Uint64(req[40:]) {
+			continue
+		}
+		stratum := int(resp[1])
+		if stratum == 0 {
+			return 0, 0, 0, fmt.Errorf("kiss of death %q from %s", resp[12:16], server)
+		}
+		t2 := fromNTPTime(binary.BigEndian.Uint64(resp[32:]))
+		t3 := fromNTPTime(binary.BigEndian.Uint64(resp[40:]))
+		offset := (t2.Sub(sent) + t3.Sub(received)) / 2
+		delay := received.Sub(sent) - t3.Sub(t2)
+		return offset, delay, stratum, nil
+	}
+}
+
+// ntpTime converts t to an NTP timestamp, the seconds since 1900 in the
+// upper 32 bits and their fraction in the lower ones.
+func ntpTime(t time.Time) uint64 {
+	secs := uint64(t.Unix() + ntpEpochOffset)
+	frac := uint64(t.Nanosecond()) << 32 / uint64(time.Second)
+	return secs<<32 | frac
+}
+
+func fromNTPTime(ts uint64) time.Time {
+	secs := int64(ts>>32) - ntpEpochOffset
+	nsecs := int64((ts & 0xffffffff) * uint64(time.Second) >> 32)
+	return time.Unix(secs, nsecs)
+}
+
+func mdbRunner(timeout time.Duration, dcmds string) ([]byte, error) {
+	bin, err := exec.LookPath("mdb")
+	if err != nil {
+		return nil, err
+	}
+	c := exec.Command(bin, "-k")
+	c.Stdin = strings.NewReader(dcmds + "\n")
+	return CombinedOutputTimeout(c, timeout)
+}
@@ -1,16 +1,62 @@
 package main
 
 import (
-	"os/exec"
-	"strings"
+	"bufio"
+	"os"
+	"path/filepath"
+	"regexp"
 	"strconv"
+	"strings"
 	"time"
-	"fmt"
-	"regexp"
 )
 
+// diskIOStats maps the fields reported to the statistics of the kstat I/O
+// counters they are read from.
+var diskIOStats = map[string]string{
+	"reads":        "reads",
+	"writes":       "writes",
+	"read_bytes":   "nread",
+	"write_bytes":  "nwritten",
+	"read_time":    "rtime",
+	"write_time":   "wtime",
+	"run_lentime":  "rlentime",
+	"wait_lentime": "wlentime",
+	"run_queue":    "rcnt",
+	"wait_queue":   "wcnt",
+}
+
+// diskName matches the cXtYdZ name of a disk, left of its slice or partition.
+var diskName = regexp.MustCompile(`^c\d+(t[0-9A-Fa-f]+)?d\d+`)
+
+// DiskIOStats reports the I/O counters of the disks, from the kstats of the
+// disk class, which hold the sd, ssd, cmdk, blkdev and ZFS pool counters,
+// and derives the iostat -x statistics from the previous gather.
 type DiskIOStats struct {
-	Devices []string
+	Devices     []string
+	DeviceNames bool `toml:"device_names"`
+	Timeout     Duration
+
+	runKstat KstatRunner
+
+	// root of /dev/dsk and /etc/path_to_inst, for unit test purposes
+	root string
+
+	// counters of each device at the previous gather
+	last map[string]diskIOCounters
+}
+
+// diskIOCounters are the counters the iostat statistics are computed from,
+// times in nanoseconds.
+type diskIOCounters struct {
+	snaptime, ops, rtime, wtime, rlentime, wlentime float64
+}
+
+func NewDiskIOStats() Input {
+	return &DiskIOStats{
+		Timeout:  Duration{Duration: 5 * time.Second},
+		runKstat: kstatRunner,
+		root:     "/",
+	}
 }
 
 func (_ *DiskIOStats) Description() string {
@@ -18,98 +64,151 @@ func (_ *DiskIOStats) Description() string {
 }
 
 var diskIoSampleConfig = `
-  ## By default, telegraf will gather stats for all devices including
-  ## disk partitions.
-  ## Setting devices will restrict the stats to the specified devices.
-  # devices = ["sda", "sdb"]
-  ## Uncomment the following line if you need disk serial numbers.
-  # skip_serial_number = false
-  #
-  ## On systems which support it, device metadata can be added in the form of
-  ## tags.
-  ## Currently only Linux is supported via udev properties. You can view
-  ## available properties for a device by running:
-  ## 'udevadm info -q property -n /dev/sda'
-  # device_tags = ["ID_FS_TYPE", "ID_FS_USAGE"]
-  #
-  ## Using the same metadata source as device_tags, you can also customize the
-  ## name of the device via templates.
-  ## The 'name_templates' parameter is a list of templates to try and apply to
-  ## the device. The template may contain variables in the form of '$PROPERTY' or
-  ## '${PROPERTY}'. The first template which does not contain any variables not
-  ## present for the device is used as the device name tag.
-  ## The typical use case is for LVM volumes, to get the VG/LV name instead of
-  ## the near-meaningless DM-0 name.
-  # name_templates = ["$ID_FS_LABEL","$DM_VG_NAME/$DM_LV_NAME"]
+  ## By default, telegraf gathers stats for all disks and ZFS pools.
+  ## Setting devices restricts the stats to the devices matching these
+  ## globs, on the kstat name, ie, "sd0", or the cXtYdZ name.
+  # devices = ["sd*", "c0t0d0", "rpool"]
+  ## Tag the devices with their cXtYdZ name, as shown by iostat -n
+  # device_names = false
+  ## Timeout for the kstat command to complete
+  # timeout = "5s"
 `
 
 func (_ *DiskIOStats) SampleConfig() string {
 	return diskIoSampleConfig
 }
 
+// Init checks the globs of the devices.
+func (s *DiskIOStats) Init() error {
+	return checkGlobs("devices", s.Devices)
+}
+
 func (s *DiskIOStats) Gather(acc Accumulator) error {
+	entries, err := readKstat(s.runKstat, s.Timeout.Duration, "-c", "disk")
+	if err != nil {
+		return err
+	}
 
-	var devices []string
+	var names map[string]string
+	if s.DeviceNames {
+		names = s.deviceNames()
+	}
 
-	if len(s.Devices) > 0 {
-		devices = s.Devices
-	} else {
-		output, err := exec.Command("iostat", "-d").CombinedOutput()
-		if err != nil {
-			return fmt.Errorf("error getting DiskIO info: %s", err.Error())
+	now := time.Now()
+	last := make(map[string]diskIOCounters)
+	for _, entry := range entries {
+		// only the I/O kstats have the queue length counters
+		if _, ok := entry.Stats["rlentime"]; !ok {
+			continue
+		}
+		tags := map[string]string{
+			"name": entry.Name,
+		}
+		if name, ok := names[entry.Name]; ok {
+			tags["device_name"] = name
+		}
+		if len(s.Devices) != 0 && !matchesAny(entry.Name, s.Devices) &&
+			!matchesAny(tags["device_name"], s.Devices) {
+			continue
 		}
-		devices = strings.Fields(strings.Split(string(output), "\n")[0])
+
+		fields := make(map[string]interface{}, len(diskIOStats)+7)
+		for field, stat := range diskIOStats {
+			setKstatField(fields, field, entry.Stats[stat])
+		}
+		if run, ok := fields["run_queue"].(int64); ok {
+			wait, _ := fields["wait_queue"].(int64)
+			fields["iops_in_progress"] = run + wait
+		}
+		counters := diskIOCountersOf(entry.Stats)
+		last[entry.Name] = counters
+		if prev, ok := s.last[entry.Name]; ok {
+			diskIOStatistics(fields, prev, counters)
+		}
+		acc.AddGauge("diskio", fields, tags, now)
+	}
+	s.last = last
+	return nil
+}
+
+func diskIOCountersOf(stats map[string]string) diskIOCounters {
+	value := func(stat string) float64 {
+		v, _ := strconv.ParseFloat(stats[stat], 64)
+		return v
+	}
+	return diskIOCounters{
+		snaptime: value("snaptime"),
+		ops:      value("reads") + value("writes"),
+		rtime:    value("rtime"),
+		wtime:    value("wtime"),
+		rlentime: value("rlentime"),
+		wlentime: value("wlentime"),
 	}
+}
 
-	for _, device := range devices {
-		output, err := exec.Command("kstat", "-p", fmt.Sprintf("*:*:%s:*", device)).CombinedOutput()
+// diskIOStatistics adds the statistics of iostat -x over the interval: the
+// average queue lengths, the service time and the busy percentages.
+func diskIOStatistics(fields map[string]interface{}, prev, cur diskIOCounters) {
+	elapsed := cur.snaptime - prev.snaptime
+	if elapsed <= 0 || cur.ops < prev.ops {
+		return
+	}
+	fields["wait_queue_avg"] = (cur.wlentime - prev.wlentime) / elapsed
+	fields["run_queue_avg"] = (cur.rlentime - prev.rlentime) / elapsed
+	fields["wait_percent"] = 100 * (cur.wtime - prev.wtime) / elapsed
+	fields["busy_percent"] = 100 * (cur.rtime - prev.rtime) / elapsed
+	fields["iops"] = (cur.ops - prev.ops) / elapsed * float64(time.Second)
+	if ops := cur.ops - prev.ops; ops > 0 {
+		lentime := cur.wlentime - prev.wlentime + cur.rlentime - prev.rlentime
+		fields["svc_time_ms"] = lentime / ops / float64(time.Millisecond)
+	}
+}
+
+// deviceNames maps the kstat names of the disks, ie, "sd0", to their
+// cXtYdZ names, following the /dev/dsk links to the physical device paths
+// and those to their driver instance in /etc/path_to_inst.
+func (s *DiskIOStats) deviceNames() map[string]string {
+	instances := make(map[string]string)
+	f, err := os.Open(filepath.Join(s.root, "etc/path_to_inst"))
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// "/pci@0,0/pci15ad,1976@10/sd@0,0" 0 "sd"
+		cols := strings.Fields(scanner.Text())
+		if len(cols) != 3 || strings.HasPrefix(cols[0], "#") {
+			continue
+		}
+		instances[strings.Trim(cols[0], `"`)] = strings.Trim(cols[2], `"`) + cols[1]
+	}
+
+	names := make(map[string]string)
+	links, _ := filepath.Glob(filepath.Join(s.root, "dev/dsk/c*"))
+	for _, link := range links {
+		target, err := os.Readlink(link)
 		if err != nil {
-			return fmt.Errorf("error getting DiskIO (kstat) info: %s", err.Error())
+			continue
 		}
-		s := string(output)
-		if s != "" {
-			fields := map[string]interface{}{}
-			tags := map[string]string{
-				"name": device,
-			}
-			stats := strings.Split(s, "\n")
-			stats = stats[0: len(stats)-1]
-			for _, row := range stats {
-				data := strings.Fields(row)
-				reg := regexp.MustCompile(".*:.*:.*:")
-				field := reg.ReplaceAllString(data[0], "${1}")
-
-				switch field {
-				case "reads":
-					fields["reads"], _ = strconv.ParseInt(data[1], 10, 0)
-					break
-				case "writes":
-					fields["writes"], _ = strconv.ParseInt(data[1], 10, 0)
-					break
-				case "rtime":
-					f, _ := strconv.ParseFloat(data[1], 64)
-					fields["read_time"] = int(f)
-					break
-				case "wtime":
-					f, _ := strconv.ParseFloat(data[1], 64)
-					fields["write_time"] = int(f)
-					break
-				case "nread":
-					fields["read_bytes"], _ = strconv.ParseInt(data[1], 10, 0)
-					break
-				case "nwritten":
-					fields["write_bytes"], _ = strconv.ParseInt(data[1], 10, 0)
-					break
-				case "rcnt":
-					fields["iops_in_progress"], _ = strconv.ParseInt(data[1], 10, 0)
-					break
-				case "wcnt":
-					fields["iops_in_progress"], _ = strconv.ParseInt(data[1], 10, 0)
-					break
-				}
-			}
-			acc.AddGauge("diskio", fields, tags, time.Now())
+		// ../../devices/pci@0,0/pci15ad,1976@10/sd@0,0:a
+		i := strings.Index(target, "/devices/")
+		if i == -1 {
+			continue
+		}
+		path := target[i+len("/devices"):]
+		if colon := strings.LastIndex(path, ":"); colon != -1 {
+			path = path[:colon]
+		}
+		instance, ok := instances[path]
+		if !ok {
+			continue
+		}
+		// c0t0d0s0 or c0t0d0p0, the slice or partition is not part of
+		// the disk name
+		if name := diskName.FindString(filepath.Base(link)); name != "" {
+			names[instance] = name
 		}
 	}
-	return nil
-}
\ No newline at end of file
+	return names
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DTraceRunner runs dtrace(1M) with the given arguments and returns its
+// output. It can be replaced with a mocked function for unit test purposes.
+type DTraceRunner func(
+	timeout time.Duration,
+	pfexec bool,
+	args ...string,
+) ([]byte, error)
+
+var (
+	dtraceDistribution = regexp.MustCompile(`^\s*value\s+-+ Distribution -+\s+count\s*$`)
+	dtraceBucket       = regexp.MustCompile(`^\s*(-?\d+|[<>]=? *-?\d+)\s+\|[@ ]*\s+(\d+)\s*$`)
+)
+
+// DTrace runs a D script and turns its output into metrics. In the
+// "aggregation" mode the script runs for duration on each gather, and the
+// aggregations dtrace prints when it exits become metrics. In the "influx"
+// mode the script prints line protocol itself, with printf or printa; it
+// either runs for duration on each gather or, with long_running, keeps
+// running and its lines are collected between gathers.
+type DTrace struct {
+	Script      string
+	ScriptFile  string `toml:"script_file"`
+	Mode        string
+	Duration    Duration
+	LongRunning bool `toml:"long_running"`
+	Measurement string
+	Keys        []string
+	UsePfexec   bool `toml:"use_pfexec"`
+
+	runDTrace DTraceRunner
+
+	// the long running consumer, and the metrics it printed and the errors
+	// it caused since the last gather
+	mu      sync.Mutex
+	cmd     *exec.Cmd
+	pending []Metric
+	errs    []error
+}
+
+func NewDTrace() Input {
+	return &DTrace{
+		Mode:        "aggregation",
+		Duration:    Duration{Duration: time.Second},
+		Measurement: "dtrace",
+		runDTrace:   dtraceRunner,
+	}
+}
+
+func (_ *DTrace) Description() string {
+	return "Run a DTrace script and report its aggregations or output"
+}
+
+var dtraceSampleConfig = `
+  ## D script to run, inline or from a file
+  script = '''
+    syscall:::entry { @calls[execname, probefunc] = count(); }
+    syscall::read:return { @bytes[execname] = quantize(arg0); }
+  '''
+  # script_file = "/etc/telegraf/syscalls.d"
+
+  ## "aggregation" to report the aggregations printed when the script exits,
+  ## "influx" if the script prints line protocol with printf or printa.
+  # mode = "aggregation"
+  ## Time the script runs for on each gather, it must be shorter than the
+  ## interval. A tick probe exiting dtrace is added to the script.
+  # duration = "1s"
+  ## Keep the script running and collect the lines it prints between
+  ## gathers, only with the influx mode.
+  # long_running = false
+
+  ## Measurement name of the aggregations and the tags of their keys, keys
+  ## without a name are tagged key0, key1, ...
+  # measurement = "dtrace"
+  # keys = ["execname", "probefunc"]
+
+  ## dtrace needs the dtrace_kernel or dtrace_proc privileges when telegraf
+  ## does not run as root, set to true to run it through pfexec.
+  # use_pfexec = false
+`
+
+func (_ *DTrace) SampleConfig() string {
+	return dtraceSampleConfig
+}
+
+// Init checks the mode, the script and the duration.
+func (d *DTrace) Init() error {
+	switch d.Mode {
+	case "aggregation":
+		if d.LongRunning {
+			return fmt.Errorf("long_running requires the influx mode")
+		}
+	case "influx":
+	default:
+		return fmt.Errorf("unknown mode %q, must be aggregation or influx",
+			d.Mode)
+	}
+	if (d.Script == "") == (d.ScriptFile == "") {
+		return fmt.Errorf("exactly one of script and script_file is required")
+	}
+	if !d.LongRunning && d.Duration.Duration < time.Millisecond {
+		return fmt.Errorf("duration must be at least 1ms")
+	}
+	return nil
+}
+
+func (d *DTrace) Gather(acc Accumulator) error {
+	if d.LongRunning {
+		return d.gatherLongRunning(acc)
+	}
+
+	script, cleanup, err := d.scriptFile()
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	run := d.runDTrace
+	if run == nil {
+		run = dtraceRunner
+	}
+	exit := fmt.Sprintf("tick-%dms { exit(0); }",
+		d.Duration.Duration/time.Millisecond)
+	out, err := run(d.Duration.Duration+10*time.Second, d.UsePfexec,
+		"-q", "-s", script, "-n", exit)
+	if err != nil {
+		return fmt.Errorf("error running dtrace: %s: %s", err,
+			strings.TrimSpace(string(out)))
+	}
+
+	if d.Mode == "influx" {
+		parser := &InfluxParser{}
+		metrics, err := parser.Parse(out)
+		for _, m := range metrics {
+			acc.AddFields(m.Name(), m.Fields(), m.Tags(), m.Time())
+		}
+		return err
+	}
+	d.parseAggregations(string(out), acc, time.Now())
+	return nil
+}
+
+// scriptFile returns the path of the script, written to a temporary file
+// when it is inline, and a function removing that file.
+func (d *DTrace) scriptFile() (string, func(), error) {
+	if d.ScriptFile != "" {
+		return d.ScriptFile, func() {}, nil
+	}
+	f, err := ioutil.TempFile("", "telegraf-dtrace")
+	if err != nil {
+		return "", nil, err
+	}
+	defer f.Close()
+	if _, err := f.WriteString(d.Script); err != nil {
+		os.Remove(f.Name())
+		return "", nil, err
+	}
+	return f.Name(), func() { os.Remove(f.Name()) }, nil
+}
+
+// parseAggregations parses the aggregations printed by dtrace: a line per
+// key with the keys followed by the value, or for distributions such as
+// quantize, a line with the keys followed by a table of buckets.
+func (d *DTrace) parseAggregations(out string, acc Accumulator, now time.Time) {
+	lines := strings.Split(out, "\n")
+	for i := 0; i < len(lines); i++ {
+		cols := strings.Fields(lines[i])
+		if len(cols) == 0 {
+			continue
+		}
+
+		// a distribution table follows its keys
+		if i+1 < len(lines) && dtraceDistribution.MatchString(lines[i+1]) {
+			tags := d.keyTags(cols)
+			for i += 2; i < len(lines); i++ {
+				match := dtraceBucket.FindStringSubmatch(lines[i])
+				if match == nil {
+					break
+				}
+				count, _ := strconv.ParseInt(match[2], 10, 64)
+				bucketTags := make(map[string]string, len(tags)+1)
+				for k, v := range tags {
+					bucketTags[k] = v
+				}
+				bucketTags["bucket"] = strings.Replace(match[1], " ", "", -1)
+				acc.AddHistogram(d.Measurement,
+					map[string]interface{}{"count": count}, bucketTags, now)
+			}
+			continue
+		}
+
+		value, err := strconv.ParseInt(cols[len(cols)-1], 10, 64)
+		if err != nil {
+			continue
+		}
+		acc.AddGauge(d.Measurement, map[string]interface{}{"value": value},
+			d.keyTags(cols[:len(cols)-1]), now)
+	}
+}
+
+func (d *DTrace) keyTags(keys []string) map[string]string {
+	tags := make(map[string]string, len(keys))
+	for i, key := range keys {
+		name := "key" + strconv.Itoa(i)
+		if i < len(d.Keys) {
+			name = d.Keys[i]
+		}
+		tags[name] = key
+	}
+	return tags
+}
+
+// gatherLongRunning starts the consumer if it is not running and adds the
+// metrics it printed since the previous gather.
+func (d *DTrace) gatherLongRunning(acc Accumulator) error {
+	d.mu.Lock()
+	running := d.cmd != nil
+	pending, errs := d.pending, d.errs
+	d.pending, d.errs = nil, nil
+	d.mu.Unlock()
+
+	for _, m := range pending {
+		acc.AddFields(m.Name(), m.Fields(), m.Tags(), m.Time())
+	}
+	for _, err := range errs {
+		acc.AddError(err)
+	}
+	if running {
+		return nil
+	}
+	return d.start()
+}
+
+func (d *DTrace) start() error {
+	script, cleanup, err := d.scriptFile()
+	if err != nil {
+		return err
+	}
+
+	cmd, err := dtraceCommand(d.UsePfexec, "-q", "-s", script)
+	if err != nil {
+		cleanup()
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		cleanup()
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		cleanup()
+		return fmt.Errorf("error starting dtrace: %s", err)
+	}
+
+	d.mu.Lock()
+	d.cmd = cmd
+	d.mu.Unlock()
+
+	go func() {
+		parser := &InfluxParser{}
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+			m, err := parser.ParseLine(line)
+			if err != nil {
+				d.mu.Lock()
+				d.errs = append(d.errs, NewGatherError(err, "line", line))
+				d.mu.Unlock()
+				continue
+			}
+			d.mu.Lock()
+			d.pending = append(d.pending, m)
+			d.mu.Unlock()
+		}
+
+		err := cmd.Wait()
+		cleanup()
+		d.mu.Lock()
+		d.errs = append(d.errs, fmt.Errorf("dtrace exited, restarting it: %v", err))
+		d.cmd = nil
+		d.mu.Unlock()
+	}()
+	return nil
+}
+
+func dtraceCommand(pfexec bool, args ...string) (*exec.Cmd, error) {
+	bin, err := exec.LookPath("dtrace")
+	if err != nil {
+		return nil, err
+	}
+	if pfexec {
+		args = append([]string{bin}, args...)
+		if bin, err = exec.LookPath("pfexec"); err != nil {
+			return nil, err
+		}
+	}
+	return exec.Command(bin, args...), nil
+}
+
+func dtraceRunner(
+	timeout time.Duration,
+	pfexec bool,
+	args ...string,
+) ([]byte, error) {
+	c, err := dtraceCommand(pfexec, args...)
+	if err != nil {
+		return nil, err
+	}
+	return CombinedOutputTimeout(c, timeout)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Exec runs commands every interval and parses their standard output with
+// the parser of its data_format, json by default.
+type Exec struct {
+	Commands []string
+	Command  string
+	Timeout  Duration
+
+	parser Parser
+}
+
+func NewExec() Input {
+	return &Exec{
+		Timeout: Duration{Duration: 5 * time.Second},
+	}
+}
+
+func (_ *Exec) Description() string {
+	return "Read metrics from one or more commands that can output to stdout"
+}
+
+var execSampleConfig = `
+  ## Commands array, run through /bin/sh so they can use pipes and
+  ## redirections.
+  commands = [
+    "/opt/site/bin/collect_app_metrics.sh",
+    "/usr/bin/mycollector --foo=bar",
+  ]
+
+  ## Timeout for each command to complete.
+  timeout = "5s"
+
+  ## measurement name suffix (for separating different commands)
+  name_suffix = "_mycollector"
+
+  ## Data format to consume, one of json, influx, graphite, value, nagios,
+  ## grok or csv.
+  data_format = "influx"
+
+  ## With json, the dot-separated path of the object, or array of objects,
+  ## to parse in the document, and the keys of the tags.
+  # json_query = "data.servers"
+  # tag_keys = ["name"]
+
+  ## With csv, the number of header rows naming the columns, concatenated,
+  ## or the names of the columns, and their types, int, float, bool or
+  ## string, guessed when empty.
+  # csv_header_row_count = 1
+  # csv_column_names = []
+  # csv_column_types = []
+  ## Rows and first columns to skip, delimiter and comment characters.
+  # csv_skip_rows = 0
+  # csv_skip_columns = 0
+  # csv_delimiter = ","
+  # csv_comment = "#"
+  # csv_trim_space = false
+  ## Columns of the tags, the measurement and the timestamp, in the format
+  ## unix, unix_ms, unix_us, unix_ns or a Go time layout.
+  # csv_tag_columns = []
+  # csv_measurement_column = ""
+  # csv_timestamp_column = ""
+  # csv_timestamp_format = "unix"
+`
+
+func (_ *Exec) SampleConfig() string {
+	return execSampleConfig
+}
+
+func (e *Exec) SetParser(parser Parser) {
+	e.parser = parser
+}
+
+// Init checks that there is a command to run.
+func (e *Exec) Init() error {
+	if len(e.Commands) == 0 && e.Command == "" {
+		return fmt.Errorf("no commands configured")
+	}
+	return nil
+}
+
+func (e *Exec) Gather(acc Accumulator) error {
+	commands := e.Commands
+	if e.Command != "" {
+		commands = append(commands, e.Command)
+	}
+
+	var wg sync.WaitGroup
+	for _, command := range commands {
+		wg.Add(1)
+		go func(command string) {
+			defer wg.Done()
+			if err := e.gatherCommand(command, acc); err != nil {
+				acc.AddError(err)
+			}
+		}(command)
+	}
+	wg.Wait()
+	return nil
+}
+
+func (e *Exec) gatherCommand(command string, acc Accumulator) error {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("/bin/sh", "-c", command)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := RunTimeout(cmd, e.Timeout.Duration); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			err = fmt.Errorf("%s: %s", err, msg)
+		}
+		return NewGatherError(err, "command", command)
+	}
+
+	metrics, err := e.parser.Parse(stdout.Bytes())
+	if err != nil {
+		return NewGatherError(err, "command", command)
+	}
+	for _, m := range metrics {
+		acc.AddFields(m.Name(), m.Fields(), m.Tags(), m.Time())
+	}
+	return nil
+}
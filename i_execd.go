@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// Execd runs a long running program, ie, a plugin built out of tree with the
+// sdk/shim package, and parses the lines it prints on its standard output
+// with the parser of its data_format, influx by default. It runs as a
+// service, the program being restarted per the restart_policy of the input
+// when it exits.
+type Execd struct {
+	Command []string
+	Signal  string
+
+	parser Parser
+
+	// the running program, and the metrics it printed and the errors it
+	// caused since the last gather
+	mu      sync.Mutex
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	pending []Metric
+	errs    []error
+	exitErr error
+}
+
+func NewExecd() Input {
+	return &Execd{
+		Signal: "none",
+	}
+}
+
+func (_ *Execd) Description() string {
+	return "Run a long running program and parse the metrics it prints"
+}
+
+var execdSampleConfig = `
+  ## Program to run, and its arguments
+  command = ["/opt/site/bin/my_plugin", "-config", "/etc/my_plugin.conf"]
+
+  ## Signal sent to the program on each gather, one of:
+  ##   "none"  : the program prints metrics on its own schedule
+  ##   "STDIN" : a newline is written to the program's standard input, the
+  ##             metrics it prints in response are added on the next gather
+  signal = "none"
+
+  ## Data format to consume, each line is parsed on its own.
+  data_format = "influx"
+
+  ## Restart the program when it exits, "on_failure" or "never", after
+  ## restart_delay, doubled on each consecutive failure.
+  # restart_policy = "on_failure"
+  # restart_delay = "10s"
+`
+
+func (_ *Execd) SampleConfig() string {
+	return execdSampleConfig
+}
+
+func (e *Execd) SetParser(parser Parser) {
+	e.parser = parser
+}
+
+// Init checks the command and the signal, so that a missing command is not
+// retried forever by the restart policy.
+func (e *Execd) Init() error {
+	if len(e.Command) == 0 || e.Command[0] == "" {
+		return fmt.Errorf("no command configured")
+	}
+	switch e.Signal {
+	case "", "none", "STDIN":
+	default:
+		return fmt.Errorf("invalid signal %q, expected none or STDIN", e.Signal)
+	}
+	return nil
+}
+
+func (e *Execd) Gather(acc Accumulator) error {
+	e.mu.Lock()
+	running := e.cmd != nil
+	stdin := e.stdin
+	exitErr := e.exitErr
+	pending, errs := e.pending, e.errs
+	e.pending, e.errs = nil, nil
+	e.mu.Unlock()
+
+	for _, m := range pending {
+		acc.AddFields(m.Name(), m.Fields(), m.Tags(), m.Time())
+	}
+	for _, err := range errs {
+		acc.AddError(err)
+	}
+	if !running {
+		return NewGatherError(fmt.Errorf("exited: %v", exitErr), "command", e.Command[0])
+	}
+
+	if e.Signal == "STDIN" {
+		if _, err := io.WriteString(stdin, "\n"); err != nil {
+			return fmt.Errorf("execd: error signaling %s: %s", e.Command[0], err)
+		}
+	}
+	return nil
+}
+
+func (e *Execd) Start(_ Accumulator) error {
+	cmd := exec.Command(e.Command[0], e.Command[1:]...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("execd: error starting %s: %s", e.Command[0], err)
+	}
+
+	e.mu.Lock()
+	e.cmd = cmd
+	e.stdin = stdin
+	e.exitErr = nil
+	e.mu.Unlock()
+
+	// the program reports its errors on its standard error
+	go func() {
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			log.Printf("E! [inputs.execd] %s: %s", e.Command[0], scanner.Text())
+		}
+	}()
+
+	go func() {
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+			m, err := e.parser.ParseLine(line)
+			if err != nil {
+				e.mu.Lock()
+				e.errs = append(e.errs, NewGatherError(err, "command", e.Command[0],
+					"line", line))
+				e.mu.Unlock()
+				continue
+			}
+			if m == nil {
+				continue
+			}
+			e.mu.Lock()
+			e.pending = append(e.pending, m)
+			e.mu.Unlock()
+		}
+
+		err := cmd.Wait()
+		e.mu.Lock()
+		if e.cmd == cmd {
+			e.cmd = nil
+			e.stdin = nil
+			e.exitErr = err
+		}
+		e.mu.Unlock()
+	}()
+	return nil
+}
+
+// Stop closes the standard input of the program, which it is expected to
+// exit on, and kills it.
+func (e *Execd) Stop() {
+	e.mu.Lock()
+	cmd, stdin := e.cmd, e.stdin
+	e.cmd, e.stdin = nil, nil
+	e.mu.Unlock()
+	if cmd == nil {
+		return
+	}
+	stdin.Close()
+	cmd.Process.Kill()
+}
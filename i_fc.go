@@ -0,0 +1,235 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FCRunner runs fcinfo(1M) or iostat(1M), given as the command, with the
+// given arguments and returns its output. It can be replaced with a mocked
+// function for unit test purposes.
+type FCRunner func(timeout time.Duration, command string, args ...string) ([]byte, error)
+
+// fcLinkErrors are the fields of the link error statistics of fcinfo.
+var fcLinkErrors = map[string]string{
+	"Link Failure Count":                 "link_failures",
+	"Loss of Sync Count":                 "loss_of_sync",
+	"Loss of Signal Count":               "loss_of_signal",
+	"Primitive Seq Protocol Error Count": "primitive_seq_protocol_errors",
+	"Invalid Tx Word Count":              "invalid_tx_words",
+	"Invalid CRC Count":                  "invalid_crcs",
+}
+
+// FC reports the state, speed and link errors of the Fibre Channel HBA
+// ports listed by fcinfo, and the throughput of their controllers measured
+// by iostat.
+type FC struct {
+	Ports      []string
+	Throughput bool
+	Timeout    Duration
+
+	runFC FCRunner
+}
+
+func NewFC() Input {
+	return &FC{
+		Throughput: true,
+		Timeout:    Duration{Duration: 10 * time.Second},
+		runFC:      fcRunner,
+	}
+}
+
+func (_ *FC) Description() string {
+	return "Read the link errors and throughput of Fibre Channel HBA ports"
+}
+
+var fcSampleConfig = `
+  ## Ports to report on, as globs matched against the port WWN.
+  ## If empty, all ports are reported.
+  # ports = ["210000e08b*"]
+  ## Report the reads and writes per second of the controller of each port,
+  ## measured by iostat -xnC over a second.
+  # throughput = true
+  ## Timeout for the fcinfo and iostat commands to complete
+  # timeout = "10s"
+`
+
+func (_ *FC) SampleConfig() string {
+	return fcSampleConfig
+}
+
+// Init checks the globs of the ports.
+func (f *FC) Init() error {
+	return checkGlobs("ports", f.Ports)
+}
+
+func (f *FC) Gather(acc Accumulator) error {
+	run := f.runFC
+	if run == nil {
+		run = fcRunner
+	}
+	out, err := run(f.Timeout.Duration, "fcinfo", "hba-port", "-l")
+	if err != nil {
+		return fmt.Errorf("error getting HBA ports: %s", err)
+	}
+	ports := parseFcinfo(string(out))
+
+	var throughput map[string]map[string]interface{}
+	if f.Throughput && len(ports) != 0 {
+		out, err := run(f.Timeout.Duration, "iostat", "-xnC", "1", "2")
+		if err != nil {
+			acc.AddError(fmt.Errorf("error getting controller throughput: %s", err))
+		} else {
+			throughput = parseControllerIostat(string(out))
+		}
+	}
+
+	now := time.Now()
+	for _, port := range ports {
+		wwn := port.props["HBA Port WWN"]
+		if len(f.Ports) != 0 && !matchesAny(wwn, f.Ports) {
+			continue
+		}
+
+		state := port.props["State"]
+		fields := map[string]interface{}{
+			"online": state == "online",
+		}
+		for key, field := range fcLinkErrors {
+			setKstatField(fields, field, port.props[key])
+		}
+		// "4Gb", or "not established" when the link is down
+		speed := strings.TrimSuffix(port.props["Current Speed"], "Gb")
+		if v, err := strconv.ParseFloat(speed, 64); err == nil {
+			fields["speed_gbps"] = v
+		}
+
+		controller := filepath.Base(port.props["OS Device Name"])
+		for k, v := range throughput[controller] {
+			fields[k] = v
+		}
+
+		tags := map[string]string{
+			"port_wwn": wwn,
+			"state":    state,
+		}
+		for tag, key := range map[string]string{
+			"node_wwn":  "Node WWN",
+			"model":     "Model",
+			"driver":    "Driver Name",
+			"port_type": "Type",
+			"port_mode": "Port Mode",
+		} {
+			if v := port.props[key]; v != "" {
+				tags[tag] = v
+			}
+		}
+		if controller != "." {
+			tags["controller"] = controller
+		}
+		acc.AddFields("fc", fields, tags, now)
+	}
+	return nil
+}
+
+// fcPort holds the properties of an HBA port listed by fcinfo.
+type fcPort struct {
+	props map[string]string
+}
+
+// parseFcinfo parses the output of fcinfo hba-port -l, a block of
+// "name: value" lines per port, starting with its WWN:
+//
+//	HBA Port WWN: 210000e08b074cb5
+//	        Port Mode: Initiator
+//	        OS Device Name: /dev/cfg/c5
+//	        State: online
+//	        Current Speed: 4Gb
+//	        Link Error Statistics:
+//	                Link Failure Count: 0
+//	                Invalid CRC Count: 0
+func parseFcinfo(out string) []*fcPort {
+	var ports []*fcPort
+	var port *fcPort
+	for _, line := range strings.Split(out, "\n") {
+		kv := strings.SplitN(strings.TrimSpace(line), ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		key, value := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+		if key == "HBA Port WWN" {
+			port = &fcPort{props: make(map[string]string)}
+			ports = append(ports, port)
+		}
+		if port != nil {
+			port.props[key] = value
+		}
+	}
+	return ports
+}
+
+// parseControllerIostat returns the throughput fields of the controllers in
+// the last report printed by iostat -xnC:
+//
+//	 r/s    w/s   kr/s   kw/s wait actv wsvc_t asvc_t  %w  %b device
+//	12.0    3.0  768.0  192.0  0.0  0.1    0.0    4.2   0   5 c5
+func parseControllerIostat(out string) map[string]map[string]interface{} {
+	var controllers map[string]map[string]interface{}
+	for _, line := range strings.Split(out, "\n") {
+		cols := strings.Fields(line)
+		if len(cols) != 11 {
+			continue
+		}
+		if cols[0] == "r/s" {
+			// a new report starts
+			controllers = make(map[string]map[string]interface{})
+			continue
+		}
+		device := cols[10]
+		if controllers == nil || !isController(device) {
+			continue
+		}
+		values := make([]float64, 4)
+		ok := true
+		for i := range values {
+			v, err := strconv.ParseFloat(cols[i], 64)
+			if err != nil {
+				ok = false
+				break
+			}
+			values[i] = v
+		}
+		if !ok {
+			continue
+		}
+		controllers[device] = map[string]interface{}{
+			"reads_per_sec":         values[0],
+			"writes_per_sec":        values[1],
+			"read_bytes_per_sec":    values[2] * 1024,
+			"written_bytes_per_sec": values[3] * 1024,
+		}
+	}
+	return controllers
+}
+
+// isController returns true for the cN controller names of iostat.
+func isController(device string) bool {
+	if len(device) < 2 || device[0] != 'c' {
+		return false
+	}
+	_, err := strconv.Atoi(device[1:])
+	return err == nil
+}
+
+func fcRunner(timeout time.Duration, command string, args ...string) ([]byte, error) {
+	bin, err := exec.LookPath(command)
+	if err != nil {
+		return nil, err
+	}
+	c := exec.Command(bin, args...)
+	return CombinedOutputTimeout(c, timeout)
+}
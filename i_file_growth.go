@@ -0,0 +1,243 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// FileGrowth reports the files and subdirectories of directories that grew
+// the most since the previous gather, to find what fills a file system.
+type FileGrowth struct {
+	Directories []string
+	Top         int
+	MaxDepth    int `toml:"max_depth"`
+	MaxFiles    int `toml:"max_files"`
+
+	// the sizes of the files and directories of each directory at the
+	// previous gather, and when it was
+	lastSizes map[string]*fileSizes
+	lastTime  time.Time
+}
+
+// fileSizes are the sizes of the files and directories under a directory, by
+// path.
+type fileSizes struct {
+	sizes map[string]int64
+	dirs  map[string]bool
+}
+
+// errMaxFiles stops the walk of a directory at max_files.
+var errMaxFiles = errors.New("max_files reached")
+
+// fileGrowthEntry is a file or directory that grew.
+type fileGrowthEntry struct {
+	path   string
+	isDir  bool
+	size   int64
+	growth int64
+}
+
+func NewFileGrowth() Input {
+	return &FileGrowth{
+		Top:      10,
+		MaxDepth: 3,
+		MaxFiles: 100000,
+	}
+}
+
+func (_ *FileGrowth) Description() string {
+	return "Report the fastest growing files and subdirectories of directories"
+}
+
+var fileGrowthSampleConfig = `
+  ## Directories to watch, the file systems mounted under them are skipped.
+  directories = ["/var"]
+  ## Number of fastest growing files and subdirectories reported for each
+  ## directory, the growth is measured over the interval of the input, which
+  ## should be a few minutes as the directories are walked on every gather.
+  # top = 10
+  ## Subdirectories deeper than this are only reported as part of their
+  ## parents, files are reported at any depth.
+  # max_depth = 3
+  ## Files walked at most per directory, the rest of the directory is
+  ## skipped.
+  # max_files = 100000
+`
+
+func (_ *FileGrowth) SampleConfig() string {
+	return fileGrowthSampleConfig
+}
+
+// Init checks the directories and the limits.
+func (f *FileGrowth) Init() error {
+	if len(f.Directories) == 0 {
+		return fmt.Errorf("no directories configured")
+	}
+	if f.Top <= 0 {
+		return fmt.Errorf("top must be positive")
+	}
+	if f.MaxDepth < 0 || f.MaxFiles <= 0 {
+		return fmt.Errorf("max_depth cannot be negative and max_files must be positive")
+	}
+	return nil
+}
+
+func (f *FileGrowth) Gather(acc Accumulator) error {
+	now := time.Now()
+	elapsed := now.Sub(f.lastTime).Seconds()
+	sizes := make(map[string]*fileSizes, len(f.Directories))
+	for _, dir := range f.Directories {
+		dir = filepath.Clean(dir)
+		current, err := f.walk(dir)
+		if err != nil {
+			acc.AddError(NewGatherError(err, "directory", dir))
+			continue
+		}
+		sizes[dir] = current
+		if last, ok := f.lastSizes[dir]; ok {
+			f.report(acc, dir, current, last, elapsed, now)
+		}
+	}
+	f.lastSizes = sizes
+	f.lastTime = now
+	return nil
+}
+
+// walk returns the size of the files under the directory, and the total
+// size of the directory and of its subdirectories down to max_depth.
+func (f *FileGrowth) walk(root string) (*fileSizes, error) {
+	info, err := os.Lstat(root)
+	if err != nil {
+		return nil, err
+	}
+	dev := fileDevice(info)
+	sizes := &fileSizes{
+		sizes: make(map[string]int64),
+		dirs:  make(map[string]bool),
+	}
+	files := 0
+	err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// files removed or unreadable while walking are skipped
+			return nil
+		}
+		if info.IsDir() {
+			if path != root && fileDevice(info) != dev {
+				return filepath.SkipDir
+			}
+			if f.depth(root, path) <= f.MaxDepth {
+				sizes.dirs[path] = true
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		if files++; files > f.MaxFiles {
+			return errMaxFiles
+		}
+
+		size := info.Size()
+		sizes.sizes[path] = size
+		// charge the file to its directories
+		for dir := filepath.Dir(path); ; dir = filepath.Dir(dir) {
+			if sizes.dirs[dir] {
+				sizes.sizes[dir] += size
+			}
+			if dir == root || dir == filepath.Dir(dir) {
+				break
+			}
+		}
+		return nil
+	})
+	if err == errMaxFiles {
+		err = nil
+	}
+	return sizes, err
+}
+
+// depth returns the depth of the directory under root, 0 for root itself.
+func (f *FileGrowth) depth(root, dir string) int {
+	rel, err := filepath.Rel(root, dir)
+	if err != nil || rel == "." {
+		return 0
+	}
+	return strings.Count(rel, string(filepath.Separator)) + 1
+}
+
+// report adds the growth of the directory and of its files and
+// subdirectories that grew the most.
+func (f *FileGrowth) report(
+	acc Accumulator,
+	root string,
+	current, last *fileSizes,
+	elapsed float64,
+	now time.Time,
+) {
+	var entries []*fileGrowthEntry
+	for path, size := range current.sizes {
+		growth := size - last.sizes[path]
+		if path == root || growth <= 0 {
+			continue
+		}
+		entries = append(entries, &fileGrowthEntry{
+			path:   path,
+			isDir:  current.dirs[path],
+			size:   size,
+			growth: growth,
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].growth != entries[j].growth {
+			return entries[i].growth > entries[j].growth
+		}
+		return entries[i].path < entries[j].path
+	})
+	if len(entries) > f.Top {
+		entries = entries[:f.Top]
+	}
+
+	for rank, entry := range entries {
+		kind := "file"
+		if entry.isDir {
+			kind = "directory"
+		}
+		fields := map[string]interface{}{
+			"size_bytes":   entry.size,
+			"growth_bytes": entry.growth,
+			"rank":         rank + 1,
+		}
+		if elapsed > 0 {
+			fields["growth_rate"] = float64(entry.growth) / elapsed
+		}
+		acc.AddFields("file_growth", fields, map[string]string{
+			"directory": root,
+			"path":      entry.path,
+			"type":      kind,
+		}, now)
+	}
+
+	growth := current.sizes[root] - last.sizes[root]
+	fields := map[string]interface{}{
+		"size_bytes":   current.sizes[root],
+		"growth_bytes": growth,
+	}
+	if elapsed > 0 {
+		fields["growth_rate"] = float64(growth) / elapsed
+	}
+	acc.AddFields("file_growth_total", fields, map[string]string{"directory": root}, now)
+}
+
+// fileDevice returns the device of the file system the file is on.
+func fileDevice(info os.FileInfo) uint64 {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Dev)
+	}
+	return 0
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// FmadmRunner runs fmadm(1M) with the given arguments and returns its
+// output. It can be replaced with a mocked function for unit test purposes.
+type FmadmRunner func(
+	timeout time.Duration,
+	pfexec bool,
+	args ...string,
+) ([]byte, error)
+
+// Fmadm reports the faults diagnosed by the fault manager, as listed by
+// "fmadm faulty".
+type Fmadm struct {
+	UsePfexec bool `toml:"use_pfexec"`
+	Timeout   Duration
+
+	runFmadm FmadmRunner
+}
+
+func NewFmadm() Input {
+	return &Fmadm{
+		Timeout:  Duration{Duration: 10 * time.Second},
+		runFmadm: fmadmRunner,
+	}
+}
+
+func (_ *Fmadm) Description() string {
+	return "Read the active faults from the fault manager"
+}
+
+var fmadmSampleConfig = `
+  ## fmadm needs the "Fault Management" rights profile when telegraf does
+  ## not run as root, set to true to run it through pfexec.
+  # use_pfexec = false
+  ## Timeout for the fmadm command to complete
+  # timeout = "10s"
+`
+
+func (_ *Fmadm) SampleConfig() string {
+	return fmadmSampleConfig
+}
+
+// fmadmFault is a single fault listed by "fmadm faulty".
+type fmadmFault struct {
+	EventID    string
+	MsgID      string
+	Severity   string
+	FaultClass string
+	FRU        string
+}
+
+func (f *Fmadm) Gather(acc Accumulator) error {
+	run := f.runFmadm
+	if run == nil {
+		run = fmadmRunner
+	}
+	out, err := run(f.Timeout.Duration, f.UsePfexec, "faulty")
+	if err != nil {
+		return fmt.Errorf("error getting fmadm faults: %s", err)
+	}
+
+	now := time.Now()
+	faults := parseFmadmFaulty(string(out))
+	counts := map[string]interface{}{
+		"faults":   int64(len(faults)),
+		"critical": int64(0),
+		"major":    int64(0),
+		"minor":    int64(0),
+	}
+	for _, fault := range faults {
+		severity := strings.ToLower(fault.Severity)
+		if n, ok := counts[severity].(int64); ok {
+			counts[severity] = n + 1
+		}
+
+		tags := map[string]string{
+			"severity":    severity,
+			"msg_id":      fault.MsgID,
+			"fault_class": fault.FaultClass,
+			"fru":         fault.FRU,
+		}
+		fields := map[string]interface{}{
+			"event_id": fault.EventID,
+			"active":   true,
+		}
+		acc.AddGauge("fmadm_fault", fields, tags, now)
+	}
+	acc.AddGauge("fmadm", counts, nil, now)
+	return nil
+}
+
+// parseFmadmFaulty parses the output of "fmadm faulty". Each fault starts
+// with a TIME EVENT-ID MSG-ID SEVERITY table followed by "key : value"
+// details, of which only the first fault class and FRU are kept.
+func parseFmadmFaulty(out string) []*fmadmFault {
+	var faults []*fmadmFault
+	var fault *fmadmFault
+	inHeader := false
+
+	for _, line := range strings.Split(out, "\n") {
+		switch {
+		case strings.HasPrefix(line, "---"):
+			continue
+		case strings.HasPrefix(line, "TIME"):
+			inHeader = true
+			continue
+		case inHeader:
+			// Mon DD HH:MM:SS EVENT-ID MSG-ID SEVERITY
+			inHeader = false
+			cols := strings.Fields(line)
+			if len(cols) < 6 {
+				fault = nil
+				continue
+			}
+			fault = &fmadmFault{
+				EventID:  cols[3],
+				MsgID:    cols[4],
+				Severity: cols[5],
+			}
+			faults = append(faults, fault)
+			continue
+		}
+
+		if fault == nil {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		value := strings.TrimSpace(parts[1])
+		switch strings.TrimSpace(parts[0]) {
+		case "Fault class", "Problem class":
+			if fault.FaultClass == "" {
+				fault.FaultClass = value
+			}
+		case "FRU":
+			if fault.FRU == "" {
+				fault.FRU = fmadmFRU(value)
+			}
+		}
+	}
+	return faults
+}
+
+// fmadmFRU returns the label of a FRU, written as "label" (hc://...), or
+// the FMRI when the FRU has no label.
+func fmadmFRU(value string) string {
+	if strings.HasPrefix(value, `"`) {
+		if end := strings.Index(value[1:], `"`); end != -1 {
+			return value[1 : end+1]
+		}
+	}
+	return strings.Trim(value, "()")
+}
+
+func fmadmRunner(
+	timeout time.Duration,
+	pfexec bool,
+	args ...string,
+) ([]byte, error) {
+	bin, err := exec.LookPath("fmadm")
+	if err != nil {
+		return nil, err
+	}
+	if pfexec {
+		args = append([]string{bin}, args...)
+		if bin, err = exec.LookPath("pfexec"); err != nil {
+			return nil, err
+		}
+	}
+	c := exec.Command(bin, args...)
+	return CombinedOutputTimeout(c, timeout)
+}
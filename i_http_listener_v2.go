@@ -0,0 +1,258 @@
+package main
+
+import (
+	"compress/gzip"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HTTPListenerV2 accepts metrics written to the /write endpoint of the
+// InfluxDB 1.x HTTP API, so that other agents and InfluxDB client libraries
+// can relay metrics through this one. It also answers the /ping of clients
+// checking the server is up. The server runs as a service and the metrics
+// received in between are added on each gather.
+type HTTPListenerV2 struct {
+	ServiceAddress string   `toml:"service_address"`
+	Path           string   `toml:"path"`
+	MaxBodySize    int64    `toml:"max_body_size"`
+	MaxPending     int      `toml:"max_pending"`
+	ReadTimeout    Duration `toml:"read_timeout"`
+	WriteTimeout   Duration `toml:"write_timeout"`
+	BasicUsername  string   `toml:"basic_username"`
+	BasicPassword  string   `toml:"basic_password"`
+	TLSCert        string   `toml:"tls_cert"`
+	TLSKey         string   `toml:"tls_key"`
+
+	parser  Parser
+	binding *LocalBinding
+
+	mu      sync.Mutex
+	pending []Metric
+	server  *http.Server
+	// the error the server stopped on, returned by the next Gather so that
+	// the agent restarts the listener
+	failed error
+
+	// counts metrics received while max_pending metrics were waiting
+	droppedOverflow Stat
+}
+
+func NewHTTPListenerV2() Input {
+	return &HTTPListenerV2{
+		ServiceAddress:  ":8186",
+		Path:            "/write",
+		MaxBodySize:     32 * 1024 * 1024,
+		MaxPending:      100000,
+		ReadTimeout:     Duration{Duration: 10 * time.Second},
+		WriteTimeout:    Duration{Duration: 10 * time.Second},
+		droppedOverflow: RegisterDropped("receive", "inputs.http_listener_v2", "overflow"),
+	}
+}
+
+func (_ *HTTPListenerV2) Description() string {
+	return "Receive metrics written to an InfluxDB compatible HTTP write API"
+}
+
+var httpListenerV2SampleConfig = `
+  ## Address to listen on
+  # service_address = ":8186"
+  ## Path of the write endpoint, /ping is answered too
+  # path = "/write"
+
+  ## Largest request body accepted, in bytes, after decompression
+  # max_body_size = 33554432
+  ## Metrics kept between two gathers, further metrics are dropped
+  # max_pending = 100000
+
+  ## Timeouts reading requests and writing responses
+  # read_timeout = "10s"
+  # write_timeout = "10s"
+
+  ## Credentials the clients must send with HTTP basic authentication
+  # basic_username = "telegraf"
+  # basic_password = "metricsmetricsmetrics"
+
+  ## Serve over TLS
+  # tls_cert = "/etc/telegraf/cert.pem"
+  # tls_key = "/etc/telegraf/key.pem"
+
+  ## Data format to consume. With the influx format, the precision query
+  ## parameter of the InfluxDB API sets the unit of the timestamps.
+  data_format = "influx"
+`
+
+func (_ *HTTPListenerV2) SampleConfig() string {
+	return httpListenerV2SampleConfig
+}
+
+func (h *HTTPListenerV2) SetParser(parser Parser) {
+	h.parser = parser
+}
+
+// SetLocalBinding listens on the local address instead of the host of the
+// service_address.
+func (h *HTTPListenerV2) SetLocalBinding(b *LocalBinding) {
+	h.binding = b
+}
+
+func (h *HTTPListenerV2) Gather(acc Accumulator) error {
+	h.mu.Lock()
+	pending := h.pending
+	h.pending = nil
+	failed := h.failed
+	h.mu.Unlock()
+
+	for _, m := range pending {
+		acc.AddFields(m.Name(), m.Fields(), m.Tags(), m.Time())
+	}
+	return failed
+}
+
+func (h *HTTPListenerV2) Start(_ Accumulator) error {
+	tlsConfig, err := GetServerTLSConfig(h.TLSCert, h.TLSKey)
+	if err != nil {
+		return err
+	}
+	address, err := h.binding.ListenAddress("tcp", h.ServiceAddress)
+	if err != nil {
+		return fmt.Errorf("error listening on %s: %s", h.ServiceAddress, err)
+	}
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("error listening on %s: %s", h.ServiceAddress, err)
+	}
+	h.server = &http.Server{
+		Handler:      h,
+		ReadTimeout:  h.ReadTimeout.Duration,
+		WriteTimeout: h.WriteTimeout.Duration,
+	}
+	if tlsConfig != nil {
+		l = tls.NewListener(l, tlsConfig)
+	}
+	h.failed = nil
+	go h.serve(h.server, l)
+	log.Printf("I! Started the HTTP listener on %s", l.Addr())
+	return nil
+}
+
+// serve serves the listener, recording the error the server stopped on
+// unless closed by Stop.
+func (h *HTTPListenerV2) serve(server *http.Server, l net.Listener) {
+	if err := server.Serve(l); err != http.ErrServerClosed {
+		log.Printf("E! [inputs.http_listener_v2] %s", err)
+		h.mu.Lock()
+		h.failed = err
+		h.mu.Unlock()
+	}
+}
+
+func (h *HTTPListenerV2) Stop() {
+	if h.server != nil {
+		h.server.Close()
+		h.server = nil
+	}
+}
+
+func (h *HTTPListenerV2) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="telegraf"`)
+		httpListenerError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	switch r.URL.Path {
+	case h.Path:
+		h.serveWrite(w, r)
+	case "/ping":
+		w.Header().Set("X-Influxdb-Version", "1.8.10")
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		httpListenerError(w, http.StatusNotFound, "not found")
+	}
+}
+
+func (h *HTTPListenerV2) authorized(r *http.Request) bool {
+	if h.BasicUsername == "" && h.BasicPassword == "" {
+		return true
+	}
+	username, password, ok := r.BasicAuth()
+	return ok &&
+		subtle.ConstantTimeCompare([]byte(username), []byte(h.BasicUsername)) == 1 &&
+		subtle.ConstantTimeCompare([]byte(password), []byte(h.BasicPassword)) == 1
+}
+
+func (h *HTTPListenerV2) serveWrite(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		httpListenerError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var body io.Reader = http.MaxBytesReader(w, r.Body, h.MaxBodySize)
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			httpListenerError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		defer gz.Close()
+		// one more byte to tell bodies of max_body_size from longer ones
+		body = io.LimitReader(gz, h.MaxBodySize+1)
+	}
+	payload, err := ioutil.ReadAll(body)
+	if err == nil && int64(len(payload)) > h.MaxBodySize {
+		err = fmt.Errorf("http: request body too large")
+	}
+	if err != nil {
+		if strings.Contains(err.Error(), "request body too large") {
+			httpListenerError(w, http.StatusRequestEntityTooLarge, err.Error())
+		} else {
+			httpListenerError(w, http.StatusBadRequest, err.Error())
+		}
+		return
+	}
+
+	var metrics []Metric
+	if influx, ok := h.parser.(*InfluxParser); ok {
+		metrics, err = influx.ParseWithDefaultTimePrecision(payload, time.Now(),
+			r.URL.Query().Get("precision"))
+	} else {
+		metrics, err = h.parser.Parse(payload)
+	}
+
+	// the metrics parsed before an invalid line are kept, as InfluxDB does
+	h.mu.Lock()
+	if room := h.MaxPending - len(h.pending); len(metrics) > room {
+		if room < 0 {
+			room = 0
+		}
+		h.droppedOverflow.Incr(int64(len(metrics) - room))
+		metrics = metrics[:room]
+	}
+	h.pending = append(h.pending, metrics...)
+	h.mu.Unlock()
+
+	if err != nil {
+		httpListenerError(w, http.StatusBadRequest, "unable to parse: "+err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// httpListenerError answers with the JSON error body of the InfluxDB API,
+// which client libraries report.
+func httpListenerError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Influxdb-Error", message)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
@@ -161,16 +162,6 @@ func (h *HTTPResponse) httpGather() (map[string]interface{}, error) {
 	// Check the response for a regex match.
 	if h.ResponseStringMatch != "" {
 
-		// Compile once and reuse
-		if h.compiledStringMatch == nil {
-			h.compiledStringMatch = regexp.MustCompile(h.ResponseStringMatch)
-			if err != nil {
-				log.Printf("E! Failed to compile regular expression %s : %s", h.ResponseStringMatch, err)
-				fields["result_type"] = "response_string_mismatch"
-				return fields, nil
-			}
-		}
-
 		bodyBytes, err := ioutil.ReadAll(resp.Body)
 		if err != nil {
 			log.Printf("E! Failed to read body of HTTP Response : %s", err)
@@ -193,13 +184,12 @@ func (h *HTTPResponse) httpGather() (map[string]interface{}, error) {
 	return fields, nil
 }
 
-// Gather gets all metric fields and tags and returns any errors it encounters
-func (h *HTTPResponse) Gather(acc Accumulator) error {
-	// Set default values
+// Init sets the default values, checks the address and compiles the
+// response_string_match.
+func (h *HTTPResponse) Init() error {
 	if h.ResponseTimeout.Duration < time.Second {
 		h.ResponseTimeout.Duration = time.Second * 5
 	}
-	// Check send and expected string
 	if h.Method == "" {
 		h.Method = "GET"
 	}
@@ -213,20 +203,23 @@ func (h *HTTPResponse) Gather(acc Accumulator) error {
 	if addr.Scheme != "http" && addr.Scheme != "https" {
 		return errors.New("Only http and https are supported")
 	}
-	// Prepare data
-	tags := map[string]string{"server": h.Address, "method": h.Method}
-	var fields map[string]interface{}
-
-	if h.client == nil {
-		client, err := h.createHttpClient()
+	if h.ResponseStringMatch != "" {
+		h.compiledStringMatch, err = regexp.Compile(h.ResponseStringMatch)
 		if err != nil {
-			return err
+			return fmt.Errorf("invalid response_string_match: %s", err)
 		}
-		h.client = client
 	}
+	h.client, err = h.createHttpClient()
+	return err
+}
+
+// Gather gets all metric fields and tags and returns any errors it encounters
+func (h *HTTPResponse) Gather(acc Accumulator) error {
+	// Prepare data
+	tags := map[string]string{"server": h.Address, "method": h.Method}
 
 	// Gather data
-	fields, err = h.httpGather()
+	fields, err := h.httpGather()
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// IntrstatRunner runs intrstat(1M), through pfexec(1) if set, with the given
+// arguments and returns its output. It can be replaced with a mocked
+// function for unit test purposes.
+type IntrstatRunner func(timeout time.Duration, pfexec bool, args ...string) ([]byte, error)
+
+// Interrupts reports the interrupts taken by each CPU and the time spent
+// handling them, by interrupt level from the intrstat kstats, and by device
+// from intrstat.
+type Interrupts struct {
+	Devices        bool
+	SampleInterval Duration `toml:"sample_interval"`
+	UsePfexec      bool     `toml:"use_pfexec"`
+	Timeout        Duration
+
+	runKstat    KstatRunner
+	runIntrstat IntrstatRunner
+
+	// counts and times of the interrupt levels of each CPU at the previous
+	// gather, and the snaptime of its kstat, in seconds
+	last map[string]map[string]float64
+}
+
+func NewInterrupts() Input {
+	return &Interrupts{
+		Devices:        true,
+		SampleInterval: Duration{Duration: time.Second},
+		Timeout:        Duration{Duration: 5 * time.Second},
+		runKstat:       kstatRunner,
+		runIntrstat:    intrstatRunner,
+	}
+}
+
+func (_ *Interrupts) Description() string {
+	return "Read the interrupt rates and handling time by CPU and device"
+}
+
+var interruptsSampleConfig = `
+  ## Report the interrupts of each device on each CPU, measured by intrstat
+  ## over sample_interval on each gather. intrstat uses DTrace, and needs the
+  ## dtrace_kernel privilege when telegraf does not run as root, set
+  ## use_pfexec to true to run it through pfexec.
+  # devices = true
+  # sample_interval = "1s"
+  # use_pfexec = false
+  ## Timeout for the kstat command to complete
+  # timeout = "5s"
+`
+
+func (_ *Interrupts) SampleConfig() string {
+	return interruptsSampleConfig
+}
+
+// Init checks the sample interval.
+func (i *Interrupts) Init() error {
+	if i.Devices && i.SampleInterval.Duration < time.Second {
+		return fmt.Errorf("sample_interval must be at least 1s")
+	}
+	return nil
+}
+
+func (i *Interrupts) Gather(acc Accumulator) error {
+	if i.Devices {
+		if err := i.gatherDevices(acc); err != nil {
+			acc.AddError(err)
+		}
+	}
+
+	entries, err := readKstat(i.runKstat, i.Timeout.Duration, "cpu::intrstat")
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	last := make(map[string]map[string]float64, len(entries))
+	for _, entry := range entries {
+		cpu := entry.Instance
+		current := make(map[string]float64, len(entry.Stats))
+		for stat, value := range entry.Stats {
+			if v, err := strconv.ParseFloat(value, 64); err == nil {
+				current[stat] = v
+			}
+		}
+		last[cpu] = current
+		prev, ok := i.last[cpu]
+		if !ok {
+			continue
+		}
+		elapsed := current["snaptime"] - prev["snaptime"]
+		if elapsed <= 0 {
+			continue
+		}
+
+		// level-<pil>-count and level-<pil>-time, in nanoseconds
+		fields := make(map[string]interface{})
+		var rate, busy float64
+		for pil := 1; pil <= 15; pil++ {
+			level := "level-" + strconv.Itoa(pil)
+			count := current[level+"-count"] - prev[level+"-count"]
+			t := current[level+"-time"] - prev[level+"-time"]
+			if count < 0 || t < 0 {
+				// the CPU went offline and back
+				count, t = 0, 0
+			}
+			rate += count / elapsed
+			busy += t / (elapsed * 1e9)
+			if count > 0 || t > 0 {
+				name := "level_" + strconv.Itoa(pil)
+				fields[name+"_rate"] = count / elapsed
+				fields[name+"_time_percent"] = 100 * t / (elapsed * 1e9)
+			}
+		}
+		fields["rate"] = rate
+		fields["time_percent"] = 100 * busy
+		acc.AddGauge("interrupts_cpu", fields, map[string]string{"cpu": cpu}, now)
+	}
+	i.last = last
+	return nil
+}
+
+// gatherDevices reports the interrupts of each device on each CPU printed
+// by intrstat, in tables of a few CPUs each, with the interrupts taken over
+// the interval and the percent of the time spent handling them:
+//
+//	      device |      cpu0 %tim      cpu1 %tim
+//	-------------+------------------------------
+//	       bge#0 |       812  1.9         0  0.0
+func (i *Interrupts) gatherDevices(acc Accumulator) error {
+	run := i.runIntrstat
+	if run == nil {
+		run = intrstatRunner
+	}
+	seconds := int(i.SampleInterval.Duration / time.Second)
+	out, err := run(i.SampleInterval.Duration+i.Timeout.Duration, i.UsePfexec,
+		strconv.Itoa(seconds), "1")
+	if err != nil {
+		return fmt.Errorf("error running intrstat: %s: %s", err,
+			strings.TrimSpace(string(out)))
+	}
+
+	now := time.Now()
+	var cpus []string
+	for _, line := range strings.Split(string(out), "\n") {
+		parts := strings.SplitN(line, "|", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		device := strings.TrimSpace(parts[0])
+		cols := strings.Fields(parts[1])
+		if device == "device" {
+			cpus = cpus[:0]
+			for _, col := range cols {
+				if strings.HasPrefix(col, "cpu") {
+					cpus = append(cpus, strings.TrimPrefix(col, "cpu"))
+				}
+			}
+			continue
+		}
+		if device == "" || strings.HasPrefix(device, "-") || len(cols) != 2*len(cpus) {
+			continue
+		}
+		for n, cpu := range cpus {
+			count, err := strconv.ParseInt(cols[2*n], 10, 64)
+			if err != nil {
+				continue
+			}
+			fields := map[string]interface{}{
+				"count": count,
+				"rate":  float64(count) / float64(seconds),
+			}
+			setKstatField(fields, "time_percent", cols[2*n+1])
+			acc.AddGauge("interrupts", fields, map[string]string{
+				"device": device,
+				"cpu":    cpu,
+			}, now)
+		}
+	}
+	return nil
+}
+
+func intrstatRunner(timeout time.Duration, pfexec bool, args ...string) ([]byte, error) {
+	bin, err := exec.LookPath("intrstat")
+	if err != nil {
+		return nil, err
+	}
+	if pfexec {
+		args = append([]string{bin}, args...)
+		if bin, err = exec.LookPath("pfexec"); err != nil {
+			return nil, err
+		}
+	}
+	c := exec.Command(bin, args...)
+	return CombinedOutputTimeout(c, timeout)
+}
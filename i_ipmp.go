@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// IpmpstatRunner runs ipmpstat(1M) with the given arguments and returns its
+// output. It can be replaced with a mocked function for unit test purposes.
+type IpmpstatRunner func(timeout time.Duration, args ...string) ([]byte, error)
+
+// ipmpGroupStates and ipmpInterfaceStates are the states reported by
+// ipmpstat, with the code of each state, ordered from healthy to broken.
+var ipmpGroupStates = []string{"ok", "degraded", "failed"}
+var ipmpInterfaceStates = []string{"ok", "offline", "unknown", "failed"}
+
+// IPMP reports the state of the IPMP groups and of their interfaces, and
+// counts the probe failures and the failovers seen between gathers.
+type IPMP struct {
+	Groups  []string
+	Timeout Duration
+
+	runIpmpstat IpmpstatRunner
+
+	// probe and interface state of each interface at the previous gather,
+	// and the failures counted since the first one
+	lastProbe     map[string]string
+	lastState     map[string]string
+	probeFailures map[string]int64
+	failovers     map[string]int64
+}
+
+func NewIPMP() Input {
+	return &IPMP{
+		Timeout:     Duration{Duration: 5 * time.Second},
+		runIpmpstat: ipmpstatRunner,
+	}
+}
+
+func (_ *IPMP) Description() string {
+	return "Read the state of IPMP groups and of their interfaces"
+}
+
+var ipmpSampleConfig = `
+  ## Groups to report on, as globs matched against the group name.
+  ## If empty, all groups are reported.
+  # groups = ["ipmp0"]
+  ## Timeout for the ipmpstat command to complete
+  # timeout = "5s"
+`
+
+func (_ *IPMP) SampleConfig() string {
+	return ipmpSampleConfig
+}
+
+// Init checks the globs of the groups.
+func (i *IPMP) Init() error {
+	return checkGlobs("groups", i.Groups)
+}
+
+func (i *IPMP) Gather(acc Accumulator) error {
+	run := i.runIpmpstat
+	if run == nil {
+		run = ipmpstatRunner
+	}
+	groups, err := run(i.Timeout.Duration, "-gP", "-o", "group,state,fdt,interfaces")
+	if err != nil {
+		return fmt.Errorf("error getting IPMP groups: %s", err)
+	}
+	ifaces, err := run(i.Timeout.Duration, "-iP", "-o", "interface,active,group,link,probe,state")
+	if err != nil {
+		return fmt.Errorf("error getting IPMP interfaces: %s", err)
+	}
+	if i.lastProbe == nil {
+		i.lastProbe = make(map[string]string)
+		i.lastState = make(map[string]string)
+		i.probeFailures = make(map[string]int64)
+		i.failovers = make(map[string]int64)
+	}
+
+	now := time.Now()
+	active := make(map[string]int)
+	failed := make(map[string]int)
+	for _, line := range strings.Split(string(ifaces), "\n") {
+		// net0:yes:ipmp0:up:ok:ok
+		cols := ipmpstatFields(line)
+		if len(cols) != 6 || !i.selected(cols[2]) {
+			continue
+		}
+		iface, group, probe, state := cols[0], cols[2], cols[4], cols[5]
+
+		// a failure is counted when the probes or the interface turn to
+		// failed, the interface failing over to the others of its group
+		if probe == "failed" && i.lastProbe[iface] != "failed" {
+			i.probeFailures[iface]++
+		}
+		if state == "failed" && i.lastState[iface] != "failed" {
+			i.failovers[iface]++
+		}
+		i.lastProbe[iface] = probe
+		i.lastState[iface] = state
+
+		if cols[1] == "yes" {
+			active[group]++
+		}
+		if state == "failed" {
+			failed[group]++
+		}
+		fields := map[string]interface{}{
+			"active":         cols[1] == "yes",
+			"link_up":        cols[3] == "up",
+			"probe_ok":       probe == "ok",
+			"state_code":     ipmpStateCode(state, ipmpInterfaceStates),
+			"probe_failures": i.probeFailures[iface],
+			"failovers":      i.failovers[iface],
+		}
+		tags := map[string]string{
+			"group":     group,
+			"interface": iface,
+			"state":     state,
+			"probe":     probe,
+		}
+		acc.AddFields("ipmp_interface", fields, tags, now)
+	}
+
+	for _, line := range strings.Split(string(groups), "\n") {
+		// ipmp0:degraded:10.00s:net1 [net0]
+		cols := ipmpstatFields(line)
+		if len(cols) != 4 || !i.selected(cols[0]) {
+			continue
+		}
+		group, state := cols[0], cols[1]
+		fields := map[string]interface{}{
+			"state_code":        ipmpStateCode(state, ipmpGroupStates),
+			"interfaces":        len(strings.Fields(cols[3])),
+			"interfaces_active": active[group],
+			"interfaces_failed": failed[group],
+		}
+		// the failure detection time is "--" without probe based detection
+		if fdt, err := strconv.ParseFloat(strings.TrimSuffix(cols[2], "s"), 64); err == nil {
+			fields["failure_detection_time"] = fdt
+		}
+		acc.AddFields("ipmp_group", fields, map[string]string{
+			"group": group,
+			"state": state,
+		}, now)
+	}
+	return nil
+}
+
+func (i *IPMP) selected(group string) bool {
+	return len(i.Groups) == 0 || matchesAny(group, i.Groups)
+}
+
+// ipmpStateCode returns the code of the state, the number of states for an
+// unknown one.
+func ipmpStateCode(state string, states []string) int {
+	for i, s := range states {
+		if s == state {
+			return i
+		}
+	}
+	return len(states)
+}
+
+// ipmpstatFields splits a line of the parsable output of ipmpstat, in which
+// the colons within fields, such as in IPv6 addresses, are escaped.
+func ipmpstatFields(line string) []string {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return nil
+	}
+	var fields []string
+	var field []byte
+	for i := 0; i < len(line); i++ {
+		switch {
+		case line[i] == '\\' && i+1 < len(line):
+			i++
+			field = append(field, line[i])
+		case line[i] == ':':
+			fields = append(fields, string(field))
+			field = nil
+		default:
+			field = append(field, line[i])
+		}
+	}
+	return append(fields, string(field))
+}
+
+func ipmpstatRunner(timeout time.Duration, args ...string) ([]byte, error) {
+	bin, err := exec.LookPath("ipmpstat")
+	if err != nil {
+		bin = "/usr/sbin/ipmpstat"
+	}
+	c := exec.Command(bin, args...)
+	return CombinedOutputTimeout(c, timeout)
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// KstatRunner runs kstat(1M) with the given arguments and returns its output.
+// It can be replaced with a mocked function for unit test purposes.
+type KstatRunner func(timeout time.Duration, args ...string) ([]byte, error)
+
+// Kstat emits the numeric statistics of the kstats matching a list of
+// module:instance:name selectors.
+type Kstat struct {
+	Selectors    []string
+	ExcludeStats []string `toml:"exclude_stats"`
+	Timeout      Duration
+
+	runKstat KstatRunner
+}
+
+func NewKstat() Input {
+	return &Kstat{
+		ExcludeStats: []string{"crtime", "snaptime"},
+		Timeout:      Duration{Duration: 5 * time.Second},
+		runKstat:     kstatRunner,
+	}
+}
+
+func (_ *Kstat) Description() string {
+	return "Read numeric statistics of arbitrary kstats"
+}
+
+var kstatSampleConfig = `
+  ## kstats to read, as module:instance:name selectors. Empty parts match
+  ## everything and each part may be a shell glob or a /regex/, see kstat(1M).
+  selectors = ["unix:0:system_pages", "zfs:0:arcstats"]
+  ## Statistics that are not emitted as fields
+  # exclude_stats = ["crtime", "snaptime"]
+  ## Timeout for the kstat command to complete
+  # timeout = "5s"
+`
+
+func (_ *Kstat) SampleConfig() string {
+	return kstatSampleConfig
+}
+
+// Init checks that there are selectors, as kstat would otherwise print
+// every kstat of the host.
+func (k *Kstat) Init() error {
+	if len(k.Selectors) == 0 {
+		return fmt.Errorf("no kstat selectors configured")
+	}
+	for _, selector := range k.Selectors {
+		if strings.TrimSpace(selector) == "" {
+			return fmt.Errorf("empty kstat selector")
+		}
+	}
+	return nil
+}
+
+func (k *Kstat) Gather(acc Accumulator) error {
+	entries, err := readKstat(k.runKstat, k.Timeout.Duration, k.Selectors...)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, entry := range entries {
+		fields := make(map[string]interface{}, len(entry.Stats))
+		for stat, value := range entry.Stats {
+			if sliceContains(stat, k.ExcludeStats) {
+				continue
+			}
+			if v, ok := kstatValue(value); ok {
+				fields[stat] = v
+			}
+		}
+		// the entries of text statistics only, ie, the ones of the
+		// firmware versions, make no metric
+		if len(fields) == 0 {
+			continue
+		}
+		tags := map[string]string{
+			"module":   entry.Module,
+			"instance": entry.Instance,
+			"name":     entry.Name,
+		}
+		if entry.Class != "" {
+			tags["class"] = entry.Class
+		}
+		acc.AddFields("kstat", fields, tags, now)
+	}
+	return nil
+}
+
+// kstatEntry holds the statistics of a single module:instance:name kstat.
+type kstatEntry struct {
+	Module   string
+	Instance string
+	Name     string
+	Class    string
+	Stats    map[string]string
+}
+
+// readKstat runs "kstat -p" for the given selectors and groups the parsable
+// output by kstat, in the order the kstats were printed.
+func readKstat(
+	run KstatRunner,
+	timeout time.Duration,
+	selectors ...string,
+) ([]*kstatEntry, error) {
+	if run == nil {
+		run = kstatRunner
+	}
+	out, err := run(timeout, append([]string{"-p"}, selectors...)...)
+	if err != nil {
+		return nil, fmt.Errorf("error getting kstat info: %s", err)
+	}
+	return parseKstat(string(out)), nil
+}
+
+func parseKstat(out string) []*kstatEntry {
+	var entries []*kstatEntry
+	index := make(map[string]*kstatEntry)
+	for _, line := range strings.Split(out, "\n") {
+		// module:instance:name:statistic<TAB>value
+		parts := strings.SplitN(line, "\t", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		key := parts[0]
+		value := strings.TrimSpace(parts[1])
+
+		// the name may itself contain colons, the statistic never does
+		last := strings.LastIndex(key, ":")
+		if last == -1 {
+			continue
+		}
+		ids := strings.SplitN(key[:last], ":", 3)
+		if len(ids) != 3 {
+			continue
+		}
+		stat := key[last+1:]
+
+		id := key[:last]
+		entry, ok := index[id]
+		if !ok {
+			entry = &kstatEntry{
+				Module:   ids[0],
+				Instance: ids[1],
+				Name:     ids[2],
+				Stats:    make(map[string]string),
+			}
+			index[id] = entry
+			entries = append(entries, entry)
+		}
+		if stat == "class" {
+			entry.Class = value
+			continue
+		}
+		entry.Stats[stat] = value
+	}
+	return entries
+}
+
+// kstatValue converts a kstat value to an int64, uint64 or float64 field
+// value, the counters above the int64 range being uint64, returning false for
+// non-numeric values.
+func kstatValue(value string) (interface{}, bool) {
+	if v, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return v, true
+	}
+	if v, err := strconv.ParseUint(value, 10, 64); err == nil {
+		return v, true
+	}
+	if v, err := strconv.ParseFloat(value, 64); err == nil {
+		return v, true
+	}
+	return nil, false
+}
+
+func kstatRunner(timeout time.Duration, args ...string) ([]byte, error) {
+	bin, err := exec.LookPath("kstat")
+	if err != nil {
+		return nil, err
+	}
+	c := exec.Command(bin, args...)
+	return CombinedOutputTimeout(c, timeout)
+}
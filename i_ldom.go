@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// LdmRunner runs ldm(1M) with the given arguments and returns its output.
+// It can be replaced with a mocked function for unit test purposes.
+type LdmRunner func(timeout time.Duration, args ...string) ([]byte, error)
+
+// ldomStates are the states of a logical domain, with the code of each
+// state, ordered from running to unconfigured.
+var ldomStates = []string{
+	"active",
+	"bound",
+	"inactive",
+}
+
+// LDom reports the state and the resources of every logical domain, as
+// listed by ldm(1M) on the control domain.
+type LDom struct {
+	Domains []string
+	Timeout Duration
+
+	runLdm LdmRunner
+}
+
+func NewLDom() Input {
+	return &LDom{
+		Timeout: Duration{Duration: 10 * time.Second},
+		runLdm:  ldmRunner,
+	}
+}
+
+func (_ *LDom) Description() string {
+	return "Read the state, vCPUs, memory and utilization of logical domains"
+}
+
+var ldomSampleConfig = `
+  ## Domains to report on, as globs matched against the domain name.
+  ## If empty, all domains are reported.
+  # domains = ["primary", "ldg*"]
+  ## Timeout for the ldm command to complete
+  # timeout = "10s"
+`
+
+func (_ *LDom) SampleConfig() string {
+	return ldomSampleConfig
+}
+
+// Init checks the globs of the domains.
+func (l *LDom) Init() error {
+	return checkGlobs("domains", l.Domains)
+}
+
+func (l *LDom) Gather(acc Accumulator) error {
+	run := l.runLdm
+	if run == nil {
+		run = ldmRunner
+	}
+	out, err := run(l.Timeout.Duration, "list", "-p")
+	if err != nil {
+		return fmt.Errorf("error getting logical domains: %s", err)
+	}
+
+	now := time.Now()
+	for _, line := range strings.Split(string(out), "\n") {
+		// DOMAIN|name=primary|state=active|flags=-n-cv-|cons=UART|ncpu=8|
+		// mem=8589934592|util=2.3|uptime=1234567|norm_util=2.3
+		parts := strings.Split(strings.TrimSpace(line), "|")
+		if parts[0] != "DOMAIN" {
+			continue
+		}
+		props := make(map[string]string, len(parts)-1)
+		for _, part := range parts[1:] {
+			if kv := strings.SplitN(part, "=", 2); len(kv) == 2 {
+				props[kv[0]] = kv[1]
+			}
+		}
+		name := props["name"]
+		if name == "" || len(l.Domains) != 0 && !matchesAny(name, l.Domains) {
+			continue
+		}
+
+		state := props["state"]
+		fields := map[string]interface{}{
+			"state_code": ldomStateCode(state),
+		}
+		setKstatField(fields, "vcpus", props["ncpu"])
+		setKstatField(fields, "memory_bytes", props["mem"])
+		// the utilization and uptime of the domains not active are empty
+		setKstatField(fields, "utilization", props["util"])
+		setKstatField(fields, "normalized_utilization", props["norm_util"])
+		setKstatField(fields, "uptime", props["uptime"])
+
+		tags := map[string]string{
+			"domain": name,
+			"state":  state,
+		}
+		// the fourth flag is c on the control domain
+		if flags := props["flags"]; len(flags) > 3 && flags[3] == 'c' {
+			tags["control"] = "true"
+		}
+		acc.AddGauge("ldom", fields, tags, now)
+	}
+	return nil
+}
+
+// ldomStateCode returns the code of the domain state, the number of states
+// for an unknown one.
+func ldomStateCode(state string) int {
+	for i, s := range ldomStates {
+		if s == state {
+			return i
+		}
+	}
+	return len(ldomStates)
+}
+
+func ldmRunner(timeout time.Duration, args ...string) ([]byte, error) {
+	bin, err := exec.LookPath("ldm")
+	if err != nil {
+		bin = "/opt/SUNWldm/bin/ldm"
+	}
+	c := exec.Command(bin, args...)
+	return CombinedOutputTimeout(c, timeout)
+}
@@ -8,10 +8,17 @@ import (
 	"time"
 	"strings"
 	"fmt"
+	"os"
 )
 
 type MemStats struct {
 	ps PS
+
+	runKstat KstatRunner
+
+	// pages scanned by the page scanner at the previous gather
+	lastScan uint64
+	lastTime time.Time
 }
 
 func (_ *MemStats) Description() string {
@@ -81,6 +88,46 @@ func (s *MemStats) Gather(acc Accumulator) error {
 		"used_percent":      100 * float64(total-free) / float64(total),
 	}
 
+	if err := s.gatherPages(fields, now); err != nil {
+		acc.AddError(err)
+	}
+
 	acc.AddCounter("mem", fields, nil, now)
 	return nil
 }
+
+// gatherPages adds the free memory thresholds of the page scanner, from the
+// unix:0:system_pages kstat, and its scan rate, from the cpu:*:vm kstats.
+func (s *MemStats) gatherPages(fields map[string]interface{}, now time.Time) error {
+	entries, err := readKstat(s.runKstat, 5*time.Second,
+		"unix:0:system_pages", "cpu::vm:scan")
+	if err != nil {
+		return err
+	}
+
+	pageSize := uint64(os.Getpagesize())
+	var scan uint64
+	for _, entry := range entries {
+		switch entry.Module {
+		case "unix":
+			for _, stat := range []string{"freemem", "lotsfree", "desfree", "minfree"} {
+				if pages, err := strconv.ParseUint(entry.Stats[stat], 10, 64); err == nil {
+					fields[stat+"_bytes"] = pages * pageSize
+				}
+			}
+		case "cpu":
+			pages, _ := strconv.ParseUint(entry.Stats["scan"], 10, 64)
+			scan += pages
+		}
+	}
+
+	// the scan rate, in pages per second, needs a previous gather
+	elapsed := now.Sub(s.lastTime).Seconds()
+	if !s.lastTime.IsZero() && elapsed > 0 && scan >= s.lastScan {
+		fields["scan_rate"] = float64(scan-s.lastScan) / elapsed
+	}
+	fields["pages_scanned"] = scan
+	s.lastScan = scan
+	s.lastTime = now
+	return nil
+}
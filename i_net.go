@@ -1,17 +1,39 @@
 package main
 
 import (
-	"regexp"
-	"fmt"
-	"os/exec"
-	"strings"
-	"strconv"
 	"time"
-	"log"
 )
 
+// netLinkStats maps the fields reported to the link kstat statistics they
+// are read from, the 64 bit counters being preferred when available.
+var netLinkStats = []struct {
+	field string
+	stats []string
+}{
+	{"bytes_sent", []string{"obytes64", "obytes"}},
+	{"bytes_recv", []string{"rbytes64", "rbytes"}},
+	{"packets_sent", []string{"opackets64", "opackets"}},
+	{"packets_recv", []string{"ipackets64", "ipackets"}},
+	{"err_in", []string{"ierrors"}},
+	{"err_out", []string{"oerrors"}},
+	{"drop_in", []string{"norcvbuf"}},
+	{"drop_out", []string{"noxmtbuf"}},
+}
+
+// NetIOStats reports the traffic of the datalinks from the link:*:* kstats
+// or, on systems without them, from the kstats of the network drivers.
 type NetIOStats struct {
 	Interfaces []string
+	Timeout    Duration
+
+	runKstat KstatRunner
+}
+
+func NewNetIOStats() Input {
+	return &NetIOStats{
+		Timeout:  Duration{Duration: 5 * time.Second},
+		runKstat: kstatRunner,
+	}
 }
 
 func (_ *NetIOStats) Description() string {
@@ -19,83 +41,60 @@ func (_ *NetIOStats) Description() string {
 }
 
 var netSampleConfig = `
-  ## By default, telegraf gathers stats from any up interface (excluding loopback)
-  ## Setting interfaces will tell it to gather these explicit interfaces,
-  ## regardless of status.
+  ## By default, telegraf gathers stats from every datalink.
+  ## Setting interfaces restricts it to the links matching these globs.
   ##
-  # interfaces = ["eth0"]
+  # interfaces = ["net0", "aggr*"]
+  ## Timeout for the kstat command to complete
+  # timeout = "5s"
 `
 
 func (_ *NetIOStats) SampleConfig() string {
 	return netSampleConfig
 }
 
-func (s *NetIOStats) Gather(acc Accumulator) error {
-	interfaces := map[string]string{}
+// Init checks the globs of the interfaces.
+func (s *NetIOStats) Init() error {
+	return checkGlobs("interfaces", s.Interfaces)
+}
 
-	if len(s.Interfaces) > 0 {
-		for _, value := range s.Interfaces {
-			interfaces[value] = ""
-		}
-	} else {
-		c1, err := exec.Command("ifconfig", "-a").CombinedOutput()
+func (s *NetIOStats) Gather(acc Accumulator) error {
+	entries, err := readKstat(s.runKstat, s.Timeout.Duration, "link:::")
+	if err != nil || len(entries) == 0 {
+		// systems without link kstats, ie, Solaris 10, have the counters in
+		// the driver kstats named after the interface. kstat also fails
+		// when no kstat matches.
+		entries, err = readKstat(s.runKstat, s.Timeout.Duration, "-c", "net")
 		if err != nil {
-			return fmt.Errorf("error getting NetIOStat info: %s", err.Error())
-		}
-
-		rows := strings.Split(string(c1), "\n")
-		rows = rows[0:len(rows)-1]
-
-		for _, row := range rows {
-			idx := strings.Index(row, ": ")
-			if idx > 0 {
-				interfaces[row[0:idx]] = ""
-			}
+			return err
 		}
 	}
 
-	for inet, _ := range interfaces {
-		output, err := exec.Command("kstat", "-p", fmt.Sprintf("::%s", inet)).CombinedOutput()
-		if err != nil {
-			log.Printf("D! Error getting NetIO (kstat) info: %s\n", err.Error())
+	now := time.Now()
+	for _, entry := range entries {
+		if entry.Module != "link" && entry.Name != entry.Module+entry.Instance {
+			continue
+		}
+		if len(s.Interfaces) != 0 && !matchesAny(entry.Name, s.Interfaces) {
 			continue
 		}
-		s := string(output)
-		if s != "" {
-			fields := map[string]interface{}{}
-			tags := map[string]string{
-				"interface": inet,
-			}
-			stats := strings.Split(s, "\n")
-			stats = stats[0: len(stats)-1]
-			for _, row := range stats {
-				data := strings.Fields(row)
-				reg := regexp.MustCompile(".*:.*:.*:")
-				field := reg.ReplaceAllString(data[0], "${1}")
 
-				switch field {
-				case "obytes":
-					fields["bytes_sent"], _ = strconv.ParseInt(data[1], 10, 0)
-					break
-				case "rbytes":
-					fields["bytes_recv"], _ = strconv.ParseInt(data[1], 10, 0)
-					break
-				case "opackets":
-					fields["packets_sent"], _ = strconv.ParseInt(data[1], 10, 0)
-					break
-				case "ipackets":
-					fields["packets_recv"], _ = strconv.ParseInt(data[1], 10, 0)
-					break
-				case "ierrors":
-					fields["err_in"], _ = strconv.ParseInt(data[1], 10, 0)
-					break
-				case "oerrors":
-					fields["err_out"], _ = strconv.ParseInt(data[1], 10, 0)
+		fields := make(map[string]interface{})
+		for _, ls := range netLinkStats {
+			for _, stat := range ls.stats {
+				if value, ok := entry.Stats[stat]; ok {
+					setKstatField(fields, ls.field, value)
 					break
 				}
 			}
-			acc.AddGauge("net", fields, tags, time.Now())
 		}
+		if len(fields) == 0 {
+			continue
+		}
+		tags := map[string]string{
+			"interface": entry.Name,
+		}
+		acc.AddCounter("net", fields, tags, now)
 	}
 	return nil
 }
@@ -3,20 +3,39 @@ package main
 import (
 	"bufio"
 	"errors"
+	"fmt"
 	"net"
 	"net/textproto"
 	"regexp"
+	"sync"
 	"time"
 )
 
+// Result codes of a check, reported as the result_code field and, by name,
+// as the result tag.
+const (
+	netResponseSuccess = iota
+	netResponseTimeout
+	netResponseConnectionFailed
+	netResponseReadFailed
+	netResponseStringMismatch
+)
+
+var netResponseResults = []string{
+	"success", "timeout", "connection_failed", "read_failed", "string_mismatch",
+}
+
 // NetResponses struct
 type NetResponse struct {
 	Address     string
+	Addresses   []string
 	Timeout     Duration
 	ReadTimeout Duration
 	Send        string
 	Expect      string
 	Protocol    string
+
+	expect *regexp.Regexp
 }
 
 func (_ *NetResponse) Description() string {
@@ -30,6 +49,8 @@ var netResponseSampleConfig = `
   protocol = "tcp"
   ## Server address (default localhost)
   address = "localhost:80"
+  ## Further addresses to check, in parallel, with the same settings
+  # addresses = ["db1:5432", "db2:5432"]
   ## Set timeout
   timeout = "1s"
 
@@ -43,29 +64,44 @@ var netResponseSampleConfig = `
   # send = "ssh"
   ## expected string in answer
   # expect = "ssh"
+
+  ## Every check reports its response_time and a result_code, also set by
+  ## name as the result tag:
+  ##   0 success, 1 timeout, 2 connection_failed, 3 read_failed,
+  ##   4 string_mismatch
 `
 
 func (_ *NetResponse) SampleConfig() string {
 	return netResponseSampleConfig
 }
 
-func (n *NetResponse) TcpGather() (map[string]interface{}, error) {
+// setResult sets the result_code field and the result tag of a check.
+func setResult(code int, fields map[string]interface{}, tags map[string]string) {
+	fields["result_code"] = code
+	tags["result"] = netResponseResults[code]
+}
+
+// errorResult returns the result code of a failed connection or read.
+func errorResult(err error, failed int) int {
+	if e, ok := err.(net.Error); ok && e.Timeout() {
+		return netResponseTimeout
+	}
+	return failed
+}
+
+func (n *NetResponse) TcpGather(address string, tags map[string]string) map[string]interface{} {
 	// Prepare fields
 	fields := make(map[string]interface{})
 	// Start Timer
 	start := time.Now()
 	// Connecting
-	conn, err := net.DialTimeout("tcp", n.Address, n.Timeout.Duration)
+	conn, err := net.DialTimeout("tcp", address, n.Timeout.Duration)
 	// Stop timer
 	responseTime := time.Since(start).Seconds()
 	// Handle error
 	if err != nil {
-		if e, ok := err.(net.Error); ok && e.Timeout() {
-			fields["result_type"] = 1
-		} else {
-			fields["result_type"] = 1
-		}
-		return fields, nil
+		setResult(errorResult(err, netResponseConnectionFailed), fields, tags)
+		return fields
 	}
 	defer conn.Close()
 	// Send string if needed
@@ -89,40 +125,40 @@ func (n *NetResponse) TcpGather() (map[string]interface{}, error) {
 		// Handle error
 		if err != nil {
 			fields["string_found"] = false
-			fields["result_type"] = 1
+			setResult(errorResult(err, netResponseReadFailed), fields, tags)
 		} else {
 			// Looking for string in answer
-			RegEx := regexp.MustCompile(`.*` + n.Expect + `.*`)
-			find := RegEx.FindString(string(data))
+			find := n.expect.FindString(string(data))
 			if find != "" {
-				fields["result_type"] = 0
 				fields["string_found"] = true
+				setResult(netResponseSuccess, fields, tags)
 			} else {
-				fields["result_type"] = 1
 				fields["string_found"] = false
+				setResult(netResponseStringMismatch, fields, tags)
 			}
 		}
 	} else {
-		fields["result_type"] = 0
+		setResult(netResponseSuccess, fields, tags)
 	}
 	fields["response_time"] = responseTime
-	return fields, nil
+	return fields
 }
 
-func (n *NetResponse) UdpGather() (map[string]interface{}, error) {
+func (n *NetResponse) UdpGather(address string, tags map[string]string) map[string]interface{} {
 	// Prepare fields
 	fields := make(map[string]interface{})
 	// Start Timer
 	start := time.Now()
-	// Resolving
-	udpAddr, err := net.ResolveUDPAddr("udp", n.Address)
-	LocalAddr, err := net.ResolveUDPAddr("udp", "127.0.0.1:0")
-	// Connecting
-	conn, err := net.DialUDP("udp", LocalAddr, udpAddr)
+	// Resolving and connecting
+	udpAddr, err := net.ResolveUDPAddr("udp", address)
+	var conn *net.UDPConn
+	if err == nil {
+		conn, err = net.DialUDP("udp", nil, udpAddr)
+	}
 	// Handle error
 	if err != nil {
-		fields["result_type"] = "connection_failed"
-		return fields, nil
+		setResult(netResponseConnectionFailed, fields, tags)
+		return fields
 	}
 	defer conn.Close()
 	// Send string
@@ -133,37 +169,39 @@ func (n *NetResponse) UdpGather() (map[string]interface{}, error) {
 	conn.SetReadDeadline(time.Now().Add(n.ReadTimeout.Duration))
 	// Read
 	buf := make([]byte, 1024)
-	_, _, err = conn.ReadFromUDP(buf)
+	size, _, err := conn.ReadFromUDP(buf)
 	// Stop timer
 	responseTime := time.Since(start).Seconds()
 	// Handle error
 	if err != nil {
-		fields["result_type"] = "read_failed"
-		return fields, nil
+		setResult(errorResult(err, netResponseReadFailed), fields, tags)
+		return fields
+	}
+	// Looking for string in answer
+	find := n.expect.FindString(string(buf[:size]))
+	if find != "" {
+		fields["string_found"] = true
+		setResult(netResponseSuccess, fields, tags)
 	} else {
-		// Looking for string in answer
-		RegEx := regexp.MustCompile(`.*` + n.Expect + `.*`)
-		find := RegEx.FindString(string(buf))
-		if find != "" {
-			fields["result_type"] = "success"
-			fields["string_found"] = true
-		} else {
-			fields["result_type"] = "string_mismatch"
-			fields["string_found"] = false
-		}
+		fields["string_found"] = false
+		setResult(netResponseStringMismatch, fields, tags)
 	}
 	fields["response_time"] = responseTime
-	return fields, nil
+	return fields
 }
 
-func (n *NetResponse) Gather(acc Accumulator) error {
-	// Set default values
+// Init sets the default values, checks the protocol, addresses and send and
+// expected strings, and compiles the expected string.
+func (n *NetResponse) Init() error {
 	if n.Timeout.Duration == 0 {
 		n.Timeout.Duration = time.Second
 	}
 	if n.ReadTimeout.Duration == 0 {
 		n.ReadTimeout.Duration = time.Second
 	}
+	if n.Protocol != "tcp" && n.Protocol != "udp" {
+		return errors.New("Bad protocol")
+	}
 	// Check send and expected string
 	if n.Protocol == "udp" && n.Send == "" {
 		return errors.New("Send string cannot be empty")
@@ -171,34 +209,50 @@ func (n *NetResponse) Gather(acc Accumulator) error {
 	if n.Protocol == "udp" && n.Expect == "" {
 		return errors.New("Expected string cannot be empty")
 	}
-	// Prepare host and port
-	host, port, err := net.SplitHostPort(n.Address)
-	if err != nil {
-		return err
-	}
-	if host == "" {
-		n.Address = "localhost:" + port
-	}
-	if port == "" {
-		return errors.New("Bad port")
-	}
-	// Prepare data
-	tags := map[string]string{"server": host, "port": port}
-	var fields map[string]interface{}
-	// Gather data
-	if n.Protocol == "tcp" {
-		fields, err = n.TcpGather()
-		tags["protocol"] = "tcp"
-	} else if n.Protocol == "udp" {
-		fields, err = n.UdpGather()
-		tags["protocol"] = "udp"
-	} else {
-		return errors.New("Bad protocol")
+	// Prepare hosts and ports
+	if n.Address != "" || len(n.Addresses) == 0 {
+		n.Addresses = append([]string{n.Address}, n.Addresses...)
+		n.Address = ""
 	}
-	if err != nil {
-		return err
+	for i, address := range n.Addresses {
+		host, port, err := net.SplitHostPort(address)
+		if err != nil {
+			return err
+		}
+		if port == "" {
+			return fmt.Errorf("Bad port in %q", address)
+		}
+		if host == "" {
+			n.Addresses[i] = "localhost:" + port
+		}
+	}
+	var err error
+	n.expect, err = regexp.Compile(`.*` + n.Expect + `.*`)
+	return err
+}
+
+func (n *NetResponse) Gather(acc Accumulator) error {
+	var wg sync.WaitGroup
+
+	// Check every address in its own go routine
+	for _, address := range n.Addresses {
+		wg.Add(1)
+		go func(address string) {
+			defer wg.Done()
+			host, port, _ := net.SplitHostPort(address)
+			// Prepare data
+			tags := map[string]string{"server": host, "port": port, "protocol": n.Protocol}
+			var fields map[string]interface{}
+			// Gather data
+			if n.Protocol == "tcp" {
+				fields = n.TcpGather(address, tags)
+			} else {
+				fields = n.UdpGather(address, tags)
+			}
+			// Add metrics
+			acc.AddFields("net_response", fields, tags)
+		}(address)
 	}
-	// Add metrics
-	acc.AddFields("net_response", fields, tags)
+	wg.Wait()
 	return nil
 }
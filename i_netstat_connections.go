@@ -25,10 +25,15 @@ func (_ *NetStatConnections) SampleConfig() string {
 	return netstatConnectionsSampleConfig
 }
 
-func (s *NetStatConnections) Gather(acc Accumulator) error {
+// Init checks that there are patterns to count the connections of.
+func (s *NetStatConnections) Init() error {
 	if !s.isValidConfig() {
 		return fmt.Errorf("Invalid netstat connection configuration")
 	}
+	return nil
+}
+
+func (s *NetStatConnections) Gather(acc Accumulator) error {
 	out, err := exec.Command("netstat", "-an").CombinedOutput()
 	if err != nil {
 		return fmt.Errorf("Error executing netstat request")
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// netstatProtoKstats are the kstats of the MIB of each protocol.
+var netstatProtoKstats = map[string]string{
+	"tcp":  "tcp:0:tcp",
+	"udp":  "udp:0:udp",
+	"ip":   "ip:0:ip",
+	"icmp": "icmp:0:icmp",
+}
+
+// NetstatProto reports the counters of the TCP, UDP, IP and ICMP MIBs, as
+// netstat -s does, with the TCP retransmission rate over the interval.
+type NetstatProto struct {
+	Protocols []string
+	Timeout   Duration
+
+	runKstat KstatRunner
+
+	// retransmitted and sent TCP segments at the previous gather, and the
+	// snaptime of the kstat, in seconds
+	lastTCP map[string]float64
+}
+
+func NewNetstatProto() Input {
+	return &NetstatProto{
+		Protocols: []string{"tcp", "udp", "ip"},
+		Timeout:   Duration{Duration: 5 * time.Second},
+		runKstat:  kstatRunner,
+	}
+}
+
+func (_ *NetstatProto) Description() string {
+	return "Read the TCP, UDP, IP and ICMP protocol statistics, as netstat -s"
+}
+
+var netstatProtoSampleConfig = `
+  ## Protocols reported, among tcp, udp, ip and icmp. The fields are the
+  ## counters of their MIB kstat in snake case, ie, retrans_segs,
+  ## listen_drop or attempt_fails for tcp, in_errors for udp, and
+  ## in_cksum_errs or udp_in_overflows for ip.
+  # protocols = ["tcp", "udp", "ip"]
+  ## Timeout for the kstat command to complete
+  # timeout = "5s"
+`
+
+func (_ *NetstatProto) SampleConfig() string {
+	return netstatProtoSampleConfig
+}
+
+// Init checks the protocols.
+func (n *NetstatProto) Init() error {
+	for _, proto := range n.Protocols {
+		if _, ok := netstatProtoKstats[proto]; !ok {
+			return fmt.Errorf("invalid protocol %q, expected tcp, udp, ip or icmp", proto)
+		}
+	}
+	return nil
+}
+
+func (n *NetstatProto) Gather(acc Accumulator) error {
+	selectors := make([]string, 0, len(n.Protocols))
+	for _, proto := range n.Protocols {
+		selectors = append(selectors, netstatProtoKstats[proto])
+	}
+	entries, err := readKstat(n.runKstat, n.Timeout.Duration, selectors...)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, entry := range entries {
+		fields := make(map[string]interface{}, len(entry.Stats))
+		for stat, value := range entry.Stats {
+			if stat == "crtime" || stat == "snaptime" {
+				continue
+			}
+			setKstatField(fields, SnakeCase(stat), value)
+		}
+		if entry.Module == "tcp" {
+			n.tcpRetransmits(fields, entry.Stats)
+		}
+		acc.AddCounter("netstat_proto", fields, map[string]string{
+			"protocol": entry.Module,
+		}, now)
+	}
+	return nil
+}
+
+// tcpRetransmits adds the segments retransmitted per second since the
+// previous gather, and their percent of the segments sent.
+func (n *NetstatProto) tcpRetransmits(fields map[string]interface{}, stats map[string]string) {
+	current := make(map[string]float64, 3)
+	for _, stat := range []string{"retransSegs", "outSegs", "snaptime"} {
+		v, err := strconv.ParseFloat(stats[stat], 64)
+		if err != nil {
+			return
+		}
+		current[stat] = v
+	}
+	prev := n.lastTCP
+	n.lastTCP = current
+	if prev == nil {
+		return
+	}
+
+	elapsed := current["snaptime"] - prev["snaptime"]
+	retrans := current["retransSegs"] - prev["retransSegs"]
+	sent := current["outSegs"] - prev["outSegs"]
+	if elapsed <= 0 || retrans < 0 || sent < 0 {
+		return
+	}
+	fields["retrans_segs_rate"] = retrans / elapsed
+	if sent > 0 {
+		fields["retrans_percent"] = 100 * retrans / sent
+	}
+}
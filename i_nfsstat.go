@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// NFSStat reports the NFS operations handled by the client and the server,
+// by protocol version, from the nfs:*:rfsreqcnt_v* and nfs:*:rfsproccnt_v*
+// kstats, and the RPC calls and errors of both roles from the
+// unix:0:rpc_* kstats.
+type NFSStat struct {
+	Client   bool
+	Server   bool
+	Versions []int
+	Timeout  Duration
+
+	runKstat KstatRunner
+
+	// rpc calls and bad calls at the previous gather, by role and transport
+	lastCalls map[string][2]uint64
+}
+
+func NewNFSStat() Input {
+	return &NFSStat{
+		Client:   true,
+		Server:   true,
+		Versions: []int{3, 4},
+		Timeout:  Duration{Duration: 5 * time.Second},
+		runKstat: kstatRunner,
+	}
+}
+
+func (_ *NFSStat) Description() string {
+	return "Read NFS client and server operation counts and RPC errors"
+}
+
+var nfsstatSampleConfig = `
+  ## Report the operations sent as an NFS client and handled as a server
+  # client = true
+  # server = true
+  ## NFS protocol versions to report
+  # versions = [3, 4]
+  ## Timeout for the kstat command to complete
+  # timeout = "5s"
+`
+
+func (_ *NFSStat) SampleConfig() string {
+	return nfsstatSampleConfig
+}
+
+// Init checks the versions, the kstats only existing for versions 2 to 4.
+func (n *NFSStat) Init() error {
+	if (n.Client || n.Server) && len(n.Versions) == 0 {
+		return fmt.Errorf("no NFS versions configured")
+	}
+	for _, v := range n.Versions {
+		if v < 2 || v > 4 {
+			return fmt.Errorf("invalid NFS version %d, expected 2, 3 or 4", v)
+		}
+	}
+	return nil
+}
+
+func (n *NFSStat) Gather(acc Accumulator) error {
+	var kstats []string
+	var roles []string
+	if n.Client {
+		kstats = append(kstats, "reqcnt")
+		roles = append(roles, "client")
+	}
+	if n.Server {
+		kstats = append(kstats, "proccnt")
+		roles = append(roles, "server")
+	}
+	if len(roles) == 0 {
+		return nil
+	}
+	var versions []string
+	for _, v := range n.Versions {
+		versions = append(versions, strconv.Itoa(v))
+	}
+
+	entries, err := readKstat(n.runKstat, n.Timeout.Duration,
+		"nfs::/^rfs("+strings.Join(kstats, "|")+")_v("+
+			strings.Join(versions, "|")+")$/",
+		"unix:0:/^rpc_(clts|cots)_("+strings.Join(roles, "|")+")$/")
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	calls := make(map[string][2]uint64)
+	for _, entry := range entries {
+		switch entry.Module {
+		case "nfs":
+			// rfsreqcnt_v3 or rfsproccnt_v4
+			parts := strings.SplitN(strings.TrimPrefix(entry.Name, "rfs"), "_v", 2)
+			if len(parts) != 2 {
+				continue
+			}
+			role := "client"
+			if parts[0] == "proccnt" {
+				role = "server"
+			}
+			fields := nfsstatFields(entry.Stats)
+			if len(fields) == 0 {
+				continue
+			}
+			tags := map[string]string{
+				"role":    role,
+				"version": parts[1],
+			}
+			if entry.Instance != "0" {
+				tags["zone_id"] = entry.Instance
+			}
+			acc.AddCounter("nfsstat", fields, tags, now)
+
+		case "unix":
+			// rpc_cots_client or rpc_clts_server
+			parts := strings.Split(entry.Name, "_")
+			if len(parts) != 3 {
+				continue
+			}
+			fields := nfsstatFields(entry.Stats)
+			total, _ := strconv.ParseUint(entry.Stats["calls"], 10, 64)
+			bad, _ := strconv.ParseUint(entry.Stats["badcalls"], 10, 64)
+			calls[entry.Name] = [2]uint64{total, bad}
+
+			// the error rate over the interval needs a previous gather
+			if last, ok := n.lastCalls[entry.Name]; ok &&
+				total > last[0] && bad >= last[1] {
+				fields["badcalls_percent"] =
+					100 * float64(bad-last[1]) / float64(total-last[0])
+			}
+			tags := map[string]string{
+				"role":      parts[2],
+				"transport": parts[1],
+			}
+			acc.AddCounter("nfsstat_rpc", fields, tags, now)
+		}
+	}
+	n.lastCalls = calls
+	return nil
+}
+
+func nfsstatFields(stats map[string]string) map[string]interface{} {
+	fields := make(map[string]interface{}, len(stats))
+	for stat, value := range stats {
+		if stat == "crtime" || stat == "snaptime" {
+			continue
+		}
+		setKstatField(fields, stat, value)
+	}
+	return fields
+}
@@ -0,0 +1,547 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OTLPReceiver accepts metrics pushed by OpenTelemetry SDKs and collectors
+// with the OTLP protocol, over HTTP with protobuf payloads, or over gRPC when
+// TLS is configured, as gRPC requires HTTP/2. The server runs as a service
+// and the metrics received in between are added on each gather.
+//
+// Each OTLP metric becomes a measurement of the same name, with the resource
+// and data point attributes as tags. Gauges have a "gauge" field and sums a
+// "counter" field, only the cumulative monotonic sums being counters, the
+// others gauges. Histograms and summaries have "count" and "sum" fields and
+// a metric per bucket, tagged by "le", or per quantile, tagged by "quantile".
+type OTLPReceiver struct {
+	ServiceAddress string `toml:"service_address"`
+	MaxBodySize    int64  `toml:"max_body_size"`
+	MaxPending     int    `toml:"max_pending"`
+	TLSCert        string `toml:"tls_cert"`
+	TLSKey         string `toml:"tls_key"`
+
+	binding *LocalBinding
+
+	mu      sync.Mutex
+	pending []*otlpReceived
+	server  *http.Server
+	// the error the server stopped on, returned by the next Gather so that
+	// the agent restarts the receiver
+	failed error
+
+	// counts metrics received while max_pending metrics were waiting
+	droppedOverflow Stat
+}
+
+type otlpReceived struct {
+	measurement string
+	fields      map[string]interface{}
+	tags        map[string]string
+	mType       ValueType
+	t           time.Time
+}
+
+func NewOTLPReceiver() Input {
+	return &OTLPReceiver{
+		ServiceAddress:  ":4318",
+		MaxBodySize:     32 * 1024 * 1024,
+		MaxPending:      100000,
+		droppedOverflow: RegisterDropped("receive", "inputs.otlp", "overflow"),
+	}
+}
+
+func (_ *OTLPReceiver) Description() string {
+	return "Receive metrics from OpenTelemetry SDKs over OTLP"
+}
+
+var otlpReceiverSampleConfig = `
+  ## Address to listen on for OTLP/HTTP requests to /v1/metrics
+  # service_address = ":4318"
+  ## Largest request body accepted, in bytes
+  # max_body_size = 33554432
+  ## Metrics kept between two gathers, further metrics are dropped
+  # max_pending = 100000
+
+  ## Serve over TLS, which also enables gRPC on the same address
+  # tls_cert = "/etc/telegraf/cert.pem"
+  # tls_key = "/etc/telegraf/key.pem"
+`
+
+func (_ *OTLPReceiver) SampleConfig() string {
+	return otlpReceiverSampleConfig
+}
+
+func (o *OTLPReceiver) Gather(acc Accumulator) error {
+	o.mu.Lock()
+	pending := o.pending
+	o.pending = nil
+	failed := o.failed
+	o.mu.Unlock()
+
+	for _, r := range pending {
+		switch r.mType {
+		case Counter:
+			acc.AddCounter(r.measurement, r.fields, r.tags, r.t)
+		case Histogram:
+			acc.AddHistogram(r.measurement, r.fields, r.tags, r.t)
+		case Summary:
+			acc.AddSummary(r.measurement, r.fields, r.tags, r.t)
+		default:
+			acc.AddGauge(r.measurement, r.fields, r.tags, r.t)
+		}
+	}
+	return failed
+}
+
+// SetLocalBinding listens on the local address instead of the host of the
+// service_address.
+func (o *OTLPReceiver) SetLocalBinding(b *LocalBinding) {
+	o.binding = b
+}
+
+func (o *OTLPReceiver) Start(_ Accumulator) error {
+	tlsConfig, err := GetServerTLSConfig(o.TLSCert, o.TLSKey)
+	if err != nil {
+		return err
+	}
+	address, err := o.binding.ListenAddress("tcp", o.ServiceAddress)
+	if err != nil {
+		return fmt.Errorf("error listening on %s: %s", o.ServiceAddress, err)
+	}
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("error listening on %s: %s", o.ServiceAddress, err)
+	}
+	o.server = &http.Server{Handler: o}
+	if tlsConfig != nil {
+		l = tls.NewListener(l, tlsConfig)
+	}
+	o.mu.Lock()
+	o.failed = nil
+	o.mu.Unlock()
+	go o.serve(o.server, l)
+	log.Printf("I! Started the OTLP receiver on %s", l.Addr())
+	return nil
+}
+
+// serve serves the listener, recording the error the server stopped on
+// unless closed by Stop.
+func (o *OTLPReceiver) serve(server *http.Server, l net.Listener) {
+	if err := server.Serve(l); err != http.ErrServerClosed {
+		log.Printf("E! [inputs.otlp] %s", err)
+		o.mu.Lock()
+		o.failed = err
+		o.mu.Unlock()
+	}
+}
+
+func (o *OTLPReceiver) Stop() {
+	if o.server != nil {
+		o.server.Close()
+		o.server = nil
+	}
+}
+
+func (o *OTLPReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		o.serveGRPC(w, r)
+		return
+	}
+
+	if r.URL.Path != otlpHTTPPath {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if ct := r.Header.Get("Content-Type"); ct != "application/x-protobuf" {
+		http.Error(w, "unsupported content type "+ct,
+			http.StatusUnsupportedMediaType)
+		return
+	}
+
+	var body io.Reader = http.MaxBytesReader(w, r.Body, o.MaxBodySize)
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer gz.Close()
+		body = io.LimitReader(gz, o.MaxBodySize)
+	}
+	payload, err := ioutil.ReadAll(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := o.receive(payload); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// an empty ExportMetricsServiceResponse
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (o *OTLPReceiver) serveGRPC(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+	status, message := "0", ""
+	defer func() {
+		w.Header().Set("Grpc-Status", status)
+		w.Header().Set("Grpc-Message", message)
+	}()
+
+	if r.URL.Path != otlpGRPCPath {
+		status, message = "12", "unimplemented method "+r.URL.Path
+		return
+	}
+
+	var header [5]byte
+	body := http.MaxBytesReader(w, r.Body, o.MaxBodySize)
+	if _, err := io.ReadFull(body, header[:]); err != nil {
+		status, message = grpcInvalidArgument, err.Error()
+		return
+	}
+	// the length of the message is checked before allocating it, the body
+	// not being read yet for MaxBytesReader to limit it
+	length := int64(binary.BigEndian.Uint32(header[1:]))
+	if length > o.MaxBodySize {
+		status, message = grpcResourceExhausted, fmt.Sprintf("message of %d "+
+			"bytes larger than the max_body_size of %d", length, o.MaxBodySize)
+		return
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(io.LimitReader(body, length), payload); err != nil {
+		status, message = grpcInvalidArgument, err.Error()
+		return
+	}
+	if header[0] == 1 {
+		gz, err := gzip.NewReader(bytes.NewReader(payload))
+		if err == nil {
+			payload, err = ioutil.ReadAll(io.LimitReader(gz, o.MaxBodySize))
+		}
+		if err != nil {
+			status, message = grpcInvalidArgument, err.Error()
+			return
+		}
+	}
+
+	if err := o.receive(payload); err != nil {
+		status, message = grpcInvalidArgument, err.Error()
+		return
+	}
+	// an empty ExportMetricsServiceResponse
+	w.Write([]byte{0, 0, 0, 0, 0})
+}
+
+// receive decodes an ExportMetricsServiceRequest and queues its metrics.
+func (o *OTLPReceiver) receive(payload []byte) error {
+	var received []*otlpReceived
+	err := ReadProto(payload, func(f ProtoField) error {
+		if f.Number != 1 {
+			return nil
+		}
+		metrics, err := decodeOTLPResourceMetrics(f.Data)
+		received = append(received, metrics...)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if room := o.MaxPending - len(o.pending); len(received) > room {
+		if room < 0 {
+			room = 0
+		}
+		o.droppedOverflow.Incr(int64(len(received) - room))
+		received = received[:room]
+	}
+	o.pending = append(o.pending, received...)
+	return nil
+}
+
+func decodeOTLPResourceMetrics(b []byte) ([]*otlpReceived, error) {
+	resource := make(map[string]string)
+	var scopes [][]byte
+	err := ReadProto(b, func(f ProtoField) error {
+		switch f.Number {
+		case 1:
+			return ReadProto(f.Data, func(f ProtoField) error {
+				if f.Number == 1 {
+					return decodeOTLPAttribute(f.Data, resource)
+				}
+				return nil
+			})
+		case 2:
+			scopes = append(scopes, f.Data)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var received []*otlpReceived
+	for _, scope := range scopes {
+		err := ReadProto(scope, func(f ProtoField) error {
+			if f.Number != 2 {
+				return nil
+			}
+			metrics, err := decodeOTLPMetric(f.Data, resource)
+			received = append(received, metrics...)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return received, nil
+}
+
+func decodeOTLPMetric(
+	b []byte,
+	resource map[string]string,
+) ([]*otlpReceived, error) {
+	var name string
+	var kind int
+	var data []byte
+	err := ReadProto(b, func(f ProtoField) error {
+		switch f.Number {
+		case 1:
+			name = f.String()
+		case 5, 7, 9, 10, 11:
+			kind, data = f.Number, f.Data
+		}
+		return nil
+	})
+	if err != nil || data == nil {
+		return nil, err
+	}
+
+	var received []*otlpReceived
+	monotonic, cumulative := false, false
+	err = ReadProto(data, func(f ProtoField) error {
+		switch {
+		case f.Number == 2 && kind == 7:
+			cumulative = f.Uint64 == 2
+		case f.Number == 3 && kind == 7:
+			monotonic = f.Uint64 != 0
+		case f.Number == 1:
+			points, err := decodeOTLPPoint(f.Data, kind, name, resource)
+			received = append(received, points...)
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// non monotonic sums go up and down as gauges do, and the delta sums
+	// are the increases over their interval, not counters
+	if kind == 7 && monotonic && cumulative {
+		for _, r := range received {
+			r.mType = Counter
+		}
+	}
+	return received, nil
+}
+
+// decodeOTLPPoint decodes a data point of a metric of the given kind, the
+// number of the field holding it in the Metric message.
+func decodeOTLPPoint(
+	b []byte,
+	kind int,
+	name string,
+	resource map[string]string,
+) ([]*otlpReceived, error) {
+	tags := make(map[string]string, len(resource))
+	for k, v := range resource {
+		tags[k] = v
+	}
+	// exponential histograms are the only points with attributes first
+	attrField := 7
+	switch kind {
+	case 9:
+		attrField = 9
+	case 10:
+		attrField = 1
+	}
+
+	var t time.Time
+	fields := make(map[string]interface{})
+	var counts []uint64
+	var bounds []uint64
+	var quantiles [][]byte
+	err := ReadProto(b, func(f ProtoField) error {
+		switch {
+		case f.Number == attrField && f.WireType == protoBytes:
+			return decodeOTLPAttribute(f.Data, tags)
+		case f.Number == 3:
+			if f.Uint64 != 0 {
+				t = time.Unix(0, int64(f.Uint64))
+			}
+		case kind == 5 || kind == 7:
+			field := "gauge"
+			if kind == 7 {
+				field = "counter"
+			}
+			switch f.Number {
+			case 4:
+				fields[field] = f.Double()
+			case 6:
+				fields[field] = int64(f.Uint64)
+			}
+		case f.Number == 4:
+			fields["count"] = f.Uint64
+		case f.Number == 5:
+			fields["sum"] = f.Double()
+		case kind == 9 && f.Number == 6:
+			counts = append(counts, f.Fixed64s()...)
+		case kind == 9 && f.Number == 7:
+			bounds = append(bounds, f.Fixed64s()...)
+		case kind == 9 && f.Number == 11:
+			fields["min"] = f.Double()
+		case kind == 9 && f.Number == 12:
+			fields["max"] = f.Double()
+		case kind == 10 && f.Number == 12:
+			fields["min"] = f.Double()
+		case kind == 10 && f.Number == 13:
+			fields["max"] = f.Double()
+		case kind == 11 && f.Number == 6:
+			quantiles = append(quantiles, f.Data)
+		}
+		return nil
+	})
+	if err != nil || len(fields) == 0 {
+		return nil, err
+	}
+	if t.IsZero() {
+		t = time.Now()
+	}
+
+	mType := Gauge
+	switch kind {
+	case 9, 10:
+		mType = Histogram
+	case 11:
+		mType = Summary
+	}
+	received := []*otlpReceived{{
+		measurement: name,
+		fields:      fields,
+		tags:        tags,
+		mType:       mType,
+		t:           t,
+	}}
+
+	// bucket counts are cumulated, as with the histogram aggregator
+	var cumulated uint64
+	for i, count := range counts {
+		cumulated += count
+		le := bucketInf
+		if i < len(bounds) {
+			le = strconv.FormatFloat(
+				math.Float64frombits(bounds[i]), 'f', -1, 64)
+		}
+		received = append(received, &otlpReceived{
+			measurement: name,
+			fields:      map[string]interface{}{"bucket": cumulated},
+			tags:        otlpTagsWith(tags, bucketTag, le),
+			mType:       Histogram,
+			t:           t,
+		})
+	}
+
+	for _, q := range quantiles {
+		var quantile, value float64
+		err := ReadProto(q, func(f ProtoField) error {
+			switch f.Number {
+			case 1:
+				quantile = f.Double()
+			case 2:
+				value = f.Double()
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		received = append(received, &otlpReceived{
+			measurement: name,
+			fields:      map[string]interface{}{"value": value},
+			tags: otlpTagsWith(tags, "quantile",
+				strconv.FormatFloat(quantile, 'f', -1, 64)),
+			mType: Summary,
+			t:     t,
+		})
+	}
+	return received, nil
+}
+
+// decodeOTLPAttribute decodes a KeyValue into tags. Array, key-value list
+// and bytes values have no tag representation and are left out.
+func decodeOTLPAttribute(b []byte, tags map[string]string) error {
+	var key string
+	var value string
+	var ok bool
+	err := ReadProto(b, func(f ProtoField) error {
+		switch f.Number {
+		case 1:
+			key = f.String()
+		case 2:
+			return ReadProto(f.Data, func(f ProtoField) error {
+				ok = true
+				switch f.Number {
+				case 1:
+					value = f.String()
+				case 2:
+					value = strconv.FormatBool(f.Uint64 != 0)
+				case 3:
+					value = strconv.FormatInt(int64(f.Uint64), 10)
+				case 4:
+					value = strconv.FormatFloat(f.Double(), 'f', -1, 64)
+				default:
+					ok = false
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	if err == nil && ok && key != "" {
+		tags[key] = value
+	}
+	return err
+}
+
+func otlpTagsWith(tags map[string]string, key, value string) map[string]string {
+	t := make(map[string]string, len(tags)+1)
+	for k, v := range tags {
+		t[k] = v
+	}
+	t[key] = value
+	return t
+}
@@ -36,6 +36,10 @@ type Ping struct {
 	// URLs to ping
 	Urls []string
 
+	// Method is "exec" to run the ping command, or "native" to send the
+	// echo requests from a raw socket
+	Method string
+
 	// host ping function
 	pingHost HostPinger
 }
@@ -56,14 +60,27 @@ const pingSampleConfig = `
   # ping_interval = 1.0
   ## per-ping timeout, in s. 0 == no timeout (ping -W <TIMEOUT>)
   # timeout = 1.0
-  ## interface to send ping from (ping -I <INTERFACE>)
+  ## interface to send ping from (ping -I <INTERFACE>), exec method only
   # interface = ""
+
+  ## "exec" runs the ping command, "native" sends the echo requests from a
+  ## raw socket, which requires root or the net_icmpaccess privilege, ie:
+  ##   usermod -K defaultpriv=basic,net_icmpaccess telegraf
+  # method = "exec"
 `
 
 func (_ *Ping) SampleConfig() string {
 	return pingSampleConfig
 }
 
+func (p *Ping) Init() error {
+	switch p.Method {
+	case "", "exec", "native":
+		return nil
+	}
+	return fmt.Errorf("invalid method %q, expected exec or native", p.Method)
+}
+
 func (p *Ping) Gather(acc Accumulator) error {
 
 	var wg sync.WaitGroup
@@ -78,12 +95,24 @@ func (p *Ping) Gather(acc Accumulator) error {
 
 			_, err := net.LookupHost(u)
 			if err != nil {
-				acc.AddError(err)
+				acc.AddError(NewGatherError(err, "url", u))
 				fields["result_code"] = 1
 				acc.AddFields("ping", fields, tags)
 				return
 			}
 
+			if p.Method == "native" {
+				trans, rec, min, avg, max, stddev, err := p.pingNative(u)
+				if err != nil {
+					acc.AddError(NewGatherError(err, "url", u))
+					acc.AddFields("ping", fields, tags)
+					return
+				}
+				p.addStats(fields, trans, rec, min, avg, max, stddev)
+				acc.AddFields("ping", fields, tags)
+				return
+			}
+
 			args := p.args(u)
 			totalTimeout := float64(p.Count)*p.Timeout + float64(p.Count-1)*p.PingInterval
 
@@ -103,10 +132,9 @@ func (p *Ping) Gather(acc Accumulator) error {
 					// Combine go err + stderr output
 					out = strings.TrimSpace(out)
 					if len(out) > 0 {
-						acc.AddError(fmt.Errorf("%s, %s", out, err))
-					} else {
-						acc.AddError(err)
+						err = fmt.Errorf("%s, %s", out, err)
 					}
+					acc.AddError(NewGatherError(err, "url", u))
 					acc.AddFields("ping", fields, tags)
 					return
 				}
@@ -115,27 +143,11 @@ func (p *Ping) Gather(acc Accumulator) error {
 			trans, rec, min, avg, max, stddev, err := processPingOutput(out)
 			if err != nil {
 				// fatal error
-				acc.AddError(fmt.Errorf("%s: %s", err, u))
+				acc.AddError(NewGatherError(err, "url", u))
 				acc.AddFields("ping", fields, tags)
 				return
 			}
-			// Calculate packet loss percentage
-			loss := float64(trans-rec) / float64(trans) * 100.0
-			fields["packets_transmitted"] = trans
-			fields["packets_received"] = rec
-			fields["percent_packet_loss"] = loss
-			if min >= 0 {
-				fields["minimum_response_ms"] = min
-			}
-			if avg >= 0 {
-				fields["average_response_ms"] = avg
-			}
-			if max >= 0 {
-				fields["maximum_response_ms"] = max
-			}
-			if stddev >= 0 {
-				fields["standard_deviation_ms"] = stddev
-			}
+			p.addStats(fields, trans, rec, min, avg, max, stddev)
 			acc.AddFields("ping", fields, tags)
 		}(url)
 	}
@@ -145,6 +157,35 @@ func (p *Ping) Gather(acc Accumulator) error {
 	return nil
 }
 
+// addStats adds the statistics of the pings to the fields, those which are
+// negative being unknown.
+func (p *Ping) addStats(
+	fields map[string]interface{},
+	trans, rec int,
+	min, avg, max, stddev float64,
+) {
+	// Calculate packet loss percentage
+	loss := 100.0
+	if trans > 0 {
+		loss = float64(trans-rec) / float64(trans) * 100.0
+	}
+	fields["packets_transmitted"] = trans
+	fields["packets_received"] = rec
+	fields["percent_packet_loss"] = loss
+	if min >= 0 {
+		fields["minimum_response_ms"] = min
+	}
+	if avg >= 0 {
+		fields["average_response_ms"] = avg
+	}
+	if max >= 0 {
+		fields["maximum_response_ms"] = max
+	}
+	if stddev >= 0 {
+		fields["standard_deviation_ms"] = stddev
+	}
+}
+
 func hostPinger(timeout float64, args ...string) (string, error) {
 	bin, err := exec.LookPath("ping")
 	if err != nil {
@@ -158,6 +199,10 @@ func hostPinger(timeout float64, args ...string) (string, error) {
 
 // args returns the arguments for the 'ping' executable
 func (p *Ping) args(url string) []string {
+	if runtime.GOOS == "solaris" || runtime.GOOS == "illumos" {
+		return p.solarisArgs(url)
+	}
+
 	// Build the ping command args based on toml config
 	args := []string{"-c", strconv.Itoa(p.Count), "-n", "-s", "16"}
 	if p.PingInterval > 0 {
@@ -181,6 +226,21 @@ func (p *Ping) args(url string) []string {
 	return args
 }
 
+// solarisArgs returns the arguments of the ping of Solaris, which takes the
+// data size and count after the host, and has no per-ping timeout:
+//
+//     ping -s -n [-I interval] [-i interface] host 16 count
+func (p *Ping) solarisArgs(url string) []string {
+	args := []string{"-s", "-n"}
+	if p.PingInterval > 0 {
+		args = append(args, "-I", strconv.FormatFloat(p.PingInterval, 'f', 1, 64))
+	}
+	if p.Interface != "" {
+		args = append(args, "-i", p.Interface)
+	}
+	return append(args, url, "16", strconv.Itoa(p.Count))
+}
+
 // processPingOutput takes in a string output from the ping command, like:
 //
 //     PING www.google.com (173.194.115.84): 56 data bytes
@@ -191,6 +251,10 @@ func (p *Ping) args(url string) []string {
 //     2 packets transmitted, 2 packets received, 0.0% packet loss
 //     round-trip min/avg/max/stddev = 34.843/43.508/52.172/8.664 ms
 //
+// or the output of the ping of Solaris, whose round trip line reads:
+//
+//     round-trip (ms)  min/avg/max/stddev = 0.041/0.052/0.070/0.016
+//
 // It returns (<transmitted packets>, <received packets>, <average response>)
 func processPingOutput(out string) (int, int, float64, float64, float64, float64, error) {
 	var trans, recv int
@@ -213,7 +277,12 @@ func processPingOutput(out string) (int, int, float64, float64, float64, float64
 				return trans, recv, min, avg, max, stddev, err
 			}
 		} else if strings.Contains(line, "min/avg/max") {
-			stats := strings.Split(line, " ")[3]
+			// the values follow the equal sign
+			eq := strings.Index(line, "=")
+			if eq == -1 {
+				continue
+			}
+			stats := strings.Fields(line[eq+1:])[0]
 			min, err = strconv.ParseFloat(strings.Split(stats, "/")[0], 64)
 			if err != nil {
 				return trans, recv, min, avg, max, stddev, err
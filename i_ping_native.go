@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/binary"
+	"math"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// pingID tells apart the echo requests of the hosts pinged at the same time,
+// as every raw ICMP socket receives the replies to all of them.
+var pingID uint32
+
+// pingNative sends the echo requests from a raw ICMP socket, which requires
+// root or the net_icmpaccess privilege, and returns the same statistics as
+// processPingOutput. Replies are awaited for the timeout after the last
+// request, 1s if not set.
+func (p *Ping) pingNative(host string) (int, int, float64, float64, float64, float64, error) {
+	var min, avg, max, stddev float64 = -1.0, -1.0, -1.0, -1.0
+
+	addr, err := net.ResolveIPAddr("ip", host)
+	if err != nil {
+		return 0, 0, min, avg, max, stddev, err
+	}
+	network, echo, reply := "ip4:icmp", byte(8), byte(0)
+	if addr.IP.To4() == nil {
+		network, echo, reply = "ip6:ipv6-icmp", byte(128), byte(129)
+	}
+	conn, err := net.ListenPacket(network, "")
+	if err != nil {
+		return 0, 0, min, avg, max, stddev, err
+	}
+	defer conn.Close()
+
+	id := uint16(atomic.AddUint32(&pingID, 1))
+	count := p.Count
+	if count < 1 {
+		count = 1
+	}
+
+	var mu sync.Mutex
+	sent := make(map[uint16]time.Time)
+	var rtts []float64
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		buf := make([]byte, 1500)
+		for {
+			n, from, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			received := time.Now()
+			if n < 8 || buf[0] != reply || binary.BigEndian.Uint16(buf[4:]) != id {
+				continue
+			}
+			if ip, ok := from.(*net.IPAddr); !ok || !ip.IP.Equal(addr.IP) {
+				continue
+			}
+			seq := binary.BigEndian.Uint16(buf[6:])
+			mu.Lock()
+			if t, ok := sent[seq]; ok {
+				rtts = append(rtts, float64(received.Sub(t))/float64(time.Millisecond))
+				delete(sent, seq)
+			}
+			mu.Unlock()
+		}
+	}()
+
+	trans := 0
+	for seq := 0; seq < count; seq++ {
+		if seq > 0 && p.PingInterval > 0 {
+			time.Sleep(time.Duration(p.PingInterval * float64(time.Second)))
+		}
+		msg := pingEchoRequest(echo, id, uint16(seq))
+		mu.Lock()
+		sent[uint16(seq)] = time.Now()
+		mu.Unlock()
+		if _, err := conn.WriteTo(msg, addr); err != nil {
+			conn.Close()
+			<-done
+			return trans, 0, min, avg, max, stddev, err
+		}
+		trans++
+	}
+
+	timeout := p.Timeout
+	if timeout <= 0 {
+		timeout = 1.0
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Duration(timeout * float64(time.Second))))
+	<-done
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(rtts) == 0 {
+		return trans, 0, min, avg, max, stddev, nil
+	}
+	min, max = rtts[0], rtts[0]
+	var sum float64
+	for _, rtt := range rtts {
+		min = math.Min(min, rtt)
+		max = math.Max(max, rtt)
+		sum += rtt
+	}
+	avg = sum / float64(len(rtts))
+	var squares float64
+	for _, rtt := range rtts {
+		squares += (rtt - avg) * (rtt - avg)
+	}
+	stddev = math.Sqrt(squares / float64(len(rtts)))
+	return trans, len(rtts), min, avg, max, stddev, nil
+}
+
+// pingEchoRequest builds an ICMP echo request with 16 bytes of data, as the
+// ping command is run with. The kernel computes the checksum of ICMPv6
+// messages.
+func pingEchoRequest(echo byte, id, seq uint16) []byte {
+	msg := make([]byte, 8+16)
+	msg[0] = echo
+	binary.BigEndian.PutUint16(msg[4:], id)
+	binary.BigEndian.PutUint16(msg[6:], seq)
+	binary.BigEndian.PutUint64(msg[8:], uint64(time.Now().UnixNano()))
+
+	if echo == 8 {
+		var sum uint32
+		for i := 0; i < len(msg); i += 2 {
+			sum += uint32(msg[i])<<8 | uint32(msg[i+1])
+		}
+		sum = sum>>16 + sum&0xffff
+		sum += sum >> 16
+		binary.BigEndian.PutUint16(msg[2:], ^uint16(sum))
+	}
+	return msg
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PkgRunner runs beadm(1M) or pkg(1), given as the command, with the given
+// arguments and returns its output. It can be replaced with a mocked
+// function for unit test purposes.
+type PkgRunner func(timeout time.Duration, command string, args ...string) ([]byte, error)
+
+// Pkg reports the boot environments, the package updates available and the
+// time since the last update of the system, to track the patch currency of
+// the hosts.
+type Pkg struct {
+	CheckUpdates        bool     `toml:"check_updates"`
+	UpdateCheckInterval Duration `toml:"update_check_interval"`
+	Timeout             Duration
+
+	runPkg PkgRunner
+
+	// packages with a newer version available at the last check, and when
+	// it was
+	updates int
+	checked time.Time
+}
+
+func NewPkg() Input {
+	return &Pkg{
+		CheckUpdates:        true,
+		UpdateCheckInterval: Duration{Duration: 6 * time.Hour},
+		Timeout:             Duration{Duration: 2 * time.Minute},
+		runPkg:              pkgRunner,
+	}
+}
+
+func (_ *Pkg) Description() string {
+	return "Read the boot environments and the package updates available"
+}
+
+var pkgSampleConfig = `
+  ## Count the packages with a newer version available, with pkg list -u,
+  ## which queries the publishers, so it is only run every
+  ## update_check_interval.
+  # check_updates = true
+  # update_check_interval = "6h"
+  ## Timeout for each beadm and pkg command to complete
+  # timeout = "2m"
+`
+
+func (_ *Pkg) SampleConfig() string {
+	return pkgSampleConfig
+}
+
+func (p *Pkg) Gather(acc Accumulator) error {
+	run := p.runPkg
+	if run == nil {
+		run = pkgRunner
+	}
+	now := time.Now()
+	fields := make(map[string]interface{})
+	tags := make(map[string]string)
+
+	if err := p.gatherBootEnvironments(run, acc, fields, tags, now); err != nil {
+		acc.AddError(err)
+	}
+
+	if p.CheckUpdates {
+		if time.Since(p.checked) >= p.UpdateCheckInterval.Duration {
+			if err := p.checkUpdates(run); err != nil {
+				acc.AddError(err)
+			}
+		}
+		if !p.checked.IsZero() {
+			fields["updates_available"] = p.updates
+		}
+	}
+
+	if last, err := p.lastUpdate(run); err != nil {
+		acc.AddError(err)
+	} else if !last.IsZero() {
+		fields["last_update"] = last.Unix()
+		fields["days_since_update"] = now.Sub(last).Hours() / 24
+	}
+
+	if len(fields) != 0 {
+		acc.AddFields("pkg", fields, tags, now)
+	}
+	return nil
+}
+
+// gatherBootEnvironments reports each boot environment listed by beadm list
+// -H, with its name, uuid, flags, mount point, space, policy and creation
+// time separated by semicolons, N flagging the active one and R the one
+// active on reboot:
+//
+//	solaris-1;8e2e5f5e-...;NR;/;4.56G;static;1396878203
+//
+// and adds the number of boot environments and the active one to the
+// fields and tags of the pkg measurement.
+func (p *Pkg) gatherBootEnvironments(
+	run PkgRunner,
+	acc Accumulator,
+	fields map[string]interface{},
+	tags map[string]string,
+	now time.Time,
+) error {
+	out, err := run(p.Timeout.Duration, "beadm", "list", "-H")
+	if err != nil {
+		return fmt.Errorf("error running beadm: %s: %s", err,
+			strings.TrimSpace(string(out)))
+	}
+
+	count := 0
+	for _, line := range strings.Split(string(out), "\n") {
+		cols := strings.Split(strings.TrimSpace(line), ";")
+		if len(cols) < 7 {
+			continue
+		}
+		count++
+		active := strings.Contains(cols[2], "N")
+		onReboot := strings.Contains(cols[2], "R")
+		if active {
+			tags["active_be"] = cols[0]
+		}
+		if onReboot {
+			tags["reboot_be"] = cols[0]
+		}
+
+		beFields := map[string]interface{}{
+			"active":           active,
+			"active_on_reboot": onReboot,
+			"mounted":          cols[3] != "" && cols[3] != "-",
+		}
+		// the sizes have the suffixes of rcapstat
+		if v, ok := parseRcapSize(cols[4]); ok {
+			beFields["space_bytes"] = int64(v)
+		}
+		if v, err := strconv.ParseInt(cols[6], 10, 64); err == nil {
+			beFields["created"] = v
+		}
+		acc.AddFields("pkg_boot_environment", beFields, map[string]string{
+			"name": cols[0],
+		}, now)
+	}
+	fields["boot_environments"] = count
+	return nil
+}
+
+// checkUpdates counts the packages with a newer version available listed
+// by pkg list -Hu, which fails when there are none.
+func (p *Pkg) checkUpdates(run PkgRunner) error {
+	out, err := run(p.Timeout.Duration, "pkg", "list", "-Hu")
+	if err != nil {
+		if !strings.Contains(string(out), "no packages have newer versions") {
+			return fmt.Errorf("error running pkg list: %s: %s", err,
+				strings.TrimSpace(string(out)))
+		}
+		out = nil
+	}
+	p.updates = 0
+	for _, line := range strings.Split(string(out), "\n") {
+		if strings.TrimSpace(line) != "" {
+			p.updates++
+		}
+	}
+	p.checked = time.Now()
+	return nil
+}
+
+// lastUpdate returns the end of the last successful update in the history
+// of pkg, the zero time if there is none:
+//
+//	2024-01-10T12:34:56 update Succeeded
+func (p *Pkg) lastUpdate(run PkgRunner) (time.Time, error) {
+	out, err := run(p.Timeout.Duration, "pkg", "history", "-H",
+		"-o", "finish,operation,outcome")
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error running pkg history: %s: %s",
+			err, strings.TrimSpace(string(out)))
+	}
+
+	var last time.Time
+	for _, line := range strings.Split(string(out), "\n") {
+		cols := strings.Fields(line)
+		if len(cols) != 3 || cols[1] != "update" || cols[2] != "Succeeded" {
+			continue
+		}
+		t, err := time.ParseInLocation("2006-01-02T15:04:05", cols[0], time.Local)
+		if err == nil && t.After(last) {
+			last = t
+		}
+	}
+	return last, nil
+}
+
+func pkgRunner(timeout time.Duration, command string, args ...string) ([]byte, error) {
+	bin, err := exec.LookPath(command)
+	if err != nil {
+		return nil, err
+	}
+	c := exec.Command(bin, args...)
+	return CombinedOutputTimeout(c, timeout)
+}
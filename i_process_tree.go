@@ -0,0 +1,257 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ProcessTree checks that configured process trees are complete: that their
+// parent process runs along with the expected number of each kind of member
+// process, and reports the members missing.
+type ProcessTree struct {
+	Trees []*processTree `toml:"tree"`
+
+	// root of the /proc file system, for unit test purposes
+	procRoot string
+}
+
+// processTree is a parent process and the members expected with it. The
+// members are its descendants or, for daemons that detach from their parent
+// such as the Oracle background processes, any process of its user.
+type processTree struct {
+	Name          string
+	ParentExe     string `toml:"parent_exe"`
+	ParentPattern string `toml:"parent_pattern"`
+	ParentPidFile string `toml:"parent_pid_file"`
+	Descendants   bool
+	Members       []*processTreeMember `toml:"member"`
+
+	parentPattern *regexp.Regexp
+}
+
+// processTreeMember is a kind of member process, of which at least Count are
+// expected.
+type processTreeMember struct {
+	Name    string
+	Exe     string
+	Pattern string
+	Count   int
+
+	pattern *regexp.Regexp
+}
+
+func NewProcessTree() Input {
+	return &ProcessTree{
+		procRoot: "/proc",
+	}
+}
+
+func (_ *ProcessTree) Description() string {
+	return "Check that process trees have their parent and expected members"
+}
+
+var processTreeSampleConfig = `
+  ## Each tree is a parent process, selected by its executable name, a
+  ## regular expression matched against its command line or a pid file, the
+  ## oldest process matching being the parent, and the members expected
+  ## with it.
+  [[inputs.process_tree.tree]]
+    name = "oracle_orcl"
+    parent_pattern = "^ora_pmon_orcl"
+    ## Members are descendants of the parent if true, otherwise any process
+    ## of the user of the parent, as the Oracle background processes are not
+    ## children of pmon.
+    descendants = false
+
+    ## Each member is selected by its executable name and a regular
+    ## expression matched against its command line, count is the minimum
+    ## number of such processes expected, 1 by default.
+    [[inputs.process_tree.tree.member]]
+      name = "dbwr"
+      pattern = "^ora_dbw[0-9a-z]_orcl"
+    [[inputs.process_tree.tree.member]]
+      name = "lgwr"
+      pattern = "^ora_lgwr_orcl"
+    [[inputs.process_tree.tree.member]]
+      name = "smon"
+      pattern = "^ora_smon_orcl"
+
+  [[inputs.process_tree.tree]]
+    name = "apache"
+    parent_pid_file = "/var/run/apache2/2.4/httpd.pid"
+    descendants = true
+    [[inputs.process_tree.tree.member]]
+      name = "workers"
+      exe = "httpd"
+      count = 5
+`
+
+func (_ *ProcessTree) SampleConfig() string {
+	return processTreeSampleConfig
+}
+
+// Init checks the trees and compiles their patterns.
+func (p *ProcessTree) Init() error {
+	for _, tree := range p.Trees {
+		if tree.Name == "" {
+			return fmt.Errorf("a tree has no name")
+		}
+		if tree.ParentExe == "" && tree.ParentPattern == "" && tree.ParentPidFile == "" {
+			return fmt.Errorf("tree %s: one of parent_exe, parent_pattern or "+
+				"parent_pid_file is required", tree.Name)
+		}
+		if tree.ParentPattern != "" {
+			re, err := regexp.Compile(tree.ParentPattern)
+			if err != nil {
+				return fmt.Errorf("tree %s: invalid parent_pattern: %s", tree.Name, err)
+			}
+			tree.parentPattern = re
+		}
+		for _, member := range tree.Members {
+			if member.Name == "" {
+				return fmt.Errorf("tree %s: a member has no name", tree.Name)
+			}
+			if member.Exe == "" && member.Pattern == "" {
+				return fmt.Errorf("tree %s: member %s: one of exe or pattern is required",
+					tree.Name, member.Name)
+			}
+			if member.Pattern != "" {
+				re, err := regexp.Compile(member.Pattern)
+				if err != nil {
+					return fmt.Errorf("tree %s: member %s: invalid pattern: %s",
+						tree.Name, member.Name, err)
+				}
+				member.pattern = re
+			}
+			if member.Count == 0 {
+				member.Count = 1
+			}
+		}
+	}
+	return nil
+}
+
+func (p *ProcessTree) Gather(acc Accumulator) error {
+	procs, err := readAllPsinfo(p.procRoot)
+	if err != nil {
+		return err
+	}
+	children := make(map[int][]*psinfo)
+	for _, proc := range procs {
+		children[proc.ppid] = append(children[proc.ppid], proc)
+	}
+
+	now := time.Now()
+	for _, tree := range p.Trees {
+		parent, err := tree.parent(procs)
+		if err != nil {
+			acc.AddError(NewGatherError(err, "tree", tree.Name))
+		}
+
+		// the members are looked for among the descendants of the parent
+		// or the processes of its user
+		var candidates []*psinfo
+		if parent != nil {
+			if tree.Descendants {
+				candidates = descendants(parent.pid, children)
+			} else {
+				for _, proc := range procs {
+					if proc.uid == parent.uid && proc != parent {
+						candidates = append(candidates, proc)
+					}
+				}
+			}
+		}
+
+		expected, found, missing := 0, 0, 0
+		for _, member := range tree.Members {
+			count := 0
+			for _, proc := range candidates {
+				if member.matches(proc) {
+					count++
+				}
+			}
+			memberMissing := 0
+			if count < member.Count {
+				memberMissing = member.Count - count
+			}
+			expected += member.Count
+			found += count
+			missing += memberMissing
+
+			acc.AddFields("process_tree_member", map[string]interface{}{
+				"expected": member.Count,
+				"found":    count,
+				"missing":  memberMissing,
+			}, map[string]string{"tree": tree.Name, "member": member.Name}, now)
+		}
+
+		fields := map[string]interface{}{
+			"parent_running":   parent != nil,
+			"members_expected": expected,
+			"members_found":    found,
+			"members_missing":  missing,
+			"complete":         parent != nil && missing == 0,
+		}
+		if parent != nil {
+			fields["parent_pid"] = parent.pid
+			fields["parent_age"] = int64(now.Sub(parent.start).Seconds())
+		}
+		acc.AddFields("process_tree", fields, map[string]string{"tree": tree.Name}, now)
+	}
+	return nil
+}
+
+// parent returns the parent process of the tree, the oldest process
+// matching, or nil if none runs.
+func (t *processTree) parent(procs []*psinfo) (*psinfo, error) {
+	pid := -1
+	if t.ParentPidFile != "" {
+		b, err := ioutil.ReadFile(t.ParentPidFile)
+		if err != nil {
+			// not running, its pid file is removed
+			return nil, nil
+		}
+		if pid, err = strconv.Atoi(strings.TrimSpace(string(b))); err != nil {
+			return nil, fmt.Errorf("invalid pid in %s: %q", t.ParentPidFile, b)
+		}
+	}
+
+	var parent *psinfo
+	for _, proc := range procs {
+		if pid != -1 && proc.pid != pid ||
+			t.ParentExe != "" && proc.fname != t.ParentExe ||
+			t.parentPattern != nil && !t.parentPattern.MatchString(proc.psargs) {
+			continue
+		}
+		if parent == nil || proc.start.Before(parent.start) {
+			parent = proc
+		}
+	}
+	return parent, nil
+}
+
+func (m *processTreeMember) matches(proc *psinfo) bool {
+	if m.Exe != "" && proc.fname != m.Exe {
+		return false
+	}
+	return m.pattern == nil || m.pattern.MatchString(proc.psargs)
+}
+
+// descendants returns the processes descending from the pid.
+func descendants(pid int, children map[int][]*psinfo) []*psinfo {
+	var procs []*psinfo
+	for _, child := range children[pid] {
+		// the ppid of the sched process is itself
+		if child.pid == pid {
+			continue
+		}
+		procs = append(procs, child)
+		procs = append(procs, descendants(child.pid, children)...)
+	}
+	return procs
+}
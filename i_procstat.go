@@ -0,0 +1,304 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Offsets in the psinfo_t of the LP64 data model, as written to
+// /proc/<pid>/psinfo, see proc(4).
+const (
+	psinfoNlwp     = 4
+	psinfoPid      = 8
+	psinfoPpid     = 12
+	psinfoUID      = 24
+	psinfoSize     = 48
+	psinfoRssize   = 56
+	psinfoPctcpu   = 80
+	psinfoPctmem   = 82
+	psinfoStart    = 88
+	psinfoTime     = 104
+	psinfoFname    = 136
+	psinfoPsargs   = 152
+	psinfoTaskid   = 260
+	psinfoProjid   = 264
+	psinfoMinSize  = 268
+	psinfoFnameLen = 16
+	psinfoArgsLen  = 80
+)
+
+// Procstat reports the resource usage of the processes selected by their
+// executable name, command line, user or pid file, read from /proc.
+type Procstat struct {
+	Exe     string
+	Pattern string
+	User    string
+	PidFile string `toml:"pid_file"`
+	PidTag  bool   `toml:"pid_tag"`
+
+	// root of the /proc file system, for unit test purposes
+	procRoot string
+
+	pattern *regexp.Regexp
+	uid     string
+
+	// cpu time of each process at the previous gather, by pid and start
+	// time so that a reused pid is not mistaken for the same process
+	lastCPU  map[string]time.Duration
+	lastTime time.Time
+}
+
+// psinfo holds the fields of psinfo_t reported by procstat.
+type psinfo struct {
+	pid     int
+	ppid    int
+	uid     uint32
+	nlwp    int32
+	size    uint64
+	rssize  uint64
+	pctcpu  uint16 // binary fraction, 0x8000 is 100%
+	pctmem  uint16 // binary fraction, 0x8000 is 100%
+	taskid  int
+	projid  int
+	start   time.Time
+	cpuTime time.Duration
+	fname   string
+	psargs  string
+}
+
+func NewProcstat() Input {
+	return &Procstat{
+		procRoot: "/proc",
+	}
+}
+
+func (_ *Procstat) Description() string {
+	return "Monitor the CPU and memory usage of selected processes"
+}
+
+var procstatSampleConfig = `
+  ## The processes are selected by one or more of the settings below, a
+  ## process has to match all of them to be reported.
+  ## Executable name, as shown by pgrep and ps -o comm
+  # exe = "java"
+  ## Regular expression matched against the command line and its arguments
+  # pattern = "ora_pmon_.*"
+  ## User the processes run as
+  # user = "oracle"
+  ## File holding the pid of the process
+  # pid_file = "/var/run/sshd.pid"
+
+  ## Tag the metrics with the pid of the processes instead of having it as
+  ## a field, so that several processes matching have their own series.
+  # pid_tag = false
+`
+
+func (_ *Procstat) SampleConfig() string {
+	return procstatSampleConfig
+}
+
+// Init checks a selector is configured, compiles the pattern and resolves
+// the user.
+func (p *Procstat) Init() error {
+	if p.Exe == "" && p.Pattern == "" && p.User == "" && p.PidFile == "" {
+		return fmt.Errorf("one of exe, pattern, user or pid_file is required")
+	}
+	if p.Pattern != "" {
+		re, err := regexp.Compile(p.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid pattern: %s", err)
+		}
+		p.pattern = re
+	}
+	if p.User != "" {
+		u, err := user.Lookup(p.User)
+		if err != nil {
+			return err
+		}
+		p.uid = u.Uid
+	}
+	return nil
+}
+
+func (p *Procstat) Gather(acc Accumulator) error {
+	pids, err := p.candidates()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	elapsed := now.Sub(p.lastTime)
+	cpu := make(map[string]time.Duration)
+	for _, pid := range pids {
+		info, err := p.readPsinfo(pid)
+		if err != nil {
+			// the process exited since /proc was listed
+			continue
+		}
+		if !p.selected(info) {
+			continue
+		}
+
+		fields := map[string]interface{}{
+			"cpu_time":    info.cpuTime.Seconds(),
+			"memory_rss":  info.rssize * 1024,
+			"memory_vms":  info.size * 1024,
+			"num_threads": info.nlwp,
+			"created_at":  info.start.UnixNano(),
+			"age":         int64(now.Sub(info.start).Seconds()),
+		}
+		if fds, err := ioutil.ReadDir(filepath.Join(p.procRoot, pid, "fd")); err == nil {
+			fields["num_fds"] = len(fds)
+		}
+
+		// cpu usage is a percentage of one cpu, like ps reports it, and
+		// can only be computed from the second gather on
+		key := pid + "/" + strconv.FormatInt(info.start.UnixNano(), 10)
+		cpu[key] = info.cpuTime
+		if last, ok := p.lastCPU[key]; ok && elapsed > 0 && info.cpuTime >= last {
+			fields["cpu_usage"] = 100 * float64(info.cpuTime-last) / float64(elapsed)
+		}
+
+		tags := map[string]string{
+			"process_name": info.fname,
+		}
+		for tag, value := range map[string]string{
+			"exe":      p.Exe,
+			"pattern":  p.Pattern,
+			"user":     p.User,
+			"pid_file": p.PidFile,
+		} {
+			if value != "" {
+				tags[tag] = value
+			}
+		}
+		if p.PidTag {
+			tags["pid"] = pid
+		} else {
+			fields["pid"] = info.pid
+		}
+		acc.AddFields("procstat", fields, tags, now)
+	}
+	p.lastCPU = cpu
+	p.lastTime = now
+	return nil
+}
+
+// candidates returns the pids to read the psinfo of, the one of the pid file
+// or all of them.
+func (p *Procstat) candidates() ([]string, error) {
+	if p.PidFile != "" {
+		b, err := ioutil.ReadFile(p.PidFile)
+		if err != nil {
+			return nil, fmt.Errorf("procstat: %s", err)
+		}
+		pid := strings.TrimSpace(string(b))
+		if _, err := strconv.Atoi(pid); err != nil {
+			return nil, fmt.Errorf("procstat: invalid pid in %s: %q", p.PidFile, pid)
+		}
+		return []string{pid}, nil
+	}
+
+	entries, err := ioutil.ReadDir(p.procRoot)
+	if err != nil {
+		return nil, err
+	}
+	pids := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if _, err := strconv.Atoi(entry.Name()); err == nil {
+			pids = append(pids, entry.Name())
+		}
+	}
+	return pids, nil
+}
+
+func (p *Procstat) selected(info *psinfo) bool {
+	if p.Exe != "" && info.fname != p.Exe {
+		return false
+	}
+	if p.pattern != nil && !p.pattern.MatchString(info.psargs) {
+		return false
+	}
+	if p.uid != "" && strconv.FormatUint(uint64(info.uid), 10) != p.uid {
+		return false
+	}
+	return true
+}
+
+func (p *Procstat) readPsinfo(pid string) (*psinfo, error) {
+	b, err := ioutil.ReadFile(filepath.Join(p.procRoot, pid, "psinfo"))
+	if err != nil {
+		return nil, err
+	}
+	return parsePsinfo(b)
+}
+
+// parsePsinfo decodes a psinfo_t, written in the byte order of the system.
+func parsePsinfo(b []byte) (*psinfo, error) {
+	if len(b) < psinfoMinSize {
+		return nil, fmt.Errorf("psinfo too short: %d bytes", len(b))
+	}
+	order := binary.NativeEndian
+	timestruc := func(off int) (int64, int64) {
+		return int64(order.Uint64(b[off:])), int64(order.Uint64(b[off+8:]))
+	}
+	cstring := func(off, n int) string {
+		s := b[off : off+n]
+		if i := strings.IndexByte(string(s), 0); i != -1 {
+			s = s[:i]
+		}
+		return string(s)
+	}
+
+	startSec, startNsec := timestruc(psinfoStart)
+	timeSec, timeNsec := timestruc(psinfoTime)
+	return &psinfo{
+		pid:     int(int32(order.Uint32(b[psinfoPid:]))),
+		ppid:    int(int32(order.Uint32(b[psinfoPpid:]))),
+		uid:     order.Uint32(b[psinfoUID:]),
+		nlwp:    int32(order.Uint32(b[psinfoNlwp:])),
+		size:    order.Uint64(b[psinfoSize:]),
+		rssize:  order.Uint64(b[psinfoRssize:]),
+		pctcpu:  order.Uint16(b[psinfoPctcpu:]),
+		pctmem:  order.Uint16(b[psinfoPctmem:]),
+		taskid:  int(int32(order.Uint32(b[psinfoTaskid:]))),
+		projid:  int(int32(order.Uint32(b[psinfoProjid:]))),
+		start:   time.Unix(startSec, startNsec),
+		cpuTime: time.Duration(timeSec)*time.Second + time.Duration(timeNsec),
+		fname:   cstring(psinfoFname, psinfoFnameLen),
+		psargs:  cstring(psinfoPsargs, psinfoArgsLen),
+	}, nil
+}
+
+// readAllPsinfo returns the psinfo of all the processes under the /proc
+// file system at procRoot.
+func readAllPsinfo(procRoot string) ([]*psinfo, error) {
+	entries, err := ioutil.ReadDir(procRoot)
+	if err != nil {
+		return nil, err
+	}
+	procs := make([]*psinfo, 0, len(entries))
+	for _, entry := range entries {
+		if _, err := strconv.Atoi(entry.Name()); err != nil {
+			continue
+		}
+		b, err := ioutil.ReadFile(filepath.Join(procRoot, entry.Name(), "psinfo"))
+		if err != nil {
+			// the process exited since /proc was listed
+			continue
+		}
+		info, err := parsePsinfo(b)
+		if err != nil {
+			continue
+		}
+		procs = append(procs, info)
+	}
+	return procs, nil
+}
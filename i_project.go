@@ -0,0 +1,171 @@
+package main
+
+import (
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Project reports the CPU and memory usage of the processes of each
+// project, and optionally of each task, like prstat -J and -T, summed from
+// the psinfo of the processes.
+type Project struct {
+	Projects []string
+	Tasks    bool
+
+	// root of the /proc file system and project database, for unit test
+	// purposes
+	procRoot    string
+	projectFile string
+}
+
+// projectUsage is the usage summed over the processes of a project or task.
+type projectUsage struct {
+	nproc  int64
+	nlwp   int64
+	size   uint64
+	rssize uint64
+	pctcpu uint64
+	pctmem uint64
+	tasks  map[int]bool
+}
+
+func NewProject() Input {
+	return &Project{
+		procRoot:    "/proc",
+		projectFile: "/etc/project",
+	}
+}
+
+func (_ *Project) Description() string {
+	return "Read the CPU and memory usage of the processes of each project"
+}
+
+var projectSampleConfig = `
+  ## Projects to report on, as globs matched against their names. If empty,
+  ## all projects running processes are reported.
+  # projects = ["user.oracle", "group.*"]
+  ## Report the usage of each task of the projects too
+  # tasks = false
+`
+
+func (_ *Project) SampleConfig() string {
+	return projectSampleConfig
+}
+
+// Init checks the globs of the projects.
+func (p *Project) Init() error {
+	return checkGlobs("projects", p.Projects)
+}
+
+func (p *Project) Gather(acc Accumulator) error {
+	procs, err := readAllPsinfo(p.procRoot)
+	if err != nil {
+		return err
+	}
+	names := p.projectNames()
+
+	projects := make(map[int]*projectUsage)
+	tasks := make(map[int]*projectUsage)
+	taskProject := make(map[int]int)
+	for _, proc := range procs {
+		// zombies, with no lwp left, hold no resources
+		if proc.nlwp == 0 {
+			continue
+		}
+		project := projects[proc.projid]
+		if project == nil {
+			project = &projectUsage{tasks: make(map[int]bool)}
+			projects[proc.projid] = project
+		}
+		project.add(proc)
+		project.tasks[proc.taskid] = true
+
+		if p.Tasks {
+			task := tasks[proc.taskid]
+			if task == nil {
+				task = &projectUsage{}
+				tasks[proc.taskid] = task
+				taskProject[proc.taskid] = proc.projid
+			}
+			task.add(proc)
+		}
+	}
+
+	now := time.Now()
+	name := func(projid int) string {
+		if name, ok := names[projid]; ok {
+			return name
+		}
+		return strconv.Itoa(projid)
+	}
+	for projid, usage := range projects {
+		tags := map[string]string{
+			"project": name(projid),
+			"projid":  strconv.Itoa(projid),
+		}
+		if len(p.Projects) != 0 && !matchesAny(tags["project"], p.Projects) {
+			continue
+		}
+		fields := usage.fields()
+		fields["ntasks"] = int64(len(usage.tasks))
+		acc.AddGauge("project", fields, tags, now)
+	}
+	for taskid, usage := range tasks {
+		projid := taskProject[taskid]
+		tags := map[string]string{
+			"project": name(projid),
+			"projid":  strconv.Itoa(projid),
+			"taskid":  strconv.Itoa(taskid),
+		}
+		if len(p.Projects) != 0 && !matchesAny(tags["project"], p.Projects) {
+			continue
+		}
+		acc.AddGauge("project_task", usage.fields(), tags, now)
+	}
+	return nil
+}
+
+func (u *projectUsage) add(proc *psinfo) {
+	u.nproc++
+	u.nlwp += int64(proc.nlwp)
+	u.size += proc.size
+	u.rssize += proc.rssize
+	u.pctcpu += uint64(proc.pctcpu)
+	u.pctmem += uint64(proc.pctmem)
+}
+
+func (u *projectUsage) fields() map[string]interface{} {
+	// the sizes of psinfo are in kilobytes
+	return map[string]interface{}{
+		"nproc":          u.nproc,
+		"nlwp":           u.nlwp,
+		"size_bytes":     u.size * 1024,
+		"rss_bytes":      u.rssize * 1024,
+		"cpu_percent":    100 * float64(u.pctcpu) / 0x8000,
+		"memory_percent": 100 * float64(u.pctmem) / 0x8000,
+	}
+}
+
+// projectNames returns the names of the projects of the local project
+// database, by project id. Projects only defined in a name service are
+// reported by id.
+func (p *Project) projectNames() map[int]string {
+	names := make(map[int]string)
+	b, err := ioutil.ReadFile(p.projectFile)
+	if err != nil {
+		return names
+	}
+	// name:projid:comment:user-list:group-list:attributes
+	for _, line := range strings.Split(string(b), "\n") {
+		cols := strings.Split(line, ":")
+		if len(cols) < 2 || strings.HasPrefix(cols[0], "#") {
+			continue
+		}
+		if projid, err := strconv.Atoi(cols[1]); err == nil {
+			names[projid] = cols[0]
+		}
+	}
+	return names
+}
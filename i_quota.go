@@ -0,0 +1,253 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// QuotaRunner runs zfs(1M) or repquota(1M), given as the command, with the
+// given arguments and returns its output. It can be replaced with a mocked
+// function for unit test purposes.
+type QuotaRunner func(timeout time.Duration, command string, args ...string) ([]byte, error)
+
+// quotaTimeUnits are the units of the grace time left printed by repquota.
+var quotaTimeUnits = map[string]bool{
+	"sec": true, "secs": true, "min": true, "mins": true,
+	"hour": true, "hours": true, "day": true, "days": true,
+	"week": true, "weeks": true, "month": true, "months": true,
+}
+
+// Quota reports the usage of the user and group quotas of the ZFS file
+// systems, and of the user quotas of the UFS file systems.
+type Quota struct {
+	ZFS              bool `toml:"zfs"`
+	UFS              bool `toml:"ufs"`
+	Filesystems      []string
+	IncludeUnlimited bool `toml:"include_unlimited"`
+	Timeout          Duration
+
+	runQuota QuotaRunner
+}
+
+func NewQuota() Input {
+	return &Quota{
+		ZFS:      true,
+		UFS:      true,
+		Timeout:  Duration{Duration: 30 * time.Second},
+		runQuota: quotaRunner,
+	}
+}
+
+func (_ *Quota) Description() string {
+	return "Read the usage of the user and group quotas of ZFS and UFS file systems"
+}
+
+var quotaSampleConfig = `
+  ## Report the user and group quotas of the ZFS file systems, with zfs
+  ## userspace and zfs groupspace
+  # zfs = true
+  ## Report the user quotas of the UFS file systems, with repquota -a,
+  ## which requires root
+  # ufs = true
+  ## File systems to report on, as globs matched against the ZFS dataset
+  ## names and the UFS mount points. If empty, all are reported; setting
+  ## them spares listing the usage of every ZFS file system.
+  # filesystems = ["rpool/export/home*", "/export/home"]
+  ## Report the usage of the users and groups without a quota too
+  # include_unlimited = false
+  ## Timeout for each zfs and repquota command to complete
+  # timeout = "30s"
+`
+
+func (_ *Quota) SampleConfig() string {
+	return quotaSampleConfig
+}
+
+// Init checks the globs of the filesystems.
+func (q *Quota) Init() error {
+	return checkGlobs("filesystems", q.Filesystems)
+}
+
+func (q *Quota) Gather(acc Accumulator) error {
+	run := q.runQuota
+	if run == nil {
+		run = quotaRunner
+	}
+	if q.ZFS {
+		if err := q.gatherZFS(run, acc); err != nil {
+			acc.AddError(err)
+		}
+	}
+	if q.UFS {
+		if err := q.gatherUFS(run, acc); err != nil {
+			acc.AddError(err)
+		}
+	}
+	return nil
+}
+
+func (q *Quota) gatherZFS(run QuotaRunner, acc Accumulator) error {
+	out, err := run(q.Timeout.Duration, "zfs", "list", "-H", "-o", "name", "-t", "filesystem")
+	if err != nil {
+		return fmt.Errorf("error listing ZFS file systems: %s", err)
+	}
+
+	for _, fs := range strings.Fields(string(out)) {
+		if len(q.Filesystems) != 0 && !matchesAny(fs, q.Filesystems) {
+			continue
+		}
+		for _, space := range []string{"userspace", "groupspace"} {
+			out, err := run(q.Timeout.Duration, "zfs", space, "-Hp",
+				"-o", "type,name,used,quota", fs)
+			if err != nil {
+				acc.AddError(NewGatherError(fmt.Errorf("zfs %s: %s", space, err),
+					"filesystem", fs))
+				continue
+			}
+			now := time.Now()
+			for _, line := range strings.Split(string(out), "\n") {
+				// POSIX User<TAB>alice<TAB>1048576<TAB>10737418240
+				cols := strings.Split(line, "\t")
+				if len(cols) != 4 {
+					continue
+				}
+				kind := "user"
+				if strings.HasSuffix(cols[0], "Group") {
+					kind = "group"
+				}
+				used, err := strconv.ParseInt(cols[2], 10, 64)
+				if err != nil {
+					continue
+				}
+				// the quota is "none" or "-" when not set
+				quota, err := strconv.ParseInt(cols[3], 10, 64)
+				if err != nil {
+					quota = 0
+				}
+				q.add(acc, "zfs", fs, kind, cols[1], used, 0, quota, nil, now)
+			}
+		}
+	}
+	return nil
+}
+
+// gatherUFS reads the user quotas printed by repquota -av, a header with the
+// device and the mount point of each file system followed by a line per
+// user, with the block usage and limits in kilobytes, each followed by the
+// grace time left when the soft limit is exceeded, and then the file usage
+// and limits:
+//
+//	/dev/dsk/c0t0d0s7 (/export/home):
+//	                      Block limits                      File limits
+//	User           used   soft   hard    timeleft    used   soft   hard    timeleft
+//	alice     +-  10240   8192  20480    7.0 days      42      0      0
+func (q *Quota) gatherUFS(run QuotaRunner, acc Accumulator) error {
+	out, err := run(q.Timeout.Duration, "repquota", "-av")
+	if err != nil {
+		return fmt.Errorf("error running repquota: %s", err)
+	}
+
+	now := time.Now()
+	mountpoint := ""
+	for _, line := range strings.Split(string(out), "\n") {
+		cols := strings.Fields(line)
+		if len(cols) == 2 && strings.HasPrefix(cols[1], "(") && strings.HasSuffix(cols[1], "):") {
+			mountpoint = strings.TrimSuffix(strings.TrimPrefix(cols[1], "("), "):")
+			continue
+		}
+		if mountpoint == "" || len(cols) < 8 || cols[0] == "User" {
+			continue
+		}
+		if len(q.Filesystems) != 0 && !matchesAny(mountpoint, q.Filesystems) {
+			continue
+		}
+
+		// the numbers, leaving out the grace times
+		var values []int64
+		for i := 2; i < len(cols); i++ {
+			if i+1 < len(cols) && quotaTimeUnits[cols[i+1]] {
+				i++
+				continue
+			}
+			if v, err := strconv.ParseInt(cols[i], 10, 64); err == nil {
+				values = append(values, v)
+			}
+		}
+		if len(values) != 6 {
+			continue
+		}
+		files := []int64{values[3], values[4], values[5]}
+		q.add(acc, "ufs", mountpoint, "user", cols[0],
+			values[0]*1024, values[1]*1024, values[2]*1024, files, now)
+	}
+	return nil
+}
+
+// add reports the usage of a quota, the limits of which are 0 when not set,
+// and percent of the hard limit, or of the soft limit without a hard one.
+// The files are the used, soft and hard file limits of UFS quotas.
+func (q *Quota) add(
+	acc Accumulator,
+	fstype, fs, kind, name string,
+	used, soft, hard int64,
+	files []int64,
+	now time.Time,
+) {
+	unlimited := soft == 0 && hard == 0
+	if files != nil {
+		unlimited = unlimited && files[1] == 0 && files[2] == 0
+	}
+	if unlimited && !q.IncludeUnlimited {
+		return
+	}
+	fields := map[string]interface{}{
+		"used_bytes": used,
+	}
+	limit := hard
+	if limit == 0 {
+		limit = soft
+	}
+	if hard > 0 {
+		fields["quota_bytes"] = hard
+	}
+	if soft > 0 {
+		fields["soft_quota_bytes"] = soft
+	}
+	if limit > 0 {
+		fields["used_percent"] = 100 * float64(used) / float64(limit)
+	}
+	if files != nil {
+		fields["files_used"] = files[0]
+		if files[1] > 0 {
+			fields["files_soft_quota"] = files[1]
+		}
+		if files[2] > 0 {
+			fields["files_quota"] = files[2]
+		}
+		limit := files[2]
+		if limit == 0 {
+			limit = files[1]
+		}
+		if limit > 0 {
+			fields["files_used_percent"] = 100 * float64(files[0]) / float64(limit)
+		}
+	}
+	acc.AddGauge("quota", fields, map[string]string{
+		"fstype":     fstype,
+		"filesystem": fs,
+		"type":       kind,
+		"name":       name,
+	}, now)
+}
+
+func quotaRunner(timeout time.Duration, command string, args ...string) ([]byte, error) {
+	bin, err := exec.LookPath(command)
+	if err != nil {
+		bin = "/usr/sbin/" + command
+	}
+	c := exec.Command(bin, args...)
+	return CombinedOutputTimeout(c, timeout)
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RcapRunner runs rcapstat(1) with the given arguments and returns its
+// output. It can be replaced with a mocked function for unit test purposes.
+type RcapRunner func(timeout time.Duration, args ...string) ([]byte, error)
+
+// rcapSizeUnits are the multipliers of the size suffixes of rcapstat.
+var rcapSizeUnits = map[byte]float64{
+	'K': 1 << 10,
+	'M': 1 << 20,
+	'G': 1 << 30,
+	'T': 1 << 40,
+}
+
+// Rcap reports the physical memory caps of the projects and zones enforced
+// by the resource capping daemon, read from rcapstat.
+type Rcap struct {
+	Types          []string
+	SampleInterval Duration `toml:"sample_interval"`
+
+	// enforcements counted so far by type and id
+	enforcements map[string]int64
+
+	runRcap RcapRunner
+}
+
+func NewRcap() Input {
+	return &Rcap{
+		Types:          []string{"project", "zone"},
+		SampleInterval: Duration{Duration: time.Second},
+		enforcements:   make(map[string]int64),
+		runRcap:        rcapRunner,
+	}
+}
+
+func (_ *Rcap) Description() string {
+	return "Read the memory cap usage and enforcement of projects and zones from rcapstat"
+}
+
+var rcapSampleConfig = `
+  ## Caps reported, "project" for the project caps enforced by rcapd, "zone"
+  ## for the zone caps.
+  # types = ["project", "zone"]
+  ## Time rcapstat samples over on each gather, the paging fields are the
+  ## bytes paged out over it. It must be shorter than the interval.
+  # sample_interval = "1s"
+`
+
+func (_ *Rcap) SampleConfig() string {
+	return rcapSampleConfig
+}
+
+// Init checks the types and the sample interval.
+func (r *Rcap) Init() error {
+	for _, t := range r.Types {
+		if t != "project" && t != "zone" {
+			return fmt.Errorf("invalid type %q, expected project or zone", t)
+		}
+	}
+	if r.SampleInterval.Duration < time.Second {
+		return fmt.Errorf("sample_interval must be at least 1s")
+	}
+	return nil
+}
+
+func (r *Rcap) Gather(acc Accumulator) error {
+	run := r.runRcap
+	if run == nil {
+		run = rcapRunner
+	}
+	if r.enforcements == nil {
+		r.enforcements = make(map[string]int64)
+	}
+	interval := strconv.Itoa(int(r.SampleInterval.Duration / time.Second))
+	global := true
+	for _, t := range r.Types {
+		args := []string{"-g"}
+		if t == "zone" {
+			args = append(args, "-z")
+		}
+		args = append(args, interval, "2")
+		out, err := run(r.SampleInterval.Duration*2+10*time.Second, args...)
+		if err != nil {
+			acc.AddError(fmt.Errorf("error running rcapstat: %s: %s", err,
+				strings.TrimSpace(string(out))))
+			continue
+		}
+		// the memory utilization is the same for both types
+		r.parse(acc, t, string(out), global)
+		global = false
+	}
+	return nil
+}
+
+// parse reports the caps of the last report printed by rcapstat -g, the
+// first one being since rcapd started:
+//
+//	    id project         nproc    vm   rss   cap    at avgat    pg avgpg
+//	   100 user.oracle         12  2.1G  1.4G  1.0G   41M   20M   38M   19M
+//	physical memory utilization: 55%   cap enforcement threshold: 0%
+func (r *Rcap) parse(acc Accumulator, kind, out string, global bool) {
+	var rows [][]string
+	var utilization, threshold string
+	for _, line := range strings.Split(out, "\n") {
+		cols := strings.Fields(line)
+		switch {
+		case len(cols) == 10 && cols[0] == "id":
+			// a new report starts
+			rows = rows[:0]
+		case len(cols) == 10:
+			rows = append(rows, cols)
+		case strings.HasPrefix(strings.TrimSpace(line), "physical memory utilization:"):
+			for i, col := range cols {
+				if col == "utilization:" && i+1 < len(cols) {
+					utilization = strings.TrimSuffix(cols[i+1], "%")
+				}
+				if col == "threshold:" && i+1 < len(cols) {
+					threshold = strings.TrimSuffix(cols[i+1], "%")
+				}
+			}
+		}
+	}
+
+	now := time.Now()
+	for _, cols := range rows {
+		fields := map[string]interface{}{}
+		if v, err := strconv.ParseInt(cols[2], 10, 64); err == nil {
+			fields["nproc"] = v
+		}
+		sizes := make(map[string]float64)
+		for i, field := range []string{"vm_bytes", "rss_bytes", "cap_bytes",
+			"paging_attempted_bytes", "avg_paging_attempted_bytes",
+			"paged_out_bytes", "avg_paged_out_bytes"} {
+			if v, ok := parseRcapSize(cols[i+3]); ok {
+				fields[field] = int64(v)
+				sizes[field] = v
+			}
+		}
+		if c := sizes["cap_bytes"]; c > 0 {
+			fields["cap_used_percent"] = 100 * sizes["rss_bytes"] / c
+			fields["over_cap"] = sizes["rss_bytes"] > c
+		}
+		// rcapd tried to page out the members since the previous sample
+		key := kind + ":" + cols[0]
+		enforcing := sizes["paging_attempted_bytes"] > 0
+		if enforcing {
+			r.enforcements[key]++
+		}
+		fields["enforcing"] = enforcing
+		fields["enforcements"] = r.enforcements[key]
+
+		acc.AddFields("rcap", fields, map[string]string{
+			"type": kind,
+			"id":   cols[0],
+			"name": cols[1],
+		}, now)
+	}
+
+	if !global || utilization == "" {
+		return
+	}
+	fields := map[string]interface{}{}
+	setKstatField(fields, "memory_utilization_percent", utilization)
+	setKstatField(fields, "enforcement_threshold_percent", threshold)
+	acc.AddGauge("rcap_memory", fields, nil, now)
+}
+
+// parseRcapSize parses a size of rcapstat, such as 512K or 1.2G, in bytes.
+func parseRcapSize(s string) (float64, bool) {
+	if s == "" {
+		return 0, false
+	}
+	mult, ok := rcapSizeUnits[s[len(s)-1]]
+	if ok {
+		s = s[:len(s)-1]
+	} else {
+		mult = 1
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, false
+	}
+	return v * mult, true
+}
+
+func rcapRunner(timeout time.Duration, args ...string) ([]byte, error) {
+	bin, err := exec.LookPath("rcapstat")
+	if err != nil {
+		return nil, err
+	}
+	c := exec.Command(bin, args...)
+	return CombinedOutputTimeout(c, timeout)
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SensorsRunner runs ipmitool(1) or prtpicl(1M), given as the command, with
+// the given arguments and returns its output. It can be replaced with a
+// mocked function for unit test purposes.
+type SensorsRunner func(timeout time.Duration, command string, args ...string) ([]byte, error)
+
+// sensorUnits maps the units of the readings of ipmitool to their type and
+// normalized unit.
+var sensorUnits = map[string][2]string{
+	"degrees C": {"temperature", "celsius"},
+	"RPM":       {"fan", "rpm"},
+	"percent":   {"fan", "percent"},
+	"Volts":     {"voltage", "volts"},
+	"Amps":      {"current", "amps"},
+	"Watts":     {"power", "watts"},
+}
+
+// picl classes of the sensors, with their type, the property holding their
+// reading and its unit.
+var piclSensors = map[string][3]string{
+	"temperature-sensor":    {"temperature", "Temperature", "celsius"},
+	"temperature-indicator": {"temperature", "", ""},
+	"fan":                   {"fan", "Speed", "rpm"},
+	"voltage-sensor":        {"voltage", "Voltage", "volts"},
+	"voltage-indicator":     {"voltage", "", ""},
+	"current-sensor":        {"current", "Current", "amps"},
+	"current-indicator":     {"current", "", ""},
+	"power-supply":          {"power_supply", "", ""},
+}
+
+// sensor is a reading normalized from ipmitool or prtpicl.
+type sensor struct {
+	name     string
+	location string
+	kind     string
+	unit     string
+	value    *float64
+	status   string
+	ok       bool
+}
+
+// Sensors reports the temperatures, fan speeds, voltages and power supply
+// states of the chassis, read with ipmitool or prtpicl.
+type Sensors struct {
+	Method  string
+	Timeout Duration
+
+	runSensors SensorsRunner
+}
+
+func NewSensors() Input {
+	return &Sensors{
+		Method:     "auto",
+		Timeout:    Duration{Duration: 20 * time.Second},
+		runSensors: sensorsRunner,
+	}
+}
+
+func (_ *Sensors) Description() string {
+	return "Read the chassis temperatures, fan speeds and power supply states"
+}
+
+var sensorsSampleConfig = `
+  ## "ipmitool" reads the sensors of the service processor with ipmitool sdr,
+  ## "prtpicl" the environmental sensors of SPARC systems from the PICL tree,
+  ## "auto" tries ipmitool first and then prtpicl.
+  # method = "auto"
+  ## Timeout for the ipmitool or prtpicl command to complete
+  # timeout = "20s"
+`
+
+func (_ *Sensors) SampleConfig() string {
+	return sensorsSampleConfig
+}
+
+// Init checks the method.
+func (s *Sensors) Init() error {
+	switch s.Method {
+	case "auto", "ipmitool", "prtpicl":
+		return nil
+	}
+	return fmt.Errorf("invalid method %q, expected auto, ipmitool or prtpicl", s.Method)
+}
+
+func (s *Sensors) Gather(acc Accumulator) error {
+	run := s.runSensors
+	if run == nil {
+		run = sensorsRunner
+	}
+
+	var sensors []*sensor
+	var err error
+	switch s.Method {
+	case "ipmitool":
+		sensors, err = s.ipmitool(run)
+	case "prtpicl":
+		sensors, err = s.prtpicl(run)
+	default:
+		if sensors, err = s.ipmitool(run); err != nil {
+			var perr error
+			if sensors, perr = s.prtpicl(run); perr != nil {
+				err = fmt.Errorf("%s, %s", err, perr)
+			} else {
+				err = nil
+			}
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, sensor := range sensors {
+		fields := map[string]interface{}{
+			"status": sensor.status,
+			"ok":     sensor.ok,
+		}
+		if sensor.value != nil {
+			fields["value"] = *sensor.value
+		}
+		tags := map[string]string{
+			"sensor": sensor.name,
+			"type":   sensor.kind,
+		}
+		if sensor.location != "" {
+			tags["location"] = sensor.location
+		}
+		if sensor.unit != "" {
+			tags["unit"] = sensor.unit
+		}
+		acc.AddFields("sensors", fields, tags, now)
+	}
+	return nil
+}
+
+// ipmitool reads the sensors from the lines of ipmitool sdr elist full:
+//
+//	MB/T_AMB         | 30h | ok  |  7.0 | 27 degrees C
+//	PS0/VINOK        | 51h | ok  | 10.1 | State Deasserted
+//
+// The location is the part of the name before the last '/', and the power
+// supplies are told apart by their entity id 10.
+func (s *Sensors) ipmitool(run SensorsRunner) ([]*sensor, error) {
+	out, err := run(s.Timeout.Duration, "ipmitool", "sdr", "elist", "full")
+	if err != nil {
+		return nil, fmt.Errorf("error running ipmitool: %s", err)
+	}
+
+	var sensors []*sensor
+	for _, line := range strings.Split(string(out), "\n") {
+		cols := strings.Split(line, "|")
+		if len(cols) != 5 {
+			continue
+		}
+		for i := range cols {
+			cols[i] = strings.TrimSpace(cols[i])
+		}
+		// sensors with no reading
+		if cols[2] == "ns" {
+			continue
+		}
+
+		sn := &sensor{
+			name:   cols[0],
+			kind:   "other",
+			status: cols[2],
+			ok:     cols[2] == "ok",
+		}
+		if i := strings.LastIndex(sn.name, "/"); i != -1 {
+			sn.location, sn.name = sn.name[:i], sn.name[i+1:]
+		}
+		if strings.HasPrefix(cols[3], "10.") {
+			sn.kind = "power_supply"
+		}
+		for unit, norm := range sensorUnits {
+			if !strings.HasSuffix(cols[4], " "+unit) {
+				continue
+			}
+			v, err := strconv.ParseFloat(strings.TrimSuffix(cols[4], " "+unit), 64)
+			if err == nil {
+				sn.value = &v
+				sn.kind, sn.unit = norm[0], norm[1]
+			}
+			break
+		}
+		sensors = append(sensors, sn)
+	}
+	return sensors, nil
+}
+
+// prtpicl reads the sensors from the nodes of the PICL tree printed by
+// prtpicl -v, nested by their indentation, each a line with its name and
+// class followed by its properties:
+//
+//	MB (fru, 2d00000519)
+//	  T_AMB (temperature-sensor, 3a00000544)
+//	    :Temperature         27
+//	    :HighWarningThreshold 40
+//	    :Condition           ok
+//
+// The location is the name of the parent node.
+func (s *Sensors) prtpicl(run SensorsRunner) ([]*sensor, error) {
+	out, err := run(s.Timeout.Duration, "prtpicl", "-v")
+	if err != nil {
+		return nil, fmt.Errorf("error running prtpicl: %s", err)
+	}
+
+	type node struct {
+		indent int
+		name   string
+		class  string
+		props  map[string]string
+	}
+	var sensors []*sensor
+	var stack []*node
+	var current *node
+	flush := func() {
+		if current == nil {
+			return
+		}
+		if sn := piclSensor(current.name, current.class, current.props); sn != nil {
+			if len(stack) > 1 {
+				sn.location = stack[len(stack)-2].name
+			}
+			sensors = append(sensors, sn)
+		}
+	}
+
+	for _, line := range strings.Split(string(out), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" {
+			continue
+		}
+		if strings.HasPrefix(trimmed, ":") {
+			if current != nil {
+				cols := strings.Fields(trimmed[1:])
+				if len(cols) > 0 {
+					current.props[cols[0]] = strings.Join(cols[1:], " ")
+				}
+			}
+			continue
+		}
+
+		// name (class, handle)
+		open := strings.LastIndex(trimmed, " (")
+		comma := strings.LastIndex(trimmed, ",")
+		if open == -1 || comma < open || !strings.HasSuffix(trimmed, ")") {
+			continue
+		}
+		flush()
+		current = &node{
+			indent: len(line) - len(strings.TrimLeft(line, " \t")),
+			name:   trimmed[:open],
+			class:  trimmed[open+2 : comma],
+			props:  make(map[string]string),
+		}
+		for len(stack) > 0 && stack[len(stack)-1].indent >= current.indent {
+			stack = stack[:len(stack)-1]
+		}
+		stack = append(stack, current)
+	}
+	flush()
+	return sensors, nil
+}
+
+// piclSensor returns the sensor of a PICL node, or nil if it is not one. Its
+// status is its condition, or the threshold its reading crossed.
+func piclSensor(name, class string, props map[string]string) *sensor {
+	desc, ok := piclSensors[class]
+	if !ok {
+		return nil
+	}
+	sn := &sensor{name: name, kind: desc[0], unit: desc[2], status: "ok"}
+	if desc[1] != "" {
+		v, err := strconv.ParseFloat(props[desc[1]], 64)
+		if err != nil {
+			return nil
+		}
+		sn.value = &v
+		if desc[1] == "Speed" && strings.EqualFold(props["SpeedUnit"], "%") {
+			sn.unit = "percent"
+		}
+		threshold := func(prop string) (float64, bool) {
+			t, err := strconv.ParseFloat(props[prop], 64)
+			return t, err == nil
+		}
+		if t, ok := threshold("HighShutdownThreshold"); ok && v >= t {
+			sn.status = "critical"
+		} else if t, ok := threshold("LowShutdownThreshold"); ok && v <= t {
+			sn.status = "critical"
+		} else if t, ok := threshold("HighWarningThreshold"); ok && v >= t {
+			sn.status = "warning"
+		} else if t, ok := threshold("LowWarningThreshold"); ok && v <= t {
+			sn.status = "warning"
+		}
+	}
+	for _, prop := range []string{"Condition", "State"} {
+		if v := props[prop]; v != "" {
+			sn.status = strings.ToLower(v)
+			break
+		}
+	}
+	sn.ok = sn.status == "ok" || sn.status == "okay" || sn.status == "on"
+	return sn
+}
+
+func sensorsRunner(timeout time.Duration, command string, args ...string) ([]byte, error) {
+	bin, err := exec.LookPath(command)
+	if err != nil {
+		return nil, err
+	}
+	c := exec.Command(bin, args...)
+	return CombinedOutputTimeout(c, timeout)
+}
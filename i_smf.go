@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// SvcsRunner runs svcs(1) with the given arguments and returns its output.
+// It can be replaced with a mocked function for unit test purposes.
+type SvcsRunner func(timeout time.Duration, args ...string) ([]byte, error)
+
+// smfStates are the service states reported by svcs(1), with the code of
+// each state, ordered from healthy to broken.
+var smfStates = []string{
+	"online",
+	"legacy_run",
+	"disabled",
+	"uninitialized",
+	"offline",
+	"degraded",
+	"maintenance",
+}
+
+// SMF reports the number of SMF service instances in each state and,
+// optionally, the state of every instance.
+type SMF struct {
+	Services   []string
+	PerService bool `toml:"per_service"`
+	Timeout    Duration
+
+	runSvcs SvcsRunner
+}
+
+func NewSMF() Input {
+	return &SMF{
+		PerService: true,
+		Timeout:    Duration{Duration: 5 * time.Second},
+		runSvcs:    svcsRunner,
+	}
+}
+
+func (_ *SMF) Description() string {
+	return "Read the state of SMF services"
+}
+
+var smfSampleConfig = `
+  ## Services to report on, as globs matched against the FMRI, a '*' does
+  ## not match the '/' separators. If empty, all services are reported.
+  # services = ["svc:/network/ssh:*", "svc:/system/filesystem/*:default"]
+  ## Report the state of each service as well as the count per state
+  # per_service = true
+  ## Timeout for the svcs command to complete
+  # timeout = "5s"
+`
+
+func (_ *SMF) SampleConfig() string {
+	return smfSampleConfig
+}
+
+// Init checks the globs of the services.
+func (s *SMF) Init() error {
+	return checkGlobs("services", s.Services)
+}
+
+func (s *SMF) Gather(acc Accumulator) error {
+	run := s.runSvcs
+	if run == nil {
+		run = svcsRunner
+	}
+	out, err := run(s.Timeout.Duration, "-aH", "-o", "state,fmri")
+	if err != nil {
+		return fmt.Errorf("error getting SMF services: %s", err)
+	}
+
+	now := time.Now()
+	counts := make(map[string]interface{}, len(smfStates)+1)
+	for _, state := range smfStates {
+		counts[state] = int64(0)
+	}
+	total := int64(0)
+
+	for _, line := range strings.Split(string(out), "\n") {
+		cols := strings.Fields(line)
+		if len(cols) != 2 {
+			continue
+		}
+		fmri := cols[1]
+		if len(s.Services) != 0 && !matchesAny(fmri, s.Services) {
+			continue
+		}
+		// services moving to another state are flagged with a '*'
+		state := strings.TrimSuffix(cols[0], "*")
+		transitioning := state != cols[0]
+
+		if n, ok := counts[state].(int64); ok {
+			counts[state] = n + 1
+		}
+		total++
+
+		if s.PerService {
+			fields := map[string]interface{}{
+				"state":         state,
+				"state_code":    smfStateCode(state),
+				"transitioning": transitioning,
+			}
+			tags := map[string]string{"fmri": fmri}
+			acc.AddGauge("smf_service", fields, tags, now)
+		}
+	}
+
+	counts["total"] = total
+	acc.AddGauge("smf", counts, nil, now)
+	return nil
+}
+
+// smfStateCode returns the index of state in smfStates, or -1 for a state
+// unknown to this plugin.
+func smfStateCode(state string) int64 {
+	for i, s := range smfStates {
+		if s == state {
+			return int64(i)
+		}
+	}
+	return -1
+}
+
+func svcsRunner(timeout time.Duration, args ...string) ([]byte, error) {
+	bin, err := exec.LookPath("svcs")
+	if err != nil {
+		return nil, err
+	}
+	c := exec.Command(bin, args...)
+	return CombinedOutputTimeout(c, timeout)
+}
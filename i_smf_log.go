@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// smfLogPatterns are the default patterns counted in the SMF logs, by field.
+var smfLogPatterns = map[string]string{
+	"errors":          `(?i)\berror\b`,
+	"method_failures": `Method "[^"]+" (exited with status [1-9]|failed)`,
+	"restarts":        `Stopping because (all processes in service exited|process dumped core|process received fatal signal)`,
+	"starts":          `Executing start method`,
+}
+
+// SMFLog follows the logs of the SMF services and counts the lines matching
+// each of its patterns, per service. The logs are discovered on every
+// gather and mapped to the FMRI of their service with svcs(1).
+type SMFLog struct {
+	LogDir      string `toml:"log_dir"`
+	Services    []string
+	Patterns    map[string]string
+	MaxLineSize int `toml:"max_line_size"`
+	Timeout     Duration
+
+	patterns map[string]*regexp.Regexp
+
+	// the followed logs and the lines matching each pattern since they were
+	// first followed, by path
+	tailers map[string]*tailer
+	counts  map[string]map[string]int64
+
+	// the FMRIs of the services, by the name of their log, "" for the logs
+	// of the services that no longer exist
+	fmris map[string]string
+
+	runSvcs SvcsRunner
+}
+
+func NewSMFLog() Input {
+	return &SMFLog{
+		LogDir:      "/var/svc/log",
+		MaxLineSize: 64 * 1024,
+		Timeout:     Duration{Duration: 5 * time.Second},
+		runSvcs:     svcsRunner,
+	}
+}
+
+func (_ *SMFLog) Description() string {
+	return "Count the error pattern matches in the SMF service logs"
+}
+
+var smfLogSampleConfig = `
+  ## Directory of the service logs, named after the FMRI of their service
+  # log_dir = "/var/svc/log"
+  ## Services to report on, as globs matched against the FMRI, a '*' does
+  ## not match the '/' separators. If empty, all services are reported.
+  # services = ["svc:/network/*:default"]
+  ## Longer lines are dropped.
+  # max_line_size = 65536
+  ## Timeout for the svcs command to complete
+  # timeout = "5s"
+
+  ## Regular expressions counted in the logs, by field. The lines logged
+  ## before the first gather are not counted, the counts are cumulative
+  ## from then on. When not set, these are counted:
+  # [inputs.smf_log.patterns]
+  #   errors = '(?i)\berror\b'
+  #   method_failures = 'Method "[^"]+" (exited with status [1-9]|failed)'
+  #   restarts = 'Stopping because (all processes in service exited|process dumped core|process received fatal signal)'
+  #   starts = 'Executing start method'
+`
+
+func (_ *SMFLog) SampleConfig() string {
+	return smfLogSampleConfig
+}
+
+// Init compiles the patterns.
+func (s *SMFLog) Init() error {
+	if len(s.Patterns) == 0 {
+		s.Patterns = smfLogPatterns
+	}
+	s.patterns = make(map[string]*regexp.Regexp, len(s.Patterns))
+	for field, pattern := range s.Patterns {
+		if field == "lines" {
+			return fmt.Errorf("pattern name %q is reserved", field)
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid pattern %q: %s", pattern, err)
+		}
+		s.patterns[field] = re
+	}
+	return checkGlobs("services", s.Services)
+}
+
+func (s *SMFLog) Gather(acc Accumulator) error {
+	if s.patterns == nil {
+		if err := s.Init(); err != nil {
+			return err
+		}
+	}
+	first := s.tailers == nil
+	if first {
+		s.tailers = make(map[string]*tailer)
+		s.counts = make(map[string]map[string]int64)
+	}
+
+	paths, err := filepath.Glob(filepath.Join(s.LogDir, "*.log"))
+	if err != nil {
+		return err
+	}
+	if s.unknownLogs(paths) {
+		if err := s.discover(paths); err != nil {
+			acc.AddError(err)
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, path := range paths {
+		fmri, ok := s.fmris[filepath.Base(path)]
+		if !ok || fmri == "" || len(s.Services) != 0 && !matchesAny(fmri, s.Services) {
+			continue
+		}
+		seen[path] = true
+
+		tl, ok := s.tailers[path]
+		if !ok {
+			// logs appearing after the first gather are of new services,
+			// and counted from their beginning
+			tl, err = openTailer(path, first)
+			if err != nil {
+				acc.AddError(NewGatherError(err, "path", path))
+				continue
+			}
+			s.tailers[path] = tl
+			s.counts[path] = make(map[string]int64, len(s.patterns)+1)
+		}
+		counts := s.counts[path]
+		err := tl.follow("inputs.smf_log", path, s.MaxLineSize, func(line string) {
+			counts["lines"]++
+			for field, re := range s.patterns {
+				if re.MatchString(line) {
+					counts[field]++
+				}
+			}
+		})
+		if err != nil {
+			acc.AddError(NewGatherError(err, "path", path))
+		}
+
+		fields := make(map[string]interface{}, len(s.patterns)+1)
+		fields["lines"] = counts["lines"]
+		for field := range s.patterns {
+			fields[field] = counts[field]
+		}
+		acc.AddCounter("smf_log", fields, map[string]string{"fmri": fmri})
+	}
+
+	for path, tl := range s.tailers {
+		if !seen[path] {
+			tl.file.Close()
+			delete(s.tailers, path)
+			delete(s.counts, path)
+		}
+	}
+	return nil
+}
+
+// unknownLogs returns true if one of the logs is not mapped to a service.
+func (s *SMFLog) unknownLogs(paths []string) bool {
+	for _, path := range paths {
+		if _, ok := s.fmris[filepath.Base(path)]; !ok {
+			return true
+		}
+	}
+	return false
+}
+
+// discover maps the logs to the FMRIs of the service instances.
+func (s *SMFLog) discover(paths []string) error {
+	run := s.runSvcs
+	if run == nil {
+		run = svcsRunner
+	}
+	out, err := run(s.Timeout.Duration, "-aH", "-o", "fmri")
+	if err != nil {
+		return fmt.Errorf("error getting SMF services: %s", err)
+	}
+
+	s.fmris = make(map[string]string)
+	for _, fmri := range strings.Fields(string(out)) {
+		if name := smfLogName(fmri); name != "" {
+			s.fmris[name] = fmri
+		}
+	}
+	for _, path := range paths {
+		if _, ok := s.fmris[filepath.Base(path)]; !ok {
+			s.fmris[filepath.Base(path)] = ""
+		}
+	}
+	return nil
+}
+
+// smfLogName returns the name of the log of the service instance, its FMRI
+// without the scheme and with the '/' replaced by '-', such as
+// network-ssh:default.log for svc:/network/ssh:default, or "" for the
+// legacy services, which have no log.
+func smfLogName(fmri string) string {
+	if !strings.HasPrefix(fmri, "svc:/") {
+		return ""
+	}
+	return strings.Replace(strings.TrimPrefix(fmri, "svc:/"), "/", "-", -1) + ".log"
+}
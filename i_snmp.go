@@ -0,0 +1,487 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SNMPRunner runs one of the net-snmp commands, snmpget, snmpwalk or
+// snmpbulkwalk, with the given arguments and returns its output. It can be
+// replaced with a mocked function for unit test purposes.
+type SNMPRunner func(
+	timeout time.Duration,
+	command string,
+	args ...string,
+) ([]byte, error)
+
+// SNMP polls agents, ie, storage arrays and switches, with the net-snmp
+// commands. The scalar fields of each agent make a metric, and each table
+// a metric per row, its columns being walked with GetBulk requests but with
+// SNMP v1. OIDs are numeric, the values being converted to integers or
+// floats when they are numbers.
+type SNMP struct {
+	Agents         []string
+	Version        int
+	Community      string
+	Timeout        Duration
+	Retries        int
+	CommandTimeout Duration `toml:"command_timeout"`
+	MaxRepetitions int      `toml:"max_repetitions"`
+
+	SecName      string `toml:"sec_name"`
+	SecLevel     string `toml:"sec_level"`
+	AuthProtocol string `toml:"auth_protocol"`
+	AuthPassword string `toml:"auth_password"`
+	PrivProtocol string `toml:"priv_protocol"`
+	PrivPassword string `toml:"priv_password"`
+	ContextName  string `toml:"context_name"`
+
+	Name   string
+	Fields []snmpField `toml:"field"`
+	Tables []snmpTable `toml:"table"`
+
+	runSNMP SNMPRunner
+}
+
+type snmpField struct {
+	Name string
+	Oid  string
+	// IsTag makes the value a tag rather than a field
+	IsTag bool `toml:"is_tag"`
+	// Conversion is one of "float", "float(N)" dividing by 10^N, "int",
+	// "hwaddr" or "ipaddr"
+	Conversion string
+}
+
+type snmpTable struct {
+	Name        string
+	InheritTags []string    `toml:"inherit_tags"`
+	IndexAsTag  bool        `toml:"index_as_tag"`
+	Fields      []snmpField `toml:"field"`
+}
+
+func NewSNMP() Input {
+	return &SNMP{
+		Version:        2,
+		Community:      "public",
+		Timeout:        Duration{Duration: 5 * time.Second},
+		Retries:        3,
+		CommandTimeout: Duration{Duration: 60 * time.Second},
+		MaxRepetitions: 10,
+		Name:           "snmp",
+		runSNMP:        snmpRunner,
+	}
+}
+
+func (_ *SNMP) Description() string {
+	return "Poll SNMP agents, reading scalars and walking tables"
+}
+
+var snmpSampleConfig = `
+  ## Agents to poll, as host or host:port, port 161 by default
+  agents = ["10.1.2.3", "array01.example.com:161"]
+  ## SNMP version, 1, 2 for v2c or 3
+  version = 2
+  ## Community of v1 and v2c
+  community = "public"
+
+  ## Timeout of each request, and the times it is retried
+  timeout = "5s"
+  retries = 3
+  ## Time a get or the walk of a table may take in all
+  # command_timeout = "60s"
+  ## Rows asked for in each GetBulk request when walking tables
+  # max_repetitions = 10
+
+  ## SNMP v3 user security model
+  # sec_name = "telegraf"
+  ## noAuthNoPriv, authNoPriv or authPriv
+  # sec_level = "authPriv"
+  ## MD5, SHA, SHA-224, SHA-256, SHA-384 or SHA-512
+  # auth_protocol = "SHA"
+  # auth_password = "authpass"
+  ## DES or AES
+  # priv_protocol = "AES"
+  # priv_password = "privpass"
+  # context_name = ""
+
+  ## Measurement of the scalar fields
+  name = "system"
+  [[inputs.snmp.field]]
+    name = "sysName"
+    oid = ".1.3.6.1.2.1.1.5.0"
+    is_tag = true
+  [[inputs.snmp.field]]
+    name = "uptime"
+    oid = ".1.3.6.1.2.1.1.3.0"
+
+  ## A metric per row of the table, tagged by the is_tag columns and the
+  ## inherit_tags of the scalar fields
+  [[inputs.snmp.table]]
+    name = "interface"
+    inherit_tags = ["sysName"]
+    ## Tag the rows with their index too
+    # index_as_tag = false
+    [[inputs.snmp.table.field]]
+      name = "ifDescr"
+      oid = ".1.3.6.1.2.1.2.2.1.2"
+      is_tag = true
+    [[inputs.snmp.table.field]]
+      name = "ifHCInOctets"
+      oid = ".1.3.6.1.2.1.31.1.1.1.6"
+    [[inputs.snmp.table.field]]
+      name = "ifPhysAddress"
+      oid = ".1.3.6.1.2.1.2.2.1.6"
+      conversion = "hwaddr"
+`
+
+func (_ *SNMP) SampleConfig() string {
+	return snmpSampleConfig
+}
+
+// Init checks the version, security level and OIDs, and normalizes the OIDs
+// to begin with a dot as they are printed by the net-snmp commands.
+func (s *SNMP) Init() error {
+	switch s.Version {
+	case 1, 2:
+	case 3:
+		if s.SecName == "" {
+			return fmt.Errorf("sec_name is required with version 3")
+		}
+		switch s.SecLevel {
+		case "", "noAuthNoPriv", "authNoPriv", "authPriv":
+		default:
+			return fmt.Errorf("invalid sec_level %q", s.SecLevel)
+		}
+	default:
+		return fmt.Errorf("invalid version %d, expected 1, 2 or 3", s.Version)
+	}
+
+	if err := snmpNormalize(s.Fields); err != nil {
+		return err
+	}
+	for i := range s.Tables {
+		if s.Tables[i].Name == "" {
+			return fmt.Errorf("a table has no name")
+		}
+		if err := snmpNormalize(s.Tables[i].Fields); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func snmpNormalize(fields []snmpField) error {
+	for i := range fields {
+		f := &fields[i]
+		oid := strings.TrimPrefix(f.Oid, ".")
+		for _, part := range strings.Split(oid, ".") {
+			if _, err := strconv.ParseUint(part, 10, 32); err != nil {
+				return fmt.Errorf("invalid oid %q, only numeric oids are supported",
+					f.Oid)
+			}
+		}
+		f.Oid = "." + oid
+		if f.Name == "" {
+			f.Name = f.Oid
+		}
+		if _, err := snmpConvert(f.Conversion, "0"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SNMP) Gather(acc Accumulator) error {
+	var wg sync.WaitGroup
+	for _, agent := range s.Agents {
+		wg.Add(1)
+		go func(agent string) {
+			defer wg.Done()
+			if err := s.gatherAgent(agent, acc); err != nil {
+				acc.AddError(NewGatherError(err, "agent", agent))
+			}
+		}(agent)
+	}
+	wg.Wait()
+	return nil
+}
+
+func (s *SNMP) gatherAgent(agent string, acc Accumulator) error {
+	run := s.runSNMP
+	if run == nil {
+		run = snmpRunner
+	}
+	host := agent
+	if h, _, err := net.SplitHostPort(agent); err == nil {
+		host = h
+	}
+
+	topTags := map[string]string{"agent_host": host}
+	if len(s.Fields) > 0 {
+		oids := make([]string, len(s.Fields))
+		for i, f := range s.Fields {
+			oids[i] = f.Oid
+		}
+		out, err := run(s.CommandTimeout.Duration, "snmpget",
+			append(s.args(agent), oids...)...)
+		if err != nil {
+			return err
+		}
+		values := parseSNMPOutput(string(out))
+
+		fields := make(map[string]interface{})
+		for _, f := range s.Fields {
+			value, ok := values[f.Oid]
+			if !ok {
+				continue
+			}
+			if err := snmpSet(f, value, fields, topTags); err != nil {
+				return err
+			}
+		}
+		if len(fields) > 0 {
+			acc.AddFields(s.Name, fields, topTags)
+		}
+	}
+
+	for _, table := range s.Tables {
+		if err := s.gatherTable(run, agent, table, topTags, acc); err != nil {
+			return fmt.Errorf("table %s: %s", table.Name, err)
+		}
+	}
+	return nil
+}
+
+// gatherTable walks each column of the table, the rows being told apart by
+// the index following the OID of the column.
+func (s *SNMP) gatherTable(
+	run SNMPRunner,
+	agent string,
+	table snmpTable,
+	topTags map[string]string,
+	acc Accumulator,
+) error {
+	command, walkArgs := "snmpbulkwalk", []string{fmt.Sprintf("-Cr%d", s.MaxRepetitions)}
+	if s.Version == 1 {
+		command, walkArgs = "snmpwalk", nil
+	}
+
+	type row struct {
+		fields map[string]interface{}
+		tags   map[string]string
+	}
+	rows := make(map[string]*row)
+	var order []string
+	for _, f := range table.Fields {
+		args := append(s.args(agent, walkArgs...), f.Oid)
+		out, err := run(s.CommandTimeout.Duration, command, args...)
+		if err != nil {
+			return err
+		}
+		for oid, value := range parseSNMPOutput(string(out)) {
+			if !strings.HasPrefix(oid, f.Oid+".") {
+				continue
+			}
+			index := oid[len(f.Oid)+1:]
+			r, ok := rows[index]
+			if !ok {
+				r = &row{
+					fields: make(map[string]interface{}),
+					tags:   map[string]string{"agent_host": topTags["agent_host"]},
+				}
+				for _, k := range table.InheritTags {
+					if v, ok := topTags[k]; ok {
+						r.tags[k] = v
+					}
+				}
+				if table.IndexAsTag {
+					r.tags["index"] = index
+				}
+				rows[index] = r
+				order = append(order, index)
+			}
+			if err := snmpSet(f, value, r.fields, r.tags); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, index := range order {
+		if r := rows[index]; len(r.fields) > 0 {
+			acc.AddFields(table.Name, r.fields, r.tags)
+		}
+	}
+	return nil
+}
+
+func snmpSet(
+	f snmpField,
+	value string,
+	fields map[string]interface{},
+	tags map[string]string,
+) error {
+	v, err := snmpConvert(f.Conversion, value)
+	if err != nil {
+		return fmt.Errorf("%s: %s", f.Name, err)
+	}
+	if f.IsTag {
+		tags[f.Name] = fmt.Sprint(v)
+	} else {
+		fields[f.Name] = v
+	}
+	return nil
+}
+
+// args are the options common to all the commands followed by the given
+// ones, ending with the agent.
+func (s *SNMP) args(agent string, options ...string) []string {
+	args := []string{
+		"-t", strconv.FormatFloat(s.Timeout.Duration.Seconds(), 'f', -1, 64),
+		"-r", strconv.Itoa(s.Retries),
+		// numeric OIDs, enums and timeticks, no types nor units
+		"-On", "-Oe", "-Ot", "-OQ", "-OU",
+	}
+	switch s.Version {
+	case 1:
+		args = append(args, "-v", "1", "-c", s.Community)
+	case 2:
+		args = append(args, "-v", "2c", "-c", s.Community)
+	case 3:
+		args = append(args, "-v", "3", "-u", s.SecName)
+		if s.SecLevel != "" {
+			args = append(args, "-l", s.SecLevel)
+		}
+		if s.AuthProtocol != "" {
+			args = append(args, "-a", s.AuthProtocol, "-A", s.AuthPassword)
+		}
+		if s.PrivProtocol != "" {
+			args = append(args, "-x", s.PrivProtocol, "-X", s.PrivPassword)
+		}
+		if s.ContextName != "" {
+			args = append(args, "-n", s.ContextName)
+		}
+	}
+	return append(append(args, options...), agent)
+}
+
+// parseSNMPOutput returns the values by OID of the lines printed with -On
+// -OQ, ie, `.1.3.6.1.2.1.1.5.0 = "host"`, strings spanning several lines.
+// The OIDs the agent has no value for are left out.
+func parseSNMPOutput(out string) map[string]string {
+	values := make(map[string]string)
+	var last string
+	for _, line := range strings.Split(out, "\n") {
+		parts := strings.SplitN(line, " = ", 2)
+		if len(parts) != 2 || !strings.HasPrefix(parts[0], ".") {
+			if last != "" {
+				values[last] += "\n" + line
+			}
+			continue
+		}
+		last = ""
+		value := parts[1]
+		if strings.HasPrefix(value, "No Such ") ||
+			strings.HasPrefix(value, "No more variables") {
+			continue
+		}
+		last = parts[0]
+		values[last] = value
+	}
+	for oid, value := range values {
+		values[oid] = strings.TrimRight(value, "\n")
+	}
+	return values
+}
+
+// snmpConvert converts the value as printed by the net-snmp commands.
+func snmpConvert(conversion, value string) (interface{}, error) {
+	if strings.HasPrefix(value, `"`) {
+		if s, err := strconv.Unquote(value); err == nil {
+			value = s
+		} else {
+			value = strings.Trim(value, `"`)
+		}
+	}
+
+	switch {
+	case conversion == "":
+		if v, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return v, nil
+		}
+		if v, err := strconv.ParseUint(value, 10, 64); err == nil {
+			return v, nil
+		}
+		if v, err := strconv.ParseFloat(value, 64); err == nil {
+			return v, nil
+		}
+		return value, nil
+	case conversion == "int":
+		return strconv.ParseInt(value, 10, 64)
+	case conversion == "float":
+		return strconv.ParseFloat(value, 64)
+	case strings.HasPrefix(conversion, "float(") && strings.HasSuffix(conversion, ")"):
+		places, err := strconv.Atoi(conversion[6 : len(conversion)-1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid conversion %q", conversion)
+		}
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i < places; i++ {
+			v /= 10
+		}
+		return v, nil
+	case conversion == "hwaddr", conversion == "ipaddr":
+		// octet strings are printed as hex bytes, ie, "00 14 4F 8A 12 34"
+		if b, ok := snmpHexBytes(value); ok {
+			if conversion == "ipaddr" {
+				return net.IP(b).String(), nil
+			}
+			return net.HardwareAddr(b).String(), nil
+		}
+		return value, nil
+	}
+	return nil, fmt.Errorf("invalid conversion %q", conversion)
+}
+
+func snmpHexBytes(value string) ([]byte, bool) {
+	var b bytes.Buffer
+	for _, part := range strings.Fields(value) {
+		n, err := strconv.ParseUint(part, 16, 8)
+		if err != nil || len(part) != 2 {
+			return nil, false
+		}
+		b.WriteByte(byte(n))
+	}
+	return b.Bytes(), b.Len() > 0
+}
+
+func snmpRunner(
+	timeout time.Duration,
+	command string,
+	args ...string,
+) ([]byte, error) {
+	bin, err := exec.LookPath(command)
+	if err != nil {
+		return nil, err
+	}
+	var stdout, stderr bytes.Buffer
+	c := exec.Command(bin, args...)
+	c.Stdout = &stdout
+	c.Stderr = &stderr
+	if err := RunTimeout(c, timeout); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%s: %s", command, msg)
+		}
+		return nil, fmt.Errorf("%s: %s", command, err)
+	}
+	return stdout.Bytes(), nil
+}
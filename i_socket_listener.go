@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SocketListener receives metrics in any data format over TCP, UDP, or unix
+// stream or datagram sockets. Streams are parsed line by line and datagrams
+// one at a time. It runs as a service and the metrics received in between
+// are added on each gather.
+type SocketListener struct {
+	ServiceAddress string   `toml:"service_address"`
+	MaxConnections int      `toml:"max_connections"`
+	ReadTimeout    Duration `toml:"read_timeout"`
+	MaxLineSize    int      `toml:"max_line_size"`
+	MaxPending     int      `toml:"max_pending"`
+	SocketMode     string   `toml:"socket_mode"`
+
+	parser  Parser
+	binding *LocalBinding
+
+	// network and address of the service_address
+	network string
+	address string
+
+	mu       sync.Mutex
+	pending  []Metric
+	listener net.Listener
+	conn     net.PacketConn
+	conns    map[net.Conn]bool
+	wg       sync.WaitGroup
+	// the error the socket stopped on, returned by the next Gather so that
+	// the agent restarts the listener
+	failed error
+
+	// counts metrics received while max_pending metrics were waiting
+	droppedOverflow Stat
+}
+
+func NewSocketListener() Input {
+	return &SocketListener{
+		ServiceAddress:  "tcp://:8094",
+		MaxLineSize:     64 * 1024,
+		MaxPending:      100000,
+		droppedOverflow: RegisterDropped("receive", "inputs.socket_listener", "overflow"),
+	}
+}
+
+func (_ *SocketListener) Description() string {
+	return "Receive metrics in any data format over a network or unix socket"
+}
+
+var socketListenerSampleConfig = `
+  ## Address to listen on, as <network>://<address> with network one of
+  ## tcp, tcp4, tcp6, udp, udp4, udp6, unix or unixgram, ie:
+  ##   service_address = "tcp://:8094"
+  ##   service_address = "udp://127.0.0.1:8094"
+  ##   service_address = "unix:///var/run/telegraf/telegraf.sock"
+  service_address = "tcp://:8094"
+
+  ## Permissions of the unix socket, in octal
+  # socket_mode = "0660"
+
+  ## Connections accepted at once on stream sockets, 0 for no limit
+  # max_connections = 0
+  ## Connections idle for longer are closed, 0 to never close them
+  # read_timeout = "0s"
+  ## Longer lines and datagrams are dropped
+  # max_line_size = 65536
+  ## Metrics kept between two gathers, further metrics are dropped
+  # max_pending = 100000
+
+  ## Data format to consume.
+  data_format = "influx"
+`
+
+func (_ *SocketListener) SampleConfig() string {
+	return socketListenerSampleConfig
+}
+
+func (s *SocketListener) SetParser(parser Parser) {
+	s.parser = parser
+}
+
+// SetLocalBinding listens on the local address instead of the host of the
+// service_address, over tcp and udp only.
+func (s *SocketListener) SetLocalBinding(b *LocalBinding) {
+	s.binding = b
+}
+
+func (s *SocketListener) Gather(acc Accumulator) error {
+	s.mu.Lock()
+	pending := s.pending
+	s.pending = nil
+	failed := s.failed
+	s.mu.Unlock()
+
+	for _, m := range pending {
+		acc.AddFields(m.Name(), m.Fields(), m.Tags(), m.Time())
+	}
+	return failed
+}
+
+// Init parses the service_address and the socket_mode.
+func (s *SocketListener) Init() error {
+	i := strings.Index(s.ServiceAddress, "://")
+	if i == -1 {
+		return fmt.Errorf("invalid service_address %q, expected <network>://<address>",
+			s.ServiceAddress)
+	}
+	s.network, s.address = s.ServiceAddress[:i], s.ServiceAddress[i+3:]
+	switch s.network {
+	case "tcp", "tcp4", "tcp6", "unix", "udp", "udp4", "udp6", "unixgram":
+	default:
+		return fmt.Errorf("unknown network %q of service_address %s",
+			s.network, s.ServiceAddress)
+	}
+	if s.SocketMode != "" {
+		if _, err := strconv.ParseUint(s.SocketMode, 8, 32); err != nil {
+			return fmt.Errorf("invalid socket_mode %q", s.SocketMode)
+		}
+	}
+	return nil
+}
+
+func (s *SocketListener) Start(_ Accumulator) error {
+	network := s.network
+	address, err := s.binding.ListenAddress(network, s.address)
+	if err != nil {
+		return fmt.Errorf("error listening on %s: %s", s.ServiceAddress, err)
+	}
+	if network == "unix" || network == "unixgram" {
+		// left behind by an agent which was killed
+		os.Remove(address)
+	}
+
+	s.conns = make(map[net.Conn]bool)
+	s.failed = nil
+	switch network {
+	case "tcp", "tcp4", "tcp6", "unix":
+		l, err := net.Listen(network, address)
+		if err != nil {
+			return fmt.Errorf("error listening on %s: %s", s.ServiceAddress, err)
+		}
+		s.listener = l
+		s.wg.Add(1)
+		go s.accept(l)
+	case "udp", "udp4", "udp6", "unixgram":
+		conn, err := net.ListenPacket(network, address)
+		if err != nil {
+			return fmt.Errorf("error listening on %s: %s", s.ServiceAddress, err)
+		}
+		s.conn = conn
+		s.wg.Add(1)
+		go s.readPackets(conn)
+	}
+
+	if s.SocketMode != "" && (network == "unix" || network == "unixgram") {
+		mode, _ := strconv.ParseUint(s.SocketMode, 8, 32)
+		if err := os.Chmod(address, os.FileMode(mode)); err != nil {
+			s.Stop()
+			return fmt.Errorf("error setting the socket_mode of %s: %s", address, err)
+		}
+	}
+	log.Printf("I! Started the socket listener on %s", s.ServiceAddress)
+	return nil
+}
+
+func (s *SocketListener) Stop() {
+	s.mu.Lock()
+	if s.conn != nil {
+		s.conn.Close()
+		// unlike listeners, datagram sockets are not unlinked on close
+		if addr, ok := s.conn.LocalAddr().(*net.UnixAddr); ok {
+			os.Remove(addr.Name)
+		}
+		s.conn = nil
+	}
+	if s.listener != nil {
+		s.listener.Close()
+		s.listener = nil
+	}
+	for c := range s.conns {
+		c.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+func (s *SocketListener) accept(l net.Listener) {
+	defer s.wg.Done()
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			s.fail(err)
+			return
+		}
+
+		s.mu.Lock()
+		if s.MaxConnections > 0 && len(s.conns) >= s.MaxConnections {
+			s.mu.Unlock()
+			log.Printf("W! [inputs.socket_listener] refusing connection from %s, "+
+				"max_connections reached", c.RemoteAddr())
+			c.Close()
+			continue
+		}
+		s.conns[c] = true
+		s.mu.Unlock()
+		s.wg.Add(1)
+		go s.readStream(c)
+	}
+}
+
+func (s *SocketListener) readStream(c net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+		c.Close()
+	}()
+
+	scanner := bufio.NewScanner(c)
+	scanner.Buffer(make([]byte, 4096), s.MaxLineSize)
+	for {
+		if s.ReadTimeout.Duration > 0 {
+			c.SetReadDeadline(time.Now().Add(s.ReadTimeout.Duration))
+		}
+		if !scanner.Scan() {
+			break
+		}
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		m, err := s.parser.ParseLine(line)
+		if err != nil {
+			log.Printf("E! [inputs.socket_listener] %s: %s", remoteName(c), err)
+			continue
+		}
+		if m != nil {
+			s.receive([]Metric{m})
+		}
+	}
+	if err := scanner.Err(); err != nil &&
+		!strings.HasSuffix(err.Error(), "use of closed network connection") {
+		log.Printf("E! [inputs.socket_listener] %s: %s", remoteName(c), err)
+	}
+}
+
+func (s *SocketListener) readPackets(conn net.PacketConn) {
+	defer s.wg.Done()
+	// one more byte to tell datagrams of max_line_size from longer ones
+	buf := make([]byte, s.MaxLineSize+1)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			s.fail(err)
+			return
+		}
+		if n > s.MaxLineSize {
+			log.Printf("W! [inputs.socket_listener] dropping datagram longer "+
+				"than %d bytes from %v", s.MaxLineSize, addr)
+			continue
+		}
+		metrics, err := s.parser.Parse(buf[:n])
+		if err != nil {
+			log.Printf("E! [inputs.socket_listener] %v: %s", addr, err)
+		}
+		s.receive(metrics)
+	}
+}
+
+// fail records the error the socket stopped on, unless closed by Stop.
+func (s *SocketListener) fail(err error) {
+	if strings.HasSuffix(err.Error(), "use of closed network connection") {
+		return
+	}
+	log.Printf("E! [inputs.socket_listener] %s", err)
+	s.mu.Lock()
+	s.failed = err
+	s.mu.Unlock()
+}
+
+func (s *SocketListener) receive(metrics []Metric) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if room := s.MaxPending - len(s.pending); len(metrics) > room {
+		if room < 0 {
+			room = 0
+		}
+		s.droppedOverflow.Incr(int64(len(metrics) - room))
+		metrics = metrics[:room]
+	}
+	s.pending = append(s.pending, metrics...)
+}
+
+// remoteName names the peer of a connection, unix sockets having no remote
+// address.
+func remoteName(c net.Conn) string {
+	if addr := c.RemoteAddr(); addr != nil && addr.String() != "" {
+		return addr.String()
+	}
+	return c.LocalAddr().String()
+}
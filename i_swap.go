@@ -2,11 +2,15 @@ package main
 
 import (
 	"os/exec"
+	"regexp"
 	"strings"
 	"strconv"
 	"fmt"
 )
 
+var swapSummary = regexp.MustCompile(
+	`(\d+)k bytes allocated \+ (\d+)k reserved = (\d+)k used, (\d+)k available`)
+
 type SwapStats struct {
 	ps PS
 }
@@ -23,65 +27,65 @@ func (s *SwapStats) Gather(acc Accumulator) error {
 	if err != nil {
 		return fmt.Errorf("error getting Swap info: %s", err.Error())
 	}
-	sout := string(output)
-	if sout != "" {
-		swapOutput := strings.Split(sout, "\n")
-		swapOutput = swapOutput[0: len(swapOutput)-1]
-
-		s := strings.Split(swapOutput[0], ",")
-
-		if len(s) == 2 {
-			kUsed := strings.Trim(strings.Replace(strings.Split(s[0], "=")[1], "k used", "", 1), " ")
-			kAvailable := strings.Trim(strings.Replace(s[1], "k available", "", 1), " ")
-
-			used, _ := strconv.ParseUint(kUsed, 10, 0)
-			avail, _ := strconv.ParseUint(kAvailable, 10, 0)
 
-			used = used * 1024
-			avail = avail * 1024
-
-			total := used + avail
-			free := total - used
-
-			var usedPercent float64
+	// total: 123456k bytes allocated + 7890k reserved = 131346k used, 987654k available
+	match := swapSummary.FindStringSubmatch(string(output))
+	if match == nil {
+		return nil
+	}
+	var kb [4]uint64
+	for i := range kb {
+		kb[i], _ = strconv.ParseUint(match[i+1], 10, 0)
+	}
+	allocated := kb[0] * 1024
+	reserved := kb[1] * 1024
+	used := kb[2] * 1024
+	avail := kb[3] * 1024
 
-			if total != 0 {
-				usedPercent = float64(used) / float64(total) * 100.0
-			}
+	total := used + avail
+	free := total - used
 
-			fieldsG := map[string]interface{}{
-				"total":        total,
-				"used":         used,
-				"free":         free,
-				"used_percent": usedPercent,
-			}
+	var usedPercent float64
 
-			acc.AddGauge("swap", fieldsG, nil)
+	if total != 0 {
+		usedPercent = float64(used) / float64(total) * 100.0
+	}
 
-			output, err = exec.Command("vmstat", "-S").CombinedOutput()
-			if err != nil {
-				return fmt.Errorf("error getting Swap Memory info: %s", err.Error())
-			}
+	// allocated is the anonymous memory backed by swap, reserved what was
+	// reserved for anonymous memory but is not allocated yet.
+	fieldsG := map[string]interface{}{
+		"total":        total,
+		"used":         used,
+		"free":         free,
+		"used_percent": usedPercent,
+		"allocated":    allocated,
+		"reserved":     reserved,
+		"available":    avail,
+	}
 
-			vmstats := string(output)
-			rows := strings.Split(vmstats, "\n")
-			rows = rows[1:]
-			data := make(map[string]uint64)
-			headers := strings.Fields(rows[0])
-			values := strings.Fields(rows[1])
-			for count := 0; count < len(headers); count++ {
-				v, _ := strconv.ParseUint(values[count], 10, 0)
-				data[headers[count]] = v
-			}
+	acc.AddGauge("swap", fieldsG, nil)
 
-			fieldsC := map[string]interface{}{
-				"in":  data["si"],
-				"out": data["so"],
-			}
+	output, err = exec.Command("vmstat", "-S").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("error getting Swap Memory info: %s", err.Error())
+	}
 
-			acc.AddCounter("swap", fieldsC, nil)
-		}
+	vmstats := string(output)
+	rows := strings.Split(vmstats, "\n")
+	rows = rows[1:]
+	data := make(map[string]uint64)
+	headers := strings.Fields(rows[0])
+	values := strings.Fields(rows[1])
+	for count := 0; count < len(headers); count++ {
+		v, _ := strconv.ParseUint(values[count], 10, 0)
+		data[headers[count]] = v
+	}
 
+	fieldsC := map[string]interface{}{
+		"in":  data["si"],
+		"out": data["so"],
 	}
+
+	acc.AddCounter("swap", fieldsC, nil)
 	return nil
 }
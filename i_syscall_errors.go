@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// solarisErrnos names the errno values of sys/errno.h.
+var solarisErrnos = map[int]string{
+	1: "EPERM", 2: "ENOENT", 3: "ESRCH", 4: "EINTR", 5: "EIO", 6: "ENXIO",
+	7: "E2BIG", 8: "ENOEXEC", 9: "EBADF", 10: "ECHILD", 11: "EAGAIN",
+	12: "ENOMEM", 13: "EACCES", 14: "EFAULT", 15: "ENOTBLK", 16: "EBUSY",
+	17: "EEXIST", 18: "EXDEV", 19: "ENODEV", 20: "ENOTDIR", 21: "EISDIR",
+	22: "EINVAL", 23: "ENFILE", 24: "EMFILE", 25: "ENOTTY", 26: "ETXTBSY",
+	27: "EFBIG", 28: "ENOSPC", 29: "ESPIPE", 30: "EROFS", 31: "EMLINK",
+	32: "EPIPE", 33: "EDOM", 34: "ERANGE", 35: "ENOMSG", 36: "EIDRM",
+	45: "EDEADLK", 46: "ENOLCK", 47: "ECANCELED", 48: "ENOTSUP",
+	49: "EDQUOT", 62: "ETIME", 71: "EPROTO", 77: "EBADMSG",
+	78: "ENAMETOOLONG", 79: "EOVERFLOW", 89: "ENOSYS", 90: "ELOOP",
+	91: "ERESTART", 93: "ENOTEMPTY", 94: "EUSERS", 95: "ENOTSOCK",
+	96: "EDESTADDRREQ", 97: "EMSGSIZE", 98: "EPROTOTYPE", 99: "ENOPROTOOPT",
+	120: "EPROTONOSUPPORT", 122: "EOPNOTSUPP", 124: "EAFNOSUPPORT",
+	125: "EADDRINUSE", 126: "EADDRNOTAVAIL", 127: "ENETDOWN",
+	128: "ENETUNREACH", 129: "ENETRESET", 130: "ECONNABORTED",
+	131: "ECONNRESET", 132: "ENOBUFS", 133: "EISCONN", 134: "ENOTCONN",
+	143: "ESHUTDOWN", 145: "ETIMEDOUT", 146: "ECONNREFUSED",
+	148: "EHOSTUNREACH", 149: "EALREADY", 150: "EINPROGRESS", 151: "ESTALE",
+}
+
+var syscallNamePattern = regexp.MustCompile(`^[a-z0-9_*?]+$`)
+
+// SyscallErrors counts the system calls failing, by process name, system
+// call and errno, tracing their return with the syscall DTrace provider for
+// duration on each gather.
+type SyscallErrors struct {
+	Syscalls  []string
+	Execnames []string
+	Errnos    []string
+	Duration  Duration
+	UsePfexec bool `toml:"use_pfexec"`
+
+	runDTrace DTraceRunner
+}
+
+func NewSyscallErrors() Input {
+	return &SyscallErrors{
+		Duration:  Duration{Duration: time.Second},
+		runDTrace: dtraceRunner,
+	}
+}
+
+func (_ *SyscallErrors) Description() string {
+	return "Count the failing system calls by process name and errno with DTrace"
+}
+
+var syscallErrorsSampleConfig = `
+  ## System calls traced, as DTrace probe function globs. If empty, all
+  ## system calls are traced.
+  # syscalls = ["open*", "write", "mkdir*", "so*"]
+  ## Process names reported, as globs. If empty, all are reported.
+  # execnames = ["oracle", "java"]
+  ## Errnos reported, by name. If empty, all are reported.
+  # errnos = ["ENOSPC", "EMFILE", "ENFILE", "EDQUOT"]
+
+  ## Time the system calls are traced for on each gather, it must be shorter
+  ## than the interval. The count field is the number of failures over it,
+  ## and the rate field the failures per second.
+  # duration = "1s"
+
+  ## dtrace needs the dtrace_kernel privilege when telegraf does not run as
+  ## root, set to true to run it through pfexec.
+  # use_pfexec = false
+`
+
+func (_ *SyscallErrors) SampleConfig() string {
+	return syscallErrorsSampleConfig
+}
+
+// Init checks the system call names and the errnos.
+func (s *SyscallErrors) Init() error {
+	for _, name := range s.Syscalls {
+		if !syscallNamePattern.MatchString(name) {
+			return fmt.Errorf("invalid system call %q", name)
+		}
+	}
+	for _, errno := range s.Errnos {
+		if solarisErrno(errno) == 0 {
+			return fmt.Errorf("unknown errno %q", errno)
+		}
+	}
+	if s.Duration.Duration <= 0 {
+		return fmt.Errorf("duration must be positive")
+	}
+	return checkGlobs("execnames", s.Execnames)
+}
+
+// script returns the D script counting the failing system calls, printing
+// the counts separated by tabs when dtrace exits.
+func (s *SyscallErrors) script() string {
+	probes := []string{"syscall:::return"}
+	if len(s.Syscalls) != 0 {
+		probes = probes[:0]
+		for _, name := range s.Syscalls {
+			probes = append(probes, "syscall::"+name+":return")
+		}
+	}
+	return fmt.Sprintf("%s /errno != 0/ { @errors[execname, probefunc, errno] = count(); }\n"+
+		"tick-%dms { exit(0); }\n"+
+		"dtrace:::END { printa(\"%%s\\t%%s\\t%%d\\t%%@d\\n\", @errors); }\n",
+		strings.Join(probes, ", "), s.Duration.Duration/time.Millisecond)
+}
+
+func (s *SyscallErrors) Gather(acc Accumulator) error {
+	run := s.runDTrace
+	if run == nil {
+		run = dtraceRunner
+	}
+	out, err := run(s.Duration.Duration+10*time.Second, s.UsePfexec,
+		"-q", "-n", s.script())
+	if err != nil {
+		return fmt.Errorf("error running dtrace: %s: %s", err,
+			strings.TrimSpace(string(out)))
+	}
+
+	now := time.Now()
+	for _, line := range strings.Split(string(out), "\n") {
+		// execname<TAB>syscall<TAB>errno<TAB>count
+		cols := strings.Split(strings.TrimSpace(line), "\t")
+		if len(cols) != 4 {
+			continue
+		}
+		errno, err := strconv.Atoi(cols[2])
+		if err != nil {
+			continue
+		}
+		count, err := strconv.ParseInt(cols[3], 10, 64)
+		if err != nil {
+			continue
+		}
+		name := solarisErrnos[errno]
+		if name == "" {
+			name = "errno" + cols[2]
+		}
+		if len(s.Execnames) != 0 && !matchesAny(cols[0], s.Execnames) ||
+			len(s.Errnos) != 0 && !sliceContains(name, s.Errnos) {
+			continue
+		}
+
+		acc.AddGauge("syscall_errors", map[string]interface{}{
+			"count": count,
+			"rate":  float64(count) / s.Duration.Duration.Seconds(),
+		}, map[string]string{
+			"execname": cols[0],
+			"syscall":  cols[1],
+			"errno":    name,
+		}, now)
+	}
+	return nil
+}
+
+// solarisErrno returns the value of the named errno, 0 if it is unknown.
+func solarisErrno(name string) int {
+	for errno, n := range solarisErrnos {
+		if n == name {
+			return errno
+		}
+	}
+	return 0
+}
@@ -0,0 +1,503 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// syslogMaxMessageSize is the largest message read from a connection or a
+// datagram, RFC 5425 requires receivers to handle 8192 bytes at least.
+const syslogMaxMessageSize = 64 * 1024
+
+var syslogSeverities = []string{
+	"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug",
+}
+
+var syslogFacilities = []string{
+	"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news",
+	"uucp", "cron", "authpriv", "ftp", "ntp", "security", "console",
+	"solaris-cron", "local0", "local1", "local2", "local3", "local4",
+	"local5", "local6", "local7",
+}
+
+// Syslog receives syslog messages over UDP, TCP or TCP with TLS, in the
+// RFC 5424 or the legacy BSD (RFC 3164) format, as sent by the syslogd of
+// Solaris. It runs as a service and the messages received in between are
+// added on each gather, as "syslog" metrics tagged by severity, facility,
+// hostname, appname and the source address of the sender.
+//
+// Messages over TCP are framed with octet counting (RFC 6587), or else end
+// with a newline, which is told apart by the first byte of each message.
+type Syslog struct {
+	Server      string
+	ReadTimeout Duration `toml:"read_timeout"`
+	MaxPending  int      `toml:"max_pending"`
+	TLSCert     string   `toml:"tls_cert"`
+	TLSKey      string   `toml:"tls_key"`
+	TLSCA       string   `toml:"tls_ca"`
+
+	// scheme and address of the server
+	scheme  string
+	address string
+	tls     *tls.Config
+	binding *LocalBinding
+
+	mu       sync.Mutex
+	pending  []syslogMessage
+	listener net.Listener
+	conn     net.PacketConn
+	conns    map[net.Conn]bool
+	wg       sync.WaitGroup
+	// the error the socket stopped on, returned by the next Gather so that
+	// the agent restarts the receiver
+	failed error
+
+	// counts messages received while max_pending messages were waiting
+	droppedOverflow Stat
+}
+
+type syslogMessage struct {
+	fields map[string]interface{}
+	tags   map[string]string
+	t      time.Time
+}
+
+func NewSyslog() Input {
+	return &Syslog{
+		Server:          "udp://:514",
+		ReadTimeout:     Duration{Duration: 5 * time.Minute},
+		MaxPending:      100000,
+		droppedOverflow: RegisterDropped("receive", "inputs.syslog", "overflow"),
+	}
+}
+
+func (_ *Syslog) Description() string {
+	return "Receive syslog messages over UDP, TCP or TLS"
+}
+
+var syslogSampleConfig = `
+  ## Address to listen on, as udp://<address>, tcp://<address> or
+  ## tcp+tls://<address>, ie, tcp+tls://:6514
+  server = "udp://:514"
+
+  ## Connections idle for longer are closed, 0 to never close them
+  # read_timeout = "5m"
+  ## Messages kept between two gathers, further messages are dropped
+  # max_pending = 100000
+
+  ## Certificate and key of the server for tcp+tls, and the CA the
+  ## certificates of the clients are verified with, if any
+  # tls_cert = "/etc/telegraf/cert.pem"
+  # tls_key = "/etc/telegraf/key.pem"
+  # tls_ca = "/etc/telegraf/clients_ca.pem"
+`
+
+func (_ *Syslog) SampleConfig() string {
+	return syslogSampleConfig
+}
+
+func (s *Syslog) Gather(acc Accumulator) error {
+	s.mu.Lock()
+	pending := s.pending
+	s.pending = nil
+	failed := s.failed
+	s.mu.Unlock()
+
+	for _, m := range pending {
+		acc.AddFields("syslog", m.fields, m.tags, m.t)
+	}
+	return failed
+}
+
+// Init parses the server and loads the TLS certificates.
+func (s *Syslog) Init() error {
+	s.scheme, s.address = "udp", s.Server
+	if i := strings.Index(s.Server, "://"); i != -1 {
+		s.scheme, s.address = s.Server[:i], s.Server[i+3:]
+	}
+	switch s.scheme {
+	case "udp", "udp4", "udp6", "tcp", "tcp4", "tcp6":
+	case "tcp+tls":
+		var err error
+		if s.tls, err = s.tlsConfig(); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown scheme %q of server %s", s.scheme, s.Server)
+	}
+	return nil
+}
+
+// SetLocalBinding listens on the local address instead of the host of the
+// server.
+func (s *Syslog) SetLocalBinding(b *LocalBinding) {
+	s.binding = b
+}
+
+func (s *Syslog) Start(_ Accumulator) error {
+	network := strings.TrimSuffix(s.scheme, "+tls")
+	address, err := s.binding.ListenAddress(network, s.address)
+	if err != nil {
+		return fmt.Errorf("error listening on %s: %s", s.Server, err)
+	}
+
+	s.conns = make(map[net.Conn]bool)
+	s.failed = nil
+	switch s.scheme {
+	case "udp", "udp4", "udp6":
+		conn, err := net.ListenPacket(s.scheme, address)
+		if err != nil {
+			return fmt.Errorf("error listening on %s: %s", s.Server, err)
+		}
+		s.conn = conn
+		s.wg.Add(1)
+		go s.readPackets(conn)
+	default:
+		l, err := net.Listen(network, address)
+		if err != nil {
+			return fmt.Errorf("error listening on %s: %s", s.Server, err)
+		}
+		if s.tls != nil {
+			l = tls.NewListener(l, s.tls)
+		}
+		s.listener = l
+		s.wg.Add(1)
+		go s.accept(l)
+	}
+	log.Printf("I! Started the syslog receiver on %s", s.Server)
+	return nil
+}
+
+func (s *Syslog) tlsConfig() (*tls.Config, error) {
+	if s.TLSCert == "" || s.TLSKey == "" {
+		return nil, fmt.Errorf("tls_cert and tls_key are required for tcp+tls")
+	}
+	config, err := GetTLSConfig(s.TLSCert, s.TLSKey, "", false)
+	if err != nil {
+		return nil, err
+	}
+	if s.TLSCA != "" {
+		ca, err := ioutil.ReadFile(s.TLSCA)
+		if err != nil {
+			return nil, fmt.Errorf("could not load TLS CA: %s", err)
+		}
+		config.ClientCAs = x509.NewCertPool()
+		config.ClientCAs.AppendCertsFromPEM(ca)
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+func (s *Syslog) Stop() {
+	s.mu.Lock()
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+	if s.listener != nil {
+		s.listener.Close()
+		s.listener = nil
+	}
+	for c := range s.conns {
+		c.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+func (s *Syslog) readPackets(conn net.PacketConn) {
+	defer s.wg.Done()
+	buf := make([]byte, syslogMaxMessageSize)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			s.fail(err)
+			return
+		}
+		s.receive(buf[:n], addr)
+	}
+}
+
+func (s *Syslog) accept(l net.Listener) {
+	defer s.wg.Done()
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			s.fail(err)
+			return
+		}
+		s.mu.Lock()
+		s.conns[c] = true
+		s.mu.Unlock()
+		s.wg.Add(1)
+		go s.readStream(c)
+	}
+}
+
+// fail records the error the socket stopped on, unless closed by Stop.
+func (s *Syslog) fail(err error) {
+	if strings.HasSuffix(err.Error(), "use of closed network connection") {
+		return
+	}
+	log.Printf("E! [inputs.syslog] %s", err)
+	s.mu.Lock()
+	s.failed = err
+	s.mu.Unlock()
+}
+
+// readStream reads the messages of a connection, each one framed by its
+// length in octets or else ended by a newline.
+func (s *Syslog) readStream(c net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+		c.Close()
+	}()
+
+	r := bufio.NewReaderSize(c, syslogMaxMessageSize)
+	for {
+		if s.ReadTimeout.Duration > 0 {
+			c.SetReadDeadline(time.Now().Add(s.ReadTimeout.Duration))
+		}
+		msg, err := readSyslogFrame(r)
+		if len(msg) > 0 {
+			s.receive(msg, c.RemoteAddr())
+		}
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			if !strings.HasSuffix(err.Error(), "use of closed network connection") {
+				log.Printf("E! [inputs.syslog] %s: %s", c.RemoteAddr(), err)
+			}
+			return
+		}
+	}
+}
+
+func readSyslogFrame(r *bufio.Reader) ([]byte, error) {
+	first, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	if first[0] < '1' || first[0] > '9' {
+		line, err := r.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			// dropping the rest of the message
+			for err == bufio.ErrBufferFull {
+				_, err = r.ReadSlice('\n')
+			}
+			return nil, err
+		}
+		return []byte(strings.TrimRight(string(line), "\r\n")), err
+	}
+
+	length, err := r.ReadString(' ')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSuffix(length, " "))
+	if err != nil || n > syslogMaxMessageSize {
+		return nil, fmt.Errorf("invalid frame length %q", length)
+	}
+	msg := make([]byte, n)
+	_, err = io.ReadFull(r, msg)
+	return msg, err
+}
+
+func (s *Syslog) receive(msg []byte, addr net.Addr) {
+	fields, tags, err := parseSyslog(strings.TrimRight(string(msg), "\r\n\x00"))
+	if err != nil {
+		log.Printf("E! [inputs.syslog] %s: %s", addr, err)
+		return
+	}
+	if host, _, err := net.SplitHostPort(addr.String()); err == nil {
+		tags["source"] = host
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.pending) >= s.MaxPending {
+		s.droppedOverflow.Incr(1)
+		return
+	}
+	s.pending = append(s.pending, syslogMessage{fields, tags, time.Now()})
+}
+
+// parseSyslog parses a message in the RFC 5424 format, or else in the BSD
+// format, into the fields and tags of its metric.
+func parseSyslog(msg string) (map[string]interface{}, map[string]string, error) {
+	if !strings.HasPrefix(msg, "<") {
+		return nil, nil, fmt.Errorf("no priority in %q", msg)
+	}
+	end := strings.IndexByte(msg, '>')
+	if end == -1 || end > 4 {
+		return nil, nil, fmt.Errorf("invalid priority in %q", msg)
+	}
+	pri, err := strconv.Atoi(msg[1:end])
+	if err != nil || pri > 191 {
+		return nil, nil, fmt.Errorf("invalid priority in %q", msg)
+	}
+	facility, severity := pri/8, pri%8
+
+	fields := map[string]interface{}{
+		"facility_code": facility,
+		"severity_code": severity,
+	}
+	tags := map[string]string{
+		"facility": syslogFacilities[facility],
+		"severity": syslogSeverities[severity],
+	}
+
+	rest := msg[end+1:]
+	if strings.HasPrefix(rest, "1 ") {
+		err = parseSyslog5424(rest[2:], fields, tags)
+	} else {
+		parseSyslog3164(rest, fields, tags)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return fields, tags, nil
+}
+
+// parseSyslog5424 parses the header, structured data and message following
+// the version, "-" being a nil value:
+//
+//	TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA [MSG]
+func parseSyslog5424(rest string, fields map[string]interface{}, tags map[string]string) error {
+	fields["version"] = 1
+	header := strings.SplitN(rest, " ", 6)
+	if len(header) < 6 {
+		return fmt.Errorf("truncated RFC 5424 header in %q", rest)
+	}
+	if header[0] != "-" {
+		t, err := time.Parse(time.RFC3339Nano, header[0])
+		if err != nil {
+			return fmt.Errorf("invalid timestamp: %s", err)
+		}
+		fields["timestamp"] = t.UnixNano()
+	}
+	if header[1] != "-" {
+		tags["hostname"] = header[1]
+	}
+	if header[2] != "-" {
+		tags["appname"] = header[2]
+	}
+	if header[3] != "-" {
+		fields["procid"] = header[3]
+	}
+	if header[4] != "-" {
+		fields["msgid"] = header[4]
+	}
+
+	rest, err := parseSyslogStructuredData(header[5], fields)
+	if err != nil {
+		return err
+	}
+	// a BOM marks UTF-8 messages
+	if rest = strings.TrimPrefix(strings.TrimPrefix(rest, " "), "\ufeff"); rest != "" {
+		fields["message"] = rest
+	}
+	return nil
+}
+
+// parseSyslogStructuredData adds the parameters of the structured data
+// elements as <sd-id>_<param-name> fields, and returns the message after
+// them.
+func parseSyslogStructuredData(rest string, fields map[string]interface{}) (string, error) {
+	if strings.HasPrefix(rest, "-") {
+		return rest[1:], nil
+	}
+	for strings.HasPrefix(rest, "[") {
+		end := strings.IndexAny(rest, " ]")
+		if end == -1 {
+			return "", fmt.Errorf("unterminated structured data")
+		}
+		id := rest[1:end]
+		rest = rest[end:]
+		for strings.HasPrefix(rest, " ") {
+			eq := strings.Index(rest, `="`)
+			if eq == -1 {
+				return "", fmt.Errorf("invalid structured data parameter in %s", id)
+			}
+			name := rest[1:eq]
+			var value []byte
+			i := eq + 2
+			for ; i < len(rest) && rest[i] != '"'; i++ {
+				// ", \ and ] are escaped with a backslash
+				if rest[i] == '\\' && i+1 < len(rest) && strings.IndexByte(`"\]`, rest[i+1]) != -1 {
+					i++
+				}
+				value = append(value, rest[i])
+			}
+			if i == len(rest) {
+				return "", fmt.Errorf("unterminated structured data parameter %s", name)
+			}
+			fields[id+"_"+name] = string(value)
+			rest = rest[i+1:]
+		}
+		if !strings.HasPrefix(rest, "]") {
+			return "", fmt.Errorf("unterminated structured data element %s", id)
+		}
+		rest = rest[1:]
+	}
+	return rest, nil
+}
+
+// parseSyslog3164 parses, as best it can, the timestamp, hostname and tag
+// following the priority, the hostname being left out by some senders:
+//
+//	Mmm dd hh:mm:ss [HOSTNAME] TAG[PID]: MSG
+func parseSyslog3164(rest string, fields map[string]interface{}, tags map[string]string) {
+	if len(rest) >= 16 && rest[15] == ' ' {
+		if t, err := time.ParseInLocation(time.Stamp, rest[:15], time.Local); err == nil {
+			// the timestamp has no year
+			now := time.Now()
+			t = t.AddDate(now.Year(), 0, 0)
+			if t.After(now.Add(24 * time.Hour)) {
+				t = t.AddDate(-1, 0, 0)
+			}
+			fields["timestamp"] = t.UnixNano()
+			rest = rest[16:]
+
+			if i := strings.IndexByte(rest, ' '); i != -1 {
+				host := rest[:i]
+				if !strings.HasSuffix(host, ":") && !strings.Contains(host, "[") {
+					tags["hostname"] = host
+					rest = rest[i+1:]
+				}
+			}
+		}
+	}
+
+	// the tag is alphanumeric, possibly followed by the pid in brackets
+	i := strings.IndexAny(rest, ":[ ")
+	if i > 0 && i <= 48 && rest[i] != ' ' {
+		tags["appname"] = rest[:i]
+		rest = rest[i:]
+		if strings.HasPrefix(rest, "[") {
+			if end := strings.IndexByte(rest, ']'); end != -1 {
+				fields["procid"] = rest[1:end]
+				rest = rest[end+1:]
+			}
+		}
+		rest = strings.TrimPrefix(rest, ":")
+	}
+	fields["version"] = 0
+	if rest = strings.TrimPrefix(rest, " "); rest != "" {
+		fields["message"] = rest
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// tailReadSize is the size of the reads of the followed files.
+const tailReadSize = 64 * 1024
+
+// Tail follows files, parsing the lines appended to them since the previous
+// gather with the parser of its data_format. A file is kept open between
+// gathers, so the end of a file rotated by renaming it is still read before
+// the new file at its path is opened; a truncated file is read again from
+// its start.
+type Tail struct {
+	Files         []string
+	FromBeginning bool `toml:"from_beginning"`
+	MaxLineSize   int  `toml:"max_line_size"`
+
+	parser Parser
+
+	// the followed files, by path
+	tailers map[string]*tailer
+}
+
+// tailer follows a file, from the offset of the last line read.
+type tailer struct {
+	file    *os.File
+	offset  int64
+	partial []byte
+
+	// modification time of the file when it was last read to offset
+	modTime time.Time
+}
+
+func NewTail() Input {
+	return &Tail{
+		MaxLineSize: 64 * 1024,
+	}
+}
+
+func (_ *Tail) Description() string {
+	return "Parse the new lines appended to files"
+}
+
+var tailSampleConfig = `
+  ## Files to follow, as globs. The globs are expanded on every gather, so
+  ## that new files are followed too.
+  files = ["/var/apache2/2.4/logs/access_log", "/var/opt/app/log/*.log"]
+
+  ## Read the files from their beginning rather than from their end when
+  ## they are first followed.
+  # from_beginning = false
+
+  ## Longer lines are dropped.
+  # max_line_size = 65536
+
+  ## Data format to consume, each line is parsed on its own.
+  data_format = "grok"
+  grok_patterns = ["%{COMBINED_LOG_FORMAT} %{NUMBER:response_time_us:int}"]
+  ## Definitions of patterns, one per line, which grok_patterns can use.
+  # grok_custom_patterns = '''
+  #   APP_ID [a-z]{3}[0-9]{4}
+  # '''
+`
+
+func (_ *Tail) SampleConfig() string {
+	return tailSampleConfig
+}
+
+func (t *Tail) SetParser(parser Parser) {
+	t.parser = parser
+}
+
+// Init checks the globs of the files and the maximum line size.
+func (t *Tail) Init() error {
+	if len(t.Files) == 0 {
+		return fmt.Errorf("no files configured")
+	}
+	for _, glob := range t.Files {
+		if _, err := filepath.Match(glob, ""); err != nil {
+			return fmt.Errorf("invalid glob %q in files: %s", glob, err)
+		}
+	}
+	if t.MaxLineSize <= 0 {
+		return fmt.Errorf("max_line_size must be positive")
+	}
+	return nil
+}
+
+func (t *Tail) Gather(acc Accumulator) error {
+	first := t.tailers == nil
+	if first {
+		t.tailers = make(map[string]*tailer)
+	}
+
+	seen := make(map[string]bool)
+	for _, glob := range t.Files {
+		paths, err := filepath.Glob(glob)
+		if err != nil {
+			acc.AddError(err)
+			continue
+		}
+		for _, path := range paths {
+			seen[path] = true
+			tl, ok := t.tailers[path]
+			if !ok {
+				// files appearing after the first gather are new, and read
+				// from their beginning
+				tl, err = openTailer(path, first && !t.FromBeginning)
+				if err != nil {
+					acc.AddError(err)
+					continue
+				}
+				t.tailers[path] = tl
+			}
+			err := tl.follow("inputs.tail", path, t.MaxLineSize, func(line string) {
+				t.parseLine(path, line, acc)
+			})
+			if err != nil {
+				acc.AddError(NewGatherError(err, "path", path))
+			}
+		}
+	}
+
+	for path, tl := range t.tailers {
+		if !seen[path] {
+			tl.file.Close()
+			delete(t.tailers, path)
+		}
+	}
+	return nil
+}
+
+func openTailer(path string, atEnd bool) (*tailer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	tl := &tailer{file: f}
+	if info, err := f.Stat(); err == nil {
+		tl.modTime = info.ModTime()
+	}
+	if atEnd {
+		if tl.offset, err = f.Seek(0, io.SeekEnd); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return tl, nil
+}
+
+// follow reads the lines appended to the file, passing them to handle, then
+// reopens it when it was rotated or truncated. Lines longer than
+// maxLineSize are dropped.
+func (tl *tailer) follow(plugin, path string, maxLineSize int, handle func(line string)) error {
+	if err := tl.read(plugin, path, maxLineSize, handle); err != nil {
+		return err
+	}
+
+	open, err := tl.file.Stat()
+	if err != nil {
+		return err
+	}
+	current, err := os.Stat(path)
+	if err != nil {
+		// rotated, the new file is not created yet
+		return nil
+	}
+	// a file truncated and rewritten to the size it had is told apart by
+	// its modification time
+	truncated := current.Size() < tl.offset ||
+		current.Size() == tl.offset && current.ModTime().After(tl.modTime)
+	if os.SameFile(open, current) && !truncated {
+		return nil
+	}
+
+	// rotated or truncated, the new content is read from its start
+	if !os.SameFile(open, current) {
+		log.Printf("D! [%s] %s was rotated, reopening it", plugin, path)
+	} else {
+		log.Printf("D! [%s] %s was truncated, reading it from its start", plugin, path)
+	}
+	tl.file.Close()
+	reopened, err := openTailer(path, false)
+	if err != nil {
+		return err
+	}
+	*tl = *reopened
+	return tl.read(plugin, path, maxLineSize, handle)
+}
+
+func (tl *tailer) read(plugin, path string, maxLineSize int, handle func(line string)) error {
+	buf := make([]byte, tailReadSize)
+	start := tl.offset
+	for {
+		n, err := tl.file.ReadAt(buf, tl.offset)
+		tl.offset += int64(n)
+		data := append(tl.partial, buf[:n]...)
+		for {
+			i := bytes.IndexByte(data, '\n')
+			if i == -1 {
+				break
+			}
+			handle(strings.TrimRight(string(data[:i]), "\r"))
+			data = data[i+1:]
+		}
+		if len(data) > maxLineSize {
+			log.Printf("W! [%s] %s: dropping line longer than %d bytes", plugin, path, maxLineSize)
+			data = nil
+		}
+		tl.partial = append([]byte(nil), data...)
+
+		if err == io.EOF {
+			if info, err := tl.file.Stat(); err == nil && tl.offset != start {
+				tl.modTime = info.ModTime()
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (t *Tail) parseLine(path, line string, acc Accumulator) {
+	if strings.TrimSpace(line) == "" {
+		return
+	}
+	m, err := t.parser.ParseLine(line)
+	if err != nil {
+		acc.AddError(NewGatherError(err, "path", path))
+		return
+	}
+	if m == nil {
+		return
+	}
+	tags := m.Tags()
+	tags["path"] = path
+	acc.AddFields(m.Name(), m.Fields(), tags, m.Time())
+}
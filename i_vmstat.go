@@ -0,0 +1,128 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+// vmstatRates are the fields of the rates, in pages or events per second,
+// by statistic of the cpu:*:vm kstats summed over the CPUs, the ones vmstat
+// -p splits the paging of in anonymous, executable and file system pages.
+var vmstatRates = map[string]string{
+	"pgin":       "page_in_ops_rate",
+	"pgout":      "page_out_ops_rate",
+	"pgpgin":     "page_in_rate",
+	"pgpgout":    "page_out_rate",
+	"pgswapin":   "swap_in_rate",
+	"pgswapout":  "swap_out_rate",
+	"dfree":      "free_rate",
+	"scan":       "scan_rate",
+	"rev":        "scanner_revolutions_rate",
+	"pgrec":      "reclaim_rate",
+	"zfod":       "zero_fill_rate",
+	"maj_fault":  "major_fault_rate",
+	"as_fault":   "as_fault_rate",
+	"hat_fault":  "hat_fault_rate",
+	"cow_fault":  "cow_fault_rate",
+	"prot_fault": "prot_fault_rate",
+	"anonpgin":   "anon_page_in_rate",
+	"anonpgout":  "anon_page_out_rate",
+	"anonfree":   "anon_free_rate",
+	"execpgin":   "exec_page_in_rate",
+	"execpgout":  "exec_page_out_rate",
+	"execfree":   "exec_free_rate",
+	"fspgin":     "fs_page_in_rate",
+	"fspgout":    "fs_page_out_rate",
+	"fsfree":     "fs_free_rate",
+}
+
+// vmstatPages are the statistics of the unix:0:system_pages kstat reported,
+// in bytes: the free list and the thresholds of the page scanner.
+var vmstatPages = []string{"freemem", "lotsfree", "desfree", "minfree", "availrmem"}
+
+// Vmstat reports the paging and page scanner activity, as vmstat -p, and
+// the size of the free list, to tell memory pressure by the scan rate.
+type Vmstat struct {
+	Timeout Duration
+
+	runKstat KstatRunner
+
+	// the counters of the cpu:*:vm kstats summed over the CPUs at the
+	// previous gather, and when it was
+	last     map[string]uint64
+	lastTime time.Time
+}
+
+func NewVmstat() Input {
+	return &Vmstat{
+		Timeout:  Duration{Duration: 5 * time.Second},
+		runKstat: kstatRunner,
+	}
+}
+
+func (_ *Vmstat) Description() string {
+	return "Read the paging and page scanner activity and the free memory, as vmstat -p"
+}
+
+var vmstatSampleConfig = `
+  ## The rates are in pages, or faults, per second since the previous
+  ## gather, and the scan_rate field is the sr column of vmstat. The
+  ## anon_, exec_ and fs_ fields split the paging between anonymous,
+  ## executable and file system pages, as vmstat -p does.
+  ## Timeout for the kstat command to complete
+  # timeout = "5s"
+`
+
+func (_ *Vmstat) SampleConfig() string {
+	return vmstatSampleConfig
+}
+
+func (v *Vmstat) Gather(acc Accumulator) error {
+	entries, err := readKstat(v.runKstat, v.Timeout.Duration,
+		"unix:0:system_pages", "cpu::vm")
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	pageSize := uint64(os.Getpagesize())
+	fields := make(map[string]interface{})
+	current := make(map[string]uint64, len(vmstatRates))
+	for _, entry := range entries {
+		switch entry.Module {
+		case "unix":
+			for _, stat := range vmstatPages {
+				if pages, err := strconv.ParseUint(entry.Stats[stat], 10, 64); err == nil {
+					fields[stat+"_bytes"] = pages * pageSize
+				}
+			}
+		case "cpu":
+			for stat := range vmstatRates {
+				if n, err := strconv.ParseUint(entry.Stats[stat], 10, 64); err == nil {
+					current[stat] += n
+				}
+			}
+		}
+	}
+
+	// the rates need a previous gather, and are left out if a CPU went
+	// offline since, taking its counters with it
+	elapsed := now.Sub(v.lastTime).Seconds()
+	if v.last != nil && elapsed > 0 {
+		for stat, field := range vmstatRates {
+			n, ok := current[stat]
+			prev, okPrev := v.last[stat]
+			if ok && okPrev && n >= prev {
+				fields[field] = float64(n-prev) / elapsed
+			}
+		}
+	}
+	v.last = current
+	v.lastTime = now
+
+	if len(fields) != 0 {
+		acc.AddGauge("vmstat", fields, nil, now)
+	}
+	return nil
+}
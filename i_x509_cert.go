@@ -0,0 +1,270 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// X509Cert reports the validity and expiry of the certificates read from
+// PEM files or presented by TLS servers.
+type X509Cert struct {
+	Sources    []string
+	Timeout    Duration
+	ServerName string `toml:"server_name"`
+
+	// Path to CA file
+	SSLCA string `toml:"ssl_ca"`
+	// Path to host cert file
+	SSLCert string `toml:"ssl_cert"`
+	// Path to cert key file
+	SSLKey string `toml:"ssl_key"`
+
+	roots     *x509.CertPool
+	tlsConfig *tls.Config
+}
+
+func NewX509Cert() Input {
+	return &X509Cert{
+		Timeout: Duration{Duration: 5 * time.Second},
+	}
+}
+
+func (_ *X509Cert) Description() string {
+	return "Read the expiry and verification status of certificates"
+}
+
+var x509CertSampleConfig = `
+  ## Certificates to check: PEM files, as globs, with or without a file://
+  ## prefix, and TLS servers as tcp://host:port or https://host[:port]
+  sources = ["/etc/apache2/2.4/server.crt", "tcp://ldap.example.com:636"]
+  ## Timeout for the TLS handshake with the servers
+  # timeout = "5s"
+  ## Host name the certificates are verified for, by default the host of
+  ## the server they are presented by, and none for the files
+  # server_name = "www.example.com"
+
+  ## CA the certificates are verified against, by default the system's
+  # ssl_ca = "/etc/telegraf/ca.pem"
+  ## Client certificate presented to the servers
+  # ssl_cert = "/etc/telegraf/cert.pem"
+  # ssl_key = "/etc/telegraf/key.pem"
+`
+
+func (_ *X509Cert) SampleConfig() string {
+	return x509CertSampleConfig
+}
+
+// Init checks the sources and loads the CA and the client certificate.
+func (x *X509Cert) Init() error {
+	for _, source := range x.Sources {
+		if _, _, err := x509Source(source); err != nil {
+			return err
+		}
+	}
+
+	tlsCfg, err := GetTLSConfig(x.SSLCert, x.SSLKey, x.SSLCA, true)
+	if err != nil {
+		return err
+	}
+	x.tlsConfig = tlsCfg
+	x.roots = tlsCfg.RootCAs
+	if x.roots == nil {
+		if x.roots, err = x509.SystemCertPool(); err != nil {
+			return fmt.Errorf("could not load the system CAs: %s", err)
+		}
+	}
+	return nil
+}
+
+func (x *X509Cert) Gather(acc Accumulator) error {
+	if x.tlsConfig == nil {
+		if err := x.Init(); err != nil {
+			return err
+		}
+	}
+
+	now := time.Now()
+	for _, source := range x.Sources {
+		scheme, location, _ := x509Source(source)
+		if scheme != "file" {
+			certs, err := x.serverCerts(location)
+			if err != nil {
+				acc.AddError(NewGatherError(err, "source", source))
+				continue
+			}
+			host, _, _ := net.SplitHostPort(location)
+			x.addCerts(acc, source, host, certs, now)
+			continue
+		}
+
+		paths, err := filepath.Glob(location)
+		if err != nil {
+			acc.AddError(NewGatherError(err, "source", source))
+			continue
+		}
+		if len(paths) == 0 {
+			acc.AddError(NewGatherError(errors.New("no such file"), "source", source))
+		}
+		for _, path := range paths {
+			certs, err := fileCerts(path)
+			if err != nil {
+				acc.AddError(NewGatherError(err, "source", path))
+				continue
+			}
+			x.addCerts(acc, path, "", certs, now)
+		}
+	}
+	return nil
+}
+
+// addCerts reports the certificates of a source, the leaf first and then the
+// chain that came with it, each verified with the others as intermediates.
+// The leaf of a server is verified for its host.
+func (x *X509Cert) addCerts(
+	acc Accumulator,
+	source, host string,
+	certs []*x509.Certificate,
+	now time.Time,
+) {
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	for i, cert := range certs {
+		opts := x509.VerifyOptions{
+			Roots:         x.roots,
+			Intermediates: intermediates,
+			CurrentTime:   now,
+		}
+		if i == 0 {
+			opts.DNSName = x.ServerName
+			if opts.DNSName == "" {
+				opts.DNSName = host
+			}
+		}
+
+		position := "intermediate"
+		if i == 0 {
+			position = "leaf"
+		} else if cert.IsCA && cert.Subject.String() == cert.Issuer.String() {
+			position = "root"
+		}
+		tags := map[string]string{
+			"source":             source,
+			"position":           position,
+			"common_name":        cert.Subject.CommonName,
+			"issuer_common_name": cert.Issuer.CommonName,
+			"serial_number":      cert.SerialNumber.Text(16),
+		}
+
+		expiry := cert.NotAfter.Sub(now)
+		fields := map[string]interface{}{
+			"age":               int64(now.Sub(cert.NotBefore).Seconds()),
+			"expiry":            int64(expiry.Seconds()),
+			"days_until_expiry": int64(expiry.Hours() / 24),
+			"startdate":         cert.NotBefore.Unix(),
+			"enddate":           cert.NotAfter.Unix(),
+		}
+		if _, err := cert.Verify(opts); err != nil {
+			tags["verification"] = "invalid"
+			fields["verification_code"] = 1
+			fields["verification_error"] = err.Error()
+		} else {
+			tags["verification"] = "valid"
+			fields["verification_code"] = 0
+		}
+		acc.AddFields("x509_cert", fields, tags, now)
+	}
+}
+
+// serverCerts returns the certificates presented by the TLS server at the
+// address, which are not verified by the handshake so that invalid ones are
+// reported too.
+func (x *X509Cert) serverCerts(address string) ([]*x509.Certificate, error) {
+	cfg := x.tlsConfig.Clone()
+	cfg.InsecureSkipVerify = true
+	cfg.ServerName = x.ServerName
+	if cfg.ServerName == "" {
+		cfg.ServerName, _, _ = net.SplitHostPort(address)
+	}
+
+	dialer := &net.Dialer{Timeout: x.Timeout.Duration}
+	conn, err := tls.DialWithDialer(dialer, "tcp", address, cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return nil, errors.New("no certificate presented")
+	}
+	return certs, nil
+}
+
+// fileCerts returns the certificates of the PEM file.
+func fileCerts(path string) ([]*x509.Certificate, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("no certificate found")
+	}
+	return certs, nil
+}
+
+// x509Source returns the scheme of the source, "file", "tcp" or "https",
+// and the path of a file or the host:port of a server.
+func x509Source(source string) (string, string, error) {
+	if !strings.Contains(source, "://") {
+		return "file", source, nil
+	}
+	u, err := url.Parse(source)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid source %q: %s", source, err)
+	}
+	switch u.Scheme {
+	case "file":
+		return "file", u.Path, nil
+	case "tcp", "https":
+		if u.Hostname() == "" {
+			return "", "", fmt.Errorf("invalid source %q: no host", source)
+		}
+		port := u.Port()
+		if port == "" {
+			if u.Scheme == "tcp" {
+				return "", "", fmt.Errorf("invalid source %q: no port", source)
+			}
+			port = "443"
+		}
+		return u.Scheme, net.JoinHostPort(u.Hostname(), port), nil
+	}
+	return "", "", fmt.Errorf("invalid source %q: scheme must be file, tcp or https",
+		source)
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ZpoolRunner runs zpool(1M) with the given arguments and returns its output.
+// It can be replaced with a mocked function for unit test purposes.
+type ZpoolRunner func(timeout time.Duration, args ...string) ([]byte, error)
+
+// Zfs reports the ARC statistics from the zfs:0:arcstats kstat and the
+// capacity and health of every imported pool.
+type Zfs struct {
+	ArcStats      []string `toml:"arc_stats"`
+	TuningMetrics bool     `toml:"tuning_metrics"`
+	PoolMetrics   bool     `toml:"pool_metrics"`
+	Pools         []string
+	Timeout       Duration
+
+	runKstat KstatRunner
+	runZpool ZpoolRunner
+
+	// the arcstats at the previous gather, for the hit ratios over the
+	// interval
+	lastArcStats map[string]string
+}
+
+func NewZfs() Input {
+	return &Zfs{
+		ArcStats:      []string{"hits", "misses", "size", "c_max"},
+		TuningMetrics: true,
+		PoolMetrics:   true,
+		Timeout:       Duration{Duration: 5 * time.Second},
+		runKstat:      kstatRunner,
+		runZpool:      zpoolRunner,
+	}
+}
+
+func (_ *Zfs) Description() string {
+	return "Read ZFS ARC statistics and zpool capacity and health"
+}
+
+var zfsSampleConfig = `
+  ## ARC statistics from the zfs:0:arcstats kstat to report, empty means all
+  # arc_stats = ["hits", "misses", "size", "c_max"]
+  ## Report the ratios derived from all the ARC statistics that tell whether
+  ## raising or lowering zfs_arc_max would help: the demand and prefetch hit
+  ## ratios, the share of the ARC used by metadata and by data, and the
+  ## ghost list hits, the misses a larger MRU or MFU list would have avoided.
+  ## The hit ratios are computed over the interval, from the second gather.
+  # tuning_metrics = true
+  ## Report capacity, fragmentation and health of the pools
+  # pool_metrics = true
+  ## Pools to report on, as globs matched against the pool name.
+  ## If empty, all pools are reported.
+  # pools = ["rpool"]
+  ## Timeout for the kstat and zpool commands to complete
+  # timeout = "5s"
+`
+
+func (_ *Zfs) SampleConfig() string {
+	return zfsSampleConfig
+}
+
+// Init checks the globs of the pools.
+func (z *Zfs) Init() error {
+	return checkGlobs("pools", z.Pools)
+}
+
+func (z *Zfs) Gather(acc Accumulator) error {
+	entries, err := readKstat(z.runKstat, z.Timeout.Duration, "zfs:0:arcstats")
+	if err != nil {
+		return err
+	}
+
+	fields := make(map[string]interface{})
+	for _, entry := range entries {
+		for stat, value := range entry.Stats {
+			if len(z.ArcStats) != 0 && !sliceContains(stat, z.ArcStats) {
+				continue
+			}
+			setKstatField(fields, "arcstats_"+stat, value)
+		}
+		if z.TuningMetrics {
+			arcTuningFields(fields, entry.Stats, z.lastArcStats)
+			z.lastArcStats = entry.Stats
+		}
+	}
+	if len(fields) != 0 {
+		acc.AddFields("zfs", fields, nil)
+	}
+
+	if z.PoolMetrics {
+		return z.gatherPools(acc)
+	}
+	return nil
+}
+
+// arcTuningFields adds the ratios, in percent, derived from the arcstats
+// that indicate whether the ARC is sized right. The hit ratios are those of
+// the hits and misses since the last arcstats, as the counters since boot
+// hardly move after a while, and are left out without them or after the
+// counters were reset. A ratio is left out when its statistics are missing
+// or its denominator is zero.
+func arcTuningFields(fields map[string]interface{}, stats, last map[string]string) {
+	// the value of the statistic, a size
+	gauge := func(name string) (float64, bool) {
+		v, err := strconv.ParseFloat(stats[name], 64)
+		return v, err == nil
+	}
+	// the increase of the counter since the last arcstats
+	counter := func(name string) (float64, bool) {
+		v, err := strconv.ParseFloat(stats[name], 64)
+		if err != nil {
+			return 0, false
+		}
+		prev, err := strconv.ParseFloat(last[name], 64)
+		if err != nil || v < prev {
+			return 0, false
+		}
+		return v - prev, true
+	}
+	ratio := func(field string, stat func(string) (float64, bool), num float64, den ...string) {
+		var total float64
+		for _, name := range den {
+			v, ok := stat(name)
+			if !ok {
+				return
+			}
+			total += v
+		}
+		if total > 0 {
+			fields[field] = 100 * num / total
+		}
+	}
+	hitRatio := func(field string, hits []string, misses ...string) {
+		var num float64
+		for _, name := range hits {
+			v, ok := counter(name)
+			if !ok {
+				return
+			}
+			num += v
+		}
+		ratio(field, counter, num, append(hits, misses...)...)
+	}
+
+	hitRatio("arc_hit_ratio", []string{"hits"}, "misses")
+	hitRatio("arc_demand_data_hit_ratio", []string{"demand_data_hits"}, "demand_data_misses")
+	hitRatio("arc_demand_metadata_hit_ratio", []string{"demand_metadata_hits"}, "demand_metadata_misses")
+	hitRatio("arc_prefetch_data_hit_ratio", []string{"prefetch_data_hits"}, "prefetch_data_misses")
+	hitRatio("arc_prefetch_metadata_hit_ratio", []string{"prefetch_metadata_hits"}, "prefetch_metadata_misses")
+	hitRatio("arc_demand_hit_ratio", []string{"demand_data_hits", "demand_metadata_hits"},
+		"demand_data_misses", "demand_metadata_misses")
+	hitRatio("arc_prefetch_hit_ratio", []string{"prefetch_data_hits", "prefetch_metadata_hits"},
+		"prefetch_data_misses", "prefetch_metadata_misses")
+
+	// the ghost lists hold the headers of evicted buffers, a hit on them is
+	// a miss that a larger list would have served
+	if v, ok := counter("mru_ghost_hits"); ok {
+		ratio("arc_mru_ghost_hit_ratio", counter, v, "misses")
+	}
+	if v, ok := counter("mfu_ghost_hits"); ok {
+		ratio("arc_mfu_ghost_hit_ratio", counter, v, "misses")
+	}
+
+	if v, ok := gauge("arc_meta_used"); ok {
+		ratio("arc_metadata_usage", gauge, v, "size")
+		ratio("arc_meta_limit_usage", gauge, v, "arc_meta_limit")
+	}
+	if v, ok := gauge("data_size"); ok {
+		ratio("arc_data_usage", gauge, v, "size")
+	}
+	if v, ok := gauge("size"); ok {
+		ratio("arc_size_usage", gauge, v, "c_max")
+	}
+}
+
+func (z *Zfs) gatherPools(acc Accumulator) error {
+	run := z.runZpool
+	if run == nil {
+		run = zpoolRunner
+	}
+	out, err := run(z.Timeout.Duration, "list", "-Hp",
+		"-o", "name,health,size,alloc,free,frag,cap,dedup")
+	if err != nil {
+		return fmt.Errorf("error getting zpool info: %s", err)
+	}
+
+	for _, line := range strings.Split(string(out), "\n") {
+		cols := strings.Split(line, "\t")
+		if len(cols) != 8 {
+			continue
+		}
+		pool := cols[0]
+		if len(z.Pools) != 0 && !matchesAny(pool, z.Pools) {
+			continue
+		}
+
+		fields := make(map[string]interface{})
+		setKstatField(fields, "size", cols[2])
+		setKstatField(fields, "allocated", cols[3])
+		setKstatField(fields, "free", cols[4])
+		// fragmentation is "-" for pools that do not track it
+		setKstatField(fields, "fragmentation", strings.TrimSuffix(cols[5], "%"))
+		setKstatField(fields, "capacity", strings.TrimSuffix(cols[6], "%"))
+		if v, err := strconv.ParseFloat(strings.TrimSuffix(cols[7], "x"), 64); err == nil {
+			fields["dedupratio"] = v
+		}
+		fields["healthy"] = cols[1] == "ONLINE"
+
+		tags := map[string]string{
+			"pool":   pool,
+			"health": cols[1],
+		}
+		acc.AddGauge("zfs_pool", fields, tags)
+	}
+	return nil
+}
+
+func zpoolRunner(timeout time.Duration, args ...string) ([]byte, error) {
+	bin, err := exec.LookPath("zpool")
+	if err != nil {
+		return nil, err
+	}
+	c := exec.Command(bin, args...)
+	return CombinedOutputTimeout(c, timeout)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"runtime"
+	"strconv"
+	"time"
+)
+
+// Zones reports the resource usage of every zone on the system, read from
+// the zones, memory_cap and caps kstats. It is meant to run in the global
+// zone, from a non-global zone only that zone is visible.
+type Zones struct {
+	Zones         []string
+	ExcludeGlobal bool `toml:"exclude_global"`
+	Timeout       Duration
+
+	runKstat KstatRunner
+
+	// cpu time of each zone at the previous gather, to compute usage
+	lastCPU  map[string]uint64
+	lastTime time.Time
+}
+
+func NewZones() Input {
+	return &Zones{
+		Timeout:  Duration{Duration: 5 * time.Second},
+		runKstat: kstatRunner,
+	}
+}
+
+func (_ *Zones) Description() string {
+	return "Read per-zone CPU, memory, swap and lwp usage"
+}
+
+var zonesSampleConfig = `
+  ## Zones to report on, as globs matched against the zone name.
+  ## If empty, all zones are reported.
+  # zones = ["web*", "db01"]
+  ## Set to true to leave out the global zone.
+  # exclude_global = false
+  ## Timeout for the kstat command to complete
+  # timeout = "5s"
+`
+
+func (_ *Zones) SampleConfig() string {
+	return zonesSampleConfig
+}
+
+// Init checks the globs of the zones.
+func (z *Zones) Init() error {
+	return checkGlobs("zones", z.Zones)
+}
+
+func (z *Zones) Gather(acc Accumulator) error {
+	entries, err := readKstat(z.runKstat, z.Timeout.Duration,
+		"zones:::", "memory_cap:::", "caps::/^lwps_zone_/")
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	zones := make(map[string]map[string]interface{})
+	zoneIDs := make(map[string]string)
+	cpu := make(map[string]uint64)
+	for _, entry := range entries {
+		zone := entry.Stats["zonename"]
+		if zone == "" || !z.selected(zone) {
+			continue
+		}
+		fields, ok := zones[zone]
+		if !ok {
+			fields = make(map[string]interface{})
+			zones[zone] = fields
+		}
+
+		switch entry.Module {
+		case "zones":
+			zoneIDs[zone] = entry.Instance
+			user, _ := strconv.ParseUint(entry.Stats["nsec_user"], 10, 64)
+			sys, _ := strconv.ParseUint(entry.Stats["nsec_sys"], 10, 64)
+			cpu[zone] = user + sys
+			fields["cpu_user_ns"] = user
+			fields["cpu_sys_ns"] = sys
+			setKstatField(fields, "cpu_waitrq_ns", entry.Stats["nsec_waitrq"])
+			// load averages are fixed point values scaled by 256 (FSCALE)
+			for key, stat := range map[string]string{
+				"load1":  "avenrun_1min",
+				"load5":  "avenrun_5min",
+				"load15": "avenrun_15min",
+			} {
+				if v, err := strconv.ParseFloat(entry.Stats[stat], 64); err == nil {
+					fields[key] = v / 256
+				}
+			}
+		case "memory_cap":
+			setKstatField(fields, "rss_bytes", entry.Stats["rss"])
+			setKstatField(fields, "physcap_bytes", entry.Stats["physcap"])
+			setKstatField(fields, "swap_bytes", entry.Stats["swap"])
+			setKstatField(fields, "swapcap_bytes", entry.Stats["swapcap"])
+			setKstatField(fields, "pagedout", entry.Stats["pagedout"])
+		case "caps":
+			setKstatField(fields, "lwps", entry.Stats["usage"])
+			setKstatField(fields, "lwps_cap", entry.Stats["value"])
+		}
+	}
+
+	// cpu usage is a percentage of all cpus, the same way prstat -Z reports
+	// it, so it can only be computed from the second gather on.
+	elapsed := now.Sub(z.lastTime).Nanoseconds() * int64(runtime.NumCPU())
+	for zone, total := range cpu {
+		last, ok := z.lastCPU[zone]
+		if !ok || elapsed <= 0 || total < last {
+			continue
+		}
+		zones[zone]["cpu_usage_percent"] =
+			100 * float64(total-last) / float64(elapsed)
+	}
+	z.lastCPU = cpu
+	z.lastTime = now
+
+	for zone, fields := range zones {
+		tags := map[string]string{"zone": zone}
+		if id, ok := zoneIDs[zone]; ok {
+			tags["zone_id"] = id
+		}
+		acc.AddGauge("zones", fields, tags, now)
+	}
+	return nil
+}
+
+func (z *Zones) selected(zone string) bool {
+	if z.ExcludeGlobal && zone == "global" {
+		return false
+	}
+	return len(z.Zones) == 0 || matchesAny(zone, z.Zones)
+}
+
+// setKstatField sets the field to the numeric value of a kstat statistic,
+// leaving it out if the statistic is missing or not numeric.
+func setKstatField(fields map[string]interface{}, key, value string) {
+	if v, ok := kstatValue(value); ok {
+		fields[key] = v
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// zpoolIostatLatencies are the fields of the latency columns added by
+// zpool iostat -l after the bandwidth ones, in nanoseconds with -p. Newer
+// releases add the last ones.
+var zpoolIostatLatencies = []string{
+	"total_wait_read_ns", "total_wait_write_ns",
+	"disk_wait_read_ns", "disk_wait_write_ns",
+	"syncq_wait_read_ns", "syncq_wait_write_ns",
+	"asyncq_wait_read_ns", "asyncq_wait_write_ns",
+	"scrub_wait_ns", "trim_wait_ns", "rebuild_wait_ns",
+}
+
+// zpoolVdevGroups are the prefixes of the names of the vdevs grouping
+// others, as raidz1-0 or mirror-1.
+var zpoolVdevGroups = []string{"raidz", "draid", "mirror", "replacing", "spare"}
+
+// zpoolVdevClasses are the names of the rows introducing the vdevs of the
+// allocation classes and of the spares, listed after the data vdevs.
+var zpoolVdevClasses = map[string]bool{
+	"logs": true, "cache": true, "spares": true, "special": true, "dedup": true,
+}
+
+// ZpoolIostat reports the operations, bandwidth and latency of every pool
+// and of each of their vdevs, measured by zpool iostat -v over the sample
+// interval, to find the failing disks that the pool numbers hide.
+type ZpoolIostat struct {
+	Pools          []string
+	Vdevs          bool
+	Latency        bool
+	SampleInterval Duration `toml:"sample_interval"`
+	Timeout        Duration
+
+	runZpool ZpoolRunner
+
+	// set once zpool iostat rejected -l, not to retry it on every gather
+	noLatency bool
+}
+
+func NewZpoolIostat() Input {
+	return &ZpoolIostat{
+		Vdevs:          true,
+		Latency:        true,
+		SampleInterval: Duration{Duration: time.Second},
+		Timeout:        Duration{Duration: 5 * time.Second},
+		runZpool:       zpoolRunner,
+	}
+}
+
+func (_ *ZpoolIostat) Description() string {
+	return "Read the operations, bandwidth and latency of the zpools and their vdevs"
+}
+
+var zpoolIostatSampleConfig = `
+  ## Pools to report on, as globs matched against the pool name.
+  ## If empty, all pools are reported.
+  # pools = ["rpool"]
+  ## Report each vdev of the pools along with the pools
+  # vdevs = true
+  ## Report the latency columns of zpool iostat -l, in nanoseconds, on the
+  ## releases that support them
+  # latency = true
+  ## The read_ops, write_ops, read_bytes and write_bytes fields are rates
+  ## per second measured by zpool iostat over sample_interval on each gather
+  # sample_interval = "1s"
+  ## Timeout for the zpool commands to complete, on top of sample_interval
+  # timeout = "5s"
+`
+
+func (_ *ZpoolIostat) SampleConfig() string {
+	return zpoolIostatSampleConfig
+}
+
+// Init checks the sample interval.
+func (z *ZpoolIostat) Init() error {
+	if z.SampleInterval.Duration < time.Second {
+		return fmt.Errorf("sample_interval must be at least 1s")
+	}
+	return checkGlobs("pools", z.Pools)
+}
+
+func (z *ZpoolIostat) Gather(acc Accumulator) error {
+	run := z.runZpool
+	if run == nil {
+		run = zpoolRunner
+	}
+
+	out, err := run(z.Timeout.Duration, "list", "-H", "-o", "name")
+	if err != nil {
+		return fmt.Errorf("error running zpool list: %s: %s", err,
+			strings.TrimSpace(string(out)))
+	}
+	pools := make(map[string]bool)
+	var args []string
+	for _, pool := range strings.Fields(string(out)) {
+		if len(z.Pools) != 0 && !matchesAny(pool, z.Pools) {
+			continue
+		}
+		pools[pool] = true
+		args = append(args, pool)
+	}
+	if len(pools) == 0 {
+		return nil
+	}
+
+	// the first report is the average since the pools were imported, the
+	// second the one over the interval
+	interval := strconv.Itoa(int(z.SampleInterval.Duration / time.Second))
+	args = append(args, interval, "2")
+	out, err = z.iostat(run, args)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, row := range parseZpoolIostat(string(out), pools) {
+		if row.vdev == "" {
+			acc.AddGauge("zpool_iostat", row.fields, map[string]string{
+				"pool": row.pool,
+			}, now)
+			continue
+		}
+		if !z.Vdevs {
+			continue
+		}
+		tags := map[string]string{
+			"pool":  row.pool,
+			"vdev":  row.vdev,
+			"class": row.class,
+		}
+		if row.parent != "" {
+			tags["parent"] = row.parent
+		}
+		acc.AddGauge("zpool_iostat_vdev", row.fields, tags, now)
+	}
+	return nil
+}
+
+// iostat runs zpool iostat -v -H -p with the latency columns if asked for,
+// and without them from then on if they are not supported.
+func (z *ZpoolIostat) iostat(run ZpoolRunner, args []string) ([]byte, error) {
+	timeout := z.SampleInterval.Duration + z.Timeout.Duration
+	opts := []string{"iostat", "-v", "-H", "-p"}
+	if z.Latency && !z.noLatency {
+		out, err := run(timeout, append(append(opts, "-l"), args...)...)
+		if err == nil {
+			return out, nil
+		}
+		if !strings.Contains(string(out), "invalid option") {
+			return nil, fmt.Errorf("error running zpool iostat: %s: %s", err,
+				strings.TrimSpace(string(out)))
+		}
+		z.noLatency = true
+	}
+	out, err := run(timeout, append(opts, args...)...)
+	if err != nil {
+		return nil, fmt.Errorf("error running zpool iostat: %s: %s", err,
+			strings.TrimSpace(string(out)))
+	}
+	return out, nil
+}
+
+// zpoolIostatRow is a pool, with an empty vdev, or a vdev of a pool.
+type zpoolIostatRow struct {
+	pool   string
+	vdev   string
+	parent string
+	class  string
+	fields map[string]interface{}
+}
+
+// parseZpoolIostat returns the rows of the last report of zpool iostat -v
+// -H -p, the tab separated name, allocated and free bytes, read and write
+// operations and bandwidth, and latencies of the pools each followed by
+// their vdevs:
+//
+//	tank	1073741824	3221225472	12	40	49152	1638400
+//	raidz1-0	1073741824	3221225472	12	40	49152	1638400
+//	c0t1d0	-	-	4	13	16384	546133
+//	logs	-	-	-	-	-	-
+//	c0t4d0	-	-	0	2	0	8192
+//
+// Scripted mode does not indent the vdevs, so the pools are told apart by
+// their names, and a disk is taken to belong to the last raidz, draid or
+// mirror vdev listed, which is only wrong for pools mixing them with single
+// disk vdevs.
+func parseZpoolIostat(out string, pools map[string]bool) []*zpoolIostatRow {
+	var rows []*zpoolIostatRow
+	seen := make(map[string]bool)
+	var pool, parent, class string
+	for _, line := range strings.Split(out, "\n") {
+		cols := strings.Split(strings.TrimSpace(line), "\t")
+		if len(cols) < 7 || strings.HasPrefix(cols[0], "-") {
+			continue
+		}
+		name := cols[0]
+		if pools[name] && cols[1] != "-" {
+			// a pool listed again starts the next report
+			if seen[name] {
+				rows, seen = nil, make(map[string]bool)
+			}
+			seen[name] = true
+			pool, parent, class = name, "", "data"
+			rows = append(rows, &zpoolIostatRow{
+				pool:   name,
+				fields: zpoolIostatFields(cols),
+			})
+			continue
+		}
+		if pool == "" {
+			continue
+		}
+		if zpoolVdevClasses[name] && cols[3] == "-" {
+			parent, class = "", name
+			continue
+		}
+
+		row := &zpoolIostatRow{
+			pool:   pool,
+			vdev:   name,
+			class:  class,
+			fields: zpoolIostatFields(cols),
+		}
+		if zpoolVdevGroup(name) {
+			parent = name
+		} else {
+			row.parent = parent
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// zpoolVdevGroup returns true if the vdev groups others.
+func zpoolVdevGroup(name string) bool {
+	for _, prefix := range zpoolVdevGroups {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// zpoolIostatFields returns the fields of the columns of a row, leaving out
+// the ones without a value.
+func zpoolIostatFields(cols []string) map[string]interface{} {
+	fields := make(map[string]interface{}, len(cols))
+	names := []string{"allocated", "free", "read_ops", "write_ops",
+		"read_bytes", "write_bytes"}
+	names = append(names, zpoolIostatLatencies...)
+	for i, value := range cols[1:] {
+		if i >= len(names) {
+			break
+		}
+		if v, err := strconv.ParseInt(value, 10, 64); err == nil {
+			fields[names[i]] = v
+		}
+	}
+	return fields
+}
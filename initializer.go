@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Initializer is implemented by the plugins, of any kind, which check their
+// options, compile their patterns or resolve their paths once configured.
+// Init is called when the agent starts, so that a misconfigured plugin
+// stops the agent from starting, with an error naming the plugin, rather
+// than failing on every interval.
+type Initializer interface {
+	Init() error
+}
+
+// initPlugin calls the Init of the plugin, if it has one.
+func initPlugin(kind, name string, plugin interface{}) error {
+	if p, ok := plugin.(Initializer); ok {
+		if err := p.Init(); err != nil {
+			return fmt.Errorf("could not initialize %s.%s: %s", kind, name, err)
+		}
+	}
+	return nil
+}
+
+// initWorkers is the number of plugins initialized at once.
+const initWorkers = 32
+
+// pluginInit is a plugin to initialize, with its kind and name.
+type pluginInit struct {
+	kind   string
+	name   string
+	plugin Initializer
+}
+
+// InitPlugins initializes the plugins of the config concurrently, logging
+// the time each one takes. The plugins are only initialized once the agent
+// is created, so that the inputs disabled in the zone are not. It fails on
+// the first plugin failing to initialize, or with the plugins still
+// initializing once the startup timeout of the agent, if any, is over.
+func (a *Agent) InitPlugins() error {
+	var plugins []pluginInit
+	add := func(kind, name string, plugin interface{}) {
+		if p, ok := plugin.(Initializer); ok {
+			plugins = append(plugins, pluginInit{kind, name, p})
+		}
+	}
+	for _, o := range a.Config.Outputs {
+		add("outputs", o.Config.Name, o.Output)
+	}
+	for _, p := range a.Config.Processors {
+		add("processors", p.Config.Name, p.Processor)
+	}
+	for _, agg := range a.Config.Aggregators {
+		add("aggregators", agg.Config.Name, agg.a)
+	}
+	for _, input := range a.Config.Inputs {
+		add("inputs", input.Config.Name, input.Input)
+	}
+	if len(plugins) == 0 {
+		return nil
+	}
+
+	// the number of plugins of each name still initializing, to tell
+	// which ones are late
+	var mu sync.Mutex
+	pending := make(map[string]int)
+	for _, p := range plugins {
+		pending[p.kind+"."+p.name]++
+	}
+
+	start := time.Now()
+	jobs := make(chan pluginInit, len(plugins))
+	for _, p := range plugins {
+		jobs <- p
+	}
+	close(jobs)
+	// stops the workers once an error or the timeout is returned
+	done := make(chan struct{})
+	defer close(done)
+	errC := make(chan error, len(plugins))
+
+	workers := initWorkers
+	if len(plugins) < workers {
+		workers = len(plugins)
+	}
+	for i := 0; i < workers; i++ {
+		go func() {
+			for p := range jobs {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				t := time.Now()
+				err := initPlugin(p.kind, p.name, p.plugin)
+				log.Printf("D! Initialized %s.%s in %s", p.kind, p.name,
+					time.Since(t))
+
+				name := p.kind + "." + p.name
+				mu.Lock()
+				if pending[name]--; pending[name] == 0 {
+					delete(pending, name)
+				}
+				mu.Unlock()
+				errC <- err
+			}
+		}()
+	}
+
+	var timeout <-chan time.Time
+	if d := a.Config.Agent.StartupTimeout.Duration; d > 0 {
+		t := time.NewTimer(d)
+		defer t.Stop()
+		timeout = t.C
+	}
+	for range plugins {
+		select {
+		case err := <-errC:
+			if err != nil {
+				return err
+			}
+		case <-timeout:
+			mu.Lock()
+			var late []string
+			for name, n := range pending {
+				if n > 1 {
+					name = fmt.Sprintf("%s (%d)", name, n)
+				}
+				late = append(late, name)
+			}
+			mu.Unlock()
+			sort.Strings(late)
+			return fmt.Errorf("plugins not initialized within the startup_timeout "+
+				"of %s: %s", a.Config.Agent.StartupTimeout.Duration,
+				strings.Join(late, " "))
+		}
+	}
+	log.Printf("I! Initialized %d plugins in %s", len(plugins), time.Since(start))
+	return nil
+}
@@ -10,4 +10,23 @@ type Input interface {
 	// Gather takes in an accumulator and adds the metrics that the Input
 	// gathers. This is called every "interval"
 	Gather(Accumulator) error
+}
+
+// ServiceInput is an Input running in the background, ie, a listener, that
+// the agent starts before gathering from it and stops on shutdown.
+//
+// When Start fails, or Gather returns an error, the service failed: the agent
+// stops it and starts it again after the restart_delay of the input, doubled
+// on each consecutive failure up to 5 minutes, unless its restart_policy is
+// "never". A service failing in the background, as a listener whose socket
+// stopped accepting, must return the error from its next Gather.
+type ServiceInput interface {
+	Input
+
+	// Start starts the service, the metrics it receives in the background
+	// being added to the accumulator. It must not block.
+	Start(Accumulator) error
+
+	// Stop stops the service, closing its listeners and connections.
+	Stop()
 }
\ No newline at end of file
@@ -14,6 +14,7 @@ import (
 	"os"
 	"os/exec"
 	"strings"
+	"text/template"
 	"time"
 	"unicode"
 )
@@ -114,6 +115,28 @@ func GetTLSConfig(
 	return t, nil
 }
 
+// GetServerTLSConfig returns the tls.Config of a server with the certificate
+// and key of the given files, loaded so that a listener fails to start rather
+// than to serve. It returns a nil pointer if neither is given.
+func GetServerTLSConfig(cert, key string) (*tls.Config, error) {
+	if cert == "" && key == "" {
+		return nil, nil
+	}
+	if cert == "" || key == "" {
+		return nil, fmt.Errorf("tls_cert and tls_key must be set together")
+	}
+	pair, err := tls.LoadX509KeyPair(cert, key)
+	if err != nil {
+		return nil, fmt.Errorf("could not load the TLS key/certificate from "+
+			"%s:%s: %s", key, cert, err)
+	}
+	// h2 as http.Server.ServeTLS does, gRPC needing HTTP/2
+	return &tls.Config{
+		Certificates: []tls.Certificate{pair},
+		NextProtos:   []string{"h2", "http/1.1"},
+	}, nil
+}
+
 // SnakeCase converts the given string to snake case following the Golang format:
 // acronyms are converted to lower-case and preceded by an underscore.
 func SnakeCase(in string) string {
@@ -199,3 +222,13 @@ func RandomSleep(max time.Duration, shutdown chan struct{}) {
 		return
 	}
 }
+
+// parseTemplate parses the text of a template, the missing keys being
+// rendered as their zero value.
+func parseTemplate(name, text string) (*template.Template, error) {
+	t, err := template.New(name).Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s template: %s", name, err)
+	}
+	return t, nil
+}
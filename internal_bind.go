@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// BindingPlugin is a network plugin able to bind its sockets to a local
+// address, on multi-homed hosts the traffic of which must go through a
+// given network: the listeners listen on it and the clients connect from
+// it. SetLocalBinding is called before Start or Connect with the local
+// address of the local_address or interface option of the plugin.
+type BindingPlugin interface {
+	SetLocalBinding(b *LocalBinding)
+}
+
+// LocalBinding is the local address a plugin binds its sockets to: the
+// address itself, or the name of an interface the address of which is
+// used, looked up again at each connection to follow its changes.
+type LocalBinding struct {
+	Address   string
+	Interface string
+}
+
+// buildLocalBinding parses the local_address and interface options of the
+// plugin, returning nil if neither is set.
+func buildLocalBinding(name string, tbl *Table) (*LocalBinding, error) {
+	b := &LocalBinding{}
+	if node, ok := tbl.Fields["local_address"]; ok {
+		if kv, ok := node.(*KeyValue); ok {
+			if str, ok := kv.Value.(*String); ok {
+				b.Address = str.Value
+			}
+		}
+	}
+	if node, ok := tbl.Fields["interface"]; ok {
+		if kv, ok := node.(*KeyValue); ok {
+			if str, ok := kv.Value.(*String); ok {
+				b.Interface = str.Value
+			}
+		}
+	}
+	delete(tbl.Fields, "local_address")
+	delete(tbl.Fields, "interface")
+
+	switch {
+	case b.Address == "" && b.Interface == "":
+		return nil, nil
+	case b.Address != "" && b.Interface != "":
+		return nil, fmt.Errorf("%s: local_address and interface cannot both be set",
+			name)
+	case b.Address != "" && net.ParseIP(b.Address) == nil:
+		return nil, fmt.Errorf("%s: invalid local_address %q, expected an IP "+
+			"address", name, b.Address)
+	case b.Interface != "":
+		if _, err := net.InterfaceByName(b.Interface); err != nil {
+			return nil, fmt.Errorf("%s: invalid interface %q: %s", name,
+				b.Interface, err)
+		}
+	}
+	return b, nil
+}
+
+func (b *LocalBinding) String() string {
+	if b.Interface != "" {
+		return "interface " + b.Interface
+	}
+	return b.Address
+}
+
+// IP returns the local address of the family, IPv6 or IPv4. The global
+// addresses of an interface are preferred to its link-local ones.
+func (b *LocalBinding) IP(ipv6 bool) (net.IP, error) {
+	family := "IPv4"
+	if ipv6 {
+		family = "IPv6"
+	}
+	if b.Interface == "" {
+		ip := net.ParseIP(b.Address)
+		if (ip.To4() == nil) != ipv6 {
+			return nil, fmt.Errorf("local address %s is not an %s address",
+				b.Address, family)
+		}
+		return ip, nil
+	}
+
+	iface, err := net.InterfaceByName(b.Interface)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	var linkLocal net.IP
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok || (ipnet.IP.To4() == nil) != ipv6 {
+			continue
+		}
+		if !ipnet.IP.IsLinkLocalUnicast() {
+			return ipnet.IP, nil
+		}
+		if linkLocal == nil {
+			linkLocal = ipnet.IP
+		}
+	}
+	// the IPv6 link-local addresses need the zone of the interface
+	if linkLocal != nil && !ipv6 {
+		return linkLocal, nil
+	}
+	return nil, fmt.Errorf("interface %s has no %s address", b.Interface, family)
+}
+
+// localAddr returns the local address of the connections to the remote IP
+// over the network.
+func (b *LocalBinding) localAddr(network string, remote net.IP) (net.Addr, error) {
+	ip, err := b.IP(remote.To4() == nil)
+	if err != nil {
+		return nil, err
+	}
+	switch network {
+	case "udp", "udp4", "udp6":
+		return &net.UDPAddr{IP: ip}, nil
+	}
+	return &net.TCPAddr{IP: ip}, nil
+}
+
+// Dialer returns a dialer of the connections to the address bound to the
+// local address, of the family of the network, tcp6 or udp6 dialing over
+// IPv6, or else of the first address of the host. The dialer only tries the
+// addresses of the host of that family. A nil binding returns a dialer not
+// bound.
+func (b *LocalBinding) Dialer(network, address string, timeout time.Duration) (*net.Dialer, error) {
+	d := &net.Dialer{Timeout: timeout}
+	if b == nil {
+		return d, nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := DefaultResolver.LookupHost(host)
+	if err != nil {
+		return nil, err
+	}
+	var remote net.IP
+	for _, addr := range addrs {
+		ip := net.ParseIP(addr)
+		if ip == nil {
+			continue
+		}
+		switch {
+		case strings.HasSuffix(network, "4") && ip.To4() == nil:
+		case strings.HasSuffix(network, "6") && ip.To4() != nil:
+		default:
+			remote = ip
+		}
+		if remote != nil {
+			break
+		}
+	}
+	if remote == nil {
+		return nil, fmt.Errorf("no %s address of %s", network, host)
+	}
+	if d.LocalAddr, err = b.localAddr(network, remote); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// DialContext connects to the address as DefaultResolver.DialContext does,
+// from the local address. It can be used as the DialContext of an
+// http.Transport, a nil binding dialing from any address.
+func (b *LocalBinding) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	return DefaultResolver.dial(ctx, network, address, b)
+}
+
+// ListenAddress returns the listen address with its host replaced by the
+// local address, of the IPv6 family over tcp6 and udp6 or if the host is an
+// IPv6 address. A nil binding returns the address unchanged.
+func (b *LocalBinding) ListenAddress(network, address string) (string, error) {
+	if b == nil {
+		return address, nil
+	}
+	if strings.HasPrefix(network, "unix") {
+		return "", fmt.Errorf("cannot bind %s sockets to %s", network, b)
+	}
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", err
+	}
+	ip := net.ParseIP(host)
+	ipv6 := strings.HasSuffix(network, "6") || ip != nil && ip.To4() == nil
+	local, err := b.IP(ipv6)
+	if err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(local.String(), port), nil
+}
@@ -35,8 +35,10 @@ func (b *Buffer) Len() int {
 	return len(b.buf)
 }
 
-// Add adds metrics to the buffer.
-func (b *Buffer) Add(metrics ...Metric) {
+// Add adds metrics to the buffer and returns how many of the oldest metrics
+// had to be dropped to make room for them.
+func (b *Buffer) Add(metrics ...Metric) int {
+	var dropped int
 	for i, _ := range metrics {
 		MetricsWritten.Incr(1)
 		select {
@@ -44,11 +46,13 @@ func (b *Buffer) Add(metrics ...Metric) {
 		default:
 			b.mu.Lock()
 			MetricsDropped.Incr(1)
+			dropped++
 			<-b.buf
 			b.buf <- metrics[i]
 			b.mu.Unlock()
 		}
 	}
+	return dropped
 }
 
 // Batch returns a batch of metrics of size batchSize.
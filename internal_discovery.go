@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EndpointDiscovery resolves the endpoints of an output from a service
+// registry, DNS SRV records or the Consul catalog, so that the outputs
+// follow the ingest endpoints as they move instead of writing to the
+// addresses of their config.
+type EndpointDiscovery struct {
+	// Source is the registry, "srv" or "consul".
+	Source string
+	// Service is the name of the SRV records, or of the Consul service.
+	Service string
+	// Scheme and Path make the endpoints URLs, scheme://host:port/path.
+	// Without a scheme, the endpoints are host:port.
+	Scheme string
+	Path   string
+
+	// HealthCheck only keeps the SRV targets accepting a TCP connection,
+	// Consul only returning the instances passing their checks.
+	HealthCheck bool `toml:"health_check"`
+
+	ConsulAddress    string `toml:"consul_address"`
+	ConsulTag        string `toml:"consul_tag"`
+	ConsulDatacenter string `toml:"consul_datacenter"`
+	ConsulToken      string `toml:"consul_token"`
+
+	RefreshInterval Duration `toml:"refresh_interval"`
+	Timeout         Duration
+
+	lookupSRV func(service, proto, name string) (string, []*net.SRV, error)
+	dial      func(network, address string, timeout time.Duration) (net.Conn, error)
+}
+
+func newEndpointDiscovery() *EndpointDiscovery {
+	return &EndpointDiscovery{
+		HealthCheck:     true,
+		ConsulAddress:   "http://127.0.0.1:8500",
+		RefreshInterval: Duration{Duration: 60 * time.Second},
+		Timeout:         Duration{Duration: 5 * time.Second},
+		lookupSRV:       net.LookupSRV,
+		dial:            net.DialTimeout,
+	}
+}
+
+// init validates the discovery config.
+func (d *EndpointDiscovery) init() error {
+	switch d.Source {
+	case "srv", "consul":
+	default:
+		return fmt.Errorf("invalid discovery source %q, expected srv or consul",
+			d.Source)
+	}
+	if d.Service == "" {
+		return fmt.Errorf("no discovery service configured")
+	}
+	if d.Path != "" && d.Scheme == "" {
+		return fmt.Errorf("discovery path %q set without a scheme", d.Path)
+	}
+	if d.RefreshInterval.Duration <= 0 {
+		return fmt.Errorf("invalid discovery refresh_interval %s",
+			d.RefreshInterval.Duration)
+	}
+	return nil
+}
+
+// resolve returns the endpoints of the service, in the order they should be
+// preferred in, or an error if the registry has none healthy.
+func (d *EndpointDiscovery) resolve() ([]string, error) {
+	var addrs []string
+	var err error
+	switch d.Source {
+	case "srv":
+		addrs, err = d.resolveSRV()
+	case "consul":
+		addrs, err = d.resolveConsul()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("no healthy endpoint found for %s", d.Service)
+	}
+
+	endpoints := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		if d.Scheme == "" {
+			endpoints = append(endpoints, addr)
+			continue
+		}
+		endpoints = append(endpoints, d.Scheme+"://"+addr+d.Path)
+	}
+	return endpoints, nil
+}
+
+// resolveSRV returns the targets of the SRV records, by priority and then
+// randomly by weight, leaving out the ones not accepting a connection if
+// HealthCheck is set.
+func (d *EndpointDiscovery) resolveSRV() ([]string, error) {
+	_, records, err := d.lookupSRV("", "", d.Service)
+	if err != nil {
+		return nil, err
+	}
+	var addrs []string
+	for _, srv := range records {
+		addr := net.JoinHostPort(strings.TrimSuffix(srv.Target, "."),
+			strconv.Itoa(int(srv.Port)))
+		if d.HealthCheck {
+			conn, err := d.dial("tcp", addr, d.Timeout.Duration)
+			if err != nil {
+				continue
+			}
+			conn.Close()
+		}
+		addrs = append(addrs, addr)
+	}
+	return addrs, nil
+}
+
+// consulServiceEntry is the part of an entry of the Consul health API used.
+type consulServiceEntry struct {
+	Node struct {
+		Address string
+	}
+	Service struct {
+		Address string
+		Port    int
+	}
+}
+
+// resolveConsul returns the instances of the Consul service, nearest to the
+// local agent first, only the ones passing their checks if HealthCheck is
+// set.
+func (d *EndpointDiscovery) resolveConsul() ([]string, error) {
+	params := url.Values{}
+	params.Set("near", "_agent")
+	if d.HealthCheck {
+		params.Set("passing", "1")
+	}
+	if d.ConsulTag != "" {
+		params.Set("tag", d.ConsulTag)
+	}
+	if d.ConsulDatacenter != "" {
+		params.Set("dc", d.ConsulDatacenter)
+	}
+	u := strings.TrimSuffix(d.ConsulAddress, "/") + "/v1/health/service/" +
+		url.PathEscape(d.Service) + "?" + params.Encode()
+
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if d.ConsulToken != "" {
+		req.Header.Set("X-Consul-Token", d.ConsulToken)
+	}
+	client := &http.Client{Timeout: d.Timeout.Duration}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", d.ConsulAddress, resp.Status)
+	}
+
+	var entries []consulServiceEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, fmt.Errorf("error decoding the Consul response: %s", err)
+	}
+	var addrs []string
+	for _, entry := range entries {
+		host := entry.Service.Address
+		if host == "" {
+			host = entry.Node.Address
+		}
+		addrs = append(addrs, net.JoinHostPort(host,
+			strconv.Itoa(entry.Service.Port)))
+	}
+	return addrs, nil
+}
+
+// sameEndpoints returns true if a and b have the same endpoints, whatever
+// their order.
+func sameEndpoints(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a = append([]string{}, a...)
+	b = append([]string{}, b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	return sameAddrs(a, b)
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	dnsTypeA     = 1
+	dnsTypeCNAME = 5
+	dnsTypeAAAA  = 28
+	dnsClassIN   = 1
+
+	// flags of the header of the messages
+	dnsFlagResponse   = 1 << 15
+	dnsFlagTruncated  = 1 << 9
+	dnsFlagRecursion  = 1 << 8
+	dnsRcodeMask      = 0xf
+	dnsRcodeNameError = 3
+
+	dnsTimeout = 2 * time.Second
+)
+
+// resolvConf is the file listing the nameservers queried for the TTLs.
+var resolvConf = "/etc/resolv.conf"
+
+// dnsServers returns the addresses of the nameservers of the resolv.conf
+// file, the local one if it lists none.
+func dnsServers(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var servers []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "nameserver" {
+			continue
+		}
+		if ip := net.ParseIP(fields[1]); ip != nil {
+			servers = append(servers, net.JoinHostPort(ip.String(), "53"))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(servers) == 0 {
+		servers = []string{"127.0.0.1:53"}
+	}
+	return servers, nil
+}
+
+// queryTTL queries the nameservers of resolv.conf for the A and AAAA records
+// of the fully qualified host, returning their addresses, sorted, and the
+// smallest TTL of the records of the answers, the CNAME ones included.
+func queryTTL(host string) ([]string, time.Duration, error) {
+	servers, err := dnsServers(resolvConf)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var addrs []string
+	ttl := time.Duration(-1)
+	for _, qtype := range []uint16{dnsTypeA, dnsTypeAAAA} {
+		var answer []string
+		var answerTTL time.Duration
+		for _, server := range servers {
+			answer, answerTTL, err = dnsQuery(server, host, qtype)
+			if err == nil {
+				break
+			}
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		addrs = append(addrs, answer...)
+		if len(answer) != 0 && (ttl < 0 || answerTTL < ttl) {
+			ttl = answerTTL
+		}
+	}
+	if len(addrs) == 0 {
+		return nil, 0, fmt.Errorf("no address records for %s", host)
+	}
+	sort.Strings(addrs)
+	return addrs, ttl, nil
+}
+
+// dnsQuery queries the server for the records of the type of the host, over
+// UDP, and again over TCP if the answer is truncated.
+func dnsQuery(server, host string, qtype uint16) ([]string, time.Duration, error) {
+	query, id, err := dnsMessage(host, qtype)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	conn, err := net.DialTimeout("udp", server, dnsTimeout)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(dnsTimeout))
+	if _, err := conn.Write(query); err != nil {
+		return nil, 0, err
+	}
+	buf := make([]byte, 1232)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(buf[:n]) >= 4 &&
+		binary.BigEndian.Uint16(buf[2:])&dnsFlagTruncated == 0 {
+		return dnsAnswer(buf[:n], id, qtype)
+	}
+
+	tcp, err := net.DialTimeout("tcp", server, dnsTimeout)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer tcp.Close()
+	tcp.SetDeadline(time.Now().Add(dnsTimeout))
+	framed := make([]byte, 2+len(query))
+	binary.BigEndian.PutUint16(framed, uint16(len(query)))
+	copy(framed[2:], query)
+	if _, err := tcp.Write(framed); err != nil {
+		return nil, 0, err
+	}
+	var length [2]byte
+	if _, err := io.ReadFull(tcp, length[:]); err != nil {
+		return nil, 0, err
+	}
+	buf = make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(tcp, buf); err != nil {
+		return nil, 0, err
+	}
+	return dnsAnswer(buf, id, qtype)
+}
+
+// dnsMessage returns the query of the records of the type of the host,
+// recursion desired, and its id.
+func dnsMessage(host string, qtype uint16) ([]byte, uint16, error) {
+	var b [2]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return nil, 0, err
+	}
+	id := binary.BigEndian.Uint16(b[:])
+
+	msg := make([]byte, 12, 12+len(host)+6)
+	binary.BigEndian.PutUint16(msg[0:], id)
+	binary.BigEndian.PutUint16(msg[2:], dnsFlagRecursion)
+	binary.BigEndian.PutUint16(msg[4:], 1)
+	for _, label := range strings.Split(strings.TrimSuffix(host, "."), ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return nil, 0, fmt.Errorf("invalid host name %q", host)
+		}
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	msg = append(msg, 0, 0, 0, 0, 0)
+	binary.BigEndian.PutUint16(msg[len(msg)-4:], qtype)
+	binary.BigEndian.PutUint16(msg[len(msg)-2:], dnsClassIN)
+	return msg, id, nil
+}
+
+// dnsAnswer returns the addresses of the records of the type of the answer
+// to the query of the id, and the smallest TTL of its records. A host with
+// no records of the type has no addresses.
+func dnsAnswer(msg []byte, id, qtype uint16) ([]string, time.Duration, error) {
+	if len(msg) < 12 {
+		return nil, 0, fmt.Errorf("short DNS message")
+	}
+	flags := binary.BigEndian.Uint16(msg[2:])
+	if binary.BigEndian.Uint16(msg) != id || flags&dnsFlagResponse == 0 {
+		return nil, 0, fmt.Errorf("unexpected DNS message")
+	}
+	switch rcode := flags & dnsRcodeMask; rcode {
+	case 0:
+	case dnsRcodeNameError:
+		return nil, 0, fmt.Errorf("no such host")
+	default:
+		return nil, 0, fmt.Errorf("DNS error, rcode %d", rcode)
+	}
+	questions := int(binary.BigEndian.Uint16(msg[4:]))
+	answers := int(binary.BigEndian.Uint16(msg[6:]))
+
+	off := 12
+	var err error
+	for i := 0; i < questions; i++ {
+		if off, err = dnsSkipName(msg, off); err != nil {
+			return nil, 0, err
+		}
+		off += 4
+	}
+
+	var addrs []string
+	ttl := time.Duration(-1)
+	for i := 0; i < answers; i++ {
+		if off, err = dnsSkipName(msg, off); err != nil {
+			return nil, 0, err
+		}
+		if off+10 > len(msg) {
+			return nil, 0, fmt.Errorf("short DNS message")
+		}
+		rtype := binary.BigEndian.Uint16(msg[off:])
+		rttl := time.Duration(binary.BigEndian.Uint32(msg[off+4:])) * time.Second
+		length := int(binary.BigEndian.Uint16(msg[off+8:]))
+		off += 10
+		if off+length > len(msg) {
+			return nil, 0, fmt.Errorf("short DNS message")
+		}
+		data := msg[off : off+length]
+		off += length
+
+		switch {
+		case rtype == qtype && rtype == dnsTypeA && length == net.IPv4len,
+			rtype == qtype && rtype == dnsTypeAAAA && length == net.IPv6len:
+			addrs = append(addrs, net.IP(data).String())
+		case rtype == dnsTypeCNAME:
+		default:
+			continue
+		}
+		if ttl < 0 || rttl < ttl {
+			ttl = rttl
+		}
+	}
+	if len(addrs) == 0 {
+		return nil, 0, nil
+	}
+	return addrs, ttl, nil
+}
+
+// dnsSkipName returns the offset past the name at the offset of the
+// message, compressed or not.
+func dnsSkipName(msg []byte, off int) (int, error) {
+	for {
+		if off >= len(msg) {
+			return 0, fmt.Errorf("short DNS message")
+		}
+		length := int(msg[off])
+		switch {
+		case length == 0:
+			return off + 1, nil
+		case length&0xc0 == 0xc0:
+			// a pointer to the rest of the name
+			return off + 2, nil
+		}
+		off += 1 + length
+	}
+}
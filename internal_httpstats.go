@@ -0,0 +1,84 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// writeStatsTransport counts the bytes of the request bodies an HTTP output
+// sends and the status codes of the responses it gets, as internal stats of
+// the output, next to the ones RunningOutput keeps.
+type writeStatsTransport struct {
+	output string
+	next   http.RoundTripper
+
+	bytesWritten Stat
+
+	mu        sync.Mutex
+	responses map[string]Stat
+}
+
+// NewWriteStatsTransport wraps the transport of the HTTP client of an output
+// to report its bytes written and response status codes. A nil next uses
+// http.DefaultTransport.
+func NewWriteStatsTransport(output string, next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &writeStatsTransport{
+		output: output,
+		next:   next,
+		bytesWritten: Register(
+			"write",
+			"bytes_written",
+			map[string]string{"output": output},
+		),
+		responses: make(map[string]Stat),
+	}
+}
+
+func (t *writeStatsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil && req.Body != http.NoBody {
+		r := *req
+		r.Body = &countingBody{ReadCloser: req.Body, stat: t.bytesWritten}
+		req = &r
+	}
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		t.response("error").Incr(1)
+		return nil, err
+	}
+	t.response(strconv.Itoa(resp.StatusCode)).Incr(1)
+	return resp, nil
+}
+
+// response returns the stat counting the responses with the status code,
+// "error" counting the requests that got no response.
+func (t *writeStatsTransport) response(code string) Stat {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	stat, ok := t.responses[code]
+	if !ok {
+		stat = Register(
+			"write_http",
+			"responses",
+			map[string]string{"output": t.output, "status_code": code},
+		)
+		t.responses[code] = stat
+	}
+	return stat
+}
+
+// countingBody counts the bytes read from a request body as they are sent.
+type countingBody struct {
+	io.ReadCloser
+	stat Stat
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.stat.Incr(int64(n))
+	return n, err
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// kerberosRenewBefore is how long before they expire the tickets are
+// renewed, so that they do not expire during a request.
+const kerberosRenewBefore = 5 * time.Minute
+
+// Kerberos authenticates the requests of an HTTP output with SPNEGO, the
+// Negotiate scheme, for the services behind a single sign-on accepting
+// Kerberos only. The principal logs in with its keys of the keytab, the
+// host keytab by default, and gets the tickets of the <service>/<host>
+// principals of the hosts of the requests, renewed before they expire.
+type Kerberos struct {
+	Keytab string
+	// Principal defaults to the one of the first key of the keytab.
+	Principal string
+	// KDCs default to the ones of the SRV records of the realm.
+	KDCs    []string `toml:"kdcs"`
+	Service string
+	Timeout Duration
+
+	// renew serializes the exchanges with the KDCs, mu being released
+	// during them for the requests with a valid ticket
+	renew sync.Mutex
+
+	mu      sync.Mutex
+	client  *kdcClient
+	tgt     *kerberosTicket
+	tickets map[string]*kerberosTicket
+	// lastAuth is the time of the last authenticator, the ones of a ticket
+	// differing for the replay cache of the services
+	lastAuth time.Time
+}
+
+func newKerberos() *Kerberos {
+	return &Kerberos{
+		Keytab:  "/etc/krb5.keytab",
+		Service: "HTTP",
+		Timeout: Duration{Duration: 10 * time.Second},
+	}
+}
+
+// init validates the Kerberos config and the keytab.
+func (k *Kerberos) init() error {
+	if k.Service == "" {
+		return fmt.Errorf("the kerberos service must not be empty")
+	}
+	if _, _, err := k.keys(); err != nil {
+		return err
+	}
+	k.tickets = make(map[string]*kerberosTicket)
+	return nil
+}
+
+// keys reads the keys of the principal in the keytab, the strongest
+// enctype first, and returns them with the client to log in with them. The
+// keytab is read again at each login, to get the keys it was updated with.
+func (k *Kerberos) keys() ([]keytabEntry, *kdcClient, error) {
+	entries, err := readKeytab(k.Keytab)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not read the keytab: %s", err)
+	}
+	principal := k.Principal
+	if principal == "" {
+		if len(entries) == 0 {
+			return nil, nil, fmt.Errorf("no key in the keytab %s", k.Keytab)
+		}
+		principal = entries[0].principal
+	}
+	name, realm, err := splitPrincipal(principal)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// the latest key of each enctype
+	var keys []keytabEntry
+	for _, etype := range []int32{etypeAES256, etypeAES128} {
+		var latest *keytabEntry
+		for i, e := range entries {
+			if e.principal == principal && e.key.etype == etype &&
+				(latest == nil || e.kvno > latest.kvno) {
+				latest = &entries[i]
+			}
+		}
+		if latest != nil {
+			keys = append(keys, *latest)
+		}
+	}
+	if len(keys) == 0 {
+		return nil, nil, fmt.Errorf("no AES key of %s in the keytab %s",
+			principal, k.Keytab)
+	}
+
+	client := &kdcClient{
+		name:    name,
+		realm:   realm,
+		kdcs:    k.KDCs,
+		timeout: k.Timeout.Duration,
+	}
+	return keys, client, nil
+}
+
+// token returns a new SPNEGO token for the host, getting the ticket of its
+// service if there is none or it is about to expire.
+func (k *Kerberos) token(host string) (string, error) {
+	host = strings.ToLower(host)
+	k.mu.Lock()
+	client, t := k.client, k.tickets[host]
+	k.mu.Unlock()
+	if !t.valid() {
+		var err error
+		if client, t, err = k.ticket(host); err != nil {
+			return "", err
+		}
+	}
+
+	k.mu.Lock()
+	now := time.Now()
+	if !now.After(k.lastAuth) {
+		now = k.lastAuth.Add(time.Microsecond)
+	}
+	k.lastAuth = now
+	k.mu.Unlock()
+	token, err := client.negotiateToken(t, now)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(token), nil
+}
+
+// ticket gets the ticket of the service of the host from the KDCs, logging
+// in first if the ticket granting ticket is about to expire. The requests
+// of the other hosts are not blocked by the exchanges.
+func (k *Kerberos) ticket(host string) (*kdcClient, *kerberosTicket, error) {
+	k.renew.Lock()
+	defer k.renew.Unlock()
+
+	// another request may have got it while waiting
+	k.mu.Lock()
+	client, tgt, t := k.client, k.tgt, k.tickets[host]
+	k.mu.Unlock()
+	if t.valid() {
+		return client, t, nil
+	}
+
+	if !tgt.valid() {
+		keys, c, err := k.keys()
+		if err != nil {
+			return nil, nil, err
+		}
+		if tgt, err = c.login(keys); err != nil {
+			return nil, nil, fmt.Errorf("could not log in as %s: %s",
+				c.principal(), err)
+		}
+		client = c
+		k.mu.Lock()
+		k.client, k.tgt = client, tgt
+		k.mu.Unlock()
+	}
+
+	t, err := client.serviceTicket(tgt, []string{k.Service, host})
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err != nil {
+		// the next request logs in again, in case the KDC lost it
+		k.tgt = nil
+		return nil, nil, fmt.Errorf("could not get a ticket of %s/%s: %s",
+			k.Service, host, err)
+	}
+	k.tickets[host] = t
+	return client, t, nil
+}
+
+// forget drops the ticket of the host, the service having refused it.
+func (k *Kerberos) forget(host string) {
+	k.mu.Lock()
+	delete(k.tickets, strings.ToLower(host))
+	k.mu.Unlock()
+}
+
+func (t *kerberosTicket) valid() bool {
+	return t != nil && time.Now().Add(kerberosRenewBefore).Before(t.end)
+}
+
+// negotiateTransport sets the Negotiate authorization of the requests.
+type negotiateTransport struct {
+	kerberos *Kerberos
+	next     http.RoundTripper
+}
+
+// Transport wraps the transport of the HTTP client of an output to
+// authenticate its requests with Kerberos, returning it as is if k is nil.
+func (k *Kerberos) Transport(next http.RoundTripper) http.RoundTripper {
+	if k == nil {
+		return next
+	}
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &negotiateTransport{kerberos: k, next: next}
+}
+
+func (t *negotiateTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := req.URL.Hostname()
+	token, err := t.kerberos.token(host)
+	if err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, fmt.Errorf("kerberos: %s", err)
+	}
+
+	r := *req
+	r.Header = make(http.Header, len(req.Header)+1)
+	for k, v := range req.Header {
+		r.Header[k] = v
+	}
+	r.Header.Set("Authorization", "Negotiate "+token)
+	resp, err := t.next.RoundTrip(&r)
+	if err == nil && resp.StatusCode == http.StatusUnauthorized {
+		// the service may have a new key, get a new ticket next time
+		t.kerberos.forget(host)
+	}
+	return resp, err
+}
@@ -0,0 +1,825 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/asn1"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"time"
+)
+
+// Kerberos 5 client, RFC 4120, of the SPNEGO authentication of the HTTP
+// outputs: it logs in with the keys of a keytab and requests the tickets
+// of the services over TCP, with the AES enctypes of RFC 3962 only.
+
+// Kerberos message types, the application tags of the messages.
+const (
+	krbAuthenticator = 2
+	krbASReq         = 10
+	krbASRep         = 11
+	krbTGSReq        = 12
+	krbTGSRep        = 13
+	krbAPReq         = 14
+	krbEncASRepPart  = 25
+	krbEncTGSRepPart = 26
+	krbError         = 30
+)
+
+// Kerberos enctypes and checksum types.
+const (
+	etypeAES128         = 17
+	etypeAES256         = 18
+	cksumHMACSHA1AES128 = 15
+	cksumHMACSHA1AES256 = 16
+	// cksumGSSAPI is the checksum of the authenticators of the GSS-API
+	// tokens, RFC 4121.
+	cksumGSSAPI = 0x8003
+)
+
+// Kerberos key usages.
+const (
+	usagePAEncTimestamp = 1
+	usageASRepEncPart   = 3
+	usageTGSReqChecksum = 6
+	usageTGSReqAuth     = 7
+	usageTGSRepEncPart  = 8
+	usageAPReqAuth      = 11
+)
+
+// Kerberos pre-authentication data types, name types and error codes.
+const (
+	paTGSReq       = 1
+	paEncTimestamp = 2
+
+	nameTypePrincipal   = 1
+	nameTypeServiceInst = 2
+	nameTypeServiceHost = 3
+
+	krbErrETypeNoSupport = 14
+	krbErrPreauthFailed  = 24
+)
+
+var (
+	oidKerberos5 = asn1.ObjectIdentifier{1, 2, 840, 113554, 1, 2, 2}
+	oidSPNEGO    = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 2}
+)
+
+// krbErrorCodes names the errors of the KDC commonly met.
+var krbErrorCodes = map[int32]string{
+	6:  "client not found in the Kerberos database",
+	7:  "server not found in the Kerberos database",
+	14: "no supported encryption type",
+	18: "client credentials revoked",
+	23: "password has expired",
+	24: "pre-authentication failed",
+	25: "additional pre-authentication required",
+	31: "integrity check on decrypted field failed",
+	32: "ticket expired",
+	37: "clock skew too great",
+	68: "wrong realm",
+}
+
+// kerberosKey is an encryption key, of a principal or of a session.
+type kerberosKey struct {
+	etype int32
+	value []byte
+}
+
+// keytabEntry is a key of a keytab.
+type keytabEntry struct {
+	principal string
+	kvno      uint32
+	key       kerberosKey
+}
+
+// readKeytab reads the entries of a keytab file of the version 0x502 of
+// MIT Kerberos, the one all implementations write.
+func readKeytab(path string) ([]keytabEntry, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < 2 || b[0] != 5 || b[1] != 2 {
+		return nil, fmt.Errorf("%s is not a keytab of version 0x502", path)
+	}
+
+	var entries []keytabEntry
+	r := bytes.NewReader(b[2:])
+	for r.Len() > 0 {
+		var size int32
+		if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+			return nil, fmt.Errorf("truncated keytab %s", path)
+		}
+		if size < 0 {
+			// hole of a deleted entry
+			r.Seek(int64(-size), io.SeekCurrent)
+			continue
+		}
+		record := make([]byte, size)
+		if _, err := io.ReadFull(r, record); err != nil {
+			return nil, fmt.Errorf("truncated keytab %s", path)
+		}
+		e, err := parseKeytabEntry(record)
+		if err != nil {
+			return nil, fmt.Errorf("invalid keytab %s: %s", path, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+func parseKeytabEntry(record []byte) (keytabEntry, error) {
+	e := keytabEntry{}
+	r := bytes.NewReader(record)
+	readString := func() (string, error) {
+		var n uint16
+		if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+			return "", err
+		}
+		s := make([]byte, n)
+		_, err := io.ReadFull(r, s)
+		return string(s), err
+	}
+
+	var count uint16
+	if err := binary.Read(r, binary.BigEndian, &count); err != nil {
+		return e, err
+	}
+	realm, err := readString()
+	if err != nil {
+		return e, err
+	}
+	components := make([]string, count)
+	for i := range components {
+		if components[i], err = readString(); err != nil {
+			return e, err
+		}
+	}
+	e.principal = strings.Join(components, "/") + "@" + realm
+
+	var header struct {
+		NameType  uint32
+		Timestamp uint32
+		KVNO      uint8
+		KeyType   uint16
+		KeyLength uint16
+	}
+	if err := binary.Read(r, binary.BigEndian, &header); err != nil {
+		return e, err
+	}
+	e.kvno = uint32(header.KVNO)
+	e.key.etype = int32(header.KeyType)
+	e.key.value = make([]byte, header.KeyLength)
+	if _, err := io.ReadFull(r, e.key.value); err != nil {
+		return e, err
+	}
+	// the 32 bit kvno of the recent keytabs supersedes the 8 bit one
+	var kvno uint32
+	if binary.Read(r, binary.BigEndian, &kvno) == nil && kvno != 0 {
+		e.kvno = kvno
+	}
+	return e, nil
+}
+
+// splitPrincipal splits a principal into its name components and realm.
+func splitPrincipal(principal string) ([]string, string, error) {
+	i := strings.LastIndex(principal, "@")
+	if i <= 0 || i == len(principal)-1 {
+		return nil, "", fmt.Errorf("invalid principal %q, expected name@REALM",
+			principal)
+	}
+	return strings.Split(principal[:i], "/"), principal[i+1:], nil
+}
+
+// DER encoding of the Kerberos messages, the encoding/asn1 package not
+// marshalling the GeneralString of the Kerberos strings.
+
+func derTLV(tag byte, content ...[]byte) []byte {
+	n := 0
+	for _, c := range content {
+		n += len(c)
+	}
+	b := []byte{tag}
+	switch {
+	case n < 0x80:
+		b = append(b, byte(n))
+	case n < 0x100:
+		b = append(b, 0x81, byte(n))
+	case n < 0x10000:
+		b = append(b, 0x82, byte(n>>8), byte(n))
+	default:
+		b = append(b, 0x83, byte(n>>16), byte(n>>8), byte(n))
+	}
+	for _, c := range content {
+		b = append(b, c...)
+	}
+	return b
+}
+
+func derSeq(content ...[]byte) []byte { return derTLV(0x30, content...) }
+
+// derField is the explicitly tagged field of a sequence.
+func derField(tag int, b []byte) []byte { return derTLV(0xa0|byte(tag), b) }
+
+func derApp(tag int, content ...[]byte) []byte {
+	return derTLV(0x60|byte(tag), content...)
+}
+
+func derInt(n int64) []byte {
+	b, _ := asn1.Marshal(n)
+	return b
+}
+
+func derString(s string) []byte { return derTLV(0x1b, []byte(s)) }
+
+func derOctets(b []byte) []byte { return derTLV(0x04, b) }
+
+func derTime(t time.Time) []byte {
+	return derTLV(0x18, []byte(t.UTC().Format("20060102150405Z")))
+}
+
+// derFlags encodes the 32 bit flags of the options of the requests.
+func derFlags(flags uint32) []byte {
+	return derTLV(0x03, []byte{0, byte(flags >> 24), byte(flags >> 16),
+		byte(flags >> 8), byte(flags)})
+}
+
+func derOID(oid asn1.ObjectIdentifier) []byte {
+	b, _ := asn1.Marshal(oid)
+	return b
+}
+
+func derPrincipal(nameType int64, components []string) []byte {
+	var names []byte
+	for _, c := range components {
+		names = append(names, derString(c)...)
+	}
+	return derSeq(derField(0, derInt(nameType)), derField(1, derSeq(names)))
+}
+
+func derEncryptedData(etype int32, cipher []byte) []byte {
+	return derSeq(derField(0, derInt(int64(etype))), derField(2, derOctets(cipher)))
+}
+
+// Decoding of the replies of the KDC.
+
+type krbPrincipal struct {
+	NameType   int32    `asn1:"explicit,tag:0"`
+	NameString []string `asn1:"explicit,tag:1"`
+}
+
+type krbEncryptedData struct {
+	EType  int32  `asn1:"explicit,tag:0"`
+	KVNO   int64  `asn1:"optional,explicit,tag:1"`
+	Cipher []byte `asn1:"explicit,tag:2"`
+}
+
+type krbEncryptionKey struct {
+	KeyType  int32  `asn1:"explicit,tag:0"`
+	KeyValue []byte `asn1:"explicit,tag:1"`
+}
+
+type krbKDCRep struct {
+	PVNO    int              `asn1:"explicit,tag:0"`
+	MsgType int              `asn1:"explicit,tag:1"`
+	PAData  asn1.RawValue    `asn1:"optional,explicit,tag:2"`
+	CRealm  string           `asn1:"explicit,tag:3"`
+	CName   krbPrincipal     `asn1:"explicit,tag:4"`
+	Ticket  asn1.RawValue    `asn1:"explicit,tag:5"`
+	EncPart krbEncryptedData `asn1:"explicit,tag:6"`
+}
+
+type krbEncKDCRepPart struct {
+	Key           krbEncryptionKey `asn1:"explicit,tag:0"`
+	LastReq       asn1.RawValue    `asn1:"explicit,tag:1"`
+	Nonce         int64            `asn1:"explicit,tag:2"`
+	KeyExpiration time.Time        `asn1:"generalized,optional,explicit,tag:3"`
+	Flags         asn1.BitString   `asn1:"explicit,tag:4"`
+	AuthTime      time.Time        `asn1:"generalized,explicit,tag:5"`
+	StartTime     time.Time        `asn1:"generalized,optional,explicit,tag:6"`
+	EndTime       time.Time        `asn1:"generalized,explicit,tag:7"`
+	RenewTill     time.Time        `asn1:"generalized,optional,explicit,tag:8"`
+	SRealm        string           `asn1:"explicit,tag:9"`
+	SName         krbPrincipal     `asn1:"explicit,tag:10"`
+}
+
+type krbErrorMsg struct {
+	PVNO      int          `asn1:"explicit,tag:0"`
+	MsgType   int          `asn1:"explicit,tag:1"`
+	CTime     time.Time    `asn1:"generalized,optional,explicit,tag:2"`
+	CUsec     int          `asn1:"optional,explicit,tag:3"`
+	STime     time.Time    `asn1:"generalized,explicit,tag:4"`
+	SUsec     int          `asn1:"explicit,tag:5"`
+	ErrorCode int32        `asn1:"explicit,tag:6"`
+	CRealm    string       `asn1:"optional,explicit,tag:7"`
+	CName     krbPrincipal `asn1:"optional,explicit,tag:8"`
+	Realm     string       `asn1:"explicit,tag:9"`
+	SName     krbPrincipal `asn1:"explicit,tag:10"`
+	EText     string       `asn1:"optional,explicit,tag:11"`
+}
+
+// kdcError is an error the KDC replied with.
+type kdcError struct {
+	code int32
+	text string
+}
+
+func (e *kdcError) Error() string {
+	msg := krbErrorCodes[e.code]
+	if msg == "" {
+		msg = "unknown error"
+	}
+	if e.text != "" {
+		return fmt.Sprintf("KDC error %d, %s: %s", e.code, msg, e.text)
+	}
+	return fmt.Sprintf("KDC error %d, %s", e.code, msg)
+}
+
+// unmarshalApp decodes the message of the application tag into v.
+func unmarshalApp(b []byte, tag int, v interface{}) error {
+	var raw asn1.RawValue
+	if _, err := asn1.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	if raw.Class == asn1.ClassApplication && raw.Tag == krbError && tag != krbError {
+		e := krbErrorMsg{}
+		if _, err := asn1.Unmarshal(raw.Bytes, &e); err != nil {
+			return fmt.Errorf("invalid KRB-ERROR: %s", err)
+		}
+		return &kdcError{code: e.ErrorCode, text: e.EText}
+	}
+	if raw.Class != asn1.ClassApplication || raw.Tag != tag {
+		return fmt.Errorf("unexpected message %d, expected %d", raw.Tag, tag)
+	}
+	_, err := asn1.Unmarshal(raw.Bytes, v)
+	return err
+}
+
+// kerberosTicket is a ticket of a service and its session key.
+type kerberosTicket struct {
+	ticket []byte
+	key    kerberosKey
+	end    time.Time
+}
+
+// kdcClient exchanges the messages of a principal with the KDCs of its
+// realm.
+type kdcClient struct {
+	name    []string
+	realm   string
+	kdcs    []string
+	timeout time.Duration
+}
+
+// login requests a ticket granting ticket with the keys of the principal,
+// pre-authenticating with the strongest key the KDC supports.
+func (c *kdcClient) login(keys []keytabEntry) (*kerberosTicket, error) {
+	var etypes []byte
+	for _, k := range keys {
+		etypes = append(etypes, derInt(int64(k.key.etype))...)
+	}
+	nonce, err := krbNonce()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	body := derSeq(
+		derField(0, derFlags(0)),
+		derField(1, derPrincipal(nameTypePrincipal, c.name)),
+		derField(2, derString(c.realm)),
+		derField(3, derPrincipal(nameTypeServiceInst,
+			[]string{"krbtgt", c.realm})),
+		derField(5, derTime(now.Add(24*time.Hour))),
+		derField(7, derInt(nonce)),
+		derField(8, derSeq(etypes)),
+	)
+
+	for i, k := range keys {
+		ts := derSeq(
+			derField(0, derTime(now)),
+			derField(1, derInt(int64(now.Nanosecond()/1000))),
+		)
+		encTS, err := k.key.encrypt(usagePAEncTimestamp, ts)
+		if err != nil {
+			return nil, err
+		}
+		padata := derSeq(derSeq(
+			derField(1, derInt(paEncTimestamp)),
+			derField(2, derOctets(derSeq(
+				derField(0, derInt(int64(k.key.etype))),
+				derField(1, derInt(int64(k.kvno))),
+				derField(2, derOctets(encTS)),
+			))),
+		))
+		req := derApp(krbASReq, derSeq(
+			derField(1, derInt(5)),
+			derField(2, derInt(krbASReq)),
+			derField(3, padata),
+			derField(4, body),
+		))
+
+		t, err := c.exchange(req, krbASRep, k.key, usageASRepEncPart, nonce)
+		if e, ok := err.(*kdcError); ok && i < len(keys)-1 &&
+			(e.code == krbErrPreauthFailed || e.code == krbErrETypeNoSupport) {
+			// the KDC may not have this key of the principal, try the next
+			continue
+		}
+		return t, err
+	}
+	return nil, fmt.Errorf("no AES key of %s in the keytab", c.principal())
+}
+
+// serviceTicket requests a ticket of the service with the ticket granting
+// ticket.
+func (c *kdcClient) serviceTicket(tgt *kerberosTicket, service []string) (*kerberosTicket, error) {
+	nonce, err := krbNonce()
+	if err != nil {
+		return nil, err
+	}
+	body := derSeq(
+		derField(0, derFlags(0)),
+		derField(2, derString(c.realm)),
+		derField(3, derPrincipal(nameTypeServiceHost, service)),
+		derField(5, derTime(time.Now().Add(24*time.Hour))),
+		derField(7, derInt(nonce)),
+		derField(8, derSeq(derInt(etypeAES256), derInt(etypeAES128))),
+	)
+	cksum := derSeq(
+		derField(0, derInt(int64(tgt.key.checksumType()))),
+		derField(1, derOctets(tgt.key.checksum(usageTGSReqChecksum, body))),
+	)
+	apReq, err := c.apReq(tgt, cksum, usageTGSReqAuth, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	req := derApp(krbTGSReq, derSeq(
+		derField(1, derInt(5)),
+		derField(2, derInt(krbTGSReq)),
+		derField(3, derSeq(derSeq(
+			derField(1, derInt(paTGSReq)),
+			derField(2, derOctets(apReq)),
+		))),
+		derField(4, body),
+	))
+	return c.exchange(req, krbTGSRep, tgt.key, usageTGSRepEncPart, nonce)
+}
+
+// apReq returns an AP-REQ of the ticket, with a new authenticator holding
+// the checksum.
+func (c *kdcClient) apReq(t *kerberosTicket, cksum []byte, usage uint32, now time.Time) ([]byte, error) {
+	auth := derApp(krbAuthenticator, derSeq(
+		derField(0, derInt(5)),
+		derField(1, derString(c.realm)),
+		derField(2, derPrincipal(nameTypePrincipal, c.name)),
+		derField(3, cksum),
+		derField(4, derInt(int64(now.Nanosecond()/1000))),
+		derField(5, derTime(now)),
+	))
+	encAuth, err := t.key.encrypt(usage, auth)
+	if err != nil {
+		return nil, err
+	}
+	return derApp(krbAPReq, derSeq(
+		derField(0, derInt(5)),
+		derField(1, derInt(krbAPReq)),
+		derField(2, derFlags(0)),
+		derField(3, t.ticket),
+		derField(4, derEncryptedData(t.key.etype, encAuth)),
+	)), nil
+}
+
+// negotiateToken returns the SPNEGO token of the ticket of a service, an
+// AP-REQ of a new authenticator not requesting mutual authentication.
+func (c *kdcClient) negotiateToken(t *kerberosTicket, now time.Time) ([]byte, error) {
+	// GSS-API checksum without channel bindings nor flags, RFC 4121
+	gss := make([]byte, 24)
+	binary.LittleEndian.PutUint32(gss, 16)
+	cksum := derSeq(
+		derField(0, derInt(cksumGSSAPI)),
+		derField(1, derOctets(gss)),
+	)
+	apReq, err := c.apReq(t, cksum, usageAPReqAuth, now)
+	if err != nil {
+		return nil, err
+	}
+	krb5Token := derApp(0, derOID(oidKerberos5), []byte{1, 0}, apReq)
+	return derApp(0, derOID(oidSPNEGO), derField(0, derSeq(
+		derField(0, derSeq(derOID(oidKerberos5))),
+		derField(2, derOctets(krb5Token)),
+	))), nil
+}
+
+func (c *kdcClient) principal() string {
+	return strings.Join(c.name, "/") + "@" + c.realm
+}
+
+// exchange sends the request to the KDCs and decrypts the ticket of the
+// reply with the key.
+func (c *kdcClient) exchange(req []byte, tag int, key kerberosKey, usage uint32, nonce int64) (*kerberosTicket, error) {
+	b, err := c.send(req)
+	if err != nil {
+		return nil, err
+	}
+	rep := krbKDCRep{}
+	if err := unmarshalApp(b, tag, &rep); err != nil {
+		return nil, err
+	}
+	if rep.EncPart.EType != key.etype {
+		return nil, fmt.Errorf("reply encrypted with enctype %d, expected %d",
+			rep.EncPart.EType, key.etype)
+	}
+	plain, err := key.decrypt(usage, rep.EncPart.Cipher)
+	if err != nil {
+		return nil, err
+	}
+	// some KDCs reply to the AS-REQ with an EncTGSRepPart
+	part := krbEncKDCRepPart{}
+	if err := unmarshalApp(plain, krbEncTGSRepPart, &part); err != nil {
+		if err := unmarshalApp(plain, krbEncASRepPart, &part); err != nil {
+			return nil, fmt.Errorf("invalid encrypted part of the reply: %s", err)
+		}
+	}
+	if part.Nonce != nonce {
+		return nil, fmt.Errorf("reply to another request, nonce %d instead of %d",
+			part.Nonce, nonce)
+	}
+	return &kerberosTicket{
+		// the ticket inside its explicit tag
+		ticket: rep.Ticket.Bytes,
+		key:    kerberosKey{etype: part.Key.KeyType, value: part.Key.KeyValue},
+		end:    part.EndTime,
+	}, nil
+}
+
+// send sends the message to the first KDC answering, over TCP, and returns
+// the reply.
+func (c *kdcClient) send(msg []byte) ([]byte, error) {
+	kdcs := c.kdcs
+	if len(kdcs) == 0 {
+		_, srvs, err := net.LookupSRV("kerberos", "tcp", c.realm)
+		if err != nil {
+			return nil, fmt.Errorf("could not find the KDCs of %s: %s", c.realm, err)
+		}
+		for _, srv := range srvs {
+			kdcs = append(kdcs, net.JoinHostPort(
+				strings.TrimSuffix(srv.Target, "."), fmt.Sprint(srv.Port)))
+		}
+	}
+
+	var err error
+	for _, kdc := range kdcs {
+		if _, _, serr := net.SplitHostPort(kdc); serr != nil {
+			kdc = net.JoinHostPort(kdc, "88")
+		}
+		var b []byte
+		if b, err = c.sendTo(kdc, msg); err == nil {
+			return b, nil
+		}
+	}
+	return nil, err
+}
+
+func (c *kdcClient) sendTo(kdc string, msg []byte) ([]byte, error) {
+	conn, err := net.DialTimeout("tcp", kdc, c.timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(c.timeout))
+
+	frame := make([]byte, 4, 4+len(msg))
+	binary.BigEndian.PutUint32(frame, uint32(len(msg)))
+	if _, err := conn.Write(append(frame, msg...)); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(conn, frame[:4]); err != nil {
+		return nil, fmt.Errorf("no reply from KDC %s: %s", kdc, err)
+	}
+	n := binary.BigEndian.Uint32(frame[:4])
+	if n > 1<<20 {
+		return nil, fmt.Errorf("reply of %d bytes from KDC %s too large", n, kdc)
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(conn, b); err != nil {
+		return nil, fmt.Errorf("truncated reply from KDC %s: %s", kdc, err)
+	}
+	return b, nil
+}
+
+func krbNonce() (int64, error) {
+	var b [4]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return 0, err
+	}
+	return int64(binary.BigEndian.Uint32(b[:]) >> 1), nil
+}
+
+// Encryption of the AES enctypes, aes128-cts-hmac-sha1-96 and
+// aes256-cts-hmac-sha1-96, RFC 3961 and RFC 3962.
+
+func (k kerberosKey) checksumType() int32 {
+	if k.etype == etypeAES128 {
+		return cksumHMACSHA1AES128
+	}
+	return cksumHMACSHA1AES256
+}
+
+// derive derives the key of the usage, the kind being 0x99 for the
+// checksums, 0xaa for the encryption and 0x55 for its integrity.
+func (k kerberosKey) derive(usage uint32, kind byte) []byte {
+	constant := make([]byte, 5)
+	binary.BigEndian.PutUint32(constant, usage)
+	constant[4] = kind
+	return deriveKey(k.value, constant)
+}
+
+func (k kerberosKey) checksum(usage uint32, data []byte) []byte {
+	mac := hmac.New(sha1.New, k.derive(usage, 0x99))
+	mac.Write(data)
+	return mac.Sum(nil)[:12]
+}
+
+func (k kerberosKey) encrypt(usage uint32, plain []byte) ([]byte, error) {
+	if err := k.check(); err != nil {
+		return nil, err
+	}
+	confounded := make([]byte, aes.BlockSize+len(plain))
+	if _, err := rand.Read(confounded[:aes.BlockSize]); err != nil {
+		return nil, err
+	}
+	copy(confounded[aes.BlockSize:], plain)
+
+	encrypted, err := aesCTSEncrypt(k.derive(usage, 0xaa), confounded)
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha1.New, k.derive(usage, 0x55))
+	mac.Write(confounded)
+	return append(encrypted, mac.Sum(nil)[:12]...), nil
+}
+
+func (k kerberosKey) decrypt(usage uint32, encrypted []byte) ([]byte, error) {
+	if err := k.check(); err != nil {
+		return nil, err
+	}
+	if len(encrypted) < aes.BlockSize+12 {
+		return nil, fmt.Errorf("encrypted data too short")
+	}
+	n := len(encrypted) - 12
+	confounded, err := aesCTSDecrypt(k.derive(usage, 0xaa), encrypted[:n])
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha1.New, k.derive(usage, 0x55))
+	mac.Write(confounded)
+	if !hmac.Equal(mac.Sum(nil)[:12], encrypted[n:]) {
+		return nil, fmt.Errorf("integrity check of the decrypted data failed")
+	}
+	return confounded[aes.BlockSize:], nil
+}
+
+func (k kerberosKey) check() error {
+	switch {
+	case k.etype == etypeAES128 && len(k.value) == 16:
+	case k.etype == etypeAES256 && len(k.value) == 32:
+	default:
+		return fmt.Errorf("unsupported key of enctype %d", k.etype)
+	}
+	return nil
+}
+
+// deriveKey is the DK function of RFC 3961: the key is encrypted from the
+// constant n-folded to the block size.
+func deriveKey(key, constant []byte) []byte {
+	block, _ := aes.NewCipher(key)
+	derived := make([]byte, 0, len(key)+aes.BlockSize)
+	in := nfold(constant, aes.BlockSize)
+	for len(derived) < len(key) {
+		out := make([]byte, aes.BlockSize)
+		block.Encrypt(out, in)
+		derived = append(derived, out...)
+		in = out
+	}
+	return derived[:len(key)]
+}
+
+// nfold is the n-fold function of RFC 3961, folding the input into n
+// bytes.
+func nfold(in []byte, n int) []byte {
+	k := len(in)
+	lcm := n * k / gcd(n, k)
+	buf := make([]byte, 0, lcm)
+	for i := 0; i < lcm/k; i++ {
+		buf = append(buf, rotateBitsRight(in, 13*i)...)
+	}
+
+	out := make([]byte, n)
+	for i := 0; i < lcm; i += n {
+		carry := 0
+		for j := n - 1; j >= 0; j-- {
+			s := int(out[j]) + int(buf[i+j]) + carry
+			out[j], carry = byte(s), s>>8
+		}
+		// ones' complement addition, the carry wraps around
+		for carry != 0 {
+			for j := n - 1; carry != 0 && j >= 0; j-- {
+				s := int(out[j]) + carry
+				out[j], carry = byte(s), s>>8
+			}
+		}
+	}
+	return out
+}
+
+func rotateBitsRight(in []byte, r int) []byte {
+	bits := len(in) * 8
+	out := make([]byte, len(in))
+	for i := 0; i < bits; i++ {
+		src := ((i-r)%bits + bits) % bits
+		if in[src/8]&(0x80>>uint(src%8)) != 0 {
+			out[i/8] |= 0x80 >> uint(i%8)
+		}
+	}
+	return out
+}
+
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+// aesCTSEncrypt encrypts with AES in CBC mode with ciphertext stealing and
+// a zero IV, the last two blocks swapped.
+func aesCTSEncrypt(key, plain []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(plain) < aes.BlockSize {
+		return nil, fmt.Errorf("data shorter than a block")
+	}
+	padded := make([]byte, (len(plain)+aes.BlockSize-1)/aes.BlockSize*aes.BlockSize)
+	copy(padded, plain)
+	out := make([]byte, len(padded))
+	cipher.NewCBCEncrypter(block, make([]byte, aes.BlockSize)).CryptBlocks(out, padded)
+	if len(out) == aes.BlockSize {
+		return out, nil
+	}
+
+	n := len(out)
+	last := len(plain) - (n - aes.BlockSize)
+	stolen := append([]byte{}, out[:n-2*aes.BlockSize]...)
+	stolen = append(stolen, out[n-aes.BlockSize:]...)
+	return append(stolen, out[n-2*aes.BlockSize:n-2*aes.BlockSize+last]...), nil
+}
+
+func aesCTSDecrypt(key, encrypted []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(encrypted) < aes.BlockSize {
+		return nil, fmt.Errorf("data shorter than a block")
+	}
+	iv := make([]byte, aes.BlockSize)
+	if len(encrypted) == aes.BlockSize {
+		out := make([]byte, aes.BlockSize)
+		cipher.NewCBCDecrypter(block, iv).CryptBlocks(out, encrypted)
+		return out, nil
+	}
+
+	// the full blocks before the last two, then the last full block
+	// ciphertext, and the partial one stolen from the one before
+	n := (len(encrypted) + aes.BlockSize - 1) / aes.BlockSize * aes.BlockSize
+	head := n - 2*aes.BlockSize
+	last := len(encrypted) - head - aes.BlockSize
+	out := make([]byte, len(encrypted))
+	if head > 0 {
+		cipher.NewCBCDecrypter(block, iv).CryptBlocks(out[:head], encrypted[:head])
+		iv = encrypted[head-aes.BlockSize : head]
+	}
+
+	d := make([]byte, aes.BlockSize)
+	block.Decrypt(d, encrypted[head:head+aes.BlockSize])
+	partial := encrypted[head+aes.BlockSize:]
+	prev := append(append([]byte{}, partial...), d[last:]...)
+	for i := 0; i < last; i++ {
+		out[head+aes.BlockSize+i] = d[i] ^ partial[i]
+	}
+	block.Decrypt(out[head:head+aes.BlockSize], prev)
+	for i := 0; i < aes.BlockSize; i++ {
+		out[head+i] ^= iv[i]
+	}
+	return out, nil
+}
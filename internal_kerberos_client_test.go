@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func unhex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// RFC 3961, appendix A.1
+func TestNfold(t *testing.T) {
+	tests := []struct {
+		bits int
+		in   string
+		out  string
+	}{
+		{64, "012345", "be072631276b1955"},
+		{56, "password", "78a07b6caf85fa"},
+		{64, "Rough Consensus, and Running Code", "bb6ed30870b7f0e0"},
+		{168, "password", "59e4a8ca7c0385c3c37b3f6d2000247cb6e6bd5b3e"},
+		{192, "MASSACHVSETTS INSTITVTE OF TECHNOLOGY",
+			"db3b0d8f0b061e603282b308a50841229ad798fab9540c1b"},
+		{168, "Q", "518a54a215a8452a518a54a215a8452a518a54a215"},
+		{168, "ba", "fb25d531ae8974499f52fd92ea9857c4ba24cf297e"},
+		{64, "kerberos", "6b65726265726f73"},
+		{128, "kerberos", "6b65726265726f737b9b5b2b93132b93"},
+		{168, "kerberos", "8372c236344e5f1550cd0747e15d62ca7a5a3bcea4"},
+		{256, "kerberos",
+			"6b65726265726f737b9b5b2b93132b935c9bdcdad95c9899c4cae4dee6d6cae4"},
+	}
+	for _, tt := range tests {
+		got := nfold([]byte(tt.in), tt.bits/8)
+		if !bytes.Equal(got, unhex(t, tt.out)) {
+			t.Errorf("%d-fold(%q) = %x, want %s", tt.bits, tt.in, got, tt.out)
+		}
+	}
+}
+
+// RFC 3962, appendix B: the keys of the string-to-key function, derived
+// from the PBKDF2 output with the "kerberos" constant
+func TestDeriveKey(t *testing.T) {
+	tests := []struct {
+		tkey string
+		key  string
+	}{
+		{"cdedb5281bb2f801565a1122b2563515", "42263c6e89f4fc28b8df68ee09799f15"},
+		{"cdedb5281bb2f801565a1122b25635150ad1f7a04bb9f3a333ecc0e2e1f70837",
+			"fe697b52bc0d3ce14432ba036a92e65bbb52280990a2fa27883998d72af30161"},
+		{"01dbee7f4a9e243e988b62c73cda935d", "c651bf29e2300ac27fa469d693bdda13"},
+		{"01dbee7f4a9e243e988b62c73cda935da05378b93244ec8f48a99e61ad799d86",
+			"a2e16d16b36069c135d5e9d2e25f896102685618b95914b467c67622225824ff"},
+	}
+	for _, tt := range tests {
+		got := deriveKey(unhex(t, tt.tkey), []byte("kerberos"))
+		if !bytes.Equal(got, unhex(t, tt.key)) {
+			t.Errorf("DK(%s, \"kerberos\") = %x, want %s", tt.tkey, got, tt.key)
+		}
+	}
+}
+
+// RFC 3962, appendix B: AES 128 in CBC mode with ciphertext stealing and a
+// zero IV
+func TestAESCTS(t *testing.T) {
+	key := unhex(t, "636869636b656e207465726979616b69")
+	tests := []struct {
+		plain     string
+		encrypted string
+	}{
+		{"4920776f756c64206c696b652074686520",
+			"c6353568f2bf8cb4d8a580362da7ff7f97"},
+		{"4920776f756c64206c696b65207468652047656e6572616c20476175277320",
+			"fc00783e0efdb2c1d445d4c8eff7ed2297687268d6ecccc0c07b25e25ecfe5"},
+		{"4920776f756c64206c696b65207468652047656e6572616c2047617527732043",
+			"39312523a78662d5be7fcbcc98ebf5a897687268d6ecccc0c07b25e25ecfe584"},
+		{"4920776f756c64206c696b65207468652047656e6572616c20476175277320" +
+			"436869636b656e2c20706c656173652c",
+			"97687268d6ecccc0c07b25e25ecfe584b3fffd940c16a18c1b5549d2f838029e" +
+				"39312523a78662d5be7fcbcc98ebf5"},
+		{"4920776f756c64206c696b65207468652047656e6572616c20476175277320" +
+			"436869636b656e2c20706c656173652c20",
+			"97687268d6ecccc0c07b25e25ecfe5849dad8bbb96c4cdc03bc103e1a194bbd8" +
+				"39312523a78662d5be7fcbcc98ebf5a8"},
+		{"4920776f756c64206c696b65207468652047656e6572616c20476175277320" +
+			"436869636b656e2c20706c656173652c20616e6420776f6e746f6e20736f75702e",
+			"97687268d6ecccc0c07b25e25ecfe58439312523a78662d5be7fcbcc98ebf5a8" +
+				"4807efe836ee89a526730dbc2f7bc8409dad8bbb96c4cdc03bc103e1a194bbd8"},
+	}
+	for _, tt := range tests {
+		plain, want := unhex(t, tt.plain), unhex(t, tt.encrypted)
+		got, err := aesCTSEncrypt(key, plain)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("encrypt(%s) = %x, want %s", tt.plain, got, tt.encrypted)
+		}
+		got, err = aesCTSDecrypt(key, want)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, plain) {
+			t.Errorf("decrypt(%s) = %x, want %s", tt.encrypted, got, tt.plain)
+		}
+	}
+}
+
+func TestKerberosKeyEncrypt(t *testing.T) {
+	for _, k := range []kerberosKey{
+		{etype: etypeAES128, value: unhex(t, "42263c6e89f4fc28b8df68ee09799f15")},
+		{etype: etypeAES256, value: unhex(t,
+			"fe697b52bc0d3ce14432ba036a92e65bbb52280990a2fa27883998d72af30161")},
+	} {
+		for _, n := range []int{0, 1, 16, 17, 100} {
+			plain := bytes.Repeat([]byte{'x'}, n)
+			encrypted, err := k.encrypt(usageAPReqAuth, plain)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := k.decrypt(usageAPReqAuth, encrypted)
+			if err != nil {
+				t.Fatalf("enctype %d, %d bytes: %s", k.etype, n, err)
+			}
+			if !bytes.Equal(got, plain) {
+				t.Errorf("enctype %d: decrypted %x, want %x", k.etype, got, plain)
+			}
+			if _, err := k.decrypt(usageTGSRepEncPart, encrypted); err == nil {
+				t.Errorf("enctype %d: decrypted with the key of another usage",
+					k.etype)
+			}
+		}
+	}
+}
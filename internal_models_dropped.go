@@ -0,0 +1,34 @@
+package main
+
+// RegisterDropped registers a counter of the metrics dropped by a plugin at
+// the given stage of the pipeline, ie, "filter", "process", "buffer" or
+// "write". The reason tells different causes within the same stage apart.
+//
+// All counters are reported as the metrics_dropped field of the
+// internal_dropped measurement by the internal input.
+func RegisterDropped(stage, plugin, reason string) Stat {
+	return Register(
+		"dropped",
+		"metrics_dropped",
+		map[string]string{
+			"stage":  stage,
+			"plugin": plugin,
+			"reason": reason,
+		},
+	)
+}
+
+// RegisterOutputDropped registers the counter of RegisterDropped for the
+// output of the name, tagged with its alias, if any, so that the outputs of
+// the same plugin are counted apart.
+func RegisterOutputDropped(stage, name, alias, reason string) Stat {
+	tags := map[string]string{
+		"stage":  stage,
+		"plugin": "outputs." + name,
+		"reason": reason,
+	}
+	if alias != "" {
+		tags["alias"] = alias
+	}
+	return Register("dropped", "metrics_dropped", tags)
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// Protocol buffers wire types.
+const (
+	protoVarint  = 0
+	protoFixed64 = 1
+	protoBytes   = 2
+)
+
+// ProtoBuffer encodes protocol buffers messages field by field, enough to
+// speak protobuf based protocols such as OTLP without generated code.
+// ReadProto does the reverse.
+// Fields holding their zero value are still written, which decoders accept.
+type ProtoBuffer struct {
+	buf []byte
+}
+
+// Bytes returns the encoded message.
+func (p *ProtoBuffer) Bytes() []byte {
+	return p.buf
+}
+
+func (p *ProtoBuffer) key(field int, wireType int) {
+	p.varint(uint64(field)<<3 | uint64(wireType))
+}
+
+func (p *ProtoBuffer) varint(v uint64) {
+	for v >= 0x80 {
+		p.buf = append(p.buf, byte(v)|0x80)
+		v >>= 7
+	}
+	p.buf = append(p.buf, byte(v))
+}
+
+// Varint writes an int32, int64, uint32, uint64, bool or enum field.
+func (p *ProtoBuffer) Varint(field int, v uint64) {
+	p.key(field, protoVarint)
+	p.varint(v)
+}
+
+// Bool writes a bool field.
+func (p *ProtoBuffer) Bool(field int, v bool) {
+	var i uint64
+	if v {
+		i = 1
+	}
+	p.Varint(field, i)
+}
+
+// Fixed64 writes a fixed64 or sfixed64 field.
+func (p *ProtoBuffer) Fixed64(field int, v uint64) {
+	p.key(field, protoFixed64)
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], v)
+	p.buf = append(p.buf, b[:]...)
+}
+
+// Double writes a double field.
+func (p *ProtoBuffer) Double(field int, v float64) {
+	p.Fixed64(field, math.Float64bits(v))
+}
+
+// String writes a string field.
+func (p *ProtoBuffer) String(field int, s string) {
+	p.key(field, protoBytes)
+	p.varint(uint64(len(s)))
+	p.buf = append(p.buf, s...)
+}
+
+// Message writes an embedded message field, encoded by fn.
+func (p *ProtoBuffer) Message(field int, fn func(m *ProtoBuffer)) {
+	var m ProtoBuffer
+	fn(&m)
+	p.key(field, protoBytes)
+	p.varint(uint64(len(m.buf)))
+	p.buf = append(p.buf, m.buf...)
+}
+
+const protoFixed32 = 5
+
+// ProtoField is a single field read from an encoded message.
+type ProtoField struct {
+	Number   int
+	WireType int
+	// the value of varint, fixed64 and fixed32 fields
+	Uint64 uint64
+	// the value of length delimited fields
+	Data []byte
+}
+
+// Double returns the value of a double field.
+func (f ProtoField) Double() float64 {
+	return math.Float64frombits(f.Uint64)
+}
+
+// String returns the value of a string field.
+func (f ProtoField) String() string {
+	return string(f.Data)
+}
+
+// Fixed64s returns the values of a repeated fixed64 or double field, which
+// may be packed.
+func (f ProtoField) Fixed64s() []uint64 {
+	if f.WireType == protoFixed64 {
+		return []uint64{f.Uint64}
+	}
+	values := make([]uint64, 0, len(f.Data)/8)
+	for i := 0; i+8 <= len(f.Data); i += 8 {
+		values = append(values, binary.LittleEndian.Uint64(f.Data[i:]))
+	}
+	return values
+}
+
+// ReadProto calls fn for every field of the encoded message b, in order.
+func ReadProto(b []byte, fn func(f ProtoField) error) error {
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return fmt.Errorf("invalid protobuf field key")
+		}
+		b = b[n:]
+
+		f := ProtoField{Number: int(key >> 3), WireType: int(key & 7)}
+		switch f.WireType {
+		case protoVarint:
+			if f.Uint64, n = binary.Uvarint(b); n <= 0 {
+				return fmt.Errorf("invalid protobuf varint in field %d",
+					f.Number)
+			}
+			b = b[n:]
+		case protoFixed64:
+			if len(b) < 8 {
+				return fmt.Errorf("truncated protobuf field %d", f.Number)
+			}
+			f.Uint64 = binary.LittleEndian.Uint64(b)
+			b = b[8:]
+		case protoFixed32:
+			if len(b) < 4 {
+				return fmt.Errorf("truncated protobuf field %d", f.Number)
+			}
+			f.Uint64 = uint64(binary.LittleEndian.Uint32(b))
+			b = b[4:]
+		case protoBytes:
+			size, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < size {
+				return fmt.Errorf("truncated protobuf field %d", f.Number)
+			}
+			f.Data = b[n : n+int(size)]
+			b = b[n+int(size):]
+		default:
+			return fmt.Errorf("unsupported protobuf wire type %d in field %d",
+				f.WireType, f.Number)
+		}
+
+		if err := fn(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Default time a resolved hostname is kept before it is looked up again.
+	DEFAULT_DNS_CACHE_TTL = 60 * time.Second
+
+	// Time a lookup of the system resolver may take.
+	dnsLookupTimeout = 5 * time.Second
+)
+
+// DefaultResolver is the resolver shared by all output plugins.
+var DefaultResolver = NewResolver(DEFAULT_DNS_CACHE_TTL)
+
+// Resolver caches hostname lookups and re-resolves them once they expire,
+// so that long-lived output connections follow DNS based failover instead of
+// writing to the same address until telegraf is restarted.
+//
+// The addresses are those of the system resolver, the hosts file and the
+// search domains included. The Go resolver does not expose the TTLs of the
+// records, so the A and AAAA records of the qualified hosts are queried
+// from the nameservers too: if they have the same addresses, the entry
+// expires with the smallest TTL of the records, at most the configured
+// ttl, else it is kept for the ttl. A ttl of 0 disables caching and
+// resolves on every call.
+//
+// Only the first lookup of a host waits for the system resolver, within
+// dnsLookupTimeout. The TTL queries and the lookups of the expired entries
+// run in the background, the cached addresses being returned meanwhile, so
+// that a slow nameserver does not stall the writes.
+type Resolver struct {
+	ttl   time.Duration
+	cache map[string]*resolverEntry
+
+	lookupHost func(host string) ([]string, error)
+	queryTTL   func(host string) ([]string, time.Duration, error)
+
+	mu sync.Mutex
+}
+
+type resolverEntry struct {
+	addrs   []string
+	expires time.Time
+	// whether a lookup of the host runs in the background
+	refreshing bool
+}
+
+// NewResolver returns a Resolver caching lookups for ttl at most.
+func NewResolver(ttl time.Duration) *Resolver {
+	return &Resolver{
+		ttl:        ttl,
+		cache:      make(map[string]*resolverEntry),
+		lookupHost: systemLookupHost,
+		queryTTL:   queryTTL,
+	}
+}
+
+// systemLookupHost looks the host up with the system resolver, giving up
+// after dnsLookupTimeout.
+func systemLookupHost(host string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dnsLookupTimeout)
+	defer cancel()
+	return net.DefaultResolver.LookupHost(ctx, host)
+}
+
+// SetTTL changes the time resolved addresses are cached for at most. Entries
+// already cached keep their current expiry.
+func (r *Resolver) SetTTL(ttl time.Duration) {
+	r.mu.Lock()
+	r.ttl = ttl
+	r.mu.Unlock()
+}
+
+// LookupHost returns the addresses of host, sorted so that results can be
+// compared between calls. The addresses of an expired entry are returned
+// while it is re-resolved in the background, and kept if re-resolving it
+// fails, so that a DNS outage does not also take down the outputs.
+func (r *Resolver) LookupHost(host string) ([]string, error) {
+	if net.ParseIP(host) != nil {
+		return []string{host}, nil
+	}
+
+	r.mu.Lock()
+	entry, ok := r.cache[host]
+	ttl := r.ttl
+	if ok && ttl > 0 {
+		if !entry.refreshing && !time.Now().Before(entry.expires) {
+			entry.refreshing = true
+			go r.refresh(host)
+		}
+		r.mu.Unlock()
+		return entry.addrs, nil
+	}
+	r.mu.Unlock()
+
+	addrs, err := r.lookupHost(host)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(addrs)
+	if ttl <= 0 {
+		return addrs, nil
+	}
+
+	r.mu.Lock()
+	r.cache[host] = &resolverEntry{
+		addrs:      addrs,
+		expires:    time.Now().Add(ttl),
+		refreshing: true,
+	}
+	r.mu.Unlock()
+	go r.expire(host, addrs, ttl)
+	return addrs, nil
+}
+
+// refresh looks the host of the expired entry up again, keeping its
+// addresses if it fails.
+func (r *Resolver) refresh(host string) {
+	addrs, err := r.lookupHost(host)
+
+	r.mu.Lock()
+	entry := r.cache[host]
+	ttl := r.ttl
+	if err != nil {
+		log.Printf("W! Could not re-resolve %s, using cached addresses %v: %s",
+			host, entry.addrs, err)
+		entry.refreshing = false
+		r.mu.Unlock()
+		return
+	}
+	sort.Strings(addrs)
+	if !sameAddrs(entry.addrs, addrs) {
+		log.Printf("I! Addresses of %s changed from %v to %v",
+			host, entry.addrs, addrs)
+	}
+	r.cache[host] = &resolverEntry{
+		addrs:      addrs,
+		expires:    time.Now().Add(ttl),
+		refreshing: true,
+	}
+	r.mu.Unlock()
+	r.expire(host, addrs, ttl)
+}
+
+// expire sets the expiry of the entry just resolved to the TTL of the DNS
+// records of the host, when shorter than the ttl, and ends its refresh.
+func (r *Resolver) expire(host string, addrs []string, ttl time.Duration) {
+	expires := time.Now().Add(ttl)
+	// the hosts without a dot may be resolved in the search domains
+	if ttl > 0 && strings.Contains(host, ".") {
+		records, recordTTL, err := r.queryTTL(host)
+		switch {
+		case err != nil:
+			log.Printf("D! Could not query the TTL of %s, caching it for %s: %s",
+				host, ttl, err)
+		case !sameAddrs(records, addrs):
+			log.Printf("D! The DNS records of %s are not its addresses %v, "+
+				"caching it for %s", host, addrs, ttl)
+		case recordTTL < ttl:
+			expires = time.Now().Add(recordTTL)
+		}
+	}
+
+	r.mu.Lock()
+	if entry, ok := r.cache[host]; ok {
+		if expires.Before(entry.expires) {
+			entry.expires = expires
+		}
+		entry.refreshing = false
+	}
+	r.mu.Unlock()
+}
+
+// DialContext resolves the host part of address through the cache and
+// connects to the first address that accepts the connection. It can be used
+// as the DialContext of an http.Transport.
+func (r *Resolver) DialContext(
+	ctx context.Context,
+	network string,
+	address string,
+) (net.Conn, error) {
+	return r.dial(ctx, network, address, nil)
+}
+
+// dial connects to the address from the local address of the binding, if
+// not nil, of the family of each address tried.
+func (r *Resolver) dial(
+	ctx context.Context,
+	network string,
+	address string,
+	bind *LocalBinding,
+) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := r.LookupHost(host)
+	if err != nil {
+		return nil, err
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("no addresses found for %s", host)
+	}
+
+	var d net.Dialer
+	for _, addr := range addrs {
+		if bind != nil {
+			ip := net.ParseIP(addr)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address %s of %s", addr, host)
+			}
+			if d.LocalAddr, err = bind.localAddr(network, ip); err != nil {
+				continue
+			}
+		}
+		var conn net.Conn
+		conn, err = d.DialContext(ctx, network, net.JoinHostPort(addr, port))
+		if err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
+func sameAddrs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
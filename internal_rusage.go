@@ -0,0 +1,95 @@
+package main
+
+import (
+	"runtime/metrics"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// ResourceUsage is a snapshot of the CPU time used and the bytes and objects
+// allocated by the telegraf process. The difference of two snapshots taken
+// around a gather estimates what the gather cost; as the counters are process
+// wide, inputs gathering at the same time are charged for each other's usage.
+type ResourceUsage struct {
+	CPUTime    time.Duration
+	AllocBytes uint64
+	Allocs     uint64
+}
+
+// allocSamples are the runtime metrics of the allocations, read without
+// stopping the world unlike runtime.ReadMemStats.
+var allocSamples = []string{
+	"/gc/heap/allocs:bytes",
+	"/gc/heap/allocs:objects",
+}
+
+// GetResourceUsage returns the current resource usage of the process.
+func GetResourceUsage() ResourceUsage {
+	samples := make([]metrics.Sample, len(allocSamples))
+	for i, name := range allocSamples {
+		samples[i].Name = name
+	}
+	metrics.Read(samples)
+
+	usage := ResourceUsage{CPUTime: GetCPUTime()}
+	if samples[0].Value.Kind() == metrics.KindUint64 {
+		usage.AllocBytes = samples[0].Value.Uint64()
+	}
+	if samples[1].Value.Kind() == metrics.KindUint64 {
+		usage.Allocs = samples[1].Value.Uint64()
+	}
+	return usage
+}
+
+// Since returns the resources used between the earlier snapshot and now.
+func (u ResourceUsage) Since() ResourceUsage {
+	now := GetResourceUsage()
+	return ResourceUsage{
+		CPUTime:    now.CPUTime - u.CPUTime,
+		AllocBytes: now.AllocBytes - u.AllocBytes,
+		Allocs:     now.Allocs - u.Allocs,
+	}
+}
+
+// GetCPUTime returns the CPU time used by the process and by its children
+// waited for, so that the inputs running commands are charged for them once
+// the commands exit. Like the rest of the usage it is process wide: the
+// difference around a gather is only approximate while other inputs gather
+// or commands of other inputs exit.
+func GetCPUTime() time.Duration {
+	var cpu time.Duration
+	for _, who := range []int{syscall.RUSAGE_SELF, syscall.RUSAGE_CHILDREN} {
+		var ru syscall.Rusage
+		if err := syscall.Getrusage(who, &ru); err == nil {
+			cpu += time.Duration(ru.Utime.Nano() + ru.Stime.Nano())
+		}
+	}
+	return cpu
+}
+
+// gathersRunning and gathersStarted count the gathers in progress and all
+// the gathers started, to tell how many gathers overlapped a given one.
+var gathersRunning, gathersStarted int64
+
+// gatherOverlap tracks the gathers overlapping a gather in progress.
+type gatherOverlap struct {
+	running int64
+	started int64
+}
+
+// beginGather records the start of a gather, to be ended with end.
+func beginGather() gatherOverlap {
+	return gatherOverlap{
+		running: atomic.AddInt64(&gathersRunning, 1) - 1,
+		started: atomic.AddInt64(&gathersStarted, 1),
+	}
+}
+
+// end records the end of the gather and returns the number of other gathers
+// that ran during it: those already running when it started plus those
+// started meanwhile. The usage of the gather is shared with them.
+func (o gatherOverlap) end() int64 {
+	atomic.AddInt64(&gathersRunning, -1)
+	return o.running + atomic.LoadInt64(&gathersStarted) - o.started
+}
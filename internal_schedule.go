@@ -0,0 +1,237 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a cron-like expression of the minutes an input may be gathered
+// in, made of the five usual fields: minute, hour, day of month, month and
+// day of week. Each field is "*", a value, a range "a-b" or a comma separated
+// list of those, any of which may carry a "/step", a value with a step
+// starting a range up to the maximum.
+type Schedule struct {
+	minute, hour, dom, month, dow uint64
+
+	// if both day fields are restricted, a day matches when either of them
+	// matches, as in cron. A field starting with "*", ie, "*/2", is not
+	// restricted.
+	domStar, dowStar bool
+}
+
+// scheduleTick is the longest interval a scheduled input is looked at, half
+// a minute so that no minute is skipped by the collection_jitter of the
+// agent, short of a jitter of 30s or more.
+const scheduleTick = 30 * time.Second
+
+var scheduleFields = []struct {
+	name     string
+	min, max int
+}{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+// ParseSchedule parses a five field cron expression.
+func ParseSchedule(expr string) (*Schedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != len(scheduleFields) {
+		return nil, fmt.Errorf("schedule %q must have %d fields, found %d",
+			expr, len(scheduleFields), len(fields))
+	}
+
+	var bits [5]uint64
+	for i, field := range fields {
+		var err error
+		f := scheduleFields[i]
+		bits[i], err = parseScheduleField(field, f.min, f.max)
+		if err != nil {
+			return nil, fmt.Errorf("schedule %q, %s: %s", expr, f.name, err)
+		}
+	}
+
+	// both 0 and 7 are sunday
+	if bits[4]&(1<<7) != 0 {
+		bits[4] |= 1
+	}
+
+	return &Schedule{
+		minute:  bits[0],
+		hour:    bits[1],
+		dom:     bits[2],
+		month:   bits[3],
+		dow:     bits[4],
+		domStar: strings.HasPrefix(fields[2], "*"),
+		dowStar: strings.HasPrefix(fields[4], "*"),
+	}, nil
+}
+
+func parseScheduleField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		step, stepped := 1, false
+		if i := strings.Index(part, "/"); i != -1 {
+			stepped = true
+			var err error
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+			part = part[:i]
+		}
+
+		low, high := min, max
+		switch {
+		case part == "*":
+		case strings.Contains(part, "-"):
+			bounds := strings.SplitN(part, "-", 2)
+			var err1, err2 error
+			low, err1 = strconv.Atoi(bounds[0])
+			high, err2 = strconv.Atoi(bounds[1])
+			if err1 != nil || err2 != nil {
+				return 0, fmt.Errorf("invalid range %q", part)
+			}
+		default:
+			v, err := strconv.Atoi(part)
+			if err != nil {
+				return 0, fmt.Errorf("invalid value %q", part)
+			}
+			low, high = v, v
+			if stepped {
+				high = max
+			}
+		}
+
+		if low < min || high > max || low > high {
+			return 0, fmt.Errorf("%q out of range %d-%d", part, min, max)
+		}
+		for v := low; v <= high; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// Matches returns true if t falls in a minute selected by the schedule.
+func (s *Schedule) Matches(t time.Time) bool {
+	if s.minute&(1<<uint(t.Minute())) == 0 ||
+		s.hour&(1<<uint(t.Hour())) == 0 ||
+		s.month&(1<<uint(t.Month())) == 0 {
+		return false
+	}
+
+	domMatch := s.dom&(1<<uint(t.Day())) != 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+// BlackoutWindow is a daily time range during which an input is not
+// gathered, written as "HH:MM-HH:MM" and optionally prefixed by the days it
+// applies to, ie, "Mon-Fri 01:00-04:30" or "Sat,Sun 22:00-02:00". Windows
+// ending before they start run past midnight.
+type BlackoutWindow struct {
+	days       uint8
+	start, end int // minutes since midnight
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// ParseBlackoutWindow parses a blackout window expression.
+func ParseBlackoutWindow(expr string) (*BlackoutWindow, error) {
+	w := &BlackoutWindow{days: 0x7f}
+
+	fields := strings.Fields(expr)
+	switch len(fields) {
+	case 1:
+	case 2:
+		days, err := parseWeekdays(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("blackout window %q: %s", expr, err)
+		}
+		w.days = days
+	default:
+		return nil, fmt.Errorf("invalid blackout window %q", expr)
+	}
+
+	times := strings.SplitN(fields[len(fields)-1], "-", 2)
+	if len(times) != 2 {
+		return nil, fmt.Errorf("blackout window %q: expected HH:MM-HH:MM", expr)
+	}
+	var err error
+	if w.start, err = parseClock(times[0]); err != nil {
+		return nil, fmt.Errorf("blackout window %q: %s", expr, err)
+	}
+	if w.end, err = parseClock(times[1]); err != nil {
+		return nil, fmt.Errorf("blackout window %q: %s", expr, err)
+	}
+	return w, nil
+}
+
+func parseWeekdays(spec string) (uint8, error) {
+	var days uint8
+	for _, part := range strings.Split(strings.ToLower(spec), ",") {
+		bounds := strings.SplitN(part, "-", 2)
+		first, ok := weekdays[bounds[0]]
+		if !ok {
+			return 0, fmt.Errorf("unknown day %q", bounds[0])
+		}
+		last := first
+		if len(bounds) == 2 {
+			if last, ok = weekdays[bounds[1]]; !ok {
+				return 0, fmt.Errorf("unknown day %q", bounds[1])
+			}
+		}
+		for d := first; ; d = (d + 1) % 7 {
+			days |= 1 << uint(d)
+			if d == last {
+				break
+			}
+		}
+	}
+	return days, nil
+}
+
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Contains returns true if t falls within the blackout window.
+func (w *BlackoutWindow) Contains(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	day := t.Weekday()
+
+	if w.start <= w.end {
+		return w.days&(1<<uint(day)) != 0 &&
+			minute >= w.start && minute < w.end
+	}
+
+	// the window runs past midnight, the part after midnight belongs to the
+	// window started the day before.
+	if minute >= w.start {
+		return w.days&(1<<uint(day)) != 0
+	}
+	if minute < w.end {
+		return w.days&(1<<uint((day+6)%7)) != 0
+	}
+	return false
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// socks5Replies are the errors of the SOCKS5 replies, by reply code.
+var socks5Replies = map[byte]string{
+	1: "general SOCKS server failure",
+	2: "connection not allowed by ruleset",
+	3: "network unreachable",
+	4: "host unreachable",
+	5: "connection refused",
+	6: "TTL expired",
+	7: "command not supported",
+	8: "address type not supported",
+}
+
+// DialSocks5 connects to the TCP address through the SOCKS5 proxy, RFC
+// 1928, authenticating with the username and password, RFC 1929, if the
+// username is not empty. The proxy resolves the host names, which the
+// hosts behind it may not be able to. The connection to the proxy is bound
+// to the local address of the binding if not nil.
+func DialSocks5(
+	binding *LocalBinding,
+	proxy, username, password, address string,
+	timeout time.Duration,
+) (net.Conn, error) {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port of %s", address)
+	}
+
+	dialer, err := binding.Dialer("tcp", proxy, timeout)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := dialer.Dial("tcp", proxy)
+	if err != nil {
+		return nil, err
+	}
+	if timeout > 0 {
+		conn.SetDeadline(time.Now().Add(timeout))
+	}
+	if err := socks5Connect(conn, username, password, host, uint16(port)); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("socks5 proxy %s: %s", proxy, err)
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+func socks5Connect(conn net.Conn, username, password, host string, port uint16) error {
+	methods := []byte{0}
+	if username != "" {
+		methods = append(methods, 2)
+	}
+	greeting := append([]byte{5, byte(len(methods))}, methods...)
+	if _, err := conn.Write(greeting); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[0] != 5 {
+		return fmt.Errorf("not a SOCKS5 proxy")
+	}
+	switch reply[1] {
+	case 0:
+	case 2:
+		if username == "" {
+			return fmt.Errorf("the proxy requires a username and password")
+		}
+		if len(username) > 255 || len(password) > 255 {
+			return fmt.Errorf("username or password longer than 255 bytes")
+		}
+		auth := []byte{1, byte(len(username))}
+		auth = append(auth, username...)
+		auth = append(auth, byte(len(password)))
+		auth = append(auth, password...)
+		if _, err := conn.Write(auth); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			return err
+		}
+		if reply[1] != 0 {
+			return fmt.Errorf("authentication failed")
+		}
+	default:
+		return fmt.Errorf("no acceptable authentication method")
+	}
+
+	req := []byte{5, 1, 0}
+	if ip := net.ParseIP(host); ip == nil {
+		if len(host) > 255 {
+			return fmt.Errorf("host name %s too long", host)
+		}
+		req = append(req, 3, byte(len(host)))
+		req = append(req, host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		req = append(req, 1)
+		req = append(req, ip4...)
+	} else {
+		req = append(req, 4)
+		req = append(req, ip.To16()...)
+	}
+	req = append(req, byte(port>>8), byte(port))
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return err
+	}
+	if header[1] != 0 {
+		msg, ok := socks5Replies[header[1]]
+		if !ok {
+			msg = fmt.Sprintf("reply %d", header[1])
+		}
+		return fmt.Errorf("could not connect to %s: %s", net.JoinHostPort(host,
+			strconv.Itoa(int(port))), msg)
+	}
+	// the address the proxy bound, unused
+	var n int
+	switch header[3] {
+	case 1:
+		n = net.IPv4len
+	case 4:
+		n = net.IPv6len
+	case 3:
+		if _, err := io.ReadFull(conn, header[:1]); err != nil {
+			return err
+		}
+		n = int(header[0])
+	default:
+		return fmt.Errorf("invalid address type %d in the reply", header[3])
+	}
+	_, err := io.ReadFull(conn, make([]byte, n+2))
+	return err
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// tokenMaxBackoff bounds the delay between the attempts to renew a token
+// failing.
+const tokenMaxBackoff = 5 * time.Minute
+
+// TokenSource renews the short-lived bearer token an output authenticates
+// with: from an OAuth2 token endpoint with the client credentials grant, or
+// from the output of a command, such as the CLI of a secret store.
+type TokenSource struct {
+	// Source is "oauth2" or "exec".
+	Source string
+
+	TokenURL     string `toml:"token_url"`
+	ClientID     string `toml:"client_id"`
+	ClientSecret string `toml:"client_secret"`
+	Scopes       []string
+	// Audience is sent as the audience parameter, which some providers
+	// require.
+	Audience string
+
+	// Command prints the token, alone or as the JSON of an OAuth2 token
+	// response with access_token and expires_in.
+	Command []string
+
+	// Lifetime is the one of the tokens the source gives no expiry of.
+	Lifetime Duration
+	// RefreshBefore is how long before it expires a token is renewed.
+	RefreshBefore Duration `toml:"refresh_before"`
+	// RetryBackoff is the delay before renewing the token again after a
+	// failure, doubled at each failure up to 5 minutes.
+	RetryBackoff Duration `toml:"retry_backoff"`
+	Timeout      Duration
+
+	// Path to CA file
+	SSLCA string `toml:"ssl_ca"`
+	// Path to host cert file
+	SSLCert string `toml:"ssl_cert"`
+	// Path to cert key file
+	SSLKey string `toml:"ssl_key"`
+	// Use SSL but skip chain & host verification
+	InsecureSkipVerify bool
+
+	client *http.Client
+}
+
+func newTokenSource() *TokenSource {
+	return &TokenSource{
+		Lifetime:      Duration{Duration: 10 * time.Minute},
+		RefreshBefore: Duration{Duration: time.Minute},
+		RetryBackoff:  Duration{Duration: 5 * time.Second},
+		Timeout:       Duration{Duration: 10 * time.Second},
+	}
+}
+
+// init validates the token config.
+func (s *TokenSource) init() error {
+	switch s.Source {
+	case "oauth2":
+		if s.TokenURL == "" || s.ClientID == "" {
+			return fmt.Errorf("token_url and client_id are required by the " +
+				"oauth2 token source")
+		}
+		tlsConfig, err := GetTLSConfig(s.SSLCert, s.SSLKey, s.SSLCA,
+			s.InsecureSkipVerify)
+		if err != nil {
+			return err
+		}
+		s.client = &http.Client{
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: tlsConfig,
+			},
+			Timeout: s.Timeout.Duration,
+		}
+	case "exec":
+		if len(s.Command) == 0 {
+			return fmt.Errorf("command is required by the exec token source")
+		}
+	default:
+		return fmt.Errorf("invalid token source %q, expected oauth2 or exec",
+			s.Source)
+	}
+	if s.Lifetime.Duration <= s.RefreshBefore.Duration {
+		return fmt.Errorf("token lifetime %s must be longer than refresh_before %s",
+			s.Lifetime.Duration, s.RefreshBefore.Duration)
+	}
+	if s.RetryBackoff.Duration <= 0 {
+		return fmt.Errorf("invalid token retry_backoff %s", s.RetryBackoff.Duration)
+	}
+	return nil
+}
+
+// tokenResponse is the response of an OAuth2 token endpoint.
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	ExpiresIn        int64  `json:"expires_in"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// fetch returns a new token, and when it expires.
+func (s *TokenSource) fetch() (string, time.Time, error) {
+	var b []byte
+	var err error
+	start := time.Now()
+	switch s.Source {
+	case "oauth2":
+		b, err = s.request()
+	case "exec":
+		b, err = s.run()
+	}
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	resp := tokenResponse{}
+	b = bytes.TrimSpace(b)
+	if len(b) != 0 && b[0] == '{' {
+		if err := json.Unmarshal(b, &resp); err != nil {
+			return "", time.Time{}, fmt.Errorf("invalid token response: %s", err)
+		}
+	} else if s.Source == "exec" {
+		resp.AccessToken = string(b)
+	}
+	if resp.Error != "" {
+		return "", time.Time{}, fmt.Errorf("%s: %s", resp.Error,
+			resp.ErrorDescription)
+	}
+	if resp.AccessToken == "" {
+		return "", time.Time{}, fmt.Errorf("no token in the response")
+	}
+	lifetime := s.Lifetime.Duration
+	if resp.ExpiresIn > 0 {
+		lifetime = time.Duration(resp.ExpiresIn) * time.Second
+	}
+	return resp.AccessToken, start.Add(lifetime), nil
+}
+
+// request requests a token with the client credentials grant.
+func (s *TokenSource) request() ([]byte, error) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(s.Scopes) != 0 {
+		form.Set("scope", strings.Join(s.Scopes, " "))
+	}
+	if s.Audience != "" {
+		form.Set("audience", s.Audience)
+	}
+	req, err := http.NewRequest("POST", s.TokenURL,
+		strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(s.ClientID), url.QueryEscape(s.ClientSecret))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		var body tokenResponse
+		if json.Unmarshal(b, &body) == nil && body.Error != "" {
+			return nil, fmt.Errorf("token endpoint returned %s: %s %s",
+				resp.Status, body.Error, body.ErrorDescription)
+		}
+		return nil, fmt.Errorf("token endpoint returned %s", resp.Status)
+	}
+	return b, nil
+}
+
+// run runs the command, and returns its output.
+func (s *TokenSource) run() ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(s.Command[0], s.Command[1:]...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := RunTimeout(cmd, s.Timeout.Duration); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%s: %s", err, msg)
+		}
+		return nil, err
+	}
+	return stdout.Bytes(), nil
+}
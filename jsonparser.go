@@ -10,8 +10,12 @@ import (
 )
 
 type JSONParser struct {
-	MetricName  string
-	TagKeys     []string
+	MetricName string
+	TagKeys    []string
+	// Query is the dot-separated path of the object or array of objects to
+	// parse in the document, of the keys of the objects and the indexes of
+	// the arrays, ie "data.servers" or "results.0.series"
+	Query       string
 	DefaultTags map[string]string
 }
 
@@ -68,6 +72,9 @@ func (p *JSONParser) Parse(buf []byte) ([]Metric, error) {
 	if len(buf) == 0 {
 		return make([]Metric, 0), nil
 	}
+	if p.Query != "" {
+		return p.parseQuery(buf)
+	}
 
 	if !isarray(buf) {
 		metrics := make([]Metric, 0)
@@ -82,6 +89,52 @@ func (p *JSONParser) Parse(buf []byte) ([]Metric, error) {
 	return p.parseArray(buf)
 }
 
+// parseQuery parses the object, or the objects of the array, at the path of
+// the query in the document.
+func (p *JSONParser) parseQuery(buf []byte) ([]Metric, error) {
+	var doc interface{}
+	if err := json.Unmarshal(buf, &doc); err != nil {
+		return nil, fmt.Errorf("unable to parse out as JSON, %s", err)
+	}
+	for _, key := range strings.Split(p.Query, ".") {
+		switch v := doc.(type) {
+		case map[string]interface{}:
+			doc = v[key]
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, fmt.Errorf("json_query %s: no index %s", p.Query, key)
+			}
+			doc = v[i]
+		default:
+			doc = nil
+		}
+		if doc == nil {
+			return nil, fmt.Errorf("json_query %s: no %s", p.Query, key)
+		}
+	}
+
+	metrics := make([]Metric, 0)
+	switch v := doc.(type) {
+	case map[string]interface{}:
+		return p.parseObject(metrics, v)
+	case []interface{}:
+		for _, item := range v {
+			obj, ok := item.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("json_query %s: not an array of objects",
+					p.Query)
+			}
+			var err error
+			if metrics, err = p.parseObject(metrics, obj); err != nil {
+				return nil, err
+			}
+		}
+		return metrics, nil
+	}
+	return nil, fmt.Errorf("json_query %s: not an object or an array", p.Query)
+}
+
 func (p *JSONParser) ParseLine(line string) (Metric, error) {
 	metrics, err := p.Parse([]byte(line + "\n"))
 
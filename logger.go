@@ -35,8 +35,9 @@ func (t *telegrafLog) Write(b []byte) (n int, err error) {
 //   debug   will set the log level to DEBUG
 //   quiet   will set the log level to ERROR
 //   logfile will direct the logging output to a file. Empty string is
-//           interpreted as stderr. If there is an error opening the file the
-//           logger will fallback to stderr.
+//           interpreted as stderr. A relative path is resolved in the state
+//           directory, which is also tried if the file cannot be opened,
+//           before the logger falls back to stderr.
 func SetupLogging(debug, quiet bool, logfile string) {
 	log.SetFlags(0)
 	if debug {
@@ -48,16 +49,11 @@ func SetupLogging(debug, quiet bool, logfile string) {
 
 	var oFile *os.File
 	if logfile != "" {
-		if _, err := os.Stat(logfile); os.IsNotExist(err) {
-			if oFile, err = os.Create(logfile); err != nil {
-				log.Printf("E! Unable to create %s (%s), using stderr", logfile, err)
-				oFile = os.Stderr
-			}
-		} else {
-			if oFile, err = os.OpenFile(logfile, os.O_APPEND|os.O_WRONLY, os.ModeAppend); err != nil {
-				log.Printf("E! Unable to append to %s (%s), using stderr", logfile, err)
-				oFile = os.Stderr
-			}
+		var err error
+		oFile, _, err = openStateFile(logfile, os.O_CREATE|os.O_APPEND|os.O_WRONLY)
+		if err != nil {
+			log.Printf("E! Unable to open %s (%s), using stderr", logfile, err)
+			oFile = os.Stderr
 		}
 	} else {
 		oFile = os.Stderr
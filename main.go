@@ -3,11 +3,14 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io/ioutil"
+	"net/http"
 	_ "net/http/pprof" // Comment this line to disable pprof endpoint.
 	"os"
 	"log"
 	"syscall"
 	"os/signal"
+	"path/filepath"
 	"strings"
 )
 
@@ -17,16 +20,37 @@ var fQuiet = flag.Bool("quiet", false,
 	"run in quiet mode")
 var fTest = flag.Bool("test", false, "gather metrics, print them out, and exit")
 var fConfig = flag.String("config", "", "configuration file to load")
+var fConfigDirectory = flag.String("config-directory", "",
+	"directory containing additional *.conf files")
 var fVersion = flag.Bool("version", false, "display the version")
 var fSampleConfig = flag.Bool("sample-config", false,
 	"print out full sample configuration")
-var fPidfile = flag.String("pidfile", "", "file to write our pid to")
+var fPidfile = flag.String("pidfile", "",
+	"file to write our pid to, overriding the pidfile of the agent config")
+var fProfile = flag.String("profile", "",
+	"profile of the config to apply, overriding the profile of the agent config")
+var fReplayRate = flag.Int("replay-rate", 0,
+	"maximum number of metrics replayed per second, unlimited if 0")
+var fTapStages = flag.String("tap-stages", "",
+	"stages the tap command mirrors the metrics at, separator is :")
+var fTapFields = flag.String("tap-fields", "",
+	"globs of the fields the tap command shows, separator is :")
+var fTapFile = flag.String("tap-file", "",
+	"file the tap command appends the metrics to, stdout of the agent if empty")
+var fIterations = flag.Int("iterations", 10,
+	"number of gathers of each input in the bench command")
 var fInputList = flag.Bool("input-list", false,
 	"print available input plugins.")
 var fOutputList = flag.Bool("output-list", false,
 	"print available output plugins.")
+var fProcessorList = flag.Bool("processor-list", false,
+	"print available processor plugins.")
+var fAggregatorList = flag.Bool("aggregator-list", false,
+	"print available aggregator plugins.")
 var fUsage = flag.String("usage", "",
 	"print usage for a plugin, ie, 'telegraf --usage mysql'")
+var fPprofAddr = flag.String("pprof-addr", "",
+	"pprof address to listen on, not activate pprof if empty")
 
 var (
 	nextVersion = "1.5.0"
@@ -45,12 +69,40 @@ The commands & flags are:
 
   config              print out full sample configuration to stdout
   version             print the version to stdout
+  bench               gather the configured inputs --iterations times and
+                      print the throughput, gather times and allocations of
+                      each one
+  profile [name]      switch the running agent to the named profile of the
+                      config, or back to its default profile without a name
+  discover [file]     inspect the services, ports, packages and processes of
+                      the system, and print a config enabling the inputs
+                      that match them, or write it to the given drop-in file
+  replay <files>      write the metrics of the given line protocol files,
+                      gzipped if named *.gz, to the configured outputs with
+                      their original timestamps
+  tap [filter|off]    mirror the metrics of the running agent matching the
+                      measurement globs and tag=glob selectors of the filter
+                      as they pass the input, processor, aggregator and
+                      output stages, or stop mirroring them with off
+  maintenance <duration> [reason]|off
+                      tag the metrics of the running agent maintenance=true
+                      and keep the event outputs from raising new alerts for
+                      the duration, such as 2h, or end the window with off
 
   --config <file>     configuration file to load
   --test              gather metrics once, print them to stdout, and exit
   --config-directory  directory containing additional *.conf files
+  --profile           profile of the config to apply, such as deep-debug
+  --iterations        number of gathers of each input in bench, 10 by default
+  --replay-rate       maximum number of metrics replayed per second, unlimited
+                      by default
+  --tap-stages        stages the tap mirrors the metrics at, separator is :
+  --tap-fields        globs of the fields the tap shows, separator is :
+  --tap-file          file the tap appends to, stdout of the agent by default
   --input-filter      filter the input plugins to enable, separator is :
   --output-filter     filter the output plugins to enable, separator is :
+  --processor-list    print available processor plugins
+  --aggregator-list   print available aggregator plugins
   --usage             print usage for a plugin, ie, 'telegraf --usage mysql'
   --debug             print metrics as they're generated to stdout
   --pprof-addr        pprof address to listen on, format: localhost:6060 or :6060
@@ -73,6 +125,29 @@ Examples:
   # run telegraf, enabling the cpu & memory input, and influxdb output plugins
   telegraf --config telegraf.conf --input-filter cpu:mem --output-filter influxdb
 
+  # collect at high resolution during an incident, then switch back
+  telegraf --config telegraf.conf --pidfile telegraf.pid profile deep-debug
+  telegraf --config telegraf.conf --pidfile telegraf.pid profile
+
+  # enable the inputs matching what runs on a new host
+  telegraf --config telegraf.conf --config-directory /etc/telegraf/telegraf.d \
+    discover /etc/telegraf/telegraf.d/discovered.conf
+
+  # backfill the outputs after an outage, at 5000 metrics per second
+  telegraf --config telegraf.conf --replay-rate 5000 replay metrics.out.gz
+
+  # follow the cpu metrics of host web1 through the processors and outputs
+  telegraf --config telegraf.conf --pidfile telegraf.pid \
+    --tap-stages processor:output --tap-file tap.out tap 'cpu*' host=web1
+  telegraf --config telegraf.conf --pidfile telegraf.pid tap off
+
+  # keep planned work on the host from paging for two hours
+  telegraf --config telegraf.conf --pidfile telegraf.pid \
+    maintenance 2h patching the kernel
+
+  # measure what the gathers of the configured inputs cost
+  telegraf --config telegraf.conf --iterations 100 bench
+
   # run telegraf with pprof
   telegraf --config telegraf.conf --pprof-addr localhost:6060
 `
@@ -111,6 +186,10 @@ func init() {
 
 	InitAllOutputs()
 
+	InitAllProcessors()
+
+	InitAllAggregators()
+
 }
 
 func RegisterAllInit() {
@@ -134,6 +213,36 @@ func main() {
 			return
 		case "config":
 			return
+		case "bench":
+			if err := bench(); err != nil {
+				log.Fatal("E! " + err.Error())
+			}
+			return
+		case "profile":
+			if err := switchProfile(args[1:]); err != nil {
+				log.Fatal("E! " + err.Error())
+			}
+			return
+		case "discover":
+			if err := discover(args[1:]); err != nil {
+				log.Fatal("E! " + err.Error())
+			}
+			return
+		case "replay":
+			if err := replay(args[1:]); err != nil {
+				log.Fatal("E! " + err.Error())
+			}
+			return
+		case "tap":
+			if err := tap(args[1:]); err != nil {
+				log.Fatal("E! " + err.Error())
+			}
+			return
+		case "maintenance":
+			if err := maintenance(args[1:]); err != nil {
+				log.Fatal("E! " + err.Error())
+			}
+			return
 		}
 	}
 
@@ -145,6 +254,18 @@ func main() {
 			fmt.Printf("  %s\n", k)
 		}
 		return
+	case *fProcessorList:
+		fmt.Println("Available Processor Plugins:")
+		for k, _ := range Processors {
+			fmt.Printf("  %s\n", k)
+		}
+		return
+	case *fAggregatorList:
+		fmt.Println("Available Aggregator Plugins:")
+		for k, _ := range Aggregators {
+			fmt.Printf("  %s\n", k)
+		}
+		return
 	case *fInputList:
 		fmt.Println("Available Input Plugins:")
 		for k, _ := range Inputs {
@@ -159,17 +280,194 @@ func main() {
 	case *fUsage != "":
 		err := PrintInputConfig(*fUsage)
 		err2 := PrintOutputConfig(*fUsage)
-		if err != nil && err2 != nil {
-			log.Fatalf("E! %s and %s", err, err2)
+		err3 := PrintProcessorConfig(*fUsage)
+		err4 := PrintAggregatorConfig(*fUsage)
+		if err != nil && err2 != nil && err3 != nil && err4 != nil {
+			log.Fatalf("E! %s, %s, %s and %s", err, err2, err3, err4)
 		}
 		return
 	}
 
+	if *fPprofAddr != "" {
+		go func() {
+			pprofHostPort := *fPprofAddr
+			parts := strings.Split(pprofHostPort, ":")
+			if len(parts) == 2 && parts[0] == "" {
+				pprofHostPort = fmt.Sprintf("localhost:%s", parts[1])
+			}
+			pprofHostPort = "http://" + pprofHostPort + "/debug/pprof"
+
+			log.Printf("I! Starting pprof HTTP server at: %s", pprofHostPort)
+
+			if err := http.ListenAndServe(*fPprofAddr, nil); err != nil {
+				log.Fatal("E! " + err.Error())
+			}
+		}()
+	}
+
 	stop = make(chan struct{})
 	reloadLoop(stop)
 
 }
 
+// loadConfig loads the configuration, and applies the selected profile.
+func loadConfig() (*Config, error) {
+	c := NewConfig()
+	if err := c.LoadConfig(*fConfig); err != nil {
+		return nil, err
+	}
+	if *fConfigDirectory != "" {
+		if err := c.LoadDirectory(*fConfigDirectory); err != nil {
+			return nil, err
+		}
+	}
+	SetupStateDirectory(c.Agent.StateDirectory)
+	if err := c.ApplyProfile(SelectedProfile(c, *fProfile)); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// switchProfile switches the running agent to the profile of the arguments,
+// or back to its default one without arguments.
+func switchProfile(args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Usage: telegraf profile [name]")
+	}
+	c := NewConfig()
+	if err := c.LoadConfig(*fConfig); err != nil {
+		return err
+	}
+	SetupStateDirectory(c.Agent.StateDirectory)
+	name := ""
+	if len(args) == 1 {
+		name = args[0]
+	}
+	return SwitchProfile(c, name, *fPidfile)
+}
+
+// tap enables the debug tap of the running agent with the filter of the
+// arguments and the tap flags, or disables it with off.
+func tap(args []string) error {
+	c := NewConfig()
+	if err := c.LoadConfig(*fConfig); err != nil {
+		return err
+	}
+	SetupStateDirectory(c.Agent.StateDirectory)
+	if len(args) == 1 && args[0] == "off" {
+		return SetTap(c, nil, *fPidfile)
+	}
+	filter := parseTapArgs(args)
+	if *fTapStages != "" {
+		filter.Stages = strings.Split(*fTapStages, ":")
+	}
+	if *fTapFields != "" {
+		filter.Fields = strings.Split(*fTapFields, ":")
+	}
+	filter.File = *fTapFile
+	return SetTap(c, filter, *fPidfile)
+}
+
+// maintenance opens a maintenance window of the running agent for the
+// duration of the arguments, or closes it with off.
+func maintenance(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("Usage: telegraf maintenance <duration> [reason]|off")
+	}
+	c := NewConfig()
+	if err := c.LoadConfig(*fConfig); err != nil {
+		return err
+	}
+	SetupStateDirectory(c.Agent.StateDirectory)
+	if len(args) == 1 && args[0] == "off" {
+		return SetMaintenance(c, nil, *fPidfile)
+	}
+	w, err := parseMaintenanceArgs(args)
+	if err != nil {
+		return err
+	}
+	return SetMaintenance(c, w, *fPidfile)
+}
+
+// bench loads the configuration and benchmarks its inputs.
+func bench() error {
+	c, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	ag, err := NewAgent(c)
+	if err != nil {
+		return err
+	}
+	SetupLogging(*fDebug, *fQuiet, "")
+	return ag.Bench(*fIterations, os.Stdout)
+}
+
+// discover prints the config of the inputs discovered on the system, or
+// writes it to the file of the arguments, which is enabled on reload when
+// in the config directory. The inputs of the configuration, if any, are not
+// discovered again, but for the ones of that file, which is rewritten.
+func discover(args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Usage: telegraf discover [file]")
+	}
+	c := NewConfig()
+	if *fConfig != "" {
+		if err := c.LoadConfig(*fConfig); err != nil {
+			return err
+		}
+	}
+	var target os.FileInfo
+	if len(args) == 1 {
+		target, _ = os.Stat(args[0])
+	}
+	if *fConfigDirectory != "" {
+		walkfn := func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() || filepath.Ext(path) != ".conf" ||
+				(target != nil && os.SameFile(info, target)) {
+				return nil
+			}
+			return c.LoadConfig(path)
+		}
+		if err := filepath.Walk(*fConfigDirectory, walkfn); err != nil {
+			return err
+		}
+	}
+	SetupLogging(*fDebug, *fQuiet, "")
+	config := Discover(c, zoneRunner, "/proc")
+	if len(args) == 0 {
+		_, err := os.Stdout.Write(config)
+		return err
+	}
+	if err := ioutil.WriteFile(args[0], config, 0644); err != nil {
+		return err
+	}
+	log.Printf("I! Wrote the discovered inputs to %s, reload the agent to "+
+		"enable them if it is in its config directory", args[0])
+	return nil
+}
+
+// replay loads the configuration and replays the archives of the arguments
+// to its outputs.
+func replay(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("Usage: telegraf replay <files>")
+	}
+	c, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if len(c.Outputs) == 0 {
+		return fmt.Errorf("no outputs found, did you provide a valid config file?")
+	}
+	ag, err := NewAgent(c)
+	if err != nil {
+		return err
+	}
+	SetupLogging(*fDebug, *fQuiet, "")
+	return ag.Replay(args, *fReplayRate)
+}
+
 func reloadLoop(
 	stop chan struct{},
 ) {
@@ -179,8 +477,7 @@ func reloadLoop(
 		reload <- false
 
 		// If no other options are specified, load the config file and run.
-		c := NewConfig()
-		err := c.LoadConfig(*fConfig)
+		c, err := loadConfig()
 		if err != nil {
 			log.Fatal("E! " + err.Error())
 		}
@@ -202,13 +499,16 @@ func reloadLoop(
 			log.Fatal("E! " + err.Error())
 		}
 
-		// Setup logging
+		// Setup logging, in the state directory for relative paths
 		SetupLogging(
 			ag.Config.Agent.Debug || *fDebug,
 			ag.Config.Agent.Quiet || *fQuiet,
 			ag.Config.Agent.Logfile,
 		)
 
+		if err := ag.InitPlugins(); err != nil {
+			log.Fatal("E! " + err.Error())
+		}
 		err = ag.Connect()
 		if err != nil {
 			log.Fatal("E! " + err.Error())
@@ -234,13 +534,43 @@ func reloadLoop(
 			}
 		}()
 
+		// the tap and maintenance commands signal the agent to load the
+		// debug tap and the maintenance window
+		controls := make(chan os.Signal, 1)
+		signal.Notify(controls, syscall.SIGUSR1, syscall.SIGUSR2)
+		go func() {
+			for {
+				select {
+				case sig := <-controls:
+					if sig == syscall.SIGUSR1 {
+						ag.LoadTap()
+					} else {
+						ag.LoadMaintenance()
+					}
+				case <-shutdown:
+					signal.Stop(controls)
+					return
+				}
+			}
+		}()
+
 		log.Printf("I! Starting Telegraf %s\n", displayVersion())
 		log.Printf("I! Loaded outputs: %s", strings.Join(c.OutputNames(), " "))
+		log.Printf("I! Loaded processors: %s", strings.Join(c.ProcessorNames(), " "))
+		log.Printf("I! Loaded aggregators: %s", strings.Join(c.AggregatorNames(), " "))
 		log.Printf("I! Loaded inputs: %s", strings.Join(c.InputNames(), " "))
 		log.Printf("I! Tags enabled: %s", c.ListTags())
+		log.Printf("I! State directory: %s", stateDirectory)
+		if c.Profile != "" {
+			log.Printf("I! Profile applied: %s", c.Profile)
+		}
 
-		if *fPidfile != "" {
-			f, err := os.OpenFile(*fPidfile, os.O_CREATE|os.O_WRONLY, 0644)
+		pidfile := *fPidfile
+		if pidfile == "" {
+			pidfile = c.Agent.Pidfile
+		}
+		if pidfile != "" {
+			f, path, err := openStateFile(pidfile, os.O_CREATE|os.O_WRONLY|os.O_TRUNC)
 			if err != nil {
 				log.Printf("E! Unable to create pidfile: %s", err)
 			} else {
@@ -249,7 +579,7 @@ func reloadLoop(
 				f.Close()
 
 				defer func() {
-					err := os.Remove(*fPidfile)
+					err := os.Remove(path)
 					if err != nil {
 						log.Printf("E! Unable to remove pidfile: %s", err)
 					}
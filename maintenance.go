@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"syscall"
+	"time"
+)
+
+const (
+	// maintenanceFile is the file of the state directory holding the
+	// maintenance window set at runtime with the maintenance command.
+	maintenanceFile = "maintenance"
+	// maintenanceTag is the tag set to "true" on the metrics written during
+	// a maintenance window.
+	maintenanceTag = "maintenance"
+)
+
+// MaintenanceWindow is a period of planned work on the host, during which
+// the metrics are tagged maintenance=true and the event outputs do not
+// raise new alerts.
+type MaintenanceWindow struct {
+	Until  time.Time `json:"until"`
+	Reason string    `json:"reason,omitempty"`
+}
+
+// tagMaintenance tags the metric with maintenance=true if a maintenance
+// window is open, forgetting the window once it ended.
+func (a *Agent) tagMaintenance(m Metric) Metric {
+	w, _ := a.maintenance.Load().(*MaintenanceWindow)
+	if w == nil {
+		return m
+	}
+	if time.Now().After(w.Until) {
+		if a.maintenance.CompareAndSwap(w, (*MaintenanceWindow)(nil)) {
+			log.Printf("I! Maintenance window ended")
+		}
+		return m
+	}
+
+	tags := m.Tags()
+	if tags[maintenanceTag] == "true" {
+		return m
+	}
+	tags[maintenanceTag] = "true"
+	tagged, err := New(m.Name(), tags, m.Fields(), m.Time(), m.Type())
+	if err != nil {
+		log.Printf("E! Could not tag metric %s for maintenance: %s",
+			m.Name(), err)
+		return m
+	}
+	tagged.SetAggregate(m.IsAggregate())
+	return tagged
+}
+
+// inMaintenance returns true if the metric was written during a maintenance
+// window.
+func inMaintenance(m Metric) bool {
+	return m.Tags()[maintenanceTag] == "true"
+}
+
+// LoadMaintenance opens the maintenance window the maintenance command
+// recorded in the state directory, or closes it if there is none or it
+// ended.
+func (a *Agent) LoadMaintenance() {
+	var w *MaintenanceWindow
+	b, err := ioutil.ReadFile(statePath(maintenanceFile))
+	if err == nil {
+		w = &MaintenanceWindow{}
+		if err = json.Unmarshal(b, w); err != nil {
+			log.Printf("E! Invalid maintenance window in %s, ignoring it: %s",
+				statePath(maintenanceFile), err)
+			w = nil
+		} else if !time.Now().Before(w.Until) {
+			w = nil
+		}
+	} else if !os.IsNotExist(err) {
+		log.Printf("E! Could not read the maintenance window: %s", err)
+	}
+
+	old, _ := a.maintenance.Swap(w).(*MaintenanceWindow)
+	switch {
+	case w != nil && w.Reason != "":
+		log.Printf("I! Maintenance window open until %s: %s",
+			w.Until.Format(time.RFC3339), w.Reason)
+	case w != nil:
+		log.Printf("I! Maintenance window open until %s",
+			w.Until.Format(time.RFC3339))
+	case old != nil:
+		log.Printf("I! Maintenance window closed")
+	}
+}
+
+// SetMaintenance records the maintenance window, or removes it to close the
+// window if nil, and signals the agent running with the pid file of the
+// config to load it. The window is kept over restarts until it ends.
+func SetMaintenance(c *Config, w *MaintenanceWindow, pidfile string) error {
+	path := statePath(maintenanceFile)
+	if w == nil {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	} else {
+		b, err := json.Marshal(w)
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(path, append(b, '\n'), 0644); err != nil {
+			return err
+		}
+	}
+	return signalAgent(c, pidfile, syscall.SIGUSR2,
+		"SIGUSR2 to load the maintenance window")
+}
+
+// parseMaintenanceArgs parses the arguments of the maintenance command: the
+// duration of the window, then its reason.
+func parseMaintenanceArgs(args []string) (*MaintenanceWindow, error) {
+	d, err := time.ParseDuration(args[0])
+	if err != nil {
+		return nil, fmt.Errorf("Invalid duration of the maintenance window: %s",
+			err)
+	}
+	if d <= 0 {
+		return nil, fmt.Errorf("The duration of the maintenance window must " +
+			"be positive")
+	}
+	w := &MaintenanceWindow{Until: time.Now().Add(d).Truncate(time.Second)}
+	for i, word := range args[1:] {
+		if i > 0 {
+			w.Reason += " "
+		}
+		w.Reason += word
+	}
+	return w, nil
+}
@@ -12,6 +12,25 @@ import (
 
 const MaxInt = int(^uint(0) >> 1)
 
+// rebuild returns a new metric with the name, tags, fields and time, of the
+// type of the metric and aggregate if it is. The processors rebuild the
+// metrics they change with it, so that the aggregates they go through are
+// still written to the outputs of the aggregated resolution.
+func rebuild(
+	m Metric,
+	name string,
+	tags map[string]string,
+	fields map[string]interface{},
+	t time.Time,
+) (Metric, error) {
+	out, err := New(name, tags, fields, t, m.Type())
+	if err != nil {
+		return nil, err
+	}
+	out.SetAggregate(m.IsAggregate())
+	return out, nil
+}
+
 func New(
 	name string,
 	tags map[string]string,
@@ -269,7 +288,7 @@ func (m *metric) Split(maxSize int) []Metric {
 		if i >= len(m.fields) {
 			// hit the end of the field byte slice
 			if len(fields) > 0 {
-				out = append(out, copyWith(m.name, m.tags, fields, m.t))
+				out = append(out, m.copyWith(fields))
 			}
 			break
 		}
@@ -289,7 +308,7 @@ func (m *metric) Split(maxSize int) []Metric {
 			// selected field anyways. This means that the given maxSize is too
 			// small for a single field to fit.
 			if len(fields) > 0 {
-				out = append(out, copyWith(m.name, m.tags, fields, m.t))
+				out = append(out, m.copyWith(fields))
 			}
 
 			fields = make([]byte, 0, maxSize)
@@ -506,20 +525,24 @@ func (m *metric) RemoveField(key string) error {
 }
 
 func (m *metric) Copy() Metric {
-	return copyWith(m.name, m.tags, m.fields, m.t)
+	return m.copyWith(m.fields)
 }
 
-func copyWith(name, tags, fields, t []byte) Metric {
+// copyWith copies the metric with the given fields, keeping its type and
+// whether it is an aggregate.
+func (m *metric) copyWith(fields []byte) Metric {
 	out := metric{
-		name:   make([]byte, len(name)),
-		tags:   make([]byte, len(tags)),
-		fields: make([]byte, len(fields)),
-		t:      make([]byte, len(t)),
-	}
-	copy(out.name, name)
-	copy(out.tags, tags)
+		name:      make([]byte, len(m.name)),
+		tags:      make([]byte, len(m.tags)),
+		fields:    make([]byte, len(fields)),
+		t:         make([]byte, len(m.t)),
+		mType:     m.mType,
+		aggregate: m.aggregate,
+	}
+	copy(out.name, m.name)
+	copy(out.tags, m.tags)
 	copy(out.fields, fields)
-	copy(out.t, t)
+	copy(out.t, m.t)
 	return &out
 }
 
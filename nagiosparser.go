@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// nagiosStates are the plugin return codes, by the status word plugins
+// usually start their output with.
+var nagiosStates = map[string]int64{
+	"OK":       0,
+	"WARNING":  1,
+	"CRITICAL": 2,
+	"UNKNOWN":  3,
+}
+
+// nagiosPerfDataItem matches a single 'label'=value[UOM];[warn];[crit];[min];[max]
+var nagiosPerfDataItem = regexp.MustCompile(
+	`('[^']+'|[^\s=']+)=([^;\s]+)(;[^;\s]*)?(;[^;\s]*)?(;[^;\s]*)?(;[^;\s]*)?`)
+
+var nagiosValue = regexp.MustCompile(`^([-+]?[0-9.]+(?:[eE][-+]?[0-9]+)?)(.*)$`)
+
+// NagiosParser parses the output of Nagios plugins: a first line of text,
+// optional long output lines, and performance data following a '|'.
+//
+// Each performance data label becomes a "nagios" metric tagged by perfdata
+// and unit, with the value, thresholds and bounds as fields. The text goes
+// to a "nagios_state" metric, whose state field is the return code, read
+// from the OK/WARNING/CRITICAL/UNKNOWN word the output starts with. Inputs
+// knowing the exit code of the plugin should set the state from it instead.
+type NagiosParser struct {
+	DefaultTags map[string]string
+}
+
+func NewNagiosParser() (Parser, error) {
+	return &NagiosParser{}, nil
+}
+
+func (p *NagiosParser) Parse(buf []byte) ([]Metric, error) {
+	var output string
+	var long []string
+	var perfData []string
+
+	scanner := bufio.NewScanner(bytes.NewReader(buf))
+	inPerfData := false
+	for first := true; scanner.Scan(); first = false {
+		line := scanner.Text()
+		if inPerfData {
+			perfData = append(perfData, line)
+			continue
+		}
+
+		text := line
+		if i := strings.Index(line, "|"); i != -1 {
+			text = line[:i]
+			perfData = append(perfData, line[i+1:])
+			// after the first line, the perfdata goes to the end
+			inPerfData = !first
+		}
+		if first {
+			output = strings.TrimSpace(text)
+		} else {
+			long = append(long, strings.TrimRight(text, " "))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	var metrics []Metric
+	for _, perf := range perfData {
+		for _, match := range nagiosPerfDataItem.FindAllStringSubmatch(perf, -1) {
+			m, err := p.perfMetric(match, now)
+			if err != nil {
+				return nil, err
+			}
+			if m != nil {
+				metrics = append(metrics, m)
+			}
+		}
+	}
+
+	fields := map[string]interface{}{
+		"service_output": output,
+	}
+	if len(long) != 0 {
+		fields["long_service_output"] = strings.Join(long, "\n")
+	}
+	if word := strings.Fields(output); len(word) != 0 {
+		if state, ok := nagiosStates[strings.TrimRight(word[0], ":-")]; ok {
+			fields["state"] = state
+		}
+	}
+	m, err := New("nagios_state", p.tags(nil), fields, now)
+	if err != nil {
+		return nil, err
+	}
+	return append(metrics, m), nil
+}
+
+// perfMetric builds the metric of a matched performance data item, or nil
+// if its value is not numeric, ie, "U" for unknown.
+func (p *NagiosParser) perfMetric(match []string, t time.Time) (Metric, error) {
+	label := strings.Trim(match[1], "'")
+	value := nagiosValue.FindStringSubmatch(match[2])
+	if value == nil {
+		return nil, nil
+	}
+	v, err := strconv.ParseFloat(value[1], 64)
+	if err != nil {
+		return nil, nil
+	}
+
+	fields := map[string]interface{}{"value": v}
+	if err := nagiosRange(fields, "warning", match[3]); err != nil {
+		return nil, fmt.Errorf("perfdata %s: %s", label, err)
+	}
+	if err := nagiosRange(fields, "critical", match[4]); err != nil {
+		return nil, fmt.Errorf("perfdata %s: %s", label, err)
+	}
+	for i, key := range []string{"min", "max"} {
+		s := strings.TrimPrefix(match[5+i], ";")
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			fields[key] = f
+		}
+	}
+
+	tags := map[string]string{"perfdata": label}
+	if value[2] != "" {
+		tags["unit"] = value[2]
+	}
+	return New("nagios", p.tags(tags), fields, t)
+}
+
+// nagiosRange sets the fields of a threshold range. The plugin alerts when
+// the value is outside of "start:end", or inside of "@start:end". A bare
+// number is "0:number", a missing start is 0 and "~" is negative infinity.
+func nagiosRange(fields map[string]interface{}, name, spec string) error {
+	spec = strings.TrimPrefix(spec, ";")
+	if spec == "" {
+		return nil
+	}
+
+	low, high := name+"_lt", name+"_gt"
+	if strings.HasPrefix(spec, "@") {
+		spec = spec[1:]
+		low, high = name+"_ge", name+"_le"
+	}
+
+	start, end := "0", spec
+	if i := strings.Index(spec, ":"); i != -1 {
+		start, end = spec[:i], spec[i+1:]
+	}
+	if start == "" {
+		start = "0"
+	}
+	if start != "~" {
+		f, err := strconv.ParseFloat(start, 64)
+		if err != nil {
+			return fmt.Errorf("invalid %s threshold %q", name, spec)
+		}
+		fields[low] = f
+	}
+	if end != "" {
+		f, err := strconv.ParseFloat(end, 64)
+		if err != nil {
+			return fmt.Errorf("invalid %s threshold %q", name, spec)
+		}
+		fields[high] = f
+	}
+	return nil
+}
+
+func (p *NagiosParser) tags(tags map[string]string) map[string]string {
+	t := make(map[string]string, len(p.DefaultTags)+len(tags))
+	for k, v := range p.DefaultTags {
+		t[k] = v
+	}
+	for k, v := range tags {
+		t[k] = v
+	}
+	return t
+}
+
+func (p *NagiosParser) ParseLine(line string) (Metric, error) {
+	metrics, err := p.Parse([]byte(line))
+	if err != nil {
+		return nil, err
+	}
+	return metrics[len(metrics)-1], nil
+}
+
+func (p *NagiosParser) SetDefaultTags(tags map[string]string) {
+	p.DefaultTags = tags
+}
+
+// nagiosPerfData formats the numeric fields as performance data items,
+// sorted by name, leaving out the skipped fields.
+func nagiosPerfData(fields map[string]interface{}, skip ...string) []string {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		if !sliceContains(k, skip) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var perf []string
+	for _, k := range keys {
+		switch v := fields[k].(type) {
+		case int64, uint64:
+			perf = append(perf, fmt.Sprintf("'%s'=%d", k, v))
+		case float64:
+			perf = append(perf, fmt.Sprintf("'%s'=%s", k,
+				strconv.FormatFloat(v, 'f', -1, 64)))
+		}
+	}
+	return perf
+}
+
+// nagiosState returns the plugin return code held by a state field.
+func nagiosState(value interface{}) (int16, bool) {
+	var state int64
+	switch v := value.(type) {
+	case int64:
+		state = v
+	case uint64:
+		state = int64(v)
+	case float64:
+		state = int64(v)
+	default:
+		return 0, false
+	}
+	if state < 0 || state > 3 {
+		// anything else is unknown to Nagios
+		state = 3
+	}
+	return int16(state), true
+}
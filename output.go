@@ -9,6 +9,55 @@ type Output interface {
 	Description() string
 	// SampleConfig returns the default configuration of the Output
 	SampleConfig() string
-	// Write takes in group of points to be written to the Output
+	// Write takes in group of points to be written to the Output. An Output
+	// which wrote part of the points only returns a *PartialWriteError, so
+	// that only the others are retried.
 	Write(metrics []Metric) error
-}
\ No newline at end of file
+}
+
+// EndpointOutput is an Output the endpoints of which can be resolved from a
+// service registry. SetEndpoints is called before Connect with the endpoints
+// found, the preferred ones first, and again between Close and Connect when
+// they change.
+type EndpointOutput interface {
+	SetEndpoints(endpoints []string)
+}
+
+// TokenOutput is an Output authenticating with a short-lived bearer token
+// renewed from a token source. SetToken is called before Connect with the
+// token, if one could be had, and again before the writes when it is
+// renewed, never during a write.
+type TokenOutput interface {
+	SetToken(token string)
+}
+
+// KerberosOutput is an HTTP Output able to authenticate its requests with
+// Kerberos. SetKerberos is called once, before Connect, with the Kerberos
+// config the output wraps the transport of its HTTP client with.
+type KerberosOutput interface {
+	SetKerberos(k *Kerberos)
+}
+
+// RejectingOutput is an Output dropping the points its server rejects
+// without knowing which points of the batch they are, so that it cannot
+// report them in a *PartialWriteError. SetDroppedRejected is called once,
+// before Connect, with the counter of the rejected metrics of its running
+// output, which it adds the points dropped to.
+type RejectingOutput interface {
+	SetDroppedRejected(dropped Stat)
+}
+
+// PartialWriteError reports the points of a batch an Output accepted, those
+// it will never accept and those its serializer failed on, by their index in
+// the batch. The other points are kept in the buffer and written again with
+// the next batches.
+type PartialWriteError struct {
+	Err            error
+	Accepted       []int
+	Rejected       []int
+	Unserializable []int
+}
+
+func (e *PartialWriteError) Error() string {
+	return e.Err.Error()
+}
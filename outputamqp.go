@@ -0,0 +1,345 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// AMQP publishes the metrics to an exchange of an AMQP 0-9-1 broker such as
+// RabbitMQ, in batches of the metrics with the same routing key, each
+// message confirmed by the broker.
+//
+// The connection is opened again at the next write once it failed, to the
+// next broker of the list, and a write failing on a connection that broke
+// while idle is retried once on a new one.
+type AMQP struct {
+	Brokers []string
+	// Username and Password replace the ones of the URLs of the brokers.
+	Username           string
+	Password           string
+	Exchange           string
+	ExchangeType       string `toml:"exchange_type"`
+	ExchangeDurability string `toml:"exchange_durability"`
+	ExchangePassive    bool   `toml:"exchange_passive"`
+	RoutingTag         string `toml:"routing_tag"`
+	RoutingKey         string `toml:"routing_key"`
+	DeliveryMode       string `toml:"delivery_mode"`
+	ContentType        string `toml:"content_type"`
+	Headers            map[string]string
+	Timeout            Duration
+
+	// Path to CA file
+	SSLCA string `toml:"ssl_ca"`
+	// Path to host cert file
+	SSLCert string `toml:"ssl_cert"`
+	// Path to cert key file
+	SSLKey string `toml:"ssl_key"`
+	// Use SSL but skip chain & host verification
+	InsecureSkipVerify bool
+
+	serializer Serializer
+	tlsConfig  *tls.Config
+	binding    *LocalBinding
+
+	conn *amqpConn
+	// broker connected to last, the next one being tried first once the
+	// connection failed
+	broker int
+}
+
+func newAMQP() *AMQP {
+	return &AMQP{
+		Exchange:           "telegraf",
+		ExchangeType:       "topic",
+		ExchangeDurability: "durable",
+		DeliveryMode:       "persistent",
+		Timeout:            Duration{Duration: 5 * time.Second},
+	}
+}
+
+var amqpSampleConfig = `
+  ## Brokers, tried in turn, amqps:// connecting with TLS. The path is the
+  ## virtual host, "/" being %2f.
+  brokers = ["amqp://localhost:5672/%2f"]
+  ## Authentication with PLAIN, replacing the user of the URLs, guest by
+  ## default
+  # username = "telegraf"
+  # password = "metricsmetricsmetrics"
+
+  ## Exchange the metrics are published to, declared with its type and
+  ## durability unless passive, when it must exist already. The empty name
+  ## is the default exchange, which routes to the queue named by the key.
+  # exchange = "telegraf"
+  # exchange_type = "topic"
+  # exchange_durability = "durable"
+  # exchange_passive = false
+
+  ## Tag the value of which is the routing key of a metric, if it has it,
+  ## else routing_key. The metrics with the same key are published in a
+  ## message together.
+  # routing_tag = "host"
+  # routing_key = ""
+
+  ## "persistent" messages are written to disk by the broker, "transient"
+  ## ones are not
+  # delivery_mode = "persistent"
+  ## Content type and headers of the messages
+  # content_type = "text/plain"
+  # [outputs.amqp.headers]
+  #   database = "telegraf"
+
+  ## Timeout of the connection, and of the confirms of the broker
+  # timeout = "5s"
+
+  ## Optional SSL Config
+  # ssl_ca = "/etc/telegraf/ca.pem"
+  # ssl_cert = "/etc/telegraf/cert.pem"
+  # ssl_key = "/etc/telegraf/key.pem"
+  ## Use SSL but skip chain & host verification
+  # insecure_skip_verify = false
+
+  ## Data format to output.
+  ## Each data format has its own unique set of configuration options, read
+  ## more about them here:
+  ## https://github.com/influxdata/telegraf/blob/master/docs/DATA_FORMATS_OUTPUT.md
+  data_format = "influx"
+`
+
+func (a *AMQP) SampleConfig() string {
+	return amqpSampleConfig
+}
+
+func (a *AMQP) Description() string {
+	return "Publish the metrics to an AMQP exchange, such as of RabbitMQ"
+}
+
+func (a *AMQP) SetSerializer(serializer Serializer) {
+	a.serializer = serializer
+}
+
+// Init checks the delivery mode and the exchange durability, the brokers
+// being checked on connect as they may be discovered.
+func (a *AMQP) Init() error {
+	switch a.DeliveryMode {
+	case "persistent", "transient":
+	default:
+		return fmt.Errorf("invalid delivery_mode %q, expected persistent or "+
+			"transient", a.DeliveryMode)
+	}
+	switch a.ExchangeDurability {
+	case "durable", "transient":
+	default:
+		return fmt.Errorf("invalid exchange_durability %q, expected durable "+
+			"or transient", a.ExchangeDurability)
+	}
+	return nil
+}
+
+func (a *AMQP) Connect() error {
+	if len(a.Brokers) == 0 {
+		return fmt.Errorf("no brokers configured")
+	}
+
+	tlsConfig, err := GetTLSConfig(a.SSLCert, a.SSLKey, a.SSLCA,
+		a.InsecureSkipVerify)
+	if err != nil {
+		return err
+	}
+	a.tlsConfig = tlsConfig
+
+	return a.connect()
+}
+
+func (a *AMQP) Close() error {
+	if a.conn == nil {
+		return nil
+	}
+	err := a.conn.Close()
+	a.conn = nil
+	return err
+}
+
+// SetEndpoints replaces the brokers.
+func (a *AMQP) SetEndpoints(endpoints []string) {
+	a.Brokers = endpoints
+	a.broker = 0
+}
+
+// SetLocalBinding binds the connections to the brokers to the local address.
+func (a *AMQP) SetLocalBinding(b *LocalBinding) {
+	a.binding = b
+}
+
+// connect connects to the first broker accepting the connection, from the
+// one connected to last, and declares the exchange.
+func (a *AMQP) connect() error {
+	var lastErr error
+	for i := range a.Brokers {
+		n := (a.broker + i) % len(a.Brokers)
+		conn, err := a.dial(a.Brokers[n])
+		if err != nil {
+			log.Printf("W! [outputs.amqp] %s", err)
+			lastErr = err
+			continue
+		}
+		if a.Exchange != "" {
+			err = conn.declareExchange(a.Exchange, a.ExchangeType,
+				a.ExchangeDurability == "durable", a.ExchangePassive)
+			if err != nil {
+				conn.Close()
+				return fmt.Errorf("could not declare exchange %s: %s", a.Exchange, err)
+			}
+		}
+		a.conn = conn
+		a.broker = n
+		return nil
+	}
+	return fmt.Errorf("could not connect to any broker: %s", lastErr)
+}
+
+// dial opens a connection to the broker of the URL.
+func (a *AMQP) dial(broker string) (*amqpConn, error) {
+	u, err := url.Parse(broker)
+	if err != nil {
+		return nil, fmt.Errorf("invalid broker URL %q: %s", broker, err)
+	}
+	tlsConfig := a.tlsConfig
+	port := "5672"
+	switch u.Scheme {
+	case "amqp":
+	case "amqps":
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{}
+		}
+		port = "5671"
+	default:
+		return nil, fmt.Errorf("invalid broker URL %q, expected amqp:// or amqps://",
+			broker)
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), port)
+	}
+	if tlsConfig != nil && tlsConfig.ServerName == "" {
+		tlsConfig = tlsConfig.Clone()
+		tlsConfig.ServerName = u.Hostname()
+	}
+
+	username, password := "guest", "guest"
+	if u.User != nil {
+		username = u.User.Username()
+		password, _ = u.User.Password()
+	}
+	if a.Username != "" {
+		username, password = a.Username, a.Password
+	}
+	vhost := "/"
+	if u.Path != "" && u.Path != "/" {
+		vhost = strings.TrimPrefix(u.Path, "/")
+	}
+	return dialAMQP(addr, vhost, username, password, a.Timeout.Duration,
+		tlsConfig, a.binding)
+}
+
+// amqpBatch is the metrics published in a message.
+type amqpBatch struct {
+	indexes []int
+	message *amqpMessage
+}
+
+func (a *AMQP) Write(metrics []Metric) error {
+	var accepted, unserializable []int
+	var batches []*amqpBatch
+	byKey := make(map[string]*amqpBatch)
+	deliveryMode := byte(2)
+	if a.DeliveryMode == "transient" {
+		deliveryMode = 1
+	}
+	now := time.Now()
+	for i, m := range metrics {
+		b, err := a.serializer.Serialize(m)
+		if err != nil {
+			log.Printf("E! [outputs.amqp] could not serialize metric %s: %s",
+				m.Name(), err)
+			unserializable = append(unserializable, i)
+			continue
+		}
+		key := a.RoutingKey
+		if value, ok := m.Tags()[a.RoutingTag]; ok && a.RoutingTag != "" {
+			key = value
+		}
+		batch, ok := byKey[key]
+		if !ok {
+			batch = &amqpBatch{message: &amqpMessage{
+				routingKey:   key,
+				contentType:  a.ContentType,
+				deliveryMode: deliveryMode,
+				headers:      a.Headers,
+				timestamp:    now,
+			}}
+			byKey[key] = batch
+			batches = append(batches, batch)
+		}
+		batch.indexes = append(batch.indexes, i)
+		batch.message.body = append(batch.message.body, b...)
+	}
+
+	var lastErr error
+	for attempt := 0; len(batches) != 0 && attempt < 2; attempt++ {
+		if a.conn == nil {
+			if err := a.connect(); err != nil {
+				lastErr = err
+				break
+			}
+		}
+
+		messages := make([]*amqpMessage, len(batches))
+		for i, batch := range batches {
+			messages[i] = batch.message
+		}
+		confirmed, err := a.conn.publish(a.Exchange, messages)
+		var failed []*amqpBatch
+		for i, batch := range batches {
+			if i < len(confirmed) && confirmed[i] {
+				accepted = append(accepted, batch.indexes...)
+			} else {
+				failed = append(failed, batch)
+			}
+		}
+		batches = failed
+		if err != nil {
+			// the next broker is tried first on the new connection
+			lastErr = err
+			a.Close()
+			a.broker++
+			continue
+		}
+		if len(batches) != 0 {
+			// not retried on the same connection, the broker having nacked
+			// them on an internal error
+			lastErr = fmt.Errorf("%d messages not confirmed by the broker",
+				len(batches))
+			break
+		}
+	}
+
+	if len(batches) == 0 && len(unserializable) == 0 {
+		return nil
+	}
+	if lastErr == nil {
+		lastErr = fmt.Errorf("metrics the serializer failed on")
+	}
+	if len(accepted) == 0 && len(unserializable) == 0 {
+		return lastErr
+	}
+	return &PartialWriteError{
+		Err:            lastErr,
+		Accepted:       accepted,
+		Unserializable: unserializable,
+	}
+}
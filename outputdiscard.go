@@ -0,0 +1,17 @@
+package main
+
+// Discard drops the metrics written to it, ie, to measure the cost of the
+// inputs alone.
+type Discard struct{}
+
+func (d *Discard) Connect() error { return nil }
+
+func (d *Discard) Close() error { return nil }
+
+func (d *Discard) SampleConfig() string { return "" }
+
+func (d *Discard) Description() string {
+	return "Drop all the metrics written to it"
+}
+
+func (d *Discard) Write(metrics []Metric) error { return nil }
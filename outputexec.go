@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// maxExecStderr caps the standard error of the command reported in the
+// write errors.
+const maxExecStderr = 512
+
+// ExecOutput runs a command for each batch, the batch serialized in the
+// data format of the output on its standard input, so that the sites can
+// ship the metrics with their own programs. The write fails, and the batch
+// is written again, when the command exits with an error or times out.
+type ExecOutput struct {
+	Command []string
+	Timeout Duration
+
+	serializer Serializer
+}
+
+func newExecOutput() *ExecOutput {
+	return &ExecOutput{
+		Timeout: Duration{Duration: 5 * time.Second},
+	}
+}
+
+var execOutputSampleConfig = `
+  ## Command to run, and its arguments, for each batch of metrics written
+  ## to its standard input.
+  command = ["/opt/site/bin/ship", "--queue", "metrics"]
+
+  ## Timeout of the command, killed past it
+  # timeout = "5s"
+
+  ## Data format to output.
+  ## Each data format has its own unique set of configuration options, read
+  ## more about them here:
+  ## https://github.com/influxdata/telegraf/blob/master/docs/DATA_FORMATS_OUTPUT.md
+  data_format = "influx"
+`
+
+func (e *ExecOutput) SampleConfig() string {
+	return execOutputSampleConfig
+}
+
+func (e *ExecOutput) Description() string {
+	return "Write each batch of metrics to the standard input of a command"
+}
+
+func (e *ExecOutput) SetSerializer(serializer Serializer) {
+	e.serializer = serializer
+}
+
+// Init checks that there is a command to run.
+func (e *ExecOutput) Init() error {
+	if len(e.Command) == 0 || e.Command[0] == "" {
+		return fmt.Errorf("no command configured")
+	}
+	return nil
+}
+
+func (e *ExecOutput) Connect() error {
+	return nil
+}
+
+func (e *ExecOutput) Close() error {
+	return nil
+}
+
+func (e *ExecOutput) Write(metrics []Metric) error {
+	var buf bytes.Buffer
+	var accepted, unserializable []int
+	for i, m := range metrics {
+		b, err := e.serializer.Serialize(m)
+		if err != nil {
+			log.Printf("E! [outputs.exec] could not serialize metric %s: %s",
+				m.Name(), err)
+			unserializable = append(unserializable, i)
+			continue
+		}
+		buf.Write(b)
+		accepted = append(accepted, i)
+	}
+
+	if buf.Len() != 0 {
+		var stderr bytes.Buffer
+		cmd := exec.Command(e.Command[0], e.Command[1:]...)
+		cmd.Stdin = &buf
+		cmd.Stderr = &stderr
+		if err := RunTimeout(cmd, e.Timeout.Duration); err != nil {
+			msg := strings.TrimSpace(stderr.String())
+			if len(msg) > maxExecStderr {
+				msg = msg[:maxExecStderr] + "..."
+			}
+			if msg != "" {
+				return fmt.Errorf("exec: %s failed: %s: %s", e.Command[0], err, msg)
+			}
+			return fmt.Errorf("exec: %s failed: %s", e.Command[0], err)
+		}
+	}
+
+	if len(unserializable) != 0 {
+		return &PartialWriteError{
+			Err:            fmt.Errorf("metrics the serializer failed on"),
+			Accepted:       accepted,
+			Unserializable: unserializable,
+		}
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os/exec"
+	"sync"
+)
+
+// ExecdOutput writes the metrics in the data format of the output, line
+// protocol by default, to the standard input of a long running program, ie,
+// a plugin built out of tree with the sdk/shim package. The program is
+// restarted on the next write when it exits.
+type ExecdOutput struct {
+	Command []string
+
+	serializer Serializer
+
+	mu    sync.Mutex
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+}
+
+var execdOutputSampleConfig = `
+  ## Program to run, and its arguments, it reads line protocol from its
+  ## standard input.
+  command = ["/opt/site/bin/my_output", "-config", "/etc/my_output.conf"]
+
+  ## Data format to output.
+  ## Each data format has its own unique set of configuration options, read
+  ## more about them here:
+  ## https://github.com/influxdata/telegraf/blob/master/docs/DATA_FORMATS_OUTPUT.md
+  data_format = "influx"
+`
+
+func (e *ExecdOutput) SampleConfig() string {
+	return execdOutputSampleConfig
+}
+
+func (e *ExecdOutput) Description() string {
+	return "Write metrics to the standard input of a long running program"
+}
+
+func (e *ExecdOutput) SetSerializer(serializer Serializer) {
+	e.serializer = serializer
+}
+
+// Init checks that there is a program to run.
+func (e *ExecdOutput) Init() error {
+	if len(e.Command) == 0 || e.Command[0] == "" {
+		return fmt.Errorf("no command configured")
+	}
+	return nil
+}
+
+func (e *ExecdOutput) Connect() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.start()
+}
+
+// start runs the program, with e.mu held.
+func (e *ExecdOutput) start() error {
+	cmd := exec.Command(e.Command[0], e.Command[1:]...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("execd: error starting %s: %s", e.Command[0], err)
+	}
+	e.cmd = cmd
+	e.stdin = stdin
+
+	go func() {
+		// the program reports its errors on its standard error
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			log.Printf("E! [outputs.execd] %s: %s", e.Command[0], scanner.Text())
+		}
+		err := cmd.Wait()
+		e.mu.Lock()
+		// unless closed, which ends the program
+		if e.cmd == cmd {
+			log.Printf("E! %s exited, restarting it on the next write: %v", e.Command[0], err)
+			e.cmd = nil
+			e.stdin = nil
+		}
+		e.mu.Unlock()
+	}()
+	return nil
+}
+
+func (e *ExecdOutput) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.stdin == nil {
+		return nil
+	}
+	// the program is expected to exit when its standard input is closed
+	err := e.stdin.Close()
+	e.cmd = nil
+	e.stdin = nil
+	return err
+}
+
+func (e *ExecdOutput) Write(metrics []Metric) error {
+	var buf bytes.Buffer
+	var accepted, unserializable []int
+	for i, m := range metrics {
+		b, err := e.serializer.Serialize(m)
+		if err != nil {
+			log.Printf("E! [outputs.execd] could not serialize metric %s: %s",
+				m.Name(), err)
+			unserializable = append(unserializable, i)
+			continue
+		}
+		buf.Write(b)
+		accepted = append(accepted, i)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.cmd == nil {
+		if err := e.start(); err != nil {
+			return err
+		}
+	}
+	if _, err := e.stdin.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("execd: error writing to %s: %s", e.Command[0], err)
+	}
+	if len(unserializable) != 0 {
+		return &PartialWriteError{
+			Err:            fmt.Errorf("metrics the serializer failed on"),
+			Accepted:       accepted,
+			Unserializable: unserializable,
+		}
+	}
+	return nil
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const icinga2CheckResultPath = "/v1/actions/process-check-result"
+
+// Icinga2 submits metrics as passive check results through the Icinga2
+// REST API. Each metric is mapped to a host and service by templates,
+// possibly overridden for some measurements, its state is read from the
+// state field and its other numeric fields are sent as performance data.
+// A service template rendering to an empty name submits a host check.
+type Icinga2 struct {
+	URL              string
+	Username         string
+	Password         string
+	Timeout          Duration
+	HostTemplate     string            `toml:"host_template"`
+	ServiceTemplate  string            `toml:"service_template"`
+	StateField       string            `toml:"state_field"`
+	OutputField      string            `toml:"output_field"`
+	SendWithoutState bool              `toml:"send_without_state"`
+	CheckSource      string            `toml:"check_source"`
+	Mappings         []*icinga2Mapping `toml:"mapping"`
+
+	// Path to CA file
+	SSLCA string `toml:"ssl_ca"`
+	// Path to host cert file
+	SSLCert string `toml:"ssl_cert"`
+	// Path to cert key file
+	SSLKey string `toml:"ssl_key"`
+	// Use SSL but skip chain & host verification
+	InsecureSkipVerify bool
+
+	client   *http.Client
+	kerberos *Kerberos
+	binding  *LocalBinding
+	url      string
+	host     *template.Template
+	service  *template.Template
+
+	// counts check results for objects unknown to Icinga2
+	droppedRejected Stat
+}
+
+// icinga2Mapping overrides the host and service of the measurements matching
+// any of its globs. Templates left empty keep the default ones.
+type icinga2Mapping struct {
+	Measurements    []string
+	HostTemplate    string `toml:"host_template"`
+	ServiceTemplate string `toml:"service_template"`
+
+	host    *template.Template
+	service *template.Template
+}
+
+// icinga2Object is the data the host and service templates are rendered with.
+type icinga2Object struct {
+	Measurement string
+	Tags        map[string]string
+}
+
+type icinga2CheckResult struct {
+	Type            string            `json:"type"`
+	Filter          string            `json:"filter"`
+	FilterVars      map[string]string `json:"filter_vars"`
+	ExitStatus      int16             `json:"exit_status"`
+	PluginOutput    string            `json:"plugin_output"`
+	PerformanceData []string          `json:"performance_data,omitempty"`
+	CheckSource     string            `json:"check_source,omitempty"`
+	ExecutionEnd    float64           `json:"execution_end"`
+}
+
+var icinga2SampleConfig = `
+  ## Icinga2 API endpoint
+  url = "https://127.0.0.1:5665"
+  ## API user, which needs the actions/process-check-result permission
+  username = "telegraf"
+  password = "s3cret"
+  ## Timeout for each request
+  # timeout = "5s"
+
+  ## Templates of the host and service names, see text/template. They are
+  ## given the .Measurement name and the .Tags of the metric. An empty
+  ## service name submits the result of the host check.
+  # host_template = "{{.Tags.host}}"
+  # service_template = "{{.Measurement}}"
+
+  ## Field holding the exit status, 0 to 3, and field holding the text of
+  ## the check result.
+  # state_field = "state"
+  # output_field = "service_output"
+  ## Metrics without the state field are not sent, unless this is set to
+  ## true to send them as OK results carrying their performance data.
+  # send_without_state = false
+  ## Reported as the source of the check results, defaults to the hostname
+  # check_source = ""
+
+  ## Different host and service names for some measurements
+  # [[outputs.icinga2.mapping]]
+  #   measurements = ["zfs*"]
+  #   host_template = "{{.Tags.host}}"
+  #   service_template = "zpool {{.Tags.pool}}"
+
+  ## Optional SSL Config
+  # ssl_ca = "/etc/telegraf/ca.pem"
+  # ssl_cert = "/etc/telegraf/cert.pem"
+  # ssl_key = "/etc/telegraf/key.pem"
+  ## Use SSL but skip chain & host verification
+  # insecure_skip_verify = false
+`
+
+func (_ *Icinga2) Description() string {
+	return "Submit metrics as passive check results to the Icinga2 API"
+}
+
+func (_ *Icinga2) SampleConfig() string {
+	return icinga2SampleConfig
+}
+
+// Init checks the url and parses the templates.
+func (i *Icinga2) Init() error {
+	u, err := url.Parse(i.URL)
+	if err != nil {
+		return fmt.Errorf("error parsing url: %s", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("url scheme must be http(s), got %s", u.Scheme)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + icinga2CheckResultPath
+	i.url = u.String()
+
+	if i.CheckSource == "" {
+		if i.CheckSource, err = os.Hostname(); err != nil {
+			return err
+		}
+	}
+
+	if i.host, err = parseTemplate("host", i.HostTemplate); err != nil {
+		return err
+	}
+	if i.service, err = parseTemplate("service", i.ServiceTemplate); err != nil {
+		return err
+	}
+	for _, m := range i.Mappings {
+		if m.HostTemplate != "" {
+			if m.host, err = parseTemplate("host", m.HostTemplate); err != nil {
+				return err
+			}
+		}
+		if m.ServiceTemplate != "" {
+			if m.service, err = parseTemplate("service", m.ServiceTemplate); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (i *Icinga2) Connect() error {
+	tlsConfig, err := GetTLSConfig(
+		i.SSLCert, i.SSLKey, i.SSLCA, i.InsecureSkipVerify)
+	if err != nil {
+		return err
+	}
+	i.client = &http.Client{
+		Timeout: i.Timeout.Duration,
+		Transport: NewWriteStatsTransport("icinga2", i.kerberos.Transport(&http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: tlsConfig,
+			DialContext:     i.binding.DialContext,
+		})),
+	}
+	return nil
+}
+
+func (i *Icinga2) Close() error {
+	return nil
+}
+
+// SetKerberos authenticates the requests with Kerberos instead of the
+// username and password of the API user.
+func (i *Icinga2) SetKerberos(k *Kerberos) {
+	i.kerberos = k
+}
+
+// SetLocalBinding binds the connections to the API to the local address.
+func (i *Icinga2) SetLocalBinding(b *LocalBinding) {
+	i.binding = b
+}
+
+// SetDroppedRejected counts the check results of the objects unknown to
+// Icinga2 in the rejected counter of the running output.
+func (i *Icinga2) SetDroppedRejected(dropped Stat) {
+	i.droppedRejected = dropped
+}
+
+func (i *Icinga2) Write(metrics []Metric) error {
+	for _, m := range metrics {
+		fields := m.Fields()
+		state, ok := nagiosState(fields[i.StateField])
+		if !ok && !i.SendWithoutState {
+			continue
+		}
+
+		host, service, err := i.object(m)
+		if err != nil {
+			log.Printf("E! Could not map %s to an Icinga2 object: %s",
+				m.Name(), err)
+			continue
+		}
+		if host == "" {
+			log.Printf("D! No Icinga2 host for %s, skipping", m.Name())
+			continue
+		}
+
+		output, _ := fields[i.OutputField].(string)
+		if output == "" {
+			output = m.Name()
+		}
+		result := &icinga2CheckResult{
+			Type:            "Host",
+			Filter:          "host.name==h",
+			FilterVars:      map[string]string{"h": host},
+			ExitStatus:      state,
+			PluginOutput:    output,
+			PerformanceData: nagiosPerfData(fields, i.StateField, i.OutputField),
+			CheckSource:     i.CheckSource,
+			ExecutionEnd:    float64(m.UnixNano()) / float64(time.Second),
+		}
+		if service != "" {
+			result.Type = "Service"
+			result.Filter = "host.name==h && service.name==s"
+			result.FilterVars["s"] = service
+		} else if state > 1 {
+			// hosts are only up or down
+			result.ExitStatus = 1
+		}
+
+		if err := i.post(result); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// object returns the host and service names of the metric.
+func (i *Icinga2) object(m Metric) (string, string, error) {
+	host, service := i.host, i.service
+	for _, mapping := range i.Mappings {
+		if matchesAny(m.Name(), mapping.Measurements) {
+			if mapping.host != nil {
+				host = mapping.host
+			}
+			if mapping.service != nil {
+				service = mapping.service
+			}
+			break
+		}
+	}
+
+	data := &icinga2Object{Measurement: m.Name(), Tags: m.Tags()}
+	var h, s bytes.Buffer
+	if err := host.Execute(&h, data); err != nil {
+		return "", "", err
+	}
+	if err := service.Execute(&s, data); err != nil {
+		return "", "", err
+	}
+	return h.String(), s.String(), nil
+}
+
+func (i *Icinga2) post(result *icinga2CheckResult) error {
+	body, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", i.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/json")
+	req.SetBasicAuth(i.Username, i.Password)
+
+	resp, err := i.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, _ := ioutil.ReadAll(resp.Body)
+
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		return nil
+	case resp.StatusCode == http.StatusNotFound:
+		// the host or service does not exist, retrying will not create it
+		log.Printf("W! No Icinga2 object matches %s, dropping the result",
+			result.FilterVars)
+		i.droppedRejected.Incr(1)
+		return nil
+	}
+	return fmt.Errorf("Icinga2 API returned %s: %s",
+		resp.Status, strings.TrimSpace(string(respBody)))
+}
+
+func newIcinga2() *Icinga2 {
+	return &Icinga2{
+		Timeout:         Duration{Duration: time.Second * 5},
+		HostTemplate:    "{{.Tags.host}}",
+		ServiceTemplate: "{{.Measurement}}",
+		StateField:      "state",
+		OutputField:     "service_output",
+	}
+}
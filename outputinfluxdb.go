@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"math/rand"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -43,6 +44,14 @@ type InfluxDB struct {
 	Precision string
 
 	clients []Client
+	// bearer token of the HTTP requests, from the token source of the output
+	token    string
+	kerberos *Kerberos
+	binding  *LocalBinding
+
+	// counts points the server refused and that will not be retried, the
+	// counter of the running output
+	droppedRejected Stat
 }
 
 var influxOutputSampleConfig = `
@@ -111,6 +120,7 @@ func (i *InfluxDB) Connect() error {
 			config := UDPConfig{
 				URL:         u,
 				PayloadSize: i.UDPPayload,
+				Binding:     i.binding,
 			}
 			c, err := NewUDP(config)
 			if err != nil {
@@ -128,6 +138,9 @@ func (i *InfluxDB) Connect() error {
 				Password:        i.Password,
 				HTTPProxy:       i.HTTPProxy,
 				HTTPHeaders:     HTTPHeaders{},
+				Token:           i.token,
+				Kerberos:        i.kerberos,
+				Binding:         i.binding,
 				ContentEncoding: i.ContentEncoding,
 			}
 			for header, value := range i.HTTPHeaders {
@@ -160,9 +173,45 @@ func (i *InfluxDB) Connect() error {
 
 // Close will terminate the session to the backend, returning error if an issue arises
 func (i *InfluxDB) Close() error {
+	i.clients = nil
 	return nil
 }
 
+// SetEndpoints replaces the urls with the endpoints, the write going to one
+// of them at random.
+func (i *InfluxDB) SetEndpoints(endpoints []string) {
+	i.URL = ""
+	i.URLs = endpoints
+}
+
+// SetToken sets the bearer token of the HTTP requests, replacing basic auth.
+func (i *InfluxDB) SetToken(token string) {
+	i.token = token
+	for _, c := range i.clients {
+		if hc, ok := c.(*httpClient); ok {
+			hc.config.Token = token
+		}
+	}
+}
+
+// SetKerberos authenticates the HTTP requests with Kerberos, replacing basic
+// auth.
+func (i *InfluxDB) SetKerberos(k *Kerberos) {
+	i.kerberos = k
+}
+
+// SetLocalBinding binds the HTTP connections and UDP sockets to the local
+// address.
+func (i *InfluxDB) SetLocalBinding(b *LocalBinding) {
+	i.binding = b
+}
+
+// SetDroppedRejected counts the points the server refused in the rejected
+// counter of the running output.
+func (i *InfluxDB) SetDroppedRejected(dropped Stat) {
+	i.droppedRejected = dropped
+}
+
 // SampleConfig returns the formatted sample configuration for the plugin
 func (i *InfluxDB) SampleConfig() string {
 	return influxOutputSampleConfig
@@ -197,6 +246,7 @@ func (i *InfluxDB) Write(metrics []Metric) error {
 				log.Printf("E! Field type conflict, dropping conflicted points: %s", e)
 				// setting err to nil, otherwise we will keep retrying and points
 				// w/ conflicting types will get stuck in the buffer forever.
+				i.droppedRejected.Incr(int64(influxDropped(e, len(metrics))))
 				err = nil
 				break
 			}
@@ -207,6 +257,7 @@ func (i *InfluxDB) Write(metrics []Metric) error {
 				// retention policy permits, and is probably not a cause for
 				// concern.  Retrying will not help unless the retention
 				// policy is modified.
+				i.droppedRejected.Incr(int64(influxDropped(e, len(metrics))))
 				err = nil
 				break
 			}
@@ -215,10 +266,16 @@ func (i *InfluxDB) Write(metrics []Metric) error {
 				log.Printf("E! Parse error; dropping points: %s", e)
 				// This error indicates a bug in Telegraf or InfluxDB parsing
 				// of line protocol.  Retries will not be successful.
+				i.droppedRejected.Incr(int64(influxDropped(e, len(metrics))))
 				err = nil
 				break
 			}
 
+			// The points over the max-series-per-database or
+			// max-values-per-tag limits of the server are not counted as
+			// rejected: the partial write is logged below and the batch
+			// retried, until the limits are raised or the series dropped.
+
 			if strings.Contains(e.Error(), "hinted handoff queue not empty") {
 				// This is an informational message
 				err = nil
@@ -236,6 +293,29 @@ func (i *InfluxDB) Write(metrics []Metric) error {
 	return err
 }
 
+// influxDropped returns the number of points of the batch of n points the
+// server dropped, from the dropped=N of its partial write error, else from
+// the lines it could not parse, else the whole batch.
+func influxDropped(err error, n int) int {
+	msg := err.Error()
+	if i := strings.LastIndex(msg, "dropped="); i != -1 {
+		digits := msg[i+len("dropped="):]
+		if j := strings.IndexFunc(digits, func(r rune) bool {
+			return r < '0' || r > '9'
+		}); j != -1 {
+			digits = digits[:j]
+		}
+		if dropped, err := strconv.Atoi(digits); err == nil && dropped > 0 &&
+			dropped <= n {
+			return dropped
+		}
+	}
+	if lines := strings.Count(msg, "unable to parse"); lines > 0 && lines <= n {
+		return lines
+	}
+	return n
+}
+
 func newInflux() *InfluxDB {
 	return &InfluxDB{
 		Timeout: Duration{Duration: time.Second * 5},
@@ -0,0 +1,447 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+)
+
+// Kafka produces the metrics to a Kafka topic, each metric a message in the
+// data format of the output, keyed by a tag to keep the metrics of a host
+// or service in the same partition. It speaks the Kafka protocol of the
+// brokers since Kafka 1.0, with gzip compression and SASL PLAIN over TLS.
+//
+// The partitions of a batch written are reported to the agent, so that
+// only the metrics of the partitions that failed are written again.
+type Kafka struct {
+	Brokers          []string
+	Topic            string
+	TopicTag         string   `toml:"topic_tag"`
+	RoutingTag       string   `toml:"routing_tag"`
+	RoutingKey       string   `toml:"routing_key"`
+	CompressionCodec string   `toml:"compression_codec"`
+	RequiredAcks     int      `toml:"required_acks"`
+	MaxRetry         int      `toml:"max_retry"`
+	RetryBackoff     Duration `toml:"retry_backoff"`
+	Timeout          Duration
+	ClientID         string `toml:"client_id"`
+	SASLUsername     string `toml:"sasl_username"`
+	SASLPassword     string `toml:"sasl_password"`
+
+	// Path to CA file
+	SSLCA string `toml:"ssl_ca"`
+	// Path to host cert file
+	SSLCert string `toml:"ssl_cert"`
+	// Path to cert key file
+	SSLKey string `toml:"ssl_key"`
+	// Use SSL but skip chain & host verification
+	InsecureSkipVerify bool
+	// Use TLS with the system CAs, without any of the files above
+	EnableTLS bool `toml:"enable_tls"`
+
+	serializer Serializer
+	tlsConfig  *tls.Config
+	binding    *LocalBinding
+
+	// connections to the brokers, by id, and the metadata of the cluster
+	conns    map[int32]*kafkaConn
+	metadata *kafkaMetadata
+	// partition the next message without a key goes to, by topic
+	next map[string]int32
+}
+
+func newKafka() *Kafka {
+	return &Kafka{
+		Topic:            "telegraf",
+		CompressionCodec: "none",
+		RequiredAcks:     -1,
+		MaxRetry:         3,
+		RetryBackoff:     Duration{Duration: 100 * time.Millisecond},
+		Timeout:          Duration{Duration: 5 * time.Second},
+		ClientID:         "telegraf",
+		conns:            make(map[int32]*kafkaConn),
+		next:             make(map[string]int32),
+	}
+}
+
+var kafkaSampleConfig = `
+  ## Brokers to get the metadata of the cluster from
+  brokers = ["localhost:9092"]
+  ## Topic to produce the metrics to
+  topic = "telegraf"
+  ## Tag the value of which is the topic of a metric, if it has it
+  # topic_tag = ""
+
+  ## Tag the value of which is the key of the message of a metric, so that
+  ## the metrics with the same value go to the same partition. Without the
+  ## tag, routing_key is the key, and without it the metrics are spread
+  ## over the partitions.
+  # routing_tag = "host"
+  # routing_key = ""
+
+  ## Compression of the messages, "none" or "gzip"
+  # compression_codec = "none"
+  ## Acknowledgements the brokers must send: 0 for none, 1 for the one of
+  ## the leader of the partition, -1 for the ones of all the in sync replicas
+  # required_acks = -1
+  ## Retries of the messages of the partitions failing, after refreshing
+  ## the metadata of the cluster
+  # max_retry = 3
+  # retry_backoff = "100ms"
+  ## Timeout of the requests, and of the acknowledgements of the brokers
+  # timeout = "5s"
+  # client_id = "telegraf"
+
+  ## Authentication with SASL PLAIN, best combined with TLS
+  # sasl_username = "telegraf"
+  # sasl_password = "metricsmetricsmetrics"
+
+  ## Optional SSL Config, enable_tls uses TLS with the system CAs
+  # enable_tls = false
+  # ssl_ca = "/etc/telegraf/ca.pem"
+  # ssl_cert = "/etc/telegraf/cert.pem"
+  # ssl_key = "/etc/telegraf/key.pem"
+  ## Use SSL but skip chain & host verification
+  # insecure_skip_verify = false
+
+  ## Data format to output.
+  ## Each data format has its own unique set of configuration options, read
+  ## more about them here:
+  ## https://github.com/influxdata/telegraf/blob/master/docs/DATA_FORMATS_OUTPUT.md
+  data_format = "influx"
+`
+
+func (k *Kafka) SampleConfig() string {
+	return kafkaSampleConfig
+}
+
+func (k *Kafka) Description() string {
+	return "Produce the metrics to a Kafka topic"
+}
+
+func (k *Kafka) SetSerializer(serializer Serializer) {
+	k.serializer = serializer
+}
+
+// Init checks the compression codec and the required acks, the brokers
+// being checked on connect as they may be discovered.
+func (k *Kafka) Init() error {
+	switch k.CompressionCodec {
+	case "", "none", "gzip":
+	default:
+		return fmt.Errorf("unsupported compression_codec %q, expected none or gzip",
+			k.CompressionCodec)
+	}
+	switch k.RequiredAcks {
+	case -1, 0, 1:
+	default:
+		return fmt.Errorf("invalid required_acks %d, expected -1, 0 or 1",
+			k.RequiredAcks)
+	}
+	return nil
+}
+
+func (k *Kafka) Connect() error {
+	if len(k.Brokers) == 0 {
+		return fmt.Errorf("no brokers configured")
+	}
+
+	tlsConfig, err := GetTLSConfig(k.SSLCert, k.SSLKey, k.SSLCA,
+		k.InsecureSkipVerify)
+	if err != nil {
+		return err
+	}
+	if tlsConfig == nil && k.EnableTLS {
+		tlsConfig = &tls.Config{}
+	}
+	k.tlsConfig = tlsConfig
+
+	return k.refreshMetadata(nil)
+}
+
+func (k *Kafka) Close() error {
+	for id, conn := range k.conns {
+		conn.Close()
+		delete(k.conns, id)
+	}
+	return nil
+}
+
+// SetEndpoints replaces the brokers the metadata of the cluster is got from,
+// forgetting the ones of the previous cluster.
+func (k *Kafka) SetEndpoints(endpoints []string) {
+	k.Brokers = endpoints
+	k.metadata = nil
+}
+
+// SetLocalBinding binds the connections to the brokers to the local address.
+func (k *Kafka) SetLocalBinding(b *LocalBinding) {
+	k.binding = b
+}
+
+// refreshMetadata gets the metadata of the topics from the first broker
+// answering, the brokers of the config then the ones of the cluster.
+func (k *Kafka) refreshMetadata(topics []string) error {
+	if len(topics) == 0 {
+		topics = []string{k.Topic}
+	}
+	addrs := append([]string{}, k.Brokers...)
+	if k.metadata != nil {
+		for _, addr := range k.metadata.brokers {
+			addrs = append(addrs, addr)
+		}
+	}
+
+	var lastErr error
+	for _, addr := range addrs {
+		conn, err := dialKafka(addr, k.ClientID, k.Timeout.Duration,
+			k.tlsConfig, k.SASLUsername, k.SASLPassword, k.binding)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		md, err := conn.metadata(topics)
+		conn.Close()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		k.metadata = md
+		return nil
+	}
+	return fmt.Errorf("could not get the metadata of the cluster: %s", lastErr)
+}
+
+// broker returns the connection to the broker, opening it if needed.
+func (k *Kafka) broker(id int32) (*kafkaConn, error) {
+	if conn, ok := k.conns[id]; ok {
+		return conn, nil
+	}
+	addr, ok := k.metadata.brokers[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown broker %d", id)
+	}
+	conn, err := dialKafka(addr, k.ClientID, k.Timeout.Duration,
+		k.tlsConfig, k.SASLUsername, k.SASLPassword, k.binding)
+	if err != nil {
+		return nil, err
+	}
+	k.conns[id] = conn
+	return conn, nil
+}
+
+// kafkaRoute is where the message of a metric goes.
+type kafkaRoute struct {
+	index     int
+	topic     string
+	partition int32
+	message   *kafkaMessage
+}
+
+func (k *Kafka) Write(metrics []Metric) error {
+	var accepted, rejected, unserializable []int
+	var routes []*kafkaRoute
+	topics := make(map[string]bool)
+	for i, m := range metrics {
+		value, err := k.serializer.Serialize(m)
+		if err != nil {
+			log.Printf("E! [outputs.kafka] could not serialize metric %s: %s",
+				m.Name(), err)
+			unserializable = append(unserializable, i)
+			continue
+		}
+		route := &kafkaRoute{
+			index:     i,
+			topic:     k.Topic,
+			partition: -1,
+			message:   &kafkaMessage{value: value, timestamp: m.Time()},
+		}
+		if topic, ok := m.Tags()[k.TopicTag]; ok && k.TopicTag != "" {
+			route.topic = topic
+		}
+		if key, ok := m.Tags()[k.RoutingTag]; ok && k.RoutingTag != "" {
+			route.message.key = []byte(key)
+		} else if k.RoutingKey != "" {
+			route.message.key = []byte(k.RoutingKey)
+		}
+		topics[route.topic] = true
+		routes = append(routes, route)
+	}
+
+	var lastErr error
+	for attempt := 0; len(routes) != 0 && attempt <= k.MaxRetry; attempt++ {
+		if attempt > 0 || k.missingTopics(topics) {
+			if attempt > 0 {
+				time.Sleep(k.RetryBackoff.Duration)
+			}
+			names := make([]string, 0, len(topics))
+			for topic := range topics {
+				names = append(names, topic)
+			}
+			sort.Strings(names)
+			if err := k.refreshMetadata(names); err != nil {
+				lastErr = err
+				continue
+			}
+		}
+
+		var failed []*kafkaRoute
+		byBroker := make(map[int32][]*kafkaRoute)
+		for _, route := range routes {
+			leaders, ok := k.metadata.leaders[route.topic]
+			if !ok {
+				lastErr = fmt.Errorf("unknown topic %s", route.topic)
+				failed = append(failed, route)
+				continue
+			}
+			if route.partition < 0 || int(route.partition) >= len(leaders) {
+				route.partition = k.partition(route, len(leaders))
+			}
+			leader := leaders[route.partition]
+			if leader < 0 {
+				lastErr = kafkaError(5)
+				failed = append(failed, route)
+				continue
+			}
+			byBroker[leader] = append(byBroker[leader], route)
+		}
+
+		for id, brokerRoutes := range byBroker {
+			ok, retry, bad, err := k.produce(id, brokerRoutes)
+			if err != nil {
+				lastErr = err
+			}
+			for _, route := range ok {
+				accepted = append(accepted, route.index)
+			}
+			for _, route := range bad {
+				rejected = append(rejected, route.index)
+			}
+			failed = append(failed, retry...)
+		}
+		routes = failed
+	}
+
+	if len(routes) == 0 && len(rejected) == 0 && len(unserializable) == 0 {
+		return nil
+	}
+	if lastErr == nil && len(rejected) == 0 {
+		lastErr = fmt.Errorf("metrics the serializer failed on")
+	} else if lastErr == nil {
+		lastErr = fmt.Errorf("messages rejected by the brokers")
+	}
+	return &PartialWriteError{
+		Err:            lastErr,
+		Accepted:       accepted,
+		Rejected:       rejected,
+		Unserializable: unserializable,
+	}
+}
+
+// partition returns the partition of a message: the one its key hashes to,
+// as the Java producer does, or the next one in turn without a key.
+func (k *Kafka) partition(route *kafkaRoute, partitions int) int32 {
+	if route.message.key != nil {
+		return (kafkaMurmur2(route.message.key) & 0x7fffffff) % int32(partitions)
+	}
+	p := k.next[route.topic] % int32(partitions)
+	k.next[route.topic] = p + 1
+	return p
+}
+
+// produce sends the messages of the routes to the broker, and returns the
+// routes written, the ones to retry and the ones rejected for good.
+func (k *Kafka) produce(id int32, routes []*kafkaRoute) (
+	ok, retry, rejected []*kafkaRoute, err error,
+) {
+	conn, err := k.broker(id)
+	if err != nil {
+		return nil, routes, nil, err
+	}
+
+	messages := make(map[string]map[int32][]*kafkaMessage)
+	for _, route := range routes {
+		if messages[route.topic] == nil {
+			messages[route.topic] = make(map[int32][]*kafkaMessage)
+		}
+		messages[route.topic][route.partition] = append(
+			messages[route.topic][route.partition], route.message)
+	}
+	codec := k.CompressionCodec
+	results, err := conn.produce(int16(k.RequiredAcks), k.Timeout.Duration,
+		codec, messages)
+	if err != nil {
+		conn.Close()
+		delete(k.conns, id)
+		return nil, routes, nil, err
+	}
+
+	for _, route := range routes {
+		res, found := results[route.topic][route.partition]
+		switch {
+		case !found:
+			err = fmt.Errorf("no acknowledgement for %s partition %d",
+				route.topic, route.partition)
+			retry = append(retry, route)
+		case res == nil:
+			ok = append(ok, route)
+		case res.(kafkaError).retriable():
+			err = res
+			retry = append(retry, route)
+		default:
+			err = res
+			log.Printf("E! [outputs.kafka] message rejected by %s partition "+
+				"%d: %s", route.topic, route.partition, res)
+			rejected = append(rejected, route)
+		}
+	}
+	return ok, retry, rejected, err
+}
+
+// kafkaMurmur2 is the murmur2 hash the Java producer partitions the keys
+// with, so that the keys go to the same partitions as with it.
+func kafkaMurmur2(data []byte) int32 {
+	const m = 0x5bd1e995
+	length := len(data)
+	h := uint32(0x9747b28c) ^ uint32(length)
+	for i := 0; i+4 <= length; i += 4 {
+		k := uint32(data[i]) | uint32(data[i+1])<<8 |
+			uint32(data[i+2])<<16 | uint32(data[i+3])<<24
+		k *= m
+		k ^= k >> 24
+		k *= m
+		h *= m
+		h ^= k
+	}
+	tail := length &^ 3
+	switch length % 4 {
+	case 3:
+		h ^= uint32(data[tail+2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint32(data[tail+1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint32(data[tail])
+		h *= m
+	}
+	h ^= h >> 13
+	h *= m
+	h ^= h >> 15
+	return int32(h)
+}
+
+// missingTopics returns true if the metadata lacks any of the topics.
+func (k *Kafka) missingTopics(topics map[string]bool) bool {
+	if k.metadata == nil {
+		return true
+	}
+	for topic := range topics {
+		if _, ok := k.metadata.leaders[topic]; !ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Sizes of the NSCA protocol version 3 packets, the data packet being the
+// C struct with its alignment padding.
+const (
+	nscaIVSize         = 128
+	nscaInitPacketSize = nscaIVSize + 4
+	nscaDataPacketSize = 720
+	nscaHostSize       = 64
+	nscaServiceSize    = 128
+	nscaOutputSize     = 512
+)
+
+// NSCA submits passive check results to Nagios through an NSCA daemon.
+// Every metric holding the state field, such as the nagios_state metrics of
+// the nagios data format, is sent as the result of the service rendered
+// from service_template, with its other numeric fields as performance data.
+type NSCA struct {
+	Address         string
+	Timeout         Duration
+	Encryption      string
+	Password        string
+	ServiceTemplate string `toml:"service_template"`
+	HostTag         string `toml:"host_tag"`
+	Host            string
+	StateField      string `toml:"state_field"`
+	OutputField     string `toml:"output_field"`
+
+	serviceTemplate *template.Template
+	binding         *LocalBinding
+}
+
+// nscaService is the data the service_template is rendered with.
+type nscaService struct {
+	Measurement string
+	Tags        map[string]string
+}
+
+var nscaSampleConfig = `
+  ## Address of the NSCA daemon
+  address = "127.0.0.1:5667"
+  ## Timeout for each write
+  # timeout = "5s"
+  ## Encryption method of the daemon, "none" or "xor", and its password
+  # encryption = "xor"
+  # password = ""
+
+  ## Template of the service names, see text/template. It is given the
+  ## .Measurement name and the .Tags of the metric.
+  # service_template = "{{.Measurement}}"
+
+  ## Tag holding the name of the Nagios host, metrics without it are sent
+  ## for host, which defaults to the local hostname.
+  # host_tag = "host"
+  # host = ""
+
+  ## Field holding the return code, 0 to 3, metrics without it are not sent
+  # state_field = "state"
+  ## Field holding the text of the check result
+  # output_field = "service_output"
+`
+
+func (_ *NSCA) Description() string {
+	return "Submit passive check results to Nagios through NSCA"
+}
+
+func (_ *NSCA) SampleConfig() string {
+	return nscaSampleConfig
+}
+
+// Init checks the address and parses the template, defaulting the host to
+// the hostname.
+func (n *NSCA) Init() error {
+	if n.Address == "" {
+		return fmt.Errorf("address is required")
+	}
+	switch n.Encryption {
+	case "", "none", "xor":
+	default:
+		return fmt.Errorf("unsupported encryption %q, must be none or xor",
+			n.Encryption)
+	}
+
+	t, err := template.New("service").Option("missingkey=zero").
+		Parse(n.ServiceTemplate)
+	if err != nil {
+		return fmt.Errorf("error parsing service_template: %s", err)
+	}
+	n.serviceTemplate = t
+
+	if n.Host == "" {
+		if n.Host, err = os.Hostname(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (n *NSCA) Connect() error {
+	return nil
+}
+
+func (n *NSCA) Close() error {
+	return nil
+}
+
+// SetLocalBinding binds the connections to the server to the local address.
+func (n *NSCA) SetLocalBinding(b *LocalBinding) {
+	n.binding = b
+}
+
+// Write sends each check result over its own connection, as the daemon
+// reads a single packet per connection.
+func (n *NSCA) Write(metrics []Metric) error {
+	var service bytes.Buffer
+	for _, m := range metrics {
+		fields := m.Fields()
+		state, ok := nagiosState(fields[n.StateField])
+		if !ok {
+			continue
+		}
+		tags := m.Tags()
+		host, ok := tags[n.HostTag]
+		if !ok {
+			host = n.Host
+		}
+
+		service.Reset()
+		err := n.serviceTemplate.Execute(&service, &nscaService{
+			Measurement: m.Name(),
+			Tags:        tags,
+		})
+		if err != nil {
+			log.Printf("E! Could not render NSCA service of %s: %s",
+				m.Name(), err)
+			continue
+		}
+
+		output := n.output(fields)
+		if err := n.send(host, service.String(), state, output); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// output returns the text of the check result followed by the perfdata.
+func (n *NSCA) output(fields map[string]interface{}) string {
+	text, _ := fields[n.OutputField].(string)
+	perf := nagiosPerfData(fields, n.StateField, n.OutputField)
+	if len(perf) == 0 {
+		return text
+	}
+	return text + " | " + strings.Join(perf, " ")
+}
+
+func (n *NSCA) send(host, service string, state int16, output string) error {
+	ctx := context.Background()
+	if n.Timeout.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, n.Timeout.Duration)
+		defer cancel()
+	}
+	conn, err := n.binding.DialContext(ctx, "tcp", n.Address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	// the daemon starts with the IV used by the encryption and its time,
+	// which must be the timestamp of the packet.
+	init := make([]byte, nscaInitPacketSize)
+	if _, err := io.ReadFull(conn, init); err != nil {
+		return fmt.Errorf("error reading NSCA init packet: %s", err)
+	}
+	iv := init[:nscaIVSize]
+	timestamp := binary.BigEndian.Uint32(init[nscaIVSize:])
+
+	packet := nscaPacket(host, service, state, output, timestamp)
+	if n.Encryption == "xor" {
+		nscaXOR(packet, iv, n.Password)
+	}
+	_, err = conn.Write(packet)
+	return err
+}
+
+func nscaPacket(
+	host, service string,
+	state int16,
+	output string,
+	timestamp uint32,
+) []byte {
+	p := make([]byte, nscaDataPacketSize)
+	binary.BigEndian.PutUint16(p[0:], 3)
+	binary.BigEndian.PutUint32(p[8:], timestamp)
+	binary.BigEndian.PutUint16(p[12:], uint16(state))
+	// strings are nul terminated in fixed size buffers
+	copy(p[14:14+nscaHostSize-1], host)
+	copy(p[78:78+nscaServiceSize-1], service)
+	copy(p[206:206+nscaOutputSize-1], output)
+	binary.BigEndian.PutUint32(p[4:], crc32.ChecksumIEEE(p))
+	return p
+}
+
+func nscaXOR(packet, iv []byte, password string) {
+	for i := range packet {
+		packet[i] ^= iv[i%len(iv)]
+		if password != "" {
+			packet[i] ^= password[i%len(password)]
+		}
+	}
+}
+
+func newNSCA() *NSCA {
+	return &NSCA{
+		Timeout:         Duration{Duration: time.Second * 5},
+		Encryption:      "none",
+		ServiceTemplate: "{{.Measurement}}",
+		HostTag:         "host",
+		StateField:      "state",
+		OutputField:     "service_output",
+	}
+}
@@ -0,0 +1,504 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	otlpHTTPPath = "/v1/metrics"
+	otlpGRPCPath = "/opentelemetry.proto.collector.metrics.v1.MetricsService/Export"
+
+	// AggregationTemporality of the sums, counters only ever grow.
+	otlpCumulative = 2
+
+	// gRPC status code for a request that will never be accepted.
+	grpcInvalidArgument = "3"
+	// gRPC status code for a request larger than the server accepts.
+	grpcResourceExhausted = "8"
+)
+
+// OTLP writes metrics to an OpenTelemetry collector with the OTLP protocol,
+// over HTTP with protobuf payloads or over gRPC. Every field becomes an OTLP
+// metric named measurement_field, counters as cumulative sums started when
+// the output was created and every other type, histograms and summaries
+// included, as gauges. Tags become attributes of the data points, except
+// those mapped to resource attributes.
+type OTLP struct {
+	Endpoint           string
+	Protocol           string
+	Timeout            Duration
+	Headers            map[string]string
+	ResourceTags       map[string]string `toml:"resource_tags"`
+	ResourceAttributes map[string]string `toml:"resource_attributes"`
+	ContentEncoding    string            `toml:"content_encoding"`
+
+	// Path to CA file
+	SSLCA string `toml:"ssl_ca"`
+	// Path to host cert file
+	SSLCert string `toml:"ssl_cert"`
+	// Path to cert key file
+	SSLKey string `toml:"ssl_key"`
+	// Use SSL but skip chain & host verification
+	InsecureSkipVerify bool
+
+	url    string
+	client *http.Client
+	// bearer token of the requests, from the token source of the output
+	token    string
+	kerberos *Kerberos
+	binding  *LocalBinding
+
+	// counts metrics the collector refused and that will not be retried
+	droppedRejected Stat
+	// start time of the cumulative sums, for the backends to tell the
+	// counter resets of a restart
+	started int64
+}
+
+var otlpSampleConfig = `
+  ## Collector endpoint. With the http protocol the path defaults to
+  ## /v1/metrics, the grpc protocol requires an https endpoint as it runs
+  ## over HTTP/2 with TLS.
+  endpoint = "http://127.0.0.1:4318"
+  ## Protocol to use, "http" (protobuf payloads) or "grpc"
+  # protocol = "http"
+  ## Timeout for each write
+  # timeout = "5s"
+
+  ## Tags moved from the data points to the resource, and the resource
+  ## attribute they are renamed to. An empty name keeps the tag name.
+  # resource_tags = {host = "host.name", zone = "solaris.zone.name"}
+  ## Additional attributes set on the resource of every metric
+  # resource_attributes = {"service.name" = "telegraf"}
+
+  ## Optional headers, ie, for authentication
+  # headers = {"Authorization" = "Bearer s3cret"}
+
+  ## Compress each payload using GZIP.
+  # content_encoding = "gzip"
+
+  ## Counters are sent as cumulative sums, every other metric type as
+  ## gauges, histograms and summaries included, one gauge per field.
+
+  ## Optional SSL Config
+  # ssl_ca = "/etc/telegraf/ca.pem"
+  # ssl_cert = "/etc/telegraf/cert.pem"
+  # ssl_key = "/etc/telegraf/key.pem"
+  ## Use SSL but skip chain & host verification
+  # insecure_skip_verify = false
+`
+
+func (_ *OTLP) Description() string {
+	return "Send metrics to an OpenTelemetry collector using OTLP"
+}
+
+func (_ *OTLP) SampleConfig() string {
+	return otlpSampleConfig
+}
+
+// Init checks the endpoint and the protocol.
+func (o *OTLP) Init() error {
+	u, err := url.Parse(o.Endpoint)
+	if err != nil {
+		return fmt.Errorf("error parsing endpoint: %s", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("endpoint scheme must be http(s), got %s", u.Scheme)
+	}
+
+	switch o.Protocol {
+	case "", "http":
+		if u.Path == "" || u.Path == "/" {
+			u.Path = otlpHTTPPath
+		}
+	case "grpc":
+		if u.Scheme != "https" {
+			return fmt.Errorf("the grpc protocol requires an https endpoint")
+		}
+		u.Path = otlpGRPCPath
+	default:
+		return fmt.Errorf("unknown protocol %q, must be http or grpc",
+			o.Protocol)
+	}
+	o.url = u.String()
+	return nil
+}
+
+func (o *OTLP) Connect() error {
+	tlsConfig, err := GetTLSConfig(
+		o.SSLCert, o.SSLKey, o.SSLCA, o.InsecureSkipVerify)
+	if err != nil {
+		return err
+	}
+
+	o.client = &http.Client{
+		Timeout: o.Timeout.Duration,
+		Transport: NewWriteStatsTransport("otlp", o.kerberos.Transport(&http.Transport{
+			Proxy:             http.ProxyFromEnvironment,
+			TLSClientConfig:   tlsConfig,
+			DialContext:       o.binding.DialContext,
+			ForceAttemptHTTP2: true,
+		})),
+	}
+	return nil
+}
+
+func (o *OTLP) Close() error {
+	return nil
+}
+
+func (o *OTLP) Write(metrics []Metric) error {
+	if len(metrics) == 0 {
+		return nil
+	}
+
+	payload := o.encode(metrics)
+	var err error
+	if o.Protocol == "grpc" {
+		err = o.writeGRPC(payload)
+	} else {
+		err = o.writeHTTP(payload)
+	}
+
+	if err == errOTLPRejected {
+		// retrying will not make the collector accept the payload, and
+		// the metrics would be stuck in the buffer forever.
+		o.droppedRejected.Incr(int64(len(metrics)))
+		return nil
+	}
+	return err
+}
+
+var errOTLPRejected = fmt.Errorf("payload rejected")
+
+func (o *OTLP) writeHTTP(payload []byte) error {
+	var err error
+	if o.ContentEncoding == "gzip" {
+		if payload, err = gzipBytes(payload); err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest("POST", o.url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	if o.ContentEncoding == "gzip" {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	o.setHeaders(req)
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusBadRequest:
+		log.Printf("E! OTLP collector rejected the metrics, dropping them: %s",
+			strings.TrimSpace(string(body)))
+		return errOTLPRejected
+	}
+	return fmt.Errorf("OTLP collector returned %s", resp.Status)
+}
+
+func (o *OTLP) writeGRPC(payload []byte) error {
+	// gRPC messages are framed with a compressed flag and their length
+	var compressed byte
+	if o.ContentEncoding == "gzip" {
+		var err error
+		if payload, err = gzipBytes(payload); err != nil {
+			return err
+		}
+		compressed = 1
+	}
+	frame := make([]byte, 5, 5+len(payload))
+	frame[0] = compressed
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(payload)))
+	frame = append(frame, payload...)
+
+	req, err := http.NewRequest("POST", o.url, bytes.NewReader(frame))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	if compressed == 1 {
+		req.Header.Set("Grpc-Encoding", "gzip")
+	}
+	o.setHeaders(req)
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// trailers are only available once the body has been read
+	ioutil.ReadAll(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("OTLP collector returned %s", resp.Status)
+	}
+
+	// errors without a body are sent in the headers rather than the trailers
+	status := resp.Trailer.Get("Grpc-Status")
+	message := resp.Trailer.Get("Grpc-Message")
+	if status == "" {
+		status = resp.Header.Get("Grpc-Status")
+		message = resp.Header.Get("Grpc-Message")
+	}
+	switch status {
+	case "0":
+		return nil
+	case grpcInvalidArgument:
+		log.Printf("E! OTLP collector rejected the metrics, dropping them: %s",
+			message)
+		return errOTLPRejected
+	case "":
+		return fmt.Errorf("OTLP collector did not return a gRPC status")
+	}
+	return fmt.Errorf("OTLP collector returned gRPC status %s: %s",
+		status, message)
+}
+
+func (o *OTLP) setHeaders(req *http.Request) {
+	req.Header.Set("User-Agent", "telegraf")
+	for k, v := range o.Headers {
+		req.Header.Set(k, v)
+	}
+	if o.token != "" {
+		req.Header.Set("Authorization", "Bearer "+o.token)
+	}
+}
+
+// SetToken sets the bearer token of the requests, replacing the
+// Authorization header.
+func (o *OTLP) SetToken(token string) {
+	o.token = token
+}
+
+// SetKerberos authenticates the requests with Kerberos.
+func (o *OTLP) SetKerberos(k *Kerberos) {
+	o.kerberos = k
+}
+
+// SetLocalBinding binds the connections to the collector to the local
+// address.
+func (o *OTLP) SetLocalBinding(b *LocalBinding) {
+	o.binding = b
+}
+
+// SetDroppedRejected counts the metrics the collector refused in the rejected
+// counter of the running output.
+func (o *OTLP) SetDroppedRejected(dropped Stat) {
+	o.droppedRejected = dropped
+}
+
+// otlpResource gathers the metrics sharing the same resource attributes.
+type otlpResource struct {
+	attributes map[string]string
+	metrics    []*otlpMetric
+	index      map[string]*otlpMetric
+}
+
+type otlpMetric struct {
+	name    string
+	counter bool
+	points  []otlpPoint
+}
+
+type otlpPoint struct {
+	attributes map[string]string
+	start      int64
+	time       int64
+	value      interface{}
+}
+
+// encode builds an ExportMetricsServiceRequest holding the metrics.
+func (o *OTLP) encode(metrics []Metric) []byte {
+	var resources []*otlpResource
+	index := make(map[string]*otlpResource)
+
+	for _, m := range metrics {
+		resAttrs := make(map[string]string, len(o.ResourceAttributes))
+		for k, v := range o.ResourceAttributes {
+			resAttrs[k] = v
+		}
+		attrs := make(map[string]string)
+		for k, v := range m.Tags() {
+			if name, ok := o.ResourceTags[k]; ok {
+				if name == "" {
+					name = k
+				}
+				resAttrs[name] = v
+				continue
+			}
+			attrs[k] = v
+		}
+
+		key := attributesKey(resAttrs)
+		res, ok := index[key]
+		if !ok {
+			res = &otlpResource{
+				attributes: resAttrs,
+				index:      make(map[string]*otlpMetric),
+			}
+			index[key] = res
+			resources = append(resources, res)
+		}
+
+		for field, value := range m.Fields() {
+			switch v := value.(type) {
+			case string:
+				// OTLP metrics are numeric
+				continue
+			case bool:
+				if v {
+					value = int64(1)
+				} else {
+					value = int64(0)
+				}
+			}
+
+			name := m.Name() + "_" + field
+			om, ok := res.index[name]
+			if !ok {
+				om = &otlpMetric{name: name, counter: m.Type() == Counter}
+				res.index[name] = om
+				res.metrics = append(res.metrics, om)
+			}
+			pt := otlpPoint{
+				attributes: attrs,
+				time:       m.UnixNano(),
+				value:      value,
+			}
+			// replayed metrics may be older than the output
+			if om.counter && o.started <= pt.time {
+				pt.start = o.started
+			}
+			om.points = append(om.points, pt)
+		}
+	}
+
+	var req ProtoBuffer
+	for _, res := range resources {
+		req.Message(1, func(rm *ProtoBuffer) {
+			rm.Message(1, func(r *ProtoBuffer) {
+				encodeAttributes(r, 1, res.attributes)
+			})
+			rm.Message(2, func(sm *ProtoBuffer) {
+				sm.Message(1, func(scope *ProtoBuffer) {
+					scope.String(1, "telegraf")
+				})
+				for _, om := range res.metrics {
+					sm.Message(2, om.encode)
+				}
+			})
+		})
+	}
+	return req.Bytes()
+}
+
+func (om *otlpMetric) encode(p *ProtoBuffer) {
+	p.String(1, om.name)
+	points := func(data *ProtoBuffer) {
+		for _, pt := range om.points {
+			data.Message(1, pt.encode)
+		}
+		if om.counter {
+			data.Varint(2, otlpCumulative)
+			data.Bool(3, true)
+		}
+	}
+	if om.counter {
+		p.Message(7, points) // sum
+	} else {
+		p.Message(5, points) // gauge
+	}
+}
+
+func (pt otlpPoint) encode(p *ProtoBuffer) {
+	if pt.start != 0 {
+		p.Fixed64(2, uint64(pt.start))
+	}
+	p.Fixed64(3, uint64(pt.time))
+	switch v := pt.value.(type) {
+	case int64:
+		p.Fixed64(6, uint64(v))
+	case uint64:
+		p.Double(4, float64(v))
+	case float64:
+		p.Double(4, v)
+	}
+	encodeAttributes(p, 7, pt.attributes)
+}
+
+// encodeAttributes writes the attributes as repeated KeyValue fields, sorted
+// by key so that equal sets always encode the same way.
+func encodeAttributes(p *ProtoBuffer, field int, attrs map[string]string) {
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		p.Message(field, func(kv *ProtoBuffer) {
+			kv.String(1, k)
+			kv.Message(2, func(v *ProtoBuffer) {
+				v.String(1, attrs[k])
+			})
+		})
+	}
+}
+
+func attributesKey(attrs map[string]string) string {
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b bytes.Buffer
+	for _, k := range keys {
+		b.WriteString(k)
+		b.WriteByte(0)
+		b.WriteString(attrs[k])
+		b.WriteByte(0)
+	}
+	return b.String()
+}
+
+func gzipBytes(b []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func newOTLP() *OTLP {
+	return &OTLP{
+		Protocol:     "http",
+		Timeout:      Duration{Duration: time.Second * 5},
+		ResourceTags: map[string]string{"host": "host.name"},
+		started:      time.Now().UnixNano(),
+	}
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PrometheusClient exposes the metrics written to it on an HTTP endpoint in
+// the Prometheus text exposition format, for Prometheus to scrape. Each
+// numeric field is a sample named after the measurement and the field,
+// labelled with the tags, and kept until a newer value replaces it or it
+// expires.
+type PrometheusClient struct {
+	Listen             string
+	Path               string
+	ExpirationInterval Duration `toml:"expiration_interval"`
+	BasicUsername      string   `toml:"basic_username"`
+	BasicPassword      string   `toml:"basic_password"`
+	TLSCert            string   `toml:"tls_cert"`
+	TLSKey             string   `toml:"tls_key"`
+
+	binding *LocalBinding
+
+	mu       sync.Mutex
+	families map[string]*promFamily
+	server   *http.Server
+}
+
+// promFamily is the samples of a metric name, with their type.
+type promFamily struct {
+	typ     string
+	samples map[string]*promSample
+}
+
+// promSample is a sample with its labels, rendered, and when it was last
+// written.
+type promSample struct {
+	labels  string
+	value   float64
+	updated time.Time
+}
+
+func newPrometheusClient() *PrometheusClient {
+	return &PrometheusClient{
+		Listen:             ":9273",
+		Path:               "/metrics",
+		ExpirationInterval: Duration{Duration: 60 * time.Second},
+		families:           make(map[string]*promFamily),
+	}
+}
+
+var prometheusClientSampleConfig = `
+  ## Address to listen on, and path of the endpoint scraped
+  # listen = ":9273"
+  # path = "/metrics"
+
+  ## Samples not written again within expiration_interval are no longer
+  ## exposed, "0s" keeping them forever. It should be a few times the
+  ## interval of the inputs.
+  # expiration_interval = "60s"
+
+  ## Credentials the scrapers must send with HTTP basic authentication
+  # basic_username = "prometheus"
+  # basic_password = "metricsmetricsmetrics"
+
+  ## Serve over TLS
+  # tls_cert = "/etc/telegraf/cert.pem"
+  # tls_key = "/etc/telegraf/key.pem"
+`
+
+func (p *PrometheusClient) SampleConfig() string {
+	return prometheusClientSampleConfig
+}
+
+func (p *PrometheusClient) Description() string {
+	return "Expose the metrics on an HTTP endpoint for Prometheus to scrape"
+}
+
+// SetLocalBinding listens on the local address instead of the host of
+// listen.
+func (p *PrometheusClient) SetLocalBinding(b *LocalBinding) {
+	p.binding = b
+}
+
+func (p *PrometheusClient) Connect() error {
+	tlsConfig, err := GetServerTLSConfig(p.TLSCert, p.TLSKey)
+	if err != nil {
+		return err
+	}
+	address, err := p.binding.ListenAddress("tcp", p.Listen)
+	if err != nil {
+		return fmt.Errorf("error listening on %s: %s", p.Listen, err)
+	}
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("error listening on %s: %s", p.Listen, err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(p.Path, p.serveMetrics)
+	p.server = &http.Server{Handler: mux}
+	if tlsConfig != nil {
+		l = tls.NewListener(l, tlsConfig)
+	}
+	go p.serve(p.server, l)
+	log.Printf("I! Exposing the metrics for Prometheus on %s%s", l.Addr(), p.Path)
+	return nil
+}
+
+// serve serves the listener, logging the error the server stopped on unless
+// closed by Close.
+func (p *PrometheusClient) serve(server *http.Server, l net.Listener) {
+	if err := server.Serve(l); err != http.ErrServerClosed {
+		log.Printf("E! [outputs.prometheus_client] %s, the metrics are no "+
+			"longer exposed", err)
+	}
+}
+
+func (p *PrometheusClient) Close() error {
+	if p.server == nil {
+		return nil
+	}
+	err := p.server.Close()
+	p.server = nil
+	return err
+}
+
+func (p *PrometheusClient) Write(metrics []Metric) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	for _, m := range metrics {
+		typ := "untyped"
+		switch m.Type() {
+		case Counter:
+			typ = "counter"
+		case Gauge:
+			typ = "gauge"
+		}
+		labels := promLabels(m.Tags())
+		for key, value := range m.Fields() {
+			v, ok := promValue(value)
+			if !ok {
+				continue
+			}
+			name := promName(m.Name())
+			if key != "value" {
+				name = promName(m.Name() + "_" + key)
+			}
+
+			family, ok := p.families[name]
+			if !ok {
+				family = &promFamily{
+					typ:     typ,
+					samples: make(map[string]*promSample),
+				}
+				p.families[name] = family
+			} else if family.typ != typ {
+				// the measurement changed type, or two of them map to the
+				// same name
+				family.typ = "untyped"
+			}
+			family.samples[labels] = &promSample{
+				labels:  labels,
+				value:   v,
+				updated: now,
+			}
+		}
+	}
+	return nil
+}
+
+// serveMetrics writes the samples not expired in the text exposition format.
+func (p *PrometheusClient) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	if !p.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="telegraf"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var buf bytes.Buffer
+	p.mu.Lock()
+	p.expire(time.Now())
+	names := make([]string, 0, len(p.families))
+	for name := range p.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		family := p.families[name]
+		fmt.Fprintf(&buf, "# HELP %s Telegraf collected metric\n", name)
+		fmt.Fprintf(&buf, "# TYPE %s %s\n", name, family.typ)
+		keys := make([]string, 0, len(family.samples))
+		for key := range family.samples {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			sample := family.samples[key]
+			fmt.Fprintf(&buf, "%s%s %s\n", name, sample.labels,
+				promFormatValue(sample.value))
+		}
+	}
+	p.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(buf.Bytes())
+}
+
+// expire removes the samples not written within the expiration interval,
+// and the families left without samples.
+func (p *PrometheusClient) expire(now time.Time) {
+	if p.ExpirationInterval.Duration <= 0 {
+		return
+	}
+	for name, family := range p.families {
+		for key, sample := range family.samples {
+			if now.Sub(sample.updated) > p.ExpirationInterval.Duration {
+				delete(family.samples, key)
+			}
+		}
+		if len(family.samples) == 0 {
+			delete(p.families, name)
+		}
+	}
+}
+
+func (p *PrometheusClient) authorized(r *http.Request) bool {
+	if p.BasicUsername == "" && p.BasicPassword == "" {
+		return true
+	}
+	username, password, ok := r.BasicAuth()
+	return ok &&
+		subtle.ConstantTimeCompare([]byte(username), []byte(p.BasicUsername)) == 1 &&
+		subtle.ConstantTimeCompare([]byte(password), []byte(p.BasicPassword)) == 1
+}
+
+// promName returns the name with the characters not allowed in Prometheus
+// metric and label names replaced with underscores.
+func promName(name string) string {
+	b := []byte(name)
+	for i, c := range b {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' ||
+			c == ':' || i > 0 && c >= '0' && c <= '9') {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
+
+// promLabels renders the tags as the labels of a sample, sorted by name,
+// "" if there are none.
+func promLabels(tags map[string]string) string {
+	if len(tags) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	labels := make([]string, 0, len(keys))
+	for _, key := range keys {
+		// colons are not allowed in label names
+		name := strings.Replace(promName(key), ":", "_", -1)
+		value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).
+			Replace(tags[key])
+		labels = append(labels, fmt.Sprintf(`%s="%s"`, name, value))
+	}
+	return "{" + strings.Join(labels, ",") + "}"
+}
+
+// promValue returns the value of a numeric or boolean field.
+func promValue(v interface{}) (float64, bool) {
+	switch value := v.(type) {
+	case int64:
+		return float64(value), true
+	case uint64:
+		return float64(value), true
+	case float64:
+		return value, true
+	case bool:
+		if value {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+func promFormatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
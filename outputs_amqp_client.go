@@ -0,0 +1,566 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The frames of the AMQP 0-9-1 protocol.
+const (
+	amqpFrameMethod = 1
+	amqpFrameHeader = 2
+	amqpFrameBody   = 3
+	amqpFrameEnd    = 0xCE
+)
+
+// The classes and methods of the AMQP 0-9-1 protocol used, as class<<16 |
+// method.
+const (
+	amqpConnectionStart   = 10<<16 | 10
+	amqpConnectionStartOk = 10<<16 | 11
+	amqpConnectionTune    = 10<<16 | 30
+	amqpConnectionTuneOk  = 10<<16 | 31
+	amqpConnectionOpen    = 10<<16 | 40
+	amqpConnectionOpenOk  = 10<<16 | 41
+	amqpConnectionClose   = 10<<16 | 50
+	amqpConnectionCloseOk = 10<<16 | 51
+	amqpChannelOpen       = 20<<16 | 10
+	amqpChannelOpenOk     = 20<<16 | 11
+	amqpChannelClose      = 20<<16 | 40
+	amqpChannelCloseOk    = 20<<16 | 41
+	amqpExchangeDeclare   = 40<<16 | 10
+	amqpExchangeDeclareOk = 40<<16 | 11
+	amqpBasicPublish      = 60<<16 | 40
+	amqpBasicAck          = 60<<16 | 80
+	amqpBasicNack         = 60<<16 | 120
+	amqpConfirmSelect     = 85<<16 | 10
+	amqpConfirmSelectOk   = 85<<16 | 11
+
+	amqpBasicClass = 60
+)
+
+// The flags of the properties of the messages set.
+const (
+	amqpPropContentType  = 0x8000
+	amqpPropHeaders      = 0x2000
+	amqpPropDeliveryMode = 0x1000
+	amqpPropTimestamp    = 0x0040
+	amqpPropAppID        = 0x0008
+)
+
+const (
+	// amqpDefaultFrameMax is the largest frame, unless the broker lowers it.
+	amqpDefaultFrameMax = 131072
+	// amqpPublishChannel is the channel the messages are published on.
+	amqpPublishChannel = 1
+	// amqpAccessRefused is the reply code of a failed authentication.
+	amqpAccessRefused = 403
+)
+
+// amqpProtocolHeader starts the connections of AMQP 0-9-1.
+var amqpProtocolHeader = []byte("AMQP\x00\x00\x09\x01")
+
+// amqpClosed is the Close method of the connection or of a channel sent by
+// the broker.
+type amqpClosed struct {
+	channel bool
+	code    uint16
+	text    string
+}
+
+func (e *amqpClosed) Error() string {
+	if e.channel {
+		return fmt.Sprintf("channel closed by the broker: %d %s", e.code, e.text)
+	}
+	return fmt.Sprintf("connection closed by the broker: %d %s", e.code, e.text)
+}
+
+// amqpFrame is a frame received.
+type amqpFrame struct {
+	typ     byte
+	channel uint16
+	payload []byte
+}
+
+// amqpConn is a connection to a broker, with a channel publishing in
+// confirm mode.
+type amqpConn struct {
+	conn     net.Conn
+	reader   *bufio.Reader
+	timeout  time.Duration
+	frameMax uint32
+	// delivery tag of the last message published
+	published uint64
+}
+
+// dialAMQP connects to the broker at addr, over TLS if tlsConfig is set,
+// authenticates with PLAIN and opens the virtual host and the channel the
+// messages are published on, in confirm mode. The connection is bound to the
+// local address of the binding if not nil.
+func dialAMQP(
+	addr string,
+	vhost string,
+	username, password string,
+	timeout time.Duration,
+	tlsConfig *tls.Config,
+	binding *LocalBinding,
+) (*amqpConn, error) {
+	dialer, err := binding.Dialer("tcp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	var conn net.Conn
+	if tlsConfig != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := &amqpConn{
+		conn:     conn,
+		reader:   bufio.NewReader(conn),
+		timeout:  timeout,
+		frameMax: amqpDefaultFrameMax,
+	}
+	if err := c.open(vhost, username, password); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("could not open the connection to %s: %s", addr, err)
+	}
+	return c, nil
+}
+
+// open negotiates the connection and opens the publishing channel.
+func (c *amqpConn) open(vhost, username, password string) error {
+	c.conn.SetDeadline(time.Now().Add(c.timeout))
+	if _, err := c.conn.Write(amqpProtocolHeader); err != nil {
+		return err
+	}
+
+	d, err := c.expect(0, amqpConnectionStart)
+	if err != nil {
+		return err
+	}
+	d.next(2) // version
+	d.table()
+	mechanisms := d.longString()
+	if d.err != nil {
+		return d.err
+	}
+	if !sliceContains("PLAIN", strings.Fields(mechanisms)) {
+		return fmt.Errorf("the broker does not support PLAIN authentication, "+
+			"only %s", mechanisms)
+	}
+	var e amqpEncoder
+	e.putTable(map[string]string{
+		"product":  "telegraf",
+		"platform": "Go",
+	})
+	e.putShortString("PLAIN")
+	e.putLongString("\x00" + username + "\x00" + password)
+	e.putShortString("en_US")
+	if err := c.sendMethod(0, amqpConnectionStartOk, e.Bytes()); err != nil {
+		return err
+	}
+
+	if d, err = c.expect(0, amqpConnectionTune); err != nil {
+		if closed, ok := err.(*amqpClosed); ok && closed.code == amqpAccessRefused {
+			return fmt.Errorf("authentication failed: %s", closed.text)
+		}
+		return err
+	}
+	channelMax := d.uint16()
+	if frameMax := d.uint32(); frameMax != 0 && frameMax < c.frameMax {
+		c.frameMax = frameMax
+	}
+	if d.err != nil {
+		return d.err
+	}
+	// the connection is idle between the writes, without a goroutine to
+	// send heartbeats, so they are disabled, a broken connection failing
+	// the next write instead
+	e.Reset()
+	e.putUint16(channelMax)
+	e.putUint32(c.frameMax)
+	e.putUint16(0)
+	if err := c.sendMethod(0, amqpConnectionTuneOk, e.Bytes()); err != nil {
+		return err
+	}
+
+	e.Reset()
+	e.putShortString(vhost)
+	e.putShortString("")
+	e.WriteByte(0)
+	if err := c.sendMethod(0, amqpConnectionOpen, e.Bytes()); err != nil {
+		return err
+	}
+	if _, err := c.expect(0, amqpConnectionOpenOk); err != nil {
+		return err
+	}
+
+	e.Reset()
+	e.putShortString("")
+	if err := c.sendMethod(amqpPublishChannel, amqpChannelOpen, e.Bytes()); err != nil {
+		return err
+	}
+	if _, err := c.expect(amqpPublishChannel, amqpChannelOpenOk); err != nil {
+		return err
+	}
+	e.Reset()
+	e.WriteByte(0) // no-wait
+	if err := c.sendMethod(amqpPublishChannel, amqpConfirmSelect, e.Bytes()); err != nil {
+		return err
+	}
+	_, err = c.expect(amqpPublishChannel, amqpConfirmSelectOk)
+	return err
+}
+
+// Close closes the connection, without waiting for the broker to confirm it.
+func (c *amqpConn) Close() error {
+	var e amqpEncoder
+	e.putUint16(200)
+	e.putShortString("closing")
+	e.putUint16(0)
+	e.putUint16(0)
+	c.sendMethod(0, amqpConnectionClose, e.Bytes())
+	return c.conn.Close()
+}
+
+// declareExchange declares the exchange, or only checks that it exists if
+// passive.
+func (c *amqpConn) declareExchange(name, kind string, durable, passive bool) error {
+	var e amqpEncoder
+	e.putUint16(0)
+	e.putShortString(name)
+	e.putShortString(kind)
+	var bits byte
+	if passive {
+		bits |= 1
+	}
+	if durable {
+		bits |= 2
+	}
+	e.WriteByte(bits)
+	e.putTable(nil)
+	if err := c.sendMethod(amqpPublishChannel, amqpExchangeDeclare, e.Bytes()); err != nil {
+		return err
+	}
+	_, err := c.expect(amqpPublishChannel, amqpExchangeDeclareOk)
+	return err
+}
+
+// amqpMessage is a message published.
+type amqpMessage struct {
+	routingKey   string
+	body         []byte
+	contentType  string
+	deliveryMode byte
+	headers      map[string]string
+	timestamp    time.Time
+}
+
+// publish publishes the messages to the exchange, and returns whether the
+// broker confirmed each of them, or an error if the connection failed
+// before it confirmed them all.
+func (c *amqpConn) publish(exchange string, messages []*amqpMessage) ([]bool, error) {
+	first := c.published + 1
+	var frames amqpEncoder
+	for _, m := range messages {
+		var e amqpEncoder
+		e.putUint16(0)
+		e.putShortString(exchange)
+		e.putShortString(m.routingKey)
+		e.WriteByte(0) // not mandatory nor immediate
+		c.putFrame(&frames, amqpFrameMethod, amqpPublishChannel,
+			amqpMethodPayload(amqpBasicPublish, e.Bytes()))
+
+		e.Reset()
+		e.putUint16(amqpBasicClass)
+		e.putUint16(0)
+		e.putUint64(uint64(len(m.body)))
+		flags := uint16(amqpPropHeaders | amqpPropDeliveryMode |
+			amqpPropTimestamp | amqpPropAppID)
+		if m.contentType != "" {
+			flags |= amqpPropContentType
+		}
+		e.putUint16(flags)
+		if m.contentType != "" {
+			e.putShortString(m.contentType)
+		}
+		e.putTable(m.headers)
+		e.WriteByte(m.deliveryMode)
+		e.putUint64(uint64(m.timestamp.Unix()))
+		e.putShortString("telegraf")
+		c.putFrame(&frames, amqpFrameHeader, amqpPublishChannel, e.Bytes())
+
+		max := int(c.frameMax) - 8
+		for body := m.body; len(body) != 0; {
+			n := len(body)
+			if n > max {
+				n = max
+			}
+			c.putFrame(&frames, amqpFrameBody, amqpPublishChannel, body[:n])
+			body = body[n:]
+		}
+		c.published++
+	}
+	c.conn.SetDeadline(time.Now().Add(c.timeout))
+	if _, err := c.conn.Write(frames.Bytes()); err != nil {
+		return nil, err
+	}
+
+	// the broker confirms the messages in any order, possibly several at
+	// once up to a delivery tag
+	confirmed := make([]bool, len(messages))
+	pending := len(messages)
+	for pending > 0 {
+		f, err := c.readFrame()
+		if err != nil {
+			return confirmed, err
+		}
+		if f.typ != amqpFrameMethod {
+			continue
+		}
+		d := &amqpDecoder{b: f.payload}
+		method := d.uint32()
+		switch method {
+		case amqpBasicAck, amqpBasicNack:
+			tag := d.uint64()
+			multiple := d.next(1)
+			if d.err != nil {
+				return confirmed, d.err
+			}
+			from := tag
+			if multiple[0]&1 != 0 {
+				from = first
+			}
+			for t := from; t <= tag; t++ {
+				if t < first || t >= first+uint64(len(messages)) {
+					continue
+				}
+				i := t - first
+				if !confirmed[i] && method == amqpBasicAck {
+					confirmed[i] = true
+				}
+				pending--
+			}
+		default:
+			if err := c.closed(f, d, method); err != nil {
+				return confirmed, err
+			}
+		}
+	}
+	return confirmed, nil
+}
+
+// putFrame adds a frame to the buffer.
+func (c *amqpConn) putFrame(e *amqpEncoder, typ byte, channel uint16, payload []byte) {
+	e.WriteByte(typ)
+	e.putUint16(channel)
+	e.putUint32(uint32(len(payload)))
+	e.Write(payload)
+	e.WriteByte(amqpFrameEnd)
+}
+
+// amqpMethodPayload returns the payload of the frame of a method.
+func amqpMethodPayload(method uint32, args []byte) []byte {
+	var e amqpEncoder
+	e.putUint32(method)
+	e.Write(args)
+	return e.Bytes()
+}
+
+// sendMethod writes the frame of a method.
+func (c *amqpConn) sendMethod(channel uint16, method uint32, args []byte) error {
+	var e amqpEncoder
+	c.putFrame(&e, amqpFrameMethod, channel, amqpMethodPayload(method, args))
+	c.conn.SetDeadline(time.Now().Add(c.timeout))
+	_, err := c.conn.Write(e.Bytes())
+	return err
+}
+
+// readFrame reads the next frame.
+func (c *amqpConn) readFrame() (*amqpFrame, error) {
+	c.conn.SetDeadline(time.Now().Add(c.timeout))
+	var head [7]byte
+	if _, err := io.ReadFull(c.reader, head[:]); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(head[3:])
+	if size > c.frameMax {
+		return nil, fmt.Errorf("frame of %d bytes larger than the maximum %d",
+			size, c.frameMax)
+	}
+	payload := make([]byte, size+1)
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		return nil, err
+	}
+	if payload[size] != amqpFrameEnd {
+		return nil, fmt.Errorf("invalid frame end")
+	}
+	return &amqpFrame{
+		typ:     head[0],
+		channel: binary.BigEndian.Uint16(head[1:]),
+		payload: payload[:size],
+	}, nil
+}
+
+// expect reads the frames up to the method on the channel, and returns a
+// decoder of its arguments.
+func (c *amqpConn) expect(channel uint16, method uint32) (*amqpDecoder, error) {
+	for {
+		f, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		if f.typ != amqpFrameMethod {
+			continue
+		}
+		d := &amqpDecoder{b: f.payload}
+		got := d.uint32()
+		if d.err != nil {
+			return nil, d.err
+		}
+		if got == method && f.channel == channel {
+			return d, nil
+		}
+		if err := c.closed(f, d, got); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// closed returns the error of the connection or of a channel the broker
+// closed, confirming the close, or nil for the other methods.
+func (c *amqpConn) closed(f *amqpFrame, d *amqpDecoder, method uint32) error {
+	switch method {
+	case amqpConnectionClose:
+		err := &amqpClosed{code: d.uint16(), text: d.shortString()}
+		c.sendMethod(0, amqpConnectionCloseOk, nil)
+		return err
+	case amqpChannelClose:
+		err := &amqpClosed{channel: true, code: d.uint16(), text: d.shortString()}
+		c.sendMethod(f.channel, amqpChannelCloseOk, nil)
+		return err
+	}
+	return nil
+}
+
+// amqpEncoder encodes the primitive types of the AMQP protocol.
+type amqpEncoder struct {
+	bytes.Buffer
+}
+
+func (e *amqpEncoder) putUint16(v uint16) {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], v)
+	e.Write(b[:])
+}
+
+func (e *amqpEncoder) putUint32(v uint32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], v)
+	e.Write(b[:])
+}
+
+func (e *amqpEncoder) putUint64(v uint64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	e.Write(b[:])
+}
+
+func (e *amqpEncoder) putShortString(s string) {
+	if len(s) > 255 {
+		s = s[:255]
+	}
+	e.WriteByte(byte(len(s)))
+	e.WriteString(s)
+}
+
+func (e *amqpEncoder) putLongString(s string) {
+	e.putUint32(uint32(len(s)))
+	e.WriteString(s)
+}
+
+// putTable encodes a field table of long strings, sorted by name.
+func (e *amqpEncoder) putTable(fields map[string]string) {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var t amqpEncoder
+	for _, name := range names {
+		t.putShortString(name)
+		t.WriteByte('S')
+		t.putLongString(fields[name])
+	}
+	e.putUint32(uint32(t.Len()))
+	e.Write(t.Bytes())
+}
+
+// amqpDecoder decodes the primitive types of the AMQP protocol, keeping
+// the first error, after which it returns zero values.
+type amqpDecoder struct {
+	b   []byte
+	err error
+}
+
+func (d *amqpDecoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || len(d.b) < n {
+		d.err = fmt.Errorf("truncated frame")
+		return nil
+	}
+	b := d.b[:n]
+	d.b = d.b[n:]
+	return b
+}
+
+func (d *amqpDecoder) uint16() uint16 {
+	if b := d.next(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (d *amqpDecoder) uint32() uint32 {
+	if b := d.next(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (d *amqpDecoder) uint64() uint64 {
+	if b := d.next(8); b != nil {
+		return binary.BigEndian.Uint64(b)
+	}
+	return 0
+}
+
+func (d *amqpDecoder) shortString() string {
+	if b := d.next(1); b != nil {
+		return string(d.next(int(b[0])))
+	}
+	return ""
+}
+
+func (d *amqpDecoder) longString() string {
+	return string(d.next(int(d.uint32())))
+}
+
+// table skips a field table, the properties of the broker not being used.
+func (d *amqpDecoder) table() {
+	d.next(int(d.uint32()))
+}
@@ -51,21 +51,24 @@ func NewHTTP(config HTTPConfig, defaultWP WriteParams) (Client, error) {
 		transport = http.Transport{
 			Proxy:           http.ProxyURL(proxyURL),
 			TLSClientConfig: config.TLSConfig,
+			DialContext:     config.Binding.DialContext,
 		}
 	} else {
 		transport = http.Transport{
 			Proxy:           http.ProxyFromEnvironment,
 			TLSClientConfig: config.TLSConfig,
+			DialContext:     config.Binding.DialContext,
 		}
 	}
 
 	return &httpClient{
-		writeURL: writeURL(u, defaultWP),
-		config:   config,
-		url:      u,
+		writeURL:  writeURL(u, defaultWP),
+		config:    config,
+		url:       u,
+		transport: &transport,
 		client: &http.Client{
 			Timeout:   config.Timeout,
-			Transport: &transport,
+			Transport: NewWriteStatsTransport("influxdb", config.Kerberos.Transport(&transport)),
 		},
 	}, nil
 }
@@ -102,6 +105,15 @@ type HTTPConfig struct {
 	// HTTP headers to append to HTTP requests.
 	HTTPHeaders HTTPHeaders
 
+	// Token is the bearer token of the requests, replacing basic auth.
+	Token string
+
+	// Kerberos authenticates the requests with SPNEGO, replacing basic auth.
+	Kerberos *Kerberos
+
+	// Binding is the local address of the connections, any if nil.
+	Binding *LocalBinding
+
 	// The content encoding mechanism to use for each request.
 	ContentEncoding string
 }
@@ -123,10 +135,14 @@ func (r *Response) Error() error {
 }
 
 type httpClient struct {
-	writeURL string
-	config   HTTPConfig
-	client   *http.Client
-	url      *url.URL
+	writeURL  string
+	config    HTTPConfig
+	client    *http.Client
+	transport *http.Transport
+	url       *url.URL
+
+	// addrs the server hostname resolved to on the previous request.
+	addrs []string
 }
 
 func (c *httpClient) Query(command string) error {
@@ -150,6 +166,7 @@ func (c *httpClient) doRequest(
 	req *http.Request,
 	expectedCode int,
 ) error {
+	c.checkAddrs()
 	resp, err := c.client.Do(req)
 	if err != nil {
 		return err
@@ -183,6 +200,19 @@ func (c *httpClient) doRequest(
 	return err
 }
 
+// checkAddrs closes kept-alive connections when the server hostname now
+// resolves to different addresses, so the next request dials the new ones.
+func (c *httpClient) checkAddrs() {
+	addrs, err := DefaultResolver.LookupHost(c.url.Hostname())
+	if err != nil {
+		return
+	}
+	if c.addrs != nil && !sameAddrs(c.addrs, addrs) {
+		c.transport.CloseIdleConnections()
+	}
+	c.addrs = addrs
+}
+
 func (c *httpClient) makeWriteRequest(
 	body io.Reader,
 	writeURL string,
@@ -218,7 +248,9 @@ func (c *httpClient) makeRequest(uri string, body io.Reader) (*http.Request, err
 	}
 
 	req.Header.Set("User-Agent", c.config.UserAgent)
-	if c.config.Username != "" && c.config.Password != "" {
+	if c.config.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.config.Token)
+	} else if c.config.Username != "" && c.config.Password != "" {
 		req.SetBasicAuth(c.config.Username, c.config.Password)
 	}
 	return req, nil
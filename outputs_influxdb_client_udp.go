@@ -23,6 +23,9 @@ type UDPConfig struct {
 	// PayloadSize is the maximum size of a UDP client message, optional
 	// Tune this based on your network. Defaults to UDPPayloadSize.
 	PayloadSize int
+
+	// Binding is the local address of the socket, any if nil.
+	Binding *LocalBinding
 }
 
 // NewUDP will return an instance of the telegraf UDP output plugin for influxdb
@@ -32,28 +35,68 @@ func NewUDP(config UDPConfig) (Client, error) {
 		return nil, fmt.Errorf("Error parsing UDP url [%s]: %s", config.URL, err)
 	}
 
-	udpAddr, err := net.ResolveUDPAddr("udp", p.Host)
-	if err != nil {
-		return nil, fmt.Errorf("Error resolving UDP Address [%s]: %s", p.Host, err)
-	}
-
-	conn, err := net.DialUDP("udp", nil, udpAddr)
-	if err != nil {
-		return nil, fmt.Errorf("Error dialing UDP address [%s]: %s",
-			udpAddr.String(), err)
-	}
-
 	size := config.PayloadSize
 	if size == 0 {
 		size = UDPPayloadSize
 	}
 	buf := make([]byte, size)
-	return &udpClient{conn: conn, buffer: buf}, nil
+	c := &udpClient{host: p.Host, buffer: buf, binding: config.Binding}
+	if err := c.dial(); err != nil {
+		return nil, err
+	}
+	return c, nil
 }
 
 type udpClient struct {
-	conn   *net.UDPConn
-	buffer []byte
+	host    string
+	conn    *net.UDPConn
+	buffer  []byte
+	binding *LocalBinding
+}
+
+// dial (re)connects the client when it has no connection yet or when its
+// host no longer resolves to the address it is connected to.
+func (c *udpClient) dial() error {
+	host, port, err := net.SplitHostPort(c.host)
+	if err != nil {
+		return fmt.Errorf("Error resolving UDP Address [%s]: %s", c.host, err)
+	}
+	addrs, err := DefaultResolver.LookupHost(host)
+	if err != nil || len(addrs) == 0 {
+		return fmt.Errorf("Error resolving UDP Address [%s]: %s", c.host, err)
+	}
+
+	if c.conn != nil {
+		current := c.conn.RemoteAddr().(*net.UDPAddr).IP.String()
+		if sliceContains(current, addrs) {
+			return nil
+		}
+	}
+
+	udpAddr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(addrs[0], port))
+	if err != nil {
+		return fmt.Errorf("Error resolving UDP Address [%s]: %s", c.host, err)
+	}
+
+	var laddr *net.UDPAddr
+	if c.binding != nil {
+		ip, err := c.binding.IP(udpAddr.IP.To4() == nil)
+		if err != nil {
+			return fmt.Errorf("Error binding UDP address [%s]: %s", c.host, err)
+		}
+		laddr = &net.UDPAddr{IP: ip}
+	}
+
+	conn, err := net.DialUDP("udp", laddr, udpAddr)
+	if err != nil {
+		return fmt.Errorf("Error dialing UDP address [%s]: %s",
+			udpAddr.String(), err)
+	}
+	if c.conn != nil {
+		c.conn.Close()
+	}
+	c.conn = conn
+	return nil
 }
 
 // Query will send the provided query command to the client, returning an error if any issues arise
@@ -63,6 +106,10 @@ func (c *udpClient) Query(command string) error {
 
 // WriteStream will send the provided data through to the client, contentLength is ignored by the UDP client
 func (c *udpClient) WriteStream(r io.Reader) error {
+	if err := c.dial(); err != nil {
+		return err
+	}
+
 	var totaln int
 	for {
 		nR, err := r.Read(c.buffer)
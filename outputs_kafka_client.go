@@ -0,0 +1,493 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"time"
+)
+
+// The API keys of the Kafka protocol requests used.
+const (
+	kafkaAPIProduce          = 0
+	kafkaAPIMetadata         = 3
+	kafkaAPISaslHandshake    = 17
+	kafkaAPISaslAuthenticate = 36
+)
+
+// kafkaAPIVersions are the versions of the requests sent, supported by the
+// brokers since Kafka 1.0.
+var kafkaAPIVersions = map[int16]int16{
+	kafkaAPIProduce:          3,
+	kafkaAPIMetadata:         1,
+	kafkaAPISaslHandshake:    1,
+	kafkaAPISaslAuthenticate: 0,
+}
+
+// kafkaErrors are the names of the error codes of the Kafka protocol the
+// producer may get, and whether they are retriable.
+var kafkaErrors = map[int16]struct {
+	name      string
+	retriable bool
+}{
+	2:  {"CORRUPT_MESSAGE", true},
+	3:  {"UNKNOWN_TOPIC_OR_PARTITION", true},
+	5:  {"LEADER_NOT_AVAILABLE", true},
+	6:  {"NOT_LEADER_FOR_PARTITION", true},
+	7:  {"REQUEST_TIMED_OUT", true},
+	10: {"MESSAGE_TOO_LARGE", false},
+	17: {"INVALID_TOPIC_EXCEPTION", false},
+	18: {"RECORD_LIST_TOO_LARGE", false},
+	19: {"NOT_ENOUGH_REPLICAS", true},
+	20: {"NOT_ENOUGH_REPLICAS_AFTER_APPEND", true},
+	29: {"TOPIC_AUTHORIZATION_FAILED", false},
+	33: {"UNSUPPORTED_SASL_MECHANISM", false},
+	34: {"ILLEGAL_SASL_STATE", false},
+	58: {"SASL_AUTHENTICATION_FAILED", false},
+}
+
+// kafkaError is an error code returned by a broker.
+type kafkaError int16
+
+func (e kafkaError) Error() string {
+	if known, ok := kafkaErrors[int16(e)]; ok {
+		return known.name
+	}
+	return fmt.Sprintf("kafka error %d", int16(e))
+}
+
+// retriable returns true if the request may succeed once retried, after
+// the metadata is refreshed.
+func (e kafkaError) retriable() bool {
+	known, ok := kafkaErrors[int16(e)]
+	return !ok || known.retriable
+}
+
+// kafkaConn is a connection to a broker.
+type kafkaConn struct {
+	conn          net.Conn
+	reader        *bufio.Reader
+	clientID      string
+	timeout       time.Duration
+	correlationID int32
+}
+
+// dialKafka connects to the broker at addr, over TLS if tlsConfig is set,
+// and authenticates with SASL PLAIN if a username is given. The connection
+// is bound to the local address of the binding if not nil.
+func dialKafka(
+	addr string,
+	clientID string,
+	timeout time.Duration,
+	tlsConfig *tls.Config,
+	username, password string,
+	binding *LocalBinding,
+) (*kafkaConn, error) {
+	dialer, err := binding.Dialer("tcp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	var conn net.Conn
+	if tlsConfig != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := &kafkaConn{
+		conn:     conn,
+		reader:   bufio.NewReader(conn),
+		clientID: clientID,
+		timeout:  timeout,
+	}
+	if username != "" {
+		if err := c.authenticate(username, password); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("SASL authentication with %s failed: %s",
+				addr, err)
+		}
+	}
+	return c, nil
+}
+
+func (c *kafkaConn) Close() error {
+	return c.conn.Close()
+}
+
+// authenticate authenticates with the PLAIN SASL mechanism.
+func (c *kafkaConn) authenticate(username, password string) error {
+	var req kafkaEncoder
+	req.putString("PLAIN")
+	resp, err := c.roundTrip(kafkaAPISaslHandshake, req.Bytes())
+	if err != nil {
+		return err
+	}
+	if code := resp.int16(); code != 0 {
+		return kafkaError(code)
+	}
+
+	req.Reset()
+	req.putBytes([]byte("\x00" + username + "\x00" + password))
+	if resp, err = c.roundTrip(kafkaAPISaslAuthenticate, req.Bytes()); err != nil {
+		return err
+	}
+	if code := resp.int16(); code != 0 {
+		if msg := resp.nullableString(); msg != "" {
+			return fmt.Errorf("%s: %s", kafkaError(code), msg)
+		}
+		return kafkaError(code)
+	}
+	return resp.err
+}
+
+// send writes a request, and returns its correlation id.
+func (c *kafkaConn) send(apiKey int16, body []byte) (int32, error) {
+	c.correlationID++
+	var req kafkaEncoder
+	req.putInt32(0) // size, set below
+	req.putInt16(apiKey)
+	req.putInt16(kafkaAPIVersions[apiKey])
+	req.putInt32(c.correlationID)
+	req.putString(c.clientID)
+	req.Write(body)
+	b := req.Bytes()
+	binary.BigEndian.PutUint32(b, uint32(len(b)-4))
+
+	c.conn.SetDeadline(time.Now().Add(c.timeout))
+	_, err := c.conn.Write(b)
+	return c.correlationID, err
+}
+
+// roundTrip sends a request, and returns a decoder of the body of its
+// response.
+func (c *kafkaConn) roundTrip(apiKey int16, body []byte) (*kafkaDecoder, error) {
+	id, err := c.send(apiKey, body)
+	if err != nil {
+		return nil, err
+	}
+	var size int32
+	if err := binary.Read(c.reader, binary.BigEndian, &size); err != nil {
+		return nil, err
+	}
+	if size < 4 {
+		return nil, fmt.Errorf("invalid response size %d", size)
+	}
+	resp := make([]byte, size)
+	if _, err := io.ReadFull(c.reader, resp); err != nil {
+		return nil, err
+	}
+	d := &kafkaDecoder{b: resp}
+	if got := d.int32(); got != id {
+		return nil, fmt.Errorf("response to request %d received for %d", got, id)
+	}
+	return d, nil
+}
+
+// kafkaMetadata is the brokers of the cluster, and the leader of each
+// partition of the topics, by topic.
+type kafkaMetadata struct {
+	brokers map[int32]string
+	leaders map[string][]int32
+}
+
+// metadata requests the metadata of the topics.
+func (c *kafkaConn) metadata(topics []string) (*kafkaMetadata, error) {
+	var req kafkaEncoder
+	req.putInt32(int32(len(topics)))
+	for _, topic := range topics {
+		req.putString(topic)
+	}
+	resp, err := c.roundTrip(kafkaAPIMetadata, req.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	md := &kafkaMetadata{
+		brokers: make(map[int32]string),
+		leaders: make(map[string][]int32),
+	}
+	for n := resp.int32(); n > 0 && resp.err == nil; n-- {
+		id := resp.int32()
+		host := resp.string()
+		port := resp.int32()
+		resp.nullableString() // rack
+		md.brokers[id] = net.JoinHostPort(host, fmt.Sprint(port))
+	}
+	resp.int32() // controller id
+	for n := resp.int32(); n > 0 && resp.err == nil; n-- {
+		code := resp.int16()
+		topic := resp.string()
+		resp.bool() // internal
+		var leaders []int32
+		for p := resp.int32(); p > 0 && resp.err == nil; p-- {
+			resp.int16() // partition error
+			partition := resp.int32()
+			leader := resp.int32()
+			resp.int32Array() // replicas
+			resp.int32Array() // in sync replicas
+			for int32(len(leaders)) <= partition {
+				leaders = append(leaders, -1)
+			}
+			leaders[partition] = leader
+		}
+		if code == 0 && len(leaders) != 0 {
+			md.leaders[topic] = leaders
+		}
+	}
+	return md, resp.err
+}
+
+// kafkaMessage is a message to produce.
+type kafkaMessage struct {
+	key       []byte
+	value     []byte
+	timestamp time.Time
+}
+
+// produce sends the messages of each partition of each topic, and returns
+// the error of each partition of each topic, nil if written. With acks set
+// to 0, the broker does not answer and the messages are taken as written.
+func (c *kafkaConn) produce(
+	acks int16,
+	timeout time.Duration,
+	compression string,
+	messages map[string]map[int32][]*kafkaMessage,
+) (map[string]map[int32]error, error) {
+	var req kafkaEncoder
+	req.putInt16(-1) // no transactional id
+	req.putInt16(acks)
+	req.putInt32(int32(timeout / time.Millisecond))
+	req.putInt32(int32(len(messages)))
+	for topic, partitions := range messages {
+		req.putString(topic)
+		req.putInt32(int32(len(partitions)))
+		for partition, msgs := range partitions {
+			req.putInt32(partition)
+			batch, err := kafkaRecordBatch(msgs, compression)
+			if err != nil {
+				return nil, err
+			}
+			req.putBytes(batch)
+		}
+	}
+
+	results := make(map[string]map[int32]error, len(messages))
+	if acks == 0 {
+		if _, err := c.send(kafkaAPIProduce, req.Bytes()); err != nil {
+			return nil, err
+		}
+		for topic, partitions := range messages {
+			results[topic] = make(map[int32]error, len(partitions))
+			for partition := range partitions {
+				results[topic][partition] = nil
+			}
+		}
+		return results, nil
+	}
+
+	resp, err := c.roundTrip(kafkaAPIProduce, req.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	for n := resp.int32(); n > 0 && resp.err == nil; n-- {
+		topic := resp.string()
+		results[topic] = make(map[int32]error)
+		for p := resp.int32(); p > 0 && resp.err == nil; p-- {
+			partition := resp.int32()
+			code := resp.int16()
+			resp.int64() // base offset
+			resp.int64() // log append time
+			if code != 0 {
+				results[topic][partition] = kafkaError(code)
+			} else {
+				results[topic][partition] = nil
+			}
+		}
+	}
+	return results, resp.err
+}
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+// kafkaRecordBatch encodes the messages as a record batch of the v2 message
+// format, its records compressed with gzip if asked for.
+func kafkaRecordBatch(msgs []*kafkaMessage, compression string) ([]byte, error) {
+	first, last := msgs[0].timestamp, msgs[0].timestamp
+	for _, msg := range msgs {
+		if msg.timestamp.Before(first) {
+			first = msg.timestamp
+		}
+		if msg.timestamp.After(last) {
+			last = msg.timestamp
+		}
+	}
+	firstMs := first.UnixNano() / int64(time.Millisecond)
+
+	var records kafkaEncoder
+	for i, msg := range msgs {
+		var rec kafkaEncoder
+		rec.WriteByte(0) // attributes
+		rec.putVarint(msg.timestamp.UnixNano()/int64(time.Millisecond) - firstMs)
+		rec.putVarint(int64(i))
+		if msg.key == nil {
+			rec.putVarint(-1)
+		} else {
+			rec.putVarint(int64(len(msg.key)))
+			rec.Write(msg.key)
+		}
+		rec.putVarint(int64(len(msg.value)))
+		rec.Write(msg.value)
+		rec.putVarint(0) // headers
+		records.putVarint(int64(rec.Len()))
+		records.Write(rec.Bytes())
+	}
+
+	var attributes int16
+	body := records.Bytes()
+	if compression == "gzip" {
+		attributes = 1
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		if _, err := gz.Write(body); err != nil {
+			return nil, err
+		}
+		if err := gz.Close(); err != nil {
+			return nil, err
+		}
+		body = buf.Bytes()
+	}
+
+	// the part of the batch the crc is computed over
+	var crced kafkaEncoder
+	crced.putInt16(attributes)
+	crced.putInt32(int32(len(msgs) - 1)) // last offset delta
+	crced.putInt64(firstMs)
+	crced.putInt64(last.UnixNano() / int64(time.Millisecond))
+	crced.putInt64(-1) // producer id
+	crced.putInt16(-1) // producer epoch
+	crced.putInt32(-1) // base sequence
+	crced.putInt32(int32(len(msgs)))
+	crced.Write(body)
+
+	var batch kafkaEncoder
+	batch.putInt64(0) // base offset
+	batch.putInt32(int32(4 + 1 + 4 + crced.Len()))
+	batch.putInt32(-1) // partition leader epoch
+	batch.WriteByte(2) // magic
+	batch.putInt32(int32(crc32.Checksum(crced.Bytes(), crc32c)))
+	batch.Write(crced.Bytes())
+	return batch.Bytes(), nil
+}
+
+// kafkaEncoder encodes the primitive types of the Kafka protocol.
+type kafkaEncoder struct {
+	bytes.Buffer
+}
+
+func (e *kafkaEncoder) putInt16(v int16) {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], uint16(v))
+	e.Write(b[:])
+}
+
+func (e *kafkaEncoder) putInt32(v int32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], uint32(v))
+	e.Write(b[:])
+}
+
+func (e *kafkaEncoder) putInt64(v int64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(v))
+	e.Write(b[:])
+}
+
+func (e *kafkaEncoder) putVarint(v int64) {
+	var b [binary.MaxVarintLen64]byte
+	e.Write(b[:binary.PutVarint(b[:], v)])
+}
+
+func (e *kafkaEncoder) putString(s string) {
+	e.putInt16(int16(len(s)))
+	e.WriteString(s)
+}
+
+func (e *kafkaEncoder) putBytes(b []byte) {
+	e.putInt32(int32(len(b)))
+	e.Write(b)
+}
+
+// kafkaDecoder decodes the primitive types of the Kafka protocol, keeping
+// the first error, after which it returns zero values.
+type kafkaDecoder struct {
+	b   []byte
+	err error
+}
+
+func (d *kafkaDecoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || len(d.b) < n {
+		d.err = fmt.Errorf("truncated response")
+		return nil
+	}
+	b := d.b[:n]
+	d.b = d.b[n:]
+	return b
+}
+
+func (d *kafkaDecoder) bool() bool {
+	b := d.next(1)
+	return b != nil && b[0] != 0
+}
+
+func (d *kafkaDecoder) int16() int16 {
+	if b := d.next(2); b != nil {
+		return int16(binary.BigEndian.Uint16(b))
+	}
+	return 0
+}
+
+func (d *kafkaDecoder) int32() int32 {
+	if b := d.next(4); b != nil {
+		return int32(binary.BigEndian.Uint32(b))
+	}
+	return 0
+}
+
+func (d *kafkaDecoder) int64() int64 {
+	if b := d.next(8); b != nil {
+		return int64(binary.BigEndian.Uint64(b))
+	}
+	return 0
+}
+
+func (d *kafkaDecoder) string() string {
+	return string(d.next(int(d.int16())))
+}
+
+func (d *kafkaDecoder) nullableString() string {
+	n := d.int16()
+	if n < 0 {
+		return ""
+	}
+	return string(d.next(int(n)))
+}
+
+func (d *kafkaDecoder) int32Array() []int32 {
+	n := d.int32()
+	var a []int32
+	for ; n > 0 && d.err == nil; n-- {
+		a = append(a, d.int32())
+	}
+	return a
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const serviceNowEventPath = "/api/global/em/jsonv2"
+
+// serviceNowSeverities maps the Nagios return codes of the state field to
+// the event severities, 0 being clear and 1 critical.
+var serviceNowSeverities = map[int16]string{
+	0: "0",
+	1: "4",
+	2: "1",
+	3: "3",
+}
+
+// ServiceNow sends events to the ServiceNow Event Management API directly
+// from the agent, so incidents are raised even when the central monitoring
+// is unreachable. This tree has no alerting stage, the events are made from
+// the metrics holding the state field, such as the nagios_state metrics of
+// the nagios data format, using the Nagios return codes.
+//
+// The em_event fields are rendered from templates, message_key being the
+// deduplication key of ServiceNow. An event is only sent again when its
+// severity changes or after resend_interval, so that steady states do not
+// flood the event table, and a clear is only sent for a key that was sent
+// with another severity.
+type ServiceNow struct {
+	URL            string
+	Username       string
+	Password       string
+	Timeout        Duration
+	Source         string
+	StateField     string            `toml:"state_field"`
+	EventFields    map[string]string `toml:"event_fields"`
+	ResendInterval Duration          `toml:"resend_interval"`
+
+	// Path to CA file
+	SSLCA string `toml:"ssl_ca"`
+	// Path to host cert file
+	SSLCert string `toml:"ssl_cert"`
+	// Path to cert key file
+	SSLKey string `toml:"ssl_key"`
+	// Use SSL but skip chain & host verification
+	InsecureSkipVerify bool
+
+	client    *http.Client
+	kerberos  *Kerberos
+	binding   *LocalBinding
+	url       string
+	templates map[string]*template.Template
+
+	// severity and time of the last event sent, by message key, the cleared
+	// keys being forgotten
+	sent map[string]serviceNowSent
+}
+
+type serviceNowSent struct {
+	severity string
+	time     time.Time
+}
+
+// serviceNowEvent is the data the event field templates are rendered with.
+type serviceNowEvent struct {
+	Measurement string
+	Tags        map[string]string
+	Fields      map[string]interface{}
+}
+
+var serviceNowSampleConfig = `
+  ## ServiceNow instance
+  url = "https://example.service-now.com"
+  ## User with the evt_mgmt_integration role
+  username = "telegraf"
+  password = "s3cret"
+  ## Timeout for each request
+  # timeout = "5s"
+
+  ## Source of the events
+  # source = "telegraf"
+  ## Field holding the Nagios return code the severity is mapped from,
+  ## metrics without it are not sent.
+  # state_field = "state"
+  ## Events with an unchanged severity are sent again after this interval
+  # resend_interval = "1h"
+
+  ## Templates of the em_event fields, see text/template. They are given the
+  ## .Measurement name, the .Tags and the .Fields of the metric. The
+  ## message_key identifies the event for deduplication.
+  # [outputs.servicenow.event_fields]
+  #   node = "{{.Tags.host}}"
+  #   type = "{{.Measurement}}"
+  #   resource = ""
+  #   metric_name = "{{.Measurement}}"
+  #   message_key = "{{.Tags.host}}:{{.Measurement}}"
+  #   description = "{{.Fields.service_output}}"
+
+  ## Optional SSL Config
+  # ssl_ca = "/etc/telegraf/ca.pem"
+  # ssl_cert = "/etc/telegraf/cert.pem"
+  # ssl_key = "/etc/telegraf/key.pem"
+  ## Use SSL but skip chain & host verification
+  # insecure_skip_verify = false
+`
+
+func (_ *ServiceNow) Description() string {
+	return "Send events to the ServiceNow Event Management API"
+}
+
+func (_ *ServiceNow) SampleConfig() string {
+	return serviceNowSampleConfig
+}
+
+// Init checks the url and parses the templates of the event fields.
+func (s *ServiceNow) Init() error {
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		return fmt.Errorf("error parsing url: %s", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("url scheme must be http(s), got %s", u.Scheme)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + serviceNowEventPath
+	s.url = u.String()
+
+	if _, ok := s.EventFields["message_key"]; !ok {
+		return fmt.Errorf("the message_key event field is required")
+	}
+	s.templates = make(map[string]*template.Template, len(s.EventFields))
+	for field, text := range s.EventFields {
+		t, err := parseTemplate(field, text)
+		if err != nil {
+			return err
+		}
+		s.templates[field] = t
+	}
+	return nil
+}
+
+func (s *ServiceNow) Connect() error {
+	// the events sent are kept when reconnecting
+	if s.sent == nil {
+		s.sent = make(map[string]serviceNowSent)
+	}
+
+	tlsConfig, err := GetTLSConfig(
+		s.SSLCert, s.SSLKey, s.SSLCA, s.InsecureSkipVerify)
+	if err != nil {
+		return err
+	}
+	s.client = &http.Client{
+		Timeout: s.Timeout.Duration,
+		Transport: NewWriteStatsTransport("servicenow", s.kerberos.Transport(&http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: tlsConfig,
+			DialContext:     s.binding.DialContext,
+		})),
+	}
+	return nil
+}
+
+func (s *ServiceNow) Close() error {
+	return nil
+}
+
+// SetKerberos authenticates the requests with Kerberos instead of the
+// username and password.
+func (s *ServiceNow) SetKerberos(k *Kerberos) {
+	s.kerberos = k
+}
+
+// SetLocalBinding binds the connections to the instance to the local address.
+func (s *ServiceNow) SetLocalBinding(b *LocalBinding) {
+	s.binding = b
+}
+
+func (s *ServiceNow) Write(metrics []Metric) error {
+	now := time.Now()
+	var records []map[string]string
+	sent := make(map[string]serviceNowSent)
+
+	for _, m := range metrics {
+		fields := m.Fields()
+		state, ok := nagiosState(fields[s.StateField])
+		if !ok {
+			continue
+		}
+		if state != 0 && inMaintenance(m) {
+			// planned work, sent if still failing once it is over
+			continue
+		}
+		severity := serviceNowSeverities[state]
+
+		record, err := s.record(m)
+		if err != nil {
+			log.Printf("E! Could not render ServiceNow event of %s: %s",
+				m.Name(), err)
+			continue
+		}
+		key := record["message_key"]
+		last, ok := sent[key]
+		if !ok {
+			last, ok = s.sent[key]
+		}
+		if severity == "0" {
+			// only what was raised is cleared, and only once
+			if !ok || last.severity == "0" {
+				continue
+			}
+		} else if ok && last.severity == severity &&
+			now.Sub(last.time) < s.ResendInterval.Duration {
+			continue
+		}
+
+		record["source"] = s.Source
+		record["severity"] = severity
+		record["time_of_event"] = m.Time().UTC().Format("2006-01-02 15:04:05")
+		record["additional_info"] = serviceNowInfo(m.Tags())
+		records = append(records, record)
+		sent[key] = serviceNowSent{severity: severity, time: now}
+	}
+	if len(records) == 0 {
+		return nil
+	}
+
+	if err := s.post(records); err != nil {
+		return err
+	}
+	// only remember events once ServiceNow has them, failed writes are
+	// retried with the rest of the buffer.
+	for key, v := range sent {
+		if v.severity == "0" {
+			delete(s.sent, key)
+			continue
+		}
+		s.sent[key] = v
+	}
+	return nil
+}
+
+func (s *ServiceNow) record(m Metric) (map[string]string, error) {
+	data := &serviceNowEvent{
+		Measurement: m.Name(),
+		Tags:        m.Tags(),
+		Fields:      m.Fields(),
+	}
+	record := make(map[string]string, len(s.templates)+4)
+	var buf bytes.Buffer
+	for field, t := range s.templates {
+		buf.Reset()
+		if err := t.Execute(&buf, data); err != nil {
+			return nil, err
+		}
+		record[field] = buf.String()
+	}
+	return record, nil
+}
+
+// serviceNowInfo returns the tags as the JSON object expected in the
+// additional_info field.
+func serviceNowInfo(tags map[string]string) string {
+	info, _ := json.Marshal(tags)
+	return string(info)
+}
+
+func (s *ServiceNow) post(records []map[string]string) error {
+	body, err := json.Marshal(map[string]interface{}{"records": records})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/json")
+	req.SetBasicAuth(s.Username, s.Password)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, _ := ioutil.ReadAll(resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("ServiceNow returned %s: %s",
+			resp.Status, strings.TrimSpace(string(respBody)))
+	}
+	return nil
+}
+
+func newServiceNow() *ServiceNow {
+	return &ServiceNow{
+		Timeout:    Duration{Duration: time.Second * 5},
+		Source:     "telegraf",
+		StateField: "state",
+		EventFields: map[string]string{
+			"node":        "{{.Tags.host}}",
+			"type":        "{{.Measurement}}",
+			"metric_name": "{{.Measurement}}",
+			"message_key": "{{.Tags.host}}:{{.Measurement}}",
+			"description": "{{.Fields.service_output}}",
+		},
+		ResendInterval: Duration{Duration: time.Hour},
+	}
+}
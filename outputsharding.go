@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// shardingReplicas is the number of points of each endpoint on the hash
+// ring, enough for the series to spread evenly across a few endpoints.
+const shardingReplicas = 160
+
+// Sharding writes each series to one of several InfluxDB endpoints, picked
+// by consistent hashing on the value of a tag, so that every endpoint, ie,
+// a relay of a relay tier, only receives its share of the series. Adding or
+// removing an endpoint moves only the series of its share of the ring.
+//
+// Metrics without the tag are sharded on their measurement name. There is
+// no failover: when an endpoint fails only the points of its share of the
+// batch are retried, those written to the other endpoints being reported as
+// accepted in a *PartialWriteError.
+type Sharding struct {
+	URLs     []string `toml:"urls"`
+	ShardTag string   `toml:"shard_tag"`
+
+	// InfluxDB holds the settings shared by the endpoints
+	InfluxDB *InfluxDB `toml:"influxdb"`
+
+	shards []*InfluxDB
+	ring   []shardingPoint
+	// the rejected counter of the running output, shared by the shards
+	droppedRejected Stat
+}
+
+type shardingPoint struct {
+	hash  uint64
+	shard int
+}
+
+var shardingSampleConfig = `
+  ## InfluxDB endpoints to shard the metrics across, each endpoint receiving
+  ## all the metrics of the series hashed to it.
+  urls = ["http://relay1:8086", "http://relay2:8086"] # required
+  ## Tag the series are sharded on, metrics without it are sharded on their
+  ## measurement name.
+  shard_tag = "host"
+
+  ## Settings of the influxdb output used for every endpoint, but for urls.
+  ## The timeout defaults to 5s.
+  [outputs.sharding.influxdb]
+    database = "telegraf"
+    # timeout = "5s"
+    # username = "telegraf"
+    # password = "metricsmetricsmetricsmetrics"
+    # content_encoding = "gzip"
+`
+
+func (s *Sharding) SampleConfig() string {
+	return shardingSampleConfig
+}
+
+func (s *Sharding) Description() string {
+	return "Shard metrics across InfluxDB endpoints by consistent hashing on a tag"
+}
+
+// SetDroppedRejected counts the points the endpoints refused in the rejected
+// counter of the running output.
+func (s *Sharding) SetDroppedRejected(dropped Stat) {
+	s.droppedRejected = dropped
+}
+
+// Init checks that there are endpoints to shard the points over.
+func (s *Sharding) Init() error {
+	if len(s.URLs) == 0 {
+		return fmt.Errorf("no urls configured")
+	}
+	return nil
+}
+
+func (s *Sharding) Connect() error {
+	settings := s.InfluxDB
+	if settings == nil {
+		settings = newInflux()
+	}
+
+	s.shards = s.shards[:0]
+	s.ring = s.ring[:0]
+	for i, u := range s.URLs {
+		shard := *settings
+		shard.URL = ""
+		shard.URLs = []string{u}
+		shard.clients = nil
+		if shard.Timeout.Duration == 0 {
+			shard.Timeout.Duration = 5 * time.Second
+		}
+		shard.droppedRejected = s.droppedRejected
+		if err := shard.Connect(); err != nil {
+			return err
+		}
+		s.shards = append(s.shards, &shard)
+
+		for r := 0; r < shardingReplicas; r++ {
+			s.ring = append(s.ring, shardingPoint{
+				hash:  shardingHash(u + "#" + strconv.Itoa(r)),
+				shard: i,
+			})
+		}
+	}
+	sort.Slice(s.ring, func(i, j int) bool { return s.ring[i].hash < s.ring[j].hash })
+	return nil
+}
+
+func (s *Sharding) Close() error {
+	for _, shard := range s.shards {
+		shard.Close()
+	}
+	return nil
+}
+
+// Write writes the metrics of each shard to its endpoint. When some of the
+// endpoints fail, the metrics written to the others are reported accepted by
+// a partial write, so that they are not written to them again.
+func (s *Sharding) Write(metrics []Metric) error {
+	batches := make([][]Metric, len(s.shards))
+	indexes := make([][]int, len(s.shards))
+	for j, m := range metrics {
+		key, ok := m.Tags()[s.ShardTag]
+		if !ok {
+			key = m.Name()
+		}
+		i := s.shardOf(key)
+		batches[i] = append(batches[i], m)
+		indexes[i] = append(indexes[i], j)
+	}
+
+	var failed []string
+	var accepted []int
+	for i, batch := range batches {
+		if len(batch) == 0 {
+			continue
+		}
+		if err := s.shards[i].Write(batch); err != nil {
+			log.Printf("E! Sharding output error writing to %s: %s", s.URLs[i], err)
+			failed = append(failed, s.URLs[i])
+			continue
+		}
+		accepted = append(accepted, indexes[i]...)
+	}
+	if len(failed) != 0 {
+		return &PartialWriteError{
+			Err: fmt.Errorf("sharding: could not write to %s",
+				strings.Join(failed, ", ")),
+			Accepted: accepted,
+		}
+	}
+	return nil
+}
+
+// shardOf returns the index of the shard owning the key, the first one
+// clockwise from the hash of the key on the ring.
+func (s *Sharding) shardOf(key string) int {
+	h := shardingHash(key)
+	i := sort.Search(len(s.ring), func(i int) bool { return s.ring[i].hash >= h })
+	if i == len(s.ring) {
+		i = 0
+	}
+	return s.ring[i].shard
+}
+
+// shardingHash hashes the key with FNV-1a, mixed with the finalizer of
+// MurmurHash3 as FNV alone spreads keys differing in their last bytes, ie,
+// "host1" and "host2", poorly across the ring.
+func shardingHash(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+func newSharding() *Sharding {
+	return &Sharding{
+		ShardTag: "host",
+	}
+}
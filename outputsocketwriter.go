@@ -0,0 +1,282 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+)
+
+// SocketWriter streams the metrics in the data format of the output over a
+// TCP or unix stream socket, or sends them one per datagram over UDP or a
+// unix datagram socket, for a local relay to pick them up. The connection
+// is opened again at the next write once a write failed.
+type SocketWriter struct {
+	Address         string
+	KeepAlivePeriod Duration `toml:"keep_alive_period"`
+	Timeout         Duration
+
+	// Path to CA file
+	SSLCA string `toml:"ssl_ca"`
+	// Path to host cert file
+	SSLCert string `toml:"ssl_cert"`
+	// Path to cert key file
+	SSLKey string `toml:"ssl_key"`
+	// Use SSL but skip chain & host verification
+	InsecureSkipVerify bool
+
+	// SOCKS5 proxy of the tcp connections
+	Socks5Address  string `toml:"socks5_address"`
+	Socks5Username string `toml:"socks5_username"`
+	Socks5Password string `toml:"socks5_password"`
+
+	serializer Serializer
+	tlsConfig  *tls.Config
+	binding    *LocalBinding
+
+	// network and address of the address
+	network string
+	address string
+	conn    net.Conn
+}
+
+func newSocketWriter() *SocketWriter {
+	return &SocketWriter{
+		KeepAlivePeriod: Duration{Duration: 15 * time.Second},
+		Timeout:         Duration{Duration: 5 * time.Second},
+	}
+}
+
+var socketWriterSampleConfig = `
+  ## Address to write to, as <network>://<address> with network one of
+  ## tcp, tcp4, tcp6, udp, udp4, udp6, unix or unixgram, ie:
+  ##   address = "tcp://127.0.0.1:8094"
+  ##   address = "udp://127.0.0.1:8094"
+  ##   address = "unix:///var/run/relay.sock"
+  address = "tcp://127.0.0.1:8094"
+
+  ## Period of the keep-alive probes of the TCP connections, "0s" disabling
+  ## them
+  # keep_alive_period = "15s"
+  ## Timeout to connect and write
+  # timeout = "5s"
+
+  ## Optional SSL Config, for the tcp networks only
+  # ssl_ca = "/etc/telegraf/ca.pem"
+  # ssl_cert = "/etc/telegraf/cert.pem"
+  # ssl_key = "/etc/telegraf/key.pem"
+  ## Use SSL but skip chain & host verification
+  # insecure_skip_verify = false
+
+  ## SOCKS5 proxy to connect through, for the tcp networks only, with the
+  ## username and password authentication if a username is set. The proxy
+  ## resolves the host name of the address.
+  # socks5_address = "jump.example.com:1080"
+  # socks5_username = ""
+  # socks5_password = ""
+
+  ## Data format to output.
+  ## Each data format has its own unique set of configuration options, read
+  ## more about them here:
+  ## https://github.com/influxdata/telegraf/blob/master/docs/DATA_FORMATS_OUTPUT.md
+  data_format = "influx"
+`
+
+func (s *SocketWriter) SampleConfig() string {
+	return socketWriterSampleConfig
+}
+
+func (s *SocketWriter) Description() string {
+	return "Write the metrics to a network or unix socket"
+}
+
+func (s *SocketWriter) SetSerializer(serializer Serializer) {
+	s.serializer = serializer
+}
+
+func (s *SocketWriter) Connect() error {
+	i := strings.Index(s.Address, "://")
+	if i == -1 {
+		return fmt.Errorf("invalid address %q, expected <network>://<address>",
+			s.Address)
+	}
+	s.network, s.address = s.Address[:i], s.Address[i+3:]
+	switch s.network {
+	case "tcp", "tcp4", "tcp6", "unix", "udp", "udp4", "udp6", "unixgram":
+	default:
+		return fmt.Errorf("unknown network %q of address %s", s.network,
+			s.Address)
+	}
+
+	tlsConfig, err := GetTLSConfig(s.SSLCert, s.SSLKey, s.SSLCA,
+		s.InsecureSkipVerify)
+	if err != nil {
+		return err
+	}
+	if tlsConfig != nil && !strings.HasPrefix(s.network, "tcp") {
+		return fmt.Errorf("TLS is only supported over tcp, not %s", s.network)
+	}
+	if s.Socks5Address != "" && !strings.HasPrefix(s.network, "tcp") {
+		return fmt.Errorf("SOCKS5 is only supported over tcp, not %s", s.network)
+	}
+	if s.binding != nil && strings.HasPrefix(s.network, "unix") {
+		return fmt.Errorf("cannot bind %s sockets to %s", s.network, s.binding)
+	}
+	s.tlsConfig = tlsConfig
+
+	return s.connect()
+}
+
+// connect opens the connection to the address.
+func (s *SocketWriter) connect() error {
+	if s.Socks5Address != "" {
+		return s.connectSocks5()
+	}
+	dialer := &net.Dialer{Timeout: s.Timeout.Duration}
+	if s.binding != nil {
+		var err error
+		dialer, err = s.binding.Dialer(s.network, s.address, s.Timeout.Duration)
+		if err != nil {
+			return err
+		}
+	}
+	dialer.KeepAlive = s.KeepAlivePeriod.Duration
+	if dialer.KeepAlive == 0 {
+		dialer.KeepAlive = -1
+	}
+	var conn net.Conn
+	var err error
+	if s.tlsConfig != nil {
+		conn, err = tls.DialWithDialer(dialer, s.network, s.address, s.tlsConfig)
+	} else {
+		conn, err = dialer.Dial(s.network, s.address)
+	}
+	if err != nil {
+		return err
+	}
+	s.conn = conn
+	return nil
+}
+
+// connectSocks5 opens the connection to the address through the SOCKS5
+// proxy, the TLS session going through the tunnel.
+func (s *SocketWriter) connectSocks5() error {
+	conn, err := DialSocks5(s.binding, s.Socks5Address, s.Socks5Username,
+		s.Socks5Password, s.address, s.Timeout.Duration)
+	if err != nil {
+		return err
+	}
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		// keep-alive probes of the connection to the proxy
+		tcp.SetKeepAlive(s.KeepAlivePeriod.Duration != 0)
+		if s.KeepAlivePeriod.Duration != 0 {
+			tcp.SetKeepAlivePeriod(s.KeepAlivePeriod.Duration)
+		}
+	}
+	if s.tlsConfig != nil {
+		config := s.tlsConfig.Clone()
+		if config.ServerName == "" {
+			config.ServerName, _, _ = net.SplitHostPort(s.address)
+		}
+		tlsConn := tls.Client(conn, config)
+		tlsConn.SetDeadline(time.Now().Add(s.Timeout.Duration))
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return err
+		}
+		tlsConn.SetDeadline(time.Time{})
+		conn = tlsConn
+	}
+	s.conn = conn
+	return nil
+}
+
+func (s *SocketWriter) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+// SetEndpoints writes to the first endpoint, the preferred one, which is
+// <network>://<address> with the network as the scheme of the discovery.
+func (s *SocketWriter) SetEndpoints(endpoints []string) {
+	s.Address = endpoints[0]
+}
+
+// SetLocalBinding binds the tcp and udp sockets to the local address, the
+// connection to the SOCKS5 proxy with one.
+func (s *SocketWriter) SetLocalBinding(b *LocalBinding) {
+	s.binding = b
+}
+
+// datagram returns true if the metrics are sent one per datagram.
+func (s *SocketWriter) datagram() bool {
+	switch s.network {
+	case "udp", "udp4", "udp6", "unixgram":
+		return true
+	}
+	return false
+}
+
+func (s *SocketWriter) Write(metrics []Metric) error {
+	if s.conn == nil {
+		if err := s.connect(); err != nil {
+			return err
+		}
+	}
+
+	var accepted, unserializable, streamed []int
+	var stream []byte
+	var err error
+	for i, m := range metrics {
+		b, serr := s.serializer.Serialize(m)
+		if serr != nil {
+			log.Printf("E! [outputs.socket_writer] could not serialize metric "+
+				"%s: %s", m.Name(), serr)
+			unserializable = append(unserializable, i)
+			continue
+		}
+		if !s.datagram() {
+			stream = append(stream, b...)
+			streamed = append(streamed, i)
+			continue
+		}
+		s.conn.SetWriteDeadline(time.Now().Add(s.Timeout.Duration))
+		if _, err = s.conn.Write(b); err != nil {
+			break
+		}
+		accepted = append(accepted, i)
+	}
+	if err == nil && len(stream) != 0 {
+		s.conn.SetWriteDeadline(time.Now().Add(s.Timeout.Duration))
+		if _, err = s.conn.Write(stream); err == nil {
+			accepted = streamed
+		}
+	}
+
+	if err != nil {
+		// the connection is opened again at the next write
+		s.Close()
+		if len(accepted) == 0 && len(unserializable) == 0 {
+			return err
+		}
+		return &PartialWriteError{
+			Err:            err,
+			Accepted:       accepted,
+			Unserializable: unserializable,
+		}
+	}
+	if len(unserializable) != 0 {
+		return &PartialWriteError{
+			Err:            fmt.Errorf("metrics the serializer failed on"),
+			Accepted:       accepted,
+			Unserializable: unserializable,
+		}
+	}
+	return nil
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// statsdReplacer replaces the characters of the statsd line format.
+var statsdReplacer = strings.NewReplacer(":", "_", "|", "_", "@", "_",
+	" ", "_", "\n", "_", "#", "_", ",", "_")
+
+// Statsd repeats the selected metrics to a statsd server. Counters are sent
+// as statsd counters of their increase since the previous write, every other
+// numeric field as a gauge.
+type Statsd struct {
+	Address        string
+	Protocol       string
+	Timeout        Duration
+	Measurements   []string
+	Fields         []string
+	BucketTemplate string `toml:"bucket_template"`
+	DogstatsdTags  bool   `toml:"dogstatsd_tags"`
+	MaxPacketSize  int    `toml:"max_packet_size"`
+
+	bucket  *template.Template
+	conn    net.Conn
+	binding *LocalBinding
+
+	// counter values at the previous write, by bucket and tags
+	lastCounters map[string]float64
+}
+
+// statsdBucket is the data the bucket_template is rendered with.
+type statsdBucket struct {
+	Measurement string
+	Field       string
+	Tags        map[string]string
+}
+
+var statsdSampleConfig = `
+  ## Address of the statsd server
+  address = "127.0.0.1:8125"
+  ## Protocol, "udp" or "tcp"
+  # protocol = "udp"
+  ## Timeout to connect and write
+  # timeout = "5s"
+
+  ## Measurements and fields to repeat, as globs. If empty, all are sent.
+  # measurements = ["cpu", "mem"]
+  # fields = ["usage_*", "used_percent"]
+
+  ## Template of the bucket names, see text/template. It is given the
+  ## .Measurement and .Field names and the .Tags of the metric.
+  # bucket_template = "{{.Measurement}}.{{.Field}}"
+  # bucket_template = "telegraf.{{.Tags.host}}.{{.Measurement}}.{{.Field}}"
+  ## Append the tags in the DogStatsD format
+  # dogstatsd_tags = false
+
+  ## Largest UDP packet sent, lines are batched up to this size
+  # max_packet_size = 1432
+`
+
+func (_ *Statsd) Description() string {
+	return "Repeat metrics to a statsd server"
+}
+
+func (_ *Statsd) SampleConfig() string {
+	return statsdSampleConfig
+}
+
+// Init checks the protocol and parses the bucket template.
+func (s *Statsd) Init() error {
+	switch s.Protocol {
+	case "udp", "tcp":
+	default:
+		return fmt.Errorf("unknown protocol %q, must be udp or tcp",
+			s.Protocol)
+	}
+
+	t, err := template.New("bucket").Option("missingkey=zero").
+		Parse(s.BucketTemplate)
+	if err != nil {
+		return fmt.Errorf("error parsing bucket_template: %s", err)
+	}
+	s.bucket = t
+	return nil
+}
+
+func (s *Statsd) Connect() error {
+	s.lastCounters = make(map[string]float64)
+	return s.dial()
+}
+
+func (s *Statsd) dial() error {
+	ctx := context.Background()
+	if s.Timeout.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.Timeout.Duration)
+		defer cancel()
+	}
+	conn, err := s.binding.DialContext(ctx, s.Protocol, s.Address)
+	if err != nil {
+		return err
+	}
+	s.conn = conn
+	return nil
+}
+
+func (s *Statsd) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+// SetLocalBinding binds the socket to the local address.
+func (s *Statsd) SetLocalBinding(b *LocalBinding) {
+	s.binding = b
+}
+
+func (s *Statsd) Write(metrics []Metric) error {
+	var lines []string
+	var bucket bytes.Buffer
+	for _, m := range metrics {
+		if len(s.Measurements) != 0 && !matchesAny(m.Name(), s.Measurements) {
+			continue
+		}
+		tags := m.Tags()
+		// the counters are told apart by their tags, whether they are sent
+		// or not
+		tagged := statsdTags(tags)
+		suffix := ""
+		if s.DogstatsdTags {
+			suffix = tagged
+		}
+
+		for field, value := range m.Fields() {
+			if len(s.Fields) != 0 && !matchesAny(field, s.Fields) {
+				continue
+			}
+			v, ok := statsdValue(value)
+			if !ok {
+				continue
+			}
+
+			bucket.Reset()
+			err := s.bucket.Execute(&bucket, &statsdBucket{
+				Measurement: m.Name(),
+				Field:       field,
+				Tags:        tags,
+			})
+			if err != nil {
+				log.Printf("E! Could not render statsd bucket of %s.%s: %s",
+					m.Name(), field, err)
+				continue
+			}
+			name := statsdReplacer.Replace(bucket.String())
+
+			kind := "g"
+			if m.Type() == Counter {
+				key := name + tagged
+				last, ok := s.lastCounters[key]
+				s.lastCounters[key] = v
+				// the first value and resets have no increase to report
+				if !ok || v < last {
+					continue
+				}
+				kind, v = "c", v-last
+			}
+			lines = append(lines, name+":"+
+				strconv.FormatFloat(v, 'f', -1, 64)+"|"+kind+suffix)
+		}
+	}
+	return s.send(lines)
+}
+
+// send writes the lines, batched into packets of at most max_packet_size
+// bytes over udp.
+func (s *Statsd) send(lines []string) error {
+	if len(lines) == 0 {
+		return nil
+	}
+	if s.conn == nil {
+		if err := s.dial(); err != nil {
+			return err
+		}
+	}
+
+	var packet bytes.Buffer
+	flush := func() error {
+		if packet.Len() == 0 {
+			return nil
+		}
+		if s.Timeout.Duration > 0 {
+			s.conn.SetWriteDeadline(time.Now().Add(s.Timeout.Duration))
+		}
+		_, err := s.conn.Write(packet.Bytes())
+		packet.Reset()
+		if err != nil {
+			// reconnect on the next write
+			s.Close()
+		}
+		return err
+	}
+
+	for _, line := range lines {
+		if s.Protocol == "udp" && packet.Len() > 0 &&
+			packet.Len()+len(line)+1 > s.MaxPacketSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		packet.WriteString(line)
+		packet.WriteByte('\n')
+	}
+	return flush()
+}
+
+// statsdTags formats the tags as a DogStatsD "|#key:value,..." suffix.
+func statsdTags(tags map[string]string) string {
+	if len(tags) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = statsdReplacer.Replace(k) + ":" + statsdReplacer.Replace(tags[k])
+	}
+	return "|#" + strings.Join(pairs, ",")
+}
+
+func statsdValue(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case float64:
+		return v, true
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+func newStatsd() *Statsd {
+	return &Statsd{
+		Protocol:       "udp",
+		Timeout:        Duration{Duration: time.Second * 5},
+		BucketTemplate: "{{.Measurement}}.{{.Field}}",
+		MaxPacketSize:  1432,
+	}
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const pagerDutyEventsURL = "https://events.pagerduty.com/v2/enqueue"
+
+// webhookSeverities maps the Nagios return codes of the state field to the
+// PagerDuty severities.
+var webhookSeverities = map[int16]string{
+	0: "info",
+	1: "warning",
+	2: "critical",
+	3: "error",
+}
+
+// Webhook pages from the edge: it posts alert events to the PagerDuty
+// Events API v2 or to any webhook, with a templated payload. This tree has
+// no alerting stage, the events are made from the metrics holding the state
+// field, such as the nagios_state metrics of the nagios data format, using
+// the Nagios return codes.
+//
+// An alert is triggered when the state of its dedup key leaves OK or changes
+// and resolved when it gets back to OK, unchanged states are not posted
+// again. Failed posts are retried with an exponential backoff before the
+// write fails, and the metrics go back to the output buffer.
+type Webhook struct {
+	URL             string
+	Format          string
+	RoutingKey      string `toml:"routing_key"`
+	DedupKey        string `toml:"dedup_key"`
+	Summary         string
+	PayloadTemplate string `toml:"payload_template"`
+	ContentType     string `toml:"content_type"`
+	Headers         map[string]string
+	StateField      string `toml:"state_field"`
+	Timeout         Duration
+	MaxRetries      int      `toml:"max_retries"`
+	RetryBackoff    Duration `toml:"retry_backoff"`
+
+	// Path to CA file
+	SSLCA string `toml:"ssl_ca"`
+	// Path to host cert file
+	SSLCert string `toml:"ssl_cert"`
+	// Path to cert key file
+	SSLKey string `toml:"ssl_key"`
+	// Use SSL but skip chain & host verification
+	InsecureSkipVerify bool
+
+	client   *http.Client
+	token    string
+	kerberos *Kerberos
+	binding  *LocalBinding
+	dedupKey *template.Template
+	summary  *template.Template
+	payload  *template.Template
+
+	// state of the triggered alerts, by dedup key
+	open map[string]int16
+
+	// counts events refused by the endpoint
+	droppedRejected Stat
+}
+
+// webhookEvent is the data the templates are rendered with.
+type webhookEvent struct {
+	Action      string
+	DedupKey    string
+	Summary     string
+	Severity    string
+	State       int16
+	Measurement string
+	Tags        map[string]string
+	Fields      map[string]interface{}
+	Time        time.Time
+}
+
+var webhookSampleConfig = `
+  ## Format of the events, "pagerduty" for the PagerDuty Events API v2 or
+  ## "template" to post payload_template.
+  # format = "pagerduty"
+  ## Endpoint, defaults to the PagerDuty Events API with the pagerduty format
+  # url = "https://events.pagerduty.com/v2/enqueue"
+  ## Integration key of the PagerDuty service
+  # routing_key = ""
+
+  ## Field holding the Nagios return code of the alert, metrics without it
+  ## are not sent.
+  # state_field = "state"
+
+  ## Templates, see text/template. They are given the .Measurement name, the
+  ## .Tags, .Fields and .Time of the metric, its .State and .Severity. The
+  ## payload_template is also given the .Action, "trigger" or "resolve", the
+  ## .DedupKey and the .Summary.
+  # dedup_key = "{{.Tags.host}}:{{.Measurement}}"
+  # summary = "{{.Measurement}} on {{.Tags.host}}: {{.Fields.service_output}}"
+  # payload_template = '{"text": "{{.Action}} {{.Summary}}"}'
+  # content_type = "application/json"
+  # headers = {"Authorization" = "Bearer s3cret"}
+
+  ## Timeout of each post, the number of retries of failed posts and the
+  ## delay before the first retry, doubled on each retry.
+  # timeout = "5s"
+  # max_retries = 3
+  # retry_backoff = "1s"
+
+  ## Optional SSL Config
+  # ssl_ca = "/etc/telegraf/ca.pem"
+  # ssl_cert = "/etc/telegraf/cert.pem"
+  # ssl_key = "/etc/telegraf/key.pem"
+  ## Use SSL but skip chain & host verification
+  # insecure_skip_verify = false
+`
+
+func (_ *Webhook) Description() string {
+	return "Post alert events to PagerDuty or a webhook"
+}
+
+func (_ *Webhook) SampleConfig() string {
+	return webhookSampleConfig
+}
+
+// Init checks the format and parses the templates, the url being checked on
+// connect as it may be discovered.
+func (w *Webhook) Init() error {
+	switch w.Format {
+	case "pagerduty":
+		if w.RoutingKey == "" {
+			return fmt.Errorf("routing_key is required with the pagerduty format")
+		}
+	case "template":
+	default:
+		return fmt.Errorf("unknown format %q, must be pagerduty or template",
+			w.Format)
+	}
+
+	var err error
+	if w.dedupKey, err = parseTemplate("dedup_key", w.DedupKey); err != nil {
+		return err
+	}
+	if w.summary, err = parseTemplate("summary", w.Summary); err != nil {
+		return err
+	}
+	if w.payload, err = parseTemplate("payload_template", w.PayloadTemplate); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (w *Webhook) Connect() error {
+	if w.URL == "" {
+		if w.Format == "template" {
+			return fmt.Errorf("url is required with the template format")
+		}
+		w.URL = pagerDutyEventsURL
+	}
+	// the incidents opened are kept when reconnecting to new endpoints
+	if w.open == nil {
+		w.open = make(map[string]int16)
+	}
+
+	tlsConfig, err := GetTLSConfig(
+		w.SSLCert, w.SSLKey, w.SSLCA, w.InsecureSkipVerify)
+	if err != nil {
+		return err
+	}
+	w.client = &http.Client{
+		Timeout: w.Timeout.Duration,
+		Transport: NewWriteStatsTransport("webhook", w.kerberos.Transport(&http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: tlsConfig,
+			DialContext:     w.binding.DialContext,
+		})),
+	}
+	return nil
+}
+
+func (w *Webhook) Close() error {
+	return nil
+}
+
+// SetEndpoints posts the events to the first endpoint, the preferred one.
+func (w *Webhook) SetEndpoints(endpoints []string) {
+	w.URL = endpoints[0]
+}
+
+// SetToken sets the bearer token of the posts, replacing the Authorization
+// header.
+func (w *Webhook) SetToken(token string) {
+	w.token = token
+}
+
+// SetKerberos authenticates the posts with Kerberos.
+func (w *Webhook) SetKerberos(k *Kerberos) {
+	w.kerberos = k
+}
+
+// SetLocalBinding binds the connections to the webhook to the local address.
+func (w *Webhook) SetLocalBinding(b *LocalBinding) {
+	w.binding = b
+}
+
+// SetDroppedRejected counts the events the endpoint refused in the rejected
+// counter of the running output.
+func (w *Webhook) SetDroppedRejected(dropped Stat) {
+	w.droppedRejected = dropped
+}
+
+func (w *Webhook) Write(metrics []Metric) error {
+	for _, m := range metrics {
+		state, ok := nagiosState(m.Fields()[w.StateField])
+		if !ok {
+			continue
+		}
+		event := &webhookEvent{
+			Severity:    webhookSeverities[state],
+			State:       state,
+			Measurement: m.Name(),
+			Tags:        m.Tags(),
+			Fields:      m.Fields(),
+			Time:        m.Time(),
+		}
+		var err error
+		if event.DedupKey, err = renderTemplate(w.dedupKey, event); err != nil {
+			log.Printf("E! Could not render the dedup key of %s: %s",
+				m.Name(), err)
+			continue
+		}
+
+		last, triggered := w.open[event.DedupKey]
+		switch {
+		case state != 0 && (!triggered || last != state) && inMaintenance(m):
+			// planned work, triggered if still failing once it is over
+			continue
+		case state != 0 && (!triggered || last != state):
+			event.Action = "trigger"
+		case state == 0 && triggered:
+			event.Action = "resolve"
+		default:
+			continue
+		}
+		if event.Summary, err = renderTemplate(w.summary, event); err != nil {
+			log.Printf("E! Could not render the summary of %s: %s",
+				m.Name(), err)
+			continue
+		}
+
+		if err := w.post(event); err != nil {
+			return err
+		}
+		if state == 0 {
+			delete(w.open, event.DedupKey)
+		} else {
+			w.open[event.DedupKey] = state
+		}
+	}
+	return nil
+}
+
+func renderTemplate(t *template.Template, data interface{}) (string, error) {
+	var buf bytes.Buffer
+	err := t.Execute(&buf, data)
+	return buf.String(), err
+}
+
+// post sends the event, retrying with an exponential backoff on network
+// errors, throttling and server errors.
+func (w *Webhook) post(event *webhookEvent) error {
+	body, contentType, err := w.body(event)
+	if err != nil {
+		return err
+	}
+
+	backoff := w.RetryBackoff.Duration
+	for attempt := 0; ; attempt++ {
+		retry, err := w.postOnce(body, contentType)
+		if err == nil {
+			return nil
+		}
+		if !retry {
+			// the endpoint will never accept this event
+			log.Printf("E! Dropping %s event %s: %s",
+				event.Action, event.DedupKey, err)
+			w.droppedRejected.Incr(1)
+			return nil
+		}
+		if attempt >= w.MaxRetries {
+			return err
+		}
+		log.Printf("W! Could not post %s event %s, retrying in %s: %s",
+			event.Action, event.DedupKey, backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func (w *Webhook) body(event *webhookEvent) ([]byte, string, error) {
+	if w.Format == "template" {
+		payload, err := renderTemplate(w.payload, event)
+		return []byte(payload), w.ContentType, err
+	}
+
+	pd := map[string]interface{}{
+		"routing_key":  w.RoutingKey,
+		"event_action": event.Action,
+		"dedup_key":    event.DedupKey,
+	}
+	if event.Action == "trigger" {
+		pd["payload"] = map[string]interface{}{
+			"summary":        event.Summary,
+			"source":         event.Tags["host"],
+			"severity":       event.Severity,
+			"timestamp":      event.Time.UTC().Format(time.RFC3339),
+			"class":          event.Measurement,
+			"custom_details": event.Fields,
+		}
+	}
+	body, err := json.Marshal(pd)
+	return body, "application/json", err
+}
+
+// postOnce posts the body once, returning whether a failure may succeed on
+// a retry.
+func (w *Webhook) postOnce(body []byte, contentType string) (bool, error) {
+	req, err := http.NewRequest("POST", w.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	for k, v := range w.Headers {
+		req.Header.Set(k, v)
+	}
+	if w.token != "" {
+		req.Header.Set("Authorization", "Bearer "+w.token)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	respBody, _ := ioutil.ReadAll(resp.Body)
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	err = fmt.Errorf("%s returned %s: %s", w.URL, resp.Status,
+		strings.TrimSpace(string(respBody)))
+	retry := resp.StatusCode == http.StatusTooManyRequests ||
+		resp.StatusCode >= 500
+	return retry, err
+}
+
+func newWebhook() *Webhook {
+	return &Webhook{
+		Format:       "pagerduty",
+		DedupKey:     "{{.Tags.host}}:{{.Measurement}}",
+		Summary:      "{{.Measurement}} on {{.Tags.host}}: {{.Fields.service_output}}",
+		ContentType:  "application/json",
+		StateField:   "state",
+		Timeout:      Duration{Duration: time.Second * 5},
+		MaxRetries:   3,
+		RetryBackoff: Duration{Duration: time.Second},
+	}
+}
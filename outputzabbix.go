@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"text/template"
+	"time"
+)
+
+// zabbixHeader starts every message of the Zabbix sender protocol, followed
+// by the length of the JSON payload as a little endian uint64.
+var zabbixHeader = []byte("ZBXD\x01")
+
+// zabbixProcessed parses the "info" of a sender data response.
+var zabbixProcessed = regexp.MustCompile(`processed: (\d+); failed: (\d+)`)
+
+// Zabbix sends metrics to a Zabbix server or proxy as trapper items with the
+// sender protocol. Each field is sent as an item, whose key is rendered from
+// key_template, of the host named by the host tag.
+type Zabbix struct {
+	Address     string
+	Timeout     Duration
+	KeyTemplate string `toml:"key_template"`
+	HostTag     string `toml:"host_tag"`
+	Host        string
+
+	keyTemplate *template.Template
+	binding     *LocalBinding
+
+	// counts items the server refused and that will not be retried
+	droppedRejected Stat
+}
+
+// zabbixKey is the data the key_template is rendered with.
+type zabbixKey struct {
+	Measurement string
+	Field       string
+	Tags        map[string]string
+}
+
+type zabbixItem struct {
+	Host  string `json:"host"`
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	Clock int64  `json:"clock"`
+	NS    int    `json:"ns"`
+}
+
+type zabbixRequest struct {
+	Request string        `json:"request"`
+	Data    []*zabbixItem `json:"data"`
+	Clock   int64         `json:"clock"`
+	NS      int           `json:"ns"`
+}
+
+type zabbixResponse struct {
+	Response string `json:"response"`
+	Info     string `json:"info"`
+}
+
+var zabbixSampleConfig = `
+  ## Address of the Zabbix server or proxy trapper port
+  address = "127.0.0.1:10051"
+  ## Timeout for each write
+  # timeout = "5s"
+
+  ## Template of the item keys, see text/template. It is given the
+  ## .Measurement and .Field names and the .Tags of the metric.
+  # key_template = "telegraf.{{.Measurement}}.{{.Field}}"
+  # key_template = "telegraf.{{.Measurement}}.{{.Field}}[{{.Tags.cpu}}]"
+
+  ## Tag holding the name of the Zabbix host, metrics without it are sent
+  ## for host, which defaults to the local hostname.
+  # host_tag = "host"
+  # host = ""
+`
+
+func (_ *Zabbix) Description() string {
+	return "Send metrics to Zabbix as trapper items"
+}
+
+func (_ *Zabbix) SampleConfig() string {
+	return zabbixSampleConfig
+}
+
+// Init checks the address and parses the template, defaulting the host to
+// the hostname.
+func (z *Zabbix) Init() error {
+	if z.Address == "" {
+		return fmt.Errorf("address is required")
+	}
+
+	t, err := template.New("key").Option("missingkey=zero").Parse(z.KeyTemplate)
+	if err != nil {
+		return fmt.Errorf("error parsing key_template: %s", err)
+	}
+	z.keyTemplate = t
+
+	if z.Host == "" {
+		if z.Host, err = os.Hostname(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (z *Zabbix) Connect() error {
+	return nil
+}
+
+func (z *Zabbix) Close() error {
+	return nil
+}
+
+// SetLocalBinding binds the connections to the server to the local address.
+func (z *Zabbix) SetLocalBinding(b *LocalBinding) {
+	z.binding = b
+}
+
+// SetDroppedRejected counts the items the server refused in the rejected
+// counter of the running output.
+func (z *Zabbix) SetDroppedRejected(dropped Stat) {
+	z.droppedRejected = dropped
+}
+
+func (z *Zabbix) Write(metrics []Metric) error {
+	now := time.Now()
+	req := zabbixRequest{
+		Request: "sender data",
+		Clock:   now.Unix(),
+		NS:      now.Nanosecond(),
+	}
+
+	var key bytes.Buffer
+	for _, m := range metrics {
+		tags := m.Tags()
+		host, ok := tags[z.HostTag]
+		if !ok {
+			host = z.Host
+		}
+		t := m.Time()
+
+		for field, value := range m.Fields() {
+			key.Reset()
+			err := z.keyTemplate.Execute(&key, &zabbixKey{
+				Measurement: m.Name(),
+				Field:       field,
+				Tags:        tags,
+			})
+			if err != nil {
+				log.Printf("E! Could not render Zabbix key of %s.%s: %s",
+					m.Name(), field, err)
+				continue
+			}
+			req.Data = append(req.Data, &zabbixItem{
+				Host:  host,
+				Key:   key.String(),
+				Value: zabbixValue(value),
+				Clock: t.Unix(),
+				NS:    t.Nanosecond(),
+			})
+		}
+	}
+	if len(req.Data) == 0 {
+		return nil
+	}
+
+	resp, err := z.send(&req)
+	if err != nil {
+		return err
+	}
+	if resp.Response != "success" {
+		return fmt.Errorf("Zabbix server returned %q: %s",
+			resp.Response, resp.Info)
+	}
+
+	// items the server does not know about or cannot convert are failed,
+	// sending them again would fail the same way.
+	if match := zabbixProcessed.FindStringSubmatch(resp.Info); match != nil {
+		if failed, _ := strconv.ParseInt(match[2], 10, 64); failed > 0 {
+			log.Printf("W! Zabbix server refused %d of %d items: %s",
+				failed, len(req.Data), resp.Info)
+			z.droppedRejected.Incr(failed)
+		}
+	}
+	return nil
+}
+
+func (z *Zabbix) send(req *zabbixRequest) (*zabbixResponse, error) {
+	payload, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	if z.Timeout.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, z.Timeout.Duration)
+		defer cancel()
+	}
+	conn, err := z.binding.DialContext(ctx, "tcp", z.Address)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	msg := make([]byte, len(zabbixHeader)+8, len(zabbixHeader)+8+len(payload))
+	copy(msg, zabbixHeader)
+	binary.LittleEndian.PutUint64(msg[len(zabbixHeader):], uint64(len(payload)))
+	msg = append(msg, payload...)
+	if _, err := conn.Write(msg); err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, len(zabbixHeader)+8)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return nil, fmt.Errorf("error reading Zabbix response: %s", err)
+	}
+	if !bytes.Equal(header[:len(zabbixHeader)], zabbixHeader) {
+		return nil, fmt.Errorf("invalid Zabbix response header %q", header)
+	}
+	size := binary.LittleEndian.Uint64(header[len(zabbixHeader):])
+	body, err := ioutil.ReadAll(io.LimitReader(conn, int64(size)))
+	if err != nil {
+		return nil, fmt.Errorf("error reading Zabbix response: %s", err)
+	}
+
+	var resp zabbixResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("invalid Zabbix response: %s", err)
+	}
+	return &resp, nil
+}
+
+func zabbixValue(value interface{}) string {
+	switch v := value.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		if v {
+			return "1"
+		}
+		return "0"
+	}
+	return fmt.Sprint(value)
+}
+
+func newZabbix() *Zabbix {
+	return &Zabbix{
+		Timeout:     Duration{Duration: time.Second * 5},
+		KeyTemplate: "telegraf.{{.Measurement}}.{{.Field}}",
+		HostTag:     "host",
+	}
+}
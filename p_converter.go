@@ -0,0 +1,242 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+)
+
+type Conversion struct {
+	Measurement []string `toml:"measurement"`
+	Tag         []string `toml:"tag"`
+	String      []string `toml:"string"`
+	Integer     []string `toml:"integer"`
+	Boolean     []string `toml:"boolean"`
+	Float       []string `toml:"float"`
+}
+
+type Converter struct {
+	Tags   *Conversion `toml:"tags"`
+	Fields *Conversion `toml:"fields"`
+}
+
+var converterSampleConfig = `
+  ## Tags to convert
+  ##
+  ## The table key determines the target type, and the array of key-values
+  ## select the keys to convert.  The array may contain globs.
+  ##   <target-type> = [<tag-key>...]
+  [processors.converter.tags]
+    string = []
+    integer = []
+    boolean = []
+    float = []
+
+  ## Fields to convert
+  ##
+  ## The table key determines the target type, and the array of key-values
+  ## select the keys to convert.  The array may contain globs.
+  ##   <target-type> = [<field-key>...]
+  [processors.converter.fields]
+    tag = []
+    string = []
+    integer = []
+    boolean = []
+    float = []
+`
+
+func (c *Converter) SampleConfig() string {
+	return converterSampleConfig
+}
+
+func (c *Converter) Description() string {
+	return "Convert values to another metric value type"
+}
+
+// Init checks the globs of the conversions.
+func (c *Converter) Init() error {
+	for kind, conv := range map[string]*Conversion{"tags": c.Tags, "fields": c.Fields} {
+		if conv == nil {
+			continue
+		}
+		for option, globs := range map[string][]string{
+			"measurement": conv.Measurement,
+			"tag":         conv.Tag,
+			"string":      conv.String,
+			"integer":     conv.Integer,
+			"boolean":     conv.Boolean,
+			"float":       conv.Float,
+		} {
+			if err := checkGlobs(kind+"."+option, globs); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (c *Converter) Apply(in ...Metric) []Metric {
+	out := make([]Metric, 0, len(in))
+	for _, point := range in {
+		name := point.Name()
+		tags := point.Tags()
+		fields := point.Fields()
+
+		if c.Tags != nil {
+			for key, value := range tags {
+				switch {
+				case matchesAny(key, c.Tags.Measurement):
+					name = value
+				case matchesAny(key, c.Tags.String):
+					fields[key] = value
+				case matchesAny(key, c.Tags.Integer):
+					if v, ok := toInteger(value); ok {
+						fields[key] = v
+					} else {
+						logConvertError(point, "tag", key, "integer")
+						continue
+					}
+				case matchesAny(key, c.Tags.Boolean):
+					if v, ok := toBool(value); ok {
+						fields[key] = v
+					} else {
+						logConvertError(point, "tag", key, "boolean")
+						continue
+					}
+				case matchesAny(key, c.Tags.Float):
+					if v, ok := toFloat(value); ok {
+						fields[key] = v
+					} else {
+						logConvertError(point, "tag", key, "float")
+						continue
+					}
+				default:
+					continue
+				}
+				delete(tags, key)
+			}
+		}
+
+		if c.Fields != nil {
+			for key, value := range fields {
+				switch {
+				case matchesAny(key, c.Fields.Measurement):
+					name = toString(value)
+					delete(fields, key)
+				case matchesAny(key, c.Fields.Tag):
+					tags[key] = toString(value)
+					delete(fields, key)
+				case matchesAny(key, c.Fields.String):
+					fields[key] = toString(value)
+				case matchesAny(key, c.Fields.Integer):
+					if v, ok := toInteger(value); ok {
+						fields[key] = v
+					} else {
+						logConvertError(point, "field", key, "integer")
+						delete(fields, key)
+					}
+				case matchesAny(key, c.Fields.Boolean):
+					if v, ok := toBool(value); ok {
+						fields[key] = v
+					} else {
+						logConvertError(point, "field", key, "boolean")
+						delete(fields, key)
+					}
+				case matchesAny(key, c.Fields.Float):
+					if v, ok := toFloat(value); ok {
+						fields[key] = v
+					} else {
+						logConvertError(point, "field", key, "float")
+						delete(fields, key)
+					}
+				}
+			}
+		}
+
+		m, err := rebuild(point, name, tags, fields, point.Time())
+		if err != nil {
+			log.Printf("E! [processors.converter] could not rebuild metric %s: %s",
+				point.Name(), err)
+			continue
+		}
+		out = append(out, m)
+	}
+	return out
+}
+
+func logConvertError(m Metric, kind, key, target string) {
+	log.Printf("D! [processors.converter] could not convert %s %s of %s to %s",
+		kind, key, m.Name(), target)
+}
+
+func toInteger(v interface{}) (int64, bool) {
+	switch value := v.(type) {
+	case int64:
+		return value, true
+	case float64:
+		if value < float64(math.MinInt64) || value > float64(math.MaxInt64) {
+			return 0, false
+		}
+		return int64(value), true
+	case bool:
+		if value {
+			return 1, true
+		}
+		return 0, true
+	case string:
+		result, err := strconv.ParseInt(value, 0, 64)
+		if err != nil {
+			f, ok := toFloat(value)
+			if !ok {
+				return 0, false
+			}
+			return toInteger(f)
+		}
+		return result, true
+	}
+	return 0, false
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch value := v.(type) {
+	case int64:
+		return float64(value), true
+	case float64:
+		return value, true
+	case bool:
+		if value {
+			return 1.0, true
+		}
+		return 0.0, true
+	case string:
+		result, err := strconv.ParseFloat(value, 64)
+		return result, err == nil
+	}
+	return 0.0, false
+}
+
+func toBool(v interface{}) (bool, bool) {
+	switch value := v.(type) {
+	case int64:
+		return value != 0, true
+	case float64:
+		return value != 0, true
+	case bool:
+		return value, true
+	case string:
+		result, err := strconv.ParseBool(value)
+		return result, err == nil
+	}
+	return false, false
+}
+
+func toString(v interface{}) string {
+	switch value := v.(type) {
+	case string:
+		return value
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	}
+	return fmt.Sprintf("%v", v)
+}
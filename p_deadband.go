@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Deadband only passes the metrics of a series the selected fields of which
+// changed by more than a threshold since the last metric passed, or after
+// max age, so that the slowly changing gauges, such as the use of the disks,
+// are not written at every interval. The metrics held back are counted as
+// dropped by the processor.
+type Deadband struct {
+	Measurements []string
+	Fields       []string
+	// Absolute is the change of a numeric field passing the metric, and
+	// Relative the change in percent of the last value passed. With both
+	// set either passes it, and with none any change does.
+	Absolute float64
+	Relative float64
+	// MaxAge passes a metric of the series when the last metric passed is
+	// older, whatever the change, 0 holding them back as long as they do
+	// not change.
+	MaxAge Duration `toml:"max_age"`
+
+	// the last metric passed of each series, by the hash of its name and
+	// tags
+	series map[uint64]*deadbandSeries
+	pruned time.Time
+}
+
+// deadbandSeries is the last metric passed of a series.
+type deadbandSeries struct {
+	t      time.Time
+	values map[string]interface{}
+}
+
+func NewDeadband() *Deadband {
+	return &Deadband{
+		MaxAge: Duration{Duration: 5 * time.Minute},
+		series: make(map[uint64]*deadbandSeries),
+	}
+}
+
+var deadbandSampleConfig = `
+  ## Globs of the measurements held back, and of their fields compared
+  measurements = ["disk", "zfs_pool"]
+  fields = ["used_percent", "free"]
+
+  ## Change of a field passing the metric, absolute, or relative in percent
+  ## of the value last passed. With both set either passes it, and with none
+  ## any change does.
+  # absolute = 0.0
+  relative = 1.0
+
+  ## A metric is passed when the last one passed is older than max_age,
+  ## whatever the change, "0s" holding them back until they change
+  # max_age = "5m"
+`
+
+func (d *Deadband) SampleConfig() string {
+	return deadbandSampleConfig
+}
+
+func (d *Deadband) Description() string {
+	return "Only pass the metrics the fields of which changed beyond a threshold"
+}
+
+func (d *Deadband) Init() error {
+	if len(d.Fields) == 0 {
+		return fmt.Errorf("no fields selected")
+	}
+	if d.Absolute < 0 || d.Relative < 0 {
+		return fmt.Errorf("the thresholds cannot be negative")
+	}
+	if err := checkGlobs("measurements", d.Measurements); err != nil {
+		return err
+	}
+	return checkGlobs("fields", d.Fields)
+}
+
+func (d *Deadband) Apply(in ...Metric) []Metric {
+	now := time.Now()
+	if d.MaxAge.Duration > 0 && now.Sub(d.pruned) > d.MaxAge.Duration {
+		for id, s := range d.series {
+			if now.Sub(s.t) > d.MaxAge.Duration {
+				delete(d.series, id)
+			}
+		}
+		d.pruned = now
+	}
+
+	out := make([]Metric, 0, len(in))
+	for _, point := range in {
+		if len(d.Measurements) != 0 && !matchesAny(point.Name(), d.Measurements) {
+			out = append(out, point)
+			continue
+		}
+		values := make(map[string]interface{})
+		for key, value := range point.Fields() {
+			if matchesAny(key, d.Fields) {
+				values[key] = value
+			}
+		}
+		if len(values) == 0 {
+			out = append(out, point)
+			continue
+		}
+
+		id := point.HashID()
+		t := point.Time()
+		last, ok := d.series[id]
+		if ok && !d.changed(last.values, values) &&
+			(d.MaxAge.Duration <= 0 || t.Sub(last.t) < d.MaxAge.Duration) {
+			continue
+		}
+		d.series[id] = &deadbandSeries{t: t, values: values}
+		out = append(out, point)
+	}
+	return out
+}
+
+// changed returns true if one of the values changed beyond the thresholds
+// from the last ones, or is new or gone.
+func (d *Deadband) changed(last, values map[string]interface{}) bool {
+	if len(last) != len(values) {
+		return true
+	}
+	for key, value := range values {
+		prev, ok := last[key]
+		if !ok {
+			return true
+		}
+		p, pok := deltaValue(prev)
+		v, vok := deltaValue(value)
+		if !pok || !vok {
+			if prev != value {
+				return true
+			}
+			continue
+		}
+		diff := math.Abs(v - p)
+		if d.Absolute == 0 && d.Relative == 0 {
+			if diff != 0 {
+				return true
+			}
+			continue
+		}
+		if d.Absolute > 0 && diff > d.Absolute {
+			return true
+		}
+		if d.Relative > 0 && (p == 0 && diff != 0 ||
+			p != 0 && diff/math.Abs(p)*100 > d.Relative) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// Delta adds to the metrics the change of the selected fields since the
+// previous metric of the same series, as <field>_delta, for the counters to
+// reach the outputs that cannot derive them at query time. The first metric
+// of a series only records its values.
+type Delta struct {
+	Measurements []string
+	Fields       []string
+	// Mode is "difference", the new value minus the previous one, or
+	// "percent", the difference in percent of the previous value.
+	Mode string
+	// PerSecond divides the difference by the seconds elapsed between the
+	// two metrics.
+	PerSecond bool `toml:"per_second"`
+	// DropNegative leaves out the negative differences, which a counter
+	// restarting from zero has.
+	DropNegative bool `toml:"drop_negative"`
+	// MaxAge is how long the previous values of a series are kept, a
+	// metric after a longer gap only recording its values, 0 keeping them
+	// forever.
+	MaxAge Duration `toml:"max_age"`
+
+	// the previous values of each series, by the hash of its name and tags
+	series map[uint64]*deltaSeries
+	pruned time.Time
+}
+
+// deltaSeries is the previous values of the selected fields of a series.
+type deltaSeries struct {
+	t      time.Time
+	values map[string]interface{}
+}
+
+func NewDelta() *Delta {
+	return &Delta{
+		Mode:   "difference",
+		MaxAge: Duration{Duration: 10 * time.Minute},
+		series: make(map[uint64]*deltaSeries),
+	}
+}
+
+var deltaSampleConfig = `
+  ## Globs of the measurements and of their fields the delta is added for,
+  ## as <field>_delta
+  # measurements = ["*"]
+  fields = ["*_count", "*_bytes"]
+
+  ## "difference" for the new value minus the previous one, or "percent" for
+  ## the difference in percent of the previous value
+  # mode = "difference"
+  ## Divide the difference by the seconds elapsed since the previous metric
+  # per_second = false
+  ## Leave out the negative differences, of the counters restarting at zero
+  # drop_negative = false
+
+  ## The previous values of a series are forgotten after max_age, the next
+  ## metric of the series getting no delta, "0s" keeping them forever
+  # max_age = "10m"
+`
+
+func (d *Delta) SampleConfig() string {
+	return deltaSampleConfig
+}
+
+func (d *Delta) Description() string {
+	return "Add the change of fields since the previous metric of the series"
+}
+
+func (d *Delta) Init() error {
+	switch d.Mode {
+	case "difference", "percent":
+	default:
+		return fmt.Errorf("invalid mode %q, expected difference or percent",
+			d.Mode)
+	}
+	if len(d.Fields) == 0 {
+		return fmt.Errorf("no fields selected")
+	}
+	if err := checkGlobs("measurements", d.Measurements); err != nil {
+		return err
+	}
+	return checkGlobs("fields", d.Fields)
+}
+
+func (d *Delta) Apply(in ...Metric) []Metric {
+	now := time.Now()
+	if d.MaxAge.Duration > 0 && now.Sub(d.pruned) > d.MaxAge.Duration {
+		d.prune(now)
+	}
+
+	out := make([]Metric, 0, len(in))
+	for _, point := range in {
+		if len(d.Measurements) != 0 && !matchesAny(point.Name(), d.Measurements) {
+			out = append(out, point)
+			continue
+		}
+		fields := point.Fields()
+		values := make(map[string]interface{})
+		for key, value := range fields {
+			if matchesAny(key, d.Fields) {
+				if _, ok := deltaValue(value); ok {
+					values[key] = value
+				}
+			}
+		}
+		if len(values) == 0 {
+			out = append(out, point)
+			continue
+		}
+
+		id := point.HashID()
+		prev, ok := d.series[id]
+		t := point.Time()
+		if ok && !t.After(prev.t) {
+			// out of order, or the same metric again
+			out = append(out, point)
+			continue
+		}
+		d.series[id] = &deltaSeries{t: t, values: values}
+		if !ok || d.MaxAge.Duration > 0 && t.Sub(prev.t) > d.MaxAge.Duration {
+			out = append(out, point)
+			continue
+		}
+
+		added := false
+		for key, value := range values {
+			if delta, ok := d.delta(prev.values[key], value, t.Sub(prev.t)); ok {
+				fields[key+"_delta"] = delta
+				added = true
+			}
+		}
+		if !added {
+			out = append(out, point)
+			continue
+		}
+		m, err := rebuild(point, point.Name(), point.Tags(), fields, t)
+		if err != nil {
+			log.Printf("E! [processors.delta] could not rebuild metric %s: %s",
+				point.Name(), err)
+			out = append(out, point)
+			continue
+		}
+		out = append(out, m)
+	}
+	return out
+}
+
+// delta returns the change from the previous value to the value over the
+// elapsed time, the difference of integers being kept an integer, or false
+// if there is none to add.
+func (d *Delta) delta(prev, value interface{}, elapsed time.Duration) (interface{}, bool) {
+	p, ok := deltaValue(prev)
+	if !ok {
+		return nil, false
+	}
+	v, _ := deltaValue(value)
+	diff := v - p
+	if d.DropNegative && diff < 0 {
+		return nil, false
+	}
+
+	if d.Mode == "percent" {
+		if p == 0 {
+			return nil, false
+		}
+		diff = diff / p * 100
+	} else if pi, ok := prev.(int64); ok && !d.PerSecond {
+		if vi, ok := value.(int64); ok {
+			return vi - pi, true
+		}
+	}
+	if d.PerSecond {
+		diff /= elapsed.Seconds()
+	}
+	return diff, true
+}
+
+// deltaValue returns the value of a numeric field.
+func deltaValue(v interface{}) (float64, bool) {
+	switch value := v.(type) {
+	case int64:
+		return float64(value), true
+	case uint64:
+		return float64(value), true
+	case float64:
+		return value, true
+	}
+	return 0, false
+}
+
+// prune forgets the series without metrics for longer than max age.
+func (d *Delta) prune(now time.Time) {
+	for id, s := range d.series {
+		if now.Sub(s.t) > d.MaxAge.Duration {
+			delete(d.series, id)
+		}
+	}
+	d.pruned = now
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// DiskInfoRunner runs diskinfo(1M) or format(1M), given as the command, with
+// the given arguments and returns its output. It can be replaced with a
+// mocked function for unit test purposes.
+type DiskInfoRunner func(timeout time.Duration, command string, args ...string) ([]byte, error)
+
+// deviceNamePattern matches the cXtWWNdN names of the disks addressed by
+// their WWN, capturing the part without the controller number, which changes
+// when the disks are reconfigured.
+var deviceNamePattern = regexp.MustCompile(`\bc[0-9]+(t[0-9A-Fa-f]{16,}d[0-9]+)`)
+
+// DeviceAlias rewrites the long cXtWWNdN disk names in tag values to short
+// aliases that survive a reconfiguration, the chassis bay the disk sits in
+// as reported by diskinfo or format, so that its series stay the same.
+type DeviceAlias struct {
+	Tags            []string
+	Aliases         map[string]string
+	RefreshInterval Duration `toml:"refresh_interval"`
+	Timeout         Duration
+
+	// aliases by the name of the disk without its controller
+	aliases   map[string]string
+	refreshed time.Time
+
+	runDiskInfo DiskInfoRunner
+}
+
+func NewDeviceAlias() *DeviceAlias {
+	return &DeviceAlias{
+		Tags:            []string{"device", "disk", "name"},
+		RefreshInterval: Duration{Duration: time.Hour},
+		Timeout:         Duration{Duration: 10 * time.Second},
+		runDiskInfo:     diskInfoRunner,
+	}
+}
+
+var deviceAliasSampleConfig = `
+  ## Tags whose values are rewritten, a cXtWWNdN disk name anywhere in them,
+  ## such as in /dev/dsk/c0t5000CCA0123ABCDEd0s0, is replaced by its alias
+  # tags = ["device", "disk", "name"]
+  ## The aliases are the chassis bays of the disks, such as SYS/HDD0, read
+  ## from diskinfo, or from format where diskinfo is not available, and
+  ## refreshed at this interval.
+  # refresh_interval = "1h"
+  ## Timeout for the diskinfo and format commands to complete
+  # timeout = "10s"
+  ## Aliases of disks, by name, that take precedence over the bays
+  # [processors.device_alias.aliases]
+  #   c0t5000CCA0123ABCDEd0 = "boot0"
+`
+
+func (d *DeviceAlias) SampleConfig() string {
+	return deviceAliasSampleConfig
+}
+
+func (d *DeviceAlias) Description() string {
+	return "Rewrite cXtWWNdN disk names in tags to stable aliases"
+}
+
+// Init checks the configured aliases and keys them by the disk name without
+// its controller.
+func (d *DeviceAlias) Init() error {
+	aliases := make(map[string]string, len(d.Aliases))
+	for name, alias := range d.Aliases {
+		m := deviceNamePattern.FindStringSubmatch(name)
+		if m == nil || m[0] != name {
+			return fmt.Errorf("%q is not a cXtWWNdN disk name", name)
+		}
+		aliases[strings.ToUpper(m[1])] = alias
+	}
+	d.Aliases = aliases
+	return nil
+}
+
+func (d *DeviceAlias) Apply(in ...Metric) []Metric {
+	if time.Since(d.refreshed) >= d.RefreshInterval.Duration {
+		d.refresh()
+	}
+
+	out := make([]Metric, 0, len(in))
+	for _, point := range in {
+		tags := point.Tags()
+		changed := false
+		for _, key := range d.Tags {
+			value, ok := tags[key]
+			if !ok {
+				continue
+			}
+			alias := deviceNamePattern.ReplaceAllStringFunc(value, d.alias)
+			if alias != value {
+				tags[key] = alias
+				changed = true
+			}
+		}
+		if !changed {
+			out = append(out, point)
+			continue
+		}
+
+		m, err := rebuild(point, point.Name(), tags, point.Fields(), point.Time())
+		if err != nil {
+			log.Printf("E! [processors.device_alias] could not rebuild metric %s: %s",
+				point.Name(), err)
+			out = append(out, point)
+			continue
+		}
+		out = append(out, m)
+	}
+	return out
+}
+
+// alias returns the alias of the disk name, or the name if it has none.
+func (d *DeviceAlias) alias(name string) string {
+	key := strings.ToUpper(deviceNamePattern.FindStringSubmatch(name)[1])
+	if alias, ok := d.Aliases[key]; ok {
+		return alias
+	}
+	if alias, ok := d.aliases[key]; ok {
+		return alias
+	}
+	return name
+}
+
+// refresh rebuilds the aliases from diskinfo, or from format if diskinfo
+// fails. On error the aliases from the last refresh are kept.
+func (d *DeviceAlias) refresh() {
+	d.refreshed = time.Now()
+	run := d.runDiskInfo
+	if run == nil {
+		run = diskInfoRunner
+	}
+
+	out, err := run(d.Timeout.Duration, "diskinfo", "-o", "Dc")
+	if err == nil {
+		d.aliases = parseDiskInfo(out)
+		return
+	}
+	out, ferr := run(d.Timeout.Duration, "format")
+	if ferr != nil {
+		log.Printf("E! [processors.device_alias] could not list the disks: "+
+			"diskinfo: %s, format: %s", err, ferr)
+		return
+	}
+	d.aliases = parseFormatDisks(out)
+}
+
+// parseDiskInfo reads the bays of the disks from the output of
+// "diskinfo -o Dc", the lines of the chassis path and the disk name:
+//
+//	/dev/chassis/SYS/HDD0/disk      c0t5000CCA0123ABCDEd0
+func parseDiskInfo(out []byte) map[string]string {
+	aliases := make(map[string]string)
+	for _, line := range strings.Split(string(out), "\n") {
+		cols := strings.Fields(line)
+		if len(cols) != 2 {
+			continue
+		}
+		addDiskAlias(aliases, cols[1], cols[0])
+	}
+	return aliases
+}
+
+// parseFormatDisks reads the bays of the disks from the disk selections
+// listed by format, each disk name followed by its device and chassis paths:
+//
+//  0. c0t5000CCA0123ABCDEd0 <HITACHI-H109060SESUN600G-A690 cyl 46873 ...>
+//     /scsi_vhci/disk@g5000cca0123abcde
+//     /dev/chassis/SYS/HDD0/disk
+func parseFormatDisks(out []byte) map[string]string {
+	aliases := make(map[string]string)
+	disk := ""
+	for _, line := range strings.Split(string(out), "\n") {
+		cols := strings.Fields(line)
+		if len(cols) >= 2 && strings.HasSuffix(cols[0], ".") {
+			disk = cols[1]
+			continue
+		}
+		if len(cols) == 1 && disk != "" {
+			addDiskAlias(aliases, disk, cols[0])
+		}
+	}
+	return aliases
+}
+
+// addDiskAlias sets the bay in the chassis path as the alias of the disk,
+// ignoring the disks not named cXtWWNdN and the paths not in /dev/chassis.
+func addDiskAlias(aliases map[string]string, disk, path string) {
+	m := deviceNamePattern.FindStringSubmatch(disk)
+	if m == nil || m[0] != disk || !strings.HasPrefix(path, "/dev/chassis/") {
+		return
+	}
+	bay := strings.TrimSuffix(strings.TrimPrefix(path, "/dev/chassis/"), "/disk")
+	aliases[strings.ToUpper(m[1])] = bay
+}
+
+func diskInfoRunner(timeout time.Duration, command string, args ...string) ([]byte, error) {
+	bin, err := exec.LookPath(command)
+	if err != nil {
+		return nil, err
+	}
+	c := exec.Command(bin, args...)
+	if command == "format" {
+		// format lists the disks and then waits for a selection
+		c.Stdin = strings.NewReader("")
+	}
+	return CombinedOutputTimeout(c, timeout)
+}
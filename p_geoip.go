@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// geoipCacheSize bounds the addresses the lookups of which are cached, the
+// cache being cleared when it is full.
+const geoipCacheSize = 10000
+
+// GeoIP adds to the metrics the country and the autonomous system of the IP
+// addresses of their tags, looked up in local MaxMind DB databases such as
+// GeoLite2-Country and GeoLite2-ASN. The databases are loaded again when
+// they are updated.
+type GeoIP struct {
+	Databases     []string
+	Tags          []string
+	CheckInterval Duration `toml:"check_interval"`
+
+	readers []*mmdbReader
+	loaded  map[string]os.FileInfo
+	checked time.Time
+	// the tags found for the addresses
+	cache map[string]map[string]string
+}
+
+func NewGeoIP() *GeoIP {
+	return &GeoIP{
+		CheckInterval: Duration{Duration: time.Hour},
+	}
+}
+
+var geoipSampleConfig = `
+  ## MaxMind DB databases the addresses are looked up in, the country being
+  ## taken from a Country or City database and the autonomous system from
+  ## an ASN database
+  databases = [
+    "/var/lib/GeoIP/GeoLite2-Country.mmdb",
+    "/var/lib/GeoIP/GeoLite2-ASN.mmdb",
+  ]
+  ## Tags holding IP addresses, to which the <tag>_country tag of the ISO
+  ## code of the country, and the <tag>_asn and <tag>_as_org tags of the
+  ## autonomous system are added
+  tags = ["remote_addr"]
+
+  ## Interval the databases are checked for updates at, and loaded again
+  # check_interval = "1h"
+`
+
+func (g *GeoIP) SampleConfig() string {
+	return geoipSampleConfig
+}
+
+func (g *GeoIP) Description() string {
+	return "Add the country and autonomous system of IP address tags"
+}
+
+func (g *GeoIP) Init() error {
+	if len(g.Databases) == 0 {
+		return fmt.Errorf("no databases configured")
+	}
+	if len(g.Tags) == 0 {
+		return fmt.Errorf("no tags configured")
+	}
+	return g.load()
+}
+
+func (g *GeoIP) Apply(in ...Metric) []Metric {
+	if time.Since(g.checked) >= g.CheckInterval.Duration {
+		g.reload()
+	}
+
+	out := make([]Metric, 0, len(in))
+	for _, point := range in {
+		tags := point.Tags()
+		changed := false
+		for _, tag := range g.Tags {
+			addr, ok := tags[tag]
+			if !ok {
+				continue
+			}
+			for suffix, value := range g.lookup(addr) {
+				if _, ok := tags[tag+suffix]; !ok {
+					tags[tag+suffix] = value
+					changed = true
+				}
+			}
+		}
+		if !changed {
+			out = append(out, point)
+			continue
+		}
+
+		m, err := rebuild(point, point.Name(), tags, point.Fields(), point.Time())
+		if err != nil {
+			log.Printf("E! [processors.geoip] could not rebuild metric %s: %s",
+				point.Name(), err)
+			out = append(out, point)
+			continue
+		}
+		out = append(out, m)
+	}
+	return out
+}
+
+// lookup returns the tags of the address by suffix, none if it is not an
+// address or not found.
+func (g *GeoIP) lookup(addr string) map[string]string {
+	if tags, ok := g.cache[addr]; ok {
+		return tags
+	}
+	tags := make(map[string]string)
+	if ip := net.ParseIP(addr); ip != nil {
+		for _, r := range g.readers {
+			record, err := r.lookup(ip)
+			if err != nil {
+				log.Printf("D! [processors.geoip] could not look up %s in %s: %s",
+					addr, r.databaseType, err)
+				continue
+			}
+			geoipTags(record, tags)
+		}
+	}
+	if len(g.cache) >= geoipCacheSize {
+		g.cache = make(map[string]map[string]string)
+	}
+	g.cache[addr] = tags
+	return tags
+}
+
+// geoipTags adds the tags of the record of a Country, City or ASN database
+// that are not set yet.
+func geoipTags(record map[string]interface{}, tags map[string]string) {
+	if _, ok := tags["_country"]; !ok {
+		for _, key := range []string{"country", "registered_country"} {
+			country, _ := record[key].(map[string]interface{})
+			if code, ok := country["iso_code"].(string); ok {
+				tags["_country"] = code
+				break
+			}
+		}
+	}
+	if _, ok := tags["_asn"]; !ok {
+		if asn, ok := mmdbUint(record["autonomous_system_number"]); ok {
+			tags["_asn"] = strconv.FormatUint(uint64(asn), 10)
+		}
+	}
+	if _, ok := tags["_as_org"]; !ok {
+		if org, ok := record["autonomous_system_organization"].(string); ok {
+			tags["_as_org"] = org
+		}
+	}
+}
+
+// reload loads the databases again if one of them was updated since they
+// were loaded. On error the databases loaded before are kept.
+func (g *GeoIP) reload() {
+	g.checked = time.Now()
+	changed := false
+	for _, path := range g.Databases {
+		info, err := os.Stat(path)
+		prev := g.loaded[path]
+		if err != nil || prev == nil || !info.ModTime().Equal(prev.ModTime()) ||
+			info.Size() != prev.Size() {
+			changed = true
+			break
+		}
+	}
+	if !changed {
+		return
+	}
+	if err := g.load(); err != nil {
+		log.Printf("E! [processors.geoip] could not load the databases, "+
+			"keeping the ones loaded before: %s", err)
+		return
+	}
+	log.Printf("I! [processors.geoip] loaded the updated databases")
+}
+
+// load reads the databases.
+func (g *GeoIP) load() error {
+	g.checked = time.Now()
+	var readers []*mmdbReader
+	loaded := make(map[string]os.FileInfo)
+	for _, path := range g.Databases {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		r, err := openMMDB(path)
+		if err != nil {
+			return err
+		}
+		readers = append(readers, r)
+		loaded[path] = info
+	}
+	g.readers = readers
+	g.loaded = loaded
+	g.cache = make(map[string]map[string]string)
+	return nil
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net"
+)
+
+// mmdbMetadataMarker starts the metadata section at the end of a MaxMind DB
+// file.
+var mmdbMetadataMarker = []byte("\xAB\xCD\xEFMaxMind.com")
+
+// mmdbMaxDepth bounds the nesting of the decoded values, against the
+// pointers of a corrupt file looping.
+const mmdbMaxDepth = 32
+
+// mmdbReader looks up IP addresses in a MaxMind DB file, the format of the
+// GeoIP2 and GeoLite2 databases, read into memory: a binary search tree on
+// the bits of the addresses, the leaves of which point to the records of
+// the data section.
+type mmdbReader struct {
+	tree         []byte
+	data         mmdbDecoder
+	nodeCount    uint
+	recordSize   uint
+	ipVersion    uint
+	databaseType string
+	// node the IPv4 addresses start from, in an IPv6 tree
+	ipv4Start uint
+}
+
+// openMMDB reads the MaxMind DB file at path.
+func openMMDB(path string) (*mmdbReader, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	i := bytes.LastIndex(buf, mmdbMetadataMarker)
+	if i < 0 {
+		return nil, fmt.Errorf("%s is not a MaxMind DB file", path)
+	}
+	meta := mmdbDecoder{buf: buf[i+len(mmdbMetadataMarker):]}
+	v, _, err := meta.decode(0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid metadata in %s: %s", path, err)
+	}
+	fields, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid metadata in %s", path)
+	}
+
+	r := &mmdbReader{}
+	r.nodeCount, _ = mmdbUint(fields["node_count"])
+	r.recordSize, _ = mmdbUint(fields["record_size"])
+	r.ipVersion, _ = mmdbUint(fields["ip_version"])
+	r.databaseType, _ = fields["database_type"].(string)
+	switch r.recordSize {
+	case 24, 28, 32:
+	default:
+		return nil, fmt.Errorf("unsupported record size %d in %s",
+			r.recordSize, path)
+	}
+	treeSize := r.recordSize * 2 / 8 * r.nodeCount
+	// the data section follows the tree and 16 null bytes
+	if treeSize+16 > uint(i) {
+		return nil, fmt.Errorf("invalid search tree size in %s", path)
+	}
+	r.tree = buf[:treeSize]
+	r.data = mmdbDecoder{buf: buf[treeSize+16 : i]}
+
+	if r.ipVersion == 6 {
+		node := uint(0)
+		for i := 0; i < 96 && node < r.nodeCount; i++ {
+			node = r.record(node, 0)
+		}
+		r.ipv4Start = node
+	}
+	return r, nil
+}
+
+// record returns the left (0) or right (1) record of the node.
+func (r *mmdbReader) record(node, bit uint) uint {
+	b := r.tree
+	switch r.recordSize {
+	case 24:
+		off := node*6 + bit*3
+		return uint(b[off])<<16 | uint(b[off+1])<<8 | uint(b[off+2])
+	case 28:
+		off := node * 7
+		if bit == 0 {
+			return uint(b[off+3]&0xF0)<<20 | uint(b[off])<<16 |
+				uint(b[off+1])<<8 | uint(b[off+2])
+		}
+		return uint(b[off+3]&0x0F)<<24 | uint(b[off+4])<<16 |
+			uint(b[off+5])<<8 | uint(b[off+6])
+	default:
+		off := node*8 + bit*4
+		return uint(binary.BigEndian.Uint32(b[off:]))
+	}
+}
+
+// lookup returns the record of the address, or nil if the database has
+// none.
+func (r *mmdbReader) lookup(ip net.IP) (map[string]interface{}, error) {
+	bits := ip.To4()
+	node := uint(0)
+	if bits != nil {
+		node = r.ipv4Start
+	} else {
+		if r.ipVersion == 4 {
+			return nil, nil
+		}
+		bits = ip.To16()
+	}
+	for i := 0; i < len(bits)*8 && node < r.nodeCount; i++ {
+		bit := uint(bits[i/8]>>(7-uint(i%8))) & 1
+		node = r.record(node, bit)
+	}
+	if node == r.nodeCount {
+		return nil, nil
+	}
+	if node < r.nodeCount {
+		return nil, fmt.Errorf("invalid search tree")
+	}
+
+	v, _, err := r.data.decode(node-r.nodeCount-16, 0)
+	if err != nil {
+		return nil, err
+	}
+	record, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("record of %s is not a map", ip)
+	}
+	return record, nil
+}
+
+// mmdbDecoder decodes the values of the data section of a MaxMind DB.
+type mmdbDecoder struct {
+	buf []byte
+}
+
+// the types of the data section
+const (
+	mmdbExtended = 0
+	mmdbPointer  = 1
+	mmdbString   = 2
+	mmdbDouble   = 3
+	mmdbBytes    = 4
+	mmdbUint16   = 5
+	mmdbUint32   = 6
+	mmdbMap      = 7
+	mmdbInt32    = 8
+	mmdbUint64   = 9
+	mmdbUint128  = 10
+	mmdbArray    = 11
+	mmdbBool     = 14
+	mmdbFloat    = 15
+)
+
+// next returns the n bytes at the offset.
+func (d *mmdbDecoder) next(offset, n uint) ([]byte, error) {
+	if offset+n > uint(len(d.buf)) || offset+n < offset {
+		return nil, fmt.Errorf("value at %d beyond the data section", offset)
+	}
+	return d.buf[offset : offset+n], nil
+}
+
+// decode returns the value at the offset, and the offset following it.
+func (d *mmdbDecoder) decode(offset uint, depth int) (interface{}, uint, error) {
+	if depth > mmdbMaxDepth {
+		return nil, 0, fmt.Errorf("values nested too deep")
+	}
+	b, err := d.next(offset, 1)
+	if err != nil {
+		return nil, 0, err
+	}
+	ctrl := b[0]
+	offset++
+	typ := uint(ctrl >> 5)
+
+	if typ == mmdbPointer {
+		n := uint(ctrl>>3&0x3) + 1
+		b, err := d.next(offset, n)
+		if err != nil {
+			return nil, 0, err
+		}
+		ptr := uint(0)
+		if n < 4 {
+			ptr = uint(ctrl & 0x7)
+		}
+		for _, c := range b {
+			ptr = ptr<<8 | uint(c)
+		}
+		switch n {
+		case 2:
+			ptr += 2048
+		case 3:
+			ptr += 526336
+		}
+		v, _, err := d.decode(ptr, depth+1)
+		return v, offset + n, err
+	}
+
+	if typ == mmdbExtended {
+		if b, err = d.next(offset, 1); err != nil {
+			return nil, 0, err
+		}
+		typ = 7 + uint(b[0])
+		offset++
+	}
+	size := uint(ctrl & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		if b, err = d.next(offset, n); err != nil {
+			return nil, 0, err
+		}
+		ext := uint(0)
+		for _, c := range b {
+			ext = ext<<8 | uint(c)
+		}
+		size = []uint{29, 285, 65821}[n-1] + ext
+		offset += n
+	}
+
+	switch typ {
+	case mmdbMap:
+		m := make(map[string]interface{}, size)
+		for i := uint(0); i < size; i++ {
+			var k, v interface{}
+			if k, offset, err = d.decode(offset, depth+1); err != nil {
+				return nil, 0, err
+			}
+			if v, offset, err = d.decode(offset, depth+1); err != nil {
+				return nil, 0, err
+			}
+			key, ok := k.(string)
+			if !ok {
+				return nil, 0, fmt.Errorf("map key at %d is not a string", offset)
+			}
+			m[key] = v
+		}
+		return m, offset, nil
+	case mmdbArray:
+		a := make([]interface{}, 0, size)
+		for i := uint(0); i < size; i++ {
+			var v interface{}
+			if v, offset, err = d.decode(offset, depth+1); err != nil {
+				return nil, 0, err
+			}
+			a = append(a, v)
+		}
+		return a, offset, nil
+	case mmdbBool:
+		return size != 0, offset, nil
+	}
+
+	if b, err = d.next(offset, size); err != nil {
+		return nil, 0, err
+	}
+	offset += size
+	switch typ {
+	case mmdbString:
+		return string(b), offset, nil
+	case mmdbBytes, mmdbUint128:
+		return append([]byte{}, b...), offset, nil
+	case mmdbDouble:
+		if size != 8 {
+			return nil, 0, fmt.Errorf("invalid double size %d", size)
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), offset, nil
+	case mmdbFloat:
+		if size != 4 {
+			return nil, 0, fmt.Errorf("invalid float size %d", size)
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), offset, nil
+	case mmdbUint16, mmdbUint32, mmdbUint64:
+		v := uint64(0)
+		for _, c := range b {
+			v = v<<8 | uint64(c)
+		}
+		return v, offset, nil
+	case mmdbInt32:
+		v := uint32(0)
+		for _, c := range b {
+			v = v<<8 | uint32(c)
+		}
+		if size == 4 {
+			return int64(int32(v)), offset, nil
+		}
+		return int64(v), offset, nil
+	}
+	return nil, 0, fmt.Errorf("unknown type %d at %d", typ, offset)
+}
+
+// mmdbUint returns the value of an unsigned integer of the data section.
+func mmdbUint(v interface{}) (uint, bool) {
+	u, ok := v.(uint64)
+	return uint(u), ok
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Lookup adds to the metrics the tags of their key in mapping files, such as
+// the owner team of a host or the application of a zpool exported from a
+// CMDB. The files are loaded again when they change.
+type Lookup struct {
+	Files []string
+	// Format is "csv", "json", or empty for the extension of each file.
+	Format string
+	// KeyTags are the tags the values of which, joined with ":", are the key
+	// looked up.
+	KeyTags      []string `toml:"key_tags"`
+	Measurements []string
+	// Overwrite replaces the tags the metrics already have.
+	Overwrite     bool
+	CheckInterval Duration `toml:"check_interval"`
+
+	// the tags of each key, and the files they were loaded from by path,
+	// with their modification time and size
+	table   map[string]map[string]string
+	loaded  map[string]os.FileInfo
+	checked time.Time
+}
+
+func NewLookup() *Lookup {
+	return &Lookup{
+		CheckInterval: Duration{Duration: time.Minute},
+	}
+}
+
+var lookupSampleConfig = `
+  ## Mapping files, of the tags to add by key. A CSV file has a header row,
+  ## the first column the key and the others the tags:
+  ##   host,team,environment
+  ##   web1,frontend,production
+  ## A JSON file has an object of the tags of each key:
+  ##   {"web1": {"team": "frontend", "environment": "production"}}
+  ## The keys of the later files take precedence.
+  files = ["/etc/telegraf/cmdb.csv"]
+  ## "csv" or "json", guessed from the extension of each file by default
+  # format = ""
+
+  ## Tags the values of which, joined with ":", are the key looked up, such
+  ## as "web1:rpool" with ["host", "pool"]
+  key_tags = ["host"]
+  ## Globs of the measurements enriched, all by default
+  # measurements = []
+  ## Replace the tags the metrics already have
+  # overwrite = false
+
+  ## Interval the files are checked for changes at, and loaded again
+  # check_interval = "1m"
+`
+
+func (l *Lookup) SampleConfig() string {
+	return lookupSampleConfig
+}
+
+func (l *Lookup) Description() string {
+	return "Add tags looked up in CSV or JSON mapping files"
+}
+
+func (l *Lookup) Init() error {
+	if len(l.Files) == 0 {
+		return fmt.Errorf("no files configured")
+	}
+	if len(l.KeyTags) == 0 {
+		return fmt.Errorf("no key_tags configured")
+	}
+	switch l.Format {
+	case "", "csv", "json":
+	default:
+		return fmt.Errorf("invalid format %q, expected csv or json", l.Format)
+	}
+	if err := checkGlobs("measurements", l.Measurements); err != nil {
+		return err
+	}
+	return l.load()
+}
+
+func (l *Lookup) Apply(in ...Metric) []Metric {
+	if time.Since(l.checked) >= l.CheckInterval.Duration {
+		l.reload()
+	}
+
+	out := make([]Metric, 0, len(in))
+	for _, point := range in {
+		if len(l.Measurements) != 0 && !matchesAny(point.Name(), l.Measurements) {
+			out = append(out, point)
+			continue
+		}
+		tags := point.Tags()
+		key, ok := l.key(tags)
+		if !ok {
+			out = append(out, point)
+			continue
+		}
+		changed := false
+		for tag, value := range l.table[key] {
+			if current, ok := tags[tag]; ok && (!l.Overwrite || current == value) {
+				continue
+			}
+			tags[tag] = value
+			changed = true
+		}
+		if !changed {
+			out = append(out, point)
+			continue
+		}
+
+		m, err := rebuild(point, point.Name(), tags, point.Fields(), point.Time())
+		if err != nil {
+			log.Printf("E! [processors.lookup] could not rebuild metric %s: %s",
+				point.Name(), err)
+			out = append(out, point)
+			continue
+		}
+		out = append(out, m)
+	}
+	return out
+}
+
+// key returns the key of the tags, or false if one of the key tags is
+// missing.
+func (l *Lookup) key(tags map[string]string) (string, bool) {
+	values := make([]string, 0, len(l.KeyTags))
+	for _, tag := range l.KeyTags {
+		value, ok := tags[tag]
+		if !ok {
+			return "", false
+		}
+		values = append(values, value)
+	}
+	return strings.Join(values, ":"), true
+}
+
+// reload loads the files again if one of them changed since they were
+// loaded. On error the tags loaded before are kept.
+func (l *Lookup) reload() {
+	l.checked = time.Now()
+	changed := false
+	for _, path := range l.Files {
+		info, err := os.Stat(path)
+		prev := l.loaded[path]
+		if err != nil || prev == nil || !info.ModTime().Equal(prev.ModTime()) ||
+			info.Size() != prev.Size() {
+			changed = true
+			break
+		}
+	}
+	if !changed {
+		return
+	}
+	if err := l.load(); err != nil {
+		log.Printf("E! [processors.lookup] could not load the mapping files, "+
+			"keeping the tags loaded before: %s", err)
+		return
+	}
+	log.Printf("I! [processors.lookup] loaded %d keys from %s", len(l.table),
+		strings.Join(l.Files, ", "))
+}
+
+// load reads the files, the keys of the later ones taking precedence.
+func (l *Lookup) load() error {
+	l.checked = time.Now()
+	table := make(map[string]map[string]string)
+	loaded := make(map[string]os.FileInfo)
+	for _, path := range l.Files {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		info, err := f.Stat()
+		if err == nil {
+			format := l.Format
+			if format == "" {
+				format = strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")
+			}
+			switch format {
+			case "csv":
+				err = readLookupCSV(f, table)
+			case "json":
+				err = readLookupJSON(f, table)
+			default:
+				err = fmt.Errorf("unknown format, set it for the files not " +
+					"named *.csv or *.json")
+			}
+		}
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %s", path, err)
+		}
+		loaded[path] = info
+	}
+	l.table = table
+	l.loaded = loaded
+	return nil
+}
+
+// readLookupCSV reads the tags of the keys from a CSV file with a header
+// row naming the tags, the key in the first column. The empty cells are
+// left out.
+func readLookupCSV(f *os.File, table map[string]map[string]string) error {
+	r := csv.NewReader(f)
+	r.TrimLeadingSpace = true
+	r.Comment = '#'
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	if err != nil {
+		return err
+	}
+	if len(records) == 0 {
+		return nil
+	}
+	header := records[0]
+	for _, record := range records[1:] {
+		if record[0] == "" {
+			continue
+		}
+		tags := lookupTags(table, record[0])
+		for i := 1; i < len(record) && i < len(header); i++ {
+			if record[i] != "" {
+				tags[header[i]] = record[i]
+			}
+		}
+	}
+	return nil
+}
+
+// readLookupJSON reads the tags of the keys from a JSON object of objects.
+func readLookupJSON(f *os.File, table map[string]map[string]string) error {
+	var keys map[string]map[string]string
+	if err := json.NewDecoder(f).Decode(&keys); err != nil {
+		return err
+	}
+	for key, values := range keys {
+		tags := lookupTags(table, key)
+		for tag, value := range values {
+			tags[tag] = value
+		}
+	}
+	return nil
+}
+
+// lookupTags returns the tags of the key in the table, adding them if the
+// key is new.
+func lookupTags(table map[string]map[string]string, key string) map[string]string {
+	tags, ok := table[key]
+	if !ok {
+		tags = make(map[string]string)
+		table[key] = tags
+	}
+	return tags
+}
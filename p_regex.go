@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+)
+
+type Regex struct {
+	Tags         []converter `toml:"tags"`
+	Fields       []converter `toml:"fields"`
+	TagRename    []converter `toml:"tag_rename"`
+	MetricRename []converter `toml:"metric_rename"`
+
+	regexCache map[string]*regexp.Regexp
+}
+
+type converter struct {
+	Key         string
+	Pattern     string
+	Replacement string
+	ResultKey   string `toml:"result_key"`
+}
+
+var regexSampleConfig = `
+  ## Tag and field conversions defined in a separate sub-tables
+  # [[processors.regex.tags]]
+  #   ## Tag to change
+  #   key = "resp_code"
+  #   ## Regular expression to match on a tag value
+  #   pattern = "^(\\d)\\d\\d$"
+  #   ## Pattern for constructing a new value (${1} represents first subgroup)
+  #   replacement = "${1}xx"
+
+  # [[processors.regex.fields]]
+  #   key = "request"
+  #   ## All the power of the Go regular expressions available here
+  #   ## For example, named subgroups
+  #   pattern = "^/api(?P<method>/[\\w/]+)\\S*"
+  #   replacement = "${method}"
+  #   ## If result_key is present, a new field will be created
+  #   ## instead of changing existing field
+  #   result_key = "method"
+
+  ## Rename tag keys matching the pattern, ie, "sd0,err" -> "sd0_err"
+  # [[processors.regex.tag_rename]]
+  #   pattern = "[^a-z0-9_]"
+  #   replacement = "_"
+
+  ## Rename measurements matching the pattern
+  # [[processors.regex.metric_rename]]
+  #   pattern = "^solaris_(.*)$"
+  #   replacement = "${1}"
+`
+
+func NewRegex() *Regex {
+	return &Regex{
+		regexCache: make(map[string]*regexp.Regexp),
+	}
+}
+
+func (r *Regex) SampleConfig() string {
+	return regexSampleConfig
+}
+
+func (r *Regex) Description() string {
+	return "Transforms tag and field values, tag keys and measurement names with regex pattern"
+}
+
+// Init compiles the patterns of all the conversions.
+func (r *Regex) Init() error {
+	for _, converters := range [][]converter{r.Tags, r.Fields, r.TagRename, r.MetricRename} {
+		for _, c := range converters {
+			regex, err := regexp.Compile(c.Pattern)
+			if err != nil {
+				return fmt.Errorf("invalid pattern %q: %s", c.Pattern, err)
+			}
+			r.regexCache[c.Pattern] = regex
+		}
+	}
+	return nil
+}
+
+func (r *Regex) Apply(in ...Metric) []Metric {
+	out := make([]Metric, 0, len(in))
+	for _, point := range in {
+		name := point.Name()
+		tags := point.Tags()
+		fields := point.Fields()
+		changed := false
+
+		for _, converter := range r.MetricRename {
+			if newName, ok := r.replace(converter, name); ok && newName != name {
+				name = newName
+				changed = true
+			}
+		}
+
+		for _, converter := range r.TagRename {
+			// renamed once the keys are all seen, so that a renamed key is
+			// not visited again
+			renames := make(map[string]string)
+			for key := range tags {
+				if newKey, ok := r.replace(converter, key); ok && newKey != key {
+					renames[key] = newKey
+				}
+			}
+			for key, newKey := range renames {
+				value := tags[key]
+				delete(tags, key)
+				tags[newKey] = value
+				changed = true
+			}
+		}
+
+		for _, converter := range r.Tags {
+			if value, ok := tags[converter.Key]; ok {
+				if newValue, ok := r.replace(converter, value); ok {
+					tags[r.resultKey(converter)] = newValue
+					changed = true
+				}
+			}
+		}
+
+		for _, converter := range r.Fields {
+			if value, ok := fields[converter.Key]; ok {
+				switch value := value.(type) {
+				case string:
+					if newValue, ok := r.replace(converter, value); ok {
+						fields[r.resultKey(converter)] = newValue
+						changed = true
+					}
+				}
+			}
+		}
+
+		if !changed {
+			out = append(out, point)
+			continue
+		}
+		m, err := rebuild(point, name, tags, fields, point.Time())
+		if err != nil {
+			log.Printf("E! [processors.regex] could not rebuild metric %s: %s",
+				point.Name(), err)
+			out = append(out, point)
+			continue
+		}
+		out = append(out, m)
+	}
+	return out
+}
+
+// replace applies the converter to src, reporting false if the pattern does
+// not match.
+func (r *Regex) replace(c converter, src string) (string, bool) {
+	regex := r.regexCache[c.Pattern]
+	if !regex.MatchString(src) {
+		return src, false
+	}
+	return regex.ReplaceAllString(src, c.Replacement), true
+}
+
+func (r *Regex) resultKey(c converter) string {
+	if c.ResultKey != "" {
+		return c.ResultKey
+	}
+	return c.Key
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"log"
+)
+
+type Rename struct {
+	Replaces []Replace `toml:"replace"`
+}
+
+type Replace struct {
+	Measurement string `toml:"measurement"`
+	Tag         string `toml:"tag"`
+	Field       string `toml:"field"`
+	Dest        string `toml:"dest"`
+}
+
+var renameSampleConfig = `
+  ## Each replace table renames a single measurement, tag or field.
+  ## Replacements are applied in the order they are listed.
+  # [[processors.rename.replace]]
+  #   measurement = "network_interface_throughput"
+  #   dest = "throughput"
+  #
+  # [[processors.rename.replace]]
+  #   tag = "hostname"
+  #   dest = "host"
+  #
+  # [[processors.rename.replace]]
+  #   field = "lower"
+  #   dest = "min"
+`
+
+func (r *Rename) SampleConfig() string {
+	return renameSampleConfig
+}
+
+func (r *Rename) Description() string {
+	return "Rename measurements, tags, and fields that pass through this filter."
+}
+
+func (r *Rename) Apply(in ...Metric) []Metric {
+	out := make([]Metric, 0, len(in))
+	for _, point := range in {
+		name := point.Name()
+		tags := point.Tags()
+		fields := point.Fields()
+
+		for _, replace := range r.Replaces {
+			if replace.Dest == "" {
+				continue
+			}
+
+			if replace.Measurement != "" && name == replace.Measurement {
+				name = replace.Dest
+				continue
+			}
+
+			if replace.Tag != "" {
+				if value, ok := tags[replace.Tag]; ok {
+					delete(tags, replace.Tag)
+					tags[replace.Dest] = value
+				}
+				continue
+			}
+
+			if replace.Field != "" {
+				if value, ok := fields[replace.Field]; ok {
+					delete(fields, replace.Field)
+					fields[replace.Dest] = value
+				}
+			}
+		}
+
+		m, err := rebuild(point, name, tags, fields, point.Time())
+		if err != nil {
+			log.Printf("E! [processors.rename] could not rebuild metric %s: %s",
+				point.Name(), err)
+			out = append(out, point)
+			continue
+		}
+		out = append(out, m)
+	}
+	return out
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+)
+
+// Scrub redacts or hashes the parts of the tag and string field values
+// matching patterns, such as user names, addresses or the home directories
+// in the metrics derived from logs, before they leave the host.
+type Scrub struct {
+	Measurements []string
+	// Salt is prepended to the values hashed, for the hashes not to be
+	// reversed by hashing the likely values.
+	Salt  string
+	Rules []scrubRule `toml:"rules"`
+}
+
+// scrubRule replaces the matches of a pattern, or of its groups if it has
+// any, in the values of the selected tags and fields, all of them if none
+// are selected.
+type scrubRule struct {
+	Pattern string
+	Tags    []string
+	Fields  []string
+	// Action is "redact", replacing with the replacement, or "hash",
+	// replacing with the start of the SHA-256 of the salt and the match,
+	// which still tells the same values apart.
+	Action      string
+	Replacement string
+
+	regex *regexp.Regexp
+}
+
+func NewScrub() *Scrub {
+	return &Scrub{}
+}
+
+var scrubSampleConfig = `
+  ## Globs of the measurements scrubbed, all by default
+  # measurements = []
+  ## Prepended to the values hashed
+  # salt = ""
+
+  ## Rules applied in order to the values of the tags and string fields
+  ## matching the globs, all of them when none are set. Only the groups of
+  ## the pattern are replaced if it has any, else all of the match.
+  [[processors.scrub.rules]]
+    ## Account names in the home directories
+    pattern = "/(?:export/)?home/([^/]+)"
+    fields = ["path", "message"]
+    tags = ["path"]
+    ## "redact" replaces with the replacement, "hash" with the start of
+    ## the SHA-256 of the salt and the match
+    action = "hash"
+
+  # [[processors.scrub.rules]]
+  #   ## IPv4 addresses
+  #   pattern = "\\b\\d{1,3}(?:\\.\\d{1,3}){3}\\b"
+  #   action = "redact"
+  #   # replacement = "redacted"
+`
+
+func (s *Scrub) SampleConfig() string {
+	return scrubSampleConfig
+}
+
+func (s *Scrub) Description() string {
+	return "Redact or hash the parts of tag and field values matching patterns"
+}
+
+// Init compiles the patterns of the rules.
+func (s *Scrub) Init() error {
+	if len(s.Rules) == 0 {
+		return fmt.Errorf("no rules configured")
+	}
+	if err := checkGlobs("measurements", s.Measurements); err != nil {
+		return err
+	}
+	for i := range s.Rules {
+		rule := &s.Rules[i]
+		switch rule.Action {
+		case "":
+			rule.Action = "redact"
+		case "redact", "hash":
+		default:
+			return fmt.Errorf("invalid action %q, expected redact or hash",
+				rule.Action)
+		}
+		if rule.Action == "redact" && rule.Replacement == "" {
+			rule.Replacement = "redacted"
+		}
+		regex, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid pattern %q: %s", rule.Pattern, err)
+		}
+		rule.regex = regex
+		if err := checkGlobs("tags", rule.Tags); err != nil {
+			return err
+		}
+		if err := checkGlobs("fields", rule.Fields); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Scrub) Apply(in ...Metric) []Metric {
+	out := make([]Metric, 0, len(in))
+	for _, point := range in {
+		if len(s.Measurements) != 0 && !matchesAny(point.Name(), s.Measurements) {
+			out = append(out, point)
+			continue
+		}
+		tags := point.Tags()
+		fields := point.Fields()
+		changed := false
+		for _, rule := range s.Rules {
+			all := len(rule.Tags) == 0 && len(rule.Fields) == 0
+			for key, value := range tags {
+				if !all && !matchesAny(key, rule.Tags) {
+					continue
+				}
+				if scrubbed := s.scrub(rule, value); scrubbed != value {
+					tags[key] = scrubbed
+					changed = true
+				}
+			}
+			for key, value := range fields {
+				str, ok := value.(string)
+				if !ok || !all && !matchesAny(key, rule.Fields) {
+					continue
+				}
+				if scrubbed := s.scrub(rule, str); scrubbed != str {
+					fields[key] = scrubbed
+					changed = true
+				}
+			}
+		}
+		if !changed {
+			out = append(out, point)
+			continue
+		}
+
+		m, err := rebuild(point, point.Name(), tags, fields, point.Time())
+		if err != nil {
+			// not passing the metric on, its values not being scrubbed
+			log.Printf("E! [processors.scrub] could not rebuild metric %s, "+
+				"dropping it: %s", point.Name(), err)
+			continue
+		}
+		out = append(out, m)
+	}
+	return out
+}
+
+// scrub returns the value with the matches of the rule, or of their groups,
+// replaced.
+func (s *Scrub) scrub(rule scrubRule, value string) string {
+	matches := rule.regex.FindAllStringSubmatchIndex(value, -1)
+	if matches == nil {
+		return value
+	}
+	var b strings.Builder
+	last := 0
+	for _, match := range matches {
+		// the bounds of the groups, or of the match without any
+		bounds := match[2:]
+		if len(bounds) == 0 {
+			bounds = match[:2]
+		}
+		for i := 0; i < len(bounds); i += 2 {
+			start, end := bounds[i], bounds[i+1]
+			if start < last || end <= start {
+				// not matched, empty, or nested in a group replaced
+				continue
+			}
+			b.WriteString(value[last:start])
+			b.WriteString(s.replacement(rule, value[start:end]))
+			last = end
+		}
+	}
+	b.WriteString(value[last:])
+	return b.String()
+}
+
+// replacement returns what the match is replaced with.
+func (s *Scrub) replacement(rule scrubRule, match string) string {
+	if rule.Action == "hash" {
+		sum := sha256.Sum256([]byte(s.Salt + match))
+		return hex.EncodeToString(sum[:6])
+	}
+	return rule.Replacement
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strings"
+)
+
+// unit is a unit of measurement, the value of which in the base unit of its
+// dimension is value*factor + offset.
+type unit struct {
+	dimension string
+	factor    float64
+	offset    float64
+}
+
+// units are the units known by name. The pages are of the page size of the
+// system the agent runs on, which kstat reports the memory in.
+var units = map[string]unit{
+	"bits":  {"data", 1.0 / 8, 0},
+	"bytes": {"data", 1, 0},
+	"pages": {"data", float64(os.Getpagesize()), 0},
+	"KB":    {"data", 1e3, 0},
+	"MB":    {"data", 1e6, 0},
+	"GB":    {"data", 1e9, 0},
+	"TB":    {"data", 1e12, 0},
+	"KiB":   {"data", 1 << 10, 0},
+	"MiB":   {"data", 1 << 20, 0},
+	"GiB":   {"data", 1 << 30, 0},
+	"TiB":   {"data", 1 << 40, 0},
+
+	"ns":  {"time", 1e-9, 0},
+	"us":  {"time", 1e-6, 0},
+	"ms":  {"time", 1e-3, 0},
+	"s":   {"time", 1, 0},
+	"min": {"time", 60, 0},
+	"h":   {"time", 3600, 0},
+	"d":   {"time", 86400, 0},
+
+	"K": {"temperature", 1, 0},
+	"C": {"temperature", 1, 273.15},
+	"F": {"temperature", 5.0 / 9, 273.15 - 32*5.0/9},
+
+	"Hz":  {"frequency", 1, 0},
+	"kHz": {"frequency", 1e3, 0},
+	"MHz": {"frequency", 1e6, 0},
+	"GHz": {"frequency", 1e9, 0},
+
+	"ratio":   {"ratio", 1, 0},
+	"percent": {"ratio", 0.01, 0},
+}
+
+// Units converts numeric fields from a unit to another, such as the pages of
+// kstat to bytes, or bytes to GiB, so that the dashboards do not each
+// convert them.
+type Units struct {
+	Fields []unitConversion `toml:"fields"`
+}
+
+// unitConversion converts the fields matching the key from a unit to
+// another.
+type unitConversion struct {
+	Measurements []string
+	// Key is a glob of the fields converted.
+	Key  string
+	From string
+	To   string
+	// ResultKey is the field the converted value is set as, replacing the
+	// field converted unless KeepOriginal, "{field}" being replaced by the
+	// name of the field. The field itself is replaced by default.
+	ResultKey    string `toml:"result_key"`
+	KeepOriginal bool   `toml:"keep_original"`
+
+	factor float64
+	offset float64
+}
+
+func NewUnits() *Units {
+	return &Units{}
+}
+
+var unitsSampleConfig = `
+  ## Conversions applied in order, of the fields matching the key glob,
+  ## from a unit to another of the same dimension:
+  ##   data:        bits, bytes, pages (of the system page size),
+  ##                KB, MB, GB, TB, KiB, MiB, GiB, TiB
+  ##   time:        ns, us, ms, s, min, h, d
+  ##   temperature: K, C, F
+  ##   frequency:   Hz, kHz, MHz, GHz
+  ##   ratio:       ratio, percent
+  [[processors.units.fields]]
+    ## Globs of the measurements converted, all by default
+    measurements = ["memory"]
+    key = "*mem"
+    from = "pages"
+    to = "bytes"
+    ## Field the converted value is set as, "{field}" being the name of the
+    ## field converted, which it replaces unless keep_original
+    result_key = "{field}_bytes"
+    # keep_original = false
+
+  # [[processors.units.fields]]
+  #   key = "temperature"
+  #   from = "K"
+  #   to = "C"
+`
+
+func (u *Units) SampleConfig() string {
+	return unitsSampleConfig
+}
+
+func (u *Units) Description() string {
+	return "Convert fields from a unit to another"
+}
+
+// Init checks the units of the conversions.
+func (u *Units) Init() error {
+	if len(u.Fields) == 0 {
+		return fmt.Errorf("no conversions configured")
+	}
+	for i := range u.Fields {
+		c := &u.Fields[i]
+		if c.Key == "" {
+			return fmt.Errorf("no key in the conversion from %s to %s", c.From, c.To)
+		}
+		from, ok := units[c.From]
+		if !ok {
+			return fmt.Errorf("unknown unit %q", c.From)
+		}
+		to, ok := units[c.To]
+		if !ok {
+			return fmt.Errorf("unknown unit %q", c.To)
+		}
+		if from.dimension != to.dimension {
+			return fmt.Errorf("cannot convert %s (%s) to %s (%s)", c.From,
+				from.dimension, c.To, to.dimension)
+		}
+		if err := checkGlobs("measurements", c.Measurements); err != nil {
+			return err
+		}
+		c.factor = from.factor / to.factor
+		c.offset = (from.offset - to.offset) / to.factor
+	}
+	return nil
+}
+
+func (u *Units) Apply(in ...Metric) []Metric {
+	out := make([]Metric, 0, len(in))
+	for _, point := range in {
+		fields := point.Fields()
+		changed := false
+		for _, c := range u.Fields {
+			if len(c.Measurements) != 0 && !matchesAny(point.Name(), c.Measurements) {
+				continue
+			}
+			var keys []string
+			for key := range fields {
+				if matchesAny(key, []string{c.Key}) {
+					keys = append(keys, key)
+				}
+			}
+			for _, key := range keys {
+				converted, ok := c.convert(fields[key])
+				if !ok {
+					continue
+				}
+				result := key
+				if c.ResultKey != "" {
+					result = strings.Replace(c.ResultKey, "{field}", key, -1)
+				}
+				if result != key && !c.KeepOriginal {
+					delete(fields, key)
+				}
+				fields[result] = converted
+				changed = true
+			}
+		}
+		if !changed {
+			out = append(out, point)
+			continue
+		}
+
+		m, err := rebuild(point, point.Name(), point.Tags(), fields, point.Time())
+		if err != nil {
+			log.Printf("E! [processors.units] could not rebuild metric %s: %s",
+				point.Name(), err)
+			out = append(out, point)
+			continue
+		}
+		out = append(out, m)
+	}
+	return out
+}
+
+// convert returns the converted value of a numeric field, an integer staying
+// one when converted to a multiple of its unit, such as pages to bytes.
+func (c *unitConversion) convert(v interface{}) (interface{}, bool) {
+	value, ok := deltaValue(v)
+	if !ok {
+		return nil, false
+	}
+	converted := value*c.factor + c.offset
+	if _, ok := v.(float64); !ok && c.offset == 0 && c.factor >= 1 &&
+		c.factor == math.Trunc(c.factor) &&
+		math.Abs(converted) < math.MaxInt64 {
+		return int64(converted), true
+	}
+	return converted, true
+}
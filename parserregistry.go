@@ -32,7 +32,8 @@ type Parser interface {
 // Config is a struct that covers the data types needed for all parser types,
 // and can be used to instantiate _any_ of the parsers.
 type ParserConfig struct {
-	// Dataformat can be one of: json, influx, graphite, value, nagios
+	// Dataformat can be one of: json, influx, graphite, value, nagios, grok,
+	// csv
 	DataFormat string
 
 	// Separator only applied to Graphite data.
@@ -42,6 +43,9 @@ type ParserConfig struct {
 
 	// TagKeys only apply to JSON data
 	TagKeys []string
+	// JSONQuery only applies to JSON data, the dot-separated path of the
+	// object or array of objects to parse
+	JSONQuery string
 	// MetricName applies to JSON & value. This will be the name of the measurement.
 	MetricName string
 
@@ -52,9 +56,33 @@ type ParserConfig struct {
 	// Dataset specification for collectd
 	CollectdTypesDB []string
 
+	// GrokPatterns only apply to grok, the patterns lines are matched with
+	GrokPatterns []string
+	// GrokCustomPatterns only apply to grok, "NAME regexp" definitions of
+	// patterns, one per line
+	GrokCustomPatterns string
+
 	// DataType only applies to value, this will be the type to parse value to
 	DataType string
 
+	// The CSV options only apply to csv: the number of header rows naming
+	// the columns, concatenated, unless named by CSVColumnNames, the rows and
+	// the first columns to skip, the delimiter and comment characters, and
+	// the columns of the tags, the measurement and the timestamp, with its
+	// format.
+	CSVHeaderRowCount    int
+	CSVSkipRows          int
+	CSVSkipColumns       int
+	CSVDelimiter         string
+	CSVComment           string
+	CSVTrimSpace         bool
+	CSVColumnNames       []string
+	CSVColumnTypes       []string
+	CSVTagColumns        []string
+	CSVMeasurementColumn string
+	CSVTimestampColumn   string
+	CSVTimestampFormat   string
+
 	// DefaultTags are the default tags that will be added to all parsed metrics.
 	DefaultTags map[string]string
 }
@@ -66,12 +94,22 @@ func NewParser(config *ParserConfig) (Parser, error) {
 	switch config.DataFormat {
 	case "json":
 		parser, err = NewJSONParser(config.MetricName,
-			config.TagKeys, config.DefaultTags)
+			config.TagKeys, config.JSONQuery, config.DefaultTags)
 	case "value":
 		parser, err = NewValueParser(config.MetricName,
 			config.DataType, config.DefaultTags)
 	case "influx":
 		parser, err = NewInfluxParser()
+	case "nagios":
+		parser, err = NewNagiosParser()
+	case "graphite":
+		parser, err = NewGraphiteParser(config.Separator,
+			config.Templates, config.DefaultTags)
+	case "grok":
+		parser, err = NewGrokParser(config.MetricName, config.GrokPatterns,
+			config.GrokCustomPatterns, config.DefaultTags)
+	case "csv":
+		parser, err = NewCSVParser(config)
 	default:
 		err = fmt.Errorf("Invalid data format: %s", config.DataFormat)
 	}
@@ -81,11 +119,13 @@ func NewParser(config *ParserConfig) (Parser, error) {
 func NewJSONParser(
 	metricName string,
 	tagKeys []string,
+	query string,
 	defaultTags map[string]string,
 ) (Parser, error) {
 	parser := &JSONParser{
 		MetricName:  metricName,
 		TagKeys:     tagKeys,
+		Query:       query,
 		DefaultTags: defaultTags,
 	}
 	return parser, nil
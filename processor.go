@@ -0,0 +1,12 @@
+package main
+
+type Processor interface {
+	// SampleConfig returns the default configuration of the Processor
+	SampleConfig() string
+
+	// Description returns a one-sentence description on the Processor
+	Description() string
+
+	// Apply the filter to the given metric.
+	Apply(in ...Metric) []Metric
+}
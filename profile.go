@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+// profileFile is the file of the state directory holding the profile
+// switched to at runtime with the profile command.
+const profileFile = "profile"
+
+// profileTable is the table of a profile in a config file, which has the
+// same agent, tags and plugin tables as the config file itself.
+type profileTable struct {
+	path  string
+	table *Table
+}
+
+// addProfiles records the [profiles.<name>] tables of the config file at
+// path, a profile can be defined over several files.
+func (c *Config) addProfiles(path string, tbl *Table) error {
+	for name, val := range tbl.Fields {
+		subTable, ok := val.(*Table)
+		if !ok {
+			return fmt.Errorf("invalid profile %s", name)
+		}
+		c.Profiles[name] = append(c.Profiles[name], &profileTable{
+			path:  path,
+			table: subTable,
+		})
+	}
+	return nil
+}
+
+// ProfileNames returns the names of the profiles defined, sorted.
+func (c *Config) ProfileNames() []string {
+	names := make([]string, 0, len(c.Profiles))
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ApplyProfile applies the tables of the named profile over the config:
+// its agent settings and tags override the ones of the config, and its
+// plugins are added to the ones of the config. No profile is applied if the
+// name is empty.
+func (c *Config) ApplyProfile(name string) error {
+	if name == "" {
+		return nil
+	}
+	tables, ok := c.Profiles[name]
+	if !ok {
+		return fmt.Errorf("Unknown profile %s, the profiles defined are: %s",
+			name, strings.Join(c.ProfileNames(), " "))
+	}
+	for _, t := range tables {
+		if err := c.loadTable(t.path, t.table, false); err != nil {
+			return err
+		}
+	}
+	c.Profile = name
+	return nil
+}
+
+// SelectedProfile returns the profile to apply: the one switched to at
+// runtime if any, else the one of the flag, else the default one of the
+// agent config. The state directory must be set up.
+func SelectedProfile(c *Config, flag string) string {
+	if b, err := ioutil.ReadFile(statePath(profileFile)); err == nil {
+		name := strings.TrimSpace(string(b))
+		if _, ok := c.Profiles[name]; ok {
+			return name
+		}
+		log.Printf("W! The profile %s switched to is no longer defined, "+
+			"ignoring it", name)
+	}
+	if flag != "" {
+		return flag
+	}
+	return c.Agent.Profile
+}
+
+// SwitchProfile records the profile to apply, which is the one of the flag
+// or of the agent config again if empty, and signals the agent running
+// with the pid file of the config to reload, in order to apply it. The
+// profile is kept over restarts until switched back.
+func SwitchProfile(c *Config, name, pidfile string) error {
+	path := statePath(profileFile)
+	if name == "" {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	} else {
+		if _, ok := c.Profiles[name]; !ok {
+			return fmt.Errorf("Unknown profile %s, the profiles defined are: %s",
+				name, strings.Join(c.ProfileNames(), " "))
+		}
+		if err := ioutil.WriteFile(path, []byte(name+"\n"), 0644); err != nil {
+			return err
+		}
+	}
+
+	return signalAgent(c, pidfile, syscall.SIGHUP, "SIGHUP to apply the profile")
+}
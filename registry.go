@@ -24,7 +24,7 @@ type Serializer interface {
 // Config is a struct that covers the data types needed for all serializer types,
 // and can be used to instantiate _any_ of the serializers.
 type SerializerConfig struct {
-	// Dataformat can be one of: influx, graphite, or json
+	// Dataformat can be one of: influx, graphite, carbon2 or json
 	DataFormat string
 
 	// Prefix to add to all measurements, only supports Graphite
@@ -47,6 +47,10 @@ func NewSerializer(config *SerializerConfig) (Serializer, error) {
 		serializer, err = NewInfluxSerializer()
 	case "json":
 		serializer, err = NewJsonSerializer(config.TimestampUnits)
+	case "graphite":
+		serializer, err = NewGraphiteSerializer(config.Prefix, config.Template)
+	case "carbon2":
+		serializer, err = NewCarbon2Serializer()
 	default:
 		err = fmt.Errorf("Invalid data format: %s", config.DataFormat)
 	}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"time"
+)
+
+type RunningAggregator struct {
+	a      Aggregator
+	Config *AggregatorConfig
+
+	metrics chan Metric
+
+	periodStart time.Time
+	periodEnd   time.Time
+}
+
+func NewRunningAggregator(
+	a Aggregator,
+	conf *AggregatorConfig,
+) *RunningAggregator {
+	return &RunningAggregator{
+		a:       a,
+		Config:  conf,
+		metrics: make(chan Metric, 100),
+	}
+}
+
+// AggregatorConfig containing configuration parameters for the running
+// aggregator plugin.
+type AggregatorConfig struct {
+	Name string
+
+	DropOriginal      bool
+	NameOverride      string
+	MeasurementPrefix string
+	MeasurementSuffix string
+	Tags              map[string]string
+
+	Period time.Duration
+	Delay  time.Duration
+
+	// Measurements are the globs of the measurements the aggregator takes,
+	// all if empty.
+	Measurements []string
+}
+
+func (r *RunningAggregator) Name() string {
+	return "aggregators." + r.Config.Name
+}
+
+func (r *RunningAggregator) MakeMetric(
+	measurement string,
+	fields map[string]interface{},
+	tags map[string]string,
+	mType ValueType,
+	t time.Time,
+) Metric {
+	m := makemetric(
+		measurement,
+		fields,
+		tags,
+		r.Config.NameOverride,
+		r.Config.MeasurementPrefix,
+		r.Config.MeasurementSuffix,
+		r.Config.Tags,
+		nil,
+		false,
+		mType,
+		t,
+	)
+
+	if m != nil {
+		m.SetAggregate(true)
+	}
+
+	return m
+}
+
+// Selects returns true if the aggregator takes the metric.
+func (r *RunningAggregator) Selects(m Metric) bool {
+	return len(r.Config.Measurements) == 0 ||
+		matchesAny(m.Name(), r.Config.Measurements)
+}
+
+// Add applies the given metric to the aggregator.
+// Add returns true if the original metric should be dropped.
+func (r *RunningAggregator) Add(in Metric) bool {
+	r.metrics <- in
+	return r.Config.DropOriginal
+}
+func (r *RunningAggregator) add(in Metric) {
+	r.a.Add(in)
+}
+
+func (r *RunningAggregator) push(acc Accumulator) {
+	r.a.Push(acc)
+}
+
+func (r *RunningAggregator) reset() {
+	r.a.Reset()
+}
+
+// Run runs the running aggregator, listens for incoming metrics, and waits
+// for period ticks to tell it when to push and reset the aggregator.
+func (r *RunningAggregator) Run(
+	acc Accumulator,
+	shutdown chan struct{},
+) {
+	// The start of the period is truncated to the nearest second.
+	//
+	// Every metric then gets it's timestamp checked and is dropped if it
+	// is not within:
+	//
+	//   start < t < end + truncation + delay
+	//
+	// So if we start at now = 00:00.2 with a 10s period and 0.3s delay:
+	//   now = 00:00.2
+	//   start = 00:00
+	//   truncation = 00:00.2
+	//   end = 00:10
+	// 1st interval: 00:00 - 00:10.5
+	// 2nd interval: 00:10 - 00:20.5
+	// etc.
+	//
+	now := time.Now()
+	r.periodStart = now.Truncate(time.Second)
+	truncation := now.Sub(r.periodStart)
+	r.periodEnd = r.periodStart.Add(r.Config.Period)
+	time.Sleep(r.Config.Delay)
+	periodT := time.NewTicker(r.Config.Period)
+	defer periodT.Stop()
+	lastTick := time.Now()
+
+	for {
+		select {
+		case <-shutdown:
+			if len(r.metrics) > 0 {
+				// wait until metrics are flushed before exiting
+				continue
+			}
+			return
+		case m := <-r.metrics:
+			if m.Time().Before(r.periodStart) ||
+				m.Time().After(r.periodEnd.Add(truncation).Add(r.Config.Delay)) {
+				// the metric is outside the current aggregation period, so
+				// skip it.
+				continue
+			}
+			r.add(m)
+		case <-periodT.C:
+			r.periodStart = r.periodEnd
+			r.periodEnd = r.periodStart.Add(r.Config.Period)
+			// follow the steps of the wall clock, else the metrics would
+			// be outside of the period from then on
+			now := time.Now()
+			if step := clockStep(lastTick, now); step != 0 {
+				r.periodStart = r.periodStart.Add(step)
+				r.periodEnd = r.periodEnd.Add(step)
+			}
+			lastTick = now
+			r.push(acc)
+			r.reset()
+		}
+	}
+}
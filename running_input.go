@@ -1,8 +1,12 @@
 package main
 
 import (
-	"time"
 	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 )
 
 var GlobalMetricsGathered Stat
@@ -15,6 +19,20 @@ type RunningInput struct {
 	defaultTags map[string]string
 
 	MetricsGathered Stat
+	MetricsDropped  Stat
+	MetricsObserved Stat
+	// counts the metrics dropped by the filter of the input
+	MetricsFiltered Stat
+
+	// start of the last scheduled minute the input was gathered in
+	lastScheduled time.Time
+
+	// gathers left on probation, and the metrics gathered and the errors,
+	// by message, observed since it began
+	mu              sync.Mutex
+	probation       int
+	observedMetrics int64
+	observedErrors  map[string]int
 }
 
 func NewRunningInput(
@@ -29,6 +47,16 @@ func NewRunningInput(
 			"metrics_gathered",
 			map[string]string{"input": config.Name},
 		),
+		MetricsDropped: RegisterDropped("gather", "inputs."+config.Name, "invalid"),
+		MetricsFiltered: RegisterDropped("filter", "inputs."+config.Name,
+			"filtered"),
+		MetricsObserved: Register(
+			"gather",
+			"metrics_observed",
+			map[string]string{"input": config.Name},
+		),
+		probation:      config.Probation,
+		observedErrors: make(map[string]int),
 	}
 }
 
@@ -40,6 +68,22 @@ type InputConfig struct {
 	MeasurementSuffix string
 	Tags              map[string]string
 	Interval          time.Duration
+
+	Schedule        *Schedule
+	BlackoutWindows []*BlackoutWindow
+
+	// RestartPolicy of a ServiceInput, "on_failure" or "never", and the
+	// delay before its first restart, doubled on each further one
+	RestartPolicy string
+	RestartDelay  time.Duration
+
+	// Probation is the number of gathers the input first runs for in
+	// observe-only mode: its metrics are counted but not sent, and its
+	// errors summarized when the probation ends.
+	Probation int
+
+	// Filter selects the metrics of the input and their fields.
+	Filter Filter
 }
 
 // MakeMetric either returns a metric, or returns nil if the metric doesn't
@@ -65,15 +109,101 @@ func (r *RunningInput) MakeMetric(
 		t,
 	)
 
-	if r.trace && m != nil {
+	if m == nil {
+		r.MetricsDropped.Incr(1)
+		return nil
+	}
+	if m = r.Config.Filter.Apply(m); m == nil {
+		r.MetricsFiltered.Incr(1)
+		return nil
+	}
+
+	if r.trace {
 		fmt.Print("> " + m.String())
 	}
 
+	r.mu.Lock()
+	observing := r.probation > 0
+	if observing {
+		r.observedMetrics++
+	}
+	r.mu.Unlock()
+	if observing {
+		r.MetricsObserved.Incr(1)
+		return nil
+	}
+
 	r.MetricsGathered.Incr(1)
 	GlobalMetricsGathered.Incr(1)
 	return m
 }
 
+// ObserveError records the error if the input is on probation, and reports
+// whether it did, in which case it is not logged.
+func (r *RunningInput) ObserveError(err error) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.probation == 0 {
+		return false
+	}
+	r.observedErrors[err.Error()]++
+	return true
+}
+
+// Gathered counts a gather of the input on probation, logging the summary of
+// what was observed once the probation ends.
+func (r *RunningInput) Gathered() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.probation == 0 {
+		return
+	}
+	r.probation--
+	if r.probation > 0 {
+		return
+	}
+
+	var errors []string
+	total := 0
+	for msg, n := range r.observedErrors {
+		errors = append(errors, fmt.Sprintf("%dx %s", n, msg))
+		total += n
+	}
+	sort.Strings(errors)
+	log.Printf("I! Input [%s] ended its probation of %d gathers, observing "+
+		"%d metrics and %d errors, its metrics are now sent", r.Name(),
+		r.Config.Probation, r.observedMetrics, total)
+	if len(errors) > 0 {
+		log.Printf("W! Input [%s] errors during its probation: %s", r.Name(),
+			strings.Join(errors, "; "))
+	}
+	r.observedErrors = nil
+}
+
+// ShouldGather returns false if the input must not be gathered at t, because
+// t is in one of its blackout windows or outside of its schedule. A scheduled
+// input is gathered at most once per matching minute, its gatherer looking
+// at it every scheduleTick at least, whatever its interval.
+func (r *RunningInput) ShouldGather(t time.Time) bool {
+	for _, window := range r.Config.BlackoutWindows {
+		if window.Contains(t) {
+			return false
+		}
+	}
+
+	if r.Config.Schedule == nil {
+		return true
+	}
+	if !r.Config.Schedule.Matches(t) {
+		return false
+	}
+	minute := t.Truncate(time.Minute)
+	if minute.Equal(r.lastScheduled) {
+		return false
+	}
+	r.lastScheduled = minute
+	return true
+}
 
 func (r *RunningInput) Name() string {
 	return "inputs." + r.Config.Name
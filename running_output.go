@@ -3,6 +3,7 @@ package main
 import (
 	"sync"
 	"log"
+	"sort"
 	"time"
 )
 
@@ -26,10 +27,39 @@ type RunningOutput struct {
 	BufferSize     Stat
 	BufferLimit    Stat
 	WriteTime      Stat
+	FlushTime      Stat
+	BatchSize      Stat
+	LastWrite      Stat
+
+	DroppedOverflow      Stat
+	DroppedRejected      Stat
+	DroppedSerialization Stat
+	DroppedOversized     Stat
+	DroppedOutOfOrder    Stat
+	DroppedFiltered      Stat
 
 	metrics     *Buffer
 	failMetrics *Buffer
 
+	// endpoints the output was last set to from its registry, when they were
+	// resolved, whether they must be resolved again before the next write,
+	// and whether the output failed to connect to them
+	endpoints      []string
+	resolved       time.Time
+	staleEndpoints bool
+	reconnect      bool
+
+	// when the token of the output must be renewed, and the delay before
+	// renewing it again after a failure
+	tokenRefresh time.Time
+	tokenBackoff time.Duration
+
+	// with strict ordering, the timestamp of the last metric written of each
+	// series, by hash id, and when the series out of the window were last
+	// forgotten
+	lastWritten map[uint64]int64
+	lastPruned  time.Time
+
 	// Guards against concurrent calls to the Output as described in #3009
 	sync.Mutex
 }
@@ -75,8 +105,39 @@ func NewRunningOutput(
 			"write_time_ns",
 			map[string]string{"output": name},
 		),
+		FlushTime: RegisterTiming(
+			"write",
+			"flush_time_ns",
+			map[string]string{"output": name},
+		),
+		BatchSize: RegisterTiming(
+			"write",
+			"batch_size",
+			map[string]string{"output": name},
+		),
+		LastWrite: RegisterSince(
+			"write",
+			"since_last_write_ns",
+			map[string]string{"output": name},
+		),
+		DroppedOverflow: RegisterOutputDropped("buffer", name, conf.Alias,
+			"overflow"),
+		DroppedRejected: RegisterOutputDropped("write", name, conf.Alias,
+			"rejected"),
+		DroppedSerialization: RegisterOutputDropped("write", name, conf.Alias,
+			"serialization"),
+		DroppedOversized: RegisterOutputDropped("write", name, conf.Alias,
+			"oversized"),
+		DroppedOutOfOrder: RegisterOutputDropped("write", name, conf.Alias,
+			"out_of_order"),
+		DroppedFiltered: RegisterOutputDropped("filter", name, conf.Alias,
+			"filtered"),
+		lastWritten: make(map[uint64]int64),
 	}
 	ro.BufferLimit.Incr(int64(ro.MetricBufferLimit))
+	if r, ok := output.(RejectingOutput); ok {
+		r.SetDroppedRejected(ro.DroppedRejected)
+	}
 	return ro
 }
 
@@ -86,6 +147,10 @@ func (ro *RunningOutput) Write() error {
 	ro.BufferSize.Set(int64(nFails + nMetrics))
 	log.Printf("D! Output [%s] buffer fullness: %d / %d metrics. ",
 		ro.Name, nFails+nMetrics, ro.MetricBufferLimit)
+	start := time.Now()
+	defer func() { ro.FlushTime.Incr(time.Since(start).Nanoseconds()) }()
+	ro.refreshEndpoints()
+	ro.refreshToken()
 	var err error
 	if !ro.failMetrics.IsEmpty() {
 		// how many batches of failed writes we need to write.
@@ -102,51 +167,391 @@ func (ro *RunningOutput) Write() error {
 			// If we've already failed previous writes, don't bother trying to
 			// write to this output again. We are not exiting the loop just so
 			// that we can rotate the metrics to preserve order.
+			failed := batch
 			if err == nil {
-				err = ro.write(batch)
+				failed, err = ro.write(batch)
 			}
 			if err != nil {
-				ro.failMetrics.Add(batch...)
+				ro.addFailed(failed)
 			}
 		}
 	}
 
 	batch := ro.metrics.Batch(ro.MetricBatchSize)
+	failed := batch
 	// see comment above about not trying to write to an already failed output.
 	// if ro.failMetrics is empty then err will always be nil at this point.
 	if err == nil {
-		err = ro.write(batch)
+		failed, err = ro.write(batch)
 	}
 
 	if err != nil {
-		ro.failMetrics.Add(batch...)
+		ro.addFailed(failed)
+		ro.staleEndpoints = true
 		return err
 	}
 	return nil
 }
 
-func (ro *RunningOutput) write(metrics []Metric) error {
-	nMetrics := len(metrics)
-	if nMetrics == 0 {
-		return nil
+// ResolveEndpoints sets the output to the endpoints of its registry, if it
+// has a discovery config. It must be called before the output connects;
+// when the registry cannot be reached, the output keeps the endpoints of
+// its config.
+func (ro *RunningOutput) ResolveEndpoints() {
+	d := ro.Config.Discovery
+	if d == nil {
+		return
+	}
+	ro.resolved = time.Now()
+	endpoints, err := d.resolve()
+	if err != nil {
+		log.Printf("W! Output [%s] could not resolve its endpoints from %s, "+
+			"using the ones of its config: %s", ro.Name, d.Source, err)
+		return
+	}
+	log.Printf("I! Output [%s] resolved its endpoints from %s: %v",
+		ro.Name, d.Source, endpoints)
+	ro.Output.(EndpointOutput).SetEndpoints(endpoints)
+	ro.endpoints = endpoints
+	ro.staleEndpoints = false
+}
+
+// refreshEndpoints resolves the endpoints of the output again once the
+// refresh interval elapsed, or after a write failed, and reconnects the
+// output when they changed. The output keeps its endpoints when the
+// registry cannot be reached.
+func (ro *RunningOutput) refreshEndpoints() {
+	d := ro.Config.Discovery
+	if d == nil {
+		return
+	}
+	if !ro.staleEndpoints && time.Since(ro.resolved) < d.RefreshInterval.Duration {
+		return
+	}
+	ro.resolved = time.Now()
+	endpoints, err := d.resolve()
+	if err != nil {
+		log.Printf("W! Output [%s] could not resolve its endpoints from %s, "+
+			"keeping %v: %s", ro.Name, d.Source, ro.endpoints, err)
+		return
+	}
+	changed := !sameEndpoints(endpoints, ro.endpoints)
+	ro.staleEndpoints = false
+	if !changed && !ro.reconnect {
+		return
+	}
+
+	if changed {
+		log.Printf("I! Output [%s] endpoints changed from %v to %v, reconnecting",
+			ro.Name, ro.endpoints, endpoints)
 	}
 	ro.Lock()
 	defer ro.Unlock()
+	if err := ro.Output.Close(); err != nil {
+		log.Printf("W! Output [%s] error closing: %s", ro.Name, err)
+	}
+	ro.Output.(EndpointOutput).SetEndpoints(endpoints)
+	ro.endpoints = endpoints
+	if err := ro.Output.Connect(); err != nil {
+		log.Printf("E! Output [%s] could not connect to %v: %s",
+			ro.Name, endpoints, err)
+		ro.staleEndpoints = true
+		ro.reconnect = true
+		return
+	}
+	ro.reconnect = false
+}
+
+// AcquireToken sets the output to a token of its token source, if it has
+// one. It must be called before the output connects; when no token can be
+// had, the output connects without and the token is renewed before the
+// writes.
+func (ro *RunningOutput) AcquireToken() {
+	if ro.Config.Token == nil {
+		return
+	}
+	ro.renewToken()
+}
+
+// refreshToken renews the token of the output before it expires, the
+// output keeping its token, which may still be valid, when it cannot be
+// renewed.
+func (ro *RunningOutput) refreshToken() {
+	if ro.Config.Token == nil || time.Now().Before(ro.tokenRefresh) {
+		return
+	}
+	ro.renewToken()
+}
+
+// renewToken gets a new token and sets the output to it, or backs off from
+// the token source on failure.
+func (ro *RunningOutput) renewToken() {
+	s := ro.Config.Token
+	token, expiry, err := s.fetch()
+	if err != nil {
+		if ro.tokenBackoff == 0 {
+			ro.tokenBackoff = s.RetryBackoff.Duration
+		}
+		log.Printf("E! Output [%s] could not renew its token from %s, "+
+			"retrying in %s: %s", ro.Name, s.Source, ro.tokenBackoff, err)
+		ro.tokenRefresh = time.Now().Add(ro.tokenBackoff)
+		ro.tokenBackoff *= 2
+		if ro.tokenBackoff > tokenMaxBackoff {
+			ro.tokenBackoff = tokenMaxBackoff
+		}
+		return
+	}
+	ro.tokenBackoff = 0
+
+	// the tokens living less than twice refresh_before are renewed halfway
+	before := s.RefreshBefore.Duration
+	if lifetime := time.Until(expiry); lifetime < 2*before {
+		before = lifetime / 2
+	}
+	ro.tokenRefresh = expiry.Add(-before)
+	log.Printf("D! Output [%s] renewed its token from %s, expiring at %s",
+		ro.Name, s.Source, expiry.Format(time.RFC3339))
+
+	ro.Lock()
+	ro.Output.(TokenOutput).SetToken(token)
+	ro.Unlock()
+}
+
+// addFailed puts metrics back into the retry buffer, counting the ones that
+// were pushed out of it.
+func (ro *RunningOutput) addFailed(metrics []Metric) {
+	ro.DroppedOverflow.Incr(int64(ro.failMetrics.Add(metrics...)))
+}
+
+// write writes the batch to the output, split in batches of at most
+// max_payload_bytes if set, and returns the metrics of the batch to write
+// again when it failed: all of them from the first batch that failed, or
+// those neither accepted nor rejected by a partial write.
+func (ro *RunningOutput) write(metrics []Metric) ([]Metric, error) {
+	if ro.Config.StrictOrdering {
+		sort.SliceStable(metrics, func(i, j int) bool {
+			return metrics[i].UnixNano() < metrics[j].UnixNano()
+		})
+	}
+	if ro.Config.MaxPayloadBytes <= 0 {
+		return ro.writeBatch(metrics)
+	}
+
+	batches := ro.splitPayload(metrics)
+	var failed []Metric
+	var partial error
+	for i, batch := range batches {
+		f, err := ro.writeBatch(batch)
+		if err == nil {
+			continue
+		}
+		failed = append(failed, f...)
+		if _, ok := err.(*PartialWriteError); ok {
+			partial = err
+			continue
+		}
+		for _, rest := range batches[i+1:] {
+			failed = append(failed, rest...)
+		}
+		return failed, err
+	}
+	if len(failed) != 0 {
+		return failed, partial
+	}
+	return nil, nil
+}
+
+// splitPayload splits the metrics in batches the serialized size of which is
+// at most max_payload_bytes, dropping the metrics larger than it alone.
+func (ro *RunningOutput) splitPayload(metrics []Metric) [][]Metric {
+	max := ro.Config.MaxPayloadBytes
+	var batches [][]Metric
+	var batch []Metric
+	size := 0
+	for _, m := range metrics {
+		n := m.Len()
+		if ro.Config.serializer != nil {
+			if b, err := ro.Config.serializer.Serialize(m); err == nil {
+				n = len(b)
+			}
+		}
+		if n > max {
+			log.Printf("E! Output [%s] dropped a metric of %d bytes, over the "+
+				"max_payload_bytes of %d: %s", ro.Name, n, max, m.Name())
+			ro.DroppedOversized.Incr(1)
+			continue
+		}
+		if size+n > max && len(batch) != 0 {
+			batches = append(batches, batch)
+			batch, size = nil, 0
+		}
+		batch = append(batch, m)
+		size += n
+	}
+	if len(batch) != 0 {
+		batches = append(batches, batch)
+	}
+	return batches
+}
+
+// writeBatch writes the batch to the output, and returns the metrics of the
+// batch to write again when it failed: all of them, or those neither
+// accepted nor rejected by a partial write.
+func (ro *RunningOutput) writeBatch(metrics []Metric) ([]Metric, error) {
+	ro.Lock()
+	defer ro.Unlock()
+	if ro.Config.StrictOrdering {
+		metrics = ro.dropOutOfOrder(metrics)
+	}
+	nMetrics := len(metrics)
+	if nMetrics == 0 {
+		return nil, nil
+	}
 	start := time.Now()
 	err := ro.Output.Write(metrics)
 	elapsed := time.Since(start)
+	ro.BatchSize.Incr(int64(nMetrics))
 	if err == nil {
+		ro.markWritten(metrics)
+		ro.LastWrite.Incr(1)
 		log.Printf("D! Output [%s] wrote batch of %d metrics in %s\n",
 			ro.Name, nMetrics, elapsed)
 		ro.MetricsWritten.Incr(int64(nMetrics))
 		ro.WriteTime.Incr(elapsed.Nanoseconds())
+		return nil, nil
+	}
+
+	partial, ok := err.(*PartialWriteError)
+	if !ok {
+		return metrics, err
+	}
+	done := make([]bool, nMetrics)
+	for _, i := range partial.Accepted {
+		done[i] = true
+		ro.markWritten(metrics[i : i+1])
+	}
+	for _, i := range partial.Rejected {
+		done[i] = true
+	}
+	for _, i := range partial.Unserializable {
+		done[i] = true
+	}
+	var failed []Metric
+	for i, m := range metrics {
+		if !done[i] {
+			failed = append(failed, m)
+		}
+	}
+	log.Printf("D! Output [%s] wrote %d, rejected %d, could not serialize %d "+
+		"and failed %d metrics of a batch in %s\n", ro.Name, len(partial.Accepted),
+		len(partial.Rejected), len(partial.Unserializable), len(failed), elapsed)
+	ro.MetricsWritten.Incr(int64(len(partial.Accepted)))
+	ro.DroppedRejected.Incr(int64(len(partial.Rejected)))
+	ro.DroppedSerialization.Incr(int64(len(partial.Unserializable)))
+	ro.WriteTime.Incr(elapsed.Nanoseconds())
+	if len(partial.Accepted) > 0 {
+		ro.LastWrite.Incr(1)
+	}
+	if len(failed) == 0 {
+		return nil, nil
+	}
+	return failed, partial
+}
+
+// dropOutOfOrder returns the metrics of the batch not older than the last
+// metric written of their series, counting the others.
+func (ro *RunningOutput) dropOutOfOrder(metrics []Metric) []Metric {
+	var kept []Metric
+	for _, m := range metrics {
+		if last, ok := ro.lastWritten[m.HashID()]; ok && m.UnixNano() < last {
+			log.Printf("W! Output [%s] dropped a metric of %s older than the "+
+				"last one written of its series", ro.Name, m.Name())
+			ro.DroppedOutOfOrder.Incr(1)
+			continue
+		}
+		kept = append(kept, m)
+	}
+	return kept
+}
+
+// markWritten records the timestamps of the metrics written, with strict
+// ordering.
+func (ro *RunningOutput) markWritten(metrics []Metric) {
+	if !ro.Config.StrictOrdering {
+		return
+	}
+	for _, m := range metrics {
+		id := m.HashID()
+		if t := m.UnixNano(); t > ro.lastWritten[id] {
+			ro.lastWritten[id] = t
+		}
+	}
+
+	// the series not written within the window are forgotten, at most once
+	// per window so as not to go through all of them on every write
+	now := time.Now()
+	if now.Sub(ro.lastPruned) < ro.Config.OrderingWindow {
+		return
+	}
+	ro.lastPruned = now
+	oldest := now.Add(-ro.Config.OrderingWindow).UnixNano()
+	for id, t := range ro.lastWritten {
+		if t < oldest {
+			delete(ro.lastWritten, id)
+		}
 	}
-	return err
 }
 
 // OutputConfig containing name and filter
 type OutputConfig struct {
 	Name string
+
+	// Alias tells the outputs of the same plugin apart in the counters of
+	// the metrics they dropped.
+	Alias string
+
+	// Resolution of the metrics written: "raw" for the metrics as gathered,
+	// "aggregated" for the metrics of the aggregators and the ones they do
+	// not take, or "all".
+	Resolution string
+
+	// MaxPayloadBytes caps the serialized size of the batches written, 0
+	// not capping it.
+	MaxPayloadBytes int
+
+	// StrictOrdering writes the metrics of each series in timestamp order,
+	// dropping the ones older than the last written of their series. The
+	// series are forgotten once the last metric written is older than the
+	// OrderingWindow.
+	StrictOrdering bool
+	OrderingWindow time.Duration
+
+	// Discovery resolves the endpoints of the output from a service
+	// registry, nil if the output writes to the ones of its config.
+	Discovery *EndpointDiscovery
+	// Token renews the bearer token of the output from its token source.
+	Token *TokenSource
+	// Kerberos authenticates the requests of the output with SPNEGO.
+	Kerberos *Kerberos
+
+	// Filter selects the metrics the output writes and their fields.
+	Filter Filter
+
+	// serializer of the output, which the payload is measured with, nil if
+	// it writes the line protocol
+	serializer Serializer
+}
+
+// Accepts returns true if the output writes the metric at its resolution,
+// downsampled telling whether an aggregator takes it.
+func (ro *RunningOutput) Accepts(m Metric, downsampled bool) bool {
+	switch ro.Config.Resolution {
+	case "raw":
+		return !m.IsAggregate()
+	case "aggregated":
+		return m.IsAggregate() || !downsampled
+	}
+	return true
 }
 
 // AddMetric adds a metric to the output. This function can also write cached
@@ -155,13 +560,22 @@ func (ro *RunningOutput) AddMetric(m Metric) {
 	if m == nil {
 		return
 	}
+	if m = ro.Config.Filter.Apply(m); m == nil {
+		ro.DroppedFiltered.Incr(1)
+		return
+	}
 
 	ro.metrics.Add(m)
 	if ro.metrics.Len() == ro.MetricBatchSize {
 		batch := ro.metrics.Batch(ro.MetricBatchSize)
-		err := ro.write(batch)
-		if err != nil {
-			ro.failMetrics.Add(batch...)
+		// with strict ordering, the batch waits behind the metrics to write
+		// again rather than being written before them
+		if ro.Config.StrictOrdering && !ro.failMetrics.IsEmpty() {
+			ro.addFailed(batch)
+			return
+		}
+		if failed, err := ro.write(batch); err != nil {
+			ro.addFailed(failed)
 		}
 	}
 }
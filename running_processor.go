@@ -0,0 +1,63 @@
+package main
+
+import (
+	"sync"
+)
+
+type RunningProcessor struct {
+	Name string
+
+	sync.Mutex
+	Processor Processor
+	Config    *ProcessorConfig
+
+	MetricsDropped Stat
+}
+
+type RunningProcessors []*RunningProcessor
+
+func (rp RunningProcessors) Len() int           { return len(rp) }
+func (rp RunningProcessors) Swap(i, j int)      { rp[i], rp[j] = rp[j], rp[i] }
+func (rp RunningProcessors) Less(i, j int) bool { return rp[i].Config.Order < rp[j].Config.Order }
+
+// ProcessorConfig containing a name and the order it is applied in
+type ProcessorConfig struct {
+	Name  string
+	Order int64
+}
+
+func NewRunningProcessor(
+	processor Processor,
+	config *ProcessorConfig,
+) *RunningProcessor {
+	return &RunningProcessor{
+		Name:      config.Name,
+		Processor: processor,
+		Config:    config,
+		MetricsDropped: RegisterDropped(
+			"process",
+			"processors."+config.Name,
+			"rejected",
+		),
+	}
+}
+
+// Apply runs the processor over the given metrics. Processors are not
+// required to be safe for concurrent use, so calls are serialized.
+func (rp *RunningProcessor) Apply(in ...Metric) []Metric {
+	rp.Lock()
+	defer rp.Unlock()
+
+	ret := []Metric{}
+	for _, metric := range in {
+		if metric == nil {
+			continue
+		}
+		out := rp.Processor.Apply(metric)
+		if len(out) == 0 {
+			rp.MetricsDropped.Incr(1)
+		}
+		ret = append(ret, out...)
+	}
+	return ret
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+// Schema declares the measurements expected from the inputs, with the type
+// of each of their fields, to keep exec scripts and the like from drifting
+// the schema of shared dashboards.
+type Schema struct {
+	// Strict drops the measurements not declared, which are otherwise
+	// written as they are.
+	Strict bool
+	// Action is what to do with the metrics of a declared measurement that
+	// do not conform to it: "drop" drops them, and "coerce" drops their
+	// undeclared fields and converts the others to their declared type.
+	Action       string
+	Measurements []*SchemaMeasurement `toml:"measurement"`
+
+	measurements map[string]*SchemaMeasurement
+}
+
+// SchemaMeasurement declares the fields of a measurement, mapping their name
+// to their type: "integer", "float", "boolean" or "string".
+type SchemaMeasurement struct {
+	Name   string
+	Fields map[string]string
+}
+
+// init checks the schema and indexes its measurements by name.
+func (s *Schema) init() error {
+	switch s.Action {
+	case "":
+		s.Action = "drop"
+	case "drop", "coerce":
+	default:
+		return fmt.Errorf("invalid schema action %q, expected drop or coerce",
+			s.Action)
+	}
+
+	s.measurements = make(map[string]*SchemaMeasurement, len(s.Measurements))
+	for _, m := range s.Measurements {
+		if m.Name == "" {
+			return fmt.Errorf("schema measurement without a name")
+		}
+		for field, typ := range m.Fields {
+			switch typ {
+			case "integer", "float", "boolean", "string":
+			default:
+				return fmt.Errorf("invalid type %q of field %s of schema "+
+					"measurement %s", typ, field, m.Name)
+			}
+		}
+		s.measurements[m.Name] = m
+	}
+	return nil
+}
+
+// conform returns the metric conforming to the schema, which is the metric
+// itself if it already does, or nil if it must be dropped. coerced tells
+// whether the metric was coerced into the schema.
+func (s *Schema) conform(m Metric) (conformed Metric, coerced bool) {
+	decl, ok := s.measurements[m.Name()]
+	if !ok {
+		if s.Strict {
+			log.Printf("D! Dropping metric %s, measurement not in the schema",
+				m.Name())
+			return nil, false
+		}
+		return m, false
+	}
+
+	fields := m.Fields()
+	for key, value := range fields {
+		typ, ok := decl.Fields[key]
+		if !ok {
+			if s.Action == "drop" {
+				log.Printf("D! Dropping metric %s, field %s not in the schema",
+					m.Name(), key)
+				return nil, false
+			}
+			delete(fields, key)
+			coerced = true
+			continue
+		}
+		if schemaType(value) == typ {
+			continue
+		}
+		if s.Action == "drop" {
+			log.Printf("D! Dropping metric %s, field %s is not of type %s",
+				m.Name(), key, typ)
+			return nil, false
+		}
+		if v, ok := coerceField(value, typ); ok {
+			fields[key] = v
+		} else {
+			log.Printf("D! Could not coerce field %s of metric %s to %s",
+				key, m.Name(), typ)
+			delete(fields, key)
+		}
+		coerced = true
+	}
+	if !coerced {
+		return m, false
+	}
+	if len(fields) == 0 {
+		log.Printf("D! Dropping metric %s, no field left in the schema",
+			m.Name())
+		return nil, false
+	}
+
+	conformed, err := rebuild(m, m.Name(), m.Tags(), fields, m.Time())
+	if err != nil {
+		log.Printf("E! Could not coerce metric %s into the schema: %s",
+			m.Name(), err)
+		return nil, false
+	}
+	return conformed, true
+}
+
+// schemaType returns the schema type of a field value.
+func schemaType(v interface{}) string {
+	switch v.(type) {
+	case int64:
+		return "integer"
+	case float64:
+		return "float"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	}
+	return ""
+}
+
+// coerceField converts a field value to the given schema type, with the
+// conversions of the converter processor.
+func coerceField(v interface{}, typ string) (interface{}, bool) {
+	switch typ {
+	case "integer":
+		if i, ok := toInteger(v); ok {
+			return i, true
+		}
+	case "float":
+		if f, ok := toFloat(v); ok {
+			return f, true
+		}
+	case "boolean":
+		if b, ok := toBool(v); ok {
+			return b, true
+		}
+	case "string":
+		return toString(v), true
+	}
+	return nil, false
+}
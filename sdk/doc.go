@@ -0,0 +1,44 @@
+// Package sdk is the interface for telegraf plugins built out of this
+// repository, so that they don't need a fork of it. A plugin implements
+// Input or Output, which have the same methods as the interfaces of the
+// plugins in the tree, and is built into its own program with a main
+// function calling RunInput or RunOutput:
+//
+//	package main
+//
+//	import (
+//		"log"
+//
+//		"github.com/vikramjakhr/telegraf-solaris/sdk"
+//	)
+//
+//	type Queue struct{}
+//
+//	func (q *Queue) Description() string  { return "Report the depth of the app queue" }
+//	func (q *Queue) SampleConfig() string { return "" }
+//	func (q *Queue) Gather(acc sdk.Accumulator) error {
+//		acc.AddGauge("app_queue", map[string]interface{}{"depth": 42}, nil)
+//		return nil
+//	}
+//
+//	func main() {
+//		if err := sdk.RunInput(&Queue{}, 0); err != nil {
+//			log.Fatal(err)
+//		}
+//	}
+//
+// The program is then run by the execd input or output of telegraf, which
+// exchange line protocol with it over its standard input and output:
+//
+//	[[inputs.execd]]
+//	  command = ["/opt/site/bin/queue"]
+//	  signal = "STDIN"
+//
+// The plugin reads its own configuration, ie, from flags or a file, and
+// reports its errors on its standard error, which telegraf logs. Line
+// protocol does not carry the metric types, the metrics added with AddGauge
+// or AddCounter are untyped once in telegraf.
+//
+// The interfaces of this package are stable: methods are not removed or
+// changed, so plugins keep building against newer versions of it.
+package sdk
@@ -0,0 +1,184 @@
+package sdk
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// metric is the Metric of the sdk, read from or written as line protocol.
+type metric struct {
+	name   string
+	tags   map[string]string
+	fields map[string]interface{}
+	t      time.Time
+}
+
+func (m *metric) Name() string                   { return m.name }
+func (m *metric) Tags() map[string]string        { return m.tags }
+func (m *metric) Fields() map[string]interface{} { return m.fields }
+func (m *metric) Time() time.Time                { return m.t }
+
+var (
+	measurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	keyEscaper         = strings.NewReplacer(",", `\,`, " ", `\ `, "=", `\=`)
+	stringEscaper      = strings.NewReplacer(`"`, `\"`, `\`, `\\`)
+)
+
+// appendLine appends the metric as a line of line protocol, leaving out the
+// fields of unsupported types.
+func appendLine(b []byte, m Metric) ([]byte, error) {
+	fields := make([]string, 0, len(m.Fields()))
+	for key, value := range m.Fields() {
+		var v string
+		switch value := value.(type) {
+		case int:
+			v = strconv.Itoa(value) + "i"
+		case int32:
+			v = strconv.FormatInt(int64(value), 10) + "i"
+		case int64:
+			v = strconv.FormatInt(value, 10) + "i"
+		case uint32:
+			v = strconv.FormatUint(uint64(value), 10) + "i"
+		case uint64:
+			if value > math.MaxInt64 {
+				value = math.MaxInt64
+			}
+			v = strconv.FormatUint(value, 10) + "i"
+		case float32:
+			v = strconv.FormatFloat(float64(value), 'f', -1, 32)
+		case float64:
+			if math.IsNaN(value) || math.IsInf(value, 0) {
+				continue
+			}
+			v = strconv.FormatFloat(value, 'f', -1, 64)
+		case bool:
+			v = strconv.FormatBool(value)
+		case string:
+			v = `"` + stringEscaper.Replace(value) + `"`
+		default:
+			continue
+		}
+		fields = append(fields, keyEscaper.Replace(key)+"="+v)
+	}
+	if len(fields) == 0 {
+		return b, fmt.Errorf("metric %s has no fields", m.Name())
+	}
+	sort.Strings(fields)
+
+	tags := make([]string, 0, len(m.Tags()))
+	for key, value := range m.Tags() {
+		if key == "" || value == "" {
+			continue
+		}
+		tags = append(tags, keyEscaper.Replace(key)+"="+keyEscaper.Replace(value))
+	}
+	sort.Strings(tags)
+
+	b = append(b, measurementEscaper.Replace(m.Name())...)
+	for _, tag := range tags {
+		b = append(b, ',')
+		b = append(b, tag...)
+	}
+	b = append(b, ' ')
+	b = append(b, strings.Join(fields, ",")...)
+	b = append(b, ' ')
+	b = strconv.AppendInt(b, m.Time().UnixNano(), 10)
+	return append(b, '\n'), nil
+}
+
+// parseLine parses a line of line protocol, with a timestamp in nanoseconds
+// or none for now.
+func parseLine(line string) (Metric, error) {
+	sections := splitUnescaped(line, ' ', true)
+	if len(sections) < 2 || len(sections) > 3 {
+		return nil, fmt.Errorf("invalid line protocol: %q", line)
+	}
+
+	m := &metric{
+		tags:   make(map[string]string),
+		fields: make(map[string]interface{}),
+		t:      time.Now(),
+	}
+	series := splitUnescaped(sections[0], ',', false)
+	m.name = unescape(series[0])
+	for _, tag := range series[1:] {
+		kv := splitUnescaped(tag, '=', false)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid tag %q", tag)
+		}
+		m.tags[unescape(kv[0])] = unescape(kv[1])
+	}
+
+	for _, field := range splitUnescaped(sections[1], ',', true) {
+		kv := splitUnescaped(field, '=', true)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid field %q", field)
+		}
+		value, err := parseFieldValue(kv[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid field %q: %s", field, err)
+		}
+		m.fields[unescape(kv[0])] = value
+	}
+
+	if len(sections) == 3 {
+		ns, err := strconv.ParseInt(sections[2], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timestamp %q", sections[2])
+		}
+		m.t = time.Unix(0, ns)
+	}
+	return m, nil
+}
+
+func parseFieldValue(v string) (interface{}, error) {
+	switch {
+	case strings.HasPrefix(v, `"`):
+		if len(v) < 2 || !strings.HasSuffix(v, `"`) {
+			return nil, fmt.Errorf("unterminated string")
+		}
+		return strings.NewReplacer(`\"`, `"`, `\\`, `\`).Replace(v[1 : len(v)-1]), nil
+	case strings.HasSuffix(v, "i"):
+		return strconv.ParseInt(v[:len(v)-1], 10, 64)
+	case strings.HasSuffix(v, "u"):
+		return strconv.ParseUint(v[:len(v)-1], 10, 64)
+	}
+	switch v {
+	case "t", "T", "true", "True", "TRUE":
+		return true, nil
+	case "f", "F", "false", "False", "FALSE":
+		return false, nil
+	}
+	return strconv.ParseFloat(v, 64)
+}
+
+// splitUnescaped splits s on the separators that are not escaped by a
+// backslash nor, when quotes is set, within a double quoted string.
+func splitUnescaped(s string, sep byte, quotes bool) []string {
+	var parts []string
+	var quoted bool
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\':
+			i++
+		case quotes && s[i] == '"':
+			quoted = !quoted
+		case s[i] == sep && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+func unescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	return strings.NewReplacer(`\,`, ",", `\ `, " ", `\=`, "=").Replace(s)
+}
@@ -0,0 +1,64 @@
+package sdk
+
+import "time"
+
+// Input is a plugin gathering metrics, run by the execd input.
+type Input interface {
+	// SampleConfig returns the default configuration of the Input
+	SampleConfig() string
+
+	// Description returns a one-sentence description on the Input
+	Description() string
+
+	// Gather takes in an accumulator and adds the metrics that the Input
+	// gathers. This is called every "interval"
+	Gather(Accumulator) error
+}
+
+// Output is a plugin writing metrics, run by the execd output.
+type Output interface {
+	// Connect to the Output
+	Connect() error
+	// Close any connections to the Output
+	Close() error
+	// Description returns a one-sentence description on the Output
+	Description() string
+	// SampleConfig returns the default configuration of the Output
+	SampleConfig() string
+	// Write takes in group of points to be written to the Output
+	Write(metrics []Metric) error
+}
+
+// Accumulator collects the metrics an Input gathers.
+type Accumulator interface {
+	// AddFields adds a metric with the given measurement name, fields, and
+	// tags (and timestamp). If a timestamp is not provided, then the
+	// accumulator sets it to "now".
+	AddFields(measurement string,
+		fields map[string]interface{},
+		tags map[string]string,
+		t ...time.Time)
+
+	// AddGauge is the same as AddFields, but will add the metric as a "Gauge" type
+	AddGauge(measurement string,
+		fields map[string]interface{},
+		tags map[string]string,
+		t ...time.Time)
+
+	// AddCounter is the same as AddFields, but will add the metric as a "Counter" type
+	AddCounter(measurement string,
+		fields map[string]interface{},
+		tags map[string]string,
+		t ...time.Time)
+
+	// AddError reports an error of the Input, logged by telegraf
+	AddError(err error)
+}
+
+// Metric is a metric written to an Output.
+type Metric interface {
+	Name() string
+	Tags() map[string]string
+	Fields() map[string]interface{}
+	Time() time.Time
+}
@@ -0,0 +1,165 @@
+package sdk
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// outputBatchSize is the maximum number of metrics RunOutput writes at once.
+const outputBatchSize = 1000
+
+// RunInput gathers the metrics of the Input and writes them as line
+// protocol to the standard output, every interval or, when interval is 0,
+// on every line read from the standard input, as sent by the execd input
+// with signal = "STDIN". It returns when the standard input is closed.
+func RunInput(input Input, interval time.Duration) error {
+	return runInput(input, interval, os.Stdin, os.Stdout, os.Stderr)
+}
+
+func runInput(input Input, interval time.Duration, stdin io.Reader, stdout, stderr io.Writer) error {
+	acc := &shimAccumulator{out: bufio.NewWriter(stdout), errs: stderr}
+	gather := func() error {
+		if err := input.Gather(acc); err != nil {
+			acc.AddError(err)
+		}
+		return acc.flush()
+	}
+
+	if interval == 0 {
+		scanner := bufio.NewScanner(stdin)
+		for scanner.Scan() {
+			if err := gather(); err != nil {
+				return err
+			}
+		}
+		return scanner.Err()
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(io.Discard, stdin)
+		done <- err
+	}()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	if err := gather(); err != nil {
+		return err
+	}
+	for {
+		select {
+		case err := <-done:
+			return err
+		case <-ticker.C:
+			if err := gather(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// RunOutput connects the Output and writes it the metrics read as line
+// protocol from the standard input, in batches of what is available, until
+// the standard input is closed. The lines that cannot be parsed and the
+// failed writes are reported on the standard error.
+func RunOutput(output Output) error {
+	return runOutput(output, os.Stdin, os.Stderr)
+}
+
+func runOutput(output Output, stdin io.Reader, stderr io.Writer) error {
+	if err := output.Connect(); err != nil {
+		return err
+	}
+	defer output.Close()
+
+	reader := bufio.NewReader(stdin)
+	var batch []Metric
+	for {
+		line, err := reader.ReadString('\n')
+		if len(line) > 1 {
+			m, perr := parseLine(line[:len(line)-1])
+			if perr != nil {
+				fmt.Fprintln(stderr, perr)
+			} else {
+				batch = append(batch, m)
+			}
+		}
+		// write once no more line is readily available
+		if len(batch) != 0 && (err != nil || reader.Buffered() == 0 || len(batch) >= outputBatchSize) {
+			if werr := output.Write(batch); werr != nil {
+				fmt.Fprintln(stderr, werr)
+			}
+			batch = nil
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// shimAccumulator writes the metrics added to it as line protocol, the
+// errors to the standard error. The metric types are not carried over line
+// protocol.
+type shimAccumulator struct {
+	mu   sync.Mutex
+	out  *bufio.Writer
+	errs io.Writer
+	buf  []byte
+}
+
+func (a *shimAccumulator) AddFields(measurement string,
+	fields map[string]interface{},
+	tags map[string]string,
+	t ...time.Time,
+) {
+	m := &metric{name: measurement, tags: tags, fields: fields, t: time.Now()}
+	if len(t) > 0 {
+		m.t = t[0]
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	b, err := appendLine(a.buf[:0], m)
+	if err != nil {
+		fmt.Fprintln(a.errs, err)
+		return
+	}
+	a.buf = b
+	a.out.Write(b)
+}
+
+func (a *shimAccumulator) AddGauge(measurement string,
+	fields map[string]interface{},
+	tags map[string]string,
+	t ...time.Time,
+) {
+	a.AddFields(measurement, fields, tags, t...)
+}
+
+func (a *shimAccumulator) AddCounter(measurement string,
+	fields map[string]interface{},
+	tags map[string]string,
+	t ...time.Time,
+) {
+	a.AddFields(measurement, fields, tags, t...)
+}
+
+func (a *shimAccumulator) AddError(err error) {
+	if err == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	fmt.Fprintln(a.errs, err)
+}
+
+func (a *shimAccumulator) flush() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.out.Flush()
+}
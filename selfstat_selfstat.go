@@ -84,6 +84,22 @@ func RegisterTiming(measurement, field string, tags map[string]string) Stat {
 	})
 }
 
+// RegisterSince registers the given measurement, field, and tags in the
+// selfstat registry. If given an identical measurement, it will return the
+// stat that's already been registered.
+//
+// Since stats record the time of an event, with Incr(), and Get() returns the
+// nanoseconds elapsed since the last one, or since registration when the
+// event did not happen yet.
+func RegisterSince(measurement, field string, tags map[string]string) Stat {
+	return registry.register(&sinceStat{
+		measurement: "internal_" + measurement,
+		field:       field,
+		tags:        tags,
+		t:           time.Now(),
+	})
+}
+
 // Metrics returns all registered stats as telegraf metrics.
 func Metrics() []Metric {
 	registry.mu.Lock()
@@ -114,7 +130,7 @@ func Metrics() []Metric {
 		}
 	}
 	registry.mu.Unlock()
-	return metrics
+	return metrics[:i]
 }
 
 type rgstry struct {
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+type sinceStat struct {
+	measurement string
+	field       string
+	tags        map[string]string
+	key         uint64
+	t           time.Time
+	mu          sync.Mutex
+}
+
+// Incr records the time of the event, v is ignored.
+func (s *sinceStat) Incr(v int64) {
+	s.mu.Lock()
+	s.t = time.Now()
+	s.mu.Unlock()
+}
+
+// Set records v, in nanoseconds since the epoch, as the time of the event.
+func (s *sinceStat) Set(v int64) {
+	s.mu.Lock()
+	s.t = time.Unix(0, v)
+	s.mu.Unlock()
+}
+
+func (s *sinceStat) Get() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return time.Since(s.t).Nanoseconds()
+}
+
+func (s *sinceStat) Name() string {
+	return s.measurement
+}
+
+func (s *sinceStat) FieldName() string {
+	return s.field
+}
+
+// Tags returns a copy of the sinceStat's tags.
+// NOTE this allocates a new map every time it is called.
+func (s *sinceStat) Tags() map[string]string {
+	m := make(map[string]string, len(s.tags))
+	for k, v := range s.tags {
+		m[k] = v
+	}
+	return m
+}
+
+func (s *sinceStat) Key() uint64 {
+	if s.key == 0 {
+		s.key = key(s.measurement, s.tags)
+	}
+	return s.key
+}
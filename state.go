@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	// defaultStateDirectory is where the agent keeps its writable state,
+	// unless configured otherwise.
+	defaultStateDirectory = "/var/telegraf"
+	// fallbackStateDirectory is used when the state directory cannot be
+	// written, as /var/run is a tmpfs writable even in read-only zones.
+	fallbackStateDirectory = "/var/run/telegraf"
+)
+
+// stateDirectory is the directory the relative paths of the pid file and
+// log file are resolved in, and which they fall back to when their paths
+// cannot be written, as in sparse-root and read-only zones.
+var stateDirectory = defaultStateDirectory
+
+// SetupStateDirectory creates the state directory, or the fallback one if
+// it cannot be written, and returns the directory used.
+func SetupStateDirectory(dir string) string {
+	if dir == "" {
+		dir = defaultStateDirectory
+	}
+	for _, d := range []string{dir, fallbackStateDirectory} {
+		err := os.MkdirAll(d, 0750)
+		if err == nil {
+			var f *os.File
+			if f, err = ioutil.TempFile(d, ".telegraf"); err == nil {
+				f.Close()
+				os.Remove(f.Name())
+				stateDirectory = d
+				return d
+			}
+		}
+		log.Printf("W! State directory %s cannot be written: %s", d, err)
+	}
+	stateDirectory = dir
+	return dir
+}
+
+// statePath returns the path, resolved in the state directory if it is
+// relative.
+func statePath(path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(stateDirectory, path)
+}
+
+// openStateFile opens the file at the path for writing, with the given
+// flags, or the file of the same name in the state directory if the path
+// cannot be written. It returns the file and its path.
+func openStateFile(path string, flag int) (*os.File, string, error) {
+	path = statePath(path)
+	f, err := os.OpenFile(path, flag, 0644)
+	if err == nil {
+		return f, path, nil
+	}
+	fallback := filepath.Join(stateDirectory, filepath.Base(path))
+	if fallback == path {
+		return nil, path, err
+	}
+	if f, ferr := os.OpenFile(fallback, flag, 0644); ferr == nil {
+		log.Printf("W! Unable to open %s (%s), using %s", path, err, fallback)
+		return f, fallback, nil
+	}
+	return nil, path, err
+}
+
+// signalAgent sends the signal to the agent running with the pid file, the
+// one of the config if empty, hint telling what to send it by hand when
+// there is no pid file.
+func signalAgent(c *Config, pidfile string, sig syscall.Signal, hint string) error {
+	if pidfile == "" {
+		pidfile = c.Agent.Pidfile
+	}
+	if pidfile == "" {
+		return fmt.Errorf("No pidfile to find the running agent, send it a %s",
+			hint)
+	}
+	// the pid file may have been written to the state directory instead
+	var b []byte
+	var err error
+	for _, p := range []string{statePath(pidfile),
+		filepath.Join(stateDirectory, filepath.Base(pidfile))} {
+		if b, err = ioutil.ReadFile(p); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return fmt.Errorf("Invalid pid in %s: %s", pidfile, err)
+	}
+	return syscall.Kill(pid, sig)
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// tapFile is the file of the state directory holding the filter of the
+// debug tap enabled at runtime with the tap command.
+const tapFile = "tap"
+
+// tapStages are the stages of the pipeline the debug tap mirrors the metrics
+// at: as gathered by the inputs, after each processor, as emitted by the
+// aggregators, and as added to each output.
+var tapStages = []string{"input", "processor", "aggregator", "output"}
+
+// TapFilter selects the metrics the debug tap mirrors, and where it writes
+// them. The empty selectors select everything.
+type TapFilter struct {
+	// globs of the measurements mirrored
+	Measurements []string `json:"measurements,omitempty"`
+	// globs of the values of the tags the metrics must have
+	Tags map[string][]string `json:"tags,omitempty"`
+	// globs of the fields shown, the metrics being mirrored even without
+	// any, so that the stage a field disappears at shows
+	Fields []string `json:"fields,omitempty"`
+	// stages the metrics are mirrored at
+	Stages []string `json:"stages,omitempty"`
+	// file the metrics are appended to, stdout if empty
+	File string `json:"file,omitempty"`
+}
+
+// validate checks the stages of the filter.
+func (f *TapFilter) validate() error {
+	for _, stage := range f.Stages {
+		if !sliceContains(stage, tapStages) {
+			return fmt.Errorf("Unknown tap stage %s, the stages are: %s",
+				stage, strings.Join(tapStages, " "))
+		}
+	}
+	return nil
+}
+
+// debugTap mirrors the metrics matching its filter as they pass the stages
+// of the pipeline.
+type debugTap struct {
+	filter *TapFilter
+	out    io.Writer
+	file   *os.File
+
+	// mu serializes the writes, and the close of the file with them, a
+	// metric being mirrored while the tap is swapped
+	mu     sync.Mutex
+	closed bool
+}
+
+// selects returns true if the tap mirrors the metric at the stage.
+func (t *debugTap) selects(stage string, m Metric) bool {
+	if len(t.filter.Stages) != 0 && !sliceContains(stage, t.filter.Stages) {
+		return false
+	}
+	if len(t.filter.Measurements) != 0 &&
+		!matchesAny(m.Name(), t.filter.Measurements) {
+		return false
+	}
+	tags := m.Tags()
+	for key, globs := range t.filter.Tags {
+		value, ok := tags[key]
+		if !ok || !matchesAny(value, globs) {
+			return false
+		}
+	}
+	return true
+}
+
+// mirror writes the metric, annotated with the stage and the plugin, if the
+// tap selects it.
+func (t *debugTap) mirror(stage, plugin string, m Metric) {
+	if !t.selects(stage, m) {
+		return
+	}
+	line := m.String()
+	if len(t.filter.Fields) != 0 {
+		fields := make(map[string]interface{})
+		for key, value := range m.Fields() {
+			if matchesAny(key, t.filter.Fields) {
+				fields[key] = value
+			}
+		}
+		line = fmt.Sprintf("%s (no field shown) %d\n", m.Name(), m.UnixNano())
+		if len(fields) != 0 {
+			shown, err := New(m.Name(), m.Tags(), fields, m.Time(), m.Type())
+			if err == nil {
+				line = shown.String()
+			}
+		}
+	}
+	if plugin != "" {
+		stage += " " + plugin
+	}
+
+	t.mu.Lock()
+	if !t.closed {
+		fmt.Fprintf(t.out, "%s [%s] %s", time.Now().Format(time.RFC3339),
+			stage, line)
+	}
+	t.mu.Unlock()
+}
+
+// close closes the file once the metrics being written are, the ones
+// mirrored later being dropped.
+func (t *debugTap) close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.closed = true
+	if t.file != nil {
+		t.file.Close()
+	}
+}
+
+// tapMetric mirrors the metric at the stage of the pipeline, plugin naming
+// the processor or output for the stages of these, if a tap is enabled.
+func (a *Agent) tapMetric(stage, plugin string, m Metric) {
+	if t, _ := a.tap.Load().(*debugTap); t != nil {
+		t.mirror(stage, plugin, m)
+	}
+}
+
+// LoadTap enables the debug tap with the filter the tap command recorded in
+// the state directory, or disables it if there is none.
+func (a *Agent) LoadTap() {
+	var filter *TapFilter
+	b, err := ioutil.ReadFile(statePath(tapFile))
+	if err == nil {
+		filter = &TapFilter{}
+		if err = json.Unmarshal(b, filter); err == nil {
+			err = filter.validate()
+		}
+		if err != nil {
+			log.Printf("E! Invalid debug tap filter in %s, disabling the tap: %s",
+				statePath(tapFile), err)
+			filter = nil
+		}
+	} else if !os.IsNotExist(err) {
+		log.Printf("E! Could not read the debug tap filter: %s", err)
+	}
+
+	var t *debugTap
+	if filter != nil {
+		t = &debugTap{filter: filter, out: os.Stdout}
+		if filter.File != "" {
+			f, _, err := openStateFile(filter.File,
+				os.O_CREATE|os.O_WRONLY|os.O_APPEND)
+			if err != nil {
+				log.Printf("E! Could not open the debug tap file, disabling "+
+					"the tap: %s", err)
+				t = nil
+			} else {
+				t.out, t.file = f, f
+			}
+		}
+	}
+
+	if old, _ := a.tap.Swap(t).(*debugTap); old != nil {
+		old.close()
+	}
+	if t == nil {
+		log.Printf("I! Debug tap disabled")
+		return
+	}
+	stages := filter.Stages
+	if len(stages) == 0 {
+		stages = tapStages
+	}
+	dest := "stdout"
+	if t.file != nil {
+		dest = t.file.Name()
+	}
+	log.Printf("I! Debug tap enabled at the %s stages, writing to %s",
+		strings.Join(stages, ", "), dest)
+}
+
+// SetTap records the filter of the debug tap, or removes it to disable the
+// tap if nil, and signals the agent running with the pid file of the config
+// to load it. The tap is kept over restarts until disabled.
+func SetTap(c *Config, filter *TapFilter, pidfile string) error {
+	path := statePath(tapFile)
+	if filter == nil {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	} else {
+		if err := filter.validate(); err != nil {
+			return err
+		}
+		b, err := json.Marshal(filter)
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(path, append(b, '\n'), 0644); err != nil {
+			return err
+		}
+	}
+	return signalAgent(c, pidfile, syscall.SIGUSR1, "SIGUSR1 to load the tap")
+}
+
+// parseTapArgs parses the arguments of the tap command: globs of the
+// measurements, and tag=glob selectors of the tags.
+func parseTapArgs(args []string) *TapFilter {
+	filter := &TapFilter{}
+	for _, arg := range args {
+		if i := strings.Index(arg, "="); i > 0 {
+			if filter.Tags == nil {
+				filter.Tags = make(map[string][]string)
+			}
+			filter.Tags[arg[:i]] = append(filter.Tags[arg[:i]], arg[i+1:])
+			continue
+		}
+		filter.Measurements = append(filter.Measurements, arg)
+	}
+	sort.Strings(filter.Measurements)
+	return filter
+}
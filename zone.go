@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ZoneRunner runs zonename(1), ppriv(1) or kstat(1M), given as the command,
+// with the given arguments and returns its output. It can be replaced with a
+// mocked function for unit test purposes.
+type ZoneRunner func(timeout time.Duration, command string, args ...string) ([]byte, error)
+
+// zoneCheckTimeout bounds each command run to detect the zone.
+const zoneCheckTimeout = 5 * time.Second
+
+// zoneRequirement is what an input needs to work in a non-global zone: to
+// run in the global zone, one of the privileges in the limit set of the
+// zone, or the kstats to be visible.
+type zoneRequirement struct {
+	global     bool
+	privileges []string
+	kstats     []string
+}
+
+// zoneRequirements are the requirements of the inputs that do not work in
+// every non-global zone, by input name.
+var zoneRequirements = map[string]zoneRequirement{
+	// the HBAs, the service processor, the domain manager and the fault
+	// manager are only reachable from the global zone
+	"fc":      {global: true},
+	"sensors": {global: true},
+	"ldom":    {global: true},
+	"fmadm":   {global: true},
+	// DTrace needs the zone to be granted its privileges with limitpriv
+	"dtrace":         {privileges: []string{"dtrace_user", "dtrace_kernel"}},
+	"syscall_errors": {privileges: []string{"dtrace_user", "dtrace_kernel"}},
+	// ipmpstat only sees the interfaces of exclusive-IP zones, the only
+	// ones allowed sys_ip_config
+	"ipmp": {privileges: []string{"sys_ip_config"}},
+	"zfs":  {kstats: []string{"zfs:0:arcstats"}},
+	// the pools are only visible from the global zone
+	"zpool_iostat": {global: true},
+}
+
+// zoneInfo is the zone the agent runs in.
+type zoneInfo struct {
+	name   string
+	global bool
+	// the limit privilege set of the agent, bounded by the one of the zone,
+	// nil if it could not be read
+	limit map[string]bool
+}
+
+// detectZone returns the zone the agent runs in, and its privilege limit
+// set if it is a non-global zone.
+func detectZone(run ZoneRunner) (*zoneInfo, error) {
+	out, err := run(zoneCheckTimeout, "zonename")
+	if err != nil {
+		return nil, fmt.Errorf("error running zonename: %s", err)
+	}
+	zone := &zoneInfo{name: strings.TrimSpace(string(out))}
+	zone.global = zone.name == "global"
+	if zone.global {
+		return zone, nil
+	}
+
+	out, err = run(zoneCheckTimeout, "ppriv", "-v", strconv.Itoa(os.Getpid()))
+	if err != nil {
+		log.Printf("W! Could not read the privileges of the zone, not checking "+
+			"them: %s", err)
+		return zone, nil
+	}
+	// the sets are listed one per line, as "L: contract_event,..."
+	for _, line := range strings.Split(string(out), "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "L:") {
+			continue
+		}
+		zone.limit = make(map[string]bool)
+		for _, priv := range strings.Split(strings.TrimSpace(line[2:]), ",") {
+			zone.limit[priv] = true
+		}
+	}
+	return zone, nil
+}
+
+// unsupported returns why the input cannot work in the zone, or "" if it
+// can.
+func (z *zoneInfo) unsupported(name string, run ZoneRunner) string {
+	req, ok := zoneRequirements[name]
+	if !ok {
+		return ""
+	}
+	if req.global {
+		return "it only works in the global zone"
+	}
+	if len(req.privileges) != 0 && z.limit != nil && !z.limit["all"] {
+		granted := false
+		for _, priv := range req.privileges {
+			granted = granted || z.limit[priv]
+		}
+		if !granted {
+			return fmt.Sprintf("the zone is not granted any of the %s privileges",
+				strings.Join(req.privileges, ", "))
+		}
+	}
+	for _, ks := range req.kstats {
+		out, err := run(zoneCheckTimeout, "kstat", "-p", ks)
+		if err != nil || strings.TrimSpace(string(out)) == "" {
+			return fmt.Sprintf("the %s kstat is not visible in the zone", ks)
+		}
+	}
+	return ""
+}
+
+// setupZone detects the zone the agent runs in according to its zone_mode.
+// In a non-global zone, it removes the inputs that cannot work there, which
+// would otherwise fail on every gather, and tags the metrics with the zone.
+func (a *Agent) setupZone(run ZoneRunner) error {
+	var zone *zoneInfo
+	switch a.Config.Agent.ZoneMode {
+	case "global":
+		return nil
+	case "", "auto":
+		z, err := detectZone(run)
+		if err != nil {
+			log.Printf("D! Could not detect the zone, assuming the global "+
+				"zone: %s", err)
+			return nil
+		}
+		zone = z
+	case "delegated":
+		z, err := detectZone(run)
+		if err != nil {
+			return fmt.Errorf("Could not detect the zone: %s", err)
+		}
+		zone = z
+		zone.global = false
+	default:
+		return fmt.Errorf("Invalid zone_mode: %s", a.Config.Agent.ZoneMode)
+	}
+	if zone.global {
+		return nil
+	}
+
+	log.Printf("I! Running in the non-global zone %s", zone.name)
+	inputs := a.Config.Inputs[:0]
+	for _, input := range a.Config.Inputs {
+		if reason := zone.unsupported(input.Config.Name, run); reason != "" {
+			log.Printf("W! Input [%s] disabled in the non-global zone: %s",
+				input.Name(), reason)
+			continue
+		}
+		inputs = append(inputs, input)
+	}
+	a.Config.Inputs = inputs
+
+	if tag := a.Config.Agent.ZoneTag; tag != "" {
+		if _, ok := a.Config.Tags[tag]; !ok {
+			a.Config.Tags[tag] = zone.name
+		}
+	}
+	return nil
+}
+
+func zoneRunner(timeout time.Duration, command string, args ...string) ([]byte, error) {
+	bin, err := exec.LookPath(command)
+	if err != nil {
+		return nil, err
+	}
+	c := exec.Command(bin, args...)
+	return CombinedOutputTimeout(c, timeout)
+}